@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dazraf/go-api-example/internal/config"
+)
+
+// redacted replaces secrets in rendered configuration
+const redacted = "********"
+
+// runConfig dispatches config subcommands
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "render" {
+		return errors.New("usage: config render [-file path] [-profile name]")
+	}
+	return runConfigRender(args[1:])
+}
+
+// runConfigRender prints the fully merged configuration, after includes,
+// profile overlays, defaults and environment overrides
+func runConfigRender(args []string) error {
+	flags := flag.NewFlagSet("config render", flag.ExitOnError)
+	file := flags.String("file", "", "config file to render (default: CONFIG_FILE or configs/config.yaml)")
+	profile := flags.String("profile", config.ActiveProfile(), "profile to apply")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var (
+		cfg *config.Config
+		err error
+	)
+	if *file != "" {
+		cfg, err = config.LoadFile(*file, *profile)
+	} else {
+		if err := os.Setenv("CONFIG_PROFILE", *profile); err != nil {
+			return err
+		}
+		cfg, err = config.Load()
+	}
+	if err != nil {
+		return err
+	}
+
+	if cfg.Database.Password != "" {
+		cfg.Database.Password = redacted
+	}
+	if cfg.Remote.Token != "" {
+		cfg.Remote.Token = redacted
+	}
+	if cfg.Discovery.Token != "" {
+		cfg.Discovery.Token = redacted
+	}
+	if cfg.Mailer.SMTP.Password != "" {
+		cfg.Mailer.SMTP.Password = redacted
+	}
+	if cfg.Notifications.Webhook.Secret != "" {
+		cfg.Notifications.Webhook.Secret = redacted
+	}
+	if cfg.Watchdog.Webhook.Secret != "" {
+		cfg.Watchdog.Webhook.Secret = redacted
+	}
+	if cfg.Auth.JWTSecret != "" {
+		cfg.Auth.JWTSecret = redacted
+	}
+	for name := range cfg.Logging.OTLP.Headers {
+		cfg.Logging.OTLP.Headers[name] = redacted
+	}
+
+	fmt.Printf("# profile: %s\n", cfg.Profile)
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
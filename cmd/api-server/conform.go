@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/conformance"
+	"github.com/dazraf/go-api-example/pkg/client"
+)
+
+// runConform runs the conformance checks against a live deployment, e.g. to
+// verify a proxy or gateway in front of it has not broken the API
+func runConform(args []string) error {
+	flags := flag.NewFlagSet("conform", flag.ExitOnError)
+	baseURL := flags.String("base-url", "", "URL the API is served at, e.g. https://staging.example.com (required)")
+	token := flags.String("token", os.Getenv("CONFORM_TOKEN"), "bearer token sent with every request (default: $CONFORM_TOKEN)")
+	run := flags.String("run", "", "only run checks whose names match this regular expression")
+	timeout := flags.Duration("timeout", 2*time.Minute, "how long the whole run may take")
+	list := flags.Bool("list", false, "list the checks without running them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *list {
+		for _, name := range conformance.Checks() {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	if *baseURL == "" {
+		return errors.New("-base-url is required")
+	}
+	var opts conformance.Options
+	if *run != "" {
+		pattern, err := regexp.Compile(*run)
+		if err != nil {
+			return fmt.Errorf("invalid -run: %w", err)
+		}
+		opts.Run = pattern
+	}
+
+	c := client.New(*baseURL)
+	c.Token = *token
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report := conformance.Run(ctx, c, opts)
+	for _, result := range report.Results {
+		switch {
+		case result.Skipped != "":
+			fmt.Printf("SKIP  %s: %s\n", result.Name, result.Skipped)
+		case result.Err != nil:
+			fmt.Printf("FAIL  %s (%v): %v\n", result.Name, result.Duration.Round(time.Millisecond), result.Err)
+		default:
+			fmt.Printf("PASS  %s (%v)\n", result.Name, result.Duration.Round(time.Millisecond))
+		}
+	}
+	if len(report.Leftover) > 0 {
+		fmt.Printf("Could not delete test user(s) %v\n", report.Leftover)
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d check(s) failed against %s", failed, len(report.Results), *baseURL)
+	}
+	fmt.Printf("All %d check(s) passed against %s\n", len(report.Results), *baseURL)
+	return nil
+}
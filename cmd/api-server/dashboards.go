@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dazraf/go-api-example/internal/metrics"
+)
+
+// runDashboards prints a Grafana dashboard of the API's RED metrics, or
+// writes it to a file for provisioning
+func runDashboards(args []string) error {
+	flags := flag.NewFlagSet("dashboards", flag.ExitOnError)
+	out := flags.String("out", "", "file to write the dashboard JSON to (default: stdout)")
+	title := flags.String("title", "User API", "dashboard title")
+	uid := flags.String("uid", "user-api-red", "dashboard UID, kept stable so imports replace the dashboard")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := metrics.Dashboard(metrics.DashboardOptions{Title: *title, UID: *uid})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write dashboard: %w", err)
+	}
+	return nil
+}
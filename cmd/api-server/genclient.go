@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/swaggo/swag"
+
+	_ "github.com/dazraf/go-api-example/api" // Load swagger docs
+	"github.com/dazraf/go-api-example/internal/apidocs"
+)
+
+// runGenClient generates a TypeScript or Python client for the API, so
+// frontend and data teams use clients with the same names and conventions
+func runGenClient(args []string) error {
+	flags := flag.NewFlagSet("genclient", flag.ExitOnError)
+	lang := flags.String("lang", "", "language to generate the client in: "+strings.Join(apidocs.ClientLanguages, " or ")+" (required)")
+	out := flags.String("out", "./clients", "directory the client is written to")
+	spec := flags.String("spec", "", "OpenAPI document to generate from: a file, or a URL such as https://api.example.com/docs/openapi.json (default: the document this binary serves)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *lang == "" {
+		return errors.New("-lang is required")
+	}
+
+	doc, err := readSpec(*spec)
+	if err != nil {
+		return err
+	}
+	loaded, err := apidocs.Load(doc)
+	if err != nil {
+		return err
+	}
+	name, code, err := apidocs.GenerateClient(loaded, *lang)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(*out, name)
+	if err := os.WriteFile(path, code, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the %s client for %s %s to %s\n", *lang, loaded.Info.Title, loaded.Info.Version, path)
+	return nil
+}
+
+// readSpec reads the OpenAPI document at source, a URL or file, or the one
+// compiled in when source is empty
+func readSpec(source string) (string, error) {
+	switch {
+	case source == "":
+		return swag.ReadDoc()
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetching %s returned %s", source, resp.Status)
+		}
+		doc, err := io.ReadAll(resp.Body)
+		return string(doc), err
+	default:
+		doc, err := os.ReadFile(source)
+		return string(doc), err
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/dazraf/go-api-example/internal/app"
+	"github.com/dazraf/go-api-example/internal/codec"
 )
 
 // @title           User API
@@ -21,15 +25,45 @@ import (
 // @host      localhost:8080
 // @BasePath  /
 
+// commands maps subcommand names to their implementations
+var commands = map[string]func(args []string) error{
+	"config":     runConfig,
+	"conform":    runConform,
+	"dashboards": runDashboards,
+	"genclient":  runGenClient,
+	"openapi":    runOpenAPI,
+	"verify":     runVerify,
+}
+
 func main() {
+	// Dispatch to a subcommand when one is given
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		if err := command(os.Args[2:]); err != nil {
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	failFast := flag.Bool("fail-fast", false, "check dependencies once at startup instead of waiting for them")
+	flag.Parse()
+
 	// Initialize application
-	application, err := app.New()
+	application, err := app.NewWithOptions(app.Options{FailFast: *failFast})
 	if err != nil {
 		log.Fatalf("Failed to initialize application: %v", err)
 	}
+	defer func() {
+		if err := application.Close(); err != nil {
+			log.Printf("Failed to close application: %v", err)
+		}
+	}()
 
 	// Start server
-	log.Printf("Starting server on %s", application.Config.Server.Address)
+	log.Printf("Starting server on %s using the %s router and %s JSON codec", application.Config.Server.Address, application.Config.Server.Router, codec.Name)
 	if err := application.Run(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dazraf/go-api-example/internal/apidocs"
+)
+
+// runOpenAPI runs an OpenAPI document subcommand
+func runOpenAPI(args []string) error {
+	if len(args) == 0 || args[0] != "diff" {
+		return errors.New("usage: openapi diff [-breaking] old.json new.json")
+	}
+	return runOpenAPIDiff(args[1:])
+}
+
+// runOpenAPIDiff prints the changes between two versions of the OpenAPI
+// document, failing when any of them would break clients of the old one
+func runOpenAPIDiff(args []string) error {
+	flags := flag.NewFlagSet("openapi diff", flag.ExitOnError)
+	breakingOnly := flags.Bool("breaking", false, "only print breaking changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: openapi diff [-breaking] old.json new.json")
+	}
+
+	specs := make([]*apidocs.Spec, 2)
+	for i, path := range flags.Args() {
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if specs[i], err = apidocs.Load(string(doc)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	changes := apidocs.Diff(specs[0], specs[1])
+	for _, change := range changes {
+		switch {
+		case change.Breaking:
+			fmt.Printf("BREAKING  %s\n", change)
+		case !*breakingOnly:
+			fmt.Printf("          %s\n", change)
+		}
+	}
+
+	if breaking := len(apidocs.Breaking(changes)); breaking > 0 {
+		return fmt.Errorf("%d of %d change(s) are breaking", breaking, len(changes))
+	}
+	fmt.Printf("%d change(s), none breaking\n", len(changes))
+	return nil
+}
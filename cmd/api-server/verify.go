@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dazraf/go-api-example/internal/app"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// runVerify checks the integrity of the configured store and prints the report
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair inconsistencies in place")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	application, err := app.New()
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+	defer func() { _ = application.Close() }()
+
+	verifier, ok := application.UserStore.(store.Verifier)
+	if !ok {
+		return errors.New("configured store does not support verification")
+	}
+
+	report, err := verifier.Verify(*repair)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if !report.Healthy() {
+		return fmt.Errorf("found %d integrity issue(s)", len(report.Issues))
+	}
+	return nil
+}
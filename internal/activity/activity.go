@@ -0,0 +1,90 @@
+// Package activity records when authenticated users were last seen. Requests
+// only note the time in memory; the times are written to the store in
+// batches, so busy users do not contend for the store's write lock.
+package activity
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// logger logs flushes of recorded activity
+var logger = logging.Named(logging.Jobs)
+
+// Tracker collects user activity and flushes it to the store
+type Tracker struct {
+	recorder store.ActivityRecorder
+	clock    clock.Clock
+
+	mutex   sync.Mutex
+	pending map[int64]time.Time
+}
+
+// NewTracker creates a tracker that writes activity to recorder
+func NewTracker(recorder store.ActivityRecorder, clk clock.Clock) *Tracker {
+	return &Tracker{recorder: recorder, clock: clk, pending: make(map[int64]time.Time)}
+}
+
+// Middleware notes the activity of the authenticated user making each
+// request. It must run inside the authentication middleware that sets the
+// principal, whose subject is the user ID; anonymous requests are ignored.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := reqctx.PrincipalFrom(r.Context()); ok {
+			if id, err := store.ParseID(principal.Subject); err == nil {
+				t.Seen(id)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Seen notes that the user made a request now
+func (t *Tracker) Seen(userID int64) {
+	now := t.clock.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pending[userID] = now
+}
+
+// Flush writes the activity noted since the last flush to the store
+func (t *Tracker) Flush() error {
+	t.mutex.Lock()
+	batch := t.pending
+	t.pending = make(map[int64]time.Time, len(batch))
+	t.mutex.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return t.recorder.RecordActivity(batch)
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes once more
+// so no activity is lost on shutdown
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				logger.Error("Failed to record user activity", "error", err)
+			}
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				logger.Error("Failed to record user activity", "error", err)
+			}
+			return
+		}
+	}
+}
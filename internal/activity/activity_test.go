@@ -0,0 +1,73 @@
+package activity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+func TestTracker_BatchesActivity(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	john, _ := users.Create(store.User{Name: "John Doe", Email: "john@example.com"})
+	jane, _ := users.Create(store.User{Name: "Jane Smith", Email: "jane@example.com"})
+
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	clk := clock.NewFake(start)
+	tracker := NewTracker(users, clk)
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(principal *reqctx.Principal) {
+		req := httptest.NewRequest("GET", "/api/v1/users", nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	request(&reqctx.Principal{Subject: "1"})
+	clk.Advance(time.Minute)
+	request(&reqctx.Principal{Subject: "1"})
+	request(&reqctx.Principal{Subject: "service-account"})
+	request(nil)
+
+	// Nothing is written until the batch is flushed
+	retrieved, _ := users.GetByID(john.ID)
+	assert.Nil(t, retrieved.LastSeenAt)
+
+	require.NoError(t, tracker.Flush())
+	retrieved, _ = users.GetByID(john.ID)
+	require.NotNil(t, retrieved.LastSeenAt)
+	assert.Equal(t, start.Add(time.Minute), *retrieved.LastSeenAt)
+
+	retrieved, _ = users.GetByID(jane.ID)
+	assert.Nil(t, retrieved.LastSeenAt)
+}
+
+func TestTracker_RunFlushesOnStop(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	john, _ := users.Create(store.User{Name: "John Doe", Email: "john@example.com"})
+	tracker := NewTracker(users, clock.Real())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tracker.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	tracker.Seen(john.ID)
+	cancel()
+	<-done
+
+	retrieved, _ := users.GetByID(john.ID)
+	assert.NotNil(t, retrieved.LastSeenAt)
+}
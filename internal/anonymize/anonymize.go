@@ -0,0 +1,101 @@
+// Package anonymize replaces the names and emails of users with realistic
+// fakes, so production data can seed non-production environments. Fakes are
+// derived from user IDs only: the same user always gets the same fake, IDs
+// and timestamps are kept, so everything that refers to users by ID still
+// does, and nothing about the original data can be recovered from them.
+package anonymize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// DefaultDomain is the domain of fake emails, reserved for examples so no
+// email is ever delivered to a real mailbox
+const DefaultDomain = "example.com"
+
+var firstNames = []string{
+	"Aaliyah", "Adam", "Amara", "Andrew", "Bea", "Carlos", "Chloe", "Daniel",
+	"Elena", "Emeka", "Fatima", "George", "Hana", "Isaac", "Ivy", "Jamal",
+	"Julia", "Kai", "Leila", "Liam", "Maya", "Mateo", "Nadia", "Noah",
+	"Olivia", "Omar", "Priya", "Quinn", "Rosa", "Samuel", "Sofia", "Theo",
+	"Uma", "Victor", "Wen", "Xavier", "Yara", "Yusuf", "Zoe", "Zane",
+}
+
+var lastNames = []string{
+	"Abbott", "Adeyemi", "Becker", "Brennan", "Castillo", "Chen", "Dubois",
+	"Evans", "Fischer", "Garcia", "Haddad", "Hughes", "Ivanova", "Jensen",
+	"Kaur", "Kowalski", "Larsen", "Lopez", "Mensah", "Moreau", "Nakamura",
+	"Novak", "Okafor", "Olsen", "Patel", "Quinn", "Rossi", "Santos", "Schmidt",
+	"Silva", "Tanaka", "Turner", "Ueda", "Varga", "Walsh", "Weber", "Xu",
+	"Yilmaz", "Young", "Zhang",
+}
+
+// Anonymizer fakes the personal data of users
+type Anonymizer struct {
+	domain string
+}
+
+// New creates an anonymizer giving fake emails at domain, or at
+// DefaultDomain when it is empty
+func New(domain string) *Anonymizer {
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	return &Anonymizer{domain: domain}
+}
+
+// User returns user with a fake name and email. Emails stay unique, as they
+// include the ID.
+func (a *Anonymizer) User(user store.User) store.User {
+	sum := sha256.Sum256(binary.BigEndian.AppendUint64(nil, uint64(user.ID)))
+	first := firstNames[binary.BigEndian.Uint32(sum[0:4])%uint32(len(firstNames))]
+	last := lastNames[binary.BigEndian.Uint32(sum[4:8])%uint32(len(lastNames))]
+	user.Name = first + " " + last
+	user.Email = fmt.Sprintf("%s.%s.%d@%s", strings.ToLower(first), strings.ToLower(last), user.ID, a.domain)
+	return user
+}
+
+// Report is the outcome of anonymizing a store
+type Report struct {
+	// Users is the number of users anonymized
+	Users int `json:"users" example:"42"`
+}
+
+// Store anonymizes every user in s in place. Stores that can replace users
+// keep their timestamps; others mark them updated. It stops at the first
+// failure or when ctx is done, leaving the users so far anonymized.
+func (a *Anonymizer) Store(ctx context.Context, s store.UserStore) (Report, error) {
+	users, err := s.GetAll()
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	replacer, replaces := s.(store.Replacer)
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		fake := a.User(user)
+		if fake.Name == user.Name && fake.Email == user.Email {
+			report.Users++
+			continue
+		}
+		if replaces {
+			_, err = replacer.Replace(fake)
+		} else {
+			_, err = s.Update(user.ID, fake)
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to anonymize user %d: %w", user.ID, err)
+		}
+		report.Users++
+	}
+	return report, nil
+}
@@ -0,0 +1,98 @@
+package anonymize
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+func TestAnonymizer_User(t *testing.T) {
+	anonymizer := New("")
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	user := store.User{ID: 7, Name: "John Doe", Email: "john@example.org", CreatedAt: created, UpdatedAt: created}
+
+	fake := anonymizer.User(user)
+	assert.Equal(t, int64(7), fake.ID)
+	assert.Equal(t, created, fake.CreatedAt)
+	assert.NotEqual(t, "John Doe", fake.Name)
+	assert.Len(t, strings.Fields(fake.Name), 2)
+	assert.True(t, strings.HasSuffix(fake.Email, ".7@example.com"), fake.Email)
+
+	// The same user always gets the same fake, whatever their data
+	user.Name, user.Email = "Johnny", "johnny@example.org"
+	assert.Equal(t, fake, anonymizer.User(user))
+	assert.Equal(t, fake, anonymizer.User(fake))
+
+	// Emails stay unique
+	emails := make(map[string]bool)
+	for id := range int64(1000) {
+		email := anonymizer.User(store.User{ID: id + 1}).Email
+		assert.False(t, emails[email], "email %s given twice", email)
+		emails[email] = true
+	}
+
+	assert.True(t, strings.HasSuffix(New("staging.test").User(user).Email, "@staging.test"))
+}
+
+// updateOnly hides the Replacer of a store
+type updateOnly struct {
+	store.UserStore
+}
+
+func TestAnonymizer_Store(t *testing.T) {
+	anonymizer := New("")
+	tests := []struct {
+		name           string
+		wrap           func(store.UserStore) store.UserStore
+		keepsTimestamp bool
+	}{
+		{name: "replacer", wrap: func(s store.UserStore) store.UserStore { return s }, keepsTimestamp: true},
+		{name: "updates", wrap: func(s store.UserStore) store.UserStore { return updateOnly{s} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := store.NewMemoryUserStore()
+			john, err := memory.Create(store.User{Name: "John Doe", Email: "john@example.org"})
+			require.NoError(t, err)
+			_, err = memory.Create(store.User{Name: "Jane Smith", Email: "jane@example.org"})
+			require.NoError(t, err)
+			s := tt.wrap(memory)
+
+			report, err := anonymizer.Store(context.Background(), s)
+			require.NoError(t, err)
+			assert.Equal(t, Report{Users: 2}, report)
+
+			users, err := s.GetAll()
+			require.NoError(t, err)
+			for _, user := range users {
+				assert.Equal(t, anonymizer.User(user), user)
+				assert.NotContains(t, user.Email, "example.org")
+			}
+			got, err := s.GetByID(john.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.keepsTimestamp, got.UpdatedAt.Equal(john.UpdatedAt))
+
+			// Anonymizing again changes nothing
+			again, err := anonymizer.Store(context.Background(), s)
+			require.NoError(t, err)
+			assert.Equal(t, report, again)
+			same, err := s.GetByID(john.ID)
+			require.NoError(t, err)
+			assert.Equal(t, got, same)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	memory := store.NewMemoryUserStore()
+	_, err := memory.Create(store.User{Name: "John Doe", Email: "john@example.org"})
+	require.NoError(t, err)
+	_, err = anonymizer.Store(ctx, memory)
+	assert.ErrorIs(t, err, context.Canceled)
+}
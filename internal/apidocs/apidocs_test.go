@@ -0,0 +1,405 @@
+package apidocs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDoc = `{
+	"swagger": "2.0",
+	"info": {"title": "User API", "description": "A simple user management API", "version": "1.0"},
+	"basePath": "/",
+	"paths": {
+		"/api/v1/users/{id}": {
+			"put": {
+				"summary": "Update a user",
+				"tags": ["users"],
+				"parameters": [
+					{"type": "integer", "name": "id", "in": "path", "required": true, "description": "User ID"},
+					{"name": "user", "in": "body", "required": true, "schema": {"$ref": "#/definitions/store.User"}}
+				],
+				"responses": {"200": {"description": "OK"}, "404": {"description": "Not Found"}}
+			}
+		},
+		"/api/v1/users": {
+			"get": {
+				"summary": "List users",
+				"tags": ["users"],
+				"parameters": [{"type": "string", "name": "inactive_since", "in": "query"}],
+				"responses": {"200": {"description": "OK"}}
+			}
+		},
+		"/api/v1/users/{id}/sessions": {
+			"get": {
+				"summary": "List sessions",
+				"tags": ["auth"],
+				"parameters": [{"type": "integer", "name": "id", "in": "path", "required": true}],
+				"responses": {"200": {"description": "OK"}, "401": {"description": "Unauthorized"}}
+			}
+		}
+	},
+	"definitions": {
+		"store.User": {
+			"type": "object",
+			"properties": {
+				"id": {"type": "integer", "example": 1},
+				"name": {"type": "string", "example": "John Doe"},
+				"tags": {"type": "array", "items": {"type": "string"}},
+				"active": {"type": "boolean"}
+			}
+		}
+	}
+}`
+
+func loadTestSpec(t *testing.T) *Spec {
+	t.Helper()
+	spec, err := Load(testDoc)
+	require.NoError(t, err)
+	return spec
+}
+
+func TestPostman(t *testing.T) {
+	collection := Postman(loadTestSpec(t), "http://localhost:8080")
+
+	assert.Equal(t, "User API", collection.Info.Name)
+	assert.Equal(t, PostmanSchema, collection.Info.Schema)
+	assert.Equal(t, PostmanVariable{Key: "baseUrl", Value: "http://localhost:8080"}, collection.Variable[0])
+
+	// Folders per tag, in order
+	require.Len(t, collection.Item, 2)
+	assert.Equal(t, "auth", collection.Item[0].Name)
+	users := collection.Item[1]
+	assert.Equal(t, "users", users.Name)
+	require.Len(t, users.Item, 2)
+	assert.Equal(t, "List users", users.Item[0].Name)
+
+	update := users.Item[1].Request
+	assert.Equal(t, "PUT", update.Method)
+	assert.Equal(t, "{{baseUrl}}/api/v1/users/:id", update.URL.Raw)
+	assert.Equal(t, []string{"api", "v1", "users", ":id"}, update.URL.Path)
+	assert.Equal(t, []PostmanVariable{{Key: "id", Value: "1", Description: "User ID"}}, update.URL.Variable)
+	require.NotNil(t, update.Body)
+	assert.JSONEq(t, `{"id": 1, "name": "John Doe", "tags": [""], "active": false}`, update.Body.Raw)
+	assert.Nil(t, update.Auth)
+
+	list := users.Item[0].Request
+	assert.Equal(t, []PostmanVariable{{Key: "inactive_since", Disabled: true}}, list.URL.Query)
+	assert.Nil(t, list.Body)
+
+	// Endpoints that can respond 401 send the token
+	sessions := collection.Item[0].Item[0].Request
+	require.NotNil(t, sessions.Auth)
+	assert.Equal(t, "bearer", sessions.Auth.Type)
+	assert.Equal(t, "{{token}}", sessions.Auth.Bearer[0].Value)
+}
+
+func TestInsomnia(t *testing.T) {
+	export := Insomnia(loadTestSpec(t), "http://localhost:8080")
+
+	assert.Equal(t, "export", export.Type)
+	assert.Equal(t, 4, export.Format)
+
+	byID := make(map[string]InsomniaResource)
+	for _, resource := range export.Resources {
+		byID[resource.ID] = resource
+	}
+	assert.Equal(t, "http://localhost:8080", byID["env_base"].Data["base_url"])
+	assert.Equal(t, "auth", byID["fld_1"].Name)
+
+	sessions := byID["req_1"]
+	assert.Equal(t, "fld_1", sessions.ParentID)
+	assert.Equal(t, "{{ _.base_url }}/api/v1/users/1/sessions", sessions.URL)
+	assert.Equal(t, map[string]string{"type": "bearer", "token": "{{ _.token }}"}, sessions.Authentication)
+
+	update := byID["req_3"]
+	assert.Equal(t, "PUT", update.Method)
+	require.NotNil(t, update.Body)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal([]byte(update.Body.Text), &body))
+	assert.Equal(t, "John Doe", body["name"])
+}
+
+func TestBaseURL(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://api.example.com/docs/postman.json", nil)
+	assert.Equal(t, "http://api.example.com", BaseURL(req, "/"))
+
+	req.Header.Set("X-Forwarded-Proto", "https")
+	assert.Equal(t, "https://api.example.com/v2", BaseURL(req, "/v2"))
+}
+
+func TestUIPage(t *testing.T) {
+	tests := []struct {
+		name   string
+		ui     string
+		script string
+		tag    string
+	}{
+		{name: "redoc", ui: UIRedoc, script: `src="/docs/assets/redoc.standalone.js"`, tag: `<redoc spec-url="/docs/openapi.json">`},
+		{name: "rapidoc", ui: UIRapiDoc, script: `src="/docs/assets/rapidoc-min.js"`, tag: `<rapi-doc spec-url="/docs/openapi.json"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := UIPage(tt.ui, "/docs/openapi.json", "/docs/assets/")
+			require.NoError(t, err)
+			assert.Contains(t, string(page), tt.script)
+			assert.Contains(t, string(page), tt.tag)
+			// No inline scripts, so the page works under a strict CSP
+			assert.NotContains(t, string(page), "<script>")
+		})
+	}
+
+	_, err := UIPage("swagger", "/docs/openapi.json", "/docs/assets/")
+	assert.Error(t, err)
+	assert.False(t, HasBundle("swagger"))
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	handler := recorder.Handler("PUT", "/api/v1/users/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "missing") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": "user not found"}`))
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/users/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	saved := `{"id": 1, "name": "Jane Roe", "password": "hunter22"}`
+	// The handler still reads the whole body
+	assert.Equal(t, saved, send(saved).Body.String())
+	assert.Equal(t, http.StatusNotFound, send(`{"name": "missing"}`).Code)
+
+	merged, err := recorder.Merge(testDoc)
+	require.NoError(t, err)
+	spec, err := Load(merged)
+	require.NoError(t, err)
+
+	// The latest request is the body example, and the exports use it
+	update := spec.Paths["/api/v1/users/{id}"]["put"]
+	assert.Equal(t, map[string]any{"name": "missing"}, update.Parameters[1].Examples["application/json"])
+	body, ok := spec.body(endpoint{Method: "PUT", Path: "/api/v1/users/{id}", Operation: update})
+	require.True(t, ok)
+	assert.JSONEq(t, `{"name": "missing"}`, body)
+
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal([]byte(merged), &raw))
+	responses := raw["paths"].(map[string]any)["/api/v1/users/{id}"].(map[string]any)["put"].(map[string]any)["responses"].(map[string]any)
+	assert.Equal(t,
+		map[string]any{"application/json": map[string]any{"id": float64(1), "name": "Jane Roe", "password": "********"}},
+		responses["200"].(map[string]any)["examples"])
+	assert.Equal(t,
+		map[string]any{"application/json": map[string]any{"error": "user not found"}},
+		responses["404"].(map[string]any)["examples"])
+}
+
+func TestDiff(t *testing.T) {
+	const (
+		put  = "PUT /api/v1/users/{id}"
+		list = "GET /api/v1/users"
+	)
+	user := func(s *Spec) Schema { return s.Definitions["store.User"] }
+	// Both versions return the user they update
+	returnsUser := func(s *Spec) {
+		update := s.Paths["/api/v1/users/{id}"]["put"]
+		update.Responses["200"] = Response{Description: "OK", Schema: &Schema{Ref: "#/definitions/store.User"}}
+	}
+
+	tests := []struct {
+		name   string
+		change func(s *Spec)
+		want   []Change
+	}{
+		{
+			name:   "unchanged",
+			change: func(s *Spec) {},
+		},
+		{
+			name:   "operation removed",
+			change: func(s *Spec) { delete(s.Paths, "/api/v1/users") },
+			want:   []Change{{Breaking: true, Endpoint: list, Message: "operation removed"}},
+		},
+		{
+			name: "operation added",
+			change: func(s *Spec) {
+				s.Paths["/api/v1/users"]["post"] = Operation{Responses: map[string]Response{"201": {}}}
+			},
+			want: []Change{{Endpoint: "POST /api/v1/users", Message: "operation added"}},
+		},
+		{
+			name: "parameters added",
+			change: func(s *Spec) {
+				operation := s.Paths["/api/v1/users"]["get"]
+				operation.Parameters = append(operation.Parameters,
+					Parameter{Name: "tenant", In: "header", Type: "string", Required: true},
+					Parameter{Name: "limit", In: "query", Type: "integer"})
+				s.Paths["/api/v1/users"]["get"] = operation
+			},
+			want: []Change{
+				{Breaking: true, Endpoint: list, Message: `required header parameter "tenant" added`},
+				{Endpoint: list, Message: `optional query parameter "limit" added`},
+			},
+		},
+		{
+			name: "parameter now required and narrowed",
+			change: func(s *Spec) {
+				param := &s.Paths["/api/v1/users"]["get"].Parameters[0]
+				param.Required = true
+				param.Enum = []any{"30d"}
+			},
+			want: []Change{
+				{Breaking: true, Endpoint: list, Message: `query parameter "inactive_since" is now required`},
+				{Breaking: true, Endpoint: list, Message: `query parameter "inactive_since" no longer accepts [any other value]`},
+			},
+		},
+		{
+			name: "path parameter type changed",
+			change: func(s *Spec) {
+				s.Paths["/api/v1/users/{id}"]["put"].Parameters[0].Type = "string"
+			},
+			want: []Change{{Breaking: true, Endpoint: put, Message: `path parameter "id" changed type from integer to string`}},
+		},
+		{
+			name: "request field required",
+			change: func(s *Spec) {
+				schema := user(s)
+				schema.Required = []string{"name"}
+				s.Definitions["store.User"] = schema
+			},
+			want: []Change{{Breaking: true, Endpoint: put, Message: `request body field "name" is now required`}},
+		},
+		{
+			name: "response fields changed",
+			change: func(s *Spec) {
+				returnsUser(s)
+				schema := user(s)
+				delete(schema.Properties, "active")
+				schema.Properties["email"] = Schema{Type: "string"}
+				schema.Properties["tags"] = Schema{Type: "array", Items: &Schema{Type: "integer"}}
+				s.Definitions["store.User"] = schema
+			},
+			want: []Change{
+				{Breaking: true, Endpoint: put, Message: `request body field "active" removed`},
+				{Breaking: true, Endpoint: put, Message: `request body field "tags[]" changed type from string to integer`},
+				{Endpoint: put, Message: `request body field "email" added`},
+				{Endpoint: put, Message: `200 response has a body`},
+			},
+		},
+		{
+			name: "definition renamed",
+			change: func(s *Spec) {
+				s.Definitions["handlers.User"] = user(s)
+				delete(s.Definitions, "store.User")
+				s.Paths["/api/v1/users/{id}"]["put"].Parameters[1].Schema = &Schema{
+					AllOf: []Schema{{Ref: "#/definitions/handlers.User"}},
+				}
+			},
+		},
+		{
+			name: "error response removed",
+			change: func(s *Spec) {
+				delete(s.Paths["/api/v1/users/{id}"]["put"].Responses, "404")
+			},
+			want: []Change{{Endpoint: put, Message: "404 response removed"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newSpec := loadTestSpec(t)
+			tt.change(newSpec)
+			assert.Equal(t, tt.want, Diff(loadTestSpec(t), newSpec))
+		})
+	}
+
+	t.Run("response field removed", func(t *testing.T) {
+		oldSpec, newSpec := loadTestSpec(t), loadTestSpec(t)
+		returnsUser(oldSpec)
+		returnsUser(newSpec)
+		schema := user(newSpec)
+		delete(schema.Properties, "name")
+		newSpec.Definitions["store.User"] = schema
+
+		changes := Diff(oldSpec, newSpec)
+		assert.Equal(t, []Change{
+			{Breaking: true, Endpoint: put, Message: `request body field "name" removed`},
+			{Breaking: true, Endpoint: put, Message: `200 response field "name" removed`},
+		}, changes)
+		assert.Len(t, Breaking(changes), 2)
+	})
+}
+
+func TestGenerateClient(t *testing.T) {
+	spec := loadTestSpec(t)
+
+	name, code, err := GenerateClient(spec, "ts")
+	require.NoError(t, err)
+	assert.Equal(t, "client.ts", name)
+	ts := string(code)
+	assert.Contains(t, ts, "export interface User {\n  active?: boolean;\n  id?: number;\n  name?: string;\n  tags?: string[];\n}")
+	assert.Contains(t, ts, "async updateUser(id: number, body: User): Promise<void> {\n"+
+		"    await this.request(\"PUT\", `/api/v1/users/${encodeURIComponent(String(id))}`, { body });")
+	assert.Contains(t, ts, "async listUsers(query: { inactive_since?: string } = {}): Promise<void>")
+	assert.Contains(t, ts, "async listSessions(id: number)")
+
+	name, code, err = GenerateClient(spec, "python")
+	require.NoError(t, err)
+	assert.Equal(t, "client.py", name)
+	py := string(code)
+	assert.Contains(t, py, "class User(TypedDict, total=False):\n    active: bool\n    id: int\n    name: str\n    tags: list[str]\n")
+	assert.Contains(t, py, "    def update_user(self, id: int, body: User) -> None:\n"+
+		"        \"\"\"Update a user\"\"\"\n"+
+		"        return self._request(\"PUT\", f\"/api/v1/users/{_quote(id)}\", body=body)\n")
+	assert.Contains(t, py, "def list_users(self, *, inactive_since: str | None = None) -> None:")
+
+	_, _, err = GenerateClient(spec, "cobol")
+	assert.Error(t, err)
+}
+
+func TestClientNames(t *testing.T) {
+	tests := []struct {
+		text  string
+		camel string
+		snake string
+	}{
+		{"Get a user's avatar", "getUserAvatar", "get_user_avatar"},
+		{"Get the OpenAPI document", "getOpenApiDocument", "get_open_api_document"},
+		{"startIndex", "startIndex", "start_index"},
+		{"Get SCIM service provider configuration", "getScimServiceProviderConfiguration", "get_scim_service_provider_configuration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.camel, camel(nameWords(tt.text)))
+			assert.Equal(t, tt.snake, snake(nameWords(tt.text)))
+		})
+	}
+
+	names := typeNames(map[string]Schema{
+		"github_com_org_repo_internal_store.User": {},
+		"github_com_org_repo_internal_scim.User":  {},
+		"github_com_org_repo_internal_scim.Error": {},
+		"internal_handlers.UserState":             {},
+	})
+	assert.Equal(t, map[string]string{
+		"github_com_org_repo_internal_store.User": "StoreUser",
+		"github_com_org_repo_internal_scim.User":  "ScimUser",
+		"github_com_org_repo_internal_scim.Error": "ScimError",
+		"internal_handlers.UserState":             "UserState",
+	}, names)
+}
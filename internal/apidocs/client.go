@@ -0,0 +1,236 @@
+package apidocs
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ClientLanguages lists the languages clients can be generated in
+var ClientLanguages = []string{"python", "ts"}
+
+// GenerateClient generates a client for spec in lang, returning the name of
+// the file to write it to and its source. Clients have a method per
+// operation, named after its summary, and a type per definition. They send
+// a bearer token when given one, and raise API errors with their status,
+// message and trace ID, like the Go client in pkg/client.
+func GenerateClient(spec *Spec, lang string) (string, []byte, error) {
+	model := newClientModel(spec)
+	switch lang {
+	case "ts":
+		return "client.ts", []byte(model.typeScript()), nil
+	case "python":
+		return "client.py", []byte(model.python()), nil
+	default:
+		return "", nil, fmt.Errorf("unknown client language %q, expected one of %s", lang, strings.Join(ClientLanguages, ", "))
+	}
+}
+
+// clientModel is what clients are generated from, whatever their language
+type clientModel struct {
+	spec *Spec
+	// typeNames are the names of the definitions' types
+	typeNames  map[string]string
+	types      []clientType
+	operations []clientOperation
+}
+
+// clientType is a named object type
+type clientType struct {
+	Name   string
+	Schema Schema
+}
+
+// clientOperation is a method of a client
+type clientOperation struct {
+	// Words make up the method name, which each language joins its own way
+	Words      []string
+	Method     string
+	Path       string
+	Summary    string
+	PathParams []Parameter
+	Query      []Parameter
+	Body       *Schema
+	// Result is the schema of the first successful response, if it has one
+	Result *Schema
+	// Binary results are files returned as they are
+	Binary bool
+}
+
+func newClientModel(spec *Spec) *clientModel {
+	m := &clientModel{spec: spec, typeNames: typeNames(spec.Definitions)}
+	for _, key := range sortedKeys(spec.Definitions) {
+		m.types = append(m.types, clientType{Name: m.typeNames[key], Schema: spec.Definitions[key]})
+	}
+	sort.Slice(m.types, func(i, j int) bool { return m.types[i].Name < m.types[j].Name })
+
+	used := make(map[string]bool)
+	for _, path := range sortedKeys(spec.Paths) {
+		for _, method := range sortedKeys(spec.Paths[path]) {
+			operation := spec.Paths[path][method]
+			op := clientOperation{Method: strings.ToUpper(method), Path: path, Summary: operation.Summary}
+
+			op.Words = nameWords(operation.Summary)
+			if len(op.Words) == 0 {
+				op.Words = nameWords(method + " " + path)
+			}
+			// Summaries are not guaranteed to be unique
+			name := strings.Join(op.Words, " ")
+			for n := 2; used[name]; n++ {
+				name = strings.Join(op.Words, " ") + fmt.Sprint(" ", n)
+			}
+			used[name] = true
+			op.Words = strings.Fields(name)
+
+			// Path parameters are passed in the order they appear in the path
+			for _, param := range operation.Parameters {
+				switch param.In {
+				case "path":
+					op.PathParams = append(op.PathParams, param)
+				case "query":
+					op.Query = append(op.Query, param)
+				case "body":
+					op.Body = param.Schema
+				}
+			}
+			sort.SliceStable(op.PathParams, func(i, j int) bool {
+				return strings.Index(path, "{"+op.PathParams[i].Name+"}") < strings.Index(path, "{"+op.PathParams[j].Name+"}")
+			})
+
+			// Operations that can be accepted to finish later, with 202, are
+			// typed by the response for finishing straight away
+			statuses := sortedKeys(operation.Responses)
+			sort.SliceStable(statuses, func(i, j int) bool {
+				return statuses[i] != "202" && statuses[j] == "202"
+			})
+			for _, status := range statuses {
+				if strings.HasPrefix(status, "2") {
+					op.Result = operation.Responses[status].Schema
+					break
+				}
+			}
+			op.Binary = op.Result != nil && op.Result.Type == "file" ||
+				len(operation.Produces) > 0 && !slices.Contains(operation.Produces, "application/json")
+			m.operations = append(m.operations, op)
+		}
+	}
+	return m
+}
+
+// reservedNames are used by the clients or the languages' standard
+// libraries, so types named after them would shadow them
+var reservedNames = map[string]bool{
+	"Any": true, "ApiError": true, "Blob": true, "Client": true, "ClientOptions": true, "Date": true,
+	"Error": true, "Exception": true, "Literal": true, "Object": true, "Record": true, "Request": true,
+	"Required": true, "Response": true, "TypedDict": true, "URL": true,
+}
+
+// typeNames names each definition's type after its Go type, prefixing the
+// package for names more than one package uses, or that are reserved
+func typeNames(definitions map[string]Schema) map[string]string {
+	short := make(map[string]string, len(definitions))
+	count := make(map[string]int, len(definitions))
+	for key := range definitions {
+		_, name := splitDefinition(key)
+		short[key] = name
+		count[name]++
+	}
+	names := make(map[string]string, len(definitions))
+	for key, name := range short {
+		if count[name] > 1 || reservedNames[name] {
+			pkg, _ := splitDefinition(key)
+			name = pascal(nameWords(pkg)) + name
+		}
+		names[key] = name
+	}
+	return names
+}
+
+// splitDefinition splits a definition such as
+// "github_com_org_repo_internal_store.User" into its package, "store", and
+// type name, "User"
+func splitDefinition(key string) (pkg, name string) {
+	pkg, name, ok := strings.Cut(key, ".")
+	if !ok {
+		return "", pascal(nameWords(key))
+	}
+	if i := strings.LastIndexAny(pkg, "_/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	if !identifier.MatchString(name) {
+		name = pascal(nameWords(name))
+	}
+	return pkg, name
+}
+
+// typeName returns the name of the type a reference is to
+func (m *clientModel) typeName(ref string) string {
+	return m.typeNames[strings.TrimPrefix(ref, "#/definitions/")]
+}
+
+var (
+	possessive = regexp.MustCompile(`'s\b`)
+	nonWord    = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	// articles are left out of method names: "Get a user" is getUser
+	articles = map[string]bool{"a": true, "an": true, "the": true}
+)
+
+// nameWords splits text into the lower case words of an identifier,
+// splitting camelCase words too
+func nameWords(text string) []string {
+	var words []string
+	for _, field := range nonWord.Split(possessive.ReplaceAllString(text, ""), -1) {
+		for _, word := range splitCamel(field) {
+			word = strings.ToLower(word)
+			if word != "" && !articles[word] {
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// splitCamel splits "startIndex" into "start" and "Index", keeping runs of
+// capitals such as "SCIM" together
+func splitCamel(s string) []string {
+	runes := []rune(s)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		upper := unicode.IsUpper(runes[i])
+		if upper && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+func pascal(words []string) string {
+	var b strings.Builder
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		runes := []rune(word)
+		b.WriteString(string(unicode.ToUpper(runes[0])) + string(runes[1:]))
+	}
+	return b.String()
+}
+
+func camel(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	return words[0] + pascal(words[1:])
+}
+
+func snake(words []string) string {
+	return strings.Join(words, "_")
+}
+
+// identifier matches names most languages accept as they are
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
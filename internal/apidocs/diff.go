@@ -0,0 +1,313 @@
+package apidocs
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Change is a difference between two versions of an OpenAPI document
+type Change struct {
+	// Breaking changes can fail clients written against the old version
+	Breaking bool `json:"breaking"`
+	// Endpoint is the method and path changed, e.g. "GET /api/v1/users"
+	Endpoint string `json:"endpoint"`
+	Message  string `json:"message"`
+}
+
+func (c Change) String() string {
+	return c.Endpoint + ": " + c.Message
+}
+
+// Breaking returns the breaking changes among changes
+func Breaking(changes []Change) []Change {
+	var breaking []Change
+	for _, change := range changes {
+		if change.Breaking {
+			breaking = append(breaking, change)
+		}
+	}
+	return breaking
+}
+
+// Diff compares two versions of a document, returning the changes by
+// endpoint. Changes are breaking when a client of the old version could
+// fail against the new one: operations, parameters or response fields
+// removed, requests needing more than they did, and types changed.
+// Definitions are compared where they are used, so renaming one is not a
+// change.
+func Diff(old, new *Spec) []Change {
+	d := &differ{old: old, new: new}
+	for _, path := range sortedKeys(old.Paths) {
+		for _, method := range sortedKeys(old.Paths[path]) {
+			d.endpoint = strings.ToUpper(method) + " " + path
+			operation, ok := new.Paths[path][method]
+			if !ok {
+				d.add(true, "operation removed")
+				continue
+			}
+			d.operation(old.Paths[path][method], operation)
+		}
+	}
+	for _, path := range sortedKeys(new.Paths) {
+		for _, method := range sortedKeys(new.Paths[path]) {
+			if _, ok := old.Paths[path][method]; !ok {
+				d.endpoint = strings.ToUpper(method) + " " + path
+				d.add(false, "operation added")
+			}
+		}
+	}
+	return d.changes
+}
+
+// differ collects the changes to the endpoint being compared
+type differ struct {
+	old, new *Spec
+	endpoint string
+	changes  []Change
+}
+
+func (d *differ) add(breaking bool, format string, args ...any) {
+	d.changes = append(d.changes, Change{Breaking: breaking, Endpoint: d.endpoint, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *differ) operation(old, new Operation) {
+	oldParams, newParams := paramsByKey(old.Parameters), paramsByKey(new.Parameters)
+	for _, key := range sortedKeys(oldParams) {
+		param := oldParams[key]
+		if _, ok := newParams[key]; !ok {
+			d.add(true, "%s removed", describeParam(param))
+			continue
+		}
+		d.param(param, newParams[key])
+	}
+	for _, key := range sortedKeys(newParams) {
+		param := newParams[key]
+		if _, ok := oldParams[key]; ok {
+			continue
+		}
+		if param.Required {
+			d.add(true, "required %s added", describeParam(param))
+		} else {
+			d.add(false, "optional %s added", describeParam(param))
+		}
+	}
+
+	for _, status := range sortedKeys(old.Responses) {
+		response, ok := new.Responses[status]
+		if !ok {
+			// Clients are written against the successful responses; error
+			// responses are documented rather than relied on
+			d.add(strings.HasPrefix(status, "2"), "%s response removed", status)
+			continue
+		}
+		oldSchema, newSchema := old.Responses[status].Schema, response.Schema
+		switch {
+		case oldSchema != nil && newSchema != nil:
+			d.schema(status+" response", "", *oldSchema, *newSchema, false, 0)
+		case oldSchema != nil:
+			d.add(true, "%s response no longer has a body", status)
+		case newSchema != nil:
+			d.add(false, "%s response has a body", status)
+		}
+	}
+	for _, status := range sortedKeys(new.Responses) {
+		if _, ok := old.Responses[status]; !ok {
+			d.add(false, "%s response added", status)
+		}
+	}
+}
+
+func (d *differ) param(old, new Parameter) {
+	what := describeParam(old)
+	switch {
+	case !old.Required && new.Required:
+		d.add(true, "%s is now required", what)
+	case old.Required && !new.Required:
+		d.add(false, "%s is now optional", what)
+	}
+	if old.Schema != nil && new.Schema != nil {
+		d.schema("request body", "", *old.Schema, *new.Schema, true, 0)
+		return
+	}
+	d.scalar(what, old.Type, new.Type, old.Format, new.Format, old.Enum, new.Enum, true)
+}
+
+// schema compares a request or response body, or the field of one named
+// field, which is empty for the body itself
+func (d *differ) schema(where, field string, old, new Schema, request bool, depth int) {
+	if depth > maxExampleDepth {
+		return
+	}
+	old, new = d.old.resolve(old, 0), d.new.resolve(new, 0)
+	what := where
+	if field != "" {
+		what = fmt.Sprintf("%s field %q", where, field)
+	}
+	if !d.scalar(what, old.Type, new.Type, old.Format, new.Format, old.Enum, new.Enum, request) {
+		return
+	}
+
+	if old.Items != nil && new.Items != nil {
+		d.schema(where, field+"[]", *old.Items, *new.Items, request, depth+1)
+	}
+
+	for _, name := range sortedKeys(old.Properties) {
+		property, ok := new.Properties[name]
+		if !ok {
+			// A field dropped from a request is silently ignored, losing
+			// what clients send in it
+			d.add(true, "%s field %q removed", where, join(field, name))
+			continue
+		}
+		d.schema(where, join(field, name), old.Properties[name], property, request, depth+1)
+
+		wasRequired, isRequired := slices.Contains(old.Required, name), slices.Contains(new.Required, name)
+		switch {
+		case request && !wasRequired && isRequired:
+			d.add(true, "%s field %q is now required", where, join(field, name))
+		case !request && wasRequired && !isRequired:
+			d.add(true, "%s field %q may now be missing", where, join(field, name))
+		case request && wasRequired && !isRequired:
+			d.add(false, "%s field %q is now optional", where, join(field, name))
+		case !request && !wasRequired && isRequired:
+			d.add(false, "%s field %q is now always present", where, join(field, name))
+		}
+	}
+	for _, name := range sortedKeys(new.Properties) {
+		if _, ok := old.Properties[name]; ok {
+			continue
+		}
+		if request && slices.Contains(new.Required, name) {
+			d.add(true, "required %s field %q added", where, join(field, name))
+		} else {
+			d.add(false, "%s field %q added", where, join(field, name))
+		}
+	}
+}
+
+// scalar compares the type, format and allowed values of what, reporting
+// whether the types match so their contents can be compared
+func (d *differ) scalar(what, oldType, newType, oldFormat, newFormat string, oldEnum, newEnum []any, request bool) bool {
+	if oldType != newType && oldType != "" && newType != "" {
+		d.add(true, "%s changed type from %s to %s", what, oldType, newType)
+		return false
+	}
+	if oldFormat != newFormat && oldFormat != "" {
+		d.add(true, "%s changed format from %q to %q", what, oldFormat, newFormat)
+	}
+
+	// Requests break when values they send are refused, and responses when
+	// they hold values clients have not seen
+	removed, added := enumDiff(oldEnum, newEnum)
+	switch {
+	case len(removed) > 0 && request:
+		d.add(true, "%s no longer accepts %v", what, removed)
+	case len(added) > 0 && !request:
+		d.add(true, "%s may now be %v", what, added)
+	case len(removed) > 0 || len(added) > 0:
+		d.add(false, "%s allowed values changed from %v to %v", what, oldEnum, newEnum)
+	}
+	return true
+}
+
+// enumDiff returns the values of old missing from new and those new adds.
+// No values means any value, so restricting values removes some.
+func enumDiff(old, new []any) (removed, added []any) {
+	switch {
+	case len(old) == 0 && len(new) == 0:
+		return nil, nil
+	case len(old) == 0:
+		return []any{"any other value"}, nil
+	case len(new) == 0:
+		return nil, []any{"any other value"}
+	}
+	for _, value := range old {
+		if !containsValue(new, value) {
+			removed = append(removed, value)
+		}
+	}
+	for _, value := range new {
+		if !containsValue(old, value) {
+			added = append(added, value)
+		}
+	}
+	return removed, added
+}
+
+func containsValue(values []any, value any) bool {
+	for _, v := range values {
+		if fmt.Sprint(v) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve follows schema's reference and merges its allOf parts, so the
+// fields of a schema can be compared whatever its definition is called
+func (s *Spec) resolve(schema Schema, depth int) Schema {
+	for schema.Ref != "" && depth <= maxExampleDepth {
+		schema = s.Definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")]
+		depth++
+	}
+	if len(schema.AllOf) == 0 {
+		return schema
+	}
+
+	merged := schema
+	merged.AllOf = nil
+	merged.Properties = make(map[string]Schema, len(schema.Properties))
+	for name, property := range schema.Properties {
+		merged.Properties[name] = property
+	}
+	for _, part := range schema.AllOf {
+		part = s.resolve(part, depth+1)
+		if merged.Type == "" {
+			merged.Type = part.Type
+		}
+		for name, property := range part.Properties {
+			merged.Properties[name] = property
+		}
+		merged.Required = append(merged.Required, part.Required...)
+	}
+	return merged
+}
+
+// paramsByKey indexes parameters by where they are and their name. An
+// operation has one body, whatever it is called.
+func paramsByKey(params []Parameter) map[string]Parameter {
+	byKey := make(map[string]Parameter, len(params))
+	for _, param := range params {
+		key := param.In + " " + param.Name
+		if param.In == "body" {
+			key = "body"
+		}
+		byKey[key] = param
+	}
+	return byKey
+}
+
+func describeParam(param Parameter) string {
+	if param.In == "body" {
+		return "request body"
+	}
+	return fmt.Sprintf("%s parameter %q", param.In, param.Name)
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
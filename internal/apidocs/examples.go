@@ -0,0 +1,231 @@
+package apidocs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxExampleBody is the largest request or response body recorded; larger
+// ones, such as big user lists, make poor examples
+const maxExampleBody = 64 << 10
+
+// redactedFields are replaced in recorded examples when a JSON key contains
+// one of them, so credentials never reach the docs
+var redactedFields = []string{"password", "token", "secret"}
+
+// Recorder captures real request and response bodies per route and merges
+// them into the OpenAPI document as examples. It is meant for development,
+// where the traffic is realistic but not sensitive.
+type Recorder struct {
+	mu       sync.Mutex
+	examples map[string]*routeExamples // keyed by method and path
+}
+
+// routeExamples holds the latest examples recorded for a route
+type routeExamples struct {
+	method    string
+	path      string
+	request   any
+	responses map[string]any // keyed by status code
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{examples: make(map[string]*routeExamples)}
+}
+
+// Handler wraps next, the handler for the route with method and path as they
+// appear in the document, recording the JSON bodies it receives and sends
+func (rec *Recorder) Handler(method, path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request any
+		if r.Body != nil && isJSON(r.Header.Get("Content-Type")) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxExampleBody+1))
+			if err == nil {
+				request = decodeExample(body)
+			}
+			// The handler reads the body again, including anything past the limit
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		capture := &captureWriter{ResponseWriter: w}
+		next.ServeHTTP(capture, r)
+
+		var response any
+		if isJSON(w.Header().Get("Content-Type")) && !capture.truncated {
+			response = decodeExample(capture.body.Bytes())
+		}
+		rec.record(method, path, request, capture.statusCode(), response)
+	})
+}
+
+func (rec *Recorder) record(method, path string, request any, status int, response any) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	key := method + " " + path
+	examples, ok := rec.examples[key]
+	if !ok {
+		examples = &routeExamples{method: method, path: path, responses: make(map[string]any)}
+		rec.examples[key] = examples
+	}
+	if request != nil {
+		examples.request = request
+	}
+	if response != nil {
+		examples.responses[strconv.Itoa(status)] = response
+	}
+}
+
+// Merge returns doc with the recorded examples added to its operations.
+// Responses get them under examples, and body parameters under x-examples,
+// which Redoc renders. Only responses the document already declares are
+// filled in.
+func (rec *Recorder) Merge(doc string) (string, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.examples) == 0 {
+		return doc, nil
+	}
+
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(doc), &spec); err != nil {
+		return "", fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	paths, _ := spec["paths"].(map[string]any)
+	for _, examples := range rec.examples {
+		methods, _ := paths[examples.path].(map[string]any)
+		operation, ok := methods[strings.ToLower(examples.method)].(map[string]any)
+		if !ok {
+			continue
+		}
+		if examples.request != nil {
+			parameters, _ := operation["parameters"].([]any)
+			for _, p := range parameters {
+				if param, ok := p.(map[string]any); ok && param["in"] == "body" {
+					param["x-examples"] = map[string]any{"application/json": examples.request}
+				}
+			}
+		}
+		responses, _ := operation["responses"].(map[string]any)
+		for status, example := range examples.responses {
+			if response, ok := responses[status].(map[string]any); ok {
+				response["examples"] = map[string]any{"application/json": example}
+			}
+		}
+	}
+
+	merged, err := json.MarshalIndent(spec, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode OpenAPI document: %w", err)
+	}
+	return string(merged), nil
+}
+
+// ReadDoc wraps readDoc, such as swag.ReadDoc, to serve the document with
+// the recorded examples merged in
+func (rec *Recorder) ReadDoc(readDoc func(...string) (string, error)) func(...string) (string, error) {
+	return func(name ...string) (string, error) {
+		doc, err := readDoc(name...)
+		if err != nil {
+			return "", err
+		}
+		return rec.Merge(doc)
+	}
+}
+
+// decodeExample parses a recorded body, redacting credentials. Bodies that
+// are empty, too large or not JSON are not recorded.
+func decodeExample(body []byte) any {
+	if len(body) == 0 || len(body) > maxExampleBody {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+	return redact(value)
+}
+
+func redact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if isRedacted(key) {
+				v[key] = "********"
+			} else {
+				v[key] = redact(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return value
+}
+
+func isRedacted(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range redactedFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func isJSON(contentType string) bool {
+	return strings.Contains(contentType, "json")
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// captureWriter copies the response body, up to maxExampleBody, as it is
+// written
+type captureWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.body.Len()+len(b) > maxExampleBody {
+		c.truncated = true
+	} else if !c.truncated {
+		c.body.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *captureWriter) statusCode() int {
+	if c.status == 0 {
+		return http.StatusOK
+	}
+	return c.status
+}
@@ -0,0 +1,114 @@
+package apidocs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InsomniaExport is an Insomnia v4 export
+type InsomniaExport struct {
+	Type      string             `json:"_type"`
+	Format    int                `json:"__export_format"`
+	Source    string             `json:"__export_source"`
+	Resources []InsomniaResource `json:"resources"`
+}
+
+// InsomniaResource is a workspace, environment, folder or request in an
+// export. Only the fields for its type are set.
+type InsomniaResource struct {
+	ID             string            `json:"_id"`
+	Type           string            `json:"_type"`
+	ParentID       string            `json:"parentId,omitempty"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	Data           map[string]string `json:"data,omitempty"`
+	Method         string            `json:"method,omitempty"`
+	URL            string            `json:"url,omitempty"`
+	Headers        []InsomniaPair    `json:"headers,omitempty"`
+	Parameters     []InsomniaPair    `json:"parameters,omitempty"`
+	Body           *InsomniaBody     `json:"body,omitempty"`
+	Authentication map[string]string `json:"authentication,omitempty"`
+}
+
+// InsomniaPair is a header or query parameter
+type InsomniaPair struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// InsomniaBody is a request body
+type InsomniaBody struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Insomnia converts spec into an export with a workspace, a base
+// environment holding base_url and token, and a folder per tag. Endpoints
+// that can reject unauthenticated callers send the token as a bearer token.
+func Insomnia(spec *Spec, baseURL string) InsomniaExport {
+	const workspaceID = "wrk_api"
+	export := InsomniaExport{
+		Type:   "export",
+		Format: 4,
+		Source: "go-api-example",
+		Resources: []InsomniaResource{
+			{ID: workspaceID, Type: "workspace", Name: spec.Info.Title, Description: spec.Info.Description},
+			{
+				ID:       "env_base",
+				Type:     "environment",
+				ParentID: workspaceID,
+				Name:     "Base Environment",
+				Data:     map[string]string{"base_url": baseURL, "token": ""},
+			},
+		},
+	}
+
+	tags, byTag := spec.endpoints()
+	requests := 0
+	for i, tag := range tags {
+		folderID := fmt.Sprintf("fld_%d", i+1)
+		export.Resources = append(export.Resources, InsomniaResource{ID: folderID, Type: "request_group", ParentID: workspaceID, Name: tag})
+		for _, e := range byTag[tag] {
+			requests++
+			request := insomniaRequest(spec, e)
+			request.ID = fmt.Sprintf("req_%d", requests)
+			request.ParentID = folderID
+			export.Resources = append(export.Resources, request)
+		}
+	}
+	return export
+}
+
+func insomniaRequest(spec *Spec, e endpoint) InsomniaResource {
+	request := InsomniaResource{
+		Type:        "request",
+		Name:        e.name(),
+		Description: e.Description,
+		Method:      e.Method,
+		Headers:     []InsomniaPair{{Name: "Accept", Value: "application/json"}},
+	}
+
+	// Insomnia has no path parameters, so examples are filled in
+	path := e.Path
+	for _, param := range e.Parameters {
+		switch param.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+param.Name+"}", paramExample(param))
+		case "query":
+			request.Parameters = append(request.Parameters, InsomniaPair{Name: param.Name, Value: paramExample(param), Disabled: !param.Required})
+		case "header":
+			request.Headers = append(request.Headers, InsomniaPair{Name: param.Name, Value: paramExample(param)})
+		}
+	}
+	request.URL = "{{ _.base_url }}" + path
+
+	if body, ok := spec.body(e); ok {
+		request.Headers = append(request.Headers, InsomniaPair{Name: "Content-Type", Value: "application/json"})
+		request.Body = &InsomniaBody{MimeType: "application/json", Text: body}
+	}
+	if e.requiresAuth() {
+		request.Authentication = map[string]string{"type": "bearer", "token": "{{ _.token }}"}
+	}
+	return request
+}
@@ -0,0 +1,136 @@
+package apidocs
+
+import (
+	"strings"
+)
+
+// PostmanSchema is the Postman collection format produced
+const PostmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// PostmanCollection is a Postman v2.1 collection
+type PostmanCollection struct {
+	Info     PostmanInfo       `json:"info"`
+	Item     []PostmanItem     `json:"item"`
+	Variable []PostmanVariable `json:"variable"`
+}
+
+// PostmanInfo describes a collection
+type PostmanInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      string `json:"schema"`
+}
+
+// PostmanItem is a folder of items or a single request
+type PostmanItem struct {
+	Name    string          `json:"name"`
+	Item    []PostmanItem   `json:"item,omitempty"`
+	Request *PostmanRequest `json:"request,omitempty"`
+}
+
+// PostmanRequest is a request in a collection
+type PostmanRequest struct {
+	Method      string            `json:"method"`
+	Description string            `json:"description,omitempty"`
+	Header      []PostmanVariable `json:"header"`
+	URL         PostmanURL        `json:"url"`
+	Body        *PostmanBody      `json:"body,omitempty"`
+	Auth        *PostmanAuth      `json:"auth,omitempty"`
+}
+
+// PostmanURL is a request URL split into its parts
+type PostmanURL struct {
+	Raw      string            `json:"raw"`
+	Host     []string          `json:"host"`
+	Path     []string          `json:"path"`
+	Query    []PostmanVariable `json:"query,omitempty"`
+	Variable []PostmanVariable `json:"variable,omitempty"`
+}
+
+// PostmanVariable is a key and value, used for variables, headers and
+// query parameters
+type PostmanVariable struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+}
+
+// PostmanBody is a raw request body
+type PostmanBody struct {
+	Mode    string         `json:"mode"`
+	Raw     string         `json:"raw"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// PostmanAuth is the authentication a request uses
+type PostmanAuth struct {
+	Type   string            `json:"type"`
+	Bearer []PostmanVariable `json:"bearer,omitempty"`
+}
+
+// Postman converts spec into a collection with a folder per tag. Requests
+// use the {{baseUrl}} variable, set to baseURL, and endpoints that can
+// reject unauthenticated callers send {{token}} as a bearer token.
+func Postman(spec *Spec, baseURL string) PostmanCollection {
+	collection := PostmanCollection{
+		Info: PostmanInfo{Name: spec.Info.Title, Description: spec.Info.Description, Schema: PostmanSchema},
+		Variable: []PostmanVariable{
+			{Key: "baseUrl", Value: baseURL},
+			{Key: "token", Value: "", Description: "Session token from POST /api/v1/login"},
+		},
+	}
+
+	tags, byTag := spec.endpoints()
+	for _, tag := range tags {
+		folder := PostmanItem{Name: tag}
+		for _, e := range byTag[tag] {
+			folder.Item = append(folder.Item, PostmanItem{Name: e.name(), Request: postmanRequest(spec, e)})
+		}
+		collection.Item = append(collection.Item, folder)
+	}
+	return collection
+}
+
+func postmanRequest(spec *Spec, e endpoint) *PostmanRequest {
+	request := &PostmanRequest{
+		Method:      e.Method,
+		Description: e.Description,
+		Header:      []PostmanVariable{{Key: "Accept", Value: "application/json"}},
+		URL:         PostmanURL{Host: []string{"{{baseUrl}}"}},
+	}
+
+	// Postman writes path parameters as :name
+	for _, segment := range strings.Split(strings.Trim(e.Path, "/"), "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			segment = ":" + strings.TrimSuffix(name, "}")
+		}
+		request.URL.Path = append(request.URL.Path, segment)
+	}
+	for _, param := range e.Parameters {
+		variable := PostmanVariable{Key: param.Name, Value: paramExample(param), Description: param.Description}
+		switch param.In {
+		case "path":
+			request.URL.Variable = append(request.URL.Variable, variable)
+		case "query":
+			variable.Disabled = !param.Required
+			request.URL.Query = append(request.URL.Query, variable)
+		case "header":
+			request.Header = append(request.Header, variable)
+		}
+	}
+	request.URL.Raw = "{{baseUrl}}/" + strings.Join(request.URL.Path, "/")
+
+	if body, ok := spec.body(e); ok {
+		request.Header = append(request.Header, PostmanVariable{Key: "Content-Type", Value: "application/json"})
+		request.Body = &PostmanBody{
+			Mode:    "raw",
+			Raw:     body,
+			Options: map[string]any{"raw": map[string]string{"language": "json"}},
+		}
+	}
+	if e.requiresAuth() {
+		request.Auth = &PostmanAuth{Type: "bearer", Bearer: []PostmanVariable{{Key: "token", Value: "{{token}}"}}}
+	}
+	return request
+}
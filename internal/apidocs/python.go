@@ -0,0 +1,286 @@
+package apidocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pythonKeywords cannot be used as names
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true, "async": true,
+	"await": true, "break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// python renders the client as a Python 3.11 module using only the
+// standard library, with a TypedDict per definition
+func (m *clientModel) python() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Code generated by api-server genclient from %s %s. DO NOT EDIT.\n", m.spec.Info.Title, m.spec.Info.Version)
+	b.WriteString(pyImports)
+
+	// Aliases are evaluated when defined, so they follow the classes they
+	// may refer to
+	var aliases []string
+	for _, typ := range m.types {
+		if len(typ.Schema.Properties) == 0 {
+			aliases = append(aliases, fmt.Sprintf("%s = %s\n", typ.Name, m.pyType(typ.Schema)))
+			continue
+		}
+		b.WriteString("\n\n")
+		m.pyTypedDict(&b, typ)
+	}
+	if len(aliases) > 0 {
+		b.WriteString("\n\n" + strings.Join(aliases, ""))
+	}
+
+	b.WriteString(pyRuntime)
+
+	for _, op := range m.operations {
+		var params, query []string
+		for _, param := range op.PathParams {
+			params = append(params, fmt.Sprintf("%s: %s", pyName(param.Name), m.pyParamType(param)))
+		}
+		if op.Body != nil {
+			params = append(params, "body: "+m.pyType(*op.Body))
+		}
+		if len(op.Query) > 0 {
+			params = append(params, "*")
+			for _, param := range op.Query {
+				params = append(params, fmt.Sprintf("%s: %s | None = None", pyName(param.Name), m.pyParamType(param)))
+				query = append(query, fmt.Sprintf("%q: %s", param.Name, pyName(param.Name)))
+			}
+		}
+
+		result := "None"
+		switch {
+		case op.Binary:
+			result = "bytes"
+		case op.Result != nil:
+			result = m.pyType(*op.Result)
+		}
+
+		path := fmt.Sprintf("%q", op.Path)
+		if len(op.PathParams) > 0 {
+			path = op.Path
+			for _, param := range op.PathParams {
+				path = strings.ReplaceAll(path, "{"+param.Name+"}", fmt.Sprintf("{_quote(%s)}", pyName(param.Name)))
+			}
+			path = fmt.Sprintf("f%q", path)
+		}
+		args := []string{fmt.Sprintf("%q", op.Method), path}
+		if len(query) > 0 {
+			args = append(args, fmt.Sprintf("query={%s}", strings.Join(query, ", ")))
+		}
+		if op.Body != nil {
+			args = append(args, "body=body")
+		}
+		if op.Binary {
+			args = append(args, "binary=True")
+		}
+
+		fmt.Fprintf(&b, "\n    def %s(%s) -> %s:\n", snake(op.Words), strings.Join(append([]string{"self"}, params...), ", "), result)
+		if op.Summary != "" {
+			fmt.Fprintf(&b, "        \"\"\"%s\"\"\"\n", pyDoc(op.Summary))
+		}
+		fmt.Fprintf(&b, "        return self._request(%s)\n", strings.Join(args, ", "))
+	}
+	return b.String()
+}
+
+// pyTypedDict renders an object definition. Fields are optional unless
+// required, and names that are not identifiers need the functional syntax.
+func (m *clientModel) pyTypedDict(b *strings.Builder, typ clientType) {
+	fields := sortedKeys(typ.Schema.Properties)
+	fieldType := func(name string) string {
+		t := m.pyType(typ.Schema.Properties[name])
+		if slices.Contains(typ.Schema.Required, name) {
+			t = "Required[" + t + "]"
+		}
+		return t
+	}
+
+	for _, name := range fields {
+		if identifier.MatchString(name) && !pythonKeywords[name] {
+			continue
+		}
+		entries := make([]string, len(fields))
+		for i, field := range fields {
+			entries[i] = fmt.Sprintf("%q: %q", field, fieldType(field))
+		}
+		fmt.Fprintf(b, "%s = TypedDict(%q, {%s}, total=False)\n", typ.Name, typ.Name, strings.Join(entries, ", "))
+		return
+	}
+
+	fmt.Fprintf(b, "class %s(TypedDict, total=False):\n", typ.Name)
+	if doc := pyDoc(typ.Schema.Description); doc != "" {
+		fmt.Fprintf(b, "    \"\"\"%s\"\"\"\n\n", doc)
+	}
+	for _, name := range fields {
+		fmt.Fprintf(b, "    %s: %s\n", name, fieldType(name))
+	}
+}
+
+// pyType renders the type of values schema describes
+func (m *clientModel) pyType(schema Schema) string {
+	switch {
+	case schema.Ref != "":
+		return m.typeName(schema.Ref)
+	case len(schema.AllOf) == 1:
+		return m.pyType(schema.AllOf[0])
+	case len(schema.AllOf) > 1:
+		return "dict[str, Any]"
+	case len(schema.Enum) > 0:
+		literals := make([]string, len(schema.Enum))
+		for i, value := range schema.Enum {
+			literals[i] = pyLiteral(value)
+		}
+		return "Literal[" + strings.Join(literals, ", ") + "]"
+	}
+	switch schema.Type {
+	case "integer":
+		return "int"
+	case "number":
+		return "float"
+	case "string":
+		return "str"
+	case "boolean":
+		return "bool"
+	case "file":
+		return "bytes"
+	case "array":
+		if schema.Items == nil {
+			return "list[Any]"
+		}
+		return "list[" + m.pyType(*schema.Items) + "]"
+	case "object":
+		return "dict[str, Any]"
+	default:
+		return "Any"
+	}
+}
+
+func (m *clientModel) pyParamType(param Parameter) string {
+	return m.pyType(Schema{Type: param.Type, Enum: param.Enum})
+}
+
+// pyName converts an API name to a snake_case Python name, avoiding
+// keywords such as async
+func pyName(name string) string {
+	name = snake(nameWords(name))
+	if pythonKeywords[name] {
+		name += "_"
+	}
+	return name
+}
+
+func pyLiteral(value any) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case nil:
+		return "None"
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// pyDoc makes text safe to put in a docstring
+func pyDoc(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	return strings.ReplaceAll(text, `"""`, `\"\"\"`)
+}
+
+const pyImports = `
+from __future__ import annotations
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Literal, Required, TypedDict
+`
+
+// pyRuntime is the error type and the start of the client class, which the
+// operations' methods are added to
+const pyRuntime = `
+
+
+class ApiError(Exception):
+    """ApiError is raised for responses with a status other than 2xx"""
+
+    def __init__(self, status: int, message: str, trace_id: str | None = None, body: bytes = b"", code: str | None = None):
+        super().__init__(message)
+        self.status = status
+        # code says what went wrong, such as USER_NOT_FOUND
+        self.code = code
+        # trace_id matches the error to the server's logs
+        self.trace_id = trace_id
+        self.body = body
+
+
+def _quote(value: Any) -> str:
+    return urllib.parse.quote(_format(value), safe="")
+
+
+def _format(value: Any) -> str:
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    return str(value)
+
+
+class Client:
+    """Client calls the API at a base URL, such as https://api.example.com"""
+
+    def __init__(self, base_url: str, token: str | None = None, timeout: float = 30.0):
+        self.base_url = base_url.rstrip("/")
+        # token is sent as a bearer token, when set
+        self.token = token
+        self.timeout = timeout
+
+    def _request(
+        self,
+        method: str,
+        path: str,
+        query: dict[str, Any] | None = None,
+        body: Any = None,
+        binary: bool = False,
+    ) -> Any:
+        url = self.base_url + path
+        params = {key: _format(value) for key, value in (query or {}).items() if value is not None}
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+        headers = {"Accept": "*/*" if binary else "application/json"}
+        data = None
+        if body is not None:
+            data = json.dumps(body).encode()
+            headers["Content-Type"] = "application/json"
+        if self.token:
+            headers["Authorization"] = "Bearer " + self.token
+
+        request = urllib.request.Request(url, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout) as response:
+                content = response.read()
+        except urllib.error.HTTPError as e:
+            content = e.read()
+            try:
+                error = json.loads(content)
+            except ValueError:
+                error = {}
+            if not isinstance(error, dict):
+                error = {}
+            raise ApiError(e.code, error.get("error") or f"API returned {e.code}", error.get("trace_id"), content, error.get("code")) from None
+        if binary:
+            return content
+        return json.loads(content) if content else None
+`
@@ -0,0 +1,226 @@
+// Package apidocs converts the served OpenAPI (Swagger 2.0) document into
+// collections that API clients such as Postman and Insomnia can import, and
+// embeds the Redoc and RapiDoc UIs that render it.
+package apidocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Spec is the part of a Swagger 2.0 document the exports use
+type Spec struct {
+	Info        Info                            `json:"info"`
+	BasePath    string                          `json:"basePath"`
+	Paths       map[string]map[string]Operation `json:"paths"`
+	Definitions map[string]Schema               `json:"definitions"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+// Operation is a single method on a path
+type Operation struct {
+	Summary     string              `json:"summary"`
+	Description string              `json:"description"`
+	Tags        []string            `json:"tags"`
+	Produces    []string            `json:"produces"`
+	Parameters  []Parameter         `json:"parameters"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter is an operation's path, query, header or body parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Type        string  `json:"type"`
+	Format      string  `json:"format"`
+	Enum        []any   `json:"enum"`
+	Schema      *Schema `json:"schema"`
+	// Examples are body examples by media type, as merged in by a Recorder
+	Examples map[string]any `json:"x-examples"`
+}
+
+// Response is an operation's response for one status code
+type Response struct {
+	Description string  `json:"description"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Schema describes a JSON value
+type Schema struct {
+	Ref         string            `json:"$ref"`
+	Description string            `json:"description"`
+	Type        string            `json:"type"`
+	Format      string            `json:"format"`
+	Enum        []any             `json:"enum"`
+	Required    []string          `json:"required"`
+	Properties  map[string]Schema `json:"properties"`
+	Items       *Schema           `json:"items"`
+	AllOf       []Schema          `json:"allOf"`
+	Example     any               `json:"example"`
+}
+
+// Load parses a Swagger 2.0 document
+func Load(doc string) (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal([]byte(doc), &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	return &spec, nil
+}
+
+// endpoint is an operation along with its method and path
+type endpoint struct {
+	Method string
+	Path   string
+	Operation
+}
+
+// endpoints returns every operation grouped by its first tag, with groups
+// and the endpoints in them in a stable order
+func (s *Spec) endpoints() (tags []string, byTag map[string][]endpoint) {
+	byTag = make(map[string][]endpoint)
+	for path, methods := range s.Paths {
+		for method, operation := range methods {
+			tag := "default"
+			if len(operation.Tags) > 0 {
+				tag = operation.Tags[0]
+			}
+			byTag[tag] = append(byTag[tag], endpoint{Method: strings.ToUpper(method), Path: path, Operation: operation})
+		}
+	}
+
+	for tag, endpoints := range byTag {
+		tags = append(tags, tag)
+		sort.Slice(endpoints, func(i, j int) bool {
+			if endpoints[i].Path != endpoints[j].Path {
+				return endpoints[i].Path < endpoints[j].Path
+			}
+			return endpoints[i].Method < endpoints[j].Method
+		})
+	}
+	sort.Strings(tags)
+	return tags, byTag
+}
+
+// name is the endpoint's display name
+func (e endpoint) name() string {
+	if e.Summary != "" {
+		return e.Summary
+	}
+	return e.Method + " " + e.Path
+}
+
+// requiresAuth reports whether the endpoint can reject unauthenticated
+// callers, so clients should send the token
+func (e endpoint) requiresAuth() bool {
+	_, ok := e.Responses["401"]
+	return ok
+}
+
+// body returns the endpoint's example JSON body, if it takes one, preferring
+// a recorded example to one built from the schema
+func (s *Spec) body(e endpoint) (string, bool) {
+	for _, param := range e.Parameters {
+		if param.In != "body" || param.Schema == nil {
+			continue
+		}
+		example, ok := param.Examples["application/json"]
+		if !ok {
+			example = s.example(*param.Schema, 0)
+		}
+		encoded, err := json.MarshalIndent(example, "", "  ")
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+	return "", false
+}
+
+// maxExampleDepth stops examples of recursive schemas
+const maxExampleDepth = 8
+
+// example builds an example value for schema from the examples in the
+// document, falling back to zero values
+func (s *Spec) example(schema Schema, depth int) any {
+	if schema.Example != nil {
+		return schema.Example
+	}
+	if depth > maxExampleDepth {
+		return nil
+	}
+	if schema.Ref != "" {
+		return s.example(s.Definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")], depth+1)
+	}
+
+	switch {
+	case len(schema.AllOf) > 0:
+		merged := make(map[string]any)
+		for _, part := range schema.AllOf {
+			if object, ok := s.example(part, depth+1).(map[string]any); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged
+	case schema.Type == "array":
+		if schema.Items == nil {
+			return []any{}
+		}
+		return []any{s.example(*schema.Items, depth+1)}
+	case schema.Type == "object" || schema.Properties != nil:
+		object := make(map[string]any, len(schema.Properties))
+		for name, property := range schema.Properties {
+			object[name] = s.example(property, depth+1)
+		}
+		return object
+	default:
+		return zeroValue(schema.Type)
+	}
+}
+
+// paramExample returns a placeholder value for a path or query parameter
+func paramExample(param Parameter) string {
+	if param.Type == "integer" || param.Type == "number" {
+		return "1"
+	}
+	return ""
+}
+
+func zeroValue(typ string) any {
+	switch typ {
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	case "string":
+		return ""
+	default:
+		return nil
+	}
+}
+
+// BaseURL returns the URL the API was reached at, for the exports'
+// environment
+func BaseURL(r *http.Request, basePath string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := r.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	return scheme + "://" + r.Host + strings.TrimSuffix(basePath, "/")
+}
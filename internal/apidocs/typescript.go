@@ -0,0 +1,258 @@
+package apidocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// typeScript renders the client as a TypeScript module with no
+// dependencies, calling the API with fetch
+func (m *clientModel) typeScript() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by api-server genclient from %s %s. DO NOT EDIT.\n\n", m.spec.Info.Title, m.spec.Info.Version)
+
+	for _, typ := range m.types {
+		writeTSDoc(&b, "", typ.Schema.Description)
+		if len(typ.Schema.Properties) == 0 {
+			fmt.Fprintf(&b, "export type %s = %s;\n\n", typ.Name, m.tsType(typ.Schema, 0))
+			continue
+		}
+		fmt.Fprintf(&b, "export interface %s %s\n\n", typ.Name, m.tsObject(typ.Schema, 0))
+	}
+
+	b.WriteString(tsRuntime)
+
+	for _, op := range m.operations {
+		var params []string
+		for _, param := range op.PathParams {
+			params = append(params, fmt.Sprintf("%s: %s", camel(nameWords(param.Name)), m.tsParamType(param)))
+		}
+		if op.Body != nil {
+			params = append(params, "body: "+m.tsType(*op.Body, 0))
+		}
+		if len(op.Query) > 0 {
+			fields := make([]string, len(op.Query))
+			for i, param := range op.Query {
+				fields[i] = fmt.Sprintf("%s?: %s", tsKey(param.Name), m.tsParamType(param))
+			}
+			params = append(params, fmt.Sprintf("query: { %s } = {}", strings.Join(fields, "; ")))
+		}
+
+		result := "void"
+		switch {
+		case op.Binary:
+			result = "Blob"
+		case op.Result != nil:
+			result = m.tsType(*op.Result, 0)
+		}
+
+		path := op.Path
+		for _, param := range op.PathParams {
+			path = strings.ReplaceAll(path, "{"+param.Name+"}", fmt.Sprintf("${encodeURIComponent(String(%s))}", camel(nameWords(param.Name))))
+		}
+		var init []string
+		if len(op.Query) > 0 {
+			init = append(init, "query")
+		}
+		if op.Body != nil {
+			init = append(init, "body")
+		}
+		if op.Binary {
+			init = append(init, "binary: true")
+		}
+
+		request := fmt.Sprintf("this.request(%q, `%s`, {})", op.Method, path)
+		if len(init) > 0 {
+			request = fmt.Sprintf("this.request(%q, `%s`, { %s })", op.Method, path, strings.Join(init, ", "))
+		}
+
+		b.WriteString("\n")
+		writeTSDoc(&b, "  ", op.Summary)
+		fmt.Fprintf(&b, "  async %s(%s): Promise<%s> {\n", camel(op.Words), strings.Join(params, ", "), result)
+		if result == "void" {
+			fmt.Fprintf(&b, "    await %s;\n", request)
+		} else {
+			fmt.Fprintf(&b, "    return (await %s) as %s;\n", request, result)
+		}
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// tsObject renders an object type's fields
+func (m *clientModel) tsObject(schema Schema, depth int) string {
+	var b strings.Builder
+	indent := strings.Repeat("  ", depth)
+	b.WriteString("{\n")
+	for _, name := range sortedKeys(schema.Properties) {
+		property := schema.Properties[name]
+		writeTSDoc(&b, indent+"  ", property.Description)
+		optional := "?"
+		if slices.Contains(schema.Required, name) {
+			optional = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, tsKey(name), optional, m.tsType(property, depth+1))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// tsType renders the type of values schema describes
+func (m *clientModel) tsType(schema Schema, depth int) string {
+	switch {
+	case schema.Ref != "":
+		return m.typeName(schema.Ref)
+	case len(schema.AllOf) > 0:
+		parts := make([]string, len(schema.AllOf))
+		for i, part := range schema.AllOf {
+			parts[i] = m.tsType(part, depth)
+		}
+		return strings.Join(parts, " & ")
+	case len(schema.Enum) > 0:
+		return tsUnion(schema.Enum)
+	}
+	switch schema.Type {
+	case "integer", "number":
+		return "number"
+	case "string":
+		return "string"
+	case "boolean":
+		return "boolean"
+	case "file":
+		return "Blob"
+	case "array":
+		if schema.Items == nil {
+			return "unknown[]"
+		}
+		item := m.tsType(*schema.Items, depth)
+		if strings.ContainsAny(item, "|&") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		if len(schema.Properties) == 0 {
+			return "Record<string, unknown>"
+		}
+		return m.tsObject(schema, depth)
+	default:
+		return "unknown"
+	}
+}
+
+func (m *clientModel) tsParamType(param Parameter) string {
+	return m.tsType(Schema{Type: param.Type, Enum: param.Enum}, 0)
+}
+
+// tsUnion renders enum values as a union of literal types
+func tsUnion(values []any) string {
+	literals := make([]string, len(values))
+	for i, value := range values {
+		encoded, _ := json.Marshal(value)
+		literals[i] = string(encoded)
+	}
+	return strings.Join(literals, " | ")
+}
+
+// tsKey quotes property names that are not identifiers
+func tsKey(name string) string {
+	if identifier.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func writeTSDoc(b *strings.Builder, indent, doc string) {
+	doc = strings.TrimSpace(strings.ReplaceAll(doc, "*/", "*\\/"))
+	if doc == "" {
+		return
+	}
+	lines := strings.Split(doc, "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, doc)
+		return
+	}
+	fmt.Fprintf(b, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(b, "%s * %s\n", indent, line)
+	}
+	fmt.Fprintf(b, "%s */\n", indent)
+}
+
+// tsRuntime is the error type and the start of the client class, which the
+// operations' methods are added to
+const tsRuntime = `/** ApiError is thrown for responses with a status other than 2xx */
+export class ApiError extends Error {
+  constructor(
+    readonly status: number,
+    message: string,
+    /** Matches the error to the server's logs */
+    readonly traceId?: string,
+    /** The response body, as text */
+    readonly body?: string,
+    /** Says what went wrong, such as USER_NOT_FOUND */
+    readonly code?: string,
+  ) {
+    super(message);
+    this.name = "ApiError";
+  }
+}
+
+export interface ClientOptions {
+  /** Sent as a bearer token, when set */
+  token?: string;
+  /** Sends requests, defaulting to the global fetch */
+  fetch?: typeof fetch;
+}
+
+/** Client calls the API at a base URL, such as https://api.example.com */
+export class Client {
+  private readonly baseUrl: string;
+
+  constructor(baseUrl: string, private readonly options: ClientOptions = {}) {
+    this.baseUrl = baseUrl.replace(/\/+$/, "");
+  }
+
+  private async request(
+    method: string,
+    path: string,
+    init: { query?: Record<string, unknown>; body?: unknown; binary?: boolean },
+  ): Promise<unknown> {
+    const url = new URL(this.baseUrl + path);
+    for (const [key, value] of Object.entries(init.query ?? {})) {
+      if (value !== undefined && value !== null) {
+        url.searchParams.set(key, String(value));
+      }
+    }
+    const headers: Record<string, string> = { Accept: init.binary ? "*/*" : "application/json" };
+    if (init.body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    if (this.options.token) {
+      headers.Authorization = ` + "`Bearer ${this.options.token}`" + `;
+    }
+
+    const response = await (this.options.fetch ?? fetch)(url, {
+      method,
+      headers,
+      body: init.body === undefined ? undefined : JSON.stringify(init.body),
+    });
+    if (!response.ok) {
+      const text = await response.text();
+      let error: { error?: string; code?: string; trace_id?: string } = {};
+      try {
+        error = JSON.parse(text);
+      } catch {
+        // Not an error response; the body is kept as it is
+      }
+      throw new ApiError(response.status, error.error || ` + "`API returned ${response.status}`" + `, error.trace_id, text, error.code);
+    }
+    if (init.binary) {
+      return response.blob();
+    }
+    const text = await response.text();
+    return text ? JSON.parse(text) : undefined;
+  }
+`
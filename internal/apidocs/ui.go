@@ -0,0 +1,73 @@
+package apidocs
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+)
+
+// Docs UIs that render the OpenAPI document. Unlike Swagger UI their pages
+// have no inline scripts and load nothing from third-party hosts, so they
+// work under a strict Content-Security-Policy.
+const (
+	UIRedoc   = "redoc"
+	UIRapiDoc = "rapidoc"
+)
+
+// uiBundles is the script each UI loads from ui/assets. The scripts are
+// fetched at pinned versions by make docs-ui and embedded at build time.
+var uiBundles = map[string]string{
+	UIRedoc:   "redoc.standalone.js",
+	UIRapiDoc: "rapidoc-min.js",
+}
+
+//go:embed all:ui
+var uiFiles embed.FS
+
+// UIPage renders the named UI's page, which loads the document from specURL
+// and its script from under assetsURL
+func UIPage(name, specURL, assetsURL string) ([]byte, error) {
+	bundle, ok := uiBundles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown docs UI %q, expected %s or %s", name, UIRedoc, UIRapiDoc)
+	}
+	tmpl, err := template.ParseFS(uiFiles, "ui/"+name+".html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s page: %w", name, err)
+	}
+
+	var page bytes.Buffer
+	data := struct{ Title, SpecURL, Script string }{
+		Title:   "API Reference",
+		SpecURL: specURL,
+		Script:  path.Join(assetsURL, bundle),
+	}
+	if err := tmpl.Execute(&page, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s page: %w", name, err)
+	}
+	return page.Bytes(), nil
+}
+
+// HasBundle reports whether the named UI's script was embedded, so a
+// missing one can be reported instead of serving a blank page
+func HasBundle(name string) bool {
+	bundle, ok := uiBundles[name]
+	if !ok {
+		return false
+	}
+	_, err := fs.Stat(uiFiles, "ui/assets/"+bundle)
+	return err == nil
+}
+
+// Assets returns the UI scripts, to be served at the assetsURL passed to
+// UIPage
+func Assets() fs.FS {
+	assets, err := fs.Sub(uiFiles, "ui/assets")
+	if err != nil {
+		panic(err) // the directory is embedded, so this cannot happen
+	}
+	return assets
+}
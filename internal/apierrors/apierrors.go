@@ -0,0 +1,150 @@
+// Package apierrors is the registry of the codes carried by error
+// responses, so clients can branch on what went wrong instead of parsing
+// messages, which are written for people and may change. Codes are stable:
+// once published, a code keeps its meaning and is never reused.
+//
+// Errors with a code of their own use it; the rest use the code of their
+// status, from ForStatus.
+package apierrors
+
+import "net/http"
+
+// Code identifies the kind of an error response, in upper snake case
+type Code string
+
+// Codes of errors specific enough for clients to handle on their own
+const (
+	// ValidationFailed is a request body whose fields break their rules;
+	// the response lists each field's problem
+	ValidationFailed Code = "VALIDATION_FAILED"
+	// InvalidParameter is a path or query parameter that cannot be parsed
+	InvalidParameter Code = "INVALID_PARAMETER"
+	// UserNotFound is a user that does not exist, or is deleted
+	UserNotFound Code = "USER_NOT_FOUND"
+	// DuplicateEmail is a user whose email another user already has
+	DuplicateEmail Code = "DUPLICATE_EMAIL"
+	// EmailDomainNotAllowed is a user whose email domain the tenant does
+	// not allow
+	EmailDomainNotAllowed Code = "EMAIL_DOMAIN_NOT_ALLOWED"
+	// InvalidCredentials is a login with a wrong email or password
+	InvalidCredentials Code = "INVALID_CREDENTIALS"
+	// InvalidToken is a bearer token that is malformed, expired or revoked
+	InvalidToken Code = "INVALID_TOKEN"
+	// AccountSuspended is a request by or for a suspended user
+	AccountSuspended Code = "ACCOUNT_SUSPENDED"
+	// AdminRequired is a request only admins may make
+	AdminRequired Code = "ADMIN_REQUIRED"
+	// ConsentRequired is a request by a user who has not accepted the
+	// current terms; the response lists the pending documents
+	ConsentRequired Code = "CONSENT_REQUIRED"
+	// DuplicateRequest is a request repeating one still being served
+	DuplicateRequest Code = "DUPLICATE_REQUEST"
+	// RateLimited is a request over the caller's rate limit
+	RateLimited Code = "RATE_LIMITED"
+	// ChangesExpired is a change feed cursor older than the changes kept
+	ChangesExpired Code = "CHANGES_EXPIRED"
+)
+
+// Codes of errors known only by their status
+const (
+	BadRequest           Code = "BAD_REQUEST"
+	Unauthenticated      Code = "UNAUTHENTICATED"
+	Forbidden            Code = "FORBIDDEN"
+	NotFound             Code = "NOT_FOUND"
+	MethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	Conflict             Code = "CONFLICT"
+	Gone                 Code = "GONE"
+	PreconditionFailed   Code = "PRECONDITION_FAILED"
+	PayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	UnsupportedMedia     Code = "UNSUPPORTED_MEDIA_TYPE"
+	Unprocessable        Code = "UNPROCESSABLE"
+	PreconditionRequired Code = "PRECONDITION_REQUIRED"
+	Unavailable          Code = "UNAVAILABLE"
+	Internal             Code = "INTERNAL"
+	NotImplemented       Code = "NOT_IMPLEMENTED"
+	UpstreamFailed       Code = "UPSTREAM_FAILED"
+	UpstreamTimeout      Code = "UPSTREAM_TIMEOUT"
+	UnavailableForLegal  Code = "UNAVAILABLE_FOR_LEGAL_REASONS"
+)
+
+// Definition documents a code
+type Definition struct {
+	Code Code `json:"code" example:"USER_NOT_FOUND"`
+	// Status is the HTTP status responses with the code have
+	Status      int    `json:"status" example:"404"`
+	Description string `json:"description" example:"The user does not exist, or is deleted"`
+}
+
+// definitions is the registry, in the order codes are documented
+var definitions = []Definition{
+	{ValidationFailed, http.StatusBadRequest, "Fields of the request body break their rules; errors lists each field's problem"},
+	{InvalidParameter, http.StatusBadRequest, "A path or query parameter cannot be parsed"},
+	{UserNotFound, http.StatusNotFound, "The user does not exist, or is deleted"},
+	{DuplicateEmail, http.StatusConflict, "Another user already has the email"},
+	{EmailDomainNotAllowed, http.StatusBadRequest, "The tenant does not allow the email's domain"},
+	{InvalidCredentials, http.StatusUnauthorized, "The email or password is wrong"},
+	{InvalidToken, http.StatusUnauthorized, "The bearer token is malformed, expired or revoked"},
+	{AccountSuspended, http.StatusForbidden, "The user is suspended"},
+	{AdminRequired, http.StatusForbidden, "Only admins may make the request"},
+	{ConsentRequired, http.StatusUnavailableForLegalReasons, "The caller has not accepted the current terms; pending lists them"},
+	{DuplicateRequest, http.StatusConflict, "The same request is still being served"},
+	{RateLimited, http.StatusTooManyRequests, "The caller is over its rate limit; retry after Retry-After seconds"},
+	{ChangesExpired, http.StatusGone, "The changes after the cursor are no longer kept; resynchronise from a full listing"},
+	{BadRequest, http.StatusBadRequest, "The request is invalid"},
+	{Unauthenticated, http.StatusUnauthorized, "The request needs authentication"},
+	{Forbidden, http.StatusForbidden, "The caller may not make the request"},
+	{NotFound, http.StatusNotFound, "The resource does not exist"},
+	{MethodNotAllowed, http.StatusMethodNotAllowed, "The resource does not support the method"},
+	{Conflict, http.StatusConflict, "The request conflicts with the resource's state"},
+	{Gone, http.StatusGone, "The resource is no longer available"},
+	{PreconditionFailed, http.StatusPreconditionFailed, "The resource has changed since the ETag in If-Match was read; read it again"},
+	{PayloadTooLarge, http.StatusRequestEntityTooLarge, "The request body is too large"},
+	{UnsupportedMedia, http.StatusUnsupportedMediaType, "The request body's media type is not supported"},
+	{Unprocessable, http.StatusUnprocessableEntity, "The request body is well formed but cannot be processed"},
+	{PreconditionRequired, http.StatusPreconditionRequired, "The request needs an If-Match header with the resource's ETag"},
+	{UnavailableForLegal, http.StatusUnavailableForLegalReasons, "The resource is withheld for legal reasons"},
+	{Internal, http.StatusInternalServerError, "The server failed"},
+	{NotImplemented, http.StatusNotImplemented, "The server does not support the request"},
+	{UpstreamFailed, http.StatusBadGateway, "A service the server depends on failed"},
+	{Unavailable, http.StatusServiceUnavailable, "The server cannot serve the request now; retry later"},
+	{UpstreamTimeout, http.StatusGatewayTimeout, "A service the server depends on did not answer in time"},
+}
+
+// Definitions returns every registered code
+func Definitions() []Definition {
+	return append([]Definition(nil), definitions...)
+}
+
+// byStatus maps statuses to the codes of errors known only by them
+var byStatus = map[int]Code{
+	http.StatusBadRequest:                 BadRequest,
+	http.StatusUnauthorized:               Unauthenticated,
+	http.StatusForbidden:                  Forbidden,
+	http.StatusNotFound:                   NotFound,
+	http.StatusMethodNotAllowed:           MethodNotAllowed,
+	http.StatusConflict:                   Conflict,
+	http.StatusGone:                       Gone,
+	http.StatusPreconditionFailed:         PreconditionFailed,
+	http.StatusRequestEntityTooLarge:      PayloadTooLarge,
+	http.StatusUnsupportedMediaType:       UnsupportedMedia,
+	http.StatusUnprocessableEntity:        Unprocessable,
+	http.StatusPreconditionRequired:       PreconditionRequired,
+	http.StatusTooManyRequests:            RateLimited,
+	http.StatusUnavailableForLegalReasons: UnavailableForLegal,
+	http.StatusNotImplemented:             NotImplemented,
+	http.StatusBadGateway:                 UpstreamFailed,
+	http.StatusServiceUnavailable:         Unavailable,
+	http.StatusGatewayTimeout:             UpstreamTimeout,
+}
+
+// ForStatus returns the code of errors with status and no code of their
+// own. Statuses without one get BadRequest below 500 and Internal above.
+func ForStatus(status int) Code {
+	if code, ok := byStatus[status]; ok {
+		return code
+	}
+	if status < http.StatusInternalServerError {
+		return BadRequest
+	}
+	return Internal
+}
@@ -0,0 +1,54 @@
+package apierrors
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefinitions(t *testing.T) {
+	upperSnake := regexp.MustCompile(`^[A-Z]+(_[A-Z]+)*$`)
+	seen := make(map[Code]bool)
+	for _, definition := range Definitions() {
+		assert.Regexp(t, upperSnake, string(definition.Code))
+		assert.False(t, seen[definition.Code], "%s is registered twice", definition.Code)
+		seen[definition.Code] = true
+		assert.NotEmpty(t, http.StatusText(definition.Status), definition.Code)
+		assert.NotEmpty(t, definition.Description, definition.Code)
+	}
+
+	for status, code := range byStatus {
+		assert.True(t, seen[code], "%s is not registered", code)
+		assert.Contains(t, Definitions(), Definition{Code: code, Status: status, Description: describe(code)}, "%s has another status", code)
+	}
+}
+
+// describe returns the registered description of code
+func describe(code Code) string {
+	for _, definition := range definitions {
+		if definition.Code == code {
+			return definition.Description
+		}
+	}
+	return ""
+}
+
+func TestForStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   Code
+	}{
+		{http.StatusBadRequest, BadRequest},
+		{http.StatusNotFound, NotFound},
+		{http.StatusTooManyRequests, RateLimited},
+		{http.StatusGatewayTimeout, UpstreamTimeout},
+		{http.StatusTeapot, BadRequest},
+		{http.StatusInternalServerError, Internal},
+		{http.StatusLoopDetected, Internal},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ForStatus(tt.status), tt.status)
+	}
+}
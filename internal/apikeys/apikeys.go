@@ -0,0 +1,327 @@
+// Package apikeys issues long-lived API keys to machine callers such as
+// CI jobs and infrastructure tools. Admins manage keys by a stable ID and
+// a unique name; the secret is only shown when a key is created, and only
+// its hash is kept.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+// SecretPrefix starts every key's secret, so keys can be told apart from
+// session tokens and found by secret scanners
+const SecretPrefix = "uak_"
+
+var (
+	// ErrNotFound is returned for keys that do not exist
+	ErrNotFound = errors.New("API key not found")
+	// ErrInvalidName is returned for names that are not lowercase slugs
+	ErrInvalidName = errors.New("API key names are 1-64 lowercase letters, digits and dashes, starting with a letter or digit")
+	// ErrNameTaken is returned when another key has the name
+	ErrNameTaken = errors.New("an API key with this name already exists")
+	// ErrInvalidKey is returned when authenticating with a secret that is
+	// not a key's
+	ErrInvalidKey = errors.New("invalid API key")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Key is an API key, without its secret
+type Key struct {
+	ID   string `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Name string `json:"name" example:"ci-deployer"`
+	// Roles are granted to requests made with the key
+	Roles []string `json:"roles" example:"admin"`
+	// Prefix is the start of the secret, to recognise the key by
+	Prefix     string     `json:"prefix" example:"uak_9c0e8a1d"`
+	CreatedAt  time.Time  `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	UpdatedAt  time.Time  `json:"updated_at" example:"2024-01-02T15:04:05Z"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" example:"2024-01-03T09:00:00Z"`
+}
+
+// Spec is what an admin sets on a key
+type Spec struct {
+	Name  string
+	Roles []string
+}
+
+// Options configures a Store
+type Options struct {
+	Clock clock.Clock
+	IDs   idgen.Generator
+}
+
+// Store holds keys in memory
+type Store struct {
+	mutex sync.Mutex
+	keys  map[string]*Key
+	// hashes maps the SHA-256 of each key's secret to its ID
+	hashes map[string]string
+	opts   Options
+}
+
+// NewStore creates an empty store
+func NewStore(opts Options) *Store {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+	return &Store{keys: make(map[string]*Key), hashes: make(map[string]string), opts: opts}
+}
+
+// Create issues a key, returning it along with its secret, which cannot be
+// retrieved again
+func (s *Store) Create(spec Spec) (Key, string, error) {
+	spec, err := normalize(spec)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.byName(spec.Name) != nil {
+		return Key{}, "", ErrNameTaken
+	}
+	secret := newSecret()
+	now := s.opts.Clock.Now().UTC()
+	key := &Key{
+		ID:        s.opts.IDs.NewID(),
+		Name:      spec.Name,
+		Roles:     spec.Roles,
+		Prefix:    secret[:len(SecretPrefix)+8],
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.keys[key.ID] = key
+	s.hashes[hash(secret)] = key.ID
+	return clone(key), secret, nil
+}
+
+// Get returns the key with id
+func (s *Store) Get(id string) (Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return clone(key), nil
+}
+
+// List returns the keys ordered by name, only the one named name when it
+// is not empty
+func (s *Store) List(name string) []Key {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		if name == "" || key.Name == name {
+			keys = append(keys, clone(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
+
+// Update replaces what is set on the key with id. Setting what is already
+// set changes nothing, including the update time.
+func (s *Store) Update(id string, spec Spec) (Key, error) {
+	spec, err := normalize(spec)
+	if err != nil {
+		return Key{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	if other := s.byName(spec.Name); other != nil && other.ID != id {
+		return Key{}, ErrNameTaken
+	}
+	if key.Name != spec.Name || !slices.Equal(key.Roles, spec.Roles) {
+		key.Name, key.Roles = spec.Name, spec.Roles
+		key.UpdatedAt = s.opts.Clock.Now().UTC()
+	}
+	return clone(key), nil
+}
+
+// Delete revokes the key with id
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return ErrNotFound
+	}
+	s.delete(id)
+	return nil
+}
+
+// Authenticate returns the key secret belongs to, recording its use
+func (s *Store) Authenticate(secret string) (Key, error) {
+	if !strings.HasPrefix(secret, SecretPrefix) {
+		return Key{}, ErrInvalidKey
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Looking the hash up rather than the secret keeps secrets out of
+	// memory and timing independent of how much of a secret matches
+	id, ok := s.hashes[hash(secret)]
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+	key := s.keys[id]
+	now := s.opts.Clock.Now().UTC()
+	key.LastUsedAt = &now
+	return clone(key), nil
+}
+
+// Record is a key with the hash of its secret, as backed up. The secret
+// itself is never kept, so restored keys authenticate with the secrets
+// they were issued with.
+type Record struct {
+	Key
+	Hash string `json:"hash" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+// Export returns every key with the hash of its secret, ordered by name
+func (s *Store) Export() []Record {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]Record, 0, len(s.keys))
+	for h, id := range s.hashes {
+		records = append(records, Record{Key: clone(s.keys[id]), Hash: h})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// Import adds the keys in records, replacing those with the same IDs.
+// Nothing is imported unless every record is valid.
+func (s *Store) Import(records []Record) error {
+	imported := make([]Record, len(records))
+	for i, record := range records {
+		spec, err := normalize(Spec{Name: record.Name, Roles: record.Roles})
+		if err != nil {
+			return fmt.Errorf("API key %s: %w", record.ID, err)
+		}
+		if record.ID == "" || len(record.Hash) != sha256.Size*2 {
+			return fmt.Errorf("API key %q needs an ID and the hex SHA-256 of its secret", record.Name)
+		}
+		record.Key = clone(&record.Key)
+		record.Name, record.Roles = spec.Name, spec.Roles
+		imported[i] = record
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Names must stay unique among the keys kept and those imported
+	names := make(map[string]string, len(s.keys)+len(imported))
+	for _, key := range s.keys {
+		if !slices.ContainsFunc(imported, func(record Record) bool { return record.ID == key.ID }) {
+			names[key.Name] = key.ID
+		}
+	}
+	for _, record := range imported {
+		if id, taken := names[record.Name]; taken && id != record.ID {
+			return fmt.Errorf("API key %q: %w", record.Name, ErrNameTaken)
+		}
+		names[record.Name] = record.ID
+	}
+	for _, record := range imported {
+		s.delete(record.ID)
+		key := record.Key
+		s.keys[key.ID] = &key
+		s.hashes[record.Hash] = key.ID
+	}
+	return nil
+}
+
+// delete removes the key with id and its hash, if any
+func (s *Store) delete(id string) {
+	delete(s.keys, id)
+	for h, keyID := range s.hashes {
+		if keyID == id {
+			delete(s.hashes, h)
+		}
+	}
+}
+
+// byName returns the key named name, if any
+func (s *Store) byName(name string) *Key {
+	for _, key := range s.keys {
+		if key.Name == name {
+			return key
+		}
+	}
+	return nil
+}
+
+// normalize validates spec, sorting its roles so equal sets compare equal
+func normalize(spec Spec) (Spec, error) {
+	if !namePattern.MatchString(spec.Name) {
+		return Spec{}, ErrInvalidName
+	}
+	roles := make([]string, 0, len(spec.Roles))
+	for _, role := range spec.Roles {
+		if !namePattern.MatchString(role) {
+			return Spec{}, fmt.Errorf("invalid role %q: roles are lowercase slugs", role)
+		}
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	slices.Sort(roles)
+	spec.Roles = roles
+	return spec, nil
+}
+
+func clone(key *Key) Key {
+	copied := *key
+	copied.Roles = slices.Clone(key.Roles)
+	if key.LastUsedAt != nil {
+		lastUsed := *key.LastUsedAt
+		copied.LastUsedAt = &lastUsed
+	}
+	return copied
+}
+
+func newSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return SecretPrefix + hex.EncodeToString(b)
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package apikeys
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+func TestStore(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	keys := NewStore(Options{Clock: clk, IDs: idgen.NewSequence("key")})
+
+	key, secret, err := keys.Create(Spec{Name: "ci-deployer", Roles: []string{"admin", "admin"}})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, SecretPrefix))
+	assert.True(t, strings.HasPrefix(secret, key.Prefix))
+	assert.Equal(t, []string{"admin"}, key.Roles)
+
+	_, _, err = keys.Create(Spec{Name: "ci-deployer"})
+	assert.ErrorIs(t, err, ErrNameTaken)
+	_, _, err = keys.Create(Spec{Name: "CI Deployer"})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, _, err = keys.Create(Spec{Name: "reader", Roles: []string{"Admin"}})
+	assert.Error(t, err)
+
+	other, _, err := keys.Create(Spec{Name: "backup"})
+	require.NoError(t, err)
+	assert.Equal(t, []Key{other, key}, keys.List(""))
+	assert.Equal(t, []Key{key}, keys.List("ci-deployer"))
+	assert.Empty(t, keys.List("missing"))
+
+	// Sending what is already set changes nothing
+	clk.Advance(time.Hour)
+	same, err := keys.Update(key.ID, Spec{Name: "ci-deployer", Roles: []string{"admin"}})
+	require.NoError(t, err)
+	assert.Equal(t, key, same)
+
+	updated, err := keys.Update(key.ID, Spec{Name: "deployer", Roles: []string{"admin"}})
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, updated.ID)
+	assert.Equal(t, clk.Now(), updated.UpdatedAt)
+	_, err = keys.Update(key.ID, Spec{Name: "backup"})
+	assert.ErrorIs(t, err, ErrNameTaken)
+	_, err = keys.Update("missing", Spec{Name: "deployer"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	used, err := keys.Authenticate(secret)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, used.ID)
+	require.NotNil(t, used.LastUsedAt)
+	_, err = keys.Authenticate(SecretPrefix + "wrong")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	require.NoError(t, keys.Delete(key.ID))
+	assert.ErrorIs(t, keys.Delete(key.ID), ErrNotFound)
+	_, err = keys.Get(key.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = keys.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestStore_ExportImport(t *testing.T) {
+	keys := NewStore(Options{IDs: idgen.NewSequence("key")})
+	key, secret, err := keys.Create(Spec{Name: "ci-deployer", Roles: []string{"admin"}})
+	require.NoError(t, err)
+	records := keys.Export()
+	require.Len(t, records, 1)
+	assert.Equal(t, key, records[0].Key)
+	assert.NotContains(t, records[0].Hash, secret)
+
+	// Imported keys authenticate with the secrets they were issued with
+	clone := NewStore(Options{IDs: idgen.NewSequence("key")})
+	require.NoError(t, clone.Import(records))
+	got, err := clone.Authenticate(secret)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, got.ID)
+
+	// Importing again replaces the keys with the same IDs
+	require.NoError(t, clone.Import(records))
+	assert.Len(t, clone.List(""), 1)
+
+	_, _, err = keys.Create(Spec{Name: "reader"})
+	require.NoError(t, err)
+	renamed := records[0]
+	renamed.ID = "other"
+	assert.ErrorIs(t, keys.Import([]Record{renamed}), ErrNameTaken)
+	fresh := records[0]
+	fresh.ID, fresh.Name = "fresh", "fresh"
+	invalid := records[0]
+	invalid.Hash = "secret"
+	assert.Error(t, keys.Import([]Record{fresh, invalid}))
+	assert.Len(t, keys.List(""), 2, "nothing is imported unless every record is valid")
+}
@@ -1,86 +1,1073 @@
 package app
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/activity"
+	"github.com/dazraf/go-api-example/internal/anonymize"
+	"github.com/dazraf/go-api-example/internal/apidocs"
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/clock"
 	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/consent"
+	"github.com/dazraf/go-api-example/internal/directory"
 	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/instances"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/mailer"
+	"github.com/dazraf/go-api-example/internal/materialized"
+	"github.com/dazraf/go-api-example/internal/metrics"
+	"github.com/dazraf/go-api-example/internal/middleware"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/orgs"
+	"github.com/dazraf/go-api-example/internal/preferences"
+	"github.com/dazraf/go-api-example/internal/ratelimit"
+	"github.com/dazraf/go-api-example/internal/replication"
+	"github.com/dazraf/go-api-example/internal/reports"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/scripts"
 	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/surrogate"
+	"github.com/dazraf/go-api-example/internal/systemd"
+	"github.com/dazraf/go-api-example/internal/tenants"
+	"github.com/dazraf/go-api-example/internal/transform"
+	"github.com/dazraf/go-api-example/internal/uploads"
+	"github.com/dazraf/go-api-example/internal/usage"
+	"github.com/dazraf/go-api-example/internal/watchdog"
+	"github.com/dazraf/go-api-example/internal/webhooks"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/swaggo/swag"
 
 	_ "github.com/dazraf/go-api-example/api" // Load swagger docs
 )
 
 // Application holds the application dependencies and configuration
 type Application struct {
-	Config      *config.Config
-	Router      *gin.Engine
-	UserStore   store.UserStore
-	UserHandler *handlers.UserHandler
+	Config        *config.Config
+	Clock         clock.Clock
+	IDs           idgen.Generator
+	Router        http.Handler
+	UserStore     store.UserStore
+	UserHandler   *handlers.UserHandler
+	AdminHandler  *handlers.AdminHandler
+	ChangeHandler *handlers.ChangeHandler
+	// NotificationHandler is nil unless notifications are enabled
+	NotificationHandler *handlers.NotificationHandler
+	// AuthHandler is nil unless authentication is enabled
+	AuthHandler *handlers.AuthHandler
+	// ApprovalHandler is nil unless some operations need approval
+	ApprovalHandler *handlers.ApprovalHandler
+	// ViewHandler is nil unless saved views are enabled
+	ViewHandler *handlers.ViewHandler
+	// OperationHandler is nil unless operations are enabled
+	OperationHandler *handlers.OperationHandler
+	// SCIMHandler is nil unless SCIM is enabled
+	SCIMHandler *handlers.SCIMHandler
+	// UploadHandler is nil unless uploads are enabled
+	UploadHandler *handlers.UploadHandler
+	// APIKeyHandler is nil unless API keys are enabled
+	APIKeyHandler *handlers.APIKeyHandler
+	// WebhookHandler is nil unless webhooks are enabled
+	WebhookHandler *handlers.WebhookHandler
+	// SchemaHandler serves event schemas only when webhooks are enabled
+	SchemaHandler *handlers.SchemaHandler
+	// RuleHandler is nil unless rules are enabled
+	RuleHandler *handlers.RuleHandler
+	// StatsHandler is nil unless stats are enabled
+	StatsHandler *handlers.StatsHandler
+	// OrgHandler is nil unless organizations are enabled
+	OrgHandler *handlers.OrgHandler
+	// Lifecycle starts and stops the application's components
+	Lifecycle *Lifecycle
+
+	// live is the latest configuration, including remote changes
+	live atomic.Pointer[config.Config]
+	// serverErr receives the error that stopped the HTTP server
+	serverErr chan error
+	// readiness backs /readyz and is cleared when shutdown begins
+	readiness *readiness
+	// tracker counts requests so shutdown can report what it drained
+	tracker *middleware.Tracker
+	// mailQueue sends emails in the background, when enabled
+	mailQueue *mailer.Queue
+	// purgeQueue purges cached responses in the background, when enabled
+	purgeQueue *surrogate.Queue
+	// meter meters each principal's use of the API, when enabled
+	meter *usage.Meter
+	// scripts run on user lifecycle events and are reloaded with the
+	// configuration, when enabled
+	scripts *scripts.Engine
+	// replicator replicates users between instances, when enabled
+	replicator *replication.Store
+	// instances is where the instance registers itself, when enabled
+	instances instances.Registry
+	// operations runs long-running operations, when enabled
+	operations *operations.Manager
+	// dispatcher sends notifications in the background, when enabled
+	dispatcher *notify.Dispatcher
+	// webhooks delivers user events to subscriptions, when enabled
+	webhooks *webhooks.Store
+	// rules runs actions on user events, when enabled
+	rules *rules.Engine
+	// activity records when users were last seen, when enabled
+	activity *activity.Tracker
+	// recycleBin keeps deleted users restorable, when enabled
+	recycleBin *store.RecycleBin
+	// materialized keeps the results of materialized views, when enabled
+	materialized *materialized.Store
+	// authService authenticates requests, when enabled
+	authService *auth.Service
+	// directory provisions users from an external directory, when enabled
+	directory *directory.Syncer
+	// uploads tracks files uploaded straight to storage, when enabled
+	uploads *uploads.Manager
+	// reports renders reports about users, when enabled
+	reports *reports.Manager
+	// watchdog checks the process's goroutines, heap and GC pauses, when
+	// enabled
+	watchdog *watchdog.Watchdog
+	// metrics receives request metrics, when enabled
+	metrics metrics.Sink
+	// logs is where log output goes, shipping it to the configured sinks
+	logs *logging.Output
+	// ran records that Run took over closing the components
+	ran atomic.Bool
+}
+
+// Options adjusts how the application starts
+type Options struct {
+	// FailFast checks dependencies once instead of waiting for them, e.g. in CI
+	FailFast bool
 }
 
 // New creates and initializes a new application instance
 func New() (*Application, error) {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates and initializes a new application instance,
+// waiting for configured dependencies to become reachable first
+func NewWithOptions(opts Options) (*Application, error) {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return nil, err
 	}
 
-	// Initialize the user store
-	userStore := store.NewMemoryUserStore()
+	// Logs are shipped to the configured sinks from here on, until the
+	// application is closed
+	logOutput, err := newLogOutput(cfg.Logging)
+	if err != nil {
+		return nil, err
+	}
+	err = logging.Configure(logOutput, logging.Options{
+		Level:  cfg.Logging.Level,
+		Format: cfg.Logging.Format,
+		Levels: cfg.Logging.Levels,
+	})
+	if err != nil {
+		_ = logOutput.Close()
+		return nil, err
+	}
+	setLogOutput(logOutput)
+	created := false
+	defer func() {
+		if !created {
+			_ = closeLogOutput(logOutput)
+		}
+	}()
+
+	// The served API is checked against its published contract before
+	// anything is started
+	if cfg.Routes.OpenAPIBaseline != "" {
+		doc, err := swag.ReadDoc()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOpenAPIBaseline(cfg.Routes.OpenAPIBaseline, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	// Wait for dependencies before creating anything that uses them
+	if err := waitForDependencies(context.Background(), cfg.Startup, opts.FailFast); err != nil {
+		return nil, err
+	}
+
+	// Time and identifier sources, injected so they can be faked in tests
+	clk := clock.Real()
+	storeIDs, err := newIDs(cfg.IDs, clk)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &builder{
+		app: &Application{
+			Config:    cfg,
+			Clock:     clk,
+			IDs:       storeIDs(""),
+			Lifecycle: NewLifecycle(),
+			serverErr: make(chan error, 1),
+			logs:      logOutput,
+		},
+		storeIDs: storeIDs,
+	}
+	// The user store is closed if anything built after it fails
+	defer func() {
+		if !created {
+			_ = b.app.closeStore()
+		}
+	}()
+
+	// Each subsystem is built from the configuration and those built
+	// before it
+	for _, build := range []func() error{
+		b.buildUserStore,
+		b.buildEvents,
+		b.buildUsers,
+		b.buildInstances,
+		b.buildAuth,
+		b.buildUserFeatures,
+		b.buildTenants,
+		b.buildProvisioning,
+		b.buildUploads,
+		b.buildAdminTools,
+		b.buildUserPolicies,
+		b.buildObservability,
+	} {
+		if err := build(); err != nil {
+			return nil, err
+		}
+	}
+
+	application, err := b.finish()
+	if err != nil {
+		return nil, err
+	}
+	application.live.Store(cfg)
+	application.registerHooks()
+
+	created = true
+	return application, nil
+}
+
+// builder carries the components NewWithOptions has built so far to the
+// subsystems built after them. Components of disabled features are nil.
+type builder struct {
+	// app receives the components that are started, stopped or exported
+	app *Application
+	// deps receives what the router mounts
+	deps routerDeps
+	// users is the user store, replicated when configured
+	users verifiableStore
+	// storeIDs returns the ID generator of each store by name
+	storeIDs func(store string) idgen.Generator
+	// listeners hear about the users written through the user handler
+	listeners []handlers.UserListener
+	// anonymizer fakes users' personal data for anonymized exports and
+	// snapshots
+	anonymizer *anonymize.Anonymizer
+	// apiKeys, orgs and uploadStorage are shared with later subsystems
+	apiKeys       *apikeys.Store
+	orgs          *orgs.Store
+	uploadStorage uploads.Storage
+}
+
+// finish sets up the router with the handlers built, returning the
+// application
+func (b *builder) finish() (*Application, error) {
+	a := b.app
+	b.deps.ids = b.storeIDs("requests")
+	b.deps.ready = new(readiness)
+	b.deps.tracker = new(middleware.Tracker)
+	b.deps.authService = a.authService
+	b.deps.meter = a.meter
+	b.deps.activityTracker = a.activity
+	b.deps.metricsSink = a.metrics
+	handler, err := setupRouter(a.Config, b.deps)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Router = handler
+	a.readiness = b.deps.ready
+	a.tracker = b.deps.tracker
+	a.UserHandler = b.deps.userHandler
+	a.AdminHandler = b.deps.adminHandler
+	a.ChangeHandler = b.deps.changeHandler
+	a.NotificationHandler = b.deps.notificationHandler
+	a.AuthHandler = b.deps.authHandler
+	a.ApprovalHandler = b.deps.approvalHandler
+	a.ViewHandler = b.deps.viewHandler
+	a.OperationHandler = b.deps.operationHandler
+	a.SCIMHandler = b.deps.scimHandler
+	a.UploadHandler = b.deps.uploadHandler
+	a.APIKeyHandler = b.deps.apiKeyHandler
+	a.WebhookHandler = b.deps.webhookHandler
+	a.SchemaHandler = b.deps.schemaHandler
+	a.RuleHandler = b.deps.ruleHandler
+	a.StatsHandler = b.deps.statsHandler
+	a.OrgHandler = b.deps.orgHandler
+	return a, nil
+}
+
+// registerHooks registers the components started by Run. They stop in
+// reverse, so the server drains before the store is closed.
+func (a *Application) registerHooks() {
+	a.registerStoreHooks()
+	a.registerAuthHooks()
+	a.registerEventHooks()
+	a.registerUserHooks()
+	a.registerTenantHooks()
+	a.registerProvisioningHooks()
+	a.registerUploadHooks()
+	a.registerObservabilityHooks()
+	a.registerServerHooks()
+}
 
-	// Add some initial sample data
-	_, _ = userStore.Create(store.User{Name: "John Doe", Email: "john@example.com"})
-	_, _ = userStore.Create(store.User{Name: "Jane Smith", Email: "jane@example.com"})
+// idStores are the stores whose ID strategy can be configured
+var idStores = []string{"api_keys", "approvals", "operations", "orgs", "reports", "requests", "sessions", "uploads", "webhooks"}
 
-	// Create handler with dependency injection
-	userHandler := handlers.NewUserHandler(userStore)
+// newIDs checks the ID strategies in cfg, returning the generator of each
+// store by name. Stores each get their own generator, so sequences are
+// named after them; the empty name is the default generator.
+func newIDs(cfg config.IDs, clk clock.Clock) (func(store string) idgen.Generator, error) {
+	generators := make(map[string]idgen.Generator, len(idStores)+1)
+	for _, name := range append([]string{""}, idStores...) {
+		strategy, prefix := cfg.Strategy, name
+		if override, ok := cfg.Stores[name]; ok {
+			strategy = override
+		}
+		if prefix == "" {
+			prefix = "id"
+		}
+		gen, err := idgen.New(strategy, idgen.Options{Prefix: prefix, Node: cfg.SnowflakeNode, Clock: clk})
+		if err != nil {
+			return nil, fmt.Errorf("ids: %w", err)
+		}
+		generators[name] = gen
+	}
+	for name := range cfg.Stores {
+		if name != userIDStore && !slices.Contains(idStores, name) {
+			return nil, fmt.Errorf("ids: unknown store %q, expected one of %v", name, append([]string{userIDStore}, idStores...))
+		}
+	}
+	if _, err := newUserIDs(cfg, clk); err != nil {
+		return nil, err
+	}
+	return func(store string) idgen.Generator { return generators[store] }, nil
+}
 
-	// Setup router
-	router := setupRouter(userHandler, cfg)
+// userIDStore names the user store in ids.stores
+const userIDStore = "users"
 
-	return &Application{
-		Config:      cfg,
-		Router:      router,
-		UserStore:   userStore,
-		UserHandler: userHandler,
+// newUserIDs returns the generator numbering users, or nil when the store
+// counts them itself. User IDs are integers, so only the integer strategies
+// apply to them: sequence is the store's own count, and users keep it when
+// the default strategy has string IDs.
+func newUserIDs(cfg config.IDs, clk clock.Clock) (idgen.IntGenerator, error) {
+	strategy, explicit := cfg.Stores[userIDStore]
+	if !explicit {
+		strategy = cfg.Strategy
+	}
+	switch {
+	case strategy == idgen.StrategySnowflake:
+		if clk == nil {
+			clk = clock.Real()
+		}
+		snowflake, err := idgen.NewSnowflake(cfg.SnowflakeNode, clk)
+		if err != nil {
+			return nil, fmt.Errorf("ids: %w", err)
+		}
+		return snowflake, nil
+	case explicit && strategy != idgen.StrategySequence:
+		return nil, fmt.Errorf("ids: users need integer IDs, so cannot use %q, expected one of %v", strategy, idgen.IntStrategies)
+	}
+	return nil, nil
+}
+
+// newRateProfiles returns the rate limit of each request's caller: the
+// profile an admin chose for the user making it, or the default
+func newRateProfiles(cfg config.RateLimit, prefs *preferences.Store) (func(*http.Request) (string, ratelimit.Limit, bool), error) {
+	if _, ok := cfg.Profiles[cfg.DefaultProfile]; !ok {
+		return nil, fmt.Errorf("unknown rate limit profile %q in middleware.rate_limit.default_profile", cfg.DefaultProfile)
+	}
+	for name, profile := range cfg.Profiles {
+		if profile.Requests <= 0 || profile.Window <= 0 {
+			return nil, fmt.Errorf("rate limit profile %q needs positive requests and window", name)
+		}
+	}
+	if cfg.WarnAt <= 0 || cfg.WarnAt > 1 {
+		return nil, fmt.Errorf("middleware.rate_limit.warn_at must be above 0 and at most 1, got %v", cfg.WarnAt)
+	}
+
+	return func(r *http.Request) (string, ratelimit.Limit, bool) {
+		profile := cfg.DefaultProfile
+		if settings, ok := tenants.SettingsFrom(r.Context()); ok && settings.RateProfile != "" {
+			profile = settings.RateProfile
+		}
+		if principal, ok := reqctx.PrincipalFrom(r.Context()); ok && prefs != nil {
+			if userID, err := store.ParseID(principal.Subject); err == nil {
+				if chosen := prefs.Get(userID).RateProfile; chosen != "" {
+					profile = chosen
+				}
+			}
+		}
+		limit := cfg.Profiles[profile]
+		return ratelimit.Caller(r), ratelimit.Limit{Profile: profile, Requests: limit.Requests, Window: limit.Window}, true
 	}, nil
 }
 
-// Run starts the application server
+// fixedLimits returns the limits of the enabled features that are the
+// same for every caller, described at /api/v1/limits
+func fixedLimits(cfg *config.Config) []handlers.Limit {
+	var limits []handlers.Limit
+	if cfg.Views.Enabled && cfg.Views.MaxPerOwner > 0 {
+		limits = append(limits, handlers.Limit{Name: "saved_views", Max: int64(cfg.Views.MaxPerOwner), Unit: "views", Description: "Views each caller can save"})
+	}
+	if cfg.Uploads.Enabled {
+		for _, purpose := range slices.Sorted(maps.Keys(cfg.Uploads.Purposes)) {
+			limits = append(limits, handlers.Limit{Name: "upload_" + purpose, Max: cfg.Uploads.Purposes[purpose], Unit: "bytes", Description: "Size of a file uploaded as " + purpose})
+		}
+	}
+	return limits
+}
+
+// toScripts returns the configured scripts, none unless they are enabled
+func toScripts(cfg config.Scripts) []scripts.Script {
+	if !cfg.Enabled {
+		return nil
+	}
+	configured := make([]scripts.Script, len(cfg.Scripts))
+	for i, s := range cfg.Scripts {
+		configured[i] = scripts.Script{Name: s.Name, Hook: s.Hook, Source: s.Source, File: s.File, Timeout: s.Timeout}
+	}
+	return configured
+}
+
+// newTransformers creates the transformers of the configured routes
+func newTransformers(cfg config.Transforms) (map[string]*transform.Transformer, error) {
+	transformers := make(map[string]*transform.Transformer, len(cfg.Routes))
+	for name, t := range cfg.Routes {
+		transformer, err := transform.New(name, transform.Template{Source: t.Template, File: t.File, ContentType: t.ContentType})
+		if err != nil {
+			return nil, err
+		}
+		transformers[name] = transformer
+		log.Printf("Transforming responses of %s", name)
+	}
+	return transformers, nil
+}
+
+// newRateLimiter creates the configured rate limiter, and what happens to
+// requests when it fails
+func newRateLimiter(cfg config.RateLimit) (ratelimit.Limiter, ratelimit.Failure, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return ratelimit.NewMemory(nil), ratelimit.FailOpen, nil
+	case "redis":
+	default:
+		return nil, "", fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+
+	shared := ratelimit.NewRedis(newRedisClient(cfg.Redis), ratelimit.RedisOptions{Prefix: cfg.Redis.Prefix})
+	log.Printf("Counting rate limits in Redis at %s, failing %s", cfg.Redis.Address, cmp.Or(cfg.OnFailure, "local"))
+
+	switch cfg.OnFailure {
+	case "", "local":
+		return ratelimit.NewFallback(shared, ratelimit.NewMemory(nil), cfg.RetryInterval, nil), ratelimit.FailOpen, nil
+	case "open":
+		return shared, ratelimit.FailOpen, nil
+	case "closed":
+		return shared, ratelimit.FailClosed, nil
+	default:
+		return nil, "", fmt.Errorf("unknown rate limit on_failure %q, expected local, open or closed", cfg.OnFailure)
+	}
+}
+
+// newRedisClient creates a client of the Redis server in cfg
+func newRedisClient(cfg config.Redis) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         cfg.Address,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.Timeout,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+		// A failed request fails at once rather than retrying, so an outage
+		// does not add to every request's latency
+		MaxRetries: -1,
+	})
+}
+
+// setReady updates /readyz and tells systemd the new state
+func (a *Application) setReady(ready bool) {
+	a.readiness.set(ready)
+
+	state := systemd.Ready
+	if !ready {
+		state = systemd.Stopping
+	}
+	if _, err := systemd.Notify(state); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
+
+// Run starts the application's components and blocks until the server
+// fails or the process is asked to stop, then shuts down: it enters
+// lame-duck mode and stops the components in reverse order, within the
+// termination grace period
 func (a *Application) Run() error {
-	return a.Router.Run(a.Config.Server.Address)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	a.ran.Store(true)
+	if err := a.Lifecycle.Start(context.Background()); err != nil {
+		return err
+	}
+	a.setReady(true)
+
+	var runErr error
+	select {
+	case sig := <-signals:
+		log.Printf("Received %v, shutting down", sig)
+	case runErr = <-a.serverErr:
+	}
+
+	// Everything from here must finish within the grace period, as the
+	// orchestrator kills the process once it has elapsed
+	ctx := context.Background()
+	if grace := a.Config.Server.TerminationGracePeriod; grace > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, grace)
+		defer cancel()
+	}
+
+	a.setReady(false)
+	if runErr == nil {
+		a.lameDuck(ctx, signals)
+	}
+
+	inFlight := a.tracker.InFlight()
+	err := a.Lifecycle.Stop(ctx)
+	log.Printf("Shutdown complete: drained %d in-flight request(s), %d abandoned", inFlight-a.tracker.InFlight(), a.tracker.InFlight())
+	// Logs are closed last, so the sinks get every line logged while
+	// shutting down
+	return errors.Join(runErr, err, closeLogOutput(a.logs))
 }
 
-// setupRouter configures the gin router with all routes and middleware
-func setupRouter(userHandler *handlers.UserHandler, cfg *config.Config) *gin.Engine {
-	// Set gin mode based on config
-	if cfg.Environment == "production" {
-		gin.SetMode(gin.ReleaseMode)
+// lameDuck keeps serving while /readyz fails, giving load balancers time to
+// stop routing new requests here. A second signal or the shutdown deadline
+// ends it early.
+func (a *Application) lameDuck(ctx context.Context, signals <-chan os.Signal) {
+	period := a.Config.Server.LameDuck
+	if period <= 0 {
+		return
 	}
 
-	router := gin.Default()
+	served := a.tracker.Served()
+	log.Printf("Entering lame-duck mode for %v with %d request(s) in flight", period, a.tracker.InFlight())
+
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case sig := <-signals:
+		log.Printf("Received %v, ending lame-duck mode early", sig)
+	case <-ctx.Done():
+		log.Printf("Termination grace period is shorter than lame-duck mode, ending it early")
+	}
+
+	log.Printf("Lame-duck mode over: served %d request(s), %d still in flight", a.tracker.Served()-served, a.tracker.InFlight())
+}
+
+// LiveConfig returns the latest configuration, including remote changes
+// made since startup
+func (a *Application) LiveConfig() *config.Config {
+	return a.live.Load()
+}
+
+// watchConfig tracks remote configuration changes and reloads scripts with
+// them. Settings that are read once at startup, such as the listen address,
+// take effect on restart.
+func (a *Application) watchConfig(ctx context.Context) {
+	err := config.WatchRemote(ctx, a.Config, func(cfg *config.Config) {
+		a.live.Store(cfg)
+		log.Printf("Applied remote configuration change")
+		a.reloadScripts(cfg.Scripts)
+	})
+	if err != nil {
+		log.Printf("Failed to watch remote config: %v", err)
+	}
+}
+
+// reloadScripts replaces the running scripts with those of cfg, keeping the
+// current ones if any fails to compile. Scripts that were disabled at
+// startup are enabled on restart.
+func (a *Application) reloadScripts(cfg config.Scripts) {
+	if a.scripts == nil {
+		if cfg.Enabled {
+			log.Printf("Scripts were enabled, restart to run them")
+		}
+		return
+	}
+	if err := a.scripts.Reload(toScripts(cfg)); err != nil {
+		log.Printf("Failed to reload scripts, keeping the current ones: %v", err)
+		return
+	}
+	log.Printf("Reloaded %d script(s)", len(toScripts(cfg)))
+}
+
+// Close releases resources held by an application that was not run, such
+// as the store journal. Run releases them itself when it stops.
+func (a *Application) Close() error {
+	if a.ran.Load() {
+		return nil
+	}
+	return errors.Join(a.closeStore(), closeLogOutput(a.logs))
+}
+
+// routerDeps are the handlers, stores and middleware setupRouter mounts
+// routes with. Those of disabled features are nil.
+type routerDeps struct {
+	userHandler         *handlers.UserHandler
+	adminHandler        *handlers.AdminHandler
+	changeHandler       *handlers.ChangeHandler
+	replicationHandler  *handlers.ReplicationHandler
+	instanceHandler     *handlers.InstanceHandler
+	notificationHandler *handlers.NotificationHandler
+	authHandler         *handlers.AuthHandler
+	authService         *auth.Service
+	approvalHandler     *handlers.ApprovalHandler
+	viewHandler         *handlers.ViewHandler
+	operationHandler    *handlers.OperationHandler
+	scimHandler         *handlers.SCIMHandler
+	uploadHandler       *handlers.UploadHandler
+	apiKeyHandler       *handlers.APIKeyHandler
+	webhookHandler      *handlers.WebhookHandler
+	ruleHandler         *handlers.RuleHandler
+	statsHandler        *handlers.StatsHandler
+	schemaHandler       *handlers.SchemaHandler
+	orgHandler          *handlers.OrgHandler
+	preferenceHandler   *handlers.PreferenceHandler
+	preferenceStore     *preferences.Store
+	tenantHandler       *handlers.TenantHandler
+	tenantStore         *tenants.Store
+	usageHandler        *handlers.UsageHandler
+	meter               *usage.Meter
+	consentHandler      *handlers.ConsentHandler
+	consentStore        *consent.Store
+	// ids generates request IDs
+	ids idgen.Generator
+	// ready backs /readyz
+	ready *readiness
+	// tracker counts the requests in flight
+	tracker         *middleware.Tracker
+	activityTracker *activity.Tracker
+	metricsSink     metrics.Sink
+}
+
+// setupRouter mounts all routes on the configured router, enabling optional
+// route groups and middleware as configured
+func setupRouter(cfg *config.Config, deps routerDeps) (http.Handler, error) {
+	r, handler, err := newRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Slow requests are logged with the time spent in each middleware,
+	// which is only timed when they are detected
+	slow := newSlowRequests(cfg.Middleware.SlowRequests)
+	timed := func(name string, m middleware.Middleware) middleware.Middleware {
+		if slow == nil {
+			return m
+		}
+		return middleware.Timed(name, m)
+	}
+
+	// Middleware applied to API routes only, so the health check stays truthful
+	var apiMiddleware []middleware.Middleware
+	// Vary comes first, so caches also tell apart the responses of
+	// requests refused by the middleware after it
+	if vary := cfg.Middleware.Caching.Vary; len(vary) > 0 {
+		apiMiddleware = append(apiMiddleware, timed("vary", middleware.Vary(vary...)))
+	}
+	if caching := cfg.Middleware.Caching; caching.SurrogateKeys && caching.CDN.Provider == "cloudflare" {
+		apiMiddleware = append(apiMiddleware, timed("cache_tags", surrogate.CacheTags))
+	}
+	if deps.authService != nil {
+		apiMiddleware = append(apiMiddleware, timed("auth", deps.authService.Middleware))
+	}
+	if deps.tenantStore != nil {
+		apiMiddleware = append(apiMiddleware, timed("tenants", deps.tenantStore.Middleware(cfg.Tenants.Header)))
+	}
+	if deps.consentStore != nil {
+		apiMiddleware = append(apiMiddleware, timed("consent", deps.consentStore.Middleware))
+	}
+	if deps.preferenceStore != nil {
+		apiMiddleware = append(apiMiddleware, timed("preferences", deps.preferenceStore.Middleware))
+	}
+	if rateLimit := cfg.Middleware.RateLimit; rateLimit.Enabled {
+		resolve, err := newRateProfiles(rateLimit, deps.preferenceStore)
+		if err != nil {
+			return nil, err
+		}
+		limiter, failure, err := newRateLimiter(rateLimit)
+		if err != nil {
+			return nil, err
+		}
+		apiMiddleware = append(apiMiddleware, timed("rate_limit", ratelimit.Middleware(limiter, resolve, ratelimit.Options{
+			Failure:     failure,
+			WarnAt:      rateLimit.WarnAt,
+			Describedby: "/api/v1/limits",
+		})))
+	}
+	if chaos := cfg.Middleware.Chaos; chaos.Enabled {
+		log.Printf("Chaos middleware enabled: latency %v, error rate %.2f", chaos.Latency, chaos.ErrorRate)
+		apiMiddleware = append(apiMiddleware, timed("chaos", middleware.Chaos(middleware.ChaosOptions{
+			Latency:   chaos.Latency,
+			ErrorRate: chaos.ErrorRate,
+		})))
+	}
+	if deps.activityTracker != nil {
+		apiMiddleware = append(apiMiddleware, timed("activity", deps.activityTracker.Middleware))
+	}
+	// Examples are recorded closest to the handlers, so rejected requests
+	// and injected faults do not replace them
+	var examples *apidocs.Recorder
+	if cfg.Middleware.RecordExamples {
+		log.Printf("Recording request and response examples into the OpenAPI document")
+		examples = apidocs.NewRecorder()
+	}
+	// Responses are transformed outside example recording, so examples show
+	// the canonical payload
+	transformers, err := newTransformers(cfg.Transforms)
+	if err != nil {
+		return nil, err
+	}
+	transformedRoutes := make(map[string]bool, len(transformers))
+	// Duplicates are caught after authentication, which identifies the caller
+	deduper := middleware.NewDeduper(nil)
+	dedupeRoutes := make(map[string]bool, len(cfg.Middleware.Dedupe.Routes))
+	api := func(routes []router.Route) []router.Route {
+		wrapped := make([]router.Route, len(routes))
+		for i, route := range routes {
+			if examples != nil {
+				route.Handler = examples.Handler(route.Method, route.Path, route.Handler)
+			}
+			name := route.Method + " " + route.Path
+			if transformer, ok := transformers[name]; ok {
+				route.Handler = timed("transform", transformer.Handler)(route.Handler)
+				transformedRoutes[name] = true
+			}
+			// Usage is metered inside deduplication, so replayed duplicates
+			// are not billed
+			if deps.meter != nil {
+				route.Handler = deps.meter.Handler(name, route.Handler)
+			}
+			if window, ok := cfg.Middleware.Dedupe.Routes[name]; ok {
+				route.Handler = timed("dedupe", deduper.Middleware(window))(route.Handler)
+				dedupeRoutes[name] = true
+			}
+			wrapped[i] = route
+		}
+		wrapped = router.Wrap(wrapped, func(handler http.Handler) http.Handler {
+			return middleware.Chain(handler, apiMiddleware...)
+		})
+		if slow != nil {
+			for i, route := range wrapped {
+				wrapped[i].Handler = slow.Handler(route.Method, route.Path, route.Handler)
+			}
+		}
+		// Metrics are recorded outermost, so latencies include the
+		// middleware and rejected requests are counted
+		if deps.metricsSink != nil {
+			for i, route := range wrapped {
+				wrapped[i].Handler = metrics.Handler(deps.metricsSink, route.Method, route.Path, route.Handler)
+			}
+		}
+		return wrapped
+	}
 
 	// API v1 routes
-	v1 := router.Group("/api/v1")
-	{
-		v1.GET("/users", userHandler.GetUsers)
-		v1.GET("/users/:id", userHandler.GetUser)
-		v1.POST("/users", userHandler.CreateUser)
-		v1.PUT("/users/:id", userHandler.UpdateUser)
-		v1.DELETE("/users/:id", userHandler.DeleteUser)
+	router.Mount(r, api(deps.userHandler.Routes()))
+	if deps.changeHandler != nil {
+		router.Mount(r, api(deps.changeHandler.Routes()))
+	}
+	if deps.notificationHandler != nil {
+		router.Mount(r, api(deps.notificationHandler.Routes()))
+	}
+	if deps.authHandler != nil {
+		router.Mount(r, api(deps.authHandler.Routes()))
+	}
+	if deps.viewHandler != nil {
+		router.Mount(r, api(deps.viewHandler.Routes()))
+	}
+	if deps.usageHandler != nil {
+		router.Mount(r, api(deps.usageHandler.Routes()))
+	}
+	if deps.statsHandler != nil {
+		router.Mount(r, api(deps.statsHandler.Routes()))
+	}
+	router.Mount(r, api(deps.schemaHandler.Routes()))
+	router.Mount(r, api(handlers.NewLimitsHandler(fixedLimits(cfg)).Routes()))
+	if deps.operationHandler != nil {
+		router.Mount(r, api(deps.operationHandler.Routes()))
+	}
+	if deps.orgHandler != nil {
+		router.Mount(r, api(deps.orgHandler.Routes()))
+	}
+	if deps.preferenceHandler != nil {
+		router.Mount(r, api(deps.preferenceHandler.Routes()))
+	}
+	if deps.consentHandler != nil {
+		router.Mount(r, api(deps.consentHandler.Routes()))
+	}
+	// SCIM authenticates providers with its own token, which the session
+	// middleware would reject
+	if deps.scimHandler != nil {
+		router.Mount(r, deps.scimHandler.Routes())
+	}
+	if deps.uploadHandler != nil {
+		router.Mount(r, api(deps.uploadHandler.Routes()))
+		// Files are authorized by their upload token, and streamed to disk
+		// without being recorded or buffered by API middleware
+		router.Mount(r, deps.uploadHandler.ContentRoutes())
+	}
+
+	// Optional route groups
+	if cfg.Routes.Swagger {
+		uiPage, err := apidocs.UIPage(cfg.Routes.DocsUI, handlers.DocsSpecPath, handlers.DocsAssetsPath)
+		if err != nil {
+			return nil, err
+		}
+		if !apidocs.HasBundle(cfg.Routes.DocsUI) {
+			log.Printf("Warning: the %s script is not embedded, so /docs will be blank; run make docs-ui and rebuild", cfg.Routes.DocsUI)
+		}
+		readDoc := swag.ReadDoc
+		if examples != nil {
+			readDoc = examples.ReadDoc(swag.ReadDoc)
+		}
+		r.Handle(http.MethodGet, "/swagger/{any...}", swaggerHandler())
+		router.Mount(r, handlers.NewDocsHandler(readDoc, uiPage).Routes())
+	}
+	if cfg.Routes.Admin {
+		router.Mount(r, api(deps.adminHandler.Routes()))
+		if deps.authHandler != nil {
+			router.Mount(r, api(deps.authHandler.AdminRoutes()))
+		}
+		if deps.approvalHandler != nil {
+			router.Mount(r, api(deps.approvalHandler.Routes()))
+		}
+		if deps.apiKeyHandler != nil {
+			router.Mount(r, api(deps.apiKeyHandler.Routes()))
+		}
+		if deps.preferenceHandler != nil {
+			router.Mount(r, api(deps.preferenceHandler.AdminRoutes()))
+		}
+		if deps.consentHandler != nil {
+			router.Mount(r, api(deps.consentHandler.AdminRoutes()))
+		}
+		if deps.webhookHandler != nil {
+			router.Mount(r, api(deps.webhookHandler.Routes()))
+		}
+		if deps.ruleHandler != nil {
+			router.Mount(r, api(deps.ruleHandler.Routes()))
+		}
+		if deps.replicationHandler != nil {
+			router.Mount(r, api(deps.replicationHandler.Routes()))
+		}
+		if deps.instanceHandler != nil {
+			router.Mount(r, api(deps.instanceHandler.Routes()))
+		}
+		if deps.tenantHandler != nil {
+			router.Mount(r, api(deps.tenantHandler.Routes()))
+		}
+	}
+	// Instances authenticate to each other with the replication secret,
+	// which the session middleware would reject
+	if deps.replicationHandler != nil {
+		router.Mount(r, deps.replicationHandler.PeerRoutes())
+	}
+	if cfg.Routes.Debug {
+		r.Handle(http.MethodGet, "/debug/pprof/{name...}", http.HandlerFunc(pprofHandler))
+	}
+
+	for name := range cfg.Middleware.Dedupe.Routes {
+		if !dedupeRoutes[name] {
+			return nil, fmt.Errorf("unknown route %q in middleware.dedupe.routes", name)
+		}
+	}
+	for name := range transformers {
+		if !transformedRoutes[name] {
+			return nil, fmt.Errorf("unknown route %q in transforms.routes", name)
+		}
+	}
+
+	// Health check endpoints
+	r.Handle(http.MethodGet, "/health", http.HandlerFunc(healthHandler))
+	r.Handle(http.MethodGet, "/readyz", deps.ready)
+	if prom, ok := deps.metricsSink.(*metrics.Prometheus); ok {
+		r.Handle(http.MethodGet, cfg.Middleware.Metrics.Path, prom.Exposition())
+	}
+
+	// Middleware shared by every router
+	var shared []middleware.Middleware
+	if cfg.Middleware.Tracing {
+		shared = append(shared, middleware.Tracing())
+	}
+	if cfg.Middleware.RequestID {
+		shared = append(shared, middleware.RequestID(deps.ids))
+	}
+	handler = middleware.Chain(handler, shared...)
+	return deps.tracker.Middleware(handler), nil
+}
+
+// newSlowRequests creates slow request detection as configured, or returns
+// nil when it is disabled
+func newSlowRequests(cfg config.SlowRequests) *middleware.SlowRequests {
+	if !cfg.Enabled {
+		return nil
+	}
+	opts := middleware.SlowRequestOptions{Threshold: cfg.Threshold}
+	if cfg.Dump.Enabled {
+		opts.Dump = middleware.GoroutineDumpOptions{
+			Dir:      cfg.Dump.Dir,
+			P99:      cfg.Dump.P99,
+			Window:   cfg.Dump.Window,
+			Breaches: cfg.Dump.Breaches,
+			Cooldown: cfg.Dump.Cooldown,
+			MaxDumps: cfg.Dump.MaxDumps,
+		}
+		log.Printf("Dumping goroutines to %s when the p99 latency of API requests stays above %v", cfg.Dump.Dir, cmp.Or(cfg.Dump.P99, cfg.Threshold))
+	}
+	return middleware.NewSlowRequests(opts)
+}
+
+// newRouter creates the configured router, returning it along with the
+// handler that serves it wrapped in panic recovery and, when enabled, access
+// logging
+func newRouter(cfg *config.Config) (router.Router, http.Handler, error) {
+	if err := router.Validate(cfg.Server.Router); err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Server.Router == router.EngineGin {
+		// Set gin mode based on config
+		if cfg.Environment == "production" {
+			gin.SetMode(gin.ReleaseMode)
+		}
+
+		// gin brings its own logger and recovery
+		engine := gin.New()
+		if cfg.Middleware.AccessLog {
+			// gin's access log keeps its own format, but follows the
+			// level of the http logger
+			engine.Use(gin.LoggerWithConfig(gin.LoggerConfig{
+				Skip: func(*gin.Context) bool { return !logging.Enabled(logging.HTTP, slog.LevelInfo) },
+			}))
+		}
+		engine.Use(gin.Recovery())
+		engine.UseRawPath = cfg.Server.Gin.UseRawPath
+		engine.UnescapePathValues = cfg.Server.Gin.UnescapePathValues
+		r := router.NewGin(engine)
+		return r, r, nil
 	}
 
-	// Swagger endpoint (only in non-production)
-	if cfg.Environment != "production" {
-		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	var r router.Router
+	if cfg.Server.Router == router.EngineChi {
+		r = router.NewChi()
+	} else {
+		r = router.NewStdlib()
 	}
 
-	// Health check endpoint
-	router.GET("/health", healthHandler)
+	chain := []middleware.Middleware{middleware.Recovery}
+	if cfg.Middleware.AccessLog {
+		chain = append([]middleware.Middleware{middleware.Logger}, chain...)
+	}
+	return r, middleware.Chain(r, chain...), nil
+}
 
-	return router
+// pprofHandler serves net/http/pprof's handlers by name
+func pprofHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.PathValue("name") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		// Index also serves named profiles such as heap and goroutine
+		pprof.Index(w, r)
+	}
+}
+
+// checkOpenAPIBaseline compares the served OpenAPI document doc with the
+// baseline at path, failing on changes that would break the baseline's
+// clients
+func checkOpenAPIBaseline(path, doc string) error {
+	baselineDoc, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read OpenAPI baseline: %w", err)
+	}
+	baseline, err := apidocs.Load(string(baselineDoc))
+	if err != nil {
+		return fmt.Errorf("OpenAPI baseline %s: %w", path, err)
+	}
+	served, err := apidocs.Load(doc)
+	if err != nil {
+		return err
+	}
+
+	changes := apidocs.Diff(baseline, served)
+	for _, change := range changes {
+		level := slog.LevelInfo
+		if change.Breaking {
+			level = slog.LevelWarn
+		}
+		slog.Log(context.Background(), level, "OpenAPI document differs from its baseline",
+			"baseline", path, "endpoint", change.Endpoint, "change", change.Message, "breaking", change.Breaking)
+	}
+	if breaking := len(apidocs.Breaking(changes)); breaking > 0 {
+		return fmt.Errorf("served OpenAPI document has %d breaking change(s) from the baseline %s", breaking, path)
+	}
+	return nil
+}
+
+// swaggerHandler serves the Swagger UI. gin-swagger only provides a gin
+// handler, so it runs on its own engine that any router can mount. It reads
+// the same document as the docs UI.
+func swaggerHandler() http.Handler {
+	engine := gin.New()
+	engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL(handlers.DocsSpecPath)))
+	return engine
 }
 
 // HealthResponse represents the health check response
@@ -96,6 +1083,36 @@ type HealthResponse struct {
 // @Produce json
 // @Success 200 {object} HealthResponse
 // @Router /health [get]
-func healthHandler(c *gin.Context) {
-	c.JSON(200, HealthResponse{Status: "ok"})
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+}
+
+// readiness reports whether the application should receive traffic
+type readiness struct {
+	ready atomic.Bool
+}
+
+func (r *readiness) set(ready bool) {
+	r.ready.Store(ready)
+}
+
+// ServeHTTP godoc
+// @Summary Readiness check
+// @Description Check if the service should receive traffic. Fails while starting and once shutdown begins, while requests are still served.
+// @Tags system
+// @Accept json
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse
+// @Router /readyz [get]
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	status, body := http.StatusOK, HealthResponse{Status: "ready"}
+	if !r.ready.Load() {
+		status, body = http.StatusServiceUnavailable, HealthResponse{Status: "unavailable"}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
 }
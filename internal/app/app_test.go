@@ -0,0 +1,498 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/anonymize"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/instances"
+	"github.com/dazraf/go-api-example/internal/preferences"
+	"github.com/dazraf/go-api-example/internal/ratelimit"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/scripts"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/tenants"
+)
+
+func TestReadiness(t *testing.T) {
+	var ready readiness
+
+	tests := []struct {
+		name           string
+		ready          bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "starting", ready: false, expectedStatus: http.StatusServiceUnavailable, expectedBody: `{"status":"unavailable"}`},
+		{name: "serving", ready: true, expectedStatus: http.StatusOK, expectedBody: `{"status":"ready"}`},
+		{name: "draining", ready: false, expectedStatus: http.StatusServiceUnavailable, expectedBody: `{"status":"unavailable"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready.set(tt.ready)
+			w := httptest.NewRecorder()
+			ready.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestNewService(t *testing.T) {
+	bound := &net.TCPAddr{IP: net.IPv6zero, Port: 9090}
+	cfg := config.Discovery{
+		ServiceName:   "api",
+		Address:       "10.0.0.1",
+		Tags:          []string{"v1"},
+		CheckInterval: 5 * time.Second,
+	}
+
+	service, err := newService(cfg, bound)
+	require.NoError(t, err)
+	assert.Equal(t, "api-10.0.0.1-9090", service.ID)
+	assert.Equal(t, 9090, service.Port)
+	assert.Equal(t, "http://10.0.0.1:9090/readyz", service.CheckURL)
+
+	cfg.ServiceID = "api-blue"
+	service, err = newService(cfg, bound)
+	require.NoError(t, err)
+	assert.Equal(t, "api-blue", service.ID)
+
+	_, err = newService(cfg, &net.UnixAddr{Name: "/run/api.sock", Net: "unix"})
+	assert.Error(t, err)
+}
+
+func TestNewIDs(t *testing.T) {
+	ids, err := newIDs(config.IDs{Strategy: "sequence", Stores: map[string]string{"orgs": "snowflake"}, SnowflakeNode: 3}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "webhooks-1", ids("webhooks").NewID())
+	assert.Equal(t, "webhooks-2", ids("webhooks").NewID(), "stores keep their generator")
+	assert.Equal(t, "id-1", ids("").NewID())
+	assert.Regexp(t, `^\d+$`, ids("orgs").NewID())
+
+	tests := []struct {
+		name string
+		cfg  config.IDs
+	}{
+		{"unknown strategy", config.IDs{Strategy: "uuid"}},
+		{"unknown store strategy", config.IDs{Strategy: "random", Stores: map[string]string{"orgs": "uuid"}}},
+		{"unknown store", config.IDs{Strategy: "random", Stores: map[string]string{"accounts": "ulid"}}},
+		{"string strategy for users", config.IDs{Strategy: "random", Stores: map[string]string{"users": "ulid"}}},
+		{"snowflake node out of range", config.IDs{Strategy: "snowflake", SnowflakeNode: 1024}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newIDs(tt.cfg, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewUserIDs(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           config.IDs
+		wantSnowflake bool
+	}{
+		{name: "string default keeps the store's count", cfg: config.IDs{Strategy: "ulid"}},
+		{name: "sequence is the store's count", cfg: config.IDs{Strategy: "snowflake", Stores: map[string]string{"users": "sequence"}}},
+		{name: "snowflake default", cfg: config.IDs{Strategy: "snowflake", SnowflakeNode: 3}, wantSnowflake: true},
+		{name: "snowflake for users", cfg: config.IDs{Strategy: "random", Stores: map[string]string{"users": "snowflake"}}, wantSnowflake: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := newUserIDs(tt.cfg, clock.Real())
+			require.NoError(t, err)
+			if !tt.wantSnowflake {
+				assert.Nil(t, ids)
+				return
+			}
+			assert.IsType(t, &idgen.Snowflake{}, ids)
+		})
+	}
+
+	// The memory store numbers users with the generator
+	cfg := &config.Config{IDs: config.IDs{Strategy: "snowflake"}}
+	userStore, err := newUserStore(cfg, clock.Real())
+	require.NoError(t, err)
+	user, err := userStore.Create(store.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	require.NoError(t, err)
+	assert.Greater(t, user.ID, int64(1)<<22)
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.RateLimit
+		wantLimiter any
+		wantFailure ratelimit.Failure
+		wantErr     bool
+	}{
+		{name: "memory", cfg: config.RateLimit{Backend: "memory"}, wantLimiter: &ratelimit.Memory{}, wantFailure: ratelimit.FailOpen},
+		{name: "redis falling back locally", cfg: config.RateLimit{Backend: "redis", OnFailure: "local"}, wantLimiter: &ratelimit.Fallback{}, wantFailure: ratelimit.FailOpen},
+		{name: "redis failing open", cfg: config.RateLimit{Backend: "redis", OnFailure: "open"}, wantLimiter: &ratelimit.Redis{}, wantFailure: ratelimit.FailOpen},
+		{name: "redis failing closed", cfg: config.RateLimit{Backend: "redis", OnFailure: "closed"}, wantLimiter: &ratelimit.Redis{}, wantFailure: ratelimit.FailClosed},
+		{name: "unknown backend", cfg: config.RateLimit{Backend: "memcached"}, wantErr: true},
+		{name: "unknown failure", cfg: config.RateLimit{Backend: "redis", OnFailure: "retry"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, failure, err := newRateLimiter(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.wantLimiter, limiter)
+			assert.Equal(t, tt.wantFailure, failure)
+		})
+	}
+}
+
+func TestNewRateProfiles_Tenants(t *testing.T) {
+	cfg := config.RateLimit{
+		DefaultProfile: "default",
+		Profiles: map[string]config.RateProfile{
+			"default":  {Requests: 10, Window: time.Minute},
+			"elevated": {Requests: 100, Window: time.Minute},
+			"partner":  {Requests: 1000, Window: time.Minute},
+		},
+		WarnAt: 0.8,
+	}
+	prefs := preferences.NewStore(preferences.Options{Profiles: []string{"default", "elevated", "partner"}})
+	_, err := prefs.SetRateProfile(1, "partner")
+	require.NoError(t, err)
+	resolve, err := newRateProfiles(cfg, prefs)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		tenant    string
+		principal *reqctx.Principal
+		want      int
+	}{
+		{name: "default", want: 10},
+		{name: "tenant profile", tenant: "elevated", want: 100},
+		{name: "user profile over tenant's", tenant: "elevated", principal: &reqctx.Principal{Subject: "1"}, want: 1000},
+		{name: "user without profile", tenant: "elevated", principal: &reqctx.Principal{Subject: "2"}, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.tenant != "" {
+				ctx = tenants.WithSettings(ctx, tenants.Settings{RateProfile: tt.tenant})
+			}
+			if tt.principal != nil {
+				ctx = reqctx.WithPrincipal(ctx, *tt.principal)
+			}
+			req := httptest.NewRequestWithContext(ctx, "GET", "/api/v1/users", nil)
+			_, limit, ok := resolve(req)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, limit.Requests)
+		})
+	}
+
+	// Warning at 0 would warn on every request
+	for _, warnAt := range []float64{0, 1.5} {
+		cfg.WarnAt = warnAt
+		_, err = newRateProfiles(cfg, prefs)
+		assert.ErrorContains(t, err, "warn_at")
+	}
+}
+
+func TestNewTenantStore(t *testing.T) {
+	tenantStore, err := newTenantStore(config.Tenants{
+		Features: map[string]bool{"bulk_export": false},
+		Overrides: map[string]config.TenantOverrides{
+			"acme": {RateProfile: "elevated", Features: map[string]bool{"bulk_export": true}, Webhooks: []config.TenantWebhook{{URL: "https://acme.example.com/hooks", Events: []string{"user.created"}, Secret: "s3cret"}}},
+		},
+	}, []string{"default", "elevated"})
+	require.NoError(t, err)
+	settings := tenantStore.Resolve("acme")
+	assert.Equal(t, "elevated", settings.RateProfile)
+	assert.True(t, settings.Feature("bulk_export"))
+	assert.Equal(t, "s3cret", settings.Webhooks[0].Secret)
+	assert.False(t, tenantStore.Resolve("globex").Feature("bulk_export"))
+
+	_, err = newTenantStore(config.Tenants{Overrides: map[string]config.TenantOverrides{"acme": {RateProfile: "unlimited"}}}, []string{"default"})
+	assert.ErrorContains(t, err, `tenant "acme"`)
+}
+
+func TestNewUserStore_Bolt(t *testing.T) {
+	cfg := &config.Config{Database: config.Database{Type: "bolt", Bolt: config.Bolt{Path: t.TempDir() + "/users.db"}}}
+	userStore, err := newUserStore(cfg, clock.Real())
+	require.NoError(t, err)
+	assert.IsType(t, &store.BoltUserStore{}, userStore)
+	require.NoError(t, userStore.(*store.BoltUserStore).Close())
+}
+
+func TestNewPluginRunner(t *testing.T) {
+	runner, err := newPluginRunner(config.Plugins{Timeout: time.Second, Plugins: []config.Plugin{
+		{Name: "policy", Command: []string{"policy"}, Hooks: []string{"validate-before-create"}},
+	}})
+	require.NoError(t, err)
+	assert.True(t, runner.Handles("validate-before-create"))
+	assert.False(t, runner.Handles("enrich-after-read"))
+
+	_, err = newPluginRunner(config.Plugins{Plugins: []config.Plugin{{Name: "policy", Command: []string{"policy"}, Hooks: []string{"validate-before-delete"}}}})
+	assert.ErrorContains(t, err, "invalid plugins")
+}
+
+func TestApplication_ReloadScripts(t *testing.T) {
+	cfg := config.Scripts{Enabled: true, Scripts: []config.Script{
+		{Name: "policy", Hook: "validate-before-create", Source: `return "closed"`},
+	}}
+	engine, err := scripts.New(toScripts(cfg), time.Second)
+	require.NoError(t, err)
+	a := &Application{scripts: engine}
+	assert.ErrorIs(t, engine.ValidateBeforeCreate(context.Background(), store.User{}), scripts.ErrRejected)
+
+	// Scripts that fail to compile leave the current ones running
+	cfg.Scripts[0].Source = `return (`
+	a.reloadScripts(cfg)
+	assert.ErrorIs(t, engine.ValidateBeforeCreate(context.Background(), store.User{}), scripts.ErrRejected)
+
+	cfg.Scripts[0].Source = `return nil`
+	a.reloadScripts(cfg)
+	assert.NoError(t, engine.ValidateBeforeCreate(context.Background(), store.User{}))
+
+	// Disabling scripts stops them
+	cfg.Scripts[0].Source = `return "closed"`
+	cfg.Enabled = false
+	a.reloadScripts(cfg)
+	assert.False(t, engine.Handles("validate-before-create"))
+}
+
+func TestNewTransformers(t *testing.T) {
+	transformers, err := newTransformers(config.Transforms{Routes: map[string]config.Transform{
+		"GET /api/v1/users/{id}": {Template: `{"userId": {{.id}}}`},
+	}})
+	require.NoError(t, err)
+	assert.Contains(t, transformers, "GET /api/v1/users/{id}")
+
+	_, err = newTransformers(config.Transforms{Routes: map[string]config.Transform{"GET /api/v1/users": {}}})
+	assert.ErrorContains(t, err, "no template")
+}
+
+func TestAnonymizeOnStartup(t *testing.T) {
+	userStore := store.NewMemoryUserStore()
+	created, err := userStore.Create(store.User{Name: "John Doe", Email: "john@example.org"})
+	require.NoError(t, err)
+	anonymizer := anonymize.New("")
+
+	cfg := &config.Config{Environment: "production", Anonymization: config.Anonymization{Enabled: true}}
+	assert.ErrorContains(t, anonymizeOnStartup(cfg, anonymizer, userStore), "not allowed in production")
+
+	cfg.Environment = "staging"
+	require.NoError(t, anonymizeOnStartup(cfg, anonymizer, userStore))
+	got, err := userStore.GetByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created, got, "only enabling the endpoint changes nothing")
+
+	cfg.Anonymization.OnStartup = true
+	require.NoError(t, anonymizeOnStartup(cfg, anonymizer, userStore))
+	got, err = userStore.GetByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, anonymizer.User(*created), *got)
+}
+
+func TestNewRuleEngine(t *testing.T) {
+	cfg := &config.Config{Rules: config.Rules{Rules: []config.Rule{{
+		Name:      "welcome",
+		Events:    []string{"user.created"},
+		Condition: `user.email ~= ""`,
+		Actions:   []config.RuleAction{{Type: "tag", Tag: "new"}, {Type: "notify", Channel: "log", Message: "Welcome"}},
+	}}}}
+	_, err := newRuleEngine(cfg, clock.Real())
+	require.NoError(t, err)
+
+	// The webhook channel is only there when notifications have a URL
+	cfg.Rules.Rules[0].Actions[1].Channel = "webhook"
+	_, err = newRuleEngine(cfg, clock.Real())
+	assert.ErrorContains(t, err, "invalid rules")
+}
+
+func TestNewUsageMeter(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Usage
+		wantErr bool
+	}{
+		{name: "no sink", cfg: config.Usage{Sink: "none"}},
+		{name: "file", cfg: config.Usage{Sink: "file", File: config.UsageFile{Path: "usage.jsonl"}}},
+		{name: "http", cfg: config.Usage{Sink: "http", HTTP: config.UsageHTTP{URL: "https://billing.example.com/usage"}}},
+		{name: "http without url", cfg: config.Usage{Sink: "http"}, wantErr: true},
+		{name: "kafka", cfg: config.Usage{Sink: "kafka", Kafka: config.UsageKafka{Brokers: []string{"localhost:9092"}, Topic: "api-usage"}}},
+		{name: "kafka without brokers", cfg: config.Usage{Sink: "kafka", Kafka: config.UsageKafka{Topic: "api-usage"}}, wantErr: true},
+		{name: "unknown", cfg: config.Usage{Sink: "s3"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meter, err := newUsageMeter(tt.cfg, clock.Real())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, meter)
+		})
+	}
+}
+
+func TestNewInstanceRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Instances
+		want    any
+		wantErr bool
+	}{
+		{name: "default", cfg: config.Instances{}, want: &instances.Memory{}},
+		{name: "redis", cfg: config.Instances{Registry: "redis"}, want: &instances.Redis{}},
+		{name: "unknown", cfg: config.Instances{Registry: "sql"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := newInstanceRegistry(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.want, registry)
+		})
+	}
+}
+
+func TestApplication_RecordsActivity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+server:
+  address: "127.0.0.1:0"
+  router: stdlib
+auth:
+  enabled: true
+middleware:
+  activity:
+    enabled: true
+    flush_interval: 1h
+`), 0o600))
+	t.Setenv("CONFIG_FILE", path)
+	a, err := NewWithOptions(Options{FailFast: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = a.Close() })
+
+	user, err := a.UserStore.Create(store.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, a.authService.Passwords().Set(user.ID, "password1"))
+	token, _, err := a.authService.Login(user.Email, "password1", httptest.NewRequest("POST", "/auth/login", nil))
+	require.NoError(t, err)
+
+	require.NoError(t, a.Lifecycle.Start(context.Background()))
+	req := httptest.NewRequest("GET", "/api/v1/users/"+strconv.FormatInt(user.ID, 10), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	a.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The flush interval has not passed, so stopping is what writes it
+	require.NoError(t, a.Lifecycle.Stop(context.Background()))
+	seen, err := a.UserStore.GetByID(user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, seen.LastSeenAt)
+	assert.False(t, seen.LastSeenAt.Before(user.CreatedAt))
+}
+
+func TestNewWithOptions_BuildsEnabledSubsystems(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+server:
+  address: "127.0.0.1:0"
+  router: stdlib
+database:
+  undo:
+    enabled: true
+mailer:
+  enabled: true
+  mode: log
+  outbox_dir: `+filepath.Join(dir, "mail")+`
+notifications:
+  enabled: true
+webhooks:
+  enabled: true
+rules:
+  enabled: true
+auth:
+  enabled: true
+  api_keys: true
+approvals:
+  operations: [user.delete]
+orgs:
+  enabled: true
+stats:
+  enabled: true
+scim:
+  enabled: true
+  token: secret
+uploads:
+  enabled: true
+  local:
+    dir: `+filepath.Join(dir, "uploads")+`
+avatars:
+  enabled: true
+reports:
+  enabled: true
+backup:
+  enabled: true
+watchdog:
+  enabled: false
+`), 0o600))
+	t.Setenv("CONFIG_FILE", path)
+	a, err := NewWithOptions(Options{FailFast: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = a.Close() })
+
+	assert.NotNil(t, a.NotificationHandler)
+	assert.NotNil(t, a.WebhookHandler)
+	assert.NotNil(t, a.RuleHandler)
+	assert.NotNil(t, a.AuthHandler)
+	assert.NotNil(t, a.APIKeyHandler)
+	assert.NotNil(t, a.ApprovalHandler)
+	assert.NotNil(t, a.OrgHandler)
+	assert.NotNil(t, a.StatsHandler)
+	assert.NotNil(t, a.SCIMHandler)
+	assert.NotNil(t, a.UploadHandler)
+	assert.NotNil(t, a.recycleBin)
+	assert.NotNil(t, a.reports)
+
+	require.NoError(t, a.Lifecycle.Start(context.Background()))
+	require.NoError(t, a.Lifecycle.Stop(context.Background()))
+}
+
+func TestNewAuthService_AdminPassword(t *testing.T) {
+	cfg := config.Auth{JWTSecret: "0123456789abcdef0123456789abcdef", Admins: []int64{1}, AdminPassword: "password1"}
+	service, err := newAuthService(cfg, store.NewMemoryUserStore(), nil, nil, clock.Real(), idgen.NewSequence("session"))
+	require.NoError(t, err)
+	assert.True(t, service.Passwords().Has(1))
+	assert.False(t, service.Passwords().Has(2))
+
+	cfg.AdminPassword = "short"
+	_, err = newAuthService(cfg, store.NewMemoryUserStore(), nil, nil, clock.Real(), idgen.NewSequence("session"))
+	assert.ErrorIs(t, err, auth.ErrWeakPassword)
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/consent"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// buildAuth authenticates requests with sessions and API keys, and holds
+// users to the terms they must accept
+func (b *builder) buildAuth() error {
+	cfg := b.app.Config
+	users := b.users
+
+	// Users log in with a password and authenticate with a session token
+	if cfg.Auth.Enabled {
+		if cfg.Auth.APIKeys {
+			b.apiKeys = apikeys.NewStore(apikeys.Options{Clock: b.app.Clock, IDs: b.storeIDs("api_keys")})
+			b.deps.apiKeyHandler = handlers.NewAPIKeyHandler(b.apiKeys)
+		}
+		service, err := newAuthService(cfg.Auth, users, b.apiKeys, b.app.rules, b.app.Clock, b.storeIDs("sessions"))
+		if err != nil {
+			return err
+		}
+		b.app.authService = service
+		b.deps.authHandler = handlers.NewAuthHandler(users, service)
+		// Users only change themselves once they can authenticate
+		b.deps.userHandler.RequireOwnership()
+	}
+
+	// Users accept the current terms, and may have to before using the API
+	if cfg.Consent.Enabled {
+		b.deps.consentStore = consent.NewStore(consent.Options{
+			Documents: cfg.Consent.Documents,
+			Enforce:   cfg.Consent.Enforce,
+			// Users must be able to log in and find what to accept
+			Exempt: []string{"/api/v1/login", "/api/v1/terms", "/api/v1/me/consents"},
+			Clock:  b.app.Clock,
+		})
+		b.deps.consentHandler = handlers.NewConsentHandler(b.deps.consentStore, users)
+	}
+	return nil
+}
+
+// registerAuthHooks closes the connections to the auth backends
+func (a *Application) registerAuthHooks() {
+	if a.authService != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "auth backends",
+			Stop: func(context.Context) error { return a.authService.Close() },
+		})
+	}
+}
+
+// newAuthService creates the authentication service, generating a token
+// secret when none is configured
+func newAuthService(cfg config.Auth, users store.UserStore, keys *apikeys.Store, ruleEngine *rules.Engine, clk clock.Clock, ids idgen.Generator) (*auth.Service, error) {
+	secret := []byte(cfg.JWTSecret)
+	if len(secret) == 0 {
+		log.Printf("Warning: no auth JWT secret configured, generating one; sessions will not survive a restart")
+		secret = make([]byte, 32)
+		// crypto/rand.Read never returns an error
+		_, _ = rand.Read(secret)
+	}
+
+	var backends []auth.Backend
+	if ldapCfg := cfg.LDAP; ldapCfg.Enabled {
+		ldap, err := auth.NewLDAP(auth.LDAPOptions{
+			URL:                ldapCfg.URL,
+			StartTLS:           ldapCfg.StartTLS,
+			CAFile:             ldapCfg.CAFile,
+			InsecureSkipVerify: ldapCfg.InsecureSkipVerify,
+			BindDN:             ldapCfg.BindDN,
+			BindPassword:       ldapCfg.BindPassword,
+			BaseDN:             ldapCfg.BaseDN,
+			UserFilter:         ldapCfg.UserFilter,
+			EmailAttribute:     ldapCfg.EmailAttribute,
+			NameAttribute:      ldapCfg.NameAttribute,
+			GroupAttribute:     ldapCfg.GroupAttribute,
+			Roles:              ldapCfg.Roles,
+			PoolSize:           ldapCfg.PoolSize,
+			Timeout:            ldapCfg.Timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ldap backend: %w", err)
+		}
+		if ldapCfg.InsecureSkipVerify {
+			log.Printf("Warning: LDAP server certificates are not verified")
+		}
+		backends = append(backends, ldap)
+	}
+
+	opts := auth.Options{
+		Secret:           secret,
+		SessionTTL:       cfg.SessionTTL,
+		ImpersonationTTL: cfg.ImpersonationTTL,
+		LoginHistory:     cfg.LoginHistory,
+		Admins:           cfg.Admins,
+		Backends:         backends,
+		Clock:            clk,
+		IDs:              ids,
+	}
+	// A nil store would be a non-nil KeyAuthenticator
+	if keys != nil {
+		opts.APIKeys = keys
+	}
+	if ruleEngine != nil {
+		opts.Suspensions = ruleEngine
+	}
+	// Only users and admins set passwords, so the admins' first one comes
+	// from configuration
+	passwords := auth.NewPasswords()
+	if cfg.AdminPassword != "" {
+		for _, id := range cfg.Admins {
+			if err := passwords.Set(id, cfg.AdminPassword); err != nil {
+				return nil, fmt.Errorf("auth.admin_password: %w", err)
+			}
+		}
+	}
+	service, err := auth.NewService(users, passwords, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth service: %w", err)
+	}
+	return service, nil
+}
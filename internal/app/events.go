@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/mailer"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/webhooks"
+)
+
+// buildEvents creates what hears about users written through the user
+// handler: emails, notifications, webhooks and rules
+func (b *builder) buildEvents() error {
+	cfg := b.app.Config
+	users := b.users
+
+	// Emails are sent in the background once the application starts
+	if cfg.Mailer.Enabled {
+		sender, err := newMailSender(cfg.Mailer)
+		if err != nil {
+			return err
+		}
+		b.app.mailQueue = mailer.NewQueue(sender, mailer.QueueOptions{
+			Size:           cfg.Mailer.QueueSize,
+			MaxAttempts:    cfg.Mailer.MaxAttempts,
+			InitialBackoff: cfg.Mailer.InitialBackoff,
+		})
+		b.listeners = append(b.listeners, mailer.NewUserEmails(b.app.mailQueue, cfg.Mailer.From))
+	}
+
+	// Notifications are sent over the channels configured per event type
+	if cfg.Notifications.Enabled {
+		preferences := notify.NewMemoryPreferenceStore()
+		dispatcher, err := notify.NewDispatcher(newNotifiers(cfg.Notifications), cfg.Notifications.Events, preferences)
+		if err != nil {
+			return err
+		}
+		b.app.dispatcher = dispatcher
+		b.listeners = append(b.listeners, dispatcher)
+		b.deps.notificationHandler = handlers.NewNotificationHandler(users, preferences)
+	}
+
+	// Admins subscribe URLs to user events
+	var registry *schemas.Registry
+	if cfg.Webhooks.Enabled {
+		var err error
+		registry, err = schemas.NewRegistry(cfg.Webhooks.SchemaBaseURL)
+		if err != nil {
+			return err
+		}
+		b.app.webhooks = webhooks.NewStore(webhooks.Options{Timeout: cfg.Webhooks.Timeout, Clock: b.app.Clock, IDs: b.storeIDs("webhooks"), Schemas: registry})
+		b.listeners = append(b.listeners, b.app.webhooks)
+		b.deps.webhookHandler = handlers.NewWebhookHandler(b.app.webhooks)
+	}
+	b.deps.schemaHandler = handlers.NewSchemaHandler(registry)
+
+	// Rules act on user events, and suspend users the auth service refuses
+	if cfg.Rules.Enabled {
+		ruleEngine, err := newRuleEngine(cfg, b.app.Clock)
+		if err != nil {
+			return err
+		}
+		b.app.rules = ruleEngine
+		b.listeners = append(b.listeners, ruleEngine)
+		b.deps.ruleHandler = handlers.NewRuleHandler(users, ruleEngine)
+	}
+	return nil
+}
+
+// registerEventHooks starts sending emails, and waits for the deliveries
+// and actions in flight when stopping
+func (a *Application) registerEventHooks() {
+	if a.mailQueue != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "mailer",
+			Start: func(context.Context) error {
+				a.mailQueue.Start()
+				return nil
+			},
+			Stop: a.mailQueue.Stop,
+		})
+	}
+
+	if a.dispatcher != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "notifications",
+			Stop: a.dispatcher.Wait,
+		})
+	}
+
+	if a.webhooks != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "webhooks",
+			Stop: a.webhooks.Wait,
+		})
+	}
+
+	if a.rules != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "rules",
+			Stop: a.rules.Wait,
+		})
+	}
+}
+
+// newMailSender creates the configured email sender
+func newMailSender(cfg config.Mailer) (mailer.Sender, error) {
+	switch cfg.Mode {
+	case "smtp":
+		if cfg.SMTP.Host == "" {
+			return nil, errors.New("mailer mode smtp needs an smtp host")
+		}
+		return mailer.NewSMTP(mailer.SMTPOptions{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+		}), nil
+	case "log":
+		return mailer.NewDev(cfg.OutboxDir), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer mode %q, expected smtp or log", cfg.Mode)
+	}
+}
+
+// newNotifiers creates the notification channels
+func newNotifiers(cfg config.Notifications) map[string]notify.Notifier {
+	notifiers := map[string]notify.Notifier{
+		notify.ChannelLog: notify.NewLog(),
+		notify.ChannelSMS: notify.NewTwilioStub(cfg.SMS.From),
+	}
+	if cfg.Webhook.URL != "" {
+		notifiers[notify.ChannelWebhook] = notify.NewWebhook(cfg.Webhook.URL, cfg.Webhook.Secret)
+	}
+	return notifiers
+}
+
+// newRuleEngine creates the engine of the configured rules. Notify actions
+// use the notification channels, whether or not notifications are enabled.
+func newRuleEngine(cfg *config.Config, clk clock.Clock) (*rules.Engine, error) {
+	configured := make([]rules.Rule, len(cfg.Rules.Rules))
+	for i, r := range cfg.Rules.Rules {
+		actions := make([]rules.Action, len(r.Actions))
+		for j, a := range r.Actions {
+			actions[j] = rules.Action{Type: a.Type, Tag: a.Tag, Channel: a.Channel, Message: a.Message, URL: a.URL, Secret: a.Secret}
+		}
+		configured[i] = rules.Rule{Name: r.Name, Events: r.Events, Condition: r.Condition, Actions: actions}
+	}
+	engine, err := rules.New(configured, rules.Options{
+		Notifiers: newNotifiers(cfg.Notifications),
+		Timeout:   cfg.Rules.Timeout,
+		AuditSize: cfg.Rules.AuditSize,
+		Clock:     clk,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	log.Printf("Running %d rule(s) on user events", len(configured))
+	return engine, nil
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultHookTimeout bounds hooks that do not set their own timeout
+const defaultHookTimeout = 10 * time.Second
+
+// Hook is a component's start and stop behaviour. Either function may be
+// nil. Start must not block beyond initialisation: long-running work belongs
+// in a goroutine that Stop ends.
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+	// Timeout bounds each of Start and Stop; zero uses the default
+	Timeout time.Duration
+}
+
+// BackgroundHook returns the hook of a component that runs fn in a goroutine
+// from Start until Stop cancels its context and waits for fn to return
+func BackgroundHook(name string, fn func(ctx context.Context)) Hook {
+	var (
+		cancel  context.CancelFunc
+		running sync.WaitGroup
+	)
+	return Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			running.Go(func() { fn(ctx) })
+			return nil
+		},
+		Stop: func(context.Context) error {
+			cancel()
+			running.Wait()
+			return nil
+		},
+	}
+}
+
+// Lifecycle starts components in the order their hooks were appended and
+// stops them in reverse
+type Lifecycle struct {
+	mutex   sync.Mutex
+	hooks   []Hook
+	started int
+}
+
+// NewLifecycle creates an empty lifecycle
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Append registers a component's hook to run after those already appended
+func (l *Lifecycle) Append(hook Hook) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start runs each start hook in order. If one fails, the components already
+// started are stopped again and the errors are returned together.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for l.started < len(l.hooks) {
+		hook := l.hooks[l.started]
+		if err := run(ctx, hook, hook.Start); err != nil {
+			startErr := fmt.Errorf("failed to start %s: %w", hook.Name, err)
+			return errors.Join(startErr, l.stop(context.Background()))
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Stop runs the stop hooks of started components in reverse order, each
+// bounded by its own timeout and by ctx, and returns all of their errors
+// together. Hooks still run after ctx expires, so each can release what it
+// can without waiting.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.stop(ctx)
+}
+
+// stop stops started components. The caller must hold the mutex.
+func (l *Lifecycle) stop(ctx context.Context) error {
+	var errs []error
+	for ; l.started > 0; l.started-- {
+		hook := l.hooks[l.started-1]
+		if err := run(ctx, hook, hook.Stop); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w", hook.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run calls fn, if any, with ctx limited to the hook's timeout. It waits
+// for fn for up to that timeout even when ctx has already expired, so hooks
+// can still do cheap cleanup such as closing files.
+func run(ctx context.Context, hook Hook, fn func(context.Context) error) error {
+	if fn == nil {
+		return nil
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+
+	wait := time.NewTimer(timeout)
+	defer wait.Stop()
+
+	select {
+	case err := <-done:
+		if elapsed := time.Since(start); elapsed > time.Second {
+			log.Printf("%s took %v", hook.Name, elapsed.Round(time.Millisecond))
+		}
+		return err
+	case <-wait.C:
+		// Hooks should honour ctx; one that does not is abandoned
+		return fmt.Errorf("abandoned after %v: %w", timeout, context.DeadlineExceeded)
+	}
+}
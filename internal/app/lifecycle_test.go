@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHook returns a hook that records its calls in events
+func recordingHook(name string, events *[]string, startErr, stopErr error) Hook {
+	return Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			*events = append(*events, "start "+name)
+			return startErr
+		},
+		Stop: func(context.Context) error {
+			*events = append(*events, "stop "+name)
+			return stopErr
+		},
+	}
+}
+
+func TestLifecycle_StartsInOrderAndStopsInReverse(t *testing.T) {
+	var events []string
+	lifecycle := NewLifecycle()
+	lifecycle.Append(recordingHook("store", &events, nil, nil))
+	lifecycle.Append(Hook{Name: "no-op"})
+	lifecycle.Append(recordingHook("server", &events, nil, nil))
+
+	require.NoError(t, lifecycle.Start(context.Background()))
+	require.NoError(t, lifecycle.Stop(context.Background()))
+	assert.Equal(t, []string{"start store", "start server", "stop server", "stop store"}, events)
+
+	// Stopping again does nothing
+	require.NoError(t, lifecycle.Stop(context.Background()))
+	assert.Len(t, events, 4)
+}
+
+func TestLifecycle_FailedStartStopsStartedComponents(t *testing.T) {
+	var events []string
+	lifecycle := NewLifecycle()
+	lifecycle.Append(recordingHook("store", &events, nil, nil))
+	lifecycle.Append(recordingHook("bus", &events, nil, errors.New("bus stop failed")))
+	lifecycle.Append(recordingHook("server", &events, errors.New("port taken"), nil))
+	lifecycle.Append(recordingHook("jobs", &events, nil, nil))
+
+	err := lifecycle.Start(context.Background())
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "failed to start server: port taken")
+	assert.ErrorContains(t, err, "failed to stop bus: bus stop failed")
+	assert.Equal(t, []string{"start store", "start bus", "start server", "stop bus", "stop store"}, events)
+}
+
+func TestLifecycle_StopAggregatesErrorsAndEnforcesTimeouts(t *testing.T) {
+	var events []string
+	lifecycle := NewLifecycle()
+	lifecycle.Append(recordingHook("store", &events, nil, errors.New("flush failed")))
+	lifecycle.Append(Hook{
+		Name:    "stuck",
+		Timeout: 20 * time.Millisecond,
+		Stop: func(context.Context) error {
+			// Ignores its context, so the lifecycle must give up on it
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+
+	require.NoError(t, lifecycle.Start(context.Background()))
+
+	start := time.Now()
+	err := lifecycle.Stop(context.Background())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "failed to stop stuck")
+	assert.ErrorContains(t, err, "failed to stop store: flush failed")
+	assert.Equal(t, []string{"start store", "stop store"}, events)
+}
+
+func TestLifecycle_StopHonoursDeadline(t *testing.T) {
+	var stopped []string
+	lifecycle := NewLifecycle()
+	for _, name := range []string{"store", "server"} {
+		lifecycle.Append(Hook{
+			Name: name,
+			Stop: func(ctx context.Context) error {
+				stopped = append(stopped, name)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+	}
+	require.NoError(t, lifecycle.Start(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := lifecycle.Stop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{"server", "store"}, stopped, "hooks still run after the deadline")
+}
+
+func TestBackgroundHook(t *testing.T) {
+	done := make(chan struct{})
+	lifecycle := NewLifecycle()
+	lifecycle.Append(BackgroundHook("loop", func(ctx context.Context) {
+		<-ctx.Done()
+		close(done)
+	}))
+
+	require.NoError(t, lifecycle.Start(context.Background()))
+	select {
+	case <-done:
+		t.Fatal("fn returned before stopping")
+	default:
+	}
+	// Stop waits for fn to return
+	require.NoError(t, lifecycle.Stop(context.Background()))
+	select {
+	case <-done:
+	default:
+		t.Fatal("fn still running after stopping")
+	}
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/dazraf/go-api-example/internal/activity"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/metrics"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/uploads"
+	"github.com/dazraf/go-api-example/internal/watchdog"
+	"github.com/gin-gonic/gin"
+)
+
+// buildObservability records users' activity, and creates the request
+// metrics and the watchdog of the process
+func (b *builder) buildObservability() error {
+	cfg := b.app.Config
+
+	// Activity of authenticated users is written to the store in batches
+	if recorder, ok := b.users.(store.ActivityRecorder); ok && cfg.Middleware.Activity.Enabled {
+		b.app.activity = activity.NewTracker(recorder, b.app.Clock)
+	}
+
+	// Request metrics are kept for Prometheus or sent to a StatsD agent
+	metricsSink, err := newMetricsSink(cfg.Middleware.Metrics)
+	if err != nil {
+		return err
+	}
+	b.app.metrics = metricsSink
+
+	if cfg.Watchdog.Enabled {
+		b.app.watchdog = newWatchdog(cfg.Watchdog, b.uploadStorage)
+	}
+
+	// Lines dropped by log sinks, watchdog alerts and replication conflicts
+	// are counted alongside the other metrics
+	if prom, ok := metricsSink.(*metrics.Prometheus); ok {
+		b.app.registerCounters(prom)
+	}
+	return nil
+}
+
+// registerCounters counts what the application's components dropped or
+// raised in prom
+func (a *Application) registerCounters(prom *metrics.Prometheus) {
+	for _, name := range a.logs.Sinks() {
+		prom.CounterFunc(metrics.LogLinesDropped, "Log lines dropped by a log sink, because its buffer was full or writing failed.",
+			map[string]string{"sink": name}, func() float64 { return float64(a.logs.Dropped(name)) })
+	}
+	if a.watchdog != nil {
+		for _, check := range watchdog.Checks {
+			prom.CounterFunc(metrics.WatchdogAlerts, "Alerts raised by the watchdog, when a check of the process exceeded its bound.",
+				map[string]string{"check": check}, func() float64 { return float64(a.watchdog.Alerts(check)) })
+		}
+	}
+	if a.replicator != nil {
+		prom.CounterFunc(metrics.ReplicationConflicts, "Replicated user writes that were concurrent with the version held, resolved by the last writer winning.",
+			nil, func() float64 { return float64(a.replicator.Conflicts()) })
+		prom.CounterFunc(metrics.ReplicationDropped, "User writes that could not be sent to another instance, left for reconciliation to repair.",
+			nil, func() float64 { return float64(a.replicator.Dropped()) })
+	}
+	if a.meter != nil {
+		prom.CounterFunc(metrics.UsageDropped, "Usage records dropped because the usage sink failed for too long.",
+			nil, func() float64 { return float64(a.meter.Dropped()) })
+	}
+}
+
+// registerObservabilityHooks flushes users' activity, runs the watchdog
+// and sends metrics to StatsD
+func (a *Application) registerObservabilityHooks() {
+	if a.activity != nil {
+		a.Lifecycle.Append(BackgroundHook("activity tracker", func(ctx context.Context) {
+			a.activity.Run(ctx, a.Config.Middleware.Activity.FlushInterval)
+		}))
+	}
+
+	if a.watchdog != nil {
+		a.Lifecycle.Append(BackgroundHook("watchdog", func(ctx context.Context) {
+			a.watchdog.Run(ctx, a.Config.Watchdog.Interval)
+		}))
+	}
+
+	if statsd, ok := a.metrics.(*metrics.StatsD); ok {
+		hook := BackgroundHook("statsd metrics", func(ctx context.Context) {
+			statsd.Run(ctx, a.Config.Middleware.Metrics.StatsD.FlushInterval)
+		})
+		stopSending := hook.Stop
+		hook.Stop = func(ctx context.Context) error {
+			_ = stopSending(ctx)
+			return statsd.Close()
+		}
+		a.Lifecycle.Append(hook)
+	}
+}
+
+// newLogOutput creates the output for logs, writing to the console and the
+// configured sinks
+func newLogOutput(cfg config.Logging) (*logging.Output, error) {
+	var console io.Writer
+	if cfg.Console {
+		console = os.Stderr
+	}
+	output := logging.NewOutput(console, cfg.Buffer)
+
+	if cfg.File.Enabled {
+		file, err := logging.NewRotatingFile(logging.FileOptions{
+			Path:       cfg.File.Path,
+			MaxSize:    int64(cfg.File.MaxSizeMB) << 20,
+			MaxAge:     cfg.File.MaxAge,
+			MaxBackups: cfg.File.MaxBackups,
+		})
+		if err != nil {
+			_ = output.Close()
+			return nil, err
+		}
+		output.Add("file", file)
+	}
+	if cfg.Syslog.Enabled {
+		writer, err := logging.NewSyslog(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Syslog.Tag)
+		if err != nil {
+			_ = output.Close()
+			return nil, err
+		}
+		output.Add("syslog", writer)
+	}
+	if cfg.OTLP.Enabled {
+		output.Add("otlp", logging.NewOTLPExporter(logging.OTLPOptions{
+			Endpoint:      cfg.OTLP.Endpoint,
+			Headers:       cfg.OTLP.Headers,
+			ServiceName:   cfg.OTLP.ServiceName,
+			BatchSize:     cfg.OTLP.BatchSize,
+			FlushInterval: cfg.OTLP.FlushInterval,
+			Timeout:       cfg.OTLP.Timeout,
+		}))
+	}
+	return output, nil
+}
+
+// setLogOutput sends gin's output to output. Everything else logs through
+// slog, which logging.Configure points at output.
+func setLogOutput(output io.Writer) {
+	gin.DefaultWriter = output
+	gin.DefaultErrorWriter = output
+}
+
+// closeLogOutput puts gin's output back on the console and closes output,
+// writing what its sinks still hold. Lines logged later only reach the
+// console.
+func closeLogOutput(output *logging.Output) error {
+	gin.DefaultWriter = os.Stdout
+	gin.DefaultErrorWriter = os.Stderr
+	return output.Close()
+}
+
+// newMetricsSink creates the configured sink for request metrics, or nil
+// when they are disabled
+func newMetricsSink(cfg config.Metrics) (metrics.Sink, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.Sink {
+	case "prometheus":
+		return metrics.NewPrometheus(metrics.PrometheusOptions{Buckets: cfg.Buckets, Exemplars: cfg.Exemplars}), nil
+	case "statsd":
+		statsd, err := metrics.NewStatsD(metrics.StatsDOptions{
+			Address:   cfg.StatsD.Address,
+			Namespace: cfg.StatsD.Namespace,
+			Tags:      cfg.StatsD.Tags,
+			Format:    cfg.StatsD.Format,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create statsd metrics: %w", err)
+		}
+		return statsd, nil
+	default:
+		return nil, fmt.Errorf("unknown metrics sink %q", cfg.Sink)
+	}
+}
+
+// newWatchdog creates the watchdog as configured, capturing heap profiles to
+// storage when enabled and there is storage
+func newWatchdog(cfg config.Watchdog, storage uploads.Storage) *watchdog.Watchdog {
+	opts := watchdog.Options{
+		MaxGoroutines:   cfg.MaxGoroutines,
+		MaxHeapBytes:    uint64(cfg.MaxHeapMB) << 20,
+		MaxGCPause:      cfg.MaxGCPause,
+		WebhookURL:      cfg.Webhook.URL,
+		WebhookSecret:   cfg.Webhook.Secret,
+		ProfileCooldown: cfg.ProfileCooldown,
+	}
+	if cfg.HeapProfiles {
+		if storage == nil {
+			log.Printf("Warning: watchdog.heap_profiles needs uploads enabled for storage, so heap profiles will not be captured")
+		} else {
+			opts.Storage = storage
+		}
+	}
+	return watchdog.New(opts)
+}
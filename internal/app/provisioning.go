@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dazraf/go-api-example/internal/directory"
+	"github.com/dazraf/go-api-example/internal/handlers"
+)
+
+// buildProvisioning provisions users from an external directory, and lets
+// identity providers provision them through SCIM
+func (b *builder) buildProvisioning() error {
+	cfg := b.app.Config
+
+	// Users are provisioned from an external directory on a schedule
+	if cfg.Directory.Enabled {
+		if cfg.Directory.URL == "" {
+			return errors.New("directory.url is required when directory sync is enabled")
+		}
+		source := directory.NewSCIM(directory.SCIMOptions{
+			URL:      cfg.Directory.URL,
+			Token:    cfg.Directory.Token,
+			PageSize: cfg.Directory.PageSize,
+		})
+		b.app.directory = directory.NewSyncer(source, b.users, b.app.Clock)
+		b.deps.adminHandler.EnableDirectorySync(b.app.directory)
+	}
+
+	// Identity providers provision users through SCIM
+	if cfg.SCIM.Enabled {
+		if cfg.SCIM.Token == "" {
+			return errors.New("scim.token or SCIM_TOKEN is required when SCIM is enabled")
+		}
+		b.deps.scimHandler = handlers.NewSCIMHandler(b.deps.userHandler, cfg.SCIM.Token, cfg.SCIM.MaxResults)
+	}
+	return nil
+}
+
+// registerProvisioningHooks syncs the directory on its schedule
+func (a *Application) registerProvisioningHooks() {
+	if a.directory != nil && a.Config.Directory.Interval > 0 {
+		a.Lifecycle.Append(BackgroundHook("directory sync", func(ctx context.Context) {
+			a.directory.Run(ctx, a.Config.Directory.Interval, a.Config.Directory.DryRun)
+		}))
+	}
+}
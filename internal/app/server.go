@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/discovery"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/instances"
+	"github.com/dazraf/go-api-example/internal/systemd"
+)
+
+// buildInstances registers each instance so operators can list the
+// replicas
+func (b *builder) buildInstances() error {
+	if !b.app.Config.Instances.Enabled {
+		return nil
+	}
+	registry, err := newInstanceRegistry(b.app.Config.Instances)
+	if err != nil {
+		return err
+	}
+	b.app.instances = registry
+	b.deps.instanceHandler = handlers.NewInstanceHandler(registry)
+	return nil
+}
+
+// registerServerHooks serves requests and reloads the configuration while
+// they are served, announcing the instance to service discovery, the
+// instance registry and systemd
+func (a *Application) registerServerHooks() {
+	a.Lifecycle.Append(BackgroundHook("config watcher", a.watchConfig))
+
+	server := newServer(a.Config.Server, a.Router)
+	// bound is the server's listening address, once started
+	var bound net.Addr
+	a.Lifecycle.Append(Hook{
+		Name: "http server",
+		Start: func(context.Context) error {
+			// Bind synchronously so a taken port fails startup
+			listener, err := listen(server.Addr)
+			if err != nil {
+				return err
+			}
+			bound = listener.Addr()
+			go func() {
+				if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+					a.serverErr <- err
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			err := server.Shutdown(ctx)
+			if err != nil {
+				// Out of time: cut the connections that did not drain
+				_ = server.Close()
+			}
+			return err
+		},
+		Timeout: a.Config.Server.ShutdownTimeout,
+	})
+
+	if a.Config.Discovery.Enabled() {
+		var (
+			registrar discovery.Registrar
+			service   discovery.Service
+		)
+		a.Lifecycle.Append(Hook{
+			Name: "service registration",
+			Start: func(ctx context.Context) error {
+				var err error
+				registrar, err = discovery.New(a.Config.Discovery.Provider, a.Config.Discovery.Endpoint, a.Config.Discovery.Token)
+				if err != nil {
+					return err
+				}
+				service, err = newService(a.Config.Discovery, bound)
+				if err != nil {
+					return err
+				}
+				if err := registrar.Register(ctx, service); err != nil {
+					return err
+				}
+				log.Printf("Registered %s as %s with %s", service.Name, service.ID, a.Config.Discovery.Provider)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				return registrar.Deregister(ctx, service.ID)
+			},
+		})
+	}
+
+	if a.instances != nil {
+		var heartbeat *instances.Heartbeat
+		a.Lifecycle.Append(Hook{
+			Name: "instance registry",
+			Start: func(ctx context.Context) error {
+				describe, err := a.describeInstance(bound)
+				if err != nil {
+					return err
+				}
+				heartbeat = instances.NewHeartbeat(a.instances, describe, instances.HeartbeatOptions{
+					Interval: a.Config.Instances.HeartbeatInterval,
+					TTL:      a.Config.Instances.TTL,
+					Clock:    a.Clock,
+				})
+				heartbeat.Start(ctx)
+				log.Printf("Registered instance %s", describe().ID)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				return heartbeat.Stop(ctx)
+			},
+		})
+	}
+
+	a.Lifecycle.Append(BackgroundHook("systemd watchdog", func(ctx context.Context) {
+		interval := systemd.WatchdogInterval()
+		if interval == 0 {
+			return
+		}
+		// Ping at half the interval, as sd_watchdog_enabled(3) advises
+		pingWatchdog(ctx, interval/2)
+	}))
+}
+
+// newServer creates an HTTP server tuned from configuration
+func newServer(cfg config.Server, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	server.SetKeepAlivesEnabled(cfg.KeepAlives)
+
+	if cfg.H2C {
+		// Serve HTTP/2 without TLS (prior knowledge) alongside HTTP/1.1,
+		// e.g. behind a proxy that terminates TLS
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return server
+}
+
+// listen returns the socket passed by systemd socket activation, if any,
+// and otherwise binds addr
+func listen(addr string) (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		return net.Listen("tcp", addr)
+	}
+
+	for _, extra := range listeners[1:] {
+		log.Printf("Ignoring extra socket-activated listener on %s", extra.Addr())
+		_ = extra.Close()
+	}
+	log.Printf("Using socket-activated listener on %s", listeners[0].Addr())
+	return listeners[0], nil
+}
+
+// pingWatchdog tells systemd the process is alive every interval until ctx
+// is cancelled
+func pingWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				log.Printf("Failed to ping systemd watchdog: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newService describes this instance to the registry, advertising the port
+// the server is listening on
+func newService(cfg config.Discovery, bound net.Addr) (discovery.Service, error) {
+	tcp, ok := bound.(*net.TCPAddr)
+	if !ok {
+		return discovery.Service{}, fmt.Errorf("cannot advertise non-TCP address %s", bound)
+	}
+
+	address := cfg.Address
+	if address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return discovery.Service{}, fmt.Errorf("failed to get host name: %w", err)
+		}
+		address = hostname
+	}
+	id := cfg.ServiceID
+	if id == "" {
+		id = fmt.Sprintf("%s-%s-%d", cfg.ServiceName, address, tcp.Port)
+	}
+
+	return discovery.Service{
+		ID:              id,
+		Name:            cfg.ServiceName,
+		Address:         address,
+		Port:            tcp.Port,
+		Tags:            cfg.Tags,
+		CheckURL:        fmt.Sprintf("http://%s/readyz", net.JoinHostPort(address, strconv.Itoa(tcp.Port))),
+		CheckInterval:   cfg.CheckInterval,
+		DeregisterAfter: cfg.DeregisterAfter,
+	}, nil
+}
+
+// describeInstance returns a func describing this instance to the registry,
+// listening on bound, with its current readiness and configuration
+func (a *Application) describeInstance(bound net.Addr) (func() instances.Instance, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host name: %w", err)
+	}
+	port := 0
+	if tcp, ok := bound.(*net.TCPAddr); ok {
+		port = tcp.Port
+	}
+	id := a.Config.Instances.ID
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", hostname, port)
+	}
+	version, revision := instances.Build()
+	instance := instances.Instance{
+		ID:        id,
+		Hostname:  hostname,
+		Address:   net.JoinHostPort(hostname, strconv.Itoa(port)),
+		Version:   version,
+		Revision:  revision,
+		GoVersion: instances.GoVersion(),
+		StartedAt: a.Clock.Now().UTC(),
+	}
+	return func() instances.Instance {
+		current := instance
+		current.Ready = a.readiness.ready.Load()
+		// Remote changes apply without a restart, so replicas can drift
+		current.ConfigFingerprint = a.LiveConfig().Fingerprint()
+		return current
+	}, nil
+}
+
+// newInstanceRegistry creates the registry the instance registers itself in
+func newInstanceRegistry(cfg config.Instances) (instances.Registry, error) {
+	switch cfg.Registry {
+	case "", "memory":
+		return instances.NewMemory(nil), nil
+	case "redis":
+		log.Printf("Registering instances in Redis at %s", cfg.Redis.Address)
+		return instances.NewRedis(newRedisClient(cfg.Redis), cfg.Redis.Prefix), nil
+	default:
+		return nil, fmt.Errorf("unknown instance registry %q, expected memory or redis", cfg.Registry)
+	}
+}
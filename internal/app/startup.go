@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/config"
+)
+
+// dependencyCheckTimeout bounds a single reachability check
+const dependencyCheckTimeout = 3 * time.Second
+
+// waitForDependencies blocks until each configured dependency, in order, is
+// reachable, retrying with exponential backoff until the wait timeout.
+// With failFast every dependency is checked exactly once.
+func waitForDependencies(ctx context.Context, cfg config.Startup, failFast bool) error {
+	if len(cfg.Dependencies) == 0 {
+		return nil
+	}
+
+	if !failFast && cfg.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.WaitTimeout)
+		defer cancel()
+	}
+
+	for _, dep := range cfg.Dependencies {
+		if err := waitForDependency(ctx, cfg, dep, failFast); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForDependency retries a single dependency until it is reachable
+func waitForDependency(ctx context.Context, cfg config.Startup, dep config.Dependency, failFast bool) error {
+	start := time.Now()
+	backoff := cfg.InitialBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	for attempt := 1; ; attempt++ {
+		err := checkDependency(ctx, dep)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Dependency %s is reachable after %v", dep.Name, time.Since(start).Round(time.Millisecond))
+			}
+			return nil
+		}
+		if failFast {
+			return fmt.Errorf("dependency %s is unreachable: %w", dep.Name, err)
+		}
+
+		log.Printf("Waiting for dependency %s (attempt %d): %v; retrying in %v", dep.Name, attempt, err, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("dependency %s is still unreachable after %v: %w", dep.Name, time.Since(start).Round(time.Millisecond), err)
+		}
+
+		backoff *= 2
+		if cfg.MaxBackoff > 0 && backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+}
+
+// checkDependency makes one reachability check
+func checkDependency(ctx context.Context, dep config.Dependency) error {
+	ctx, cancel := context.WithTimeout(ctx, dependencyCheckTimeout)
+	defer cancel()
+
+	switch {
+	case dep.URL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, dep.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned %s", dep.URL, resp.Status)
+		}
+		return nil
+	case dep.Address != "":
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", dep.Address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		return errors.New("neither address nor url is configured")
+	}
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/config"
+)
+
+// unusedAddress returns a local TCP address with nothing listening on it
+func unusedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
+
+func TestWaitForDependencies(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	startup := config.Startup{
+		WaitTimeout:    200 * time.Millisecond,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}
+
+	tests := []struct {
+		name         string
+		dependencies []config.Dependency
+		failFast     bool
+		expectError  bool
+	}{
+		{name: "no dependencies"},
+		{
+			name: "reachable dependencies",
+			dependencies: []config.Dependency{
+				{Name: "tcp", Address: healthy.Listener.Addr().String()},
+				{Name: "http", URL: healthy.URL},
+			},
+		},
+		{
+			name:         "unreachable dependency times out",
+			dependencies: []config.Dependency{{Name: "db", Address: unusedAddress(t)}},
+			expectError:  true,
+		},
+		{
+			name:         "unhealthy dependency times out",
+			dependencies: []config.Dependency{{Name: "bus", URL: unhealthy.URL}},
+			expectError:  true,
+		},
+		{
+			name:         "fail fast",
+			dependencies: []config.Dependency{{Name: "db", Address: unusedAddress(t)}},
+			failFast:     true,
+			expectError:  true,
+		},
+		{
+			name:         "missing target",
+			dependencies: []config.Dependency{{Name: "redis"}},
+			failFast:     true,
+			expectError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := startup
+			cfg.Dependencies = tt.dependencies
+
+			err := waitForDependencies(context.Background(), cfg, tt.failFast)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWaitForDependencies_WaitsForLateDependency(t *testing.T) {
+	address := unusedAddress(t)
+	cfg := config.Startup{
+		WaitTimeout:    5 * time.Second,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		Dependencies:   []config.Dependency{{Name: "db", Address: address}},
+	}
+
+	// Start listening only after the first attempts have failed
+	started := make(chan net.Listener)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			close(started)
+			return
+		}
+		started <- listener
+	}()
+
+	err := waitForDependencies(context.Background(), cfg, false)
+	listener, ok := <-started
+	require.True(t, ok, "address was taken before the listener started")
+	defer listener.Close()
+	assert.NoError(t, err)
+}
+
+func TestWaitForDependencies_FailFastDoesNotRetry(t *testing.T) {
+	cfg := config.Startup{
+		WaitTimeout:    time.Minute,
+		InitialBackoff: time.Minute,
+		Dependencies:   []config.Dependency{{Name: "db", Address: unusedAddress(t)}},
+	}
+
+	start := time.Now()
+	assert.Error(t, waitForDependencies(context.Background(), cfg, true))
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
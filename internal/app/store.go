@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/dazraf/go-api-example/internal/anonymize"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/replication"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// buildUserStore opens the user store, replicating it between instances
+// when configured, and serves its change feed
+func (b *builder) buildUserStore() error {
+	cfg := b.app.Config
+	userStore, err := newUserStore(cfg, b.app.Clock)
+	if err != nil {
+		return err
+	}
+	b.users = userStore
+	b.app.UserStore = userStore
+
+	// Changes are read beneath replication, so writes replicated from other
+	// instances are captured too
+	if feed, ok := userStore.(store.ChangeFeed); ok {
+		b.deps.changeHandler = handlers.NewChangeHandler(feed)
+	}
+	if cfg.Database.Replication.Enabled {
+		replicator, err := newReplicator(cfg.Database.Replication, userStore, b.app.Clock)
+		if err != nil {
+			return err
+		}
+		b.app.replicator = replicator
+		b.users = replicator
+		b.app.UserStore = replicator
+		b.deps.replicationHandler = handlers.NewReplicationHandler(replicator)
+	}
+
+	// Add some initial sample data to an empty store. Replicas get it from
+	// the first node rather than each adding their own.
+	users := b.users
+	if count, _ := users.Count(); count == 0 && (b.app.replicator == nil || isFirstNode(cfg.Database.Replication)) {
+		_, _ = users.Create(store.User{Name: "John Doe", Email: "john@example.com"})
+		_, _ = users.Create(store.User{Name: "Jane Smith", Email: "jane@example.com"})
+	}
+
+	// Production snapshots are anonymized before anything can read them
+	b.anonymizer = anonymize.New(cfg.Anonymization.Domain)
+	return anonymizeOnStartup(cfg, b.anonymizer, users)
+}
+
+// registerStoreHooks closes the user store last, after replication stops
+func (a *Application) registerStoreHooks() {
+	a.Lifecycle.Append(Hook{
+		Name: "user store",
+		Stop: func(context.Context) error { return a.closeStore() },
+	})
+
+	if a.replicator != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "replication",
+			Start: func(context.Context) error {
+				a.replicator.Start()
+				return nil
+			},
+			Stop: a.replicator.Stop,
+		})
+	}
+}
+
+// closeStore closes the user store if it holds resources
+func (a *Application) closeStore() error {
+	if closer, ok := a.UserStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// verifiableStore is a user store that supports integrity verification
+type verifiableStore interface {
+	store.UserStore
+	store.Verifier
+}
+
+// newUserStore creates the bolt, MongoDB or memory store, the memory store
+// journaled, and wraps it with change data capture when configured
+func newUserStore(cfg *config.Config, clk clock.Clock) (userStore verifiableStore, err error) {
+	ids, err := newUserIDs(cfg.IDs, clk)
+	if err != nil {
+		return nil, err
+	}
+	journal := cfg.Database.Journal
+	switch {
+	case cfg.Database.Type == "bolt":
+		bolt := cfg.Database.Bolt
+		userStore, err = store.NewBoltUserStore(store.BoltOptions{Path: bolt.Path, Timeout: bolt.Timeout, Clock: clk, IDs: ids})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open user store: %w", err)
+		}
+		log.Printf("Storing users in %s", bolt.Path)
+	case cfg.Database.Type == "mongo":
+		mongo := cfg.Database.Mongo
+		userStore, err = store.NewMongoUserStore(store.MongoOptions{
+			URI:        mongo.URI,
+			Database:   mongo.Database,
+			Collection: mongo.Collection,
+			Timeout:    mongo.Timeout,
+			Clock:      clk,
+			IDs:        ids,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open user store: %w", err)
+		}
+		log.Printf("Storing users in MongoDB collection %s.%s", mongo.Database, mongo.Collection)
+	case journal.Enabled:
+		userStore, err = store.NewJournaledMemoryUserStore(store.JournalOptions{
+			Path:            journal.Path,
+			Fsync:           journal.Fsync,
+			FsyncInterval:   journal.FsyncInterval,
+			CompactInterval: journal.CompactInterval,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open user store: %w", err)
+		}
+	default:
+		userStore = store.NewMemoryUserStore()
+	}
+	if memory, ok := userStore.(*store.MemoryUserStore); ok && ids != nil {
+		memory.SetIDs(ids)
+	}
+
+	cdc := cfg.Database.CDC
+	if cdc.Enabled {
+		captured, err := store.NewChangeCapturingUserStore(userStore, store.ChangeLogOptions{
+			OutboxPath: cdc.OutboxPath,
+			Retention:  cdc.Retention,
+			Clock:      clk,
+		})
+		if err != nil {
+			if closer, ok := userStore.(io.Closer); ok {
+				_ = closer.Close()
+			}
+			return nil, err
+		}
+		userStore = captured
+	}
+
+	return userStore, nil
+}
+
+// newReplicator wraps userStore with replication between the configured
+// instances
+func newReplicator(cfg config.Replication, userStore store.UserStore, clk clock.Clock) (*replication.Store, error) {
+	nodes := make([]replication.Node, len(cfg.Nodes))
+	for i, node := range cfg.Nodes {
+		nodes[i] = replication.Node{Name: node.Name, URL: node.URL}
+	}
+	replicator, err := replication.New(userStore, replication.Options{
+		Node:              cfg.Node,
+		Nodes:             nodes,
+		Secret:            cfg.Secret,
+		QueueSize:         cfg.QueueSize,
+		MaxAttempts:       cfg.MaxAttempts,
+		InitialBackoff:    cfg.InitialBackoff,
+		ReconcileInterval: cfg.ReconcileInterval,
+		Clock:             clk,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up replication: %w", err)
+	}
+	log.Printf("Replicating users as node %s of %d", cfg.Node, len(nodes))
+	return replicator, nil
+}
+
+// isFirstNode reports whether this instance is the first replication node
+func isFirstNode(cfg config.Replication) bool {
+	return len(cfg.Nodes) > 0 && cfg.Nodes[0].Name == cfg.Node
+}
+
+// anonymizeOnStartup anonymizes every user when configured to, refusing to
+// in production or to serve the admin endpoint there
+func anonymizeOnStartup(cfg *config.Config, anonymizer *anonymize.Anonymizer, userStore store.UserStore) error {
+	if cfg.Environment == "production" && (cfg.Anonymization.Enabled || cfg.Anonymization.OnStartup) {
+		return errors.New("anonymization is not allowed in production")
+	}
+	if !cfg.Anonymization.OnStartup {
+		return nil
+	}
+	report, err := anonymizer.Store(context.Background(), userStore)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize users: %w", err)
+	}
+	log.Printf("Anonymized %d user(s)", report.Users)
+	return nil
+}
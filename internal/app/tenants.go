@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/preferences"
+	"github.com/dazraf/go-api-example/internal/tenants"
+	"github.com/dazraf/go-api-example/internal/usage"
+)
+
+// buildTenants lets users and tenants choose how the API treats their
+// requests, and meters each principal's use of it
+func (b *builder) buildTenants() error {
+	cfg := b.app.Config
+	profiles := slices.Sorted(maps.Keys(cfg.Middleware.RateLimit.Profiles))
+
+	// Users choose how the API pages and formats their responses
+	if cfg.Preferences.Enabled {
+		b.deps.preferenceStore = preferences.NewStore(preferences.Options{
+			MaxPageSize: 1000,
+			Profiles:    profiles,
+		})
+		b.deps.preferenceHandler = handlers.NewPreferenceHandler(b.deps.preferenceStore, b.users)
+	}
+
+	// Tenants override the server's settings for their requests
+	if cfg.Tenants.Enabled {
+		tenantStore, err := newTenantStore(cfg.Tenants, profiles)
+		if err != nil {
+			return err
+		}
+		b.deps.tenantStore = tenantStore
+		b.deps.tenantHandler = handlers.NewTenantHandler(tenantStore)
+	}
+
+	// Each principal's use of the API is metered for billing
+	if cfg.Usage.Enabled {
+		meter, err := newUsageMeter(cfg.Usage, b.app.Clock)
+		if err != nil {
+			return err
+		}
+		b.app.meter = meter
+		b.deps.usageHandler = handlers.NewUsageHandler(meter)
+	}
+	return nil
+}
+
+// registerTenantHooks starts exporting usage
+func (a *Application) registerTenantHooks() {
+	if a.meter != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "usage metering",
+			Start: func(context.Context) error {
+				a.meter.Start()
+				return nil
+			},
+			Stop: a.meter.Stop,
+		})
+	}
+}
+
+// newTenantStore creates the store of tenants' overrides, holding those
+// configured
+func newTenantStore(cfg config.Tenants, profiles []string) (*tenants.Store, error) {
+	tenantStore := tenants.NewStore(tenants.Options{Profiles: profiles, Features: cfg.Features})
+	for id, overrides := range cfg.Overrides {
+		hooks := make([]tenants.Webhook, len(overrides.Webhooks))
+		for i, webhook := range overrides.Webhooks {
+			hooks[i] = tenants.Webhook{URL: webhook.URL, Events: webhook.Events, Secret: webhook.Secret}
+		}
+		_, err := tenantStore.Put(id, tenants.Settings{
+			RateProfile:  overrides.RateProfile,
+			Features:     overrides.Features,
+			EmailDomains: overrides.EmailDomains,
+			Webhooks:     hooks,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides of tenant %q: %w", id, err)
+		}
+	}
+	return tenantStore, nil
+}
+
+// newUsageMeter creates the usage meter, exporting to the configured sink
+func newUsageMeter(cfg config.Usage, clk clock.Clock) (*usage.Meter, error) {
+	var sink usage.Sink
+	switch cfg.Sink {
+	case "", "none":
+	case "file":
+		sink = usage.NewFile(cfg.File.Path)
+	case "http":
+		if cfg.HTTP.URL == "" {
+			return nil, fmt.Errorf("usage.http.url is required for the http usage sink")
+		}
+		sink = usage.NewHTTP(cfg.HTTP.URL, cfg.HTTP.Token, cfg.HTTP.Timeout)
+	case "kafka":
+		if len(cfg.Kafka.Brokers) == 0 || cfg.Kafka.Topic == "" {
+			return nil, fmt.Errorf("usage.kafka.brokers and topic are required for the kafka usage sink")
+		}
+		sink = usage.NewKafka(cfg.Kafka.Brokers, cfg.Kafka.Topic, cfg.Kafka.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown usage sink %q, expected none, file, http or kafka", cfg.Sink)
+	}
+	if sink != nil {
+		log.Printf("Exporting usage every %v to the %s sink", cfg.Window, cfg.Sink)
+	}
+	return usage.NewMeter(sink, usage.Options{Window: cfg.Window, Retention: cfg.Retention, MaxPending: cfg.MaxPending, Clock: clk}), nil
+}
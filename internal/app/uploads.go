@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dazraf/go-api-example/internal/avatars"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/reports"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// buildUploads lets large files be uploaded straight to storage rather
+// than through the API, and keeps avatars and reports there
+func (b *builder) buildUploads() error {
+	cfg := b.app.Config
+	clk := b.app.Clock
+
+	if cfg.Uploads.Enabled {
+		storage, local, err := newUploadStorage(cfg.Uploads, clk)
+		if err != nil {
+			return err
+		}
+		scanner, err := newUploadScanner(cfg.Uploads.Scan)
+		if err != nil {
+			return err
+		}
+		b.app.uploads = uploads.NewManager(storage, uploads.Options{
+			Purposes:   cfg.Uploads.Purposes,
+			TTL:        cfg.Uploads.TTL,
+			Retention:  cfg.Uploads.Retention,
+			Scanner:    scanner,
+			Quarantine: cfg.Uploads.Scan.Quarantine,
+			Clock:      clk,
+			IDs:        b.storeIDs("uploads"),
+		})
+		b.deps.uploadHandler = handlers.NewUploadHandler(b.app.uploads, local)
+		b.uploadStorage = storage
+
+		// Avatars are made from avatar uploads and kept in the same storage
+		if cfg.Avatars.Enabled {
+			processor := avatars.NewProcessor(storage, avatars.Options{
+				Sizes:        cfg.Avatars.Sizes,
+				MinDimension: cfg.Avatars.MinDimension,
+				MaxDimension: cfg.Avatars.MaxDimension,
+			})
+			b.deps.userHandler.EnableAvatars(processor, b.app.uploads, cfg.Avatars.MaxAge)
+		}
+	}
+	if cfg.Avatars.Enabled && (!cfg.Uploads.Enabled || cfg.Uploads.Purposes["avatar"] == 0) {
+		return errors.New("avatars need uploads.enabled with an avatar purpose")
+	}
+
+	// Admins get PDF reports about users; large ones are generated as
+	// operations and kept in upload storage until downloaded
+	if cfg.Reports.Enabled {
+		b.app.reports = reports.NewManager(b.uploadStorage, reports.Options{
+			Recent:    cfg.Reports.Recent,
+			Retention: cfg.Reports.Retention,
+			Clock:     clk,
+			IDs:       b.storeIDs("reports"),
+		})
+		b.deps.adminHandler.EnableReports(b.users, b.app.reports, cfg.Reports.SyncLimit)
+	}
+	return nil
+}
+
+// registerUploadHooks cleans up expired uploads and stored reports
+func (a *Application) registerUploadHooks() {
+	if a.uploads != nil {
+		a.Lifecycle.Append(BackgroundHook("upload cleanup", func(ctx context.Context) {
+			a.uploads.Run(ctx, a.Config.Uploads.CleanupInterval)
+		}))
+	}
+
+	if a.reports != nil && a.reports.CanStore() {
+		a.Lifecycle.Append(BackgroundHook("report cleanup", func(ctx context.Context) {
+			a.reports.Run(ctx, a.Config.Reports.CleanupInterval)
+		}))
+	}
+}
+
+// newUploadStorage creates the configured storage for uploads, and the
+// local storage that receives files when that is what is used
+func newUploadStorage(cfg config.Uploads, clk clock.Clock) (uploads.Storage, *uploads.LocalStorage, error) {
+	switch cfg.Backend {
+	case "local":
+		local, err := uploads.NewLocalStorage(cfg.Local.Dir, cfg.Local.BaseURL, clk)
+		return local, local, err
+	case "s3":
+		storage, err := uploads.NewS3Storage(uploads.S3Options{
+			Bucket:          cfg.S3.Bucket,
+			Region:          cfg.S3.Region,
+			Endpoint:        cfg.S3.Endpoint,
+			PathStyle:       cfg.S3.PathStyle,
+			Prefix:          cfg.S3.Prefix,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			Clock:           clk,
+		})
+		return storage, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unknown uploads backend %q, expected local or s3", cfg.Backend)
+	}
+}
+
+// newUploadScanner creates the configured scanner for uploaded files
+func newUploadScanner(cfg config.UploadScan) (uploads.Scanner, error) {
+	switch cfg.Scanner {
+	case "", "none":
+		return uploads.NopScanner{}, nil
+	case "clamav":
+		return uploads.NewClamAV(cfg.ClamAV.Address, cfg.ClamAV.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown uploads scanner %q, expected none or clamav", cfg.Scanner)
+	}
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/approval"
+	"github.com/dazraf/go-api-example/internal/backup"
+	"github.com/dazraf/go-api-example/internal/config"
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/materialized"
+	"github.com/dazraf/go-api-example/internal/merge"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/orgs"
+	"github.com/dazraf/go-api-example/internal/plugins"
+	"github.com/dazraf/go-api-example/internal/scripts"
+	"github.com/dazraf/go-api-example/internal/stats"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/surrogate"
+	"github.com/dazraf/go-api-example/internal/views"
+	"github.com/dazraf/go-api-example/internal/visibility"
+)
+
+// buildUsers creates the user and admin handlers, with the caching,
+// materialized views and stats they serve users through
+func (b *builder) buildUsers() error {
+	cfg := b.app.Config
+	users := b.users
+
+	// Create handler with dependency injection
+	userHandler := handlers.NewUserHandler(users, b.listeners...)
+	b.deps.userHandler = userHandler
+	// Cached responses about users are purged in the background once the
+	// application starts
+	if caching := cfg.Middleware.Caching; caching.SurrogateKeys {
+		purger, err := newPurger(caching.CDN)
+		if err != nil {
+			return err
+		}
+		b.app.purgeQueue = surrogate.NewQueue(purger, surrogate.QueueOptions{
+			Size:           caching.CDN.QueueSize,
+			MaxAttempts:    caching.CDN.MaxAttempts,
+			InitialBackoff: caching.CDN.InitialBackoff,
+		})
+		userHandler.EnableSurrogateKeys(b.app.purgeQueue)
+	}
+	userHandler.SetAnonymizer(b.anonymizer)
+	b.deps.adminHandler = handlers.NewAdminHandler(users)
+	if cfg.Anonymization.Enabled {
+		b.deps.adminHandler.EnableAnonymization(users, b.anonymizer)
+	}
+
+	// Materialized views and stats are served from results refreshed in the
+	// background
+	if cfg.Materialized.Enabled {
+		opts := materialized.Options{RefreshDelay: cfg.Materialized.RefreshDelay, IdleTimeout: cfg.Materialized.IdleTimeout, Clock: b.app.Clock}
+		if revisioner, ok := users.(store.Revisioner); ok {
+			opts.Revision = revisioner.Revision
+		}
+		b.app.materialized = materialized.NewStore(opts)
+		userHandler.EnableMaterializedViews(b.app.materialized)
+	}
+	if cfg.Stats.Enabled {
+		b.deps.statsHandler = handlers.NewStatsHandler(stats.NewPublisher(users, stats.Privacy{
+			MinBucket: cfg.Stats.Privacy.MinBucket,
+			Epsilon:   cfg.Stats.Privacy.Epsilon,
+		}))
+		if b.app.materialized != nil {
+			b.deps.statsHandler.EnableMaterialized(b.app.materialized)
+		}
+	}
+	return nil
+}
+
+// buildUserFeatures enables the optional ways of changing users: with
+// preconditions, undoably, after approval, through saved views, within
+// organizations and in the background
+func (b *builder) buildUserFeatures() error {
+	cfg := b.app.Config
+	clk := b.app.Clock
+	userHandler := b.deps.userHandler
+
+	if cfg.Preconditions.Required {
+		userHandler.RequirePreconditions()
+	}
+
+	// Deleted users stay restorable for the undo window
+	if recoverer, ok := b.users.(store.Recoverer); ok && cfg.Database.Undo.Enabled {
+		b.app.recycleBin = store.NewRecycleBin(recoverer, cfg.Database.Undo.Window, clk)
+		userHandler.EnableUndelete(b.app.recycleBin)
+	}
+
+	// Destructive operations can be held for a second admin's approval
+	if len(cfg.Approvals.Operations) > 0 {
+		approvals := approval.NewWorkflow(approval.Options{TTL: cfg.Approvals.TTL, Clock: clk, IDs: b.storeIDs("approvals")})
+		for _, operation := range cfg.Approvals.Operations {
+			switch operation {
+			case approval.DeleteUser:
+				userHandler.RequireDeleteApproval(approvals)
+			default:
+				return fmt.Errorf("unknown operation %q in approvals, expected %s", operation, approval.DeleteUser)
+			}
+		}
+		b.deps.approvalHandler = handlers.NewApprovalHandler(approvals)
+	}
+
+	// Callers can save list queries and reuse them by name
+	if cfg.Views.Enabled {
+		viewStore := views.NewStore(views.Options{MaxPerOwner: cfg.Views.MaxPerOwner, Clock: clk})
+		userHandler.EnableViews(viewStore)
+		b.deps.viewHandler = handlers.NewViewHandler(viewStore)
+	}
+
+	// Users are members of organizations, inheriting roles down the hierarchy
+	if cfg.Orgs.Enabled {
+		b.orgs = orgs.NewStore(orgs.Options{Clock: clk, IDs: b.storeIDs("orgs")})
+		b.deps.orgHandler = handlers.NewOrgHandler(b.orgs, b.users)
+	}
+
+	// Long-running work is accepted straight away and run in the background
+	if cfg.Operations.Enabled {
+		b.app.operations = operations.NewManager(operations.Options{
+			Workers:         cfg.Operations.Workers,
+			Size:            cfg.Operations.QueueSize,
+			TTL:             cfg.Operations.TTL,
+			CleanupInterval: cfg.Operations.CleanupInterval,
+			Clock:           clk,
+			IDs:             b.storeIDs("operations"),
+		})
+		userHandler.EnableAsync(b.app.operations)
+		b.deps.adminHandler.EnableAsync(b.app.operations)
+		b.deps.operationHandler = handlers.NewOperationHandler(b.app.operations)
+	}
+	return nil
+}
+
+// buildAdminTools lets admins back up every enabled component and merge
+// duplicate users
+func (b *builder) buildAdminTools() error {
+	users := b.users
+	adminHandler := b.deps.adminHandler
+
+	// Admins back up the state of every enabled component into one archive,
+	// and restore archives to clone environments
+	if b.app.Config.Backup.Enabled {
+		adminHandler.EnableBackup(backup.New(backup.Options{
+			Users:    users,
+			APIKeys:  b.apiKeys,
+			Webhooks: b.app.webhooks,
+			Tenants:  b.deps.tenantStore,
+			Rules:    b.app.rules,
+			Clock:    b.app.Clock,
+		}))
+	}
+
+	// Admins merge duplicate users, moving their memberships and what rules
+	// did to them over to the survivor
+	merger := merge.New(users)
+	merger.Hook(b.deps.userHandler.MergeHooks())
+	if b.orgs != nil {
+		merger.Remap("memberships", b.orgs)
+	}
+	if b.app.rules != nil {
+		merger.Remap("rules", b.app.rules)
+	}
+	adminHandler.EnableMerge(users, merger)
+	return nil
+}
+
+// buildUserPolicies restricts which fields callers see, and runs plugins
+// and scripts on the users created and read
+func (b *builder) buildUserPolicies() error {
+	cfg := b.app.Config
+	userHandler := b.deps.userHandler
+
+	// Sensitive fields are hidden from callers without the roles to see them
+	if len(cfg.Visibility.Fields) > 0 {
+		policy := visibility.NewPolicy(cfg.Visibility.Fields)
+		userHandler.RestrictFields(policy)
+		if b.deps.changeHandler != nil {
+			b.deps.changeHandler.RestrictFields(policy)
+		}
+		if b.deps.orgHandler != nil {
+			b.deps.orgHandler.RestrictFields(policy)
+		}
+	}
+
+	// External plugins validate users before they are created and enrich
+	// them after they are read
+	if cfg.Plugins.Enabled {
+		runner, err := newPluginRunner(cfg.Plugins)
+		if err != nil {
+			return err
+		}
+		userHandler.EnablePlugins(runner)
+	}
+
+	// Lua scripts do the same in process, after plugins
+	if cfg.Scripts.Enabled {
+		engine, err := scripts.New(toScripts(cfg.Scripts), cfg.Scripts.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid scripts: %w", err)
+		}
+		b.app.scripts = engine
+		userHandler.EnableScripts(engine)
+		log.Printf("Running %d script(s)", len(cfg.Scripts.Scripts))
+	}
+	return nil
+}
+
+// registerUserHooks starts purging cached users and running operations,
+// and the background work of deleted users and materialized views
+func (a *Application) registerUserHooks() {
+	if a.purgeQueue != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "cdn purges",
+			Start: func(context.Context) error {
+				a.purgeQueue.Start()
+				return nil
+			},
+			Stop: a.purgeQueue.Stop,
+		})
+	}
+
+	if a.operations != nil {
+		a.Lifecycle.Append(Hook{
+			Name: "operations",
+			Start: func(context.Context) error {
+				a.operations.Start()
+				return nil
+			},
+			Stop: a.operations.Stop,
+		})
+	}
+
+	if a.recycleBin != nil {
+		a.Lifecycle.Append(BackgroundHook("deletion purger", func(ctx context.Context) {
+			a.recycleBin.Run(ctx, a.Config.Database.Undo.PurgeInterval)
+		}))
+	}
+
+	if a.materialized != nil {
+		a.Lifecycle.Append(BackgroundHook("materialized view refresh", func(ctx context.Context) {
+			a.materialized.Run(ctx, a.Config.Materialized.RefreshInterval)
+		}))
+	}
+}
+
+// newPurger creates the purger for the configured CDN
+func newPurger(cfg config.CDN) (surrogate.Purger, error) {
+	switch cfg.Provider {
+	case "", "log":
+		return surrogate.NewLog(), nil
+	case "cloudflare":
+		if cfg.Cloudflare.ZoneID == "" || cfg.Cloudflare.Token == "" {
+			return nil, errors.New("cdn provider cloudflare needs a zone_id and token")
+		}
+		return surrogate.NewCloudflare(surrogate.CloudflareOptions{ZoneID: cfg.Cloudflare.ZoneID, Token: cfg.Cloudflare.Token}), nil
+	case "fastly":
+		if cfg.Fastly.ServiceID == "" || cfg.Fastly.Token == "" {
+			return nil, errors.New("cdn provider fastly needs a service_id and token")
+		}
+		return surrogate.NewFastly(surrogate.FastlyOptions{ServiceID: cfg.Fastly.ServiceID, Token: cfg.Fastly.Token, Soft: cfg.Fastly.Soft}), nil
+	default:
+		return nil, fmt.Errorf("unknown cdn provider %q, expected log, cloudflare or fastly", cfg.Provider)
+	}
+}
+
+// newPluginRunner creates the runner of the configured plugins
+func newPluginRunner(cfg config.Plugins) (*plugins.Runner, error) {
+	configured := make([]plugins.Plugin, len(cfg.Plugins))
+	for i, p := range cfg.Plugins {
+		configured[i] = plugins.Plugin{Name: p.Name, Command: p.Command, Hooks: p.Hooks, Timeout: p.Timeout, FailOpen: p.FailOpen}
+	}
+	runner, err := plugins.New(configured, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugins: %w", err)
+	}
+	for _, p := range configured {
+		log.Printf("Running plugin %s for %s", p.Name, strings.Join(p.Hooks, ", "))
+	}
+	return runner, nil
+}
@@ -0,0 +1,195 @@
+// Package approval holds destructive operations until a second admin
+// approves them. An operation requested by one caller is stored as a
+// pending request and only runs when someone else approves it before it
+// expires.
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+// Operations that can require approval
+const (
+	DeleteUser = "user.delete"
+)
+
+// Request statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusFailed   = "failed"
+	StatusExpired  = "expired"
+)
+
+var (
+	// ErrNotFound is returned for unknown approval requests
+	ErrNotFound = errors.New("approval request not found")
+	// ErrNotPending is returned when approving a request that was already
+	// decided or has expired
+	ErrNotPending = errors.New("approval request is not pending")
+	// ErrSelfApproval is returned when the requester tries to approve
+	// their own request
+	ErrSelfApproval = errors.New("approval must come from someone other than the requester")
+)
+
+// Request is an operation waiting for, or given, approval
+type Request struct {
+	ID          string     `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Operation   string     `json:"operation" example:"user.delete"`
+	Target      string     `json:"target" example:"1"`
+	Status      string     `json:"status" example:"pending"`
+	RequestedBy string     `json:"requested_by,omitempty" example:"2"`
+	RequestedAt time.Time  `json:"requested_at" example:"2024-01-02T15:04:05Z"`
+	ExpiresAt   time.Time  `json:"expires_at" example:"2024-01-03T15:04:05Z"`
+	ApprovedBy  string     `json:"approved_by,omitempty" example:"3"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty" example:"2024-01-02T16:04:05Z"`
+	// Error is why the operation failed after approval
+	Error string `json:"error,omitempty"`
+}
+
+// Executor carries out an approved operation on its target
+type Executor func(ctx context.Context, target string) error
+
+// Options configures a Workflow
+type Options struct {
+	// TTL is how long a request waits for approval
+	TTL   time.Duration
+	Clock clock.Clock
+	IDs   idgen.Generator
+}
+
+// Workflow holds approval requests in memory and runs operations once
+// approved
+type Workflow struct {
+	mutex     sync.Mutex
+	requests  map[string]*Request
+	executors map[string]Executor
+	opts      Options
+}
+
+// NewWorkflow creates a workflow with no operations registered
+func NewWorkflow(opts Options) *Workflow {
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+	return &Workflow{
+		requests:  make(map[string]*Request),
+		executors: make(map[string]Executor),
+		opts:      opts,
+	}
+}
+
+// Register makes operation require approval, running execute once approved
+func (w *Workflow) Register(operation string, execute Executor) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.executors[operation] = execute
+}
+
+// Submit creates a pending request for operation on target. A request
+// still pending for the same operation and target is returned instead of
+// creating another.
+func (w *Workflow) Submit(operation, target, requestedBy string) (Request, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, ok := w.executors[operation]; !ok {
+		return Request{}, fmt.Errorf("operation %q does not require approval", operation)
+	}
+
+	now := w.opts.Clock.Now().UTC()
+	for _, request := range w.requests {
+		w.expire(request, now)
+		if request.Status == StatusPending && request.Operation == operation && request.Target == target {
+			return *request, nil
+		}
+	}
+
+	request := &Request{
+		ID:          w.opts.IDs.NewID(),
+		Operation:   operation,
+		Target:      target,
+		Status:      StatusPending,
+		RequestedBy: requestedBy,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(w.opts.TTL),
+	}
+	w.requests[request.ID] = request
+	return *request, nil
+}
+
+// List returns every request, newest first
+func (w *Workflow) List() []Request {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.opts.Clock.Now()
+	requests := make([]Request, 0, len(w.requests))
+	for _, request := range w.requests {
+		w.expire(request, now)
+		requests = append(requests, *request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedAt.After(requests[j].RequestedAt) })
+	return requests
+}
+
+// Approve runs a pending request's operation on behalf of approver, who
+// must not be the requester. The request records whether the operation
+// succeeded, and its error is returned.
+func (w *Workflow) Approve(ctx context.Context, id, approver string) (Request, error) {
+	w.mutex.Lock()
+	request, ok := w.requests[id]
+	if !ok {
+		w.mutex.Unlock()
+		return Request{}, ErrNotFound
+	}
+	now := w.opts.Clock.Now().UTC()
+	w.expire(request, now)
+	if request.Status != StatusPending {
+		defer w.mutex.Unlock()
+		return *request, ErrNotPending
+	}
+	if approver == request.RequestedBy {
+		defer w.mutex.Unlock()
+		return *request, ErrSelfApproval
+	}
+
+	// Claim the request before running it, so it cannot run twice
+	request.Status = StatusApproved
+	request.ApprovedBy = approver
+	request.DecidedAt = &now
+	execute := w.executors[request.Operation]
+	target := request.Target
+	w.mutex.Unlock()
+
+	err := execute(ctx, target)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err != nil {
+		request.Status = StatusFailed
+		request.Error = err.Error()
+	}
+	return *request, err
+}
+
+// expire marks a pending request expired once its TTL has passed
+func (w *Workflow) expire(request *Request, now time.Time) {
+	if request.Status == StatusPending && !now.Before(request.ExpiresAt) {
+		request.Status = StatusExpired
+	}
+}
@@ -0,0 +1,107 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+func newTestWorkflow(clk clock.Clock) (*Workflow, *[]string) {
+	workflow := NewWorkflow(Options{TTL: time.Hour, Clock: clk, IDs: idgen.NewSequence("approval")})
+	executed := &[]string{}
+	workflow.Register(DeleteUser, func(ctx context.Context, target string) error {
+		if target == "broken" {
+			return errors.New("user not found")
+		}
+		*executed = append(*executed, target)
+		return nil
+	})
+	return workflow, executed
+}
+
+func TestWorkflow_Approve(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	clk := clock.NewFake(start)
+	workflow, executed := newTestWorkflow(clk)
+
+	request, err := workflow.Submit(DeleteUser, "1", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, Request{
+		ID:          "approval-1",
+		Operation:   DeleteUser,
+		Target:      "1",
+		Status:      StatusPending,
+		RequestedBy: "alice",
+		RequestedAt: start,
+		ExpiresAt:   start.Add(time.Hour),
+	}, request)
+
+	// Submitting again returns the pending request
+	again, err := workflow.Submit(DeleteUser, "1", "carol")
+	require.NoError(t, err)
+	assert.Equal(t, request.ID, again.ID)
+
+	_, err = workflow.Approve(context.Background(), request.ID, "alice")
+	assert.ErrorIs(t, err, ErrSelfApproval)
+	assert.Empty(t, *executed)
+
+	clk.Advance(time.Minute)
+	approved, err := workflow.Approve(context.Background(), request.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, StatusApproved, approved.Status)
+	assert.Equal(t, "bob", approved.ApprovedBy)
+	assert.Equal(t, start.Add(time.Minute), *approved.DecidedAt)
+	assert.Equal(t, []string{"1"}, *executed)
+
+	_, err = workflow.Approve(context.Background(), request.ID, "carol")
+	assert.ErrorIs(t, err, ErrNotPending)
+	assert.Equal(t, []string{"1"}, *executed, "an approved request runs once")
+
+	_, err = workflow.Approve(context.Background(), "unknown", "bob")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestWorkflow_Expiry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	workflow, executed := newTestWorkflow(clk)
+
+	request, err := workflow.Submit(DeleteUser, "1", "alice")
+	require.NoError(t, err)
+	clk.Advance(time.Hour)
+
+	expired, err := workflow.Approve(context.Background(), request.ID, "bob")
+	assert.ErrorIs(t, err, ErrNotPending)
+	assert.Equal(t, StatusExpired, expired.Status)
+	assert.Empty(t, *executed)
+
+	// A new request can be made once the old one has expired
+	renewed, err := workflow.Submit(DeleteUser, "1", "alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, request.ID, renewed.ID)
+
+	requests := workflow.List()
+	require.Len(t, requests, 2)
+	assert.Equal(t, renewed.ID, requests[0].ID)
+	assert.Equal(t, StatusExpired, requests[1].Status)
+}
+
+func TestWorkflow_FailedOperation(t *testing.T) {
+	workflow, _ := newTestWorkflow(clock.Real())
+
+	_, err := workflow.Submit("user.purge", "1", "alice")
+	assert.Error(t, err, "operations must be registered")
+
+	request, err := workflow.Submit(DeleteUser, "broken", "alice")
+	require.NoError(t, err)
+	failed, err := workflow.Approve(context.Background(), request.ID, "bob")
+	assert.EqualError(t, err, "user not found")
+	assert.Equal(t, StatusFailed, failed.Status)
+	assert.Equal(t, "user not found", failed.Error)
+}
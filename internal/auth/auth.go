@@ -0,0 +1,378 @@
+// Package auth logs users in with a password and authenticates requests
+// with the session token issued, a signed JWT tied to a session that can be
+// listed and revoked. Every login attempt is kept in a per-user history.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// RoleAdmin lets a user manage other users' sessions
+const RoleAdmin = "admin"
+
+// ErrInvalidCredentials is returned when a login fails. It does not say
+// whether the email or the password was wrong.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// ErrSelfImpersonation is returned when an admin tries to impersonate
+// themselves
+var ErrSelfImpersonation = errors.New("cannot impersonate yourself")
+
+// ErrSuspended is returned when a suspended user logs in
+var ErrSuspended = errors.New("account suspended")
+
+// Options configures a Service
+type Options struct {
+	// Secret signs session tokens
+	Secret []byte
+	// SessionTTL is how long a session lasts
+	SessionTTL time.Duration
+	// ImpersonationTTL is how long an impersonation session lasts
+	ImpersonationTTL time.Duration
+	// LoginHistory is the number of login attempts kept per user
+	LoginHistory int
+	// Admins are the IDs of users granted the admin role
+	Admins []int64
+	// Backends check the credentials of logins that local passwords do
+	// not accept, in order
+	Backends []Backend
+	// APIKeys authenticates bearer tokens that are API keys, when set
+	APIKeys KeyAuthenticator
+	// Suspensions refuses logins and sessions of suspended users, when set
+	Suspensions Suspensions
+	Clock       clock.Clock
+	IDs         idgen.Generator
+}
+
+// Suspensions says which users are suspended
+type Suspensions interface {
+	Suspended(userID int64) bool
+}
+
+// KeyAuthenticator authenticates requests made with an API key rather than
+// a session token
+type KeyAuthenticator interface {
+	Authenticate(secret string) (apikeys.Key, error)
+}
+
+// Service logs users in and authenticates their requests
+type Service struct {
+	users     store.UserStore
+	passwords *Passwords
+	sessions  *sessions
+	signer    signer
+	opts      Options
+}
+
+// NewService creates an authentication service for users
+func NewService(users store.UserStore, passwords *Passwords, opts Options) (*Service, error) {
+	if len(opts.Secret) < 32 {
+		return nil, errors.New("token secret must be at least 32 bytes")
+	}
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 24 * time.Hour
+	}
+	if opts.ImpersonationTTL <= 0 {
+		opts.ImpersonationTTL = 15 * time.Minute
+	}
+	if opts.LoginHistory <= 0 {
+		opts.LoginHistory = 50
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+
+	return &Service{
+		users:     users,
+		passwords: passwords,
+		sessions:  newSessions(opts.LoginHistory),
+		signer:    signer{secret: opts.Secret},
+		opts:      opts,
+	}, nil
+}
+
+// Close closes the backends that hold connections
+func (s *Service) Close() error {
+	var errs []error
+	for _, backend := range s.opts.Backends {
+		if closer, ok := backend.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Passwords returns the store of users' passwords
+func (s *Service) Passwords() *Passwords {
+	return s.passwords
+}
+
+// Login checks a user's password and starts a session, returning its token.
+// External backends are asked first, and local passwords only checked when
+// they all decline, for users none of them has vouched for. The attempt is
+// recorded in the user's login history either way.
+func (s *Service) Login(email, password string, r *http.Request) (string, Session, error) {
+	now := s.opts.Clock.Now().UTC()
+	event := LoginEvent{Time: now, IP: clientIP(r), UserAgent: r.UserAgent(), Outcome: OutcomeFailure}
+
+	user, err := s.users.GetByEmail(email)
+	if err != nil {
+		user = nil
+	}
+	external, roles, err := s.loginExternal(r.Context(), email, password, user)
+	switch {
+	case err == nil:
+		user = external
+	case !errors.Is(err, ErrInvalidCredentials):
+		if user != nil {
+			s.sessions.record(user.ID, event)
+		}
+		return "", Session{}, err
+	case user == nil:
+		// Hash anyway, so response times do not reveal which emails exist
+		s.passwords.Verify(0, password)
+		return "", Session{}, ErrInvalidCredentials
+	case !s.passwords.Verify(user.ID, password):
+		// Verify fails for external users, who have no local password
+		s.sessions.record(user.ID, event)
+		return "", Session{}, ErrInvalidCredentials
+	}
+	if s.suspended(user.ID) {
+		s.sessions.record(user.ID, event)
+		return "", Session{}, ErrSuspended
+	}
+
+	session := Session{
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.opts.SessionTTL),
+		IP:        event.IP,
+		UserAgent: event.UserAgent,
+		Roles:     roles,
+	}
+	token, session, err := s.start(session)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	event.Outcome = OutcomeSuccess
+	s.sessions.record(user.ID, event)
+	return token, session, nil
+}
+
+// Impersonate starts a short session as userID on behalf of an admin,
+// returning its token. The token carries the admin as its actor and a
+// banner claim, and the session shows up in the user's session list and
+// login history.
+func (s *Service) Impersonate(adminID, userID int64, r *http.Request) (string, Session, error) {
+	if adminID == userID {
+		return "", Session{}, ErrSelfImpersonation
+	}
+	if _, err := s.users.GetByID(userID); err != nil {
+		return "", Session{}, err
+	}
+
+	now := s.opts.Clock.Now().UTC()
+	session := Session{
+		UserID:         userID,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.opts.ImpersonationTTL),
+		IP:             clientIP(r),
+		UserAgent:      r.UserAgent(),
+		ImpersonatorID: adminID,
+	}
+	token, session, err := s.start(session)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	s.sessions.record(userID, LoginEvent{Time: now, IP: session.IP, UserAgent: session.UserAgent, Outcome: OutcomeImpersonated})
+	return token, session, nil
+}
+
+// start issues a token for a new session
+func (s *Service) start(session Session) (string, Session, error) {
+	session.ID = s.opts.IDs.NewID()
+	claims := Claims{
+		Subject:   strconv.FormatInt(session.UserID, 10),
+		SessionID: session.ID,
+		IssuedAt:  session.CreatedAt.Unix(),
+		ExpiresAt: session.ExpiresAt.Unix(),
+		Banner:    session.Banner(),
+	}
+	if session.Impersonated() {
+		claims.Actor = &Actor{Subject: strconv.FormatInt(session.ImpersonatorID, 10)}
+	}
+
+	token, err := s.signer.sign(claims)
+	if err != nil {
+		return "", Session{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	s.sessions.add(session)
+	return token, session, nil
+}
+
+// Authenticate returns the session of a valid token whose session has not
+// expired or been revoked
+func (s *Service) Authenticate(token string) (Session, error) {
+	now := s.opts.Clock.Now()
+	claims, err := s.signer.parse(token, now)
+	if err != nil {
+		return Session{}, err
+	}
+	session, ok := s.sessions.get(claims.SessionID, now)
+	if !ok || strconv.FormatInt(session.UserID, 10) != claims.Subject || session.Impersonated() != (claims.Actor != nil) {
+		return Session{}, ErrInvalidToken
+	}
+	return session, nil
+}
+
+// suspended reports whether a user is suspended
+func (s *Service) suspended(userID int64) bool {
+	return s.opts.Suspensions != nil && s.opts.Suspensions.Suspended(userID)
+}
+
+// Sessions returns a user's active sessions, newest first
+func (s *Service) Sessions(userID int64) []Session {
+	return s.sessions.list(userID, s.opts.Clock.Now())
+}
+
+// Revoke ends one of a user's sessions, or all of them when sessionID is
+// empty, returning how many were ended
+func (s *Service) Revoke(userID int64, sessionID string) int {
+	return s.sessions.revoke(userID, sessionID)
+}
+
+// Logins returns a user's recent login attempts, newest first
+func (s *Service) Logins(userID int64) []LoginEvent {
+	return s.sessions.logins(userID)
+}
+
+// Middleware authenticates requests carrying a bearer token, setting the
+// principal to the user ID. Requests without a token pass through
+// anonymously; those with an invalid token are rejected. API keys, when
+// enabled, are bearer tokens too, and their principal is "apikey:<id>" with
+// the key's roles.
+//
+// Impersonation sessions never get the admin role and cannot delete
+// anything, and every request made with one is written to the audit log.
+// Requests in sessions of suspended users are refused.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			writeAuthError(w, r, http.StatusUnauthorized, apierrors.InvalidToken, "Invalid or expired token")
+			return
+		}
+		if s.opts.APIKeys != nil && strings.HasPrefix(token, apikeys.SecretPrefix) {
+			key, err := s.opts.APIKeys.Authenticate(token)
+			if err != nil {
+				writeAuthError(w, r, http.StatusUnauthorized, apierrors.InvalidToken, "Invalid or expired token")
+				return
+			}
+			principal := reqctx.Principal{Subject: "apikey:" + key.ID, Roles: key.Roles}
+			next.ServeHTTP(w, r.WithContext(reqctx.WithPrincipal(r.Context(), principal)))
+			return
+		}
+		session, err := s.Authenticate(token)
+		if err != nil {
+			writeAuthError(w, r, http.StatusUnauthorized, apierrors.InvalidToken, "Invalid or expired token")
+			return
+		}
+		if s.suspended(session.UserID) {
+			writeAuthError(w, r, http.StatusForbidden, apierrors.AccountSuspended, "Account suspended")
+			return
+		}
+
+		principal := reqctx.Principal{Subject: strconv.FormatInt(session.UserID, 10)}
+		if session.Impersonated() {
+			logging.FromContext(r.Context(), logging.Auth).Info("Audit: impersonating",
+				"impersonator_id", session.ImpersonatorID, "user_id", session.UserID, "method", r.Method, "path", r.URL.Path, "session", session.ID)
+			if r.Method == http.MethodDelete {
+				writeAuthError(w, r, http.StatusForbidden, apierrors.Forbidden, "Not allowed while impersonating")
+				return
+			}
+		} else {
+			principal.Roles = slices.Clone(session.Roles)
+			if slices.Contains(s.opts.Admins, session.UserID) && !slices.Contains(principal.Roles, RoleAdmin) {
+				principal.Roles = append(principal.Roles, RoleAdmin)
+			}
+		}
+		ctx := reqctx.WithPrincipal(r.Context(), principal)
+		ctx = WithSession(ctx, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// sessionKey is the context key of the authenticated session
+type sessionKey struct{}
+
+// WithSession returns a context carrying the authenticated session
+func WithSession(ctx context.Context, session Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFrom returns the authenticated session, if any
+func SessionFrom(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(Session)
+	return session, ok
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authError is the body of the errors the middleware writes, shaped like
+// every other error response
+type authError struct {
+	Error   string         `json:"error"`
+	Code    apierrors.Code `json:"code"`
+	TraceID string         `json:"trace_id,omitempty"`
+}
+
+// writeAuthError refuses the request r with status, carrying its trace ID
+// when it is traced
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, code apierrors.Code, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	}
+	response := authError{Error: message, Code: code}
+	if trace, ok := reqctx.Trace(r.Context()); ok {
+		response.TraceID = trace.TraceID
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// logger logs logins and the users they provision
+var logger = logging.Named(logging.Auth)
+
+// Identity is a user as an external backend knows them
+type Identity struct {
+	Email string
+	Name  string
+	// Roles are granted to the user's sessions
+	Roles []string
+}
+
+// Backend checks credentials against an external identity store, such as
+// an LDAP directory
+type Backend interface {
+	// Name identifies the backend in logs
+	Name() string
+	// Authenticate returns the identity of the user logging in as login
+	// with password, or ErrInvalidCredentials
+	Authenticate(ctx context.Context, login, password string) (Identity, error)
+}
+
+// loginExternal tries each backend in turn, returning the local user of the
+// first to accept the credentials, created on their first login, and the
+// roles it grants. user is the local user with the login's email, if any.
+// From then on the user logs in only through the backends.
+func (s *Service) loginExternal(ctx context.Context, login, password string, user *store.User) (*store.User, []string, error) {
+	for _, backend := range s.opts.Backends {
+		identity, err := backend.Authenticate(ctx, login, password)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				logger.WarnContext(ctx, "Failed to authenticate", "login", login, "backend", backend.Name(), "error", err)
+			}
+			continue
+		}
+		if identity.Email == "" {
+			identity.Email = login
+		}
+		if identity.Name == "" {
+			identity.Name = identity.Email
+		}
+
+		if user == nil {
+			if user, err = s.users.GetByEmail(identity.Email); err != nil {
+				if user, err = s.users.Create(store.User{Name: identity.Name, Email: identity.Email}); err != nil {
+					return nil, nil, fmt.Errorf("failed to create user from %s: %w", backend.Name(), err)
+				}
+				logger.InfoContext(ctx, "Created user on their first login", "user_id", user.ID, "backend", backend.Name())
+			}
+		}
+		if user.Name != identity.Name {
+			updated := *user
+			updated.Name = identity.Name
+			if user, err = s.users.Update(user.ID, updated); err != nil {
+				return nil, nil, fmt.Errorf("failed to update user from %s: %w", backend.Name(), err)
+			}
+		}
+		s.passwords.SetExternal(user.ID)
+		return user, identity.Roles, nil
+	}
+	return nil, nil, ErrInvalidCredentials
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPOptions configures an LDAP backend
+type LDAPOptions struct {
+	// URL is the server, ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool
+	// CAFile is a PEM bundle of the CAs trusted to sign the server's
+	// certificate, instead of the system's
+	CAFile string
+	// InsecureSkipVerify accepts any server certificate; for testing only
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the service account that searches for
+	// users; empty binds anonymously
+	BindDN       string
+	BindPassword string
+	// BaseDN is where users are searched for
+	BaseDN string
+	// UserFilter finds a user by login, with %s replaced by the escaped
+	// login, e.g. (&(objectClass=person)(mail=%s))
+	UserFilter string
+	// EmailAttribute and NameAttribute are mapped to the user's email and
+	// name
+	EmailAttribute string
+	NameAttribute  string
+	// GroupAttribute lists the DNs of the user's groups, e.g. memberOf
+	GroupAttribute string
+	// Roles maps roles to the group DNs whose members are granted them
+	Roles map[string][]string
+	// PoolSize is the number of idle connections kept open
+	PoolSize int
+	// Timeout bounds connecting and each request
+	Timeout time.Duration
+}
+
+// ldapConn is the part of an LDAP connection used, so tests can fake it
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	IsClosing() bool
+	Close() error
+}
+
+// LDAP authenticates users against an LDAP directory or Active Directory.
+// It searches for the user with the service account, then binds as them
+// with their password, reusing connections from a pool.
+type LDAP struct {
+	opts LDAPOptions
+	dial func() (ldapConn, error)
+	pool chan ldapConn
+}
+
+// NewLDAP creates an LDAP backend
+func NewLDAP(opts LDAPOptions) (*LDAP, error) {
+	if opts.URL == "" || opts.BaseDN == "" {
+		return nil, errors.New("ldap url and base_dn are required")
+	}
+	if opts.UserFilter == "" {
+		opts.UserFilter = "(mail=%s)"
+	}
+	if !strings.Contains(opts.UserFilter, "%s") {
+		return nil, fmt.Errorf("ldap user_filter %q must contain %%s for the login", opts.UserFilter)
+	}
+	if opts.EmailAttribute == "" {
+		opts.EmailAttribute = "mail"
+	}
+	if opts.NameAttribute == "" {
+		opts.NameAttribute = "cn"
+	}
+	if opts.GroupAttribute == "" {
+		opts.GroupAttribute = "memberOf"
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	tlsConfig, err := ldapTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	l := &LDAP{opts: opts, pool: make(chan ldapConn, opts.PoolSize)}
+	l.dial = func() (ldapConn, error) {
+		conn, err := ldap.DialURL(opts.URL, ldap.DialWithTLSConfig(tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(opts.Timeout)
+		if opts.StartTLS {
+			if err := conn.StartTLS(tlsConfig); err != nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("failed to start TLS: %w", err)
+			}
+		}
+		return conn, nil
+	}
+	return l, nil
+}
+
+// ldapTLSConfig returns the TLS configuration for ldaps:// and StartTLS
+func ldapTLSConfig(opts LDAPOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CAFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(opts.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ldap ca_file: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ldap ca_file %s", opts.CAFile)
+	}
+	return config, nil
+}
+
+// Name identifies the backend in logs
+func (l *LDAP) Name() string {
+	return "ldap"
+}
+
+// Authenticate finds the user logging in as login and binds as them with
+// password, mapping their attributes and groups to an identity
+func (l *LDAP) Authenticate(ctx context.Context, login, password string) (Identity, error) {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if login == "" || password == "" {
+		return Identity{}, ErrInvalidCredentials
+	}
+	if err := ctx.Err(); err != nil {
+		return Identity{}, err
+	}
+
+	conn, err := l.conn()
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to connect to ldap: %w", err)
+	}
+	identity, err := l.authenticate(conn, login, password)
+	if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		// The connection may be broken, so do not reuse it
+		_ = conn.Close()
+		return Identity{}, err
+	}
+	l.release(conn)
+	return identity, err
+}
+
+func (l *LDAP) authenticate(conn ldapConn, login, password string) (Identity, error) {
+	if err := l.bindService(conn); err != nil {
+		return Identity{}, fmt.Errorf("failed to bind as the ldap service account: %w", err)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.opts.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(l.opts.Timeout.Seconds()), false,
+		fmt.Sprintf(l.opts.UserFilter, ldap.EscapeFilter(login)),
+		[]string{l.opts.EmailAttribute, l.opts.NameAttribute, l.opts.GroupAttribute},
+		nil,
+	))
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || (err == nil && len(result.Entries) > 1):
+		return Identity{}, fmt.Errorf("ldap user_filter matches more than one entry for %s", login)
+	case err != nil:
+		return Identity{}, fmt.Errorf("failed to search ldap: %w", err)
+	case len(result.Entries) == 0:
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Identity{}, ErrInvalidCredentials
+		}
+		return Identity{}, fmt.Errorf("failed to bind as %s: %w", entry.DN, err)
+	}
+
+	return Identity{
+		Email: entry.GetAttributeValue(l.opts.EmailAttribute),
+		Name:  entry.GetAttributeValue(l.opts.NameAttribute),
+		Roles: l.roles(entry.GetAttributeValues(l.opts.GroupAttribute)),
+	}, nil
+}
+
+// bindService binds as the service account, or anonymously without one
+func (l *LDAP) bindService(conn ldapConn) error {
+	if l.opts.BindDN == "" {
+		return conn.Bind("", "")
+	}
+	return conn.Bind(l.opts.BindDN, l.opts.BindPassword)
+}
+
+// roles returns the roles granted to members of groups, sorted
+func (l *LDAP) roles(groups []string) []string {
+	var roles []string
+	for role, roleGroups := range l.opts.Roles {
+		if slices.ContainsFunc(roleGroups, func(group string) bool {
+			return slices.ContainsFunc(groups, func(member string) bool { return strings.EqualFold(member, group) })
+		}) {
+			roles = append(roles, role)
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// conn returns an idle connection from the pool, or a new one
+func (l *LDAP) conn() (ldapConn, error) {
+	for {
+		select {
+		case conn := <-l.pool:
+			if !conn.IsClosing() {
+				return conn, nil
+			}
+		default:
+			return l.dial()
+		}
+	}
+}
+
+// release returns conn to the pool, closing it when the pool is full
+func (l *LDAP) release(conn ldapConn) {
+	select {
+	case l.pool <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+// Close closes the idle connections
+func (l *LDAP) Close() error {
+	for {
+		select {
+		case conn := <-l.pool:
+			_ = conn.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// fakeLDAP is a directory of entries with passwords, served over fake
+// connections
+type fakeLDAP struct {
+	entries   []*ldap.Entry
+	passwords map[string]string
+	// searchErr fails searches, e.g. as a dropped connection would
+	searchErr error
+	dials     int
+}
+
+func (f *fakeLDAP) dial() (ldapConn, error) {
+	f.dials++
+	return &fakeConn{directory: f}, nil
+}
+
+type fakeConn struct {
+	directory *fakeLDAP
+	closed    bool
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	if c.directory.passwords[username] != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (c *fakeConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.directory.searchErr != nil {
+		return nil, c.directory.searchErr
+	}
+	result := &ldap.SearchResult{}
+	for _, entry := range c.directory.entries {
+		if request.Filter == "(mail="+entry.GetAttributeValue("mail")+")" {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeConn) IsClosing() bool {
+	return c.closed
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestLDAP(t *testing.T) (*LDAP, *fakeLDAP) {
+	t.Helper()
+	directory := &fakeLDAP{
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=jane,ou=people,dc=example,dc=com", map[string][]string{
+				"mail":     {"jane@example.com"},
+				"cn":       {"Jane Roe"},
+				"memberOf": {"CN=Admins,OU=Groups,DC=example,DC=com", "cn=staff,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=sam,ou=people,dc=example,dc=com", map[string][]string{
+				"mail": {"sam@example.com"},
+				"cn":   {"Sam Poe"},
+			}),
+		},
+		passwords: map[string]string{
+			"cn=api,dc=example,dc=com":             "service",
+			"uid=jane,ou=people,dc=example,dc=com": "jane-password",
+			"uid=sam,ou=people,dc=example,dc=com":  "sam-password",
+		},
+	}
+	backend, err := NewLDAP(LDAPOptions{
+		URL:          "ldap://ldap.example.com",
+		BindDN:       "cn=api,dc=example,dc=com",
+		BindPassword: "service",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		Roles: map[string][]string{
+			RoleAdmin: {"cn=admins,ou=groups,dc=example,dc=com"},
+			"auditor": {"cn=auditors,ou=groups,dc=example,dc=com"},
+			"staff":   {"cn=staff,ou=groups,dc=example,dc=com"},
+		},
+		PoolSize: 1,
+	})
+	require.NoError(t, err)
+	backend.dial = directory.dial
+	return backend, directory
+}
+
+func TestLDAP_Authenticate(t *testing.T) {
+	backend, directory := newTestLDAP(t)
+	ctx := context.Background()
+
+	identity, err := backend.Authenticate(ctx, "jane@example.com", "jane-password")
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Email: "jane@example.com", Name: "Jane Roe", Roles: []string{RoleAdmin, "staff"}}, identity)
+
+	identity, err = backend.Authenticate(ctx, "sam@example.com", "sam-password")
+	require.NoError(t, err)
+	assert.Empty(t, identity.Roles)
+
+	for name, credentials := range map[string][2]string{
+		"wrong password":  {"jane@example.com", "sam-password"},
+		"empty password":  {"jane@example.com", ""},
+		"unknown user":    {"kim@example.com", "kim-password"},
+		"filter injected": {"*)(mail=*", "jane-password"},
+	} {
+		_, err := backend.Authenticate(ctx, credentials[0], credentials[1])
+		assert.ErrorIs(t, err, ErrInvalidCredentials, name)
+	}
+
+	// Connections are reused, except after errors
+	assert.Equal(t, 1, directory.dials)
+	directory.searchErr = ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))
+	_, err = backend.Authenticate(ctx, "jane@example.com", "jane-password")
+	assert.ErrorContains(t, err, "failed to search ldap")
+	directory.searchErr = nil
+	_, err = backend.Authenticate(ctx, "jane@example.com", "jane-password")
+	require.NoError(t, err)
+	assert.Equal(t, 2, directory.dials)
+
+	// A wrong service account password is a configuration error
+	directory.passwords["cn=api,dc=example,dc=com"] = "rotated"
+	_, err = backend.Authenticate(ctx, "jane@example.com", "jane-password")
+	assert.ErrorContains(t, err, "failed to bind as the ldap service account")
+	assert.NoError(t, backend.Close())
+}
+
+func TestNewLDAP_Invalid(t *testing.T) {
+	_, err := NewLDAP(LDAPOptions{URL: "ldap://ldap.example.com"})
+	assert.ErrorContains(t, err, "base_dn")
+	_, err = NewLDAP(LDAPOptions{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(mail=jane)"})
+	assert.ErrorContains(t, err, "must contain %s")
+	_, err = NewLDAP(LDAPOptions{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", CAFile: "missing.pem"})
+	assert.ErrorContains(t, err, "failed to read ldap ca_file")
+}
+
+func TestService_LoginWithBackend(t *testing.T) {
+	service := newTestService(t, nil)
+	backend, _ := newTestLDAP(t)
+	service.opts.Backends = []Backend{backend}
+
+	// Users the directory does not know log in with local passwords
+	_, session, err := service.Login("john@example.com", "password1", loginRequest())
+	require.NoError(t, err)
+	assert.Empty(t, session.Roles)
+	assert.False(t, service.passwords.External(session.UserID))
+
+	// A local password is only checked when the directory declines, and
+	// is dropped once the directory vouches for the user
+	sam, err := service.users.Create(store.User{Name: "Sam Poe", Email: "sam@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, service.passwords.Set(sam.ID, "local-password"))
+	_, _, err = service.Login("sam@example.com", "local-password", loginRequest())
+	require.NoError(t, err)
+	_, session, err = service.Login("sam@example.com", "sam-password", loginRequest())
+	require.NoError(t, err)
+	assert.Equal(t, sam.ID, session.UserID)
+	_, _, err = service.Login("sam@example.com", "local-password", loginRequest())
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.ErrorIs(t, service.passwords.Set(sam.ID, "local-password"), ErrExternalUser)
+
+	// Directory users are created on their first login, with their roles
+	token, session, err := service.Login("jane@example.com", "jane-password", loginRequest())
+	require.NoError(t, err)
+	assert.Equal(t, []string{RoleAdmin, "staff"}, session.Roles)
+	user, err := service.users.GetByEmail("jane@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Roe", user.Name)
+	assert.Equal(t, user.ID, session.UserID)
+	assert.True(t, service.passwords.External(user.ID))
+
+	var principal reqctx.Principal
+	handler := service.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = reqctx.PrincipalFrom(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/api/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, principal.HasRole(RoleAdmin))
+	assert.True(t, principal.HasRole("staff"))
+
+	// Logging in again reuses the user
+	_, again, err := service.Login("jane@example.com", "jane-password", loginRequest())
+	require.NoError(t, err)
+	assert.Equal(t, session.UserID, again.UserID)
+
+	_, _, err = service.Login("jane@example.com", "password1", loginRequest())
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, _, err = service.Login("kim@example.com", "kim-password", loginRequest())
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	logins := service.Logins(user.ID)
+	require.Len(t, logins, 3)
+	assert.Equal(t, OutcomeFailure, logins[0].Outcome)
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+var (
+	// ErrUnauthenticated is returned by Authorize when no one is logged in
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrNotOwner is returned by Authorize when the principal neither owns
+	// the resource nor is an admin
+	ErrNotOwner = errors.New("not the owner")
+)
+
+// Authorize checks the principal in ctx may change or delete a resource
+// owned by owner, the subject of the principal who owns it. Principals may
+// act on what they own and admins on anything.
+func Authorize(ctx context.Context, owner string) error {
+	principal, ok := reqctx.PrincipalFrom(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	if (owner == "" || principal.Subject != owner) && !principal.HasRole(RoleAdmin) {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// UserOwner returns the owner of the user with id: the user themselves
+func UserOwner(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"sync"
+)
+
+const (
+	// passwordIterations follows OWASP's recommendation for PBKDF2-SHA256
+	passwordIterations = 600000
+	passwordKeyLength  = 32
+	saltLength         = 16
+	// MinPasswordLength is the shortest password accepted
+	MinPasswordLength = 8
+)
+
+var (
+	// ErrWeakPassword is returned for passwords that are too short
+	ErrWeakPassword = errors.New("password must be at least 8 characters")
+	// ErrExternalUser is returned for setting the password of a user who
+	// logs in through an external backend
+	ErrExternalUser = errors.New("user logs in through an external identity provider")
+)
+
+// passwordHash is a salted PBKDF2 hash
+type passwordHash struct {
+	salt       []byte
+	key        []byte
+	iterations int
+}
+
+// Passwords holds users' password hashes in memory
+type Passwords struct {
+	mutex  sync.RWMutex
+	hashes map[int64]passwordHash
+	// external holds the users an external backend vouches for, who have
+	// no local password
+	external map[int64]bool
+	// iterations is lowered in tests to keep them fast
+	iterations int
+}
+
+// NewPasswords creates an empty password store
+func NewPasswords() *Passwords {
+	return &Passwords{hashes: make(map[int64]passwordHash), external: make(map[int64]bool), iterations: passwordIterations}
+}
+
+// Set replaces a user's password
+func (p *Passwords) Set(userID int64, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+
+	salt := make([]byte, saltLength)
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, p.iterations, passwordKeyLength)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.external[userID] {
+		return ErrExternalUser
+	}
+	p.hashes[userID] = passwordHash{salt: salt, key: key, iterations: p.iterations}
+	return nil
+}
+
+// SetExternal marks a user as logging in only through an external backend,
+// dropping any local password they had
+func (p *Passwords) SetExternal(userID int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.hashes, userID)
+	p.external[userID] = true
+}
+
+// External reports whether a user logs in only through an external backend
+func (p *Passwords) External(userID int64) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.external[userID]
+}
+
+// dummySalt is hashed against for users without a password, so that
+// checking them takes as long as checking everyone else
+var dummySalt = make([]byte, saltLength)
+
+// Verify reports whether password is the user's password. Users without a
+// password cannot log in.
+func (p *Passwords) Verify(userID int64, password string) bool {
+	p.mutex.RLock()
+	hash, ok := p.hashes[userID]
+	p.mutex.RUnlock()
+	if !ok {
+		_, _ = pbkdf2.Key(sha256.New, password, dummySalt, p.iterations, passwordKeyLength)
+		return false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, hash.salt, hash.iterations, passwordKeyLength)
+	return err == nil && subtle.ConstantTimeCompare(key, hash.key) == 1
+}
+
+// Has reports whether the user has set a password
+func (p *Passwords) Has(userID int64) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	_, ok := p.hashes[userID]
+	return ok
+}
+
+// Delete removes a user's password, and whether they are external
+func (p *Passwords) Delete(userID int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.hashes, userID)
+	delete(p.external, userID)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Session is a logged-in session of a user
+type Session struct {
+	ID        string    `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	UserID    int64     `json:"user_id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	ExpiresAt time.Time `json:"expires_at" example:"2024-01-03T15:04:05Z"`
+	IP        string    `json:"ip" example:"203.0.113.7"`
+	UserAgent string    `json:"user_agent" example:"curl/8.5.0"`
+	// ImpersonatorID is the admin acting as the user, if impersonated
+	ImpersonatorID int64 `json:"impersonator_id,omitempty" example:"2"`
+	// Roles are granted by the backend that accepted the login, such as
+	// roles mapped from LDAP groups
+	Roles []string `json:"roles,omitempty" example:"admin"`
+}
+
+// Impersonated reports whether an admin is acting as the user
+func (s Session) Impersonated() bool {
+	return s.ImpersonatorID != 0
+}
+
+// Banner is the notice put in the tokens of impersonated sessions
+func (s Session) Banner() string {
+	if !s.Impersonated() {
+		return ""
+	}
+	return fmt.Sprintf("Impersonated by user %d", s.ImpersonatorID)
+}
+
+// Login outcomes
+const (
+	OutcomeSuccess      = "success"
+	OutcomeFailure      = "failure"
+	OutcomeImpersonated = "impersonated"
+)
+
+// LoginEvent records an attempt to log in as a user
+type LoginEvent struct {
+	Time      time.Time `json:"time" example:"2024-01-02T15:04:05Z"`
+	IP        string    `json:"ip" example:"203.0.113.7"`
+	UserAgent string    `json:"user_agent" example:"curl/8.5.0"`
+	Outcome   string    `json:"outcome" example:"success"`
+}
+
+// sessions holds active sessions and login history in memory
+type sessions struct {
+	mutex    sync.Mutex
+	byID     map[string]Session
+	history  map[int64][]LoginEvent
+	retained int
+}
+
+func newSessions(retained int) *sessions {
+	return &sessions{
+		byID:     make(map[string]Session),
+		history:  make(map[int64][]LoginEvent),
+		retained: retained,
+	}
+}
+
+func (s *sessions) add(session Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.byID[session.ID] = session
+}
+
+// get returns a session that has not expired or been revoked
+func (s *sessions) get(id string, now time.Time) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.byID[id]
+	if !ok {
+		return Session{}, false
+	}
+	if !now.Before(session.ExpiresAt) {
+		delete(s.byID, id)
+		return Session{}, false
+	}
+	return session, true
+}
+
+// list returns a user's active sessions, newest first
+func (s *sessions) list(userID int64, now time.Time) []Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	active := make([]Session, 0)
+	for id, session := range s.byID {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.byID, id)
+			continue
+		}
+		if session.UserID == userID {
+			active = append(active, session)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].CreatedAt.After(active[j].CreatedAt) })
+	return active
+}
+
+// revoke ends one of a user's sessions, or all of them when id is empty,
+// and returns how many were ended
+func (s *sessions) revoke(userID int64, id string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	revoked := 0
+	for sessionID, session := range s.byID {
+		if session.UserID == userID && (id == "" || id == sessionID) {
+			delete(s.byID, sessionID)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// record adds a login event to a user's history, keeping the most recent
+func (s *sessions) record(userID int64, event LoginEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := append(s.history[userID], event)
+	if excess := len(events) - s.retained; excess > 0 {
+		events = append(events[:0:0], events[excess:]...)
+	}
+	s.history[userID] = events
+}
+
+// logins returns a user's login history, newest first
+func (s *sessions) logins(userID int64) []LoginEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := s.history[userID]
+	newestFirst := make([]LoginEvent, len(events))
+	for i, event := range events {
+		newestFirst[len(events)-1-i] = event
+	}
+	return newestFirst
+}
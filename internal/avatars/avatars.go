@@ -0,0 +1,202 @@
+// Package avatars turns uploaded images into users' avatars. Each image is
+// checked, resized to every configured size and re-encoded as WebP, which
+// drops EXIF and other metadata, then stored alongside uploads.
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // registers GIF decoding
+	_ "image/jpeg" // registers JPEG decoding
+	_ "image/png"  // registers PNG decoding
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// ContentType is the type avatars are served as
+const ContentType = "image/webp"
+
+var (
+	// ErrNotFound is returned for users without an avatar
+	ErrNotFound = errors.New("avatar not found")
+	// ErrUnknownSize is returned for sizes that are not configured
+	ErrUnknownSize = errors.New("unknown avatar size")
+	// ErrInvalidImage is returned for files that are not supported images
+	ErrInvalidImage = errors.New("not a PNG, JPEG, GIF or WebP image")
+	// ErrDimensions is returned for images too small or too large
+	ErrDimensions = errors.New("image dimensions are out of range")
+)
+
+// Options configures a Processor
+type Options struct {
+	// Sizes maps names, such as thumb, to the width and height in pixels
+	// avatars are scaled to fit within
+	Sizes map[string]int
+	// MinDimension and MaxDimension bound the width and height of images
+	// accepted
+	MinDimension int
+	MaxDimension int
+}
+
+// Variant is an avatar stored at one size
+type Variant struct {
+	Size   string `json:"size" example:"thumb"`
+	Width  int    `json:"width" example:"64"`
+	Height int    `json:"height" example:"64"`
+	Bytes  int    `json:"bytes" example:"2048"`
+}
+
+// Avatar is a user's avatar at each configured size
+type Avatar struct {
+	UserID   int64     `json:"user_id" example:"1"`
+	Variants []Variant `json:"variants"`
+}
+
+// Processor stores images as avatars in storage
+type Processor struct {
+	storage uploads.Storage
+	opts    Options
+}
+
+// NewProcessor creates a processor storing avatars in storage
+func NewProcessor(storage uploads.Storage, opts Options) *Processor {
+	if len(opts.Sizes) == 0 {
+		opts.Sizes = map[string]int{"thumb": 64, "medium": 256}
+	}
+	if opts.MinDimension <= 0 {
+		opts.MinDimension = 32
+	}
+	if opts.MaxDimension <= 0 {
+		opts.MaxDimension = 4096
+	}
+	return &Processor{storage: storage, opts: opts}
+}
+
+// Sizes returns the configured size names, smallest first
+func (p *Processor) Sizes() []string {
+	names := make([]string, 0, len(p.opts.Sizes))
+	for name := range p.opts.Sizes {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int { return p.opts.Sizes[a] - p.opts.Sizes[b] })
+	return names
+}
+
+// Process decodes data as an image and stores it as the avatar of userID
+// at every size, replacing any existing avatar
+func (p *Processor) Process(ctx context.Context, userID int64, data []byte) (Avatar, error) {
+	img, err := p.decode(data)
+	if err != nil {
+		return Avatar{}, err
+	}
+
+	// Variants are scaled to fit a square, so can be turned upright after
+	// scaling, which is cheaper
+	orientation := jpegOrientation(data)
+	avatar := Avatar{UserID: userID}
+	for _, size := range p.Sizes() {
+		variant := orient(fit(img, p.opts.Sizes[size]), orientation)
+
+		var buf bytes.Buffer
+		if err := nativewebp.Encode(&buf, variant, nil); err != nil {
+			return Avatar{}, fmt.Errorf("failed to encode %s avatar: %w", size, err)
+		}
+		length := buf.Len()
+		if err := p.storage.Put(ctx, key(userID, size), ContentType, &buf, int64(length)); err != nil {
+			return Avatar{}, fmt.Errorf("failed to store %s avatar: %w", size, err)
+		}
+		bounds := variant.Bounds()
+		avatar.Variants = append(avatar.Variants, Variant{Size: size, Width: bounds.Dx(), Height: bounds.Dy(), Bytes: length})
+	}
+	return avatar, nil
+}
+
+// decode checks the dimensions of the image in data before decoding it, so
+// huge images are rejected without being allocated
+func (p *Processor) decode(data []byte) (image.Image, error) {
+	decodeConfig, decode := image.DecodeConfig, image.Decode
+	if isWebP(data) {
+		// Decoded with x/image, which reads lossy WebP too
+		decodeConfig = func(r io.Reader) (image.Config, string, error) {
+			config, err := webp.DecodeConfig(r)
+			return config, "webp", err
+		}
+		decode = func(r io.Reader) (image.Image, string, error) {
+			img, err := webp.Decode(r)
+			return img, "webp", err
+		}
+	}
+
+	config, _, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if min(config.Width, config.Height) < p.opts.MinDimension || max(config.Width, config.Height) > p.opts.MaxDimension {
+		return nil, fmt.Errorf("%w: %dx%d is not between %d and %d pixels a side",
+			ErrDimensions, config.Width, config.Height, p.opts.MinDimension, p.opts.MaxDimension)
+	}
+	img, _, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, nil
+}
+
+// Open opens the avatar of userID at size
+func (p *Processor) Open(ctx context.Context, userID int64, size string) (io.ReadCloser, error) {
+	if _, ok := p.opts.Sizes[size]; !ok {
+		return nil, ErrUnknownSize
+	}
+	file, err := p.storage.Open(ctx, key(userID, size))
+	if errors.Is(err, uploads.ErrNotUploaded) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete deletes the avatar of userID at every size
+func (p *Processor) Delete(ctx context.Context, userID int64) error {
+	for size := range p.opts.Sizes {
+		if err := p.storage.Delete(ctx, key(userID, size)); err != nil {
+			return fmt.Errorf("failed to delete %s avatar: %w", size, err)
+		}
+	}
+	return nil
+}
+
+// key returns where the avatar of userID at size is stored
+func key(userID int64, size string) string {
+	return "avatars/" + strconv.FormatInt(userID, 10) + "/" + size + ".webp"
+}
+
+// isWebP reports whether data starts with a WebP header
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// fit scales img down to fit within size by size pixels, keeping its
+// aspect ratio; smaller images are kept at their size
+func fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,172 @@
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// testImage returns a w by h image, red in its top left pixel and blue
+// elsewhere, so tests can tell which way up it is
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.NRGBA{B: 255, A: 255})
+		}
+	}
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// withOrientation returns a JPEG of img with an EXIF orientation
+func withOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+
+	var exif bytes.Buffer
+	exif.WriteString("Exif\x00\x00MM\x00\x2a")
+	for _, field := range []any{
+		uint32(8), // first IFD
+		uint16(1), // entries
+		uint16(exifOrientationTag), uint16(3), uint32(1), orientation, uint16(0),
+		uint32(0), // no next IFD
+	} {
+		require.NoError(t, binary.Write(&exif, binary.BigEndian, field))
+	}
+	segment := append([]byte{0xFF, 0xE1, 0, 0}, exif.Bytes()...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(exif.Len()+2))
+
+	data := buf.Bytes()
+	return append(append([]byte{0xFF, 0xD8}, segment...), data[2:]...)
+}
+
+func newTestProcessor(t *testing.T) (*Processor, *uploads.LocalStorage) {
+	t.Helper()
+	storage, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+	require.NoError(t, err)
+	return NewProcessor(storage, Options{Sizes: map[string]int{"thumb": 16, "medium": 48}, MinDimension: 8, MaxDimension: 200}), storage
+}
+
+// open decodes the stored avatar of user 1 at size
+func open(t *testing.T, processor *Processor, size string) image.Image {
+	t.Helper()
+	file, err := processor.Open(context.Background(), 1, size)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	img, err := nativewebp.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	return img
+}
+
+func TestProcessor_Process(t *testing.T) {
+	processor, _ := newTestProcessor(t)
+	ctx := context.Background()
+
+	avatar, err := processor.Process(ctx, 1, encodePNG(t, testImage(100, 50)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), avatar.UserID)
+	require.Len(t, avatar.Variants, 2)
+	assert.Equal(t, Variant{Size: "thumb", Width: 16, Height: 8, Bytes: avatar.Variants[0].Bytes}, avatar.Variants[0])
+	assert.Equal(t, Variant{Size: "medium", Width: 48, Height: 24, Bytes: avatar.Variants[1].Bytes}, avatar.Variants[1])
+	assert.Equal(t, image.Rect(0, 0, 48, 24), open(t, processor, "medium").Bounds())
+
+	// Small images are not scaled up
+	avatar, err = processor.Process(ctx, 1, encodePNG(t, testImage(12, 20)))
+	require.NoError(t, err)
+	assert.Equal(t, 12, avatar.Variants[1].Width)
+	assert.Equal(t, 20, avatar.Variants[1].Height)
+
+	_, err = processor.Open(ctx, 1, "huge")
+	assert.ErrorIs(t, err, ErrUnknownSize)
+	_, err = processor.Open(ctx, 2, "thumb")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, processor.Delete(ctx, 1))
+	_, err = processor.Open(ctx, 1, "thumb")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestProcessor_Invalid(t *testing.T) {
+	processor, _ := newTestProcessor(t)
+	ctx := context.Background()
+
+	_, err := processor.Process(ctx, 1, []byte("not an image"))
+	assert.ErrorIs(t, err, ErrInvalidImage)
+	_, err = processor.Process(ctx, 1, encodePNG(t, testImage(100, 4)))
+	assert.ErrorIs(t, err, ErrDimensions)
+	_, err = processor.Process(ctx, 1, encodePNG(t, testImage(201, 100)))
+	assert.ErrorIs(t, err, ErrDimensions)
+	_, err = processor.Process(ctx, 1, encodePNG(t, testImage(100, 100))[:100])
+	assert.ErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestProcessor_WebP(t *testing.T) {
+	processor, _ := newTestProcessor(t)
+	var buf bytes.Buffer
+	require.NoError(t, nativewebp.Encode(&buf, testImage(40, 40), nil))
+
+	avatar, err := processor.Process(context.Background(), 1, buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, 40, avatar.Variants[1].Width)
+}
+
+func TestProcessor_Orientation(t *testing.T) {
+	// Where the red top left pixel of a 20 by 10 image ends up once each
+	// orientation is applied, in the upright 20 by 10 or 10 by 20 result
+	tests := []struct {
+		orientation uint16
+		size        image.Point
+		red         image.Point
+	}{
+		{1, image.Pt(20, 10), image.Pt(0, 0)},
+		{2, image.Pt(20, 10), image.Pt(19, 0)},
+		{3, image.Pt(20, 10), image.Pt(19, 9)},
+		{4, image.Pt(20, 10), image.Pt(0, 9)},
+		{5, image.Pt(10, 20), image.Pt(0, 0)},
+		{6, image.Pt(10, 20), image.Pt(9, 0)},
+		{7, image.Pt(10, 20), image.Pt(9, 19)},
+		{8, image.Pt(10, 20), image.Pt(0, 19)},
+	}
+	for _, tt := range tests {
+		data := withOrientation(t, testImage(20, 10), tt.orientation)
+		assert.Equal(t, int(tt.orientation), jpegOrientation(data))
+
+		img := orient(testImage(20, 10), int(tt.orientation))
+		assert.Equal(t, tt.size, img.Bounds().Size(), "orientation %d", tt.orientation)
+		r, _, _, _ := img.At(tt.red.X, tt.red.Y).RGBA()
+		assert.Equal(t, uint32(0xFFFF), r, "orientation %d", tt.orientation)
+	}
+
+	// Processing applies the orientation, then drops it with the rest of
+	// the EXIF data
+	processor, _ := newTestProcessor(t)
+	avatar, err := processor.Process(context.Background(), 1, withOrientation(t, testImage(40, 20), 6))
+	require.NoError(t, err)
+	assert.Equal(t, Variant{Size: "medium", Width: 20, Height: 40, Bytes: avatar.Variants[1].Bytes}, avatar.Variants[1])
+
+	assert.Equal(t, 1, jpegOrientation(encodePNG(t, testImage(8, 8))))
+	assert.Equal(t, 1, jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}), "truncated segments are ignored")
+}
@@ -0,0 +1,219 @@
+// Package backup exports the state of the application into a single
+// versioned archive and restores it, so a whole environment can be cloned:
+// users, API keys with the hashes of their secrets, webhook subscriptions
+// with their secrets, tenants with their feature flags, and the rule audit
+// log. Archives hold credentials and must be kept like them.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/tenants"
+	"github.com/dazraf/go-api-example/internal/webhooks"
+)
+
+const (
+	// Version is the schema version of the archives written
+	Version = 1
+	// MinVersion is the oldest schema version that can be restored. Older
+	// archives are upgraded to Version when they are decoded.
+	MinVersion = 1
+)
+
+// Sections of an archive
+const (
+	SectionUsers    = "users"
+	SectionAPIKeys  = "api_keys"
+	SectionWebhooks = "webhooks"
+	SectionTenants  = "tenants"
+	SectionAudit    = "audit"
+)
+
+var (
+	// ErrUnsupportedVersion is returned for archives of a schema version
+	// this server cannot restore
+	ErrUnsupportedVersion = errors.New("unsupported archive version")
+	// ErrInvalidArchive is returned for archives that are not well formed,
+	// or hold items the stores reject
+	ErrInvalidArchive = errors.New("invalid archive")
+)
+
+// Archive is the state of an application. Sections lists what it holds;
+// components that were not enabled are left out.
+type Archive struct {
+	Version   int               `json:"version" example:"1"`
+	CreatedAt time.Time         `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	Sections  []string          `json:"sections" example:"users,api_keys,webhooks,tenants,audit"`
+	Users     []store.User      `json:"users,omitempty"`
+	APIKeys   []apikeys.Record  `json:"api_keys,omitempty"`
+	Webhooks  []webhooks.Record `json:"webhooks,omitempty"`
+	Tenants   []tenants.Tenant  `json:"tenants,omitempty"`
+	// Audit is the rule audit log, newest first
+	Audit []rules.Entry `json:"audit,omitempty"`
+}
+
+// Report is the outcome of restoring an archive
+type Report struct {
+	// Version is the schema version of the archive
+	Version int `json:"version" example:"1"`
+	// Restored counts the items restored in each section
+	Restored map[string]int `json:"restored"`
+	// Skipped lists the sections of the archive whose component is not
+	// enabled here
+	Skipped []string `json:"skipped" example:"webhooks"`
+}
+
+// Options holds the components backed up. Those left nil are not enabled,
+// and their sections are left out of archives and skipped on restore.
+type Options struct {
+	Users    store.UserStore
+	APIKeys  *apikeys.Store
+	Webhooks *webhooks.Store
+	Tenants  *tenants.Store
+	Rules    *rules.Engine
+	Clock    clock.Clock
+}
+
+// Archiver exports and restores archives
+type Archiver struct {
+	opts Options
+}
+
+// New creates an archiver of the components in opts
+func New(opts Options) *Archiver {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Archiver{opts: opts}
+}
+
+// Export returns an archive of the current state
+func (a *Archiver) Export(ctx context.Context) (Archive, error) {
+	archive := Archive{Version: Version, CreatedAt: a.opts.Clock.Now().UTC(), Sections: []string{SectionUsers}}
+	users, err := a.opts.Users.GetAll()
+	if err != nil {
+		return Archive{}, err
+	}
+	archive.Users = users
+	if err := ctx.Err(); err != nil {
+		return Archive{}, err
+	}
+
+	if a.opts.APIKeys != nil {
+		archive.Sections = append(archive.Sections, SectionAPIKeys)
+		archive.APIKeys = a.opts.APIKeys.Export()
+	}
+	if a.opts.Webhooks != nil {
+		archive.Sections = append(archive.Sections, SectionWebhooks)
+		archive.Webhooks = a.opts.Webhooks.Export()
+	}
+	if a.opts.Tenants != nil {
+		archive.Sections = append(archive.Sections, SectionTenants)
+		archive.Tenants = a.opts.Tenants.Export()
+	}
+	if a.opts.Rules != nil {
+		archive.Sections = append(archive.Sections, SectionAudit)
+		archive.Audit = a.opts.Rules.Audit(0, 0)
+	}
+	return archive, nil
+}
+
+// Decode reads an archive, checking its schema version before anything
+// else, as other versions may not decode as this one
+func Decode(r io.Reader) (Archive, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Archive{}, err
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return Archive{}, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	if header.Version == 0 {
+		return Archive{}, fmt.Errorf("%w: no version", ErrInvalidArchive)
+	}
+	if header.Version < MinVersion || header.Version > Version {
+		return Archive{}, fmt.Errorf("%w: archive has version %d, this server restores versions %d to %d", ErrUnsupportedVersion, header.Version, MinVersion, Version)
+	}
+
+	var archive Archive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return Archive{}, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	for _, section := range archive.Sections {
+		if !slices.Contains([]string{SectionUsers, SectionAPIKeys, SectionWebhooks, SectionTenants, SectionAudit}, section) {
+			return Archive{}, fmt.Errorf("%w: unknown section %q", ErrInvalidArchive, section)
+		}
+	}
+	return archive, nil
+}
+
+// Restore restores the sections of archive whose components are enabled.
+// Items are matched by ID: those in the archive replace those with the
+// same IDs, keeping their timestamps, and others are left alone. Each
+// section other than users is restored entirely or not at all, and users
+// are restored last, so a failure among them leaves the others restored.
+func (a *Archiver) Restore(ctx context.Context, archive Archive) (Report, error) {
+	report := Report{Version: archive.Version, Restored: make(map[string]int), Skipped: []string{}}
+	has := func(section string, enabled bool) bool {
+		if !slices.Contains(archive.Sections, section) {
+			return false
+		}
+		if !enabled {
+			report.Skipped = append(report.Skipped, section)
+		}
+		return enabled
+	}
+
+	if has(SectionTenants, a.opts.Tenants != nil) {
+		if err := a.opts.Tenants.Import(archive.Tenants); err != nil {
+			return report, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		report.Restored[SectionTenants] = len(archive.Tenants)
+	}
+	if has(SectionAPIKeys, a.opts.APIKeys != nil) {
+		if err := a.opts.APIKeys.Import(archive.APIKeys); err != nil {
+			return report, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		report.Restored[SectionAPIKeys] = len(archive.APIKeys)
+	}
+	if has(SectionWebhooks, a.opts.Webhooks != nil) {
+		if err := a.opts.Webhooks.Import(archive.Webhooks); err != nil {
+			return report, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		report.Restored[SectionWebhooks] = len(archive.Webhooks)
+	}
+	if has(SectionAudit, a.opts.Rules != nil) {
+		a.opts.Rules.ImportAudit(archive.Audit)
+		report.Restored[SectionAudit] = len(archive.Audit)
+	}
+
+	if has(SectionUsers, true) {
+		replacer, ok := a.opts.Users.(store.Replacer)
+		if !ok {
+			return report, errors.New("the user store cannot restore users with their IDs")
+		}
+		for _, user := range archive.Users {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			if _, err := replacer.Replace(user); err != nil {
+				return report, fmt.Errorf("failed to restore user %d: %w", user.ID, err)
+			}
+			report.Restored[SectionUsers]++
+		}
+	}
+	return report, nil
+}
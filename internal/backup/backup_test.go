@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/tenants"
+	"github.com/dazraf/go-api-example/internal/webhooks"
+)
+
+// environment is every component an archive covers
+func environment(t *testing.T) Options {
+	engine, err := rules.New([]rules.Rule{{Name: "vip", Actions: []rules.Action{{Type: rules.ActionTag, Tag: "vip"}}}}, rules.Options{})
+	require.NoError(t, err)
+	return Options{
+		Users:    store.NewMemoryUserStore(),
+		APIKeys:  apikeys.NewStore(apikeys.Options{}),
+		Webhooks: webhooks.NewStore(webhooks.Options{}),
+		Tenants:  tenants.NewStore(tenants.Options{}),
+		Rules:    engine,
+		Clock:    clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)),
+	}
+}
+
+func TestArchiver_ExportRestore(t *testing.T) {
+	source := environment(t)
+	john, err := source.Users.Create(store.User{Name: "John Doe", Email: "john@example.com"})
+	require.NoError(t, err)
+	_, secret, err := source.APIKeys.Create(apikeys.Spec{Name: "ci-deployer", Roles: []string{"admin"}})
+	require.NoError(t, err)
+	_, err = source.Webhooks.Create(webhooks.Spec{Name: "crm", URL: "https://crm.example.com/hooks", Events: []string{notify.EventUserCreated}, Enabled: true, Secret: "s3cret"})
+	require.NoError(t, err)
+	_, err = source.Tenants.Put("acme", tenants.Settings{Features: map[string]bool{"beta": true}, Webhooks: []tenants.Webhook{{URL: "https://acme.example.com/hooks", Events: []string{notify.EventUserCreated}, Secret: "acme"}}})
+	require.NoError(t, err)
+	source.Rules.UserCreated(context.Background(), *john)
+
+	archive, err := New(source).Export(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Version, archive.Version)
+	assert.Equal(t, []string{SectionUsers, SectionAPIKeys, SectionWebhooks, SectionTenants, SectionAudit}, archive.Sections)
+
+	// Archives are cloned through their JSON form
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(archive))
+	assert.NotContains(t, buf.String(), secret)
+	decoded, err := Decode(&buf)
+	require.NoError(t, err)
+
+	target := environment(t)
+	report, err := New(target).Restore(context.Background(), decoded)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Version: Version, Restored: map[string]int{SectionUsers: 1, SectionAPIKeys: 1, SectionWebhooks: 1, SectionTenants: 1, SectionAudit: 1}, Skipped: []string{}}, report)
+
+	user, err := target.Users.GetByID(john.ID)
+	require.NoError(t, err)
+	assert.Equal(t, john.Email, user.Email)
+	assert.True(t, user.UpdatedAt.Equal(john.UpdatedAt))
+	_, err = target.APIKeys.Authenticate(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, source.Webhooks.Export(), target.Webhooks.Export())
+	assert.Equal(t, source.Tenants.Export(), target.Tenants.Export())
+	assert.True(t, target.Tenants.Resolve("acme").Feature("beta"))
+	assert.Len(t, target.Rules.Audit(0, 0), 1)
+
+	// Restoring again replaces rather than duplicates
+	_, err = New(target).Restore(context.Background(), decoded)
+	require.NoError(t, err)
+	count, err := target.Users.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, target.Rules.Audit(0, 0), 1)
+	assert.Len(t, target.APIKeys.List(""), 1)
+}
+
+func TestArchiver_RestoreSkipsDisabledComponents(t *testing.T) {
+	source := environment(t)
+	archive, err := New(source).Export(context.Background())
+	require.NoError(t, err)
+
+	report, err := New(Options{Users: store.NewMemoryUserStore()}).Restore(context.Background(), archive)
+	require.NoError(t, err)
+	assert.Equal(t, []string{SectionTenants, SectionAPIKeys, SectionWebhooks, SectionAudit}, report.Skipped)
+
+	// Components that were not enabled are left out of archives
+	archive, err = New(Options{Users: store.NewMemoryUserStore()}).Export(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{SectionUsers}, archive.Sections)
+}
+
+func TestArchiver_RestoreRejectsInvalidItems(t *testing.T) {
+	target := environment(t)
+	archive := Archive{
+		Version:  Version,
+		Sections: []string{SectionUsers, SectionWebhooks},
+		Users:    []store.User{{ID: 1, Name: "John Doe", Email: "john@example.com"}},
+		Webhooks: []webhooks.Record{{Webhook: webhooks.Webhook{ID: "1", Name: "crm", URL: "crm"}}},
+	}
+	_, err := New(target).Restore(context.Background(), archive)
+	assert.ErrorIs(t, err, ErrInvalidArchive)
+	count, err := target.Users.Count()
+	require.NoError(t, err)
+	assert.Zero(t, count, "users are restored last")
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		wantErr error
+	}{
+		{name: "current", archive: `{"version":1,"sections":["users"],"users":[{"id":1,"name":"John Doe","email":"john@example.com"}]}`},
+		{name: "newer", archive: `{"version":2,"users":"a format this server does not know"}`, wantErr: ErrUnsupportedVersion},
+		{name: "no version", archive: `{"users":[]}`, wantErr: ErrInvalidArchive},
+		{name: "not JSON", archive: `users`, wantErr: ErrInvalidArchive},
+		{name: "unknown section", archive: `{"version":1,"sections":["orgs"]}`, wantErr: ErrInvalidArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := Decode(strings.NewReader(tt.archive))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, archive.Users, 1)
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	fake := NewFake(start)
+	assert.Equal(t, start, fake.Now())
+	assert.Equal(t, start, fake.Now(), "a fake clock does not move by itself")
+
+	fake.Advance(90 * time.Second)
+	assert.Equal(t, start.Add(90*time.Second), fake.Now())
+
+	fake.Set(start)
+	assert.Equal(t, start, fake.Now())
+}
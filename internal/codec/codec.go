@@ -0,0 +1,28 @@
+// Package codec selects the JSON implementation used for API responses.
+//
+// encoding/json is the default. Building with -tags=jsoniter or
+// -tags="sonic avx" (amd64 only) swaps in a faster codec; the tags match the
+// ones gin uses, so request binding and rendering switch along with it.
+package codec
+
+import "io"
+
+// Encoder writes JSON values to an output stream
+type Encoder interface {
+	Encode(v any) error
+}
+
+// Decoder reads JSON values from an input stream
+type Decoder interface {
+	Decode(v any) error
+}
+
+// NewEncoder returns an encoder writing to w using the selected codec
+func NewEncoder(w io.Writer) Encoder {
+	return newEncoder(w)
+}
+
+// NewDecoder returns a decoder reading from r using the selected codec
+func NewDecoder(r io.Reader) Decoder {
+	return newDecoder(r)
+}
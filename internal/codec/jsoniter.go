@@ -0,0 +1,29 @@
+//go:build jsoniter
+
+package codec
+
+import (
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Name identifies the JSON codec compiled into the binary
+const Name = "jsoniter"
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var (
+	// Marshal encodes v using the selected codec
+	Marshal = json.Marshal
+	// Unmarshal decodes data into v using the selected codec
+	Unmarshal = json.Unmarshal
+)
+
+func newEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func newDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
@@ -0,0 +1,29 @@
+//go:build !jsoniter && sonic && avx && (linux || windows || darwin) && amd64
+
+package codec
+
+import (
+	"io"
+
+	"github.com/bytedance/sonic"
+)
+
+// Name identifies the JSON codec compiled into the binary
+const Name = "sonic"
+
+var json = sonic.ConfigStd
+
+var (
+	// Marshal encodes v using the selected codec
+	Marshal = json.Marshal
+	// Unmarshal decodes data into v using the selected codec
+	Unmarshal = json.Unmarshal
+)
+
+func newEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func newDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
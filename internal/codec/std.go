@@ -0,0 +1,26 @@
+//go:build !jsoniter && !(sonic && avx && (linux || windows || darwin) && amd64)
+
+package codec
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Name identifies the JSON codec compiled into the binary
+const Name = "encoding/json"
+
+var (
+	// Marshal encodes v using the selected codec
+	Marshal = json.Marshal
+	// Unmarshal decodes data into v using the selected codec
+	Unmarshal = json.Unmarshal
+)
+
+func newEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func newDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
@@ -1,106 +1,1421 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Environment string   `yaml:"environment"`
-	Server      Server   `yaml:"server"`
-	Database    Database `yaml:"database"`
-	Logging     Logging  `yaml:"logging"`
+	// Profile is the config profile that was applied when loading
+	Profile string `yaml:"-"`
+	// Source is the config file that was loaded, if any
+	Source        string        `yaml:"-"`
+	Environment   string        `yaml:"environment"`
+	Server        Server        `yaml:"server"`
+	Database      Database      `yaml:"database"`
+	Logging       Logging       `yaml:"logging"`
+	Routes        Routes        `yaml:"routes"`
+	Middleware    Middleware    `yaml:"middleware"`
+	Startup       Startup       `yaml:"startup"`
+	Remote        Remote        `yaml:"remote"`
+	Discovery     Discovery     `yaml:"discovery"`
+	Mailer        Mailer        `yaml:"mailer"`
+	Notifications Notifications `yaml:"notifications"`
+	Auth          Auth          `yaml:"auth"`
+	Approvals     Approvals     `yaml:"approvals"`
+	Preconditions Preconditions `yaml:"preconditions"`
+	Views         Views         `yaml:"views"`
+	Materialized  Materialized  `yaml:"materialized"`
+	Webhooks      Webhooks      `yaml:"webhooks"`
+	Orgs          Orgs          `yaml:"orgs"`
+	Preferences   Preferences   `yaml:"preferences"`
+	Tenants       Tenants       `yaml:"tenants"`
+	Usage         Usage         `yaml:"usage"`
+	Plugins       Plugins       `yaml:"plugins"`
+	Scripts       Scripts       `yaml:"scripts"`
+	Rules         Rules         `yaml:"rules"`
+	Transforms    Transforms    `yaml:"transforms"`
+	Consent       Consent       `yaml:"consent"`
+	Operations    Operations    `yaml:"operations"`
+	Visibility    Visibility    `yaml:"visibility"`
+	Directory     Directory     `yaml:"directory"`
+	SCIM          SCIM          `yaml:"scim"`
+	Uploads       Uploads       `yaml:"uploads"`
+	Avatars       Avatars       `yaml:"avatars"`
+	Reports       Reports       `yaml:"reports"`
+	Anonymization Anonymization `yaml:"anonymization"`
+	Stats         Stats         `yaml:"stats"`
+	Backup        Backup        `yaml:"backup"`
+	Watchdog      Watchdog      `yaml:"watchdog"`
+	IDs           IDs           `yaml:"ids"`
+	Instances     Instances     `yaml:"instances"`
+
+	// remoteVersion is the version of the remote document that was merged
+	remoteVersion string
 }
 
 // Server holds server configuration
 type Server struct {
-	Address string `yaml:"address"`
-	Port    int    `yaml:"port"`
+	Address           string        `yaml:"address"`
+	Port              int           `yaml:"port"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	KeepAlives        bool          `yaml:"keep_alives"`
+	H2C               bool          `yaml:"h2c"`
+	// ShutdownTimeout bounds draining in-flight requests on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// LameDuck is how long to keep serving after /readyz starts failing
+	LameDuck time.Duration `yaml:"lame_duck"`
+	// TerminationGracePeriod bounds the whole shutdown, and should be below
+	// the orchestrator's, e.g. Kubernetes' terminationGracePeriodSeconds
+	TerminationGracePeriod time.Duration `yaml:"termination_grace_period"`
+	Router                 string        `yaml:"router"`
+	Gin                    Gin           `yaml:"gin"`
+}
+
+// Gin holds gin engine options, used when the router is gin
+type Gin struct {
+	UseRawPath         bool `yaml:"use_raw_path"`
+	UnescapePathValues bool `yaml:"unescape_path_values"`
 }
 
 // Database holds database configuration
 type Database struct {
-	Type     string `yaml:"type"`
+	// Type is memory, bolt to keep users in a local file, or mongo to keep
+	// them in MongoDB
+	Type        string      `yaml:"type"`
+	Host        string      `yaml:"host"`
+	Port        int         `yaml:"port"`
+	Name        string      `yaml:"name"`
+	User        string      `yaml:"user"`
+	Password    string      `yaml:"password"`
+	Bolt        Bolt        `yaml:"bolt"`
+	Mongo       Mongo       `yaml:"mongo"`
+	Journal     Journal     `yaml:"journal"`
+	CDC         CDC         `yaml:"cdc"`
+	Undo        Undo        `yaml:"undo"`
+	Replication Replication `yaml:"replication"`
+}
+
+// Bolt holds the file of the bolt store
+type Bolt struct {
+	Path string `yaml:"path"`
+	// Timeout bounds waiting for another process to release the file
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Mongo holds the MongoDB connection for the mongo store
+type Mongo struct {
+	// URI is the connection string; prefer MONGO_URI when it holds
+	// credentials
+	URI        string `yaml:"uri"`
+	Database   string `yaml:"database"`
+	Collection string `yaml:"collection"`
+	// Timeout bounds connecting and each operation
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Journal holds write-ahead journal configuration for the memory store
+type Journal struct {
+	Enabled         bool          `yaml:"enabled"`
+	Path            string        `yaml:"path"`
+	Fsync           string        `yaml:"fsync"`
+	FsyncInterval   time.Duration `yaml:"fsync_interval"`
+	CompactInterval time.Duration `yaml:"compact_interval"`
+}
+
+// CDC holds change data capture configuration
+type CDC struct {
+	Enabled    bool   `yaml:"enabled"`
+	OutboxPath string `yaml:"outbox_path"`
+	Retention  int    `yaml:"retention"`
+}
+
+// Undo holds configuration for restoring deleted users
+type Undo struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is how long a deleted user can be restored
+	Window time.Duration `yaml:"window"`
+	// PurgeInterval is how often users past the window are purged
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// Replication holds configuration for replicating users between instances
+type Replication struct {
+	Enabled bool `yaml:"enabled"`
+	// Node is the name of this instance, one of Nodes
+	Node string `yaml:"node"`
+	// Nodes are every instance, listed in the same order on each
+	Nodes []ReplicationNode `yaml:"nodes"`
+	// Secret authenticates the instances to each other
+	Secret         string        `yaml:"secret"`
+	QueueSize      int           `yaml:"queue_size"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	// ReconcileInterval is how often the instances pull each other's state
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+}
+
+// ReplicationNode is an instance taking part in replication
+type ReplicationNode struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// Routes holds toggles for optional route groups
+type Routes struct {
+	Swagger bool `yaml:"swagger"`
+	// DocsUI renders the OpenAPI document at /docs: redoc or rapidoc
+	DocsUI string `yaml:"docs_ui"`
+	Admin  bool   `yaml:"admin"`
+	// Debug serves net/http/pprof under /debug/pprof/
+	Debug bool `yaml:"debug"`
+	// OpenAPIBaseline is a committed OpenAPI document the served one is
+	// compared with at startup, failing on breaking changes; off when empty
+	OpenAPIBaseline string `yaml:"openapi_baseline"`
+}
+
+// Middleware holds toggles for optional middleware
+type Middleware struct {
+	AccessLog bool `yaml:"access_log"`
+	RequestID bool `yaml:"request_id"`
+	// Tracing propagates W3C traceparent and B3 headers
+	Tracing bool `yaml:"tracing"`
+	// RecordExamples merges real API traffic into the served OpenAPI
+	// document as examples; meant for development only
+	RecordExamples bool         `yaml:"record_examples"`
+	Activity       Activity     `yaml:"activity"`
+	Chaos          Chaos        `yaml:"chaos"`
+	Dedupe         Dedupe       `yaml:"dedupe"`
+	Metrics        Metrics      `yaml:"metrics"`
+	SlowRequests   SlowRequests `yaml:"slow_requests"`
+	RateLimit      RateLimit    `yaml:"rate_limit"`
+	Caching        Caching      `yaml:"caching"`
+}
+
+// Caching holds configuration for caching proxies, such as Fastly or
+// Varnish, in front of the API
+type Caching struct {
+	// Vary lists the request headers API responses depend on
+	Vary []string `yaml:"vary"`
+	// SurrogateKeys tags user responses with Surrogate-Key headers naming
+	// the users in them, and purges the keys as users change
+	SurrogateKeys bool `yaml:"surrogate_keys"`
+	CDN           CDN  `yaml:"cdn"`
+}
+
+// CDN holds configuration for purging a CDN's cached responses. Purges are
+// queued and sent in the background with retries.
+type CDN struct {
+	// Provider is log to only log purges, cloudflare or fastly
+	Provider   string        `yaml:"provider"`
+	Cloudflare CloudflareCDN `yaml:"cloudflare"`
+	Fastly     FastlyCDN     `yaml:"fastly"`
+	// QueueSize bounds purges waiting to be sent
+	QueueSize      int           `yaml:"queue_size"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+}
+
+// CloudflareCDN holds the Cloudflare zone purged
+type CloudflareCDN struct {
+	ZoneID string `yaml:"zone_id"`
+	// Token is an API token allowed to purge the zone; prefer
+	// CLOUDFLARE_API_TOKEN
+	Token string `yaml:"token"`
+}
+
+// FastlyCDN holds the Fastly service purged
+type FastlyCDN struct {
+	ServiceID string `yaml:"service_id"`
+	// Token is an API token allowed to purge the service; prefer
+	// FASTLY_API_TOKEN
+	Token string `yaml:"token"`
+	// Soft marks responses stale rather than removing them
+	Soft bool `yaml:"soft"`
+}
+
+// RateLimit holds configuration for limiting each caller's request rate.
+// Callers are counted against the default profile unless an admin chose
+// another for them, which needs preferences enabled.
+type RateLimit struct {
+	Enabled        bool                   `yaml:"enabled"`
+	DefaultProfile string                 `yaml:"default_profile"`
+	Profiles       map[string]RateProfile `yaml:"profiles"`
+	// Backend counts requests: memory in each replica, or redis shared by
+	// all of them
+	Backend string `yaml:"backend"`
+	Redis   Redis  `yaml:"redis"`
+	// OnFailure is what happens while the backend fails: local counts in
+	// memory, open allows requests and closed refuses them
+	OnFailure string `yaml:"on_failure"`
+	// RetryInterval is how long requests are counted locally before the
+	// backend is tried again
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// WarnAt is the share of their limit callers use before responses
+	// warn them with X-RateLimit-Warning, above 0 and at most 1
+	WarnAt float64 `yaml:"warn_at"`
+}
+
+// Redis holds a Redis server and the keys used in it
+type Redis struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// Prefix is prepended to the keys used
+	Prefix string `yaml:"prefix"`
+	// Timeout bounds each request to Redis, after which it has failed
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// RateProfile allows Requests in any Window
+type RateProfile struct {
+	Requests int           `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+}
+
+// Activity holds configuration for recording when users were last seen
+type Activity struct {
+	Enabled bool `yaml:"enabled"`
+	// FlushInterval is how often recorded activity is written to the store
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// Chaos holds fault injection configuration for API routes
+type Chaos struct {
+	Enabled   bool          `yaml:"enabled"`
+	Latency   time.Duration `yaml:"latency"`
+	ErrorRate float64       `yaml:"error_rate"`
+}
+
+// Dedupe holds configuration for treating identical requests from the same
+// caller as one
+type Dedupe struct {
+	// Routes maps routes, as "METHOD /path" with the router's path pattern,
+	// to the window in which identical requests are duplicates
+	Routes map[string]time.Duration `yaml:"routes"`
+}
+
+// Metrics holds configuration for RED metrics of API routes
+type Metrics struct {
+	Enabled bool `yaml:"enabled"`
+	// Sink is where metrics go: prometheus, served at Path for scraping,
+	// or statsd, sent to a StatsD or DogStatsD agent
+	Sink string `yaml:"sink"`
+	Path string `yaml:"path"`
+	// Buckets are the upper bounds of the latency histogram, in seconds
+	Buckets []float64 `yaml:"buckets"`
+	// Exemplars attaches the trace IDs of sampled requests to latencies
+	Exemplars bool   `yaml:"exemplars"`
+	StatsD    StatsD `yaml:"statsd"`
+}
+
+// SlowRequests holds configuration for logging API requests slower than
+// Threshold, with a breakdown of the time spent in middleware and store calls
+type SlowRequests struct {
+	Enabled   bool          `yaml:"enabled"`
+	Threshold time.Duration `yaml:"threshold"`
+	Dump      GoroutineDump `yaml:"dump"`
+}
+
+// GoroutineDump holds configuration for dumping goroutines when the p99
+// latency of API requests stays above P99 for Breaches windows of Window
+// requests in a row
+type GoroutineDump struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+	// P99 defaults to the slow request threshold
+	P99      time.Duration `yaml:"p99"`
+	Window   int           `yaml:"window"`
+	Breaches int           `yaml:"breaches"`
+	// Cooldown is the least time between dumps
+	Cooldown time.Duration `yaml:"cooldown"`
+	// MaxDumps is how many dumps are kept in Dir
+	MaxDumps int `yaml:"max_dumps"`
+}
+
+// StatsD holds configuration for sending metrics to a StatsD agent
+type StatsD struct {
+	// Address is the agent's UDP host and port
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+	// Tags are added to every metric as "key:value"; dogstatsd only
+	Tags []string `yaml:"tags"`
+	// Format is dogstatsd, with tags, or statsd, with labels in names
+	Format        string        `yaml:"format"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// Startup holds configuration for waiting on dependencies at startup
+type Startup struct {
+	WaitTimeout    time.Duration `yaml:"wait_timeout"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// Dependencies are waited for in order before the server starts
+	Dependencies []Dependency `yaml:"dependencies"`
+}
+
+// Dependency is a service that must be reachable at startup, checked with
+// a TCP connection to Address or an HTTP GET of URL expecting a 2xx
+type Dependency struct {
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+	URL     string `yaml:"url"`
+}
+
+// Discovery holds service discovery registration configuration
+type Discovery struct {
+	// Provider is consul; empty disables registration
+	Provider string `yaml:"provider"`
+	Endpoint string `yaml:"endpoint"`
+	Token    string `yaml:"token"`
+	// ServiceName is the name clients look up; ServiceID identifies this
+	// instance and defaults to the name, host and port
+	ServiceName string   `yaml:"service_name"`
+	ServiceID   string   `yaml:"service_id"`
+	Tags        []string `yaml:"tags"`
+	// Address is advertised to clients and defaults to the host name
+	Address       string        `yaml:"address"`
+	CheckInterval time.Duration `yaml:"check_interval"`
+	// DeregisterAfter removes an instance whose check has failed this long,
+	// e.g. after a crash that skipped deregistration
+	DeregisterAfter time.Duration `yaml:"deregister_after"`
+}
+
+// Enabled reports whether registration is configured
+func (d Discovery) Enabled() bool {
+	return d.Provider != ""
+}
+
+// Mailer holds email configuration
+type Mailer struct {
+	Enabled bool `yaml:"enabled"`
+	// Mode is smtp to send emails, or log to log them and save them to
+	// OutboxDir in development
+	Mode      string `yaml:"mode"`
+	From      string `yaml:"from"`
+	OutboxDir string `yaml:"outbox_dir"`
+	SMTP      SMTP   `yaml:"smtp"`
+	// QueueSize bounds emails waiting to be sent
+	QueueSize      int           `yaml:"queue_size"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+}
+
+// SMTP holds SMTP server configuration
+type SMTP struct {
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
-	Name     string `yaml:"name"`
-	User     string `yaml:"user"`
+	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 }
 
+// Notifications holds notification channel and routing configuration
+type Notifications struct {
+	Enabled bool    `yaml:"enabled"`
+	Webhook Webhook `yaml:"webhook"`
+	SMS     SMS     `yaml:"sms"`
+	// Events lists the channels each event type is sent over: log,
+	// webhook or sms
+	Events map[string][]string `yaml:"events"`
+}
+
+// Webhook holds where a webhook is posted and how its bodies are signed
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Secret signs each request body
+	Secret string `yaml:"secret"`
+}
+
+// SMS holds SMS notification configuration
+type SMS struct {
+	From string `yaml:"from"`
+}
+
+// Auth holds login and session configuration
+type Auth struct {
+	Enabled bool `yaml:"enabled"`
+	// JWTSecret signs session tokens. A random secret is generated when it
+	// is empty, so sessions do not survive a restart.
+	JWTSecret  string        `yaml:"jwt_secret"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// ImpersonationTTL is how long an admin can act as another user
+	ImpersonationTTL time.Duration `yaml:"impersonation_ttl"`
+	// LoginHistory is the number of login attempts kept per user
+	LoginHistory int `yaml:"login_history"`
+	// Admins are the IDs of users who can manage everyone's sessions
+	Admins []int64 `yaml:"admins"`
+	// AdminPassword is the first password of the admins, who need one to
+	// set anyone else's; prefer AUTH_ADMIN_PASSWORD
+	AdminPassword string `yaml:"admin_password"`
+	// APIKeys lets admins issue API keys under /admin/api-keys, which
+	// authenticate as bearer tokens
+	APIKeys bool `yaml:"api_keys"`
+	// LDAP checks logins before local passwords
+	LDAP LDAP `yaml:"ldap"`
+}
+
+// LDAP holds configuration for authenticating against an LDAP directory
+// or Active Directory
+type LDAP struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the server, ldap://host:389 or ldaps://host:636
+	URL string `yaml:"url"`
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool `yaml:"start_tls"`
+	// CAFile is a PEM bundle of CAs trusted instead of the system's
+	CAFile string `yaml:"ca_file"`
+	// InsecureSkipVerify accepts any server certificate; for testing only
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	// BindDN and BindPassword are the service account that searches for
+	// users; prefer LDAP_BIND_PASSWORD
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+	BaseDN       string `yaml:"base_dn"`
+	// UserFilter finds a user by login, with %s replaced by the login
+	UserFilter     string `yaml:"user_filter"`
+	EmailAttribute string `yaml:"email_attribute"`
+	NameAttribute  string `yaml:"name_attribute"`
+	GroupAttribute string `yaml:"group_attribute"`
+	// Roles maps roles, such as admin, to the group DNs granted them
+	Roles    map[string][]string `yaml:"roles"`
+	PoolSize int                 `yaml:"pool_size"`
+	Timeout  time.Duration       `yaml:"timeout"`
+}
+
+// Preconditions holds configuration for conditional writes. Users carry
+// ETags of their version, and writes with If-Match fail with 412 once the
+// user has changed since.
+type Preconditions struct {
+	// Required refuses to change or delete users without If-Match, with
+	// 428, so clients cannot overwrite changes they have not seen. It is
+	// off by default because clients written before ETags send no
+	// If-Match, and would have every write refused.
+	Required bool `yaml:"required"`
+}
+
+// Views holds configuration for saved list queries
+type Views struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxPerOwner caps how many views each caller can save
+	MaxPerOwner int `yaml:"max_per_owner"`
+}
+
+// Materialized holds configuration for materialized views and stats, whose
+// results are kept precomputed and refreshed in the background
+type Materialized struct {
+	Enabled bool `yaml:"enabled"`
+	// RefreshInterval is how often results are refreshed if the users
+	// changed
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// RefreshDelay is how long after a write through the API results are
+	// refreshed; 0 leaves them to the periodic refresh
+	RefreshDelay time.Duration `yaml:"refresh_delay"`
+	// IdleTimeout drops results not read for this long; 0 keeps them
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// Preferences holds configuration for per-user API preferences
+type Preferences struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// Tenants holds per-tenant overrides of the server's settings
+type Tenants struct {
+	Enabled bool `yaml:"enabled"`
+	// Header names the tenant of a request
+	Header string `yaml:"header"`
+	// Features are the feature flags of requests for no tenant, and of
+	// tenants that do not override them
+	Features map[string]bool `yaml:"features"`
+	// Overrides are the tenants' settings at startup, by tenant ID; admins
+	// change them at /admin/tenants
+	Overrides map[string]TenantOverrides `yaml:"overrides"`
+}
+
+// TenantOverrides are the settings a tenant overrides
+type TenantOverrides struct {
+	RateProfile  string          `yaml:"rate_profile"`
+	Features     map[string]bool `yaml:"features"`
+	EmailDomains []string        `yaml:"email_domains"`
+	Webhooks     []TenantWebhook `yaml:"webhooks"`
+}
+
+// TenantWebhook is an endpoint receiving the user events of a tenant's
+// requests
+type TenantWebhook struct {
+	URL    string   `yaml:"url"`
+	Events []string `yaml:"events"`
+	Secret string   `yaml:"secret"`
+}
+
+// Consent holds configuration for tracking which versions of the terms of
+// service and other documents users have accepted
+type Consent struct {
+	Enabled bool `yaml:"enabled"`
+	// Documents maps each document users accept to its current version
+	Documents map[string]string `yaml:"documents"`
+	// Enforce refuses requests from users who have not accepted every
+	// current version
+	Enforce bool `yaml:"enforce"`
+}
+
+// Orgs holds configuration for the organization hierarchy
+type Orgs struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// Webhooks holds configuration for webhook subscriptions to user events,
+// managed by admins under /admin/webhooks
+type Webhooks struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout bounds a single delivery
+	Timeout time.Duration `yaml:"timeout"`
+	// SchemaBaseURL is how consumers reach the API, prefixed to the schema
+	// URIs events name; empty gives relative URIs
+	SchemaBaseURL string `yaml:"schema_base_url"`
+}
+
+// Usage holds configuration for metering each principal's use of the API
+type Usage struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is how long each usage record aggregates requests for
+	Window time.Duration `yaml:"window"`
+	// Retention is how long records are kept for GET /api/v1/usage
+	Retention time.Duration `yaml:"retention"`
+	// Sink receives the records: none, file, http or kafka
+	Sink  string     `yaml:"sink"`
+	File  UsageFile  `yaml:"file"`
+	HTTP  UsageHTTP  `yaml:"http"`
+	Kafka UsageKafka `yaml:"kafka"`
+	// MaxPending bounds the records kept while the sink fails
+	MaxPending int `yaml:"max_pending"`
+}
+
+// UsageFile holds the file usage records are appended to
+type UsageFile struct {
+	Path string `yaml:"path"`
+}
+
+// UsageHTTP holds the endpoint usage records are posted to
+type UsageHTTP struct {
+	URL string `yaml:"url"`
+	// Token is sent as a bearer token; prefer USAGE_HTTP_TOKEN
+	Token   string        `yaml:"token"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// UsageKafka holds the Kafka topic usage records are produced to
+type UsageKafka struct {
+	Brokers []string      `yaml:"brokers"`
+	Topic   string        `yaml:"topic"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Plugins holds configuration for external plugins run on user lifecycle
+// events
+type Plugins struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout bounds each run of a plugin that sets none itself
+	Timeout time.Duration `yaml:"timeout"`
+	// Plugins are run in order for each hook they handle
+	Plugins []Plugin `yaml:"plugins"`
+}
+
+// Plugin is a command run for the hooks it handles
+type Plugin struct {
+	Name string `yaml:"name"`
+	// Command is the executable and its arguments
+	Command []string `yaml:"command"`
+	// Hooks are validate-before-create and enrich-after-read
+	Hooks   []string      `yaml:"hooks"`
+	Timeout time.Duration `yaml:"timeout"`
+	// FailOpen ignores the plugin's failures rather than failing requests
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// Scripts holds configuration for Lua scripts run on user lifecycle events,
+// after any plugins. They are reloaded when the configuration changes.
+type Scripts struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout bounds each run of a script that sets none itself
+	Timeout time.Duration `yaml:"timeout"`
+	// Scripts are run in order for their hook
+	Scripts []Script `yaml:"scripts"`
+}
+
+// Script is Lua code run for a hook, given inline or in a file
+type Script struct {
+	Name string `yaml:"name"`
+	// Hook is validate-before-create or enrich-after-read
+	Hook    string        `yaml:"hook"`
+	Source  string        `yaml:"source"`
+	File    string        `yaml:"file"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Rules holds configuration for rules run on user events, whose actions tag
+// or suspend the user, notify them or post to a webhook
+type Rules struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout bounds each evaluation of a condition
+	Timeout time.Duration `yaml:"timeout"`
+	// AuditSize is the number of triggered actions kept for the audit
+	// endpoint
+	AuditSize int `yaml:"audit_size"`
+	// Rules are evaluated in order
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule runs its actions on the events it lists when its condition holds
+type Rule struct {
+	Name string `yaml:"name"`
+	// Events are user.created or user.updated; all of them when empty
+	Events []string `yaml:"events"`
+	// Condition is a Lua expression about event, user and before
+	Condition string       `yaml:"condition"`
+	Actions   []RuleAction `yaml:"actions"`
+}
+
+// RuleAction is something a rule does: tag, suspend, notify or webhook
+type RuleAction struct {
+	Type    string `yaml:"type"`
+	Tag     string `yaml:"tag"`
+	Channel string `yaml:"channel"`
+	Message string `yaml:"message"`
+	URL     string `yaml:"url"`
+	Secret  string `yaml:"secret"`
+}
+
+// Transforms holds configuration for reshaping the responses of API routes
+// for downstream clients
+type Transforms struct {
+	// Routes maps routes, as "METHOD /path" with the router's path pattern,
+	// to the template their successful JSON responses are rendered with
+	Routes map[string]Transform `yaml:"routes"`
+}
+
+// Transform is a Go template rendering a route's response, given inline or
+// in a file
+type Transform struct {
+	Template string `yaml:"template"`
+	File     string `yaml:"file"`
+	// ContentType replaces the response's content type when set
+	ContentType string `yaml:"content_type"`
+}
+
+// Operations holds configuration for long-running operations processed in
+// the background, such as POST /api/v1/users?async=true
+type Operations struct {
+	Enabled bool `yaml:"enabled"`
+	// Workers is the number of operations run at once
+	Workers int `yaml:"workers"`
+	// QueueSize bounds operations waiting to run
+	QueueSize int `yaml:"queue_size"`
+	// TTL is how long finished operations can be polled
+	TTL time.Duration `yaml:"ttl"`
+	// CleanupInterval is how often operations past their TTL are removed
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// Visibility holds the roles allowed to see each restricted user field
+type Visibility struct {
+	// Fields maps JSON field names to the roles that may see them; "self"
+	// lets users see their own. Fields not listed are visible to everyone.
+	Fields map[string][]string `yaml:"fields"`
+}
+
+// Directory holds configuration for provisioning users from a SCIM
+// directory, such as an identity provider
+type Directory struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the SCIM service's base URL, e.g. https://idp.example.com/scim/v2
+	URL string `yaml:"url"`
+	// Token authenticates to the directory; prefer DIRECTORY_TOKEN
+	Token string `yaml:"token"`
+	// PageSize is the number of users fetched per request
+	PageSize int `yaml:"page_size"`
+	// Interval is how often users are synced; zero syncs only on request
+	Interval time.Duration `yaml:"interval"`
+	// DryRun makes scheduled syncs log what they would change instead
+	DryRun bool `yaml:"dry_run"`
+}
+
+// SCIM holds configuration for the SCIM 2.0 endpoints identity providers
+// provision users through
+type SCIM struct {
+	Enabled bool `yaml:"enabled"`
+	// Token is the bearer token providers authenticate with; prefer
+	// SCIM_TOKEN
+	Token string `yaml:"token"`
+	// MaxResults bounds the users in a list response
+	MaxResults int `yaml:"max_results"`
+}
+
+// Uploads holds configuration for uploading files, such as avatars and
+// imports, straight to storage
+type Uploads struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is where files are kept: local or s3
+	Backend string `yaml:"backend"`
+	// TTL is how long clients have to send a file once they create an
+	// upload
+	TTL time.Duration `yaml:"ttl"`
+	// Retention is how long completed uploads are tracked for
+	Retention time.Duration `yaml:"retention"`
+	// CleanupInterval is how often expired uploads are removed
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+	// Purposes maps what files are for to their largest size in bytes
+	Purposes map[string]int64 `yaml:"purposes"`
+	Local    LocalUploads     `yaml:"local"`
+	S3       S3Uploads        `yaml:"s3"`
+	Scan     UploadScan       `yaml:"scan"`
+}
+
+// UploadScan holds configuration for scanning uploaded files for malware
+type UploadScan struct {
+	// Scanner is none or clamav
+	Scanner string `yaml:"scanner"`
+	// Quarantine keeps infected files for inspection instead of deleting
+	// them
+	Quarantine bool       `yaml:"quarantine"`
+	ClamAV     ClamAVScan `yaml:"clamav"`
+}
+
+// ClamAVScan holds configuration for scanning with clamd
+type ClamAVScan struct {
+	// Address is clamd's TCP address
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LocalUploads holds configuration for keeping uploaded files on disk
+type LocalUploads struct {
+	Dir string `yaml:"dir"`
+	// BaseURL is how clients reach the API, prefixed to upload URLs;
+	// empty gives relative URLs
+	BaseURL string `yaml:"base_url"`
+}
+
+// S3Uploads holds configuration for keeping uploaded files in S3, or a
+// compatible store
+type S3Uploads struct {
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
+	// Endpoint overrides AWS's, e.g. for MinIO
+	Endpoint  string `yaml:"endpoint"`
+	PathStyle bool   `yaml:"path_style"`
+	Prefix    string `yaml:"prefix"`
+	// AccessKeyID and SecretAccessKey sign URLs; prefer AWS_ACCESS_KEY_ID
+	// and AWS_SECRET_ACCESS_KEY
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// Avatars holds configuration for users' avatars, made from avatar uploads
+type Avatars struct {
+	Enabled bool `yaml:"enabled"`
+	// Sizes maps names, such as thumb, to the width and height in pixels
+	// avatars are scaled to fit within
+	Sizes map[string]int `yaml:"sizes"`
+	// MinDimension and MaxDimension bound the width and height of images
+	// accepted
+	MinDimension int `yaml:"min_dimension"`
+	MaxDimension int `yaml:"max_dimension"`
+	// MaxAge is how long clients and CDNs may cache avatars
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Reports holds configuration for PDF reports about users
+type Reports struct {
+	Enabled bool `yaml:"enabled"`
+	// SyncLimit is the most users reported on while the request waits;
+	// larger reports are generated in the background, which needs
+	// operations and uploads enabled
+	SyncLimit int `yaml:"sync_limit"`
+	// Recent is the number of the latest signups listed
+	Recent int `yaml:"recent"`
+	// Retention is how long reports generated in the background can be
+	// downloaded
+	Retention time.Duration `yaml:"retention"`
+	// CleanupInterval is how often expired reports are deleted
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// IDs holds configuration for how stores generate identifiers
+type IDs struct {
+	// Strategy is random, sequence, ulid, ksuid or snowflake
+	Strategy string `yaml:"id_strategy"`
+	// Stores overrides the strategy of stores by name, such as orgs. Users
+	// take only sequence or snowflake.
+	Stores map[string]string `yaml:"stores"`
+	// SnowflakeNode distinguishes each replica's Snowflake IDs, from 0 to 1023
+	SnowflakeNode int `yaml:"snowflake_node"`
+}
+
+// Instances holds configuration for the registry of running replicas
+type Instances struct {
+	Enabled bool `yaml:"enabled"`
+	// Registry is memory, listing only this instance, or redis
+	Registry string `yaml:"registry"`
+	Redis    Redis  `yaml:"redis"`
+	// ID identifies this instance, defaulting to its host name and port
+	ID                string        `yaml:"id"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// TTL is how long an instance stays listed after its last heartbeat
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// Anonymization holds configuration for replacing the names and emails of
+// users with fakes, so production snapshots can seed other environments. It
+// is refused in production.
+type Anonymization struct {
+	// Enabled serves POST /admin/anonymize
+	Enabled bool `yaml:"enabled"`
+	// OnStartup anonymizes every user before the server starts
+	OnStartup bool `yaml:"on_startup"`
+	// Domain is the domain of fake emails, also used by anonymized exports
+	Domain string `yaml:"domain"`
+}
+
+// Stats holds configuration for aggregate counts of users
+type Stats struct {
+	Enabled bool `yaml:"enabled"`
+	// Privacy protects the counts served to callers who are not admins
+	Privacy StatsPrivacy `yaml:"privacy"`
+}
+
+// StatsPrivacy holds the differential privacy of stats. Zero values turn
+// each protection off.
+type StatsPrivacy struct {
+	// MinBucket suppresses buckets of fewer users
+	MinBucket int `yaml:"min_bucket"`
+	// Epsilon is the scale of the Laplace noise added to counts, 1/epsilon;
+	// lower values add more
+	Epsilon float64 `yaml:"epsilon"`
+}
+
+// Backup holds configuration for backing up and restoring the application's
+// state
+type Backup struct {
+	// Enabled serves GET /admin/backup and POST /admin/restore
+	Enabled bool `yaml:"enabled"`
+}
+
+// Watchdog holds configuration for checking the process's goroutines, heap
+// and garbage collection pauses. Bounds left at zero are not checked.
+type Watchdog struct {
+	Enabled       bool          `yaml:"enabled"`
+	Interval      time.Duration `yaml:"interval"`
+	MaxGoroutines int           `yaml:"max_goroutines"`
+	MaxHeapMB     int           `yaml:"max_heap_mb"`
+	MaxGCPause    time.Duration `yaml:"max_gc_pause"`
+	// Webhook is posted alerts, when it has a URL
+	Webhook Webhook `yaml:"webhook"`
+	// HeapProfiles captures a heap profile to upload storage when the heap
+	// bound is exceeded, which needs uploads enabled
+	HeapProfiles    bool          `yaml:"heap_profiles"`
+	ProfileCooldown time.Duration `yaml:"profile_cooldown"`
+}
+
+// Approvals holds configuration for operations that need a second admin's
+// approval
+type Approvals struct {
+	// Operations lists the operations that need approval, e.g. user.delete
+	Operations []string `yaml:"operations"`
+	// TTL is how long a request waits for approval before it expires
+	TTL time.Duration `yaml:"ttl"`
+}
+
 // Logging holds logging configuration
 type Logging struct {
-	Level  string `yaml:"level"`
+	Level string `yaml:"level"`
+	// Levels sets the levels of named loggers, overriding Level: http,
+	// store, auth, jobs and events
+	Levels map[string]string `yaml:"levels"`
+	// Format is json or text
 	Format string `yaml:"format"`
+	// Console writes logs to standard error
+	Console bool `yaml:"console"`
+	// Buffer is how many lines each sink below holds before dropping them
+	Buffer int      `yaml:"buffer"`
+	File   LogFile  `yaml:"file"`
+	Syslog Syslog   `yaml:"syslog"`
+	OTLP   OTLPLogs `yaml:"otlp"`
 }
 
-// Load loads configuration from file and environment variables
+// LogFile holds configuration for writing logs to rotating files
+type LogFile struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// MaxSizeMB is the size at which the file is rotated, zero for no limit
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxAge is how long a file is written before it is rotated, zero for
+	// no limit
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxBackups is how many rotated files are kept, zero to keep them all
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// Syslog holds configuration for sending logs to syslog
+type Syslog struct {
+	Enabled bool `yaml:"enabled"`
+	// Network and Address reach a remote daemon, such as udp and
+	// logs.example.com:514; both empty use the local one
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag"`
+}
+
+// OTLPLogs holds configuration for exporting logs to an OpenTelemetry
+// collector over OTLP/HTTP
+type OTLPLogs struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export, such as an API key
+	Headers       map[string]string `yaml:"headers"`
+	ServiceName   string            `yaml:"service_name"`
+	BatchSize     int               `yaml:"batch_size"`
+	FlushInterval time.Duration     `yaml:"flush_interval"`
+	Timeout       time.Duration     `yaml:"timeout"`
+}
+
+// Load loads configuration from the config file, with the active profile
+// applied, and environment variables
 func Load() (*Config, error) {
+	return LoadFile(getConfigFile(), ActiveProfile())
+}
+
+// LoadFile loads configuration from path with the named profile applied,
+// merges the remote source when one is configured, then applies environment
+// variable overrides. An empty path loads only defaults and environment
+// variables.
+func LoadFile(path, profile string) (*Config, error) {
+	cfg, err := loadLocal(path, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		remoteDoc     []byte
+		remoteVersion string
+	)
+	if cfg.Remote.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), remoteFetchTimeout)
+		defer cancel()
+
+		source, err := newRemoteSource(cfg.Remote)
+		if err != nil {
+			return nil, err
+		}
+		remoteDoc, remoteVersion, err = source.fetch(ctx, "")
+		if err != nil {
+			if !cfg.Remote.Optional {
+				return nil, fmt.Errorf("failed to load remote config: %w", err)
+			}
+			log.Printf("Failed to load remote config, continuing without it: %v", err)
+		}
+	}
+
+	return finish(cfg, remoteDoc, remoteVersion)
+}
+
+// Fingerprint identifies the configuration, leaving out the settings that
+// differ between replicas by design such as their addresses and node names.
+// Replicas with different fingerprints are running different configs.
+func (c *Config) Fingerprint() string {
+	shared := *c
+	shared.Server.Address = ""
+	shared.Server.Port = 0
+	shared.Database.Replication.Node = ""
+	shared.Discovery.ServiceID = ""
+	shared.Discovery.Address = ""
+	shared.IDs.SnowflakeNode = 0
+	shared.Instances.ID = ""
+
+	encoded, err := yaml.Marshal(shared)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+// finish merges a remote document over locally loaded configuration and
+// applies environment variable overrides
+func finish(cfg *Config, remoteDoc []byte, remoteVersion string) (*Config, error) {
+	if err := applyRemote(cfg, remoteDoc); err != nil {
+		return nil, err
+	}
+	cfg.remoteVersion = remoteVersion
+
+	// Override with environment variables
+	loadFromEnv(cfg)
+
+	return cfg, nil
+}
+
+// loadLocal loads defaults overlaid with the config file and profile
+func loadLocal(path, profile string) (*Config, error) {
 	// Set defaults
 	cfg := &Config{
+		Profile:     profile,
+		Source:      path,
 		Environment: "development",
 		Server: Server{
-			Address: ":8080",
-			Port:    8080,
+			Address:                ":8080",
+			Port:                   8080,
+			ReadTimeout:            15 * time.Second,
+			ReadHeaderTimeout:      5 * time.Second,
+			WriteTimeout:           30 * time.Second,
+			IdleTimeout:            60 * time.Second,
+			MaxHeaderBytes:         1 << 20,
+			KeepAlives:             true,
+			ShutdownTimeout:        20 * time.Second,
+			LameDuck:               5 * time.Second,
+			TerminationGracePeriod: 28 * time.Second,
+			Router:                 "gin",
+			Gin: Gin{
+				UnescapePathValues: true,
+			},
 		},
 		Database: Database{
 			Type: "memory",
+			Bolt: Bolt{
+				Path:    "data/users.db",
+				Timeout: 5 * time.Second,
+			},
+			Mongo: Mongo{
+				URI:        "mongodb://localhost:27017",
+				Database:   "userapi",
+				Collection: "users",
+				Timeout:    5 * time.Second,
+			},
+			Journal: Journal{
+				Path:            "data/users.journal",
+				Fsync:           "interval",
+				FsyncInterval:   time.Second,
+				CompactInterval: 10 * time.Minute,
+			},
+			CDC: CDC{
+				Retention: 10000,
+			},
+			Undo: Undo{
+				Window:        24 * time.Hour,
+				PurgeInterval: time.Minute,
+			},
+			Replication: Replication{
+				QueueSize:         1000,
+				MaxAttempts:       5,
+				InitialBackoff:    500 * time.Millisecond,
+				ReconcileInterval: 30 * time.Second,
+			},
 		},
 		Logging: Logging{
-			Level:  "info",
-			Format: "json",
+			Level:   "info",
+			Format:  "json",
+			Console: true,
+			Buffer:  1024,
+			File: LogFile{
+				Path:       "logs/api.log",
+				MaxSizeMB:  100,
+				MaxAge:     24 * time.Hour,
+				MaxBackups: 7,
+			},
+			Syslog: Syslog{
+				Tag: "go-api-example",
+			},
+			OTLP: OTLPLogs{
+				Endpoint:      "http://localhost:4318/v1/logs",
+				ServiceName:   "go-api-example",
+				BatchSize:     100,
+				FlushInterval: time.Second,
+				Timeout:       5 * time.Second,
+			},
+		},
+		Middleware: Middleware{
+			AccessLog: true,
+			RequestID: true,
+			Tracing:   true,
+			Activity: Activity{
+				Enabled:       true,
+				FlushInterval: 30 * time.Second,
+			},
+			Metrics: Metrics{
+				Enabled:   true,
+				Sink:      "prometheus",
+				Path:      "/metrics",
+				Exemplars: true,
+				StatsD: StatsD{
+					Address:       "127.0.0.1:8125",
+					Namespace:     "go_api_example",
+					Format:        "dogstatsd",
+					FlushInterval: time.Second,
+				},
+			},
+			RateLimit: RateLimit{
+				DefaultProfile: "standard",
+				Profiles: map[string]RateProfile{
+					"standard": {Requests: 600, Window: time.Minute},
+				},
+				Backend: "memory",
+				Redis: Redis{
+					Address: "localhost:6379",
+					Prefix:  "ratelimit:",
+					Timeout: 100 * time.Millisecond,
+				},
+				OnFailure:     "local",
+				RetryInterval: 5 * time.Second,
+				WarnAt:        0.8,
+			},
+			Caching: Caching{
+				Vary: []string{"Accept", "Accept-Encoding", "Authorization"},
+				CDN: CDN{
+					Provider:       "log",
+					QueueSize:      1000,
+					MaxAttempts:    5,
+					InitialBackoff: 500 * time.Millisecond,
+				},
+			},
+			SlowRequests: SlowRequests{
+				Enabled:   true,
+				Threshold: time.Second,
+				Dump: GoroutineDump{
+					Dir:      "data/dumps",
+					Window:   100,
+					Breaches: 3,
+					Cooldown: 10 * time.Minute,
+					MaxDumps: 10,
+				},
+			},
+		},
+		Startup: Startup{
+			WaitTimeout:    time.Minute,
+			InitialBackoff: 500 * time.Millisecond,
+			MaxBackoff:     5 * time.Second,
+		},
+		Remote: Remote{
+			Key:          "go-api-example/config",
+			PollInterval: 30 * time.Second,
+		},
+		Discovery: Discovery{
+			Endpoint:        "http://localhost:8500",
+			ServiceName:     "go-api-example",
+			CheckInterval:   10 * time.Second,
+			DeregisterAfter: time.Minute,
+		},
+		Mailer: Mailer{
+			Mode:           "log",
+			From:           "no-reply@example.com",
+			OutboxDir:      "data/mail",
+			SMTP:           SMTP{Port: 587},
+			QueueSize:      100,
+			MaxAttempts:    5,
+			InitialBackoff: 2 * time.Second,
+		},
+		Auth: Auth{
+			SessionTTL:       24 * time.Hour,
+			ImpersonationTTL: 15 * time.Minute,
+			LoginHistory:     50,
+			LDAP: LDAP{
+				UserFilter:     "(mail=%s)",
+				EmailAttribute: "mail",
+				NameAttribute:  "cn",
+				GroupAttribute: "memberOf",
+				PoolSize:       4,
+				Timeout:        10 * time.Second,
+			},
+		},
+		Approvals: Approvals{
+			TTL: 24 * time.Hour,
+		},
+		Views: Views{
+			MaxPerOwner: 50,
+		},
+		Materialized: Materialized{
+			RefreshInterval: time.Minute,
+			RefreshDelay:    time.Second,
+			IdleTimeout:     time.Hour,
+		},
+		Webhooks: Webhooks{
+			Timeout: 10 * time.Second,
+		},
+		Usage: Usage{
+			Window:     time.Minute,
+			Retention:  7 * 24 * time.Hour,
+			Sink:       "none",
+			File:       UsageFile{Path: "usage.jsonl"},
+			HTTP:       UsageHTTP{Timeout: 10 * time.Second},
+			Kafka:      UsageKafka{Topic: "api-usage", Timeout: 10 * time.Second},
+			MaxPending: 100000,
+		},
+		Plugins: Plugins{
+			Timeout: 5 * time.Second,
+		},
+		Scripts: Scripts{
+			Timeout: 100 * time.Millisecond,
+		},
+		Rules: Rules{
+			Timeout:   100 * time.Millisecond,
+			AuditSize: 1000,
+		},
+		Operations: Operations{
+			Workers:         4,
+			QueueSize:       1000,
+			TTL:             time.Hour,
+			CleanupInterval: time.Minute,
+		},
+		Directory: Directory{
+			PageSize: 100,
+			Interval: time.Hour,
+		},
+		SCIM: SCIM{
+			MaxResults: 200,
+		},
+		Uploads: Uploads{
+			Backend:         "local",
+			TTL:             15 * time.Minute,
+			Retention:       24 * time.Hour,
+			CleanupInterval: time.Minute,
+			Purposes: map[string]int64{
+				"avatar": 5 << 20,
+				"import": 100 << 20,
+			},
+			Local: LocalUploads{
+				Dir: "data/uploads",
+			},
+			Scan: UploadScan{
+				Scanner: "none",
+				ClamAV: ClamAVScan{
+					Address: "localhost:3310",
+					Timeout: 30 * time.Second,
+				},
+			},
+		},
+		Avatars: Avatars{
+			Sizes: map[string]int{
+				"thumb":  64,
+				"medium": 256,
+			},
+			MinDimension: 32,
+			MaxDimension: 4096,
+			MaxAge:       time.Hour,
+		},
+		Reports: Reports{
+			SyncLimit:       1000,
+			Recent:          10,
+			Retention:       24 * time.Hour,
+			CleanupInterval: 10 * time.Minute,
+		},
+		Anonymization: Anonymization{
+			Domain: "example.com",
+		},
+		Stats: Stats{
+			Privacy: StatsPrivacy{
+				MinBucket: 5,
+				Epsilon:   1,
+			},
+		},
+		Watchdog: Watchdog{
+			Enabled:         true,
+			Interval:        15 * time.Second,
+			MaxGoroutines:   10000,
+			MaxHeapMB:       1024,
+			MaxGCPause:      100 * time.Millisecond,
+			ProfileCooldown: 30 * time.Minute,
+		},
+		Routes: Routes{
+			DocsUI: "redoc",
+		},
+		IDs: IDs{
+			Strategy: "random",
+		},
+		Tenants: Tenants{
+			Header: "X-Tenant-ID",
+		},
+		Instances: Instances{
+			Registry: "memory",
+			Redis: Redis{
+				Address: "localhost:6379",
+				Prefix:  "instances:",
+				Timeout: 100 * time.Millisecond,
+			},
+			HeartbeatInterval: 10 * time.Second,
+			TTL:               30 * time.Second,
 		},
 	}
 
 	// Load from config file
-	configFile := getConfigFile()
-	if configFile != "" {
-		if err := loadFromFile(cfg, configFile); err != nil {
+	if path != "" {
+		if err := loadFromFile(cfg, path, profile); err != nil {
 			return nil, fmt.Errorf("failed to load config file: %w", err)
 		}
 	}
 
-	// Override with environment variables
-	loadFromEnv(cfg)
+	// The remote source is located before it is fetched, so its overrides
+	// cannot wait for loadFromEnv
+	if endpoint := os.Getenv("CONFIG_REMOTE_ENDPOINT"); endpoint != "" {
+		cfg.Remote.Endpoint = endpoint
+	}
+	if token := os.Getenv("CONFIG_REMOTE_TOKEN"); token != "" {
+		cfg.Remote.Token = token
+	}
 
 	return cfg, nil
 }
 
-// getConfigFile returns the config file path based on environment
+// ActiveProfile returns the config profile to apply: CONFIG_PROFILE if set,
+// otherwise GO_ENV, otherwise development
+func ActiveProfile() string {
+	if profile := os.Getenv("CONFIG_PROFILE"); profile != "" {
+		return profile
+	}
+	if env := os.Getenv("GO_ENV"); env != "" {
+		return env
+	}
+	return "development"
+}
+
+// getConfigFile returns the config file path: CONFIG_FILE if set,
+// otherwise configs/config.yaml when it exists
 func getConfigFile() string {
-	env := os.Getenv("GO_ENV")
-	if env == "" {
-		env = "development"
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		return configFile
 	}
 
-	configFile := fmt.Sprintf("configs/config.%s.yaml", env)
+	configFile := "configs/config.yaml"
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		// Fall back to default config
-		configFile = "configs/config.yaml"
-		if _, err := os.Stat(configFile); os.IsNotExist(err) {
-			return ""
-		}
+		return ""
 	}
-
 	return configFile
 }
 
-// loadFromFile loads configuration from YAML file
-func loadFromFile(cfg *Config, filename string) error {
-	absPath, err := filepath.Abs(filename)
+// loadFromFile loads configuration from a YAML file, its includes and the
+// named profile
+func loadFromFile(cfg *Config, filename, profile string) error {
+	doc, err := loadLayers(filename, profile)
 	if err != nil {
 		return err
 	}
 
-	data, err := os.ReadFile(absPath)
+	// Round-trip the merged document so it decodes exactly like a single file
+	data, err := yaml.Marshal(doc)
 	if err != nil {
 		return err
 	}
-
 	return yaml.Unmarshal(data, cfg)
 }
 
@@ -112,13 +1427,103 @@ func loadFromEnv(cfg *Config) {
 	if addr := os.Getenv("SERVER_ADDRESS"); addr != "" {
 		cfg.Server.Address = addr
 	}
+	if router := os.Getenv("SERVER_ROUTER"); router != "" {
+		cfg.Server.Router = router
+	}
 	if dbType := os.Getenv("DB_TYPE"); dbType != "" {
 		cfg.Database.Type = dbType
 	}
 	if dbHost := os.Getenv("DB_HOST"); dbHost != "" {
 		cfg.Database.Host = dbHost
 	}
+	if boltPath := os.Getenv("DB_BOLT_PATH"); boltPath != "" {
+		cfg.Database.Bolt.Path = boltPath
+	}
+	if uri := os.Getenv("MONGO_URI"); uri != "" {
+		cfg.Database.Mongo.URI = uri
+	}
+	if journalPath := os.Getenv("DB_JOURNAL_PATH"); journalPath != "" {
+		cfg.Database.Journal.Enabled = true
+		cfg.Database.Journal.Path = journalPath
+	}
+	if node := os.Getenv("REPLICATION_NODE"); node != "" {
+		cfg.Database.Replication.Node = node
+	}
+	if secret := os.Getenv("REPLICATION_SECRET"); secret != "" {
+		cfg.Database.Replication.Secret = secret
+	}
+	if endpoint := os.Getenv("DISCOVERY_ENDPOINT"); endpoint != "" {
+		cfg.Discovery.Endpoint = endpoint
+	}
+	if token := os.Getenv("DISCOVERY_TOKEN"); token != "" {
+		cfg.Discovery.Token = token
+	}
+	if token := os.Getenv("CLOUDFLARE_API_TOKEN"); token != "" {
+		cfg.Middleware.Caching.CDN.Cloudflare.Token = token
+	}
+	if token := os.Getenv("FASTLY_API_TOKEN"); token != "" {
+		cfg.Middleware.Caching.CDN.Fastly.Token = token
+	}
+	if password := os.Getenv("RATE_LIMIT_REDIS_PASSWORD"); password != "" {
+		cfg.Middleware.RateLimit.Redis.Password = password
+	}
+	if password := os.Getenv("INSTANCES_REDIS_PASSWORD"); password != "" {
+		cfg.Instances.Redis.Password = password
+	}
+	if token := os.Getenv("USAGE_HTTP_TOKEN"); token != "" {
+		cfg.Usage.HTTP.Token = token
+	}
+	if token := os.Getenv("DIRECTORY_TOKEN"); token != "" {
+		cfg.Directory.Token = token
+	}
+	if token := os.Getenv("SCIM_TOKEN"); token != "" {
+		cfg.SCIM.Token = token
+	}
+	if password := os.Getenv("SMTP_PASSWORD"); password != "" {
+		cfg.Mailer.SMTP.Password = password
+	}
+	if secret := os.Getenv("NOTIFICATIONS_WEBHOOK_SECRET"); secret != "" {
+		cfg.Notifications.Webhook.Secret = secret
+	}
+	if secret := os.Getenv("WATCHDOG_WEBHOOK_SECRET"); secret != "" {
+		cfg.Watchdog.Webhook.Secret = secret
+	}
+	if secret := os.Getenv("AUTH_JWT_SECRET"); secret != "" {
+		cfg.Auth.JWTSecret = secret
+	}
+	if password := os.Getenv("AUTH_ADMIN_PASSWORD"); password != "" {
+		cfg.Auth.AdminPassword = password
+	}
+	if password := os.Getenv("LDAP_BIND_PASSWORD"); password != "" {
+		cfg.Auth.LDAP.BindPassword = password
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		cfg.Uploads.S3.AccessKeyID = id
+	}
+	if secret := os.Getenv("AWS_SECRET_ACCESS_KEY"); secret != "" {
+		cfg.Uploads.S3.SecretAccessKey = secret
+	}
 	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
 		cfg.Logging.Level = logLevel
 	}
+	if headers := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_HEADERS"); headers != "" {
+		cfg.Logging.OTLP.Headers = parseHeaders(headers)
+	}
+}
+
+// parseHeaders parses headers in the OpenTelemetry environment variable
+// format, comma-separated name=value pairs with URL-encoded values
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for pair := range strings.SplitSeq(value, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if decoded, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		headers[strings.TrimSpace(name)] = value
+	}
+	return headers
 }
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{name: "single", value: "api-key=secret", want: map[string]string{"api-key": "secret"}},
+		{name: "several with spaces", value: "a=1, b = 2", want: map[string]string{"a": "1", "b": "2"}},
+		{name: "encoded value", value: "Authorization=Basic%20dXNlcjpwYXNz", want: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}},
+		{name: "equals in value", value: "token=abc==", want: map[string]string{"token": "abc=="}},
+		{name: "malformed pairs skipped", value: "a=1,nonsense", want: map[string]string{"a": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseHeaders(tt.value))
+		})
+	}
+}
+
+func TestConfig_Fingerprint(t *testing.T) {
+	base, err := LoadFile("", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		same   bool
+	}{
+		{name: "unchanged", change: func(*Config) {}, same: true},
+		{name: "port differs by replica", change: func(c *Config) { c.Server.Port = 9090 }, same: true},
+		{name: "node differs by replica", change: func(c *Config) { c.Database.Replication.Node = "b" }, same: true},
+		{name: "instance ID differs by replica", change: func(c *Config) { c.Instances.ID = "api-2" }, same: true},
+		{name: "rate limit changed", change: func(c *Config) { c.Middleware.RateLimit.Enabled = !c.Middleware.RateLimit.Enabled }},
+		{name: "database changed", change: func(c *Config) { c.Database.Type = "other" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFile("", "")
+			require.NoError(t, err)
+			tt.change(cfg)
+
+			if tt.same {
+				assert.Equal(t, base.Fingerprint(), cfg.Fingerprint())
+			} else {
+				assert.NotEqual(t, base.Fingerprint(), cfg.Fingerprint())
+			}
+			assert.Len(t, cfg.Fingerprint(), 16)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys with special meaning in config files
+const (
+	includeKey  = "include"
+	profilesKey = "profiles"
+)
+
+// layer is a parsed config document
+type layer = map[string]any
+
+// loadLayers reads path, resolves its includes and applies the named
+// profile, returning the merged document.
+//
+// Includes are merged in the order listed, each relative to the file naming
+// it, and the including file is merged over them. The active profile's
+// overlay, taken from the profiles section, is merged last. Maps merge key
+// by key; any other value, including lists, replaces the one below it.
+func loadLayers(path, profile string) (layer, error) {
+	doc, err := readLayer(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := asLayer(doc[profilesKey], profilesKey)
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, profilesKey)
+
+	if overlay, ok := profiles[profile]; ok && overlay != nil {
+		overlayLayer, err := asLayer(overlay, profilesKey+"."+profile)
+		if err != nil {
+			return nil, err
+		}
+		merge(doc, overlayLayer)
+	}
+
+	return doc, nil
+}
+
+// readLayer parses a config file and resolves its includes, including those
+// of its profile overlays. stack holds the files being read, to detect cycles.
+func readLayer(path string, stack []string) (layer, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(stack, absPath) {
+		return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), absPath)
+	}
+	stack = append(stack, absPath)
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := make(layer)
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc == nil {
+		// An empty file decodes to a nil map
+		doc = make(layer)
+	}
+
+	dir := filepath.Dir(absPath)
+	doc, err = resolveIncludes(doc, dir, stack)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	profiles, err := asLayer(doc[profilesKey], profilesKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, overlay := range profiles {
+		overlayLayer, err := asLayer(overlay, profilesKey+"."+name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if profiles[name], err = resolveIncludes(overlayLayer, dir, stack); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// resolveIncludes merges doc over the files it includes, resolved from dir
+func resolveIncludes(doc layer, dir string, stack []string) (layer, error) {
+	includes, err := includePaths(doc[includeKey])
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, includeKey)
+
+	merged := make(layer)
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
+		included, err := readLayer(include, stack)
+		if err != nil {
+			return nil, err
+		}
+		merge(merged, included)
+	}
+	merge(merged, doc)
+	return merged, nil
+}
+
+// includePaths accepts a single path or a list of paths
+func includePaths(value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		paths := make([]string, 0, len(v))
+		for _, item := range v {
+			path, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s entries must be strings, got %T", includeKey, item)
+			}
+			paths = append(paths, path)
+		}
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("%s must be a path or a list of paths, got %T", includeKey, value)
+	}
+}
+
+// asLayer converts a nested config value to a layer
+func asLayer(value any, name string) (layer, error) {
+	switch v := value.(type) {
+	case nil:
+		return make(layer), nil
+	case layer:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%s must be a mapping, got %T", name, value)
+	}
+}
+
+// merge deep-merges src into dst
+func merge(dst, src layer) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(layer)
+		dstMap, dstIsMap := dst[key].(layer)
+		if srcIsMap && dstIsMap {
+			merge(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestLoadFile_IncludesAndProfiles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": `
+include: ["base/server.yaml", "base/logging.yaml"]
+server:
+  port: 9090
+logging:
+  level: warn
+profiles:
+  production:
+    include: overlays/production.yaml
+    logging:
+      format: json
+  test:
+    server:
+      router: stdlib
+`,
+		"base/server.yaml": `
+server:
+  address: ":9090"
+  port: 8000
+  read_timeout: 3s
+`,
+		"base/logging.yaml": `
+logging:
+  level: debug
+  format: text
+`,
+		"overlays/production.yaml": `
+environment: production
+server:
+  read_timeout: 10s
+`,
+	})
+	path := filepath.Join(dir, "config.yaml")
+
+	tests := []struct {
+		name    string
+		check   func(t *testing.T, cfg *Config)
+		profile string
+	}{
+		{
+			name:    "development",
+			profile: "development",
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, ":9090", cfg.Server.Address)
+				assert.Equal(t, 9090, cfg.Server.Port, "including file overrides includes")
+				assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout)
+				assert.Equal(t, "warn", cfg.Logging.Level)
+				assert.Equal(t, "text", cfg.Logging.Format)
+				assert.Equal(t, "gin", cfg.Server.Router, "unset keys keep their defaults")
+				assert.Equal(t, "development", cfg.Environment)
+			},
+		},
+		{
+			name:    "production",
+			profile: "production",
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, "production", cfg.Environment)
+				assert.Equal(t, 10*time.Second, cfg.Server.ReadTimeout)
+				assert.Equal(t, 9090, cfg.Server.Port)
+				assert.Equal(t, "warn", cfg.Logging.Level)
+				assert.Equal(t, "json", cfg.Logging.Format)
+			},
+		},
+		{
+			name:    "test",
+			profile: "test",
+			check: func(t *testing.T, cfg *Config) {
+				assert.Equal(t, "stdlib", cfg.Server.Router)
+				assert.Equal(t, "text", cfg.Logging.Format)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GO_ENV", "")
+			cfg, err := LoadFile(path, tt.profile)
+			require.NoError(t, err)
+			assert.Equal(t, tt.profile, cfg.Profile)
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"config.yaml": "include: a.yaml\n",
+				"a.yaml":      "include: config.yaml\n",
+			},
+		},
+		{
+			name:  "missing include",
+			files: map[string]string{"config.yaml": "include: missing.yaml\n"},
+		},
+		{
+			name:  "invalid include",
+			files: map[string]string{"config.yaml": "include: {a: b}\n"},
+		},
+		{
+			name:  "invalid profile",
+			files: map[string]string{"config.yaml": "profiles:\n  development: [a]\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigFiles(t, tt.files)
+			_, err := LoadFile(filepath.Join(dir, "config.yaml"), "development")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_RepositoryProfiles(t *testing.T) {
+	path := filepath.Join("..", "..", "configs", "config.yaml")
+	t.Setenv("GO_ENV", "")
+
+	development, err := LoadFile(path, "development")
+	require.NoError(t, err)
+	assert.Equal(t, "development", development.Environment)
+	assert.Equal(t, "debug", development.Logging.Level)
+
+	production, err := LoadFile(path, "production")
+	require.NoError(t, err)
+	assert.Equal(t, "production", production.Environment)
+	assert.Equal(t, "postgres", production.Database.Type)
+	assert.Equal(t, "info", production.Logging.Level)
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Remote configuration providers
+const (
+	RemoteConsul = "consul"
+	RemoteEtcd   = "etcd"
+)
+
+const (
+	// remoteFetchTimeout bounds the initial fetch at startup
+	remoteFetchTimeout = 10 * time.Second
+	// remoteRetryDelay is how long the watcher waits after a failed fetch
+	remoteRetryDelay = 5 * time.Second
+)
+
+// Remote holds remote configuration source settings. The source holds a
+// YAML document, in the same format as the config file, that is merged over
+// the file and profile but under environment variable overrides.
+type Remote struct {
+	// Provider is consul or etcd; empty disables the remote source
+	Provider string `yaml:"provider"`
+	Endpoint string `yaml:"endpoint"`
+	Key      string `yaml:"key"`
+	Token    string `yaml:"token"`
+	// PollInterval is how often etcd is polled for changes, and how long a
+	// Consul blocking query waits
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Optional lets startup continue on local configuration when the remote
+	// source is unreachable
+	Optional bool `yaml:"optional"`
+}
+
+// Enabled reports whether a remote source is configured
+func (r Remote) Enabled() bool {
+	return r.Provider != ""
+}
+
+// remoteSource fetches the config document from a key-value store
+type remoteSource interface {
+	// fetch returns the document, empty if the key does not exist, and a
+	// version that changes whenever the document does. Sources that support
+	// it block until the version moves past after, or their wait elapses.
+	fetch(ctx context.Context, after string) (doc []byte, version string, err error)
+	// blocking reports whether fetch waits for changes itself
+	blocking() bool
+}
+
+// newRemoteSource creates the source for the configured provider
+func newRemoteSource(cfg Remote) (remoteSource, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("remote config provider %s needs an endpoint", cfg.Provider)
+	}
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+
+	switch cfg.Provider {
+	case RemoteConsul:
+		return &consulSource{cfg: cfg, endpoint: endpoint}, nil
+	case RemoteEtcd:
+		return &etcdSource{cfg: cfg, endpoint: endpoint}, nil
+	default:
+		return nil, fmt.Errorf("unknown remote config provider %q, expected %s or %s", cfg.Provider, RemoteConsul, RemoteEtcd)
+	}
+}
+
+// consulSource reads a Consul KV key, using blocking queries to wait for
+// changes
+type consulSource struct {
+	cfg      Remote
+	endpoint string
+}
+
+func (s *consulSource) fetch(ctx context.Context, after string) ([]byte, string, error) {
+	query := url.Values{"raw": {""}}
+	if after != "" {
+		query.Set("index", after)
+		query.Set("wait", s.cfg.PollInterval.String())
+	}
+	target := fmt.Sprintf("%s/v1/kv/%s?%s", s.endpoint, strings.TrimPrefix(s.cfg.Key, "/"), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", s.cfg.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	version := resp.Header.Get("X-Consul-Index")
+	switch resp.StatusCode {
+	case http.StatusOK:
+		doc, err := io.ReadAll(resp.Body)
+		return doc, version, err
+	case http.StatusNotFound:
+		return nil, version, nil
+	default:
+		return nil, "", fmt.Errorf("consul returned %s", resp.Status)
+	}
+}
+
+func (s *consulSource) blocking() bool {
+	return true
+}
+
+// etcdSource reads a key through etcd's v3 JSON gateway, which is polled
+// for changes
+type etcdSource struct {
+	cfg      Remote
+	endpoint string
+}
+
+// etcdRangeResponse is the subset of an etcd range response that is used
+type etcdRangeResponse struct {
+	Kvs []struct {
+		Value       string `json:"value"`
+		ModRevision string `json:"mod_revision"`
+	} `json:"kvs"`
+}
+
+func (s *etcdSource) fetch(ctx context.Context, _ string) ([]byte, string, error) {
+	body, err := json.Marshal(map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(s.cfg.Key)),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", s.cfg.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("etcd returned %s", resp.Status)
+	}
+
+	var result etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("failed to decode etcd response: %w", err)
+	}
+	if len(result.Kvs) == 0 {
+		// A missing key has no revision of its own
+		return nil, "0", nil
+	}
+
+	doc, err := base64.StdEncoding.DecodeString(result.Kvs[0].Value)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode etcd value: %w", err)
+	}
+	return doc, result.Kvs[0].ModRevision, nil
+}
+
+func (s *etcdSource) blocking() bool {
+	return false
+}
+
+// applyRemote merges a remote document over cfg. The remote section itself
+// always comes from local configuration.
+func applyRemote(cfg *Config, doc []byte) error {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return nil
+	}
+
+	remote := cfg.Remote
+	if err := yaml.Unmarshal(doc, cfg); err != nil {
+		return fmt.Errorf("failed to parse remote config: %w", err)
+	}
+	cfg.Remote = remote
+	return nil
+}
+
+// WatchRemote reloads configuration whenever the remote document changes and
+// passes the result to onChange, until ctx is cancelled. Failed fetches and
+// invalid documents are logged and the previous configuration stays in
+// effect. It returns immediately when no remote source is configured.
+func WatchRemote(ctx context.Context, cfg *Config, onChange func(*Config)) error {
+	if !cfg.Remote.Enabled() {
+		return nil
+	}
+	source, err := newRemoteSource(cfg.Remote)
+	if err != nil {
+		return err
+	}
+
+	// Start from the version merged at load time so no change is missed
+	version := cfg.remoteVersion
+	for {
+		doc, next, err := source.fetch(ctx, version)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			log.Printf("Failed to fetch remote config: %v", err)
+			if !sleep(ctx, remoteRetryDelay) {
+				return nil
+			}
+			continue
+		case next != version:
+			version = next
+			if updated, err := reload(cfg, doc, next); err != nil {
+				log.Printf("Failed to apply remote config version %s: %v", next, err)
+			} else {
+				onChange(updated)
+			}
+		}
+
+		if !source.blocking() && !sleep(ctx, cfg.Remote.PollInterval) {
+			return nil
+		}
+	}
+}
+
+// reload rebuilds configuration from the same local sources as cfg with a
+// new remote document
+func reload(cfg *Config, doc []byte, version string) (*Config, error) {
+	local, err := loadLocal(cfg.Source, cfg.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return finish(local, doc, version)
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV serves a single key over the Consul and etcd HTTP APIs
+type fakeKV struct {
+	mutex   sync.Mutex
+	doc     string
+	version int
+	changed chan struct{}
+}
+
+func newFakeKV(doc string) *fakeKV {
+	return &fakeKV{doc: doc, version: 1, changed: make(chan struct{})}
+}
+
+func (kv *fakeKV) set(doc string) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.doc = doc
+	kv.version++
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *fakeKV) get() (string, int, chan struct{}) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	return kv.doc, kv.version, kv.changed
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	doc, version, changed := kv.get()
+
+	switch r.URL.Path {
+	case "/v1/kv/app/config":
+		// Consul blocking query: wait while the index is current
+		if index := r.URL.Query().Get("index"); index == strconv.Itoa(version) {
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			doc, version, _ = kv.get()
+		}
+		w.Header().Set("X-Consul-Index", strconv.Itoa(version))
+		_, _ = fmt.Fprint(w, doc)
+	case "/v3/kv/range":
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"kvs": []map[string]string{{
+				"value":        base64.StdEncoding.EncodeToString([]byte(doc)),
+				"mod_revision": strconv.Itoa(version),
+			}},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeRemoteConfig(t *testing.T, provider, endpoint string, optional bool) string {
+	t.Helper()
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": fmt.Sprintf(`
+server:
+  port: 8000
+logging:
+  level: debug
+remote:
+  provider: %s
+  endpoint: %s
+  key: app/config
+  poll_interval: 20ms
+  optional: %t
+`, provider, endpoint, optional),
+	})
+	return filepath.Join(dir, "config.yaml")
+}
+
+func TestLoadFile_MergesRemote(t *testing.T) {
+	for _, provider := range []string{RemoteConsul, RemoteEtcd} {
+		t.Run(provider, func(t *testing.T) {
+			kv := newFakeKV("server:\n  port: 9000\nremote:\n  provider: none\n")
+			server := httptest.NewServer(kv)
+			defer server.Close()
+
+			t.Setenv("GO_ENV", "")
+			t.Setenv("LOG_LEVEL", "warn")
+			cfg, err := LoadFile(writeRemoteConfig(t, provider, server.URL, false), "development")
+			require.NoError(t, err)
+
+			assert.Equal(t, 9000, cfg.Server.Port, "remote overrides the file")
+			assert.Equal(t, "warn", cfg.Logging.Level, "environment overrides remote")
+			assert.Equal(t, provider, cfg.Remote.Provider, "remote cannot redirect itself")
+		})
+	}
+}
+
+func TestLoadFile_UnreachableRemote(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, err := LoadFile(writeRemoteConfig(t, RemoteEtcd, server.URL, false), "development")
+	assert.Error(t, err)
+
+	cfg, err := LoadFile(writeRemoteConfig(t, RemoteEtcd, server.URL, true), "development")
+	require.NoError(t, err)
+	assert.Equal(t, 8000, cfg.Server.Port)
+}
+
+func TestWatchRemote_ReloadsOnChange(t *testing.T) {
+	for _, provider := range []string{RemoteConsul, RemoteEtcd} {
+		t.Run(provider, func(t *testing.T) {
+			kv := newFakeKV("server:\n  port: 9000\n")
+			server := httptest.NewServer(kv)
+			defer server.Close()
+
+			t.Setenv("GO_ENV", "")
+			cfg, err := LoadFile(writeRemoteConfig(t, provider, server.URL, false), "development")
+			require.NoError(t, err)
+			require.Equal(t, 9000, cfg.Server.Port)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			updates := make(chan *Config, 1)
+			done := make(chan error)
+			go func() {
+				done <- WatchRemote(ctx, cfg, func(updated *Config) { updates <- updated })
+			}()
+
+			kv.set("server:\n  port: 9001\n")
+			select {
+			case updated := <-updates:
+				assert.Equal(t, 9001, updated.Server.Port)
+				assert.Equal(t, "debug", updated.Logging.Level, "local settings are kept")
+			case <-time.After(5 * time.Second):
+				t.Fatal("remote change was not picked up")
+			}
+
+			cancel()
+			assert.NoError(t, <-done)
+		})
+	}
+}
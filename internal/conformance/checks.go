@@ -0,0 +1,240 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dazraf/go-api-example/pkg/client"
+)
+
+// missingID is an ID no deployment is expected to have reached
+const missingID = 2147483000
+
+func checkCreate(ctx context.Context, s *suite) error {
+	want := s.newUser()
+	var created client.User
+	resp, err := s.client.Do(ctx, http.MethodPost, "/api/v1/users", want, &created)
+	if err != nil {
+		return err
+	}
+	if created.ID != 0 {
+		s.created = append(s.created, created.ID)
+	}
+
+	switch {
+	case resp.StatusCode != http.StatusCreated:
+		return fmt.Errorf("got %d, expected %d", resp.StatusCode, http.StatusCreated)
+	case !isJSON(resp.Header.Get("Content-Type")):
+		return fmt.Errorf("got content type %q, expected application/json", resp.Header.Get("Content-Type"))
+	case created.ID <= 0:
+		return fmt.Errorf("got ID %d, expected a positive one", created.ID)
+	case created.Name != want.Name || created.Email != want.Email:
+		return fmt.Errorf("got %q <%s>, expected %q <%s>", created.Name, created.Email, want.Name, want.Email)
+	case created.CreatedAt.IsZero():
+		return errors.New("created_at is not set")
+	case created.UpdatedAt.Before(created.CreatedAt):
+		return fmt.Errorf("updated_at %v is before created_at %v", created.UpdatedAt, created.CreatedAt)
+	}
+	return nil
+}
+
+func checkGet(ctx context.Context, s *suite) error {
+	created, err := s.create(ctx)
+	if err != nil {
+		return err
+	}
+	got, err := s.client.GetUser(ctx, created.ID)
+	if err != nil {
+		return err
+	}
+	return sameUser(*got, *created)
+}
+
+func checkList(ctx context.Context, s *suite) error {
+	first, err := s.create(ctx)
+	if err != nil {
+		return err
+	}
+	second, err := s.create(ctx)
+	if err != nil {
+		return err
+	}
+	if first.ID == second.ID {
+		return fmt.Errorf("two users were both given ID %d", first.ID)
+	}
+
+	users, err := s.client.ListUsers(ctx, client.ListQuery{})
+	if err != nil {
+		return err
+	}
+	seen := make(map[int64]client.User, len(users))
+	for _, user := range users {
+		if _, ok := seen[user.ID]; ok {
+			return fmt.Errorf("user %d is listed more than once", user.ID)
+		}
+		seen[user.ID] = user
+	}
+	for _, want := range []*client.User{first, second} {
+		got, ok := seen[want.ID]
+		if !ok {
+			return fmt.Errorf("user %d is not listed", want.ID)
+		}
+		if err := sameUser(got, *want); err != nil {
+			return fmt.Errorf("listed user: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkListFilters(ctx context.Context, s *suite) error {
+	created, err := s.create(ctx)
+	if err != nil {
+		return err
+	}
+	listed := func(query client.ListQuery) (bool, error) {
+		users, err := s.client.ListUsers(ctx, query)
+		if err != nil {
+			return false, err
+		}
+		for _, user := range users {
+			if user.ID == created.ID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	// Bounds are exclusive
+	tests := []struct {
+		name  string
+		query client.ListQuery
+		want  bool
+	}{
+		{"created_after a second before", client.ListQuery{CreatedAfter: created.CreatedAt.Add(-time.Second)}, true},
+		{"created_after its creation", client.ListQuery{CreatedAfter: created.CreatedAt}, false},
+		{"created_before a second after", client.ListQuery{CreatedBefore: created.CreatedAt.Add(time.Second)}, true},
+		{"created_before its creation", client.ListQuery{CreatedBefore: created.CreatedAt}, false},
+	}
+	for _, tt := range tests {
+		got, err := listed(tt.query)
+		if err != nil {
+			return err
+		}
+		if got != tt.want {
+			return fmt.Errorf("user created at %s listed is %v with %s, expected %v",
+				created.CreatedAt.Format(time.RFC3339Nano), got, tt.name, tt.want)
+		}
+	}
+	return nil
+}
+
+func checkUpdate(ctx context.Context, s *suite) error {
+	created, err := s.create(ctx)
+	if err != nil {
+		return err
+	}
+	change := s.newUser()
+	// Updating from the version created sends it in If-Match, which
+	// deployments may require
+	change.Version = created.Version
+	updated, err := s.client.UpdateUser(ctx, created.ID, change)
+	if err != nil {
+		return err
+	}
+	if created.Version != 0 {
+		if updated.Version <= created.Version {
+			return fmt.Errorf("version went from %d to %d", created.Version, updated.Version)
+		}
+		_, err := s.client.UpdateUser(ctx, created.ID, change)
+		if err := expectError(err, http.StatusPreconditionFailed); err != nil {
+			return fmt.Errorf("update from a stale version: %w", err)
+		}
+	}
+
+	want := *created
+	want.Name, want.Email = change.Name, change.Email
+	if err := sameUser(*updated, want); err != nil {
+		return err
+	}
+	if updated.UpdatedAt.Before(created.UpdatedAt) {
+		return fmt.Errorf("updated_at went back from %v to %v", created.UpdatedAt, updated.UpdatedAt)
+	}
+
+	got, err := s.client.GetUser(ctx, created.ID)
+	if err != nil {
+		return err
+	}
+	if err := sameUser(*got, want); err != nil {
+		return fmt.Errorf("after update: %w", err)
+	}
+	return nil
+}
+
+func checkDelete(ctx context.Context, s *suite) error {
+	created, err := s.create(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(ctx, http.MethodDelete, userPath(created.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+	case http.StatusAccepted:
+		return skip("deletions need approval on this deployment")
+	default:
+		return fmt.Errorf("got %d, expected %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	if _, err := s.client.GetUser(ctx, created.ID); err != nil {
+		if err := expectError(err, http.StatusNotFound); err != nil {
+			return fmt.Errorf("get after delete: %w", err)
+		}
+	} else {
+		return errors.New("deleted user can still be read")
+	}
+	if err := expectError(s.client.DeleteUser(ctx, created.ID), http.StatusNotFound); err != nil {
+		return fmt.Errorf("delete again: %w", err)
+	}
+	return nil
+}
+
+func checkNotFound(ctx context.Context, s *suite) error {
+	_, err := s.client.GetUser(ctx, missingID)
+	if err := expectError(err, http.StatusNotFound); err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	_, err = s.client.UpdateUser(ctx, missingID, s.newUser())
+	if err := expectError(err, http.StatusNotFound); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
+
+func checkInvalidID(ctx context.Context, s *suite) error {
+	_, err := s.client.Do(ctx, http.MethodGet, "/api/v1/users/not-an-id", nil, nil)
+	return expectError(err, http.StatusBadRequest)
+}
+
+func checkMalformedBody(ctx context.Context, s *suite) error {
+	var created client.User
+	_, err := s.client.Do(ctx, http.MethodPost, "/api/v1/users", []byte(`{"name":`), &created)
+	if created.ID != 0 {
+		s.created = append(s.created, created.ID)
+	}
+	return expectError(err, http.StatusBadRequest)
+}
+
+func checkInvalidQuery(ctx context.Context, s *suite) error {
+	_, err := s.client.ListUsers(ctx, client.ListQuery{Extra: url.Values{"created_after": {"not-a-time"}}})
+	return expectError(err, http.StatusBadRequest)
+}
+
+func userPath(id int64) string {
+	return fmt.Sprintf("/api/v1/users/%d", id)
+}
@@ -0,0 +1,228 @@
+// Package conformance checks that a running deployment behaves as the API
+// promises: CRUD semantics, status codes, error shapes and list
+// invariants. Checks call the API through the client package, as any
+// caller would, so proxies and gateways in front of the server are checked
+// along with it.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/dazraf/go-api-example/pkg/client"
+)
+
+// Options configures a run
+type Options struct {
+	// Run selects the checks to run by name; all of them run when nil
+	Run *regexp.Regexp
+	// Prefix starts the names of the users created, so they can be told
+	// apart from real ones
+	Prefix string
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name string
+	// Err says why the check failed, nil when it passed or was skipped
+	Err error
+	// Skipped says why the check could not run against the deployment
+	Skipped  string
+	Duration time.Duration
+}
+
+// Passed reports whether the check ran and passed
+func (r Result) Passed() bool {
+	return r.Err == nil && r.Skipped == ""
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Results []Result
+	// Leftover lists the users created that could not be deleted
+	Leftover []int64
+}
+
+// Failed returns how many checks failed
+func (r Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// check is a named behaviour of the API
+type check struct {
+	name string
+	run  func(ctx context.Context, s *suite) error
+}
+
+// checks are run in order
+var checks = []check{
+	{"create returns 201 with the stored user", checkCreate},
+	{"get returns the user created", checkGet},
+	{"list holds each user once", checkList},
+	{"list filters by creation time", checkListFilters},
+	{"update replaces the user", checkUpdate},
+	{"delete removes the user", checkDelete},
+	{"unknown users are 404", checkNotFound},
+	{"invalid IDs are 400", checkInvalidID},
+	{"malformed bodies are 400", checkMalformedBody},
+	{"invalid query parameters are 400", checkInvalidQuery},
+}
+
+// Checks returns the names of the checks, in the order they run
+func Checks() []string {
+	names := make([]string, len(checks))
+	for i, check := range checks {
+		names[i] = check.name
+	}
+	return names
+}
+
+// skipError marks a check that cannot run against the deployment
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+func skip(format string, args ...any) error {
+	return &skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+// Run runs the selected checks against the API c calls, then deletes the
+// users they created
+func Run(ctx context.Context, c *client.Client, opts Options) Report {
+	if opts.Prefix == "" {
+		opts.Prefix = "conform"
+	}
+	s := &suite{client: c, prefix: opts.Prefix + "-" + randomHex(4)}
+
+	var report Report
+	for _, check := range checks {
+		if opts.Run != nil && !opts.Run.MatchString(check.name) {
+			continue
+		}
+		start := time.Now()
+		err := check.run(ctx, s)
+		result := Result{Name: check.name, Duration: time.Since(start)}
+		var skipped *skipError
+		if errors.As(err, &skipped) {
+			result.Skipped = skipped.reason
+		} else {
+			result.Err = err
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Leftover = s.cleanup(ctx)
+	return report
+}
+
+// suite holds what checks share: the client and the users created
+type suite struct {
+	client  *client.Client
+	prefix  string
+	count   int
+	created []int64
+}
+
+// newUser returns a user that no other run uses
+func (s *suite) newUser() client.User {
+	s.count++
+	name := fmt.Sprintf("%s-%d", s.prefix, s.count)
+	return client.User{Name: name, Email: name + "@example.com"}
+}
+
+// create creates a new user, to be deleted when the run ends
+func (s *suite) create(ctx context.Context) (*client.User, error) {
+	created, err := s.client.CreateUser(ctx, s.newUser())
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+	s.created = append(s.created, created.ID)
+	return created, nil
+}
+
+// cleanup deletes the users created that are still there, returning those
+// that could not be deleted
+func (s *suite) cleanup(ctx context.Context) []int64 {
+	var leftover []int64
+	for _, id := range s.created {
+		err := s.client.DeleteUser(ctx, id)
+		if err != nil && client.StatusCode(err) != http.StatusNotFound {
+			leftover = append(leftover, id)
+		}
+	}
+	s.created = nil
+	return leftover
+}
+
+// expectError checks err is an error response with status: a JSON object
+// with a message, a code, and a trace ID, if any, that is a W3C trace ID
+func expectError(err error, status int) error {
+	if err == nil {
+		return fmt.Errorf("expected %d, got a successful response", status)
+	}
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if apiErr.StatusCode != status {
+		return fmt.Errorf("expected %d, got %w", status, apiErr)
+	}
+	if !isJSON(apiErr.ContentType) {
+		return fmt.Errorf("error response has content type %q, expected application/json", apiErr.ContentType)
+	}
+	if apiErr.Message == "" {
+		return fmt.Errorf(`error response has no "error" message: %.200s`, apiErr.Body)
+	}
+	if apiErr.Code == "" {
+		return fmt.Errorf(`error response has no "code": %w`, apiErr)
+	}
+	if apiErr.TraceID != "" && !traceID.MatchString(apiErr.TraceID) {
+		return fmt.Errorf("error response has trace ID %q, expected 32 lowercase hex digits", apiErr.TraceID)
+	}
+	return nil
+}
+
+var traceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// isJSON reports whether contentType is application/json, with any
+// parameters
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// sameUser reports how got differs from want in the fields clients set and
+// those the server keeps, if it does
+func sameUser(got, want client.User) error {
+	switch {
+	case got.ID != want.ID:
+		return fmt.Errorf("got user %d, expected %d", got.ID, want.ID)
+	case got.Name != want.Name || got.Email != want.Email:
+		return fmt.Errorf("got %q <%s>, expected %q <%s>", got.Name, got.Email, want.Name, want.Email)
+	case !got.CreatedAt.Equal(want.CreatedAt):
+		return fmt.Errorf("got created_at %v, expected %v", got.CreatedAt, want.CreatedAt)
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
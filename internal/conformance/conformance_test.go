@@ -0,0 +1,79 @@
+package conformance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/handlers"
+	"github.com/dazraf/go-api-example/internal/middleware"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/pkg/client"
+)
+
+// newServer serves the users API, through gateway when not nil
+func newServer(t *testing.T, userStore store.UserStore, gateway func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	r := router.NewStdlib()
+	router.Mount(r, handlers.NewUserHandler(userStore).Routes())
+	handler := middleware.Tracing()(r)
+	if gateway != nil {
+		handler = gateway(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRun(t *testing.T) {
+	userStore := store.NewMemoryUserStore()
+	server := newServer(t, userStore, nil)
+
+	report := Run(context.Background(), client.New(server.URL), Options{})
+	require.Len(t, report.Results, len(Checks()))
+	for _, result := range report.Results {
+		assert.True(t, result.Passed(), "%s: %v", result.Name, result.Err)
+	}
+	assert.Zero(t, report.Failed())
+
+	// The users created are deleted
+	assert.Empty(t, report.Leftover)
+	users, err := userStore.GetAll()
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestRun_BrokenGateway(t *testing.T) {
+	// A gateway replacing error responses with its own pages breaks the
+	// error shape clients rely on
+	gateway := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := httptest.NewRecorder()
+			next.ServeHTTP(recorder, r)
+			if recorder.Code >= 400 {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(recorder.Code)
+				_, _ = w.Write([]byte("<html>Error</html>"))
+				return
+			}
+			for name, values := range recorder.Header() {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(recorder.Code)
+			_, _ = w.Write(recorder.Body.Bytes())
+		})
+	}
+	server := newServer(t, store.NewMemoryUserStore(), gateway)
+
+	report := Run(context.Background(), client.New(server.URL), Options{Run: regexp.MustCompile("404|400|get")})
+	require.Len(t, report.Results, 5)
+	assert.True(t, report.Results[0].Passed())
+	assert.Equal(t, 4, report.Failed())
+	assert.ErrorContains(t, report.Results[1].Err, `content type "text/html"`)
+}
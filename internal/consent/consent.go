@@ -0,0 +1,192 @@
+// Package consent tracks which versions of legal documents, such as the
+// terms of service and privacy policy, each user has accepted and when.
+// With enforcement on, Middleware refuses requests from users who have not
+// accepted the current version of every document.
+package consent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// ErrorCode identifies the error Middleware responds with, so clients can
+// tell it from other 451s and prompt the user
+const ErrorCode = apierrors.ConsentRequired
+
+var (
+	// ErrUnknownDocument is returned for documents that are not configured
+	ErrUnknownDocument = errors.New("unknown document")
+	// ErrNotCurrent is returned when accepting a version of a document
+	// other than the current one
+	ErrNotCurrent = errors.New("only the current version of a document can be accepted")
+)
+
+// Document is a version of a document users accept
+type Document struct {
+	Name    string `json:"document" example:"terms"`
+	Version string `json:"version" example:"2024-06"`
+}
+
+// Acceptance records a user accepting a version of a document
+type Acceptance struct {
+	Document
+	AcceptedAt time.Time `json:"accepted_at" example:"2024-01-02T15:04:05Z"`
+}
+
+// Status is what a user has accepted, and what they still must
+type Status struct {
+	// Accepted are the user's acceptances, oldest first
+	Accepted []Acceptance `json:"accepted"`
+	// Pending are the current documents the user has not accepted
+	Pending []Document `json:"pending"`
+}
+
+// RequiredError is the body of responses refusing users who have not
+// accepted the current documents
+type RequiredError struct {
+	Error   string         `json:"error" example:"The current terms must be accepted"`
+	Code    apierrors.Code `json:"code" example:"CONSENT_REQUIRED"`
+	Pending []Document     `json:"pending"`
+	TraceID string         `json:"trace_id,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736"`
+}
+
+// Options configures a Store
+type Options struct {
+	// Documents maps each document users accept to its current version
+	Documents map[string]string
+	// Enforce refuses requests from users with pending documents
+	Enforce bool
+	// Exempt are path prefixes served to users with pending documents, so
+	// they can log in and accept them
+	Exempt []string
+	Clock  clock.Clock
+}
+
+// Store holds acceptances in memory, keyed by user ID
+type Store struct {
+	mutex       sync.RWMutex
+	acceptances map[int64][]Acceptance
+	opts        Options
+}
+
+// NewStore creates a store in which no one has accepted anything
+func NewStore(opts Options) *Store {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Store{acceptances: make(map[int64][]Acceptance), opts: opts}
+}
+
+// Current returns the current version of every document, by name
+func (s *Store) Current() []Document {
+	documents := make([]Document, 0, len(s.opts.Documents))
+	for _, name := range slices.Sorted(maps.Keys(s.opts.Documents)) {
+		documents = append(documents, Document{Name: name, Version: s.opts.Documents[name]})
+	}
+	return documents
+}
+
+// Accept records the user accepting a version of a document, which must be
+// the current one. Accepting a version again keeps the first acceptance.
+func (s *Store) Accept(userID int64, document Document) (Acceptance, error) {
+	current, ok := s.opts.Documents[document.Name]
+	if !ok {
+		return Acceptance{}, fmt.Errorf("%w %q", ErrUnknownDocument, document.Name)
+	}
+	if document.Version != current {
+		return Acceptance{}, fmt.Errorf("%w: %s is at version %q", ErrNotCurrent, document.Name, current)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, acceptance := range s.acceptances[userID] {
+		if acceptance.Document == document {
+			return acceptance, nil
+		}
+	}
+	acceptance := Acceptance{Document: document, AcceptedAt: s.opts.Clock.Now().UTC()}
+	s.acceptances[userID] = append(s.acceptances[userID], acceptance)
+	return acceptance, nil
+}
+
+// Status returns what the user has accepted and what is pending
+func (s *Store) Status(userID int64) Status {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	accepted := slices.Clone(s.acceptances[userID])
+	if accepted == nil {
+		accepted = []Acceptance{}
+	}
+	return Status{Accepted: accepted, Pending: s.pending(userID)}
+}
+
+// pending returns the current documents the user has not accepted. The
+// caller holds the mutex.
+func (s *Store) pending(userID int64) []Document {
+	pending := []Document{}
+	for _, document := range s.Current() {
+		if !slices.ContainsFunc(s.acceptances[userID], func(a Acceptance) bool { return a.Document == document }) {
+			pending = append(pending, document)
+		}
+	}
+	return pending
+}
+
+// Middleware refuses requests from users who have not accepted the current
+// version of every document with 451 Unavailable For Legal Reasons and a
+// RequiredError naming them, when enforcement is on. Anonymous requests,
+// those from callers other than users, such as API keys, and exempt paths
+// pass through.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.opts.Enforce || s.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, ok := reqctx.PrincipalFrom(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := store.ParseID(principal.Subject)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.mutex.RLock()
+		pending := s.pending(userID)
+		s.mutex.RUnlock()
+		if len(pending) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		response := RequiredError{Error: "The current terms must be accepted", Code: ErrorCode, Pending: pending}
+		if trace, ok := reqctx.Trace(r.Context()); ok {
+			response.TraceID = trace.TraceID
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
+
+// exempt reports whether path is served whatever the caller has accepted
+func (s *Store) exempt(path string) bool {
+	return slices.ContainsFunc(s.opts.Exempt, func(prefix string) bool { return strings.HasPrefix(path, prefix) })
+}
@@ -0,0 +1,105 @@
+package consent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+var (
+	terms   = Document{Name: "terms", Version: "2024-06"}
+	privacy = Document{Name: "privacy", Version: "2024-01"}
+)
+
+func TestStore_Accept(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+	store := NewStore(Options{Documents: map[string]string{"terms": "2024-06", "privacy": "2024-01"}, Clock: clk})
+	assert.Equal(t, []Document{privacy, terms}, store.Current())
+
+	tests := []struct {
+		name     string
+		document Document
+		wantErr  error
+	}{
+		{"current version", terms, nil},
+		{"old version", Document{Name: "terms", Version: "2023-01"}, ErrNotCurrent},
+		{"unknown document", Document{Name: "cookies", Version: "1"}, ErrUnknownDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acceptance, err := store.Accept(1, tt.document)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, Acceptance{Document: tt.document, AcceptedAt: clk.Now()}, acceptance)
+		})
+	}
+
+	// Accepting again keeps the first acceptance
+	clk.Advance(time.Hour)
+	again, err := store.Accept(1, terms)
+	require.NoError(t, err)
+	assert.Equal(t, clk.Now().Add(-time.Hour), again.AcceptedAt)
+
+	status := store.Status(1)
+	assert.Len(t, status.Accepted, 1)
+	assert.Equal(t, []Document{privacy}, status.Pending)
+	assert.Equal(t, Status{Accepted: []Acceptance{}, Pending: []Document{privacy, terms}}, store.Status(2))
+}
+
+func TestStore_Middleware(t *testing.T) {
+	store := NewStore(Options{Documents: map[string]string{"terms": "2024-06"}, Enforce: true, Exempt: []string{"/api/v1/me/consents"}})
+	_, err := store.Accept(1, terms)
+	require.NoError(t, err)
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name      string
+		path      string
+		principal *reqctx.Principal
+		expected  int
+	}{
+		{"anonymous", "/api/v1/users", nil, http.StatusNoContent},
+		{"accepted", "/api/v1/users", &reqctx.Principal{Subject: "1"}, http.StatusNoContent},
+		{"pending", "/api/v1/users", &reqctx.Principal{Subject: "2"}, http.StatusUnavailableForLegalReasons},
+		{"pending on an exempt path", "/api/v1/me/consents", &reqctx.Principal{Subject: "2"}, http.StatusNoContent},
+		{"api key", "/api/v1/users", &reqctx.Principal{Subject: "apikey:k1"}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.principal != nil {
+				req = req.WithContext(reqctx.WithPrincipal(req.Context(), *tt.principal))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			require.Equal(t, tt.expected, w.Code)
+			if w.Code == http.StatusUnavailableForLegalReasons {
+				var body RequiredError
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, ErrorCode, body.Code)
+				assert.Equal(t, []Document{terms}, body.Pending)
+			}
+		})
+	}
+
+	// Without enforcement, acceptance is only tracked
+	lenient := NewStore(Options{Documents: map[string]string{"terms": "2024-06"}})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req = req.WithContext(reqctx.WithPrincipal(req.Context(), reqctx.Principal{Subject: "2"}))
+	w := httptest.NewRecorder()
+	lenient.Middleware(http.NotFoundHandler()).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,218 @@
+// Package contract checks that the routes handlers serve agree with the
+// OpenAPI document generated from their annotations. Annotations are
+// written by hand next to the handlers, so they drift: a route is added
+// without a @Router line, a path parameter is renamed in one place only, or
+// a handler starts returning a status no @Failure line mentions. Tests use
+// Check to compare the route registry with the document, and a Recorder to
+// catch the statuses handlers actually return.
+package contract
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dazraf/go-api-example/internal/apidocs"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+// Problem is a disagreement between a route and the document
+type Problem struct {
+	// Endpoint is the method and documented path, e.g. "GET /api/v1/users/{id}"
+	Endpoint string
+	Message  string
+}
+
+func (p Problem) String() string {
+	return p.Endpoint + ": " + p.Message
+}
+
+// Check compares routes with the operations in spec. It reports routes the
+// document leaves out, operations no route serves, and path parameters
+// named differently, missing or extra on either side.
+func Check(spec *apidocs.Spec, routes []router.Route) []Problem {
+	var problems []Problem
+	served := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		path, params := documentedPath(route.Path)
+		endpoint := route.Method + " " + path
+		if served[endpoint] {
+			problems = append(problems, Problem{Endpoint: endpoint, Message: "served by more than one route"})
+			continue
+		}
+		served[endpoint] = true
+
+		operation, ok := lookup(spec, route.Method, path)
+		if !ok {
+			problems = append(problems, Problem{Endpoint: endpoint, Message: "served but not documented"})
+			continue
+		}
+		documented := pathParams(operation)
+		for _, param := range params {
+			if !slices.Contains(documented, param) {
+				problems = append(problems, Problem{Endpoint: endpoint, Message: fmt.Sprintf("path parameter %q is not documented", param)})
+			}
+		}
+		for _, param := range documented {
+			if !slices.Contains(params, param) {
+				problems = append(problems, Problem{Endpoint: endpoint, Message: fmt.Sprintf("documented path parameter %q is not in the route", param)})
+			}
+		}
+	}
+
+	for _, path := range sortedKeys(spec.Paths) {
+		for _, method := range sortedKeys(spec.Paths[path]) {
+			endpoint := strings.ToUpper(method) + " " + strings.TrimSuffix(spec.BasePath, "/") + path
+			if !served[endpoint] {
+				problems = append(problems, Problem{Endpoint: endpoint, Message: "documented but not served"})
+			}
+		}
+	}
+	return problems
+}
+
+// documentedPath returns a route's path as the document writes it, with
+// wildcards such as {file...} written {file}, and its path parameters in
+// order
+func documentedPath(path string) (string, []string) {
+	var params []string
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		name, ok := strings.CutPrefix(part, "{")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "}"), "...")
+		params = append(params, name)
+		parts[i] = "{" + name + "}"
+	}
+	return strings.Join(parts, "/"), params
+}
+
+// lookup finds the operation for method on path, which includes the
+// document's base path
+func lookup(spec *apidocs.Spec, method, path string) (apidocs.Operation, bool) {
+	basePath := strings.TrimSuffix(spec.BasePath, "/")
+	relative, ok := strings.CutPrefix(path, basePath)
+	if !ok {
+		return apidocs.Operation{}, false
+	}
+	operation, ok := spec.Paths[relative][strings.ToLower(method)]
+	return operation, ok
+}
+
+// pathParams returns the names of an operation's path parameters
+func pathParams(operation apidocs.Operation) []string {
+	var names []string
+	for _, param := range operation.Parameters {
+		if param.In == "path" {
+			names = append(names, param.Name)
+		}
+	}
+	return names
+}
+
+// Recorder records the statuses routes respond with, so they can be
+// compared with the responses documented for them. It is safe for
+// concurrent use.
+type Recorder struct {
+	mutex    sync.Mutex
+	statuses map[string]map[int]bool // keyed by endpoint
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{statuses: make(map[string]map[int]bool)}
+}
+
+// Wrap returns routes with each handler wrapped to record its statuses
+func (rec *Recorder) Wrap(routes []router.Route) []router.Route {
+	wrapped := make([]router.Route, len(routes))
+	for i, route := range routes {
+		path, _ := documentedPath(route.Path)
+		endpoint := route.Method + " " + path
+		next := route.Handler
+		route.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(status, r)
+			rec.record(endpoint, status.code())
+		})
+		wrapped[i] = route
+	}
+	return wrapped
+}
+
+func (rec *Recorder) record(endpoint string, status int) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	if rec.statuses[endpoint] == nil {
+		rec.statuses[endpoint] = make(map[int]bool)
+	}
+	rec.statuses[endpoint][status] = true
+}
+
+// Undocumented reports the statuses recorded for each endpoint that spec
+// does not document for it. Operations with a default response document
+// every status. Endpoints missing from spec are left to Check.
+func (rec *Recorder) Undocumented(spec *apidocs.Spec) []Problem {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	var problems []Problem
+	for _, endpoint := range sortedKeys(rec.statuses) {
+		method, path, _ := strings.Cut(endpoint, " ")
+		operation, ok := lookup(spec, method, path)
+		if !ok {
+			continue
+		}
+		if _, ok := operation.Responses["default"]; ok {
+			continue
+		}
+		for _, status := range slices.Sorted(maps.Keys(rec.statuses[endpoint])) {
+			if _, ok := operation.Responses[strconv.Itoa(status)]; !ok {
+				problems = append(problems, Problem{Endpoint: endpoint, Message: fmt.Sprintf("responded %d, which is not documented", status)})
+			}
+		}
+	}
+	return problems
+}
+
+// statusWriter remembers the status a handler writes
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// code returns the status written, 200 if the handler wrote nothing
+func (s *statusWriter) code() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
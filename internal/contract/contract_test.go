@@ -0,0 +1,151 @@
+package contract
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/apidocs"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+const testDoc = `{
+	"swagger": "2.0",
+	"basePath": "/",
+	"paths": {
+		"/api/v1/users": {
+			"get": {"responses": {"200": {"description": "OK"}}},
+			"post": {"responses": {"201": {"description": "Created"}, "400": {"description": "Bad Request"}}}
+		},
+		"/api/v1/users/{id}": {
+			"get": {
+				"parameters": [{"type": "integer", "name": "id", "in": "path", "required": true}],
+				"responses": {"200": {"description": "OK"}, "404": {"description": "Not Found"}}
+			},
+			"delete": {
+				"parameters": [{"type": "integer", "name": "userID", "in": "path", "required": true}],
+				"responses": {"default": {"description": "Anything"}}
+			}
+		},
+		"/files/{path}": {
+			"get": {
+				"parameters": [{"type": "string", "name": "path", "in": "path", "required": true}],
+				"responses": {"200": {"description": "OK"}}
+			}
+		},
+		"/health": {
+			"get": {"responses": {"200": {"description": "OK"}}}
+		}
+	}
+}`
+
+func loadTestSpec(t *testing.T) *apidocs.Spec {
+	t.Helper()
+	spec, err := apidocs.Load(testDoc)
+	require.NoError(t, err)
+	return spec
+}
+
+func status(code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	})
+}
+
+func TestCheck(t *testing.T) {
+	routes := []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/users", Handler: status(http.StatusOK)},
+		{Method: http.MethodPost, Path: "/api/v1/users", Handler: status(http.StatusCreated)},
+		{Method: http.MethodGet, Path: "/api/v1/users/{id}", Handler: status(http.StatusOK)},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{id}", Handler: status(http.StatusNoContent)},
+		{Method: http.MethodGet, Path: "/files/{path...}", Handler: status(http.StatusOK)},
+		{Method: http.MethodPut, Path: "/api/v1/users/{id}", Handler: status(http.StatusOK)},
+		{Method: http.MethodGet, Path: "/api/v1/users", Handler: status(http.StatusOK)},
+	}
+
+	problems := Check(loadTestSpec(t), routes)
+
+	assert.Equal(t, []Problem{
+		{Endpoint: "DELETE /api/v1/users/{id}", Message: `path parameter "id" is not documented`},
+		{Endpoint: "DELETE /api/v1/users/{id}", Message: `documented path parameter "userID" is not in the route`},
+		{Endpoint: "PUT /api/v1/users/{id}", Message: "served but not documented"},
+		{Endpoint: "GET /api/v1/users", Message: "served by more than one route"},
+		{Endpoint: "GET /health", Message: "documented but not served"},
+	}, problems)
+}
+
+func TestCheck_Agreeing(t *testing.T) {
+	spec := loadTestSpec(t)
+	delete(spec.Paths["/api/v1/users/{id}"], "delete")
+	routes := []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/users", Handler: status(http.StatusOK)},
+		{Method: http.MethodPost, Path: "/api/v1/users", Handler: status(http.StatusCreated)},
+		{Method: http.MethodGet, Path: "/api/v1/users/{id}", Handler: status(http.StatusOK)},
+		{Method: http.MethodGet, Path: "/files/{path...}", Handler: status(http.StatusOK)},
+		{Method: http.MethodGet, Path: "/health", Handler: status(http.StatusOK)},
+	}
+
+	assert.Empty(t, Check(spec, routes))
+}
+
+func TestRecorder_Undocumented(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		handler  http.Handler
+		expected []Problem
+	}{
+		{
+			name:    "documented status",
+			method:  http.MethodPost,
+			path:    "/api/v1/users",
+			handler: status(http.StatusBadRequest),
+		},
+		{
+			name:     "undocumented status",
+			method:   http.MethodPost,
+			path:     "/api/v1/users",
+			handler:  status(http.StatusConflict),
+			expected: []Problem{{Endpoint: "POST /api/v1/users", Message: "responded 409, which is not documented"}},
+		},
+		{
+			name:   "body without a status is a 200",
+			method: http.MethodGet,
+			path:   "/api/v1/users",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("[]"))
+			}),
+		},
+		{
+			name:    "default response documents every status",
+			method:  http.MethodDelete,
+			path:    "/api/v1/users/{id}",
+			handler: status(http.StatusTeapot),
+		},
+		{
+			name:    "undocumented endpoints are left to Check",
+			method:  http.MethodPatch,
+			path:    "/api/v1/users/{id}",
+			handler: status(http.StatusTeapot),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := NewRecorder()
+			mux := http.NewServeMux()
+			for _, route := range rec.Wrap([]router.Route{{Method: tt.method, Path: tt.path, Handler: tt.handler}}) {
+				mux.Handle(route.Method+" "+route.Path, route.Handler)
+			}
+
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/api/v1/users/1", nil))
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/api/v1/users", nil))
+
+			assert.Equal(t, tt.expected, rec.Undocumented(loadTestSpec(t)))
+		})
+	}
+}
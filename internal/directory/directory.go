@@ -0,0 +1,202 @@
+// Package directory provisions users from an external directory, such as a
+// SCIM-capable identity provider. A Syncer pulls every directory user and
+// reconciles the local store with them, matching users by email: new users
+// are created, changed names are updated, and users the directory marks
+// inactive are deleted. Users the directory does not list are left alone,
+// so local accounts survive. A dry run reports what a sync would change
+// without changing anything.
+package directory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// logger logs scheduled directory syncs
+var logger = logging.Named(logging.Jobs)
+
+// ErrSyncRunning is returned when a sync is started while another runs
+var ErrSyncRunning = errors.New("a directory sync is already running")
+
+// Entry is a user as the directory knows them
+type Entry struct {
+	// ExternalID is the directory's identifier for the user
+	ExternalID string
+	Name       string
+	Email      string
+	Active     bool
+}
+
+// Source lists every user in a directory
+type Source interface {
+	Users(ctx context.Context) ([]Entry, error)
+}
+
+// Report describes the changes a sync made, or would make in a dry run
+type Report struct {
+	DryRun     bool      `json:"dry_run" example:"false"`
+	StartedAt  time.Time `json:"started_at" example:"2024-01-02T15:04:05Z"`
+	FinishedAt time.Time `json:"finished_at" example:"2024-01-02T15:04:06Z"`
+	// Created, Updated and Deactivated list the users changed
+	Created     []Change `json:"created"`
+	Updated     []Change `json:"updated"`
+	Deactivated []Change `json:"deactivated"`
+	// Unchanged counts directory users that already matched
+	Unchanged int `json:"unchanged" example:"40"`
+	// Skipped lists directory entries that could not be applied, and why
+	Skipped []string `json:"skipped"`
+}
+
+// Change is a user a sync created, updated or deactivated
+type Change struct {
+	// ID is the local user's ID; zero for users a dry run would create
+	ID         int64  `json:"id,omitempty" example:"1"`
+	ExternalID string `json:"external_id" example:"2819c223-7f76-453a-919d-413861904646"`
+	Email      string `json:"email" example:"john@example.com"`
+	Name       string `json:"name" example:"John Doe"`
+	// PreviousName is the name replaced by an update
+	PreviousName string `json:"previous_name,omitempty" example:"John"`
+}
+
+// Syncer reconciles a user store with a directory
+type Syncer struct {
+	source    Source
+	userStore store.UserStore
+	clock     clock.Clock
+
+	// running makes syncs exclusive, so scheduled and requested syncs never
+	// apply the same changes twice
+	running sync.Mutex
+}
+
+// NewSyncer creates a Syncer applying source's users to userStore
+func NewSyncer(source Source, userStore store.UserStore, clk clock.Clock) *Syncer {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &Syncer{source: source, userStore: userStore, clock: clk}
+}
+
+// Sync reconciles the store with the directory, changing nothing when
+// dryRun is set
+func (s *Syncer) Sync(ctx context.Context, dryRun bool) (Report, error) {
+	if !s.running.TryLock() {
+		return Report{}, ErrSyncRunning
+	}
+	defer s.running.Unlock()
+
+	report := Report{
+		DryRun:      dryRun,
+		StartedAt:   s.clock.Now().UTC(),
+		Created:     []Change{},
+		Updated:     []Change{},
+		Deactivated: []Change{},
+		Skipped:     []string{},
+	}
+	entries, err := s.source.Users(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to list directory users: %w", err)
+	}
+	local, err := s.userStore.GetAll()
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to list local users: %w", err)
+	}
+	byEmail := make(map[string]store.User, len(local))
+	for _, user := range local {
+		byEmail[normalizeEmail(user.Email)] = user
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return Report{}, err
+		}
+		email := normalizeEmail(entry.Email)
+		switch {
+		case email == "":
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: no email", entry.ExternalID))
+			continue
+		case seen[email]:
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %s is listed more than once", entry.ExternalID, entry.Email))
+			continue
+		}
+		seen[email] = true
+
+		change := Change{ExternalID: entry.ExternalID, Email: entry.Email, Name: entry.Name}
+		user, exists := byEmail[email]
+		switch {
+		case !exists && !entry.Active:
+			report.Unchanged++
+		case !exists:
+			if !dryRun {
+				created, err := s.userStore.Create(store.User{Name: entry.Name, Email: entry.Email})
+				if err != nil {
+					report.Skipped = append(report.Skipped, fmt.Sprintf("%s: failed to create: %v", entry.ExternalID, err))
+					continue
+				}
+				change.ID = created.ID
+			}
+			report.Created = append(report.Created, change)
+		case !entry.Active:
+			change.ID = user.ID
+			if !dryRun {
+				if err := s.userStore.Delete(user.ID); err != nil {
+					report.Skipped = append(report.Skipped, fmt.Sprintf("%s: failed to deactivate: %v", entry.ExternalID, err))
+					continue
+				}
+			}
+			report.Deactivated = append(report.Deactivated, change)
+		case entry.Name != "" && entry.Name != user.Name:
+			change.ID = user.ID
+			change.PreviousName = user.Name
+			if !dryRun {
+				user.Name = entry.Name
+				if _, err := s.userStore.Update(user.ID, user); err != nil {
+					report.Skipped = append(report.Skipped, fmt.Sprintf("%s: failed to update: %v", entry.ExternalID, err))
+					continue
+				}
+			}
+			report.Updated = append(report.Updated, change)
+		default:
+			report.Unchanged++
+		}
+	}
+
+	sort.Strings(report.Skipped)
+	report.FinishedAt = s.clock.Now().UTC()
+	return report, nil
+}
+
+// Run syncs every interval until ctx is done, logging what changed
+func (s *Syncer) Run(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.Sync(ctx, dryRun)
+			if err != nil {
+				logger.Error("Failed to sync users from the directory", "error", err)
+				continue
+			}
+			logger.Info("Directory sync", "dry_run", dryRun, "created", len(report.Created), "updated", len(report.Updated),
+				"deactivated", len(report.Deactivated), "unchanged", report.Unchanged, "skipped", len(report.Skipped))
+		}
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
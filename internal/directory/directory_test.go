@@ -0,0 +1,165 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// scimServer serves users as a SCIM provider would, pageSize at a time
+func scimServer(t *testing.T, pageSize int, users ...map[string]any) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "/scim/v2/Users", r.URL.Path)
+		start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		assert.Equal(t, pageSize, count)
+
+		page := []map[string]any{}
+		if start >= 1 && start <= len(users) {
+			page = users[start-1 : min(start-1+count, len(users))]
+		}
+		w.Header().Set("Content-Type", "application/scim+json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"schemas":      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+			"totalResults": len(users),
+			"startIndex":   start,
+			"itemsPerPage": len(page),
+			"Resources":    page,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSCIM_Users(t *testing.T) {
+	server := scimServer(t, 2,
+		map[string]any{
+			"id": "1", "userName": "jane", "name": map[string]any{"formatted": "Jane Roe"},
+			"emails": []any{map[string]any{"value": "jane@home.example"}, map[string]any{"value": "jane@example.com", "primary": true}},
+		},
+		map[string]any{"id": "2", "userName": "sam@example.com", "name": map[string]any{"givenName": "Sam", "familyName": "Poe"}, "active": false},
+		map[string]any{"id": "3", "userName": "lee", "displayName": "Lee", "emails": []any{map[string]any{"value": "lee@example.com"}}, "active": true},
+	)
+
+	entries, err := NewSCIM(SCIMOptions{URL: server.URL + "/scim/v2/", Token: "secret", PageSize: 2}).Users(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{ExternalID: "1", Name: "Jane Roe", Email: "jane@example.com", Active: true},
+		{ExternalID: "2", Name: "Sam Poe", Email: "sam@example.com", Active: false},
+		{ExternalID: "3", Name: "Lee", Email: "lee@example.com", Active: true},
+	}, entries)
+
+	_, err = NewSCIM(SCIMOptions{URL: server.URL + "/scim/v2"}).Users(context.Background())
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+// fakeSource is a directory listing fixed entries
+type fakeSource struct {
+	entries []Entry
+	err     error
+}
+
+func (s fakeSource) Users(context.Context) ([]Entry, error) {
+	return s.entries, s.err
+}
+
+func TestSyncer_Sync(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	userStore := store.NewMemoryUserStore()
+	for _, user := range []store.User{
+		{Name: "Jane", Email: "jane@example.com"},
+		{Name: "Sam Poe", Email: "sam@example.com"},
+		{Name: "Lee", Email: "LEE@example.com"},
+		{Name: "Local Only", Email: "local@example.com"},
+	} {
+		_, err := userStore.Create(user)
+		require.NoError(t, err)
+	}
+	source := fakeSource{entries: []Entry{
+		{ExternalID: "a", Name: "Jane Roe", Email: "jane@example.com", Active: true},
+		{ExternalID: "b", Name: "Sam Poe", Email: "sam@example.com", Active: false},
+		{ExternalID: "c", Name: "Lee", Email: "lee@example.com", Active: true},
+		{ExternalID: "d", Name: "Kim", Email: "kim@example.com", Active: true},
+		{ExternalID: "e", Name: "Gone", Email: "gone@example.com", Active: false},
+		{ExternalID: "f", Name: "No Email", Active: true},
+		{ExternalID: "g", Name: "Kim Again", Email: "Kim@example.com", Active: true},
+	}}
+	syncer := NewSyncer(source, userStore, clock.NewFake(start))
+
+	expected := Report{
+		DryRun:     true,
+		StartedAt:  start,
+		FinishedAt: start,
+		Created:    []Change{{ExternalID: "d", Email: "kim@example.com", Name: "Kim"}},
+		Updated:    []Change{{ID: 1, ExternalID: "a", Email: "jane@example.com", Name: "Jane Roe", PreviousName: "Jane"}},
+		Deactivated: []Change{
+			{ID: 2, ExternalID: "b", Email: "sam@example.com", Name: "Sam Poe"},
+		},
+		Unchanged: 2,
+		Skipped:   []string{"f: no email", "g: Kim@example.com is listed more than once"},
+	}
+
+	// A dry run reports the changes without making them
+	report, err := syncer.Sync(context.Background(), true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report)
+	count, err := userStore.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	report, err = syncer.Sync(context.Background(), false)
+	require.NoError(t, err)
+	expected.DryRun = false
+	expected.Created[0].ID = 5
+	assert.Equal(t, expected, report)
+
+	users, err := userStore.GetAll()
+	require.NoError(t, err)
+	names := map[string]string{}
+	for _, user := range users {
+		names[user.Email] = user.Name
+	}
+	assert.Equal(t, map[string]string{
+		"jane@example.com":  "Jane Roe",
+		"LEE@example.com":   "Lee",
+		"local@example.com": "Local Only",
+		"kim@example.com":   "Kim",
+	}, names)
+
+	// Syncing again changes nothing
+	report, err = syncer.Sync(context.Background(), false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+	assert.Empty(t, report.Updated)
+	assert.Empty(t, report.Deactivated)
+	assert.Equal(t, 5, report.Unchanged)
+
+	_, err = NewSyncer(fakeSource{err: errors.New("unreachable")}, userStore, nil).Sync(context.Background(), false)
+	assert.ErrorContains(t, err, "failed to list directory users: unreachable")
+}
+
+func TestSyncer_Exclusive(t *testing.T) {
+	syncer := NewSyncer(fakeSource{}, store.NewMemoryUserStore(), nil)
+	syncer.running.Lock()
+	_, err := syncer.Sync(context.Background(), true)
+	assert.ErrorIs(t, err, ErrSyncRunning)
+	syncer.running.Unlock()
+
+	_, err = syncer.Sync(context.Background(), true)
+	assert.NoError(t, err)
+}
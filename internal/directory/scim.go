@@ -0,0 +1,96 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/scim"
+)
+
+// SCIMOptions configures a SCIM source
+type SCIMOptions struct {
+	// URL is the SCIM service's base URL, under which /Users is listed
+	URL string
+	// Token is sent as a bearer token, if set
+	Token string
+	// PageSize is the number of users asked for per request
+	PageSize int
+	Client   *http.Client
+}
+
+// SCIM lists users from a SCIM 2.0 service provider (RFC 7644)
+type SCIM struct {
+	opts SCIMOptions
+}
+
+// NewSCIM creates a source listing users from the SCIM service at opts.URL
+func NewSCIM(opts SCIMOptions) *SCIM {
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+	if opts.PageSize <= 0 {
+		opts.PageSize = 100
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &SCIM{opts: opts}
+}
+
+// entry converts u to an Entry
+func entry(u scim.User) Entry {
+	return Entry{ExternalID: u.ID, Name: u.FullName(), Email: u.PrimaryEmail(), Active: u.IsActive()}
+}
+
+// Users lists every user, a page at a time
+func (s *SCIM) Users(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+	for start := 1; ; {
+		page, err := s.page(ctx, start)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page.Resources {
+			entries = append(entries, entry(user))
+		}
+		start += len(page.Resources)
+		if len(page.Resources) == 0 || start > page.TotalResults {
+			return entries, nil
+		}
+	}
+}
+
+// page fetches the users from startIndex, which is 1-based
+func (s *SCIM) page(ctx context.Context, startIndex int) (scim.ListResponse, error) {
+	query := url.Values{
+		"startIndex": {strconv.Itoa(startIndex)},
+		"count":      {strconv.Itoa(s.opts.PageSize)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.opts.URL+"/Users?"+query.Encode(), nil)
+	if err != nil {
+		return scim.ListResponse{}, fmt.Errorf("failed to create SCIM request: %w", err)
+	}
+	req.Header.Set("Accept", scim.ContentType+", application/json")
+	if s.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.Token)
+	}
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return scim.ListResponse{}, fmt.Errorf("failed to list SCIM users: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return scim.ListResponse{}, fmt.Errorf("failed to list SCIM users: %s", resp.Status)
+	}
+
+	var page scim.ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return scim.ListResponse{}, fmt.Errorf("failed to decode SCIM users: %w", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,134 @@
+// Package discovery registers the running instance with a service registry
+// so clients can find it without a static list of upstreams.
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Supported registry providers
+const (
+	ProviderConsul = "consul"
+)
+
+// Service describes the instance being registered
+type Service struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	// CheckURL is polled by the registry every CheckInterval; the instance
+	// is removed DeregisterAfter its check starts failing
+	CheckURL        string
+	CheckInterval   time.Duration
+	DeregisterAfter time.Duration
+}
+
+// Registrar adds and removes services in a registry
+type Registrar interface {
+	Register(ctx context.Context, service Service) error
+	Deregister(ctx context.Context, id string) error
+}
+
+// New creates the registrar for provider
+func New(provider, endpoint, token string) (Registrar, error) {
+	switch provider {
+	case ProviderConsul:
+		if endpoint == "" {
+			return nil, fmt.Errorf("discovery provider %s needs an endpoint", provider)
+		}
+		return &consul{endpoint: strings.TrimSuffix(endpoint, "/"), token: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery provider %q, expected %s", provider, ProviderConsul)
+	}
+}
+
+// consul registers services with the local Consul agent
+type consul struct {
+	endpoint string
+	token    string
+}
+
+// consulRegistration is the agent service registration payload
+type consulRegistration struct {
+	ID      string       `json:"ID"`
+	Name    string       `json:"Name"`
+	Address string       `json:"Address,omitempty"`
+	Port    int          `json:"Port,omitempty"`
+	Tags    []string     `json:"Tags,omitempty"`
+	Check   *consulCheck `json:"Check,omitempty"`
+}
+
+type consulCheck struct {
+	HTTP                           string `json:"HTTP"`
+	Interval                       string `json:"Interval"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+func (c *consul) Register(ctx context.Context, service Service) error {
+	registration := consulRegistration{
+		ID:      service.ID,
+		Name:    service.Name,
+		Address: service.Address,
+		Port:    service.Port,
+		Tags:    service.Tags,
+	}
+	if service.CheckURL != "" {
+		registration.Check = &consulCheck{
+			HTTP:     service.CheckURL,
+			Interval: service.CheckInterval.String(),
+		}
+		if service.DeregisterAfter > 0 {
+			registration.Check.DeregisterCriticalServiceAfter = service.DeregisterAfter.String()
+		}
+	}
+
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	if err := c.put(ctx, "/v1/agent/service/register", body); err != nil {
+		return fmt.Errorf("failed to register %s: %w", service.ID, err)
+	}
+	return nil
+}
+
+func (c *consul) Deregister(ctx context.Context, id string) error {
+	if err := c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(id), nil); err != nil {
+		return fmt.Errorf("failed to deregister %s: %w", id, err)
+	}
+	return nil
+}
+
+// put sends a request to the agent API
+func (c *consul) put(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
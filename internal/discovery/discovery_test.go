@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsul_RegisterAndDeregister(t *testing.T) {
+	var (
+		registered   map[string]any
+		deregistered string
+	)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "secret", r.Header.Get("X-Consul-Token"))
+
+		switch r.URL.Path {
+		case "/v1/agent/service/register":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&registered))
+		case "/v1/agent/service/deregister/api-1":
+			deregistered = "api-1"
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer agent.Close()
+
+	registrar, err := New(ProviderConsul, agent.URL+"/", "secret")
+	require.NoError(t, err)
+
+	err = registrar.Register(context.Background(), Service{
+		ID:              "api-1",
+		Name:            "api",
+		Address:         "10.0.0.1",
+		Port:            8080,
+		Tags:            []string{"v1"},
+		CheckURL:        "http://10.0.0.1:8080/readyz",
+		CheckInterval:   10 * time.Second,
+		DeregisterAfter: time.Minute,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "api-1", registered["ID"])
+	assert.Equal(t, "api", registered["Name"])
+	assert.Equal(t, float64(8080), registered["Port"])
+	assert.Equal(t, []any{"v1"}, registered["Tags"])
+	assert.Equal(t, map[string]any{
+		"HTTP":                           "http://10.0.0.1:8080/readyz",
+		"Interval":                       "10s",
+		"DeregisterCriticalServiceAfter": "1m0s",
+	}, registered["Check"])
+
+	require.NoError(t, registrar.Deregister(context.Background(), "api-1"))
+	assert.Equal(t, "api-1", deregistered)
+
+	err = registrar.Deregister(context.Background(), "unknown")
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    string
+		endpoint    string
+		expectError bool
+	}{
+		{name: "consul", provider: ProviderConsul, endpoint: "http://localhost:8500"},
+		{name: "missing endpoint", provider: ProviderConsul, expectError: true},
+		{name: "unknown provider", provider: "zookeeper", endpoint: "http://localhost:2181", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.provider, tt.endpoint, "")
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
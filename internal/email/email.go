@@ -0,0 +1,64 @@
+// Package email normalizes email addresses, so addresses that differ only
+// in case, in Unicode normalization form or in how an internationalized
+// domain is written, such as bücher.de and xn--bcher-kva.de, compare equal.
+package email
+
+import (
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// domains maps domains to ASCII as lookups do, lower casing them, and
+// rejects those that are not valid host names
+var domains = idna.New(idna.MapForLookup(), idna.VerifyDNSLength(true), idna.BidiRule())
+
+// Normalize returns the form of address to compare and index it by: in
+// Unicode NFC and lower case, with its domain in ASCII (punycode)
+func Normalize(address string) string {
+	address = norm.NFC.String(strings.TrimSpace(address))
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return strings.ToLower(address)
+	}
+	return strings.ToLower(address[:at]) + "@" + NormalizeDomain(address[at+1:])
+}
+
+// NormalizeDomain returns domain in lower case and in its ASCII form.
+// Domains that are not valid domain names are only lower cased.
+func NormalizeDomain(domain string) string {
+	ascii, err := domains.ToASCII(domain)
+	if err != nil {
+		return strings.ToLower(norm.NFC.String(domain))
+	}
+	return ascii
+}
+
+// Domain returns the normalized domain of address, or "" when it has none
+func Domain(address string) string {
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return ""
+	}
+	return NormalizeDomain(strings.TrimSpace(address[at+1:]))
+}
+
+// ValidDomain reports whether domain is a valid domain name, written in
+// Unicode or punycode
+func ValidDomain(domain string) bool {
+	_, err := domains.ToASCII(domain)
+	return err == nil
+}
+
+// Valid reports whether address is a bare email address, such as
+// john@example.com, with a valid domain. Internationalized addresses are
+// accepted, with their domains written in Unicode or punycode.
+func Valid(address string) bool {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return false
+	}
+	return ValidDomain(address[strings.LastIndexByte(address, '@')+1:])
+}
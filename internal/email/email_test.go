@@ -0,0 +1,62 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{name: "ascii", address: " John@Example.COM ", want: "john@example.com"},
+		{name: "internationalized domain", address: "jörg@Bücher.de", want: "jörg@xn--bcher-kva.de"},
+		{name: "punycode domain", address: "JÖRG@xn--bcher-kva.de", want: "jörg@xn--bcher-kva.de"},
+		{name: "decomposed", address: "jo\u0308rg@bu\u0308cher.de", want: "jörg@xn--bcher-kva.de"},
+		{name: "invalid domain", address: "john@Exa_mple.com", want: "john@exa_mple.com"},
+		{name: "no domain", address: "John", want: "john"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Normalize(tt.address))
+		})
+	}
+
+	assert.Equal(t, "xn--bcher-kva.de", Domain("jörg@BÜCHER.de"))
+	assert.Equal(t, "", Domain("jörg"))
+}
+
+func TestValidDomain(t *testing.T) {
+	for domain, want := range map[string]bool{
+		"example.com":      true,
+		"bücher.de":        true,
+		"xn--bcher-kva.de": true,
+		"localhost":        true,
+		"exa_mple.com":     false,
+		"-example.com":     false,
+		"bücher..de":       false,
+		"xn--zz.com":       false,
+	} {
+		assert.Equal(t, want, ValidDomain(domain), domain)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for address, want := range map[string]bool{
+		"john@example.com":        true,
+		"jörg@bücher.de":          true,
+		"jörg@xn--bcher-kva.de":   true,
+		"john+news@example.com":   true,
+		"":                        false,
+		"john":                    false,
+		"john@":                   false,
+		"John <john@example.com>": false,
+		" john@example.com":       false,
+		"john@exa_mple.com":       false,
+	} {
+		assert.Equal(t, want, Valid(address), address)
+	}
+}
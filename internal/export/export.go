@@ -0,0 +1,141 @@
+// Package export writes tables of records, such as users, as CSV or Excel
+// workbooks. Rows are written as they are produced, so exports of any size
+// stream without being held in memory.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Format is a file format tables can be exported as
+type Format string
+
+const (
+	// FormatCSV is comma-separated values with a header row
+	FormatCSV Format = "csv"
+	// FormatXLSX is an Excel workbook with a single sheet
+	FormatXLSX Format = "xlsx"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{FormatCSV, FormatXLSX}
+
+// ErrUnknownFormat is returned for formats that are not supported
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// ContentType returns the media type of files in the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// BOM is the UTF-8 byte order mark. Excel reads CSV files starting with it
+// as UTF-8, rather than in the system's legacy code page, so non-ASCII text
+// such as accented names is not garbled.
+const BOM = "\ufeff"
+
+// Type is the type of a column's values, which decides how they are written
+type Type int
+
+const (
+	// String columns hold text
+	String Type = iota
+	// Number columns hold ints, int64s or float64s
+	Number
+	// Time columns hold time.Times, written in UTC
+	Time
+)
+
+// Column describes a column of a table
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Writer writes the rows of a table. Each row has a value per column, of
+// the column's type, or nil for an empty cell.
+type Writer interface {
+	WriteRow(values []any) error
+	// Close finishes the file, without closing the underlying writer
+	Close() error
+}
+
+// NewWriter starts a table with columns in w, writing its header row
+func NewWriter(w io.Writer, format Format, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatXLSX:
+		return newXLSXWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("%w %q, expected one of %v", ErrUnknownFormat, format, Formats)
+	}
+}
+
+// csvWriter writes tables as CSV
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, column := range columns {
+		cw.record[i] = column.Name
+	}
+	if err := cw.w.Write(cw.record); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// WriteRow writes values as a CSV record
+func (cw *csvWriter) WriteRow(values []any) error {
+	for i := range cw.columns {
+		cw.record[i] = ""
+		if i < len(values) {
+			text, err := formatValue(values[i])
+			if err != nil {
+				return fmt.Errorf("column %s: %w", cw.columns[i].Name, err)
+			}
+			cw.record[i] = text
+		}
+	}
+	return cw.w.Write(cw.record)
+}
+
+// Close flushes buffered records
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// formatValue returns v as text, with times in RFC 3339
+func formatValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
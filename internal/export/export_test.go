@@ -0,0 +1,126 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testColumns = []Column{
+	{Name: "id", Type: Number},
+	{Name: "name", Type: String},
+	{Name: "created_at", Type: Time},
+}
+
+func export(t *testing.T, format Format, rows ...[]any) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, testColumns)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, w.WriteRow(row))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestNewWriter_UnknownFormat(t *testing.T) {
+	_, err := NewWriter(io.Discard, "pdf", testColumns)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestCSVWriter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	data := export(t, FormatCSV,
+		[]any{1, "Doe, John", created},
+		[]any{2, "Jane", nil},
+	)
+	assert.Equal(t, "id,name,created_at\n1,\"Doe, John\",2024-01-02T14:04:05Z\n2,Jane,\n", string(data))
+
+	w, err := NewWriter(io.Discard, FormatCSV, testColumns)
+	require.NoError(t, err)
+	assert.Error(t, w.WriteRow([]any{struct{}{}}))
+}
+
+// sheet is the part of a worksheet the tests read
+type sheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			T      string `xml:"t,attr"`
+			S      int    `xml:"s,attr"`
+			V      string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+	AutoFilter struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"autoFilter"`
+}
+
+func TestXLSXWriter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	data := export(t, FormatXLSX,
+		[]any{1, "=SUM(A1) & <b>", created},
+		[]any{int64(2), "Jane\x01", nil},
+	)
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	parts := map[string][]byte{}
+	for _, file := range archive.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		parts[file.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		require.Contains(t, parts, name)
+		assert.NoError(t, xml.Unmarshal(parts[name], new(struct{})), name)
+	}
+
+	var s sheet
+	require.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &s))
+	require.Len(t, s.Rows, 3)
+	assert.Equal(t, "A1:C3", s.AutoFilter.Ref)
+
+	header := s.Rows[0].Cells
+	require.Len(t, header, 3)
+	assert.Equal(t, "created_at", header[2].Inline)
+	assert.Equal(t, styleHeader, header[2].S)
+
+	row := s.Rows[1].Cells
+	require.Len(t, row, 3)
+	assert.Equal(t, "A2", row[0].R)
+	assert.Equal(t, "", row[0].T, "numbers are typed")
+	assert.Equal(t, "1", row[0].V)
+	assert.Equal(t, "inlineStr", row[1].T, "strings are never formulas")
+	assert.Equal(t, "=SUM(A1) & <b>", row[1].Inline)
+	assert.Equal(t, styleDateTime, row[2].S)
+	assert.Equal(t, "45293.5", row[2].V)
+
+	row = s.Rows[2].Cells
+	require.Len(t, row, 2, "nil values are empty cells")
+	assert.Equal(t, "2", row[0].V)
+	assert.Equal(t, "Jane�", row[1].Inline)
+
+	w, err := NewWriter(io.Discard, FormatXLSX, testColumns)
+	require.NoError(t, err)
+	assert.Error(t, w.WriteRow([]any{"one"}))
+	assert.Error(t, w.WriteRow([]any{math.NaN()}))
+}
+
+func TestCellName(t *testing.T) {
+	for i, name := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, name, cellName(i))
+	}
+}
@@ -0,0 +1,235 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// xlsxMaxRows is the most rows an Excel sheet holds, including the header
+const xlsxMaxRows = 1 << 20
+
+// Cell styles, indexes into cellXfs in xlsxStyles
+const (
+	styleHeader   = 1
+	styleDateTime = 2
+)
+
+// xlsxEpoch is day zero of Excel's date serial numbers
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxWriter writes tables as an Excel workbook. The sheet is streamed into
+// the zip archive row by row; the small parts describing the workbook are
+// written after it, as zip entries can come in any order.
+type xlsxWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	columns []Column
+	rows    int
+}
+
+func newXLSXWriter(w io.Writer, columns []Column) (*xlsxWriter, error) {
+	archive := zip.NewWriter(w)
+	entry, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw := &xlsxWriter{zip: archive, sheet: bufio.NewWriter(entry), columns: columns}
+
+	xw.sheet.WriteString(xml.Header)
+	xw.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	// Freeze the header row so it stays in view while scrolling
+	xw.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	xw.sheet.WriteString(`<cols>`)
+	for i, column := range columns {
+		fmt.Fprintf(xw.sheet, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, columnWidth(column.Type))
+	}
+	xw.sheet.WriteString(`</cols><sheetData>`)
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := xw.writeRow(header, true); err != nil {
+		return nil, err
+	}
+	return xw, nil
+}
+
+// WriteRow writes values as a row of the sheet
+func (xw *xlsxWriter) WriteRow(values []any) error {
+	if xw.rows >= xlsxMaxRows {
+		return fmt.Errorf("xlsx sheets hold at most %d rows", xlsxMaxRows)
+	}
+	return xw.writeRow(values, false)
+}
+
+func (xw *xlsxWriter) writeRow(values []any, header bool) error {
+	xw.rows++
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.rows)
+	for i, column := range xw.columns {
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+		ref := cellName(i) + strconv.Itoa(xw.rows)
+		if header {
+			xw.writeString(ref, values[i].(string), styleHeader)
+			continue
+		}
+		if err := xw.writeCell(ref, column.Type, values[i]); err != nil {
+			return fmt.Errorf("column %s: %w", column.Name, err)
+		}
+	}
+	// bufio errors are sticky, so this reports any failed write of the row
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+// writeCell writes v as a cell of type t. Strings are written inline rather
+// than shared, so the sheet can be streamed, and are never read as formulas.
+func (xw *xlsxWriter) writeCell(ref string, t Type, v any) error {
+	switch t {
+	case Number:
+		var number float64
+		switch v := v.(type) {
+		case int:
+			number = float64(v)
+		case int64:
+			number = float64(v)
+		case float64:
+			number = v
+		default:
+			return fmt.Errorf("unsupported number type %T", v)
+		}
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return fmt.Errorf("%v is not a number Excel can store", number)
+		}
+		fmt.Fprintf(xw.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(number, 'g', -1, 64))
+	case Time:
+		at, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("unsupported time type %T", v)
+		}
+		// Excel stores times as days since its epoch, without a zone
+		days := float64(at.UTC().Sub(xlsxEpoch)) / float64(24*time.Hour)
+		fmt.Fprintf(xw.sheet, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDateTime, strconv.FormatFloat(days, 'f', -1, 64))
+	default:
+		text, err := formatValue(v)
+		if err != nil {
+			return err
+		}
+		xw.writeString(ref, text, 0)
+	}
+	return nil
+}
+
+// writeString writes text as an inline string cell with a style
+func (xw *xlsxWriter) writeString(ref, text string, style int) {
+	fmt.Fprintf(xw.sheet, `<c r="%s" t="inlineStr"`, ref)
+	if style != 0 {
+		fmt.Fprintf(xw.sheet, ` s="%d"`, style)
+	}
+	xw.sheet.WriteString(`><is><t xml:space="preserve">`)
+	// Characters XML cannot hold, such as most control characters, are
+	// replaced rather than making the workbook unreadable
+	_ = xml.EscapeText(xw.sheet, []byte(text))
+	xw.sheet.WriteString(`</t></is></c>`)
+}
+
+// Close finishes the sheet and writes the rest of the workbook
+func (xw *xlsxWriter) Close() error {
+	xw.sheet.WriteString(`</sheetData>`)
+	if len(xw.columns) > 0 {
+		fmt.Fprintf(xw.sheet, `<autoFilter ref="A1:%s%d"/>`, cellName(len(xw.columns)-1), xw.rows)
+	}
+	xw.sheet.WriteString(`</worksheet>`)
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	} {
+		entry, err := xw.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(entry, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+	return xw.zip.Close()
+}
+
+// cellName returns the letters naming the column at index i: A to Z, then
+// AA, AB and so on
+func cellName(i int) string {
+	var name []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = append(name, byte('A'+(i-1)%26))
+	}
+	for l, r := 0, len(name)-1; l < r; l, r = l+1, r-1 {
+		name[l], name[r] = name[r], name[l]
+	}
+	return string(name)
+}
+
+// columnWidth returns the width, in characters, of columns of type t
+func columnWidth(t Type) int {
+	switch t {
+	case Number:
+		return 10
+	case Time:
+		return 20
+	default:
+		return 30
+	}
+}
+
+const xlsxContentTypes = `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+const xlsxRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const xlsxWorkbook = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets>` +
+	`</workbook>`
+
+const xlsxWorkbookRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// xlsxStyles defines the cell styles: plain, a bold shaded header with a
+// rule beneath, and dates with times
+const xlsxStyles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFD9E1F2"/><bgColor indexed="64"/></patternFill></fill></fills>` +
+	`<borders count="2"><border><left/><right/><top/><bottom/><diagonal/></border>` +
+	`<border><left/><right/><top/><bottom style="thin"><color auto="1"/></bottom><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="1" xfId="0" applyFont="1" applyFill="1" applyBorder="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -0,0 +1,286 @@
+// Package graph builds a user's relationships as nodes and edges: the
+// organizations they are members of and those above them, the other members
+// of those organizations and the users' managers. It walks outwards from
+// the user a level at a time, looking each level's users up in one batch,
+// and visits every node once however many paths lead to it.
+package graph
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+
+	"github.com/dazraf/go-api-example/internal/orgs"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+const (
+	// DefaultDepth is the number of edges walked from the user by default
+	DefaultDepth = 2
+	// MaxDepth is the most edges walked from the user
+	MaxDepth = 4
+	// MaxNodes is the most nodes in a graph. Larger graphs are truncated.
+	MaxNodes = 500
+)
+
+// Kinds of nodes
+const (
+	NodeUser = "user"
+	NodeOrg  = "org"
+)
+
+// Kinds of edges
+const (
+	// EdgeMemberOf goes from a user to an organization they are a member of
+	EdgeMemberOf = "member_of"
+	// EdgeChildOf goes from an organization to its parent
+	EdgeChildOf = "child_of"
+	// EdgeManagedBy goes from a user to one of their managers
+	EdgeManagedBy = "managed_by"
+)
+
+// ErrNotFound is returned for graphs of users that do not exist
+var ErrNotFound = errors.New("user not found")
+
+// Node is a user or an organization
+type Node struct {
+	// ID is the kind and ID of the user or organization
+	ID   string `json:"id" example:"user:1"`
+	Kind string `json:"kind" example:"user" enums:"user,org"`
+	// Label is the user's or organization's name
+	Label string `json:"label" example:"John Doe"`
+	// Depth is the number of edges between the node and the root
+	Depth int `json:"depth" example:"1"`
+}
+
+// Edge is a relationship between two nodes
+type Edge struct {
+	From string `json:"from" example:"user:1"`
+	To   string `json:"to" example:"org:3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Kind string `json:"kind" example:"member_of" enums:"member_of,child_of,managed_by"`
+	// Roles are those granted by a membership, or that make a manager one
+	Roles []string `json:"roles,omitempty" example:"admin"`
+}
+
+// Graph is the relationships of the user at Root
+type Graph struct {
+	Root  string `json:"root" example:"user:1"`
+	Depth int    `json:"depth" example:"2"`
+	// Nodes are ordered by depth, then ID
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+	// Truncated is set when nodes were left out to stay within MaxNodes
+	Truncated bool `json:"truncated"`
+}
+
+// Builder builds graphs from users and their organizations
+type Builder struct {
+	users store.UserStore
+	orgs  *orgs.Store
+}
+
+// NewBuilder creates a builder of the graphs of users in users, related
+// through organizations in orgStore
+func NewBuilder(users store.UserStore, orgStore *orgs.Store) *Builder {
+	return &Builder{users: users, orgs: orgStore}
+}
+
+// UserNode returns the ID of the user's node
+func UserNode(id int64) string {
+	return NodeUser + ":" + strconv.FormatInt(id, 10)
+}
+
+// OrgNode returns the ID of the organization's node
+func OrgNode(id string) string {
+	return NodeOrg + ":" + id
+}
+
+// walk is the state of a graph being built
+type walk struct {
+	graph Graph
+	// nodes holds the IDs of the nodes in the graph
+	nodes map[string]bool
+	// edges holds the edges in the graph
+	edges map[edgeKey]bool
+	// visible reports whether an organization may be in the graph
+	visible func(orgID string) bool
+}
+
+// Build returns the graph of the user with id up to depth edges away,
+// clamped to 1 through MaxDepth. Organizations visible rejects are left
+// out, along with what is only reachable through them; nil allows all.
+func (b *Builder) Build(id int64, depth int, visible func(orgID string) bool) (Graph, error) {
+	depth = min(max(depth, 1), MaxDepth)
+	if visible == nil {
+		visible = func(string) bool { return true }
+	}
+	root, err := store.GetUsers(b.users, []int64{id})
+	if err != nil {
+		return Graph{}, err
+	}
+	if len(root) == 0 {
+		return Graph{}, ErrNotFound
+	}
+
+	w := &walk{
+		graph:   Graph{Root: UserNode(id), Depth: depth, Nodes: []Node{}, Edges: []Edge{}},
+		nodes:   make(map[string]bool),
+		edges:   make(map[edgeKey]bool),
+		visible: visible,
+	}
+	w.add(Node{ID: UserNode(id), Kind: NodeUser, Label: root[0].Name})
+
+	users, orgIDs := []int64{id}, []string(nil)
+	for level := 1; level <= depth && (len(users) > 0 || len(orgIDs) > 0); level++ {
+		found := newLevel()
+		b.expandUsers(w, users, found)
+		b.expandOrgs(w, orgIDs, found)
+		users, orgIDs, err = b.settle(w, level, found)
+		if err != nil {
+			return Graph{}, err
+		}
+	}
+
+	slices.SortStableFunc(w.graph.Nodes, func(a, b Node) int {
+		return cmp.Or(cmp.Compare(a.Depth, b.Depth), cmp.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(w.graph.Edges, func(a, b Edge) int {
+		return cmp.Or(cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To), cmp.Compare(a.Kind, b.Kind))
+	})
+	return w.graph, nil
+}
+
+// edgeKey identifies an edge by its ends and kind
+type edgeKey struct {
+	from, to, kind string
+}
+
+// level is what expanding a level found: nodes new to the graph, which are
+// only added once looked up, and the edges leading to them or between
+// nodes already in the graph
+type level struct {
+	users map[int64]bool
+	orgs  map[string]orgs.Organization
+	edges []Edge
+}
+
+func newLevel() *level {
+	return &level{users: make(map[int64]bool), orgs: make(map[string]orgs.Organization)}
+}
+
+// expandUsers finds the organizations the users are members of and their
+// managers
+func (b *Builder) expandUsers(w *walk, users []int64, found *level) {
+	if len(users) == 0 {
+		return
+	}
+	for _, member := range b.orgs.Memberships(users) {
+		if !w.visible(member.OrgID) {
+			continue
+		}
+		if !w.nodes[OrgNode(member.OrgID)] {
+			org, err := b.orgs.Get(member.OrgID)
+			if err != nil {
+				continue
+			}
+			found.orgs[org.ID] = org
+		}
+		found.edges = append(found.edges, Edge{From: UserNode(member.UserID), To: OrgNode(member.OrgID), Kind: EdgeMemberOf, Roles: member.Roles})
+	}
+	for userID, managers := range b.orgs.Managers(users) {
+		for _, manager := range managers {
+			if !w.visible(manager.OrgID) {
+				continue
+			}
+			if !w.nodes[UserNode(manager.UserID)] {
+				found.users[manager.UserID] = true
+			}
+			found.edges = append(found.edges, Edge{From: UserNode(userID), To: UserNode(manager.UserID), Kind: EdgeManagedBy, Roles: manager.Roles})
+		}
+	}
+}
+
+// expandOrgs finds the parents and direct members of the organizations
+func (b *Builder) expandOrgs(w *walk, orgIDs []string, found *level) {
+	for _, orgID := range orgIDs {
+		org, err := b.orgs.Get(orgID)
+		if err != nil {
+			continue
+		}
+		if org.ParentID != "" && w.visible(org.ParentID) {
+			if !w.nodes[OrgNode(org.ParentID)] {
+				if parent, err := b.orgs.Get(org.ParentID); err == nil {
+					found.orgs[parent.ID] = parent
+				}
+			}
+			found.edges = append(found.edges, Edge{From: OrgNode(orgID), To: OrgNode(org.ParentID), Kind: EdgeChildOf})
+		}
+		members, err := b.orgs.Members(orgID, false)
+		if err != nil {
+			continue
+		}
+		for _, member := range members {
+			if !w.nodes[UserNode(member.UserID)] {
+				found.users[member.UserID] = true
+			}
+			found.edges = append(found.edges, Edge{From: UserNode(member.UserID), To: OrgNode(orgID), Kind: EdgeMemberOf, Roles: member.Roles})
+		}
+	}
+}
+
+// settle adds what a level found to the graph at depth, looking its users
+// up in one batch, and returns the nodes to expand next. Users that no
+// longer exist, as memberships outlive them, are left out with their edges.
+func (b *Builder) settle(w *walk, depth int, found *level) ([]int64, []string, error) {
+	ids := make([]int64, 0, len(found.users))
+	for id := range found.users {
+		ids = append(ids, id)
+	}
+	users, err := store.GetUsers(b.users, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nextUsers []int64
+	for _, user := range users {
+		if w.add(Node{ID: UserNode(user.ID), Kind: NodeUser, Label: user.Name, Depth: depth}) {
+			nextUsers = append(nextUsers, user.ID)
+		}
+	}
+	orgIDs := make([]string, 0, len(found.orgs))
+	for id := range found.orgs {
+		orgIDs = append(orgIDs, id)
+	}
+	slices.Sort(orgIDs)
+	var nextOrgs []string
+	for _, id := range orgIDs {
+		if w.add(Node{ID: OrgNode(id), Kind: NodeOrg, Label: found.orgs[id].Name, Depth: depth}) {
+			nextOrgs = append(nextOrgs, id)
+		}
+	}
+
+	for _, edge := range found.edges {
+		key := edgeKey{from: edge.From, to: edge.To, kind: edge.Kind}
+		if w.nodes[edge.From] && w.nodes[edge.To] && !w.edges[key] {
+			w.edges[key] = true
+			w.graph.Edges = append(w.graph.Edges, edge)
+		}
+	}
+	return nextUsers, nextOrgs, nil
+}
+
+// add adds node to the graph unless it is there already or the graph is
+// full, reporting whether it was added
+func (w *walk) add(node Node) bool {
+	if w.nodes[node.ID] {
+		return false
+	}
+	if len(w.graph.Nodes) >= MaxNodes {
+		w.graph.Truncated = true
+		return false
+	}
+	w.nodes[node.ID] = true
+	w.graph.Nodes = append(w.graph.Nodes, node)
+	return true
+}
@@ -0,0 +1,154 @@
+package graph
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/orgs"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// newTestBuilder returns a builder over acme > europe, where John is an
+// engineer in Europe managed by Jane, a manager of Acme, alongside Bob and
+// a member who has been deleted
+func newTestBuilder(t *testing.T) (*Builder, orgs.Organization, orgs.Organization) {
+	t.Helper()
+	users := store.NewMemoryUserStore()
+	for _, user := range []store.User{
+		{Name: "John Doe", Email: "john@example.com"},
+		{Name: "Jane Smith", Email: "jane@example.com"},
+		{Name: "Bob Jones", Email: "bob@example.com"},
+	} {
+		_, err := users.Create(user)
+		require.NoError(t, err)
+	}
+	orgStore := orgs.NewStore(orgs.Options{
+		Clock: clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)),
+		IDs:   idgen.NewSequence("org"),
+	})
+	acme, err := orgStore.Create(orgs.Spec{Name: "Acme"})
+	require.NoError(t, err)
+	europe, err := orgStore.Create(orgs.Spec{Name: "Europe", ParentID: acme.ID})
+	require.NoError(t, err)
+	for _, m := range []struct {
+		org    string
+		userID int64
+		roles  []string
+	}{
+		{org: europe.ID, userID: 1, roles: []string{"engineer"}},
+		{org: acme.ID, userID: 2, roles: []string{orgs.RoleManager}},
+		{org: europe.ID, userID: 3},
+		{org: europe.ID, userID: 99},
+	} {
+		_, _, err := orgStore.SetMember(m.org, m.userID, m.roles)
+		require.NoError(t, err)
+	}
+	return NewBuilder(users, orgStore), acme, europe
+}
+
+func TestBuilder_Build(t *testing.T) {
+	builder, acme, europe := newTestBuilder(t)
+	john, jane, bob := UserNode(1), UserNode(2), UserNode(3)
+
+	tests := []struct {
+		name      string
+		depth     int
+		visible   func(string) bool
+		wantNodes []Node
+		wantEdges []Edge
+	}{
+		{
+			name:  "depth 1",
+			depth: 1,
+			wantNodes: []Node{
+				{ID: OrgNode(europe.ID), Kind: NodeOrg, Label: "Europe", Depth: 1},
+				{ID: jane, Kind: NodeUser, Label: "Jane Smith", Depth: 1},
+			},
+			wantEdges: []Edge{
+				{From: john, To: OrgNode(europe.ID), Kind: EdgeMemberOf, Roles: []string{"engineer"}},
+				{From: john, To: jane, Kind: EdgeManagedBy, Roles: []string{orgs.RoleManager}},
+			},
+		},
+		{
+			name:  "depth 2 leaves out deleted members",
+			depth: 2,
+			wantNodes: []Node{
+				{ID: OrgNode(europe.ID), Kind: NodeOrg, Label: "Europe", Depth: 1},
+				{ID: jane, Kind: NodeUser, Label: "Jane Smith", Depth: 1},
+				{ID: OrgNode(acme.ID), Kind: NodeOrg, Label: "Acme", Depth: 2},
+				{ID: bob, Kind: NodeUser, Label: "Bob Jones", Depth: 2},
+			},
+			wantEdges: []Edge{
+				{From: OrgNode(europe.ID), To: OrgNode(acme.ID), Kind: EdgeChildOf},
+				{From: john, To: OrgNode(europe.ID), Kind: EdgeMemberOf, Roles: []string{"engineer"}},
+				{From: john, To: jane, Kind: EdgeManagedBy, Roles: []string{orgs.RoleManager}},
+				{From: jane, To: OrgNode(acme.ID), Kind: EdgeMemberOf, Roles: []string{orgs.RoleManager}},
+				{From: bob, To: OrgNode(europe.ID), Kind: EdgeMemberOf, Roles: []string{}},
+			},
+		},
+		{
+			name:    "hidden organizations",
+			depth:   MaxDepth,
+			visible: func(id string) bool { return id == europe.ID },
+			wantNodes: []Node{
+				{ID: OrgNode(europe.ID), Kind: NodeOrg, Label: "Europe", Depth: 1},
+				{ID: bob, Kind: NodeUser, Label: "Bob Jones", Depth: 2},
+			},
+			wantEdges: []Edge{
+				{From: john, To: OrgNode(europe.ID), Kind: EdgeMemberOf, Roles: []string{"engineer"}},
+				{From: bob, To: OrgNode(europe.ID), Kind: EdgeMemberOf, Roles: []string{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := builder.Build(1, tt.depth, tt.visible)
+			require.NoError(t, err)
+			assert.Equal(t, john, graph.Root)
+			assert.Equal(t, Node{ID: john, Kind: NodeUser, Label: "John Doe"}, graph.Nodes[0])
+			assert.Equal(t, tt.wantNodes, graph.Nodes[1:])
+			assert.ElementsMatch(t, tt.wantEdges, graph.Edges)
+			assert.False(t, graph.Truncated)
+		})
+	}
+
+	// Cycles through shared organizations end where nodes were visited
+	deep, err := builder.Build(1, MaxDepth+10, nil)
+	require.NoError(t, err)
+	assert.Equal(t, MaxDepth, deep.Depth)
+	assert.Len(t, deep.Nodes, 5)
+
+	_, err = builder.Build(42, DefaultDepth, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestBuilder_BuildTruncates(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	orgStore := orgs.NewStore(orgs.Options{IDs: idgen.NewSequence("org")})
+	org, err := orgStore.Create(orgs.Spec{Name: "Everyone"})
+	require.NoError(t, err)
+	for i := range MaxNodes + 10 {
+		user, err := users.Create(store.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+		require.NoError(t, err)
+		_, _, err = orgStore.SetMember(org.ID, user.ID, nil)
+		require.NoError(t, err)
+	}
+
+	graph, err := NewBuilder(users, orgStore).Build(1, DefaultDepth, nil)
+	require.NoError(t, err)
+	assert.True(t, graph.Truncated)
+	assert.Len(t, graph.Nodes, MaxNodes)
+	nodes := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		nodes[node.ID] = true
+	}
+	for _, edge := range graph.Edges {
+		assert.True(t, nodes[edge.From] && nodes[edge.To], "edges only join nodes in the graph")
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/anonymize"
+	"github.com/dazraf/go-api-example/internal/backup"
+	"github.com/dazraf/go-api-example/internal/directory"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/merge"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/reports"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+type AdminHandler struct {
+	verifier store.Verifier
+	// operations runs repairs requested with ?async=true, when enabled
+	operations *operations.Manager
+	// directory provisions users from an external directory, when enabled
+	directory *directory.Syncer
+	// users are reported on by reports, when enabled
+	users           store.UserStore
+	reports         *reports.Manager
+	reportSyncLimit int
+	// anonymizer fakes the users in users on demand, when enabled
+	anonymizer *anonymize.Anonymizer
+	// archiver backs up and restores the application's state, when enabled
+	archiver *backup.Archiver
+	// merger merges duplicates among users, when enabled
+	merger *merge.Merger
+}
+
+func NewAdminHandler(verifier store.Verifier) *AdminHandler {
+	return &AdminHandler{
+		verifier: verifier,
+	}
+}
+
+// EnableAsync lets repairs run in the background as operations of manager
+func (h *AdminHandler) EnableAsync(manager *operations.Manager) {
+	h.operations = manager
+}
+
+// EnableDirectorySync lets admins sync users from a directory on demand
+func (h *AdminHandler) EnableDirectorySync(syncer *directory.Syncer) {
+	h.directory = syncer
+}
+
+// EnableReports lets admins get reports about users in userStore, rendered
+// by manager. Reports about more than syncLimit users are generated in the
+// background when operations are enabled and manager can store them.
+func (h *AdminHandler) EnableReports(userStore store.UserStore, manager *reports.Manager, syncLimit int) {
+	h.users = userStore
+	h.reports = manager
+	h.reportSyncLimit = syncLimit
+}
+
+// EnableAnonymization lets admins replace the names and emails of every
+// user in userStore with fakes, which must never happen in production
+func (h *AdminHandler) EnableAnonymization(userStore store.UserStore, anonymizer *anonymize.Anonymizer) {
+	h.users = userStore
+	h.anonymizer = anonymizer
+}
+
+// EnableBackup lets admins download the application's state as an archive
+// and restore one, e.g. to clone an environment
+func (h *AdminHandler) EnableBackup(archiver *backup.Archiver) {
+	h.archiver = archiver
+}
+
+// EnableMerge lets admins find likely duplicates among the users in
+// userStore and merge them with merger
+func (h *AdminHandler) EnableMerge(userStore store.UserStore, merger *merge.Merger) {
+	h.users = userStore
+	h.merger = merger
+}
+
+// Routes returns the endpoints served by the handler
+func (h *AdminHandler) Routes() []router.Route {
+	routes := []router.Route{
+		{Method: http.MethodGet, Path: "/admin/integrity", Handler: http.HandlerFunc(h.GetIntegrity)},
+		{Method: http.MethodPost, Path: "/admin/integrity/repair", Handler: http.HandlerFunc(h.RepairIntegrity)},
+	}
+	if h.directory != nil {
+		routes = append(routes, router.Route{Method: http.MethodPost, Path: "/admin/directory/sync", Handler: http.HandlerFunc(h.SyncDirectory)})
+	}
+	if h.anonymizer != nil {
+		routes = append(routes, router.Route{Method: http.MethodPost, Path: "/admin/anonymize", Handler: http.HandlerFunc(h.Anonymize)})
+	}
+	if h.merger != nil {
+		routes = append(routes,
+			router.Route{Method: http.MethodGet, Path: "/admin/users/duplicates", Handler: http.HandlerFunc(h.GetDuplicateUsers)},
+			router.Route{Method: http.MethodPost, Path: "/admin/users/merge", Handler: http.HandlerFunc(h.MergeUsers)},
+		)
+	}
+	if h.archiver != nil {
+		routes = append(routes,
+			router.Route{Method: http.MethodGet, Path: "/admin/backup", Handler: http.HandlerFunc(h.GetBackup)},
+			router.Route{Method: http.MethodPost, Path: "/admin/restore", Handler: http.HandlerFunc(h.RestoreBackup)},
+		)
+	}
+	if h.reports != nil {
+		routes = append(routes,
+			router.Route{Method: http.MethodGet, Path: "/admin/reports/users.pdf", Handler: http.HandlerFunc(h.GetUsersReport)},
+			router.Route{Method: http.MethodGet, Path: reports.DownloadPath + "{id}", Handler: http.HandlerFunc(h.DownloadReport)},
+		)
+	}
+	return routes
+}
+
+// @Summary Verify store integrity
+// @Description Recompute record checksums and report any inconsistencies without modifying data
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} store.IntegrityReport
+// @Failure 409 {object} store.IntegrityReport
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/integrity [get]
+func (h *AdminHandler) GetIntegrity(w http.ResponseWriter, r *http.Request) {
+	h.verify(w, r, false)
+}
+
+// @Summary Repair store integrity
+// @Description Recompute record checksums and repair any inconsistencies in place. With async, the repair runs in the background and its report is the operation's response.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param async query bool false "Repair in the background, responding 202 with an operation to poll"
+// @Success 200 {object} store.IntegrityReport
+// @Success 202 {object} operations.Operation
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /admin/integrity/repair [post]
+func (h *AdminHandler) RepairIntegrity(w http.ResponseWriter, r *http.Request) {
+	var query asyncQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if !query.Async {
+		h.verify(w, r, true)
+		return
+	}
+	if h.operations == nil {
+		writeError(w, r, http.StatusBadRequest, "Async processing is not enabled")
+		return
+	}
+	submitOperation(w, r, h.operations, "integrity.repair", func(context.Context, operations.Progress) (any, error) {
+		return h.verifier.Verify(true)
+	})
+}
+
+func (h *AdminHandler) verify(w http.ResponseWriter, r *http.Request, repair bool) {
+	report, err := h.verifier.Verify(repair)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !report.Healthy() {
+		writeJSON(w, http.StatusConflict, report)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// directorySyncQuery holds the query parameters of SyncDirectory
+type directorySyncQuery struct {
+	DryRun bool `query:"dry_run"`
+	Async  bool `query:"async"`
+}
+
+// @Summary Sync users from the directory
+// @Description Pull every user from the external directory and reconcile the store with them: create new users, update changed names and delete users the directory marks inactive. A dry run reports the changes without making them. With async, the sync runs in the background and its report is the operation's response.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param dry_run query bool false "Report the changes without making them"
+// @Param async query bool false "Sync in the background, responding 202 with an operation to poll"
+// @Success 200 {object} directory.Report
+// @Success 202 {object} operations.Operation
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /admin/directory/sync [post]
+func (h *AdminHandler) SyncDirectory(w http.ResponseWriter, r *http.Request) {
+	var query directorySyncQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if query.Async {
+		if h.operations == nil {
+			writeError(w, r, http.StatusBadRequest, "Async processing is not enabled")
+			return
+		}
+		submitOperation(w, r, h.operations, "directory.sync", func(ctx context.Context, _ operations.Progress) (any, error) {
+			return h.directory.Sync(ctx, query.DryRun)
+		})
+		return
+	}
+
+	report, err := h.directory.Sync(r.Context(), query.DryRun)
+	switch {
+	case errors.Is(err, directory.ErrSyncRunning):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusBadGateway, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// @Summary Anonymize users
+// @Description Replace the name and email of every user with realistic fakes derived from their ID, keeping IDs and timestamps, e.g. after restoring a production snapshot into staging. It cannot be undone. With async, it runs in the background and its report is the operation's response.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param async query bool false "Anonymize in the background, responding 202 with an operation to poll"
+// @Success 200 {object} anonymize.Report
+// @Success 202 {object} operations.Operation
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/anonymize [post]
+func (h *AdminHandler) Anonymize(w http.ResponseWriter, r *http.Request) {
+	var query asyncQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if query.Async {
+		if h.operations == nil {
+			writeError(w, r, http.StatusBadRequest, "Async processing is not enabled")
+			return
+		}
+		submitOperation(w, r, h.operations, "users.anonymize", func(ctx context.Context, _ operations.Progress) (any, error) {
+			return h.anonymize(ctx)
+		})
+		return
+	}
+
+	report, err := h.anonymize(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// anonymize anonymizes the users, writing the outcome to the audit log
+func (h *AdminHandler) anonymize(ctx context.Context) (anonymize.Report, error) {
+	report, err := h.anonymizer.Store(ctx, h.users)
+	logger := logging.FromContext(ctx, logging.Store)
+	if err != nil {
+		logger.Error("Audit: anonymization failed", "users", report.Users, "error", err)
+		return report, err
+	}
+	logger.Info("Audit: users anonymized", "users", report.Users)
+	return report, nil
+}
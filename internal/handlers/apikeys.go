@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+// APIKeyRequest sets an API key's name and roles
+type APIKeyRequest struct {
+	Name  string   `json:"name" example:"ci-deployer"`
+	Roles []string `json:"roles" example:"admin"`
+}
+
+// CreatedAPIKey is a new API key along with its secret, which is only
+// returned once
+type CreatedAPIKey struct {
+	apikeys.Key
+	Secret string `json:"secret" example:"uak_9c0e8a1d4b7c9e6f5a4b3c2d1e0f3f2b9c0e8a1d4b7c9e6f"`
+}
+
+type APIKeyHandler struct {
+	keys *apikeys.Store
+}
+
+func NewAPIKeyHandler(keys *apikeys.Store) *APIKeyHandler {
+	return &APIKeyHandler{
+		keys: keys,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *APIKeyHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/api-keys", Handler: http.HandlerFunc(h.GetAPIKeys)},
+		{Method: http.MethodPost, Path: "/admin/api-keys", Handler: http.HandlerFunc(h.CreateAPIKey)},
+		{Method: http.MethodGet, Path: "/admin/api-keys/{id}", Handler: http.HandlerFunc(h.GetAPIKey)},
+		{Method: http.MethodPut, Path: "/admin/api-keys/{id}", Handler: http.HandlerFunc(h.UpdateAPIKey)},
+		{Method: http.MethodDelete, Path: "/admin/api-keys/{id}", Handler: http.HandlerFunc(h.DeleteAPIKey)},
+	}
+}
+
+// @Summary List API keys
+// @Description List API keys by name, or find one by its name, e.g. to import it into Terraform
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param name query string false "Only the key with this name"
+// @Success 200 {array} apikeys.Key
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/api-keys [get]
+func (h *APIKeyHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.keys.List(r.URL.Query().Get("name")))
+}
+
+// @Summary Create an API key
+// @Description Issue an API key with a unique name. The secret is only returned in this response; send it as a bearer token.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param key body APIKeyRequest true "Key to create"
+// @Success 201 {object} CreatedAPIKey
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /admin/api-keys [post]
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req APIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	key, secret, err := h.keys.Create(apikeys.Spec{Name: req.Name, Roles: req.Roles})
+	if err != nil {
+		writeAPIKeyError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, CreatedAPIKey{Key: key, Secret: secret})
+}
+
+// @Summary Get an API key
+// @Description Get an API key by its ID, without its secret
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Key ID"
+// @Success 200 {object} apikeys.Key
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/api-keys/{id} [get]
+func (h *APIKeyHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	key, err := h.keys.Get(r.PathValue("id"))
+	if err != nil {
+		writeAPIKeyError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// @Summary Update an API key
+// @Description Replace an API key's name and roles, keeping its ID and secret. Sending what is already set changes nothing, including updated_at.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Key ID"
+// @Param key body APIKeyRequest true "Name and roles"
+// @Success 200 {object} apikeys.Key
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /admin/api-keys/{id} [put]
+func (h *APIKeyHandler) UpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req APIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	key, err := h.keys.Update(r.PathValue("id"), apikeys.Spec{Name: req.Name, Roles: req.Roles})
+	if err != nil {
+		writeAPIKeyError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// @Summary Delete an API key
+// @Description Revoke an API key, so requests made with it are rejected
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Key ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.keys.Delete(r.PathValue("id")); err != nil {
+		writeAPIKeyError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAPIKeyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, apikeys.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "API key not found")
+	case errors.Is(err, apikeys.ErrNameTaken):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/approval"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+type ApprovalHandler struct {
+	approvals *approval.Workflow
+}
+
+func NewApprovalHandler(approvals *approval.Workflow) *ApprovalHandler {
+	return &ApprovalHandler{
+		approvals: approvals,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *ApprovalHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/approvals", Handler: http.HandlerFunc(h.GetApprovals)},
+		{Method: http.MethodPost, Path: "/admin/approvals/{id}/approve", Handler: http.HandlerFunc(h.Approve)},
+	}
+}
+
+// @Summary List approval requests
+// @Description List operations waiting for approval, and those already decided, newest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {array} approval.Request
+// @Router /admin/approvals [get]
+func (h *ApprovalHandler) GetApprovals(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.approvals.List())
+}
+
+// @Summary Approve a request
+// @Description Approve a pending operation, which then runs. Requires an admin token from someone other than the requester.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Approval request ID"
+// @Success 200 {object} approval.Request
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/approvals/{id}/approve [post]
+func (h *ApprovalHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	if !principal.HasRole(auth.RoleAdmin) {
+		writeCodedError(w, r, http.StatusForbidden, apierrors.AdminRequired, "Only admins can approve requests")
+		return
+	}
+
+	request, err := h.approvals.Approve(r.Context(), r.PathValue("id"), principal.Subject)
+	switch {
+	case errors.Is(err, approval.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Approval request not found")
+	case errors.Is(err, approval.ErrSelfApproval):
+		writeError(w, r, http.StatusForbidden, err.Error())
+	case errors.Is(err, approval.ErrNotPending):
+		writeError(w, r, http.StatusConflict, "Approval request is "+request.Status)
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "Approved operation failed: "+err.Error())
+	default:
+		writeJSON(w, http.StatusOK, request)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/avatars"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/surrogate"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// avatarPurpose is the purpose of uploads that can be made avatars
+const avatarPurpose = "avatar"
+
+// SetAvatarRequest names a completed upload to make a user's avatar
+type SetAvatarRequest struct {
+	UploadID string `json:"upload_id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+}
+
+// avatarQuery holds the query parameters of GetAvatar
+type avatarQuery struct {
+	// Size defaults to the largest configured
+	Size string `query:"size"`
+}
+
+// @Summary Get a user's avatar
+// @Description Get a user's avatar as WebP, at a configured size such as thumb or medium; the largest by default. Responses carry an ETag and Cache-Control, and conditional requests get 304 when the avatar is unchanged.
+// @Tags users
+// @Produce image/webp
+// @Param id path int true "User ID"
+// @Param size query string false "Avatar size, e.g. thumb"
+// @Success 200 {file} binary
+// @Success 304
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/users/{id}/avatar [get]
+func (h *UserHandler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	h.tag(w, surrogate.User(id))
+	var query avatarQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if query.Size == "" {
+		sizes := h.avatars.Sizes()
+		query.Size = sizes[len(sizes)-1]
+	}
+
+	file, err := h.avatars.Open(r.Context(), id, query.Size)
+	switch {
+	case errors.Is(err, avatars.ErrUnknownSize):
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid size: must be one of %v", h.avatars.Sizes()))
+		return
+	case errors.Is(err, avatars.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Avatar not found")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+	// Variants are small, so are read whole to hash them for the ETag
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hash := sha256.Sum256(data)
+	w.Header().Set("Content-Type", avatars.ContentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.avatarMaxAge.Seconds())))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// @Summary Set a user's avatar
+// @Description Make a completed avatar upload the user's avatar. The image is checked, turned upright, stripped of EXIF data, resized to each configured size and stored as WebP. Users can set their own avatar; admins anyone's.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param avatar body SetAvatarRequest true "Upload to use"
+// @Success 200 {object} avatars.Avatar
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/users/{id}/avatar [put]
+func (h *UserHandler) SetAvatar(w http.ResponseWriter, r *http.Request) {
+	id, principal, ok := h.avatarOwner(w, r)
+	if !ok {
+		return
+	}
+	var req SetAvatarRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	upload, file, err := h.uploads.Open(r.Context(), req.UploadID)
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Upload not found")
+		return
+	case errors.Is(err, uploads.ErrNotUploaded):
+		writeError(w, r, http.StatusConflict, "Upload is not completed")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+	// Others' uploads are reported as not found, so their IDs cannot be
+	// probed
+	if upload.Owner != principal.Subject {
+		writeError(w, r, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if upload.Purpose != avatarPurpose {
+		writeError(w, r, http.StatusBadRequest, "Upload is not an avatar")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, upload.Size))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	avatar, err := h.avatars.Process(r.Context(), id, data)
+	switch {
+	case errors.Is(err, avatars.ErrInvalidImage), errors.Is(err, avatars.ErrDimensions):
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		h.purge(r.Context(), id)
+		writeJSON(w, http.StatusOK, avatar)
+	}
+}
+
+// @Summary Delete a user's avatar
+// @Description Delete a user's avatar at every size. Users can delete their own avatar; admins anyone's.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/users/{id}/avatar [delete]
+func (h *UserHandler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := h.avatarOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.avatars.Delete(r.Context(), id); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.purge(r.Context(), id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// avatarOwner returns the user named in r if the caller is them or an
+// admin, writing an error response otherwise
+func (h *UserHandler) avatarOwner(w http.ResponseWriter, r *http.Request) (int64, reqctx.Principal, bool) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return 0, reqctx.Principal{}, false
+	}
+	if !requireOwner(w, r, auth.UserOwner(id), "Not allowed to change this user's avatar") {
+		return 0, reqctx.Principal{}, false
+	}
+	principal, _ := reqctx.PrincipalFrom(r.Context())
+	if _, err := timedStore(r.Context(), h.userStore).GetByID(id); err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return 0, reqctx.Principal{}, false
+	}
+	return id, principal, true
+}
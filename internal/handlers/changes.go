@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/visibility"
+)
+
+// maxChangesLimit is the most changes returned at once
+const maxChangesLimit = 1000
+
+// changesQuery holds the query parameters of GetChanges
+type changesQuery struct {
+	FromSeq uint64 `query:"from_seq" default:"0"`
+	Limit   int    `query:"limit" default:"100" min:"1" max:"1000"`
+}
+
+// ChangesResponse is a page of the change stream
+type ChangesResponse struct {
+	Changes []store.Change `json:"changes"`
+	// NextFromSeq is the from_seq to use for the next page
+	NextFromSeq uint64 `json:"next_from_seq" example:"42"`
+	// LastSeq is the most recent sequence number in the stream
+	LastSeq uint64 `json:"last_seq" example:"42"`
+}
+
+type ChangeHandler struct {
+	feed store.ChangeFeed
+	// fields hides user fields from callers without the roles to see them
+	fields *visibility.Policy
+}
+
+func NewChangeHandler(feed store.ChangeFeed) *ChangeHandler {
+	return &ChangeHandler{
+		feed: feed,
+	}
+}
+
+// RestrictFields hides the user fields policy restricts from callers who
+// may not see them, in the users carried by changes
+func (h *ChangeHandler) RestrictFields(policy *visibility.Policy) {
+	h.fields = policy
+}
+
+// Routes returns the endpoints served by the handler
+func (h *ChangeHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/cdc", Handler: http.HandlerFunc(h.GetChanges)},
+	}
+}
+
+// @Summary Stream changes
+// @Description Get user mutations with a sequence number after from_seq, in order. Resume by passing next_from_seq from the previous page.
+// @Tags changes
+// @Accept json
+// @Produce json
+// @Param from_seq query int false "Return changes after this sequence number" default(0)
+// @Param limit query int false "Maximum number of changes to return, defaulting to the caller's page_size preference" default(100)
+// @Success 200 {object} ChangesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Router /api/v1/cdc [get]
+func (h *ChangeHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	var query changesQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if size, ok := reqctx.PageSize(r.Context()); ok && !r.URL.Query().Has("limit") {
+		query.Limit = min(size, maxChangesLimit)
+	}
+
+	changes, err := h.feed.Changes(query.FromSeq, query.Limit)
+	if errors.Is(err, store.ErrChangesExpired) {
+		writeCodedError(w, r, http.StatusGone, apierrors.ChangesExpired, "Changes are no longer available, resynchronise from a full listing")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	next := query.FromSeq
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+
+	response := ChangesResponse{
+		Changes:     changes,
+		NextFromSeq: next,
+		LastSeq:     h.feed.LastSeq(),
+	}
+	if !h.fields.Restricts(r.Context()) {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	redacted := make([]any, len(changes))
+	for i, change := range changes {
+		redacted[i] = change
+		if change.User == nil {
+			continue
+		}
+		user, err := redactUser(r, h.fields, change.User, change.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		redacted[i] = redactedChange{Change: change, User: user}
+	}
+	writeJSON(w, http.StatusOK, redactedChanges{ChangesResponse: response, Changes: redacted})
+}
+
+// redactedChange is a change whose user has hidden fields removed. Its User
+// shadows the embedded one when encoded.
+type redactedChange struct {
+	store.Change
+	User any `json:"user,omitempty"`
+}
+
+// redactedChanges is a ChangesResponse carrying redacted changes
+type redactedChanges struct {
+	ChangesResponse
+	Changes []any `json:"changes"`
+}
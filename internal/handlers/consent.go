@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/consent"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// AcceptRequest accepts a version of a document
+type AcceptRequest struct {
+	Document string `json:"document" example:"terms"`
+	Version  string `json:"version" example:"2024-06"`
+}
+
+type ConsentHandler struct {
+	consent   *consent.Store
+	userStore store.UserStore
+}
+
+func NewConsentHandler(consentStore *consent.Store, userStore store.UserStore) *ConsentHandler {
+	return &ConsentHandler{
+		consent:   consentStore,
+		userStore: userStore,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *ConsentHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/terms", Handler: http.HandlerFunc(h.GetTerms)},
+		{Method: http.MethodGet, Path: "/api/v1/me/consents", Handler: http.HandlerFunc(h.GetMyConsents)},
+		{Method: http.MethodPost, Path: "/api/v1/me/consents", Handler: http.HandlerFunc(h.Accept)},
+	}
+}
+
+// AdminRoutes returns the endpoints for auditing other users' consents
+func (h *ConsentHandler) AdminRoutes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/users/{id}/consents", Handler: http.HandlerFunc(h.GetUserConsents)},
+	}
+}
+
+// @Summary List the current terms
+// @Description List the current version of every document users must accept, such as the terms of service and privacy policy
+// @Tags consent
+// @Accept json
+// @Produce json
+// @Success 200 {array} consent.Document
+// @Router /api/v1/terms [get]
+func (h *ConsentHandler) GetTerms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.consent.Current())
+}
+
+// @Summary Get my consents
+// @Description Get the document versions the caller has accepted and when, and the current ones they still must accept
+// @Tags consent
+// @Accept json
+// @Produce json
+// @Success 200 {object} consent.Status
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/v1/me/consents [get]
+func (h *ConsentHandler) GetMyConsents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.consent.Status(userID))
+}
+
+// @Summary Accept a document
+// @Description Record the caller accepting the current version of a document. Once every current document is accepted, requests refused with 451 CONSENT_REQUIRED are served again.
+// @Tags consent
+// @Accept json
+// @Produce json
+// @Param acceptance body AcceptRequest true "Document version"
+// @Success 200 {object} consent.Acceptance
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/me/consents [post]
+func (h *ConsentHandler) Accept(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	var req AcceptRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	acceptance, err := h.consent.Accept(userID, consent.Document{Name: req.Document, Version: req.Version})
+	switch {
+	case errors.Is(err, consent.ErrUnknownDocument):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, consent.ErrNotCurrent):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, acceptance)
+	}
+}
+
+// @Summary Get a user's consents
+// @Description Get the document versions a user has accepted and when, and the current ones they still must accept
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} consent.Status
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/users/{id}/consents [get]
+func (h *ConsentHandler) GetUserConsents(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	userID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := timedStore(r.Context(), h.userStore).GetByID(userID); err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.consent.Status(userID))
+}
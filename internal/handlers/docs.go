@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apidocs"
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+// Paths the docs UI page loads the document and its script from
+const (
+	DocsSpecPath   = "/docs/openapi.json"
+	DocsAssetsPath = "/docs/assets/"
+)
+
+type DocsHandler struct {
+	// readDoc returns the served OpenAPI document
+	readDoc func(...string) (string, error)
+	// uiPage is the rendered docs UI page
+	uiPage []byte
+}
+
+func NewDocsHandler(readDoc func(...string) (string, error), uiPage []byte) *DocsHandler {
+	return &DocsHandler{
+		readDoc: readDoc,
+		uiPage:  uiPage,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *DocsHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/docs", Handler: http.HandlerFunc(h.GetUI)},
+		{Method: http.MethodGet, Path: DocsSpecPath, Handler: http.HandlerFunc(h.GetSpec)},
+		{Method: http.MethodGet, Path: DocsAssetsPath + "{file}", Handler: http.HandlerFunc(h.GetAsset)},
+		{Method: http.MethodGet, Path: "/docs/postman.json", Handler: http.HandlerFunc(h.GetPostman)},
+		{Method: http.MethodGet, Path: "/docs/insomnia.json", Handler: http.HandlerFunc(h.GetInsomnia)},
+		{Method: http.MethodGet, Path: "/docs/errors.json", Handler: http.HandlerFunc(h.GetErrorCodes)},
+	}
+}
+
+// GetUI serves the docs UI page, which renders the document from
+// DocsSpecPath. It is HTML, so it is left out of the document.
+func (h *DocsHandler) GetUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(h.uiPage)
+}
+
+// GetAsset serves the docs UI's embedded script
+func (h *DocsHandler) GetAsset(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, apidocs.Assets(), r.PathValue("file"))
+}
+
+// @Summary Get the OpenAPI document
+// @Description The document rendered by the docs UI at /docs and by Swagger UI
+// @Tags docs
+// @Produce json
+// @Success 200 {object} object
+// @Failure 500 {object} ErrorResponse
+// @Router /docs/openapi.json [get]
+func (h *DocsHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.readDoc()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(doc))
+}
+
+// @Summary Export a Postman collection
+// @Description Convert the OpenAPI document into a Postman v2.1 collection, with a folder per tag, example bodies and bearer auth for endpoints that need it
+// @Tags docs
+// @Produce json
+// @Success 200 {object} apidocs.PostmanCollection
+// @Failure 500 {object} ErrorResponse
+// @Router /docs/postman.json [get]
+func (h *DocsHandler) GetPostman(w http.ResponseWriter, r *http.Request) {
+	spec, ok := h.spec(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="postman.json"`)
+	writeJSON(w, http.StatusOK, apidocs.Postman(spec, apidocs.BaseURL(r, spec.BasePath)))
+}
+
+// @Summary Export an Insomnia collection
+// @Description Convert the OpenAPI document into an Insomnia v4 export, with a folder per tag, example bodies and bearer auth for endpoints that need it
+// @Tags docs
+// @Produce json
+// @Success 200 {object} apidocs.InsomniaExport
+// @Failure 500 {object} ErrorResponse
+// @Router /docs/insomnia.json [get]
+func (h *DocsHandler) GetInsomnia(w http.ResponseWriter, r *http.Request) {
+	spec, ok := h.spec(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="insomnia.json"`)
+	writeJSON(w, http.StatusOK, apidocs.Insomnia(spec, apidocs.BaseURL(r, spec.BasePath)))
+}
+
+// @Summary List error codes
+// @Description List the codes error responses carry, with the status they come with, so clients can branch on the code rather than the message. Codes are stable: a published code keeps its meaning.
+// @Tags docs
+// @Produce json
+// @Success 200 {array} apierrors.Definition
+// @Router /docs/errors.json [get]
+func (h *DocsHandler) GetErrorCodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apierrors.Definitions())
+}
+
+// spec parses the OpenAPI document, writing an error response when it
+// cannot be read
+func (h *DocsHandler) spec(w http.ResponseWriter, r *http.Request) (*apidocs.Spec, bool) {
+	doc, err := h.readDoc()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	spec, err := apidocs.Load(doc)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return spec, true
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/instances"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+type InstanceHandler struct {
+	registry instances.Registry
+}
+
+func NewInstanceHandler(registry instances.Registry) *InstanceHandler {
+	return &InstanceHandler{
+		registry: registry,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *InstanceHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/instances", Handler: http.HandlerFunc(h.ListInstances)},
+	}
+}
+
+// @Summary List running instances
+// @Description List the instances that sent a heartbeat recently, with their build, config fingerprint and readiness. Consistent is false while instances run different builds or configs, e.g. during a rollout or when a replica's config drifted.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} instances.Summary
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /admin/instances [get]
+func (h *InstanceHandler) ListInstances(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	list, err := h.registry.List(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, instances.Summarize(list))
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/ratelimit"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+// Limit is a fixed limit on requests, the same for every caller
+type Limit struct {
+	Name        string `json:"name" example:"batch_users"`
+	Max         int64  `json:"max" example:"1000"`
+	Unit        string `json:"unit" example:"users"`
+	Description string `json:"description" example:"Users a batch may create"`
+}
+
+// RateLimitStatus is the caller's standing against its rate limit, as of
+// the request describing it
+type RateLimitStatus struct {
+	Profile       string `json:"profile" example:"standard"`
+	Requests      int    `json:"requests" example:"600"`
+	WindowSeconds int    `json:"window_seconds" example:"60"`
+	Remaining     int    `json:"remaining" example:"598"`
+	ResetSeconds  int    `json:"reset_seconds" example:"42"`
+	// WarnAt is the share of the limit used after which responses carry
+	// X-RateLimit-Warning
+	WarnAt float64 `json:"warn_at" example:"0.8"`
+}
+
+// LimitsResponse describes the limits applying to the caller
+type LimitsResponse struct {
+	// RateLimit is absent when the caller's requests are not rate limited
+	RateLimit *RateLimitStatus `json:"rate_limit,omitempty"`
+	Limits    []Limit          `json:"limits"`
+}
+
+type LimitsHandler struct {
+	limits []Limit
+}
+
+// NewLimitsHandler creates a handler describing the batch limit and
+// limits, the fixed limits of the features enabled
+func NewLimitsHandler(limits []Limit) *LimitsHandler {
+	return &LimitsHandler{
+		limits: append([]Limit{{Name: "batch_users", Max: maxBatchUsers, Unit: "users", Description: "Users a batch may create"}}, limits...),
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *LimitsHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/limits", Handler: http.HandlerFunc(h.GetLimits)},
+	}
+}
+
+// @Summary Get my limits
+// @Description Describe the limits applying to the caller, so clients can throttle themselves before they are refused: its rate limit profile and standing in the current window, counting this request, and the fixed limits of the features enabled. Responses to callers past warn_at of their rate limit carry X-RateLimit-Warning and link here.
+// @Tags limits
+// @Accept json
+// @Produce json
+// @Success 200 {object} LimitsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /api/v1/limits [get]
+func (h *LimitsHandler) GetLimits(w http.ResponseWriter, r *http.Request) {
+	response := LimitsResponse{Limits: h.limits}
+	if status, ok := ratelimit.StatusFrom(r.Context()); ok {
+		response.RateLimit = &RateLimitStatus{
+			Profile:       status.Limit.Profile,
+			Requests:      status.Limit.Requests,
+			WindowSeconds: int(status.Limit.Window.Seconds()),
+			Remaining:     status.Result.Remaining,
+			ResetSeconds:  int(math.Ceil(status.Result.Reset.Seconds())),
+			WarnAt:        status.WarnAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/merge"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// duplicatesQuery holds the query parameters of GetDuplicateUsers
+type duplicatesQuery struct {
+	Threshold float64 `query:"threshold" default:"0.8" min:"0.5" max:"1"`
+	Limit     int     `query:"limit" default:"100" min:"1" max:"1000"`
+}
+
+// @Summary Find duplicate users
+// @Description List pairs of users who are likely the same person, most similar first. Names are compared ignoring case, accents and word order, and emails ignoring case, +tags and how an internationalized domain is written. The score averages both similarities, from 0 to 1; users with the same email always score 1. Admins only.
+// @Tags admin
+// @Produce json
+// @Param threshold query number false "Lowest score listed, from 0.5 to 1" default(0.8)
+// @Param limit query int false "Maximum number of pairs" default(100)
+// @Success 200 {array} merge.Candidate
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/duplicates [get]
+func (h *AdminHandler) GetDuplicateUsers(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var query duplicatesQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	users, err := timedStore(r.Context(), h.users).GetAll()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	candidates := merge.Find(users, query.Threshold)
+	if len(candidates) > query.Limit {
+		candidates = candidates[:query.Limit]
+	}
+	if candidates == nil {
+		candidates = []merge.Candidate{}
+	}
+	writeJSON(w, http.StatusOK, candidates)
+}
+
+// @Summary Merge duplicate users
+// @Description Merge duplicates into a survivor, which keeps its name and email and takes the earliest creation time and latest activity of them all. Team memberships, keeping the roles of both, and rule audit entries, tags and suspensions move to the survivor, then the duplicates are deleted. It cannot be undone, and is written to the audit log. Admins only.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param merge body merge.Request true "Survivor and duplicates"
+// @Success 200 {object} merge.Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/merge [post]
+func (h *AdminHandler) MergeUsers(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req merge.Request
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := h.merger.Merge(r.Context(), req)
+	principal, _ := reqctx.PrincipalFrom(r.Context())
+	logger := logging.FromContext(r.Context(), logging.Store)
+	switch {
+	case errors.Is(err, merge.ErrInvalid):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, merge.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case err != nil:
+		logger.Error("Audit: merge failed", "survivor", req.Survivor, "duplicates", req.Duplicates, "merged", report.Merged, "remapped", report.Remapped, "admin", principal.Subject, "error", err)
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		logger.Info("Audit: users merged", "survivor", req.Survivor, "duplicates", req.Duplicates, "remapped", report.Remapped, "admin", principal.Subject)
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+type NotificationHandler struct {
+	userStore   store.UserStore
+	preferences notify.PreferenceStore
+}
+
+func NewNotificationHandler(userStore store.UserStore, preferences notify.PreferenceStore) *NotificationHandler {
+	return &NotificationHandler{
+		userStore:   userStore,
+		preferences: preferences,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *NotificationHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/users/{id}/notifications", Handler: http.HandlerFunc(h.GetPreferences)},
+		{Method: http.MethodPut, Path: "/api/v1/users/{id}/notifications", Handler: http.HandlerFunc(h.UpdatePreferences)},
+	}
+}
+
+// @Summary Get notification preferences
+// @Description Get a user's notification settings
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} notify.Preferences
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/users/{id}/notifications [get]
+func (h *NotificationHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.userID(w, r)
+	if !ok {
+		return
+	}
+
+	prefs, err := h.preferences.Get(id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// @Summary Update notification preferences
+// @Description Replace a user's notification settings, e.g. to opt out of SMS
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param preferences body notify.Preferences true "Notification preferences"
+// @Success 200 {object} notify.Preferences
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/users/{id}/notifications [put]
+func (h *NotificationHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.userID(w, r)
+	if !ok {
+		return
+	}
+
+	var prefs notify.Preferences
+	if err := decodeJSON(r, &prefs); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.preferences.Set(id, prefs); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	saved, err := h.preferences.Get(id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, saved)
+}
+
+// userID parses the user ID from the path and checks the user exists,
+// writing an error response when it does not
+func (h *NotificationHandler) userID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return 0, false
+	}
+	if _, err := timedStore(r.Context(), h.userStore).GetByID(id); err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+// operationsBase prefixes operation names to form their URLs
+const operationsBase = "/api/v1/"
+
+type OperationHandler struct {
+	operations *operations.Manager
+}
+
+func NewOperationHandler(manager *operations.Manager) *OperationHandler {
+	return &OperationHandler{
+		operations: manager,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *OperationHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/operations", Handler: http.HandlerFunc(h.GetOperations)},
+		{Method: http.MethodGet, Path: "/api/v1/operations/{id}", Handler: http.HandlerFunc(h.GetOperation)},
+		{Method: http.MethodPost, Path: "/api/v1/operations/{id}/cancel", Handler: http.HandlerFunc(h.CancelOperation)},
+		{Method: http.MethodDelete, Path: "/api/v1/operations/{id}", Handler: http.HandlerFunc(h.DeleteOperation)},
+	}
+}
+
+// @Summary List operations
+// @Description List the caller's operations, newest first; admins see everyone's
+// @Tags operations
+// @Accept json
+// @Produce json
+// @Success 200 {array} operations.Operation
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/operations [get]
+func (h *OperationHandler) GetOperations(w http.ResponseWriter, r *http.Request) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.operations.List(principal.Subject, principal.HasRole(auth.RoleAdmin)))
+}
+
+// @Summary Get an operation
+// @Description Get the state and progress of a request accepted for background processing, and its response or error once done. Operations submitted by an authenticated caller are only visible to them and to admins.
+// @Tags operations
+// @Accept json
+// @Produce json
+// @Param id path string true "Operation ID"
+// @Success 200 {object} operations.Operation
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/operations/{id} [get]
+func (h *OperationHandler) GetOperation(w http.ResponseWriter, r *http.Request) {
+	op, ok := h.visible(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
+}
+
+// @Summary Cancel an operation
+// @Description Cancel an operation. One still pending is cancelled straight away; one running is asked to stop and may still finish.
+// @Tags operations
+// @Accept json
+// @Produce json
+// @Param id path string true "Operation ID"
+// @Success 200 {object} operations.Operation
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/operations/{id}/cancel [post]
+func (h *OperationHandler) CancelOperation(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.visible(w, r); !ok {
+		return
+	}
+	op, err := h.operations.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, operations.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Operation not found")
+	case errors.Is(err, operations.ErrDone):
+		writeError(w, r, http.StatusConflict, "Operation is already "+op.Metadata.State)
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, op)
+	}
+}
+
+// @Summary Delete an operation
+// @Description Forget a finished operation before its TTL passes
+// @Tags operations
+// @Accept json
+// @Produce json
+// @Param id path string true "Operation ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/operations/{id} [delete]
+func (h *OperationHandler) DeleteOperation(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.visible(w, r); !ok {
+		return
+	}
+	err := h.operations.Delete(r.PathValue("id"))
+	switch {
+	case errors.Is(err, operations.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Operation not found")
+	case errors.Is(err, operations.ErrNotDone):
+		writeError(w, r, http.StatusConflict, "Operation is not done; cancel it first")
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// visible returns the operation named in r if its caller may see it,
+// otherwise writing 404
+func (h *OperationHandler) visible(w http.ResponseWriter, r *http.Request) (operations.Operation, bool) {
+	op, err := h.operations.Get(r.PathValue("id"))
+	if err != nil || !canSeeOperation(r, op) {
+		writeError(w, r, http.StatusNotFound, "Operation not found")
+		return operations.Operation{}, false
+	}
+	return op, true
+}
+
+// canSeeOperation reports whether the caller of r may see op. Others'
+// operations are reported as not found, so their IDs cannot be probed.
+func canSeeOperation(r *http.Request, op operations.Operation) bool {
+	if op.Owner == "" {
+		return true
+	}
+	return auth.Authorize(r.Context(), op.Owner) == nil
+}
+
+// asyncQuery holds the query parameter asking for a write to run in the
+// background
+type asyncQuery struct {
+	Async bool `query:"async"`
+}
+
+// submitOperation queues fn as an operation of kind for the caller of r,
+// writing 202 Accepted with the operation and a Location to poll, or an
+// error if it cannot be queued
+func submitOperation(w http.ResponseWriter, r *http.Request, manager *operations.Manager, kind string, fn operations.Func) {
+	var owner string
+	if principal, ok := reqctx.PrincipalFrom(r.Context()); ok {
+		owner = principal.Subject
+	}
+
+	op, err := manager.Submit(r.Context(), kind, owner, fn)
+	if err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, "Failed to queue request: "+err.Error())
+		return
+	}
+	w.Header().Set("Location", operationsBase+op.Name)
+	writeJSON(w, http.StatusAccepted, op)
+}
@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/graph"
+	"github.com/dazraf/go-api-example/internal/orgs"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/visibility"
+)
+
+// OrgRequest sets an organization's name and parent
+type OrgRequest struct {
+	Name string `json:"name" example:"Acme Europe"`
+	// ParentID places the organization below another; empty makes it
+	// top-level
+	ParentID string `json:"parent_id,omitempty" example:"9c0e8a1d4b7c9e6f5a4b3c2d1e0f3f2b"`
+}
+
+// MemberRequest sets the roles a member is granted in an organization
+type MemberRequest struct {
+	Roles []string `json:"roles" example:"admin"`
+}
+
+// OrgUser is a user in an organization
+type OrgUser struct {
+	User store.User `json:"user"`
+	// OrgID is the organization the user is a member of
+	OrgID string `json:"org_id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	// Roles are granted by the membership of OrgID
+	Roles []string `json:"roles" example:"billing"`
+	// EffectiveRoles add those granted in the organizations above OrgID
+	EffectiveRoles []string `json:"effective_roles" example:"admin,billing"`
+}
+
+// orgUsersQuery holds the query parameters of GetOrgUsers
+type orgUsersQuery struct {
+	Descendants bool `query:"descendants"`
+}
+
+// userGraphQuery holds the query parameters of GetUserGraph
+type userGraphQuery struct {
+	Depth int `query:"depth" default:"2" min:"1" max:"4"`
+}
+
+type OrgHandler struct {
+	orgs      *orgs.Store
+	userStore store.UserStore
+	graphs    *graph.Builder
+	fields    *visibility.Policy
+}
+
+func NewOrgHandler(orgStore *orgs.Store, userStore store.UserStore) *OrgHandler {
+	return &OrgHandler{
+		orgs:      orgStore,
+		userStore: userStore,
+		graphs:    graph.NewBuilder(userStore, orgStore),
+	}
+}
+
+// RestrictFields hides the user fields policy restricts from callers who
+// may not see them, in the users listed as members
+func (h *OrgHandler) RestrictFields(policy *visibility.Policy) {
+	h.fields = policy
+}
+
+// Routes returns the endpoints served by the handler
+func (h *OrgHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/orgs", Handler: http.HandlerFunc(h.GetOrgs)},
+		{Method: http.MethodPost, Path: "/api/v1/orgs", Handler: http.HandlerFunc(h.CreateOrg)},
+		{Method: http.MethodGet, Path: "/api/v1/orgs/{id}", Handler: http.HandlerFunc(h.GetOrg)},
+		{Method: http.MethodPut, Path: "/api/v1/orgs/{id}", Handler: http.HandlerFunc(h.UpdateOrg)},
+		{Method: http.MethodDelete, Path: "/api/v1/orgs/{id}", Handler: http.HandlerFunc(h.DeleteOrg)},
+		{Method: http.MethodGet, Path: "/api/v1/orgs/{id}/children", Handler: http.HandlerFunc(h.GetOrgChildren)},
+		{Method: http.MethodGet, Path: "/api/v1/orgs/{id}/users", Handler: http.HandlerFunc(h.GetOrgUsers)},
+		{Method: http.MethodGet, Path: "/api/v1/orgs/{id}/users/{userID}", Handler: http.HandlerFunc(h.GetOrgUser)},
+		{Method: http.MethodPut, Path: "/api/v1/orgs/{id}/users/{userID}", Handler: http.HandlerFunc(h.SetOrgUser)},
+		{Method: http.MethodDelete, Path: "/api/v1/orgs/{id}/users/{userID}", Handler: http.HandlerFunc(h.RemoveOrgUser)},
+		{Method: http.MethodGet, Path: "/api/v1/users/{id}/graph", Handler: http.HandlerFunc(h.GetUserGraph)},
+	}
+}
+
+// @Summary List organizations
+// @Description List every organization for admins, and the organizations the caller is a member of, directly or through a parent, for others
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Success 200 {array} orgs.Organization
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/orgs [get]
+func (h *OrgHandler) GetOrgs(w http.ResponseWriter, r *http.Request) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	all := h.orgs.List()
+	if principal.HasRole(auth.RoleAdmin) {
+		writeJSON(w, http.StatusOK, all)
+		return
+	}
+	visible := make([]orgs.Organization, 0, len(all))
+	if userID, err := store.ParseID(principal.Subject); err == nil {
+		for _, org := range all {
+			if h.orgs.IsMember(org.ID, userID) {
+				visible = append(visible, org)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// @Summary Create an organization
+// @Description Create a top-level organization, or one below a parent
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param org body OrgRequest true "Organization to create"
+// @Success 201 {object} orgs.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs [post]
+func (h *OrgHandler) CreateOrg(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req OrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	org, err := h.orgs.Create(orgs.Spec{Name: req.Name, ParentID: req.ParentID})
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, org)
+}
+
+// @Summary Get an organization
+// @Description Get an organization the caller is a member of, directly or through a parent
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Success 200 {object} orgs.Organization
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs/{id} [get]
+func (h *OrgHandler) GetOrg(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, org)
+}
+
+// @Summary Update an organization
+// @Description Rename an organization or move it, along with the organizations below it, under another parent
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param org body OrgRequest true "Name and parent"
+// @Success 200 {object} orgs.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/orgs/{id} [put]
+func (h *OrgHandler) UpdateOrg(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req OrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	org, err := h.orgs.Update(r.PathValue("id"), orgs.Spec{Name: req.Name, ParentID: req.ParentID})
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, org)
+}
+
+// @Summary Delete an organization
+// @Description Delete an organization without children, ending its memberships
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/orgs/{id} [delete]
+func (h *OrgHandler) DeleteOrg(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.orgs.Delete(r.PathValue("id")); err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary List child organizations
+// @Description List the organizations directly below an organization
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Success 200 {array} orgs.Organization
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs/{id}/children [get]
+func (h *OrgHandler) GetOrgChildren(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	children, err := h.orgs.Children(org.ID)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, children)
+}
+
+// @Summary List an organization's users
+// @Description List the members of an organization with their roles, including those of the organizations below it with descendants=true
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param descendants query bool false "Include the members of organizations below it"
+// @Success 200 {array} OrgUser
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs/{id}/users [get]
+func (h *OrgHandler) GetOrgUsers(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	var query orgUsersQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	members, err := h.orgs.Members(org.ID, query.Descendants)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+
+	users := timedStore(r.Context(), h.userStore)
+	orgUsers := make([]OrgUser, 0, len(members))
+	for _, member := range members {
+		user, err := users.GetByID(member.UserID)
+		if err != nil {
+			// Memberships outlive deleted users
+			continue
+		}
+		effective, err := h.orgs.Roles(member.OrgID, member.UserID)
+		if err != nil {
+			writeOrgError(w, r, err)
+			return
+		}
+		orgUsers = append(orgUsers, OrgUser{User: *user, OrgID: member.OrgID, Roles: member.Roles, EffectiveRoles: effective})
+	}
+	writeUsers(w, r, h.fields, orgUsers, func(member OrgUser) int64 { return member.User.ID })
+}
+
+// @Summary Get a user's roles in an organization
+// @Description Get the roles a user has in an organization, including those inherited from the organizations above it
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param userID path int true "User ID"
+// @Success 200 {object} OrgUser
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs/{id}/users/{userID} [get]
+func (h *OrgHandler) GetOrgUser(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	user, ok := h.member(w, r)
+	if !ok {
+		return
+	}
+	if !h.orgs.IsMember(org.ID, user.ID) {
+		writeOrgError(w, r, orgs.ErrNotMember)
+		return
+	}
+
+	// Members of a parent have no roles granted here
+	granted := []string{}
+	if member, err := h.orgs.Member(org.ID, user.ID); err == nil {
+		granted = member.Roles
+	}
+	effective, err := h.orgs.Roles(org.ID, user.ID)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	writeUser(w, r, h.fields, http.StatusOK, OrgUser{User: *user, OrgID: org.ID, Roles: granted, EffectiveRoles: effective}, user.ID)
+}
+
+// @Summary Add a user to an organization
+// @Description Make a user a member of an organization with roles, or replace the roles of their membership. The roles also apply in the organizations below it. Needs the admin role, or the admin role in the organization.
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param userID path int true "User ID"
+// @Param member body MemberRequest true "Roles to grant"
+// @Success 200 {object} orgs.Member
+// @Success 201 {object} orgs.Member
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs/{id}/users/{userID} [put]
+func (h *OrgHandler) SetOrgUser(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.authorize(w, r, true)
+	if !ok {
+		return
+	}
+	user, ok := h.member(w, r)
+	if !ok {
+		return
+	}
+	var req MemberRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	member, created, err := h.orgs.SetMember(org.ID, user.ID, req.Roles)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, member)
+}
+
+// @Summary Remove a user from an organization
+// @Description End a user's membership of an organization. Needs the admin role, or the admin role in the organization.
+// @Tags orgs
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param userID path int true "User ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/orgs/{id}/users/{userID} [delete]
+func (h *OrgHandler) RemoveOrgUser(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.authorize(w, r, true)
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "userID")
+	if !ok {
+		return
+	}
+	if err := h.orgs.RemoveMember(org.ID, userID); err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Get a user's relationship graph
+// @Description Get the organizations a user is a member of, the organizations above them, their other members and the user's managers, as nodes and edges, up to depth edges from the user. Managers are members with the manager role in an organization the user is a member of or one above it. Every node appears once however many paths lead to it, and graphs beyond 500 nodes are truncated. Users get their own graph, with only the organizations they can see; admins get anyone's.
+// @Tags orgs
+// @Produce json
+// @Param id path int true "User ID"
+// @Param depth query int false "Edges walked from the user, from 1 to 4" default(2)
+// @Success 200 {object} graph.Graph
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/users/{id}/graph [get]
+func (h *OrgHandler) GetUserGraph(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathID(w, r, "id")
+	if !ok || !requireOwner(w, r, auth.UserOwner(userID), "Not allowed to see this user's graph") {
+		return
+	}
+	var query userGraphQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+
+	// Users only see the organizations they are members of
+	var visible func(orgID string) bool
+	if principal, _ := reqctx.PrincipalFrom(r.Context()); !principal.HasRole(auth.RoleAdmin) {
+		visible = func(orgID string) bool { return h.orgs.IsMember(orgID, userID) }
+	}
+	result, err := h.graphs.Build(userID, query.Depth, visible)
+	switch {
+	case errors.Is(err, graph.ErrNotFound):
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// authorize returns the organization in the path if the caller may see it,
+// or manage its members when manage is set, writing an error otherwise.
+// Admins may do both anywhere; other users may see the organizations they
+// are members of and manage those where they have the admin role,
+// including through a parent.
+func (h *OrgHandler) authorize(w http.ResponseWriter, r *http.Request, manage bool) (orgs.Organization, bool) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return orgs.Organization{}, false
+	}
+	org, err := h.orgs.Get(r.PathValue("id"))
+	if err != nil {
+		writeOrgError(w, r, err)
+		return orgs.Organization{}, false
+	}
+	if principal.HasRole(auth.RoleAdmin) {
+		return org, true
+	}
+
+	// Callers such as API keys are not users, so only have their own roles
+	if userID, err := store.ParseID(principal.Subject); err == nil && h.orgs.IsMember(org.ID, userID) {
+		roles, err := h.orgs.Roles(org.ID, userID)
+		if err == nil && (!manage || slices.Contains(roles, auth.RoleAdmin)) {
+			return org, true
+		}
+	}
+	writeError(w, r, http.StatusForbidden, "Not allowed in this organization")
+	return orgs.Organization{}, false
+}
+
+// member returns the user in the path, writing an error if there is none
+func (h *OrgHandler) member(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	userID, ok := pathID(w, r, "userID")
+	if !ok {
+		return nil, false
+	}
+	user, err := timedStore(r.Context(), h.userStore).GetByID(userID)
+	if err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return nil, false
+	}
+	return user, true
+}
+
+func writeOrgError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, orgs.ErrNotFound), errors.Is(err, orgs.ErrNotMember):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, orgs.ErrCycle), errors.Is(err, orgs.ErrHasChildren):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/visibility"
+)
+
+// versionETag returns the entity tag of user's version, which changes with
+// every update but not as the user is seen
+func versionETag(user store.User) string {
+	return `"` + strconv.FormatInt(user.Version, 10) + `"`
+}
+
+// userETag returns the entity tag of user as written for the caller of r:
+// its version, followed by a hash of what else the body depends on when
+// there is any. That is when the user was last seen, which changes without
+// a new version, and the caller's time zone and the fields hidden from them.
+func userETag(r *http.Request, policy *visibility.Policy, user store.User) string {
+	var variant strings.Builder
+	writeVariant(&variant, r, policy, user)
+	if variant.Len() == 0 {
+		return versionETag(user)
+	}
+	hash := fnv.New64a()
+	_, _ = io.WriteString(hash, variant.String())
+	return fmt.Sprintf(`"%d-%x"`, user.Version, hash.Sum64())
+}
+
+// usersETag returns the entity tag of a list of users as written for the
+// caller of r, which changes as users join or leave it, move in it or are
+// updated or seen, and with the caller's time zone and hidden fields
+func usersETag(r *http.Request, policy *visibility.Policy, users []store.User) string {
+	return hashETag(func(w io.Writer) {
+		for _, user := range users {
+			_, _ = fmt.Fprintf(w, "%d:%d:", user.ID, user.Version)
+			writeVariant(w, r, policy, user)
+			_, _ = io.WriteString(w, ";")
+		}
+	})
+}
+
+// writeVariant writes what user's body depends on beyond its version,
+// writing nothing when that is nothing
+func writeVariant(w io.Writer, r *http.Request, policy *visibility.Policy, user store.User) {
+	if user.LastSeenAt != nil {
+		_, _ = fmt.Fprintf(w, "seen=%d,", user.LastSeenAt.UnixNano())
+	}
+	if loc, ok := reqctx.Timezone(r.Context()); ok {
+		_, _ = fmt.Fprintf(w, "tz=%s,", loc)
+	}
+	if hidden := policy.Hidden(r.Context(), strconv.FormatInt(user.ID, 10)); len(hidden) > 0 {
+		_, _ = fmt.Fprintf(w, "hidden=%s,", strings.Join(hidden, "+"))
+	}
+}
+
+// hashETag returns an entity tag hashing what write writes
+func hashETag(write func(w io.Writer)) string {
+	hash := fnv.New64a()
+	write(hash)
+	return `"` + strconv.FormatUint(hash.Sum64(), 16) + `"`
+}
+
+// notModified sets the response's ETag, and writes 304 Not Modified when
+// the request's If-None-Match already has it
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	// If-None-Match compares weakly, ignoring W/
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// anyVersion is the version expected by writes that apply whatever version
+// the user is at: those without If-Match, or with If-Match: *
+const anyVersion int64 = -1
+
+var (
+	// errPreconditionRequired refuses writes without If-Match when
+	// preconditions are required
+	errPreconditionRequired = errors.New("If-Match required: send the ETag of the user being changed")
+	// errPreconditionFailed refuses writes from a version of the user that
+	// is no longer current
+	errPreconditionFailed = errors.New("User has changed since the ETag in If-Match was read")
+)
+
+// expectedVersion returns the version of the user the request's If-Match
+// names, or anyVersion without If-Match or with *. Only the version in a
+// tag is compared, as the rest depends on who read the user rather than on
+// what they read. Without If-Match, it fails with errPreconditionRequired
+// when required is true; tags that are not a version cannot match, so fail
+// with errPreconditionFailed.
+func expectedVersion(r *http.Request, required bool) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case header == "" && required:
+		return 0, errPreconditionRequired
+	case header == "", header == "*":
+		return anyVersion, nil
+	}
+	tag, quoted := strings.CutPrefix(header, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	tag, _, _ = strings.Cut(tag, "-")
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if !quoted || !closed || err != nil || version < 0 {
+		return 0, errPreconditionFailed
+	}
+	return version, nil
+}
+
+// ifMatch returns the version of the user the request's If-Match names, as
+// expectedVersion does, otherwise writing 428 or 412
+func (h *UserHandler) ifMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	version, err := expectedVersion(r, h.preconditions)
+	switch {
+	case errors.Is(err, errPreconditionRequired):
+		writeError(w, r, http.StatusPreconditionRequired, err.Error())
+		return 0, false
+	case err != nil:
+		writePreconditionFailed(w, r)
+		return 0, false
+	}
+	return version, true
+}
+
+// updateVersion updates the user id in s while it is at version, or
+// whatever version it is at for anyVersion
+func updateVersion(s store.UserStore, id, version int64, user store.User) (*store.User, error) {
+	if version == anyVersion {
+		return s.Update(id, user)
+	}
+	return store.UpdateVersion(s, id, version, user)
+}
+
+// deleteVersion deletes the user id from s while it is at version, or
+// whatever version it is at for anyVersion
+func deleteVersion(s store.UserStore, id, version int64) error {
+	if version == anyVersion {
+		return s.Delete(id)
+	}
+	return store.DeleteVersion(s, id, version)
+}
+
+// writePreconditionFailed writes a 412 for a write from a version of the
+// user that is no longer current
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeCodedError(w, r, http.StatusPreconditionFailed, apierrors.PreconditionFailed, errPreconditionFailed.Error())
+}
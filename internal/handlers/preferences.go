@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/preferences"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// PreferencesRequest sets the caller's API preferences; omitted fields use
+// the server's defaults
+type PreferencesRequest struct {
+	PageSize int    `json:"page_size,omitempty" example:"50"`
+	Timezone string `json:"timezone,omitempty" example:"Europe/London"`
+	Locale   string `json:"locale,omitempty" example:"en-GB"`
+}
+
+// RateProfileRequest sets the rate limit profile of a user
+type RateProfileRequest struct {
+	// Profile is a configured profile, or empty for the default
+	Profile string `json:"profile" example:"elevated"`
+}
+
+type PreferenceHandler struct {
+	preferences *preferences.Store
+	userStore   store.UserStore
+}
+
+func NewPreferenceHandler(preferenceStore *preferences.Store, userStore store.UserStore) *PreferenceHandler {
+	return &PreferenceHandler{
+		preferences: preferenceStore,
+		userStore:   userStore,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *PreferenceHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/me/preferences", Handler: http.HandlerFunc(h.GetMyPreferences)},
+		{Method: http.MethodPut, Path: "/api/v1/me/preferences", Handler: http.HandlerFunc(h.SetMyPreferences)},
+	}
+}
+
+// AdminRoutes returns the endpoints for managing other users' preferences
+func (h *PreferenceHandler) AdminRoutes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/users/{id}/preferences", Handler: http.HandlerFunc(h.GetUserPreferences)},
+		{Method: http.MethodPut, Path: "/admin/users/{id}/rate-profile", Handler: http.HandlerFunc(h.SetRateProfile)},
+	}
+}
+
+// @Summary Get my preferences
+// @Description Get the caller's API preferences, including the rate limit profile admins set for them
+// @Tags preferences
+// @Accept json
+// @Produce json
+// @Success 200 {object} preferences.Preferences
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/v1/me/preferences [get]
+func (h *PreferenceHandler) GetMyPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.preferences.Get(userID))
+}
+
+// @Summary Set my preferences
+// @Description Replace the caller's API preferences, which apply to their requests from then on: page_size is the default limit of paged endpoints, timezone is the zone user timestamps are written in and locale is returned as Content-Language
+// @Tags preferences
+// @Accept json
+// @Produce json
+// @Param preferences body PreferencesRequest true "Preferences"
+// @Success 200 {object} preferences.Preferences
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/v1/me/preferences [put]
+func (h *PreferenceHandler) SetMyPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	var req PreferencesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	prefs, err := h.preferences.Set(userID, preferences.Preferences{PageSize: req.PageSize, Timezone: req.Timezone, Locale: req.Locale})
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// @Summary Get a user's preferences
+// @Description Get a user's API preferences and rate limit profile
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} preferences.Preferences
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/users/{id}/preferences [get]
+func (h *PreferenceHandler) GetUserPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.preferences.Get(userID))
+}
+
+// @Summary Set a user's rate limit profile
+// @Description Count a user's requests against another configured rate limit profile, or the default with an empty profile
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param profile body RateProfileRequest true "Rate limit profile"
+// @Success 200 {object} preferences.Preferences
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/users/{id}/rate-profile [put]
+func (h *PreferenceHandler) SetRateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	var req RateProfileRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	prefs, err := h.preferences.SetRateProfile(userID, req.Profile)
+	if errors.Is(err, preferences.ErrUnknownProfile) {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// adminTarget returns the ID of the existing user in the path, if the
+// caller is an admin, writing an error otherwise
+func (h *PreferenceHandler) adminTarget(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if !requireAdmin(w, r) {
+		return 0, false
+	}
+	userID, ok := pathID(w, r, "id")
+	if !ok {
+		return 0, false
+	}
+	if _, err := timedStore(r.Context(), h.userStore).GetByID(userID); err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/replication"
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+type ReplicationHandler struct {
+	replicator *replication.Store
+}
+
+func NewReplicationHandler(replicator *replication.Store) *ReplicationHandler {
+	return &ReplicationHandler{
+		replicator: replicator,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *ReplicationHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/replication", Handler: http.HandlerFunc(h.GetReplication)},
+		{Method: http.MethodPost, Path: "/admin/replication/reconcile", Handler: http.HandlerFunc(h.Reconcile)},
+	}
+}
+
+// PeerRoutes returns the endpoints the other instances replicate through,
+// authenticated with the replication secret rather than as API callers
+func (h *ReplicationHandler) PeerRoutes() []router.Route {
+	return h.replicator.Routes()
+}
+
+// @Summary Get replication status
+// @Description Describe replication between instances: this node, the writes waiting for each other node and when it last answered, and counts of the writes applied, conflicting and dropped
+// @Tags admin
+// @Produce json
+// @Success 200 {object} replication.Status
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/replication [get]
+func (h *ReplicationHandler) GetReplication(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.replicator.Status())
+}
+
+// @Summary Reconcile with the other instances
+// @Description Pull the state of every other instance now and apply the writes this one missed, rather than waiting for the next periodic reconciliation. Conflicting writes are resolved as when streamed: the last writer wins.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} replication.ReconcileResult
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/replication/reconcile [post]
+func (h *ReplicationHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.replicator.Reconcile(r.Context()))
+}
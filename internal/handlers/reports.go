@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/reports"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// reportQuery holds the query parameters of GetUsersReport
+type reportQuery struct {
+	// Async defaults to whether there are more users than the sync limit
+	Async *bool `query:"async"`
+}
+
+// @Summary Get a users report
+// @Description Render a paginated PDF report of users: summary counts, the latest signups and a table of every user. Small reports are returned straight away. Above the configured number of users, or with async=true, the report is generated in the background: the response is 202 with an operation to poll, whose response gives the URL to download the report from until it expires. Admins only.
+// @Tags admin
+// @Produce application/pdf
+// @Produce json
+// @Param async query bool false "Generate in the background; defaults to true above the configured number of users"
+// @Success 200 {file} binary
+// @Success 202 {object} operations.Operation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /admin/reports/users.pdf [get]
+func (h *AdminHandler) GetUsersReport(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var query reportQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	// Users are read now, so the report shows them as of the request even
+	// when generated later
+	users, err := snapshotUsers(h.users)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	canStore := h.operations != nil && h.reports.CanStore()
+	async := canStore && len(users) > h.reportSyncLimit
+	if query.Async != nil {
+		async = *query.Async
+	}
+	if async {
+		if !canStore {
+			writeError(w, r, http.StatusBadRequest, "Background reports need operations and uploads enabled")
+			return
+		}
+		submitOperation(w, r, h.operations, "report.users", func(ctx context.Context, progress operations.Progress) (any, error) {
+			return h.reports.StoreUsers(ctx, users, progress)
+		})
+		return
+	}
+
+	// Rendered in full first, so failures still get an error response
+	var buf bytes.Buffer
+	if err := h.reports.WriteUsers(&buf, users, nil); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", reports.ContentType)
+	w.Header().Set("Content-Disposition", reportDisposition(time.Now()))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// @Summary Download a report
+// @Description Download a report generated in the background, until it expires. Admins only.
+// @Tags admin
+// @Produce application/pdf
+// @Param id path string true "Report ID"
+// @Success 200 {file} binary
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/reports/{id} [get]
+func (h *AdminHandler) DownloadReport(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	report, file, err := h.reports.Open(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, reports.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Report not found")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", reports.ContentType)
+	w.Header().Set("Content-Disposition", reportDisposition(report.CreatedAt))
+	w.Header().Set("Content-Length", strconv.FormatInt(report.Size, 10))
+	// Stored reports may not be seekable, as with S3, so are copied out
+	// rather than served with ranges
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, file)
+}
+
+// reportDisposition returns the Content-Disposition of a report created at
+func reportDisposition(at time.Time) string {
+	return fmt.Sprintf(`attachment; filename="users-%s.pdf"`, at.UTC().Format("20060102"))
+}
+
+// requireAdmin reports whether the caller of r is an admin, writing an
+// error response otherwise
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return false
+	}
+	if !principal.HasRole(auth.RoleAdmin) {
+		writeCodedError(w, r, http.StatusForbidden, apierrors.AdminRequired, "Admin role required")
+		return false
+	}
+	return true
+}
+
+// requireOwner reports whether the caller of r may act on a resource owned
+// by owner, writing an error response with forbidden otherwise
+func requireOwner(w http.ResponseWriter, r *http.Request, owner, forbidden string) bool {
+	switch err := auth.Authorize(r.Context(), owner); {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return false
+	case err != nil:
+		writeError(w, r, http.StatusForbidden, forbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/codec"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/validation"
+	"github.com/dazraf/go-api-example/pkg/httpx"
+)
+
+// decodeJSON decodes the request body into v
+func decodeJSON(r *http.Request, v any) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return errors.New("request body is empty")
+	}
+	return codec.NewDecoder(r.Body).Decode(v)
+}
+
+// maxFormMemory is how much of a multipart form is held in memory, the
+// rest going to temporary files
+const maxFormMemory = 1 << 20
+
+// decodeResource decodes a resource described by schema into v, from a
+// body negotiated by Content-Type: JSON, or URL-encoded or multipart form
+// fields coerced to the schema's types. Requests without a body give the
+// fields as query parameters instead, for clients that cannot send one.
+// Fields that are not the schema's properties are ignored, as they are in
+// JSON.
+func decodeResource(r *http.Request, schema *schemas.Schema, v any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var values url.Values
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		// PostForm leaves out the query, which r.Form merges in
+		values = r.PostForm
+	case mediaType == "multipart/form-data":
+		if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+			return err
+		}
+		defer func() { _ = r.MultipartForm.RemoveAll() }()
+		values = r.MultipartForm.Value
+	case r.Body == nil || r.Body == http.NoBody:
+		values = r.URL.Query()
+	default:
+		return decodeJSON(r, v)
+	}
+
+	object, err := schema.Coerce(values)
+	if err != nil {
+		return err
+	}
+	if len(object) == 0 && mediaType == "" {
+		return errors.New("request body is empty")
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return codec.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// bindQuery binds r's query parameters into dst with httpx.BindQuery,
+// writing a 400 response naming the invalid parameter when one fails
+func bindQuery(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return bindValues(w, r, r.URL.Query(), dst)
+}
+
+// bindValues is bindQuery for parameters other than r's own, such as those
+// merged from a saved view
+func bindValues(w http.ResponseWriter, r *http.Request, values url.Values, dst any) bool {
+	err := httpx.BindValues(values, dst)
+	if err == nil {
+		return true
+	}
+	var invalid *httpx.Error
+	if errors.As(err, &invalid) {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, "Invalid "+invalid.Param+": "+invalid.Message)
+		return false
+	}
+	writeError(w, r, http.StatusInternalServerError, err.Error())
+	return false
+}
+
+// bound reports whether v passes the rules of its binding tags, writing
+// a 400 response listing the fields that fail otherwise
+func bound(w http.ResponseWriter, r *http.Request, v any) bool {
+	problems := validation.Struct(v)
+	if len(problems) == 0 {
+		return true
+	}
+	writeProblems(w, r, http.StatusBadRequest, apierrors.ValidationFailed, "Invalid request body", problems)
+	return false
+}
+
+// pathID returns the user ID in r's path parameter name, writing a 400
+// response when it is not a positive integer that fits an int64
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := store.ParseID(r.PathValue(name))
+	if err != nil {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, "Invalid user ID")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/codec"
+	"github.com/dazraf/go-api-example/internal/materialized"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/schemas"
+)
+
+const (
+	jsonContentType = "application/json; charset=utf-8"
+
+	// estimatedUserJSONSize is a rough encoded size of one user, used to
+	// pre-size list response buffers
+	estimatedUserJSONSize = 80
+	// maxPooledBufferSize stops unusually large responses from pinning
+	// memory in the pool
+	maxPooledBufferSize = 1 << 20
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	writeSizedJSON(w, status, v, 0)
+}
+
+// writeError writes an ErrorResponse with the given message and the code
+// of its status, tagged with the request's trace ID so it can be matched to
+// the logs
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeProblems(w, r, status, apierrors.ForStatus(status), message, nil)
+}
+
+// writeCodedError is writeError for errors with a code of their own
+func writeCodedError(w http.ResponseWriter, r *http.Request, status int, code apierrors.Code, message string) {
+	writeProblems(w, r, status, code, message, nil)
+}
+
+// writeProblems is writeCodedError listing the fields of the request body
+// at fault and what is wrong with each
+func writeProblems(w http.ResponseWriter, r *http.Request, status int, code apierrors.Code, message string, problems []schemas.Problem) {
+	response := ErrorResponse{Error: message, Code: code, Errors: problems}
+	if trace, ok := reqctx.Trace(r.Context()); ok {
+		response.TraceID = trace.TraceID
+	}
+	writeJSON(w, status, response)
+}
+
+// writeSizedJSON encodes v into a pooled buffer and writes it as the
+// response body. sizeHint, when positive, pre-sizes the buffer to avoid
+// regrowth.
+func writeSizedJSON(w http.ResponseWriter, status int, v any, sizeHint int) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	if sizeHint > 0 {
+		buf.Grow(sizeHint)
+	}
+
+	if err := codec.NewEncoder(buf).Encode(v); err != nil {
+		buf.Reset()
+		if err := codec.NewEncoder(buf).Encode(ErrorResponse{Error: err.Error(), Code: apierrors.Internal}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		status = http.StatusInternalServerError
+	}
+
+	writeData(w, status, buf.Bytes())
+}
+
+// writeData writes an already encoded JSON body
+func writeData(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// setAsOf sets the X-Data-As-Of header of a response served from results
+// last known to be current at asOf
+func setAsOf(w http.ResponseWriter, asOf time.Time) {
+	w.Header().Set(materialized.AsOfHeader, asOf.UTC().Format(time.RFC3339))
+}
+
+// encodeJSON encodes v into a new byte slice that the caller may retain
+func encodeJSON(v any, sizeHint int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, sizeHint))
+	if err := codec.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// RuleEvaluationRequest is a user event to evaluate the rules for. Give
+// user_id to evaluate a stored user, user to evaluate one as given, or both
+// to evaluate an update of the stored user to user.
+type RuleEvaluationRequest struct {
+	Event  string      `json:"event" example:"user.created"`
+	UserID int64       `json:"user_id,omitempty" example:"1"`
+	User   *store.User `json:"user,omitempty"`
+	// Before is the user before an update, when user_id is not given
+	Before *store.User `json:"before,omitempty"`
+}
+
+// RuleEvaluationResponse is the outcome of every rule reacting to the event
+type RuleEvaluationResponse struct {
+	Event string             `json:"event" example:"user.created"`
+	Rules []rules.Evaluation `json:"rules"`
+}
+
+// ruleAuditQuery holds the query parameters of GetRuleAudit
+type ruleAuditQuery struct {
+	UserID int64 `query:"user_id" default:"0" min:"0"`
+	Limit  int   `query:"limit" default:"100" min:"1" max:"1000"`
+}
+
+type RuleHandler struct {
+	userStore store.UserStore
+	rules     *rules.Engine
+}
+
+func NewRuleHandler(userStore store.UserStore, engine *rules.Engine) *RuleHandler {
+	return &RuleHandler{
+		userStore: userStore,
+		rules:     engine,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *RuleHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodPost, Path: "/admin/rules/evaluate", Handler: http.HandlerFunc(h.EvaluateRules)},
+		{Method: http.MethodGet, Path: "/admin/rules/audit", Handler: http.HandlerFunc(h.GetRuleAudit)},
+		{Method: http.MethodGet, Path: "/admin/rules/users/{id}", Handler: http.HandlerFunc(h.GetRuleStatus)},
+		{Method: http.MethodDelete, Path: "/admin/rules/users/{id}/suspension", Handler: http.HandlerFunc(h.LiftSuspension)},
+	}
+}
+
+// @Summary Evaluate rules
+// @Description Dry run the rules for a user event: say which rules match and the actions they would trigger, without running them
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param event body RuleEvaluationRequest true "Event to evaluate"
+// @Success 200 {object} RuleEvaluationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/rules/evaluate [post]
+func (h *RuleHandler) EvaluateRules(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req RuleEvaluationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, before := req.User, req.Before
+	if req.UserID != 0 {
+		stored, err := timedStore(r.Context(), h.userStore).GetByID(req.UserID)
+		if err != nil {
+			writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+			return
+		}
+		before = nil
+		if user == nil {
+			user = stored
+		} else {
+			updated := *user
+			updated.ID, updated.CreatedAt = stored.ID, stored.CreatedAt
+			user, before = &updated, stored
+		}
+	}
+	if user == nil {
+		writeError(w, r, http.StatusBadRequest, "Either user_id or user is required")
+		return
+	}
+
+	evaluations, err := h.rules.Evaluate(r.Context(), req.Event, *user, before)
+	if errors.Is(err, rules.ErrUnknownEvent) {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, RuleEvaluationResponse{Event: req.Event, Rules: evaluations})
+}
+
+// @Summary Get the rule audit log
+// @Description Get the most recent actions rules triggered, newest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param user_id query int false "Only actions on this user"
+// @Param limit query int false "Maximum number of entries to return" default(100)
+// @Success 200 {array} rules.Entry
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/rules/audit [get]
+func (h *RuleHandler) GetRuleAudit(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var query ruleAuditQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.rules.Audit(query.UserID, query.Limit))
+}
+
+// @Summary Get what rules did to a user
+// @Description Get the tags rules gave a user, and their suspension if a rule suspended them
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} rules.Status
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/rules/users/{id} [get]
+func (h *RuleHandler) GetRuleStatus(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	userID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := timedStore(r.Context(), h.userStore).GetByID(userID); err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.rules.Status(userID))
+}
+
+// @Summary Lift a suspension
+// @Description Let a user a rule suspended log in and use their sessions again. The rule suspends them again if it matches another event.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/rules/users/{id}/suspension [delete]
+func (h *RuleHandler) LiftSuspension(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	userID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if !h.rules.Lift(userID) {
+		writeError(w, r, http.StatusNotFound, "User is not suspended")
+		return
+	}
+	principal, _ := reqctx.PrincipalFrom(r.Context())
+	logging.FromContext(r.Context(), logging.Events).Info("Audit: suspension lifted", "user_id", userID, "admin", principal.Subject)
+	w.WriteHeader(http.StatusNoContent)
+}
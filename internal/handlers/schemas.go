@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dazraf/go-api-example/internal/codec"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// resourceSchemas are the JSON Schemas of the API's resources, by name,
+// generated from their types
+var resourceSchemas = map[string]*schemas.Schema{
+	"user": schemas.Generate(store.User{}),
+}
+
+type SchemaHandler struct {
+	// registry holds the event schemas, when webhooks are enabled
+	registry *schemas.Registry
+}
+
+func NewSchemaHandler(registry *schemas.Registry) *SchemaHandler {
+	return &SchemaHandler{
+		registry: registry,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *SchemaHandler) Routes() []router.Route {
+	routes := []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/schema/{resource}", Handler: http.HandlerFunc(h.GetResourceSchema)},
+	}
+	if h.registry != nil {
+		routes = append(routes,
+			router.Route{Method: http.MethodGet, Path: "/schemas", Handler: http.HandlerFunc(h.ListSchemas)},
+			router.Route{Method: http.MethodGet, Path: schemas.Path + "{type}/{version}", Handler: http.HandlerFunc(h.GetSchema)},
+		)
+	}
+	return routes
+}
+
+// @Summary Get a resource schema
+// @Description Get the JSON Schema of a resource, such as user, e.g. to build forms. Properties marked readOnly are set by the server. POST /api/v1/users/validate checks a user against it.
+// @Tags schemas
+// @Produce json
+// @Param resource path string true "Resource" example(user)
+// @Success 200 {object} schemas.Schema
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/schema/{resource} [get]
+func (h *SchemaHandler) GetResourceSchema(w http.ResponseWriter, r *http.Request) {
+	schema, ok := resourceSchemas[r.PathValue("resource")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Schema not found")
+		return
+	}
+	writeSchema(w, schema)
+}
+
+// @Summary List event schemas
+// @Description List every version of the JSON Schemas of the event payloads sent to webhooks. Events name the schema they match in their ce-dataschema header.
+// @Tags schemas
+// @Produce json
+// @Success 200 {array} schemas.Ref
+// @Router /schemas [get]
+func (h *SchemaHandler) ListSchemas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.registry.List())
+}
+
+// @Summary Get an event schema
+// @Description Get a version of the JSON Schema of an event type's payload
+// @Tags schemas
+// @Produce json
+// @Param type path string true "Event type" example(user.created)
+// @Param version path int true "Schema version"
+// @Success 200 {object} schemas.Schema
+// @Failure 404 {object} ErrorResponse
+// @Router /schemas/{type}/{version} [get]
+func (h *SchemaHandler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "Schema not found")
+		return
+	}
+	schema, err := h.registry.Get(r.PathValue("type"), version)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "Schema not found")
+		return
+	}
+	writeSchema(w, schema)
+}
+
+// writeSchema writes a JSON Schema with its own media type
+func writeSchema(w http.ResponseWriter, schema *schemas.Schema) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_ = codec.NewEncoder(w).Encode(schema)
+}
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/plugins"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/scim"
+	"github.com/dazraf/go-api-example/internal/scripts"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/pkg/httpx"
+)
+
+// scimUsersPath is where SCIM User resources are served
+const scimUsersPath = "/scim/v2/Users"
+
+// SCIMHandler serves users as SCIM 2.0 resources, so identity providers can
+// provision them. A user's userName and primary email are both its email,
+// and its name is formatted, given and family names joined. Users only
+// exist while active: deactivating one deletes it.
+type SCIMHandler struct {
+	users      *UserHandler
+	token      string
+	maxResults int
+}
+
+// NewSCIMHandler creates a handler writing through users, so listeners and
+// the recycle bin see SCIM writes, for callers presenting token. Lists hold
+// at most maxResults users.
+func NewSCIMHandler(users *UserHandler, token string, maxResults int) *SCIMHandler {
+	if maxResults <= 0 {
+		maxResults = 200
+	}
+	return &SCIMHandler{
+		users:      users,
+		token:      token,
+		maxResults: maxResults,
+	}
+}
+
+// Routes returns the endpoints served by the handler, each requiring the
+// bearer token
+func (h *SCIMHandler) Routes() []router.Route {
+	routes := []router.Route{
+		{Method: http.MethodGet, Path: "/scim/v2/ServiceProviderConfig", Handler: http.HandlerFunc(h.GetServiceProviderConfig)},
+		{Method: http.MethodGet, Path: scimUsersPath, Handler: http.HandlerFunc(h.ListUsers)},
+		{Method: http.MethodPost, Path: scimUsersPath, Handler: http.HandlerFunc(h.CreateUser)},
+		{Method: http.MethodGet, Path: scimUsersPath + "/{id}", Handler: http.HandlerFunc(h.GetUser)},
+		{Method: http.MethodPut, Path: scimUsersPath + "/{id}", Handler: http.HandlerFunc(h.ReplaceUser)},
+		{Method: http.MethodPatch, Path: scimUsersPath + "/{id}", Handler: http.HandlerFunc(h.PatchUser)},
+		{Method: http.MethodDelete, Path: scimUsersPath + "/{id}", Handler: http.HandlerFunc(h.DeleteUser)},
+	}
+	return router.Wrap(routes, h.authenticate)
+}
+
+// authenticate rejects requests without the bearer token
+func (h *SCIMHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, scim.NewError(http.StatusUnauthorized, "", "A valid bearer token is required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary Get SCIM service provider configuration
+// @Description Describe the SCIM features supported, for identity providers
+// @Tags scim
+// @Produce json
+// @Success 200 {object} scim.ServiceProviderConfig
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/ServiceProviderConfig [get]
+func (h *SCIMHandler) GetServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, scim.ServiceProviderConfig{
+		Schemas: []string{scim.SchemaServiceProviderConfig},
+		Patch:   scim.Supported{Supported: true},
+		Filter:  scim.Filtering{Supported: true, MaxResults: h.maxResults},
+		AuthenticationSchemes: []scim.AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "A static bearer token in the Authorization header",
+		}},
+	})
+}
+
+// scimListQuery holds the query parameters of ListUsers
+type scimListQuery struct {
+	Filter     string `query:"filter"`
+	StartIndex int    `query:"startIndex" default:"1"`
+	Count      *int   `query:"count" min:"0"`
+}
+
+// @Summary List SCIM users
+// @Description List users as SCIM resources in ID order, optionally filtered, e.g. userName eq "john@example.com"
+// @Tags scim
+// @Produce json
+// @Param filter query string false "SCIM filter expression"
+// @Param startIndex query int false "1-based index of the first result" default(1)
+// @Param count query int false "Maximum number of results"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/Users [get]
+func (h *SCIMHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	var query scimListQuery
+	if err := httpx.BindQuery(r, &query); err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, err.Error()))
+		return
+	}
+	var filter *scim.Filter
+	if query.Filter != "" {
+		var err error
+		if filter, err = scim.ParseFilter(query.Filter); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+	}
+
+	users, err := timedStore(r.Context(), h.users.userStore).GetAll()
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	slices.SortFunc(users, func(a, b store.User) int { return cmp.Compare(a.ID, b.ID) })
+	matched := []scim.User{}
+	for _, user := range users {
+		resource := toSCIMUser(user)
+		if filter == nil || filter.Matches(resource) {
+			matched = append(matched, resource)
+		}
+	}
+
+	count := h.maxResults
+	if query.Count != nil {
+		count = min(*query.Count, h.maxResults)
+	}
+	start := max(query.StartIndex, 1)
+	page := matched[min(start-1, len(matched)):min(start-1+count, len(matched))]
+	writeSCIM(w, http.StatusOK, scim.NewListResponse(page, start, len(matched)))
+}
+
+// @Summary Get a SCIM user
+// @Description Get a user as a SCIM resource
+// @Tags scim
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} scim.User
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [get]
+func (h *SCIMHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", versionETag(*user))
+	writeSCIM(w, http.StatusOK, toSCIMUser(*user))
+}
+
+// @Summary Create a SCIM user
+// @Description Provision a user. Its email is the primary email, or the userName when that is an email address, and must not be taken.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param user body scim.User true "SCIM user"
+// @Success 201 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users [post]
+func (h *SCIMHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var resource scim.User
+	if err := decodeJSON(r, &resource); err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error()))
+		return
+	}
+	user, err := h.fromSCIMUser(0, resource)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	if !resource.IsActive() {
+		writeSCIMError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "Inactive users cannot be created"))
+		return
+	}
+	if err := h.users.validate(r.Context(), user); err != nil {
+		if errors.Is(err, plugins.ErrRejected) || errors.Is(err, scripts.ErrRejected) {
+			err = scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, err.Error())
+		}
+		writeSCIMError(w, err)
+		return
+	}
+
+	created, err := h.users.createUser(r.Context(), user)
+	if errors.Is(err, store.ErrDuplicateEmail) {
+		err = errEmailTaken()
+	}
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	w.Header().Set("Location", scimUsersPath+"/"+strconv.FormatInt(created.ID, 10))
+	writeSCIM(w, http.StatusCreated, toSCIMUser(*created))
+}
+
+// @Summary Replace a SCIM user
+// @Description Replace a user's name and email. Replacing it with an inactive user deletes it.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param user body scim.User true "SCIM user"
+// @Param If-Match header string false "meta.version of the user being replaced; refused with 412 if the user has changed since, and required when preconditions are"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Failure 412 {object} scim.Error
+// @Failure 428 {object} scim.Error
+// @Router /scim/v2/Users/{id} [put]
+func (h *SCIMHandler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.user(w, r)
+	if !ok || !h.ifMatch(w, r, *existing) {
+		return
+	}
+	var resource scim.User
+	if err := decodeJSON(r, &resource); err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error()))
+		return
+	}
+	h.replace(w, r, *existing, resource)
+}
+
+// @Summary Patch a SCIM user
+// @Description Add, replace or remove user attributes. Setting active to false deletes the user.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param patch body scim.PatchOp true "PATCH operations"
+// @Param If-Match header string false "meta.version of the user being patched; refused with 412 if the user has changed since, and required when preconditions are"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Failure 412 {object} scim.Error
+// @Failure 428 {object} scim.Error
+// @Router /scim/v2/Users/{id} [patch]
+func (h *SCIMHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.user(w, r)
+	if !ok || !h.ifMatch(w, r, *existing) {
+		return
+	}
+	var patch scim.PatchOp
+	if err := decodeJSON(r, &patch); err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error()))
+		return
+	}
+	resource := toSCIMUser(*existing)
+	if err := patch.Apply(&resource); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	h.replace(w, r, *existing, resource)
+}
+
+// @Summary Delete a SCIM user
+// @Description Deprovision a user, keeping it restorable when undelete is enabled. Deletes from SCIM do not wait for approval.
+// @Tags scim
+// @Param id path string true "User ID"
+// @Param If-Match header string false "meta.version of the user being deleted; refused with 412 if the user has changed since, and required when preconditions are"
+// @Success 204 "No Content"
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 412 {object} scim.Error
+// @Failure 428 {object} scim.Error
+// @Router /scim/v2/Users/{id} [delete]
+func (h *SCIMHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.user(w, r)
+	if !ok || !h.ifMatch(w, r, *user) {
+		return
+	}
+	if err := h.users.deleteUser(r.Context(), user.ID, user.Version); err != nil {
+		writeSCIMWriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replace makes existing match resource, deleting it when resource is
+// inactive. It writes only while the user is still as read, so changes made
+// in between are not overwritten.
+func (h *SCIMHandler) replace(w http.ResponseWriter, r *http.Request, existing store.User, resource scim.User) {
+	if !resource.IsActive() {
+		if err := h.users.deleteUser(r.Context(), existing.ID, existing.Version); err != nil {
+			writeSCIMWriteError(w, err)
+			return
+		}
+		deactivated := toSCIMUser(existing)
+		active := false
+		deactivated.Active = &active
+		writeSCIM(w, http.StatusOK, deactivated)
+		return
+	}
+
+	user, err := h.fromSCIMUser(existing.ID, resource)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	updated, err := h.users.updateUser(r.Context(), existing.ID, existing.Version, user)
+	if err != nil {
+		writeSCIMWriteError(w, err)
+		return
+	}
+	w.Header().Set("ETag", versionETag(*updated))
+	writeSCIM(w, http.StatusOK, toSCIMUser(*updated))
+}
+
+// ifMatch checks the request's If-Match against existing, otherwise writing
+// 428 or 412
+func (h *SCIMHandler) ifMatch(w http.ResponseWriter, r *http.Request, existing store.User) bool {
+	version, err := expectedVersion(r, h.users.preconditions)
+	if err == nil && version != anyVersion && version != existing.Version {
+		err = errPreconditionFailed
+	}
+	switch {
+	case errors.Is(err, errPreconditionRequired):
+		writeSCIMError(w, scim.NewError(http.StatusPreconditionRequired, "", err.Error()))
+	case err != nil:
+		writeSCIMError(w, scim.NewError(http.StatusPreconditionFailed, "", err.Error()))
+	default:
+		return true
+	}
+	return false
+}
+
+// writeSCIMWriteError writes the SCIM error for a failed update or delete
+func writeSCIMWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrDuplicateEmail):
+		writeSCIMError(w, errEmailTaken())
+	case errors.Is(err, store.ErrVersionConflict):
+		writeSCIMError(w, scim.NewError(http.StatusPreconditionFailed, "", errPreconditionFailed.Error()))
+	default:
+		writeSCIMError(w, scim.NewError(http.StatusNotFound, "", "User not found"))
+	}
+}
+
+// user returns the user named in r, otherwise writing 404
+func (h *SCIMHandler) user(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	// SCIM IDs are opaque to clients, so any that is not a user's is not
+	// found rather than invalid
+	id, err := store.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusNotFound, "", "User not found"))
+		return nil, false
+	}
+	user, err := timedStore(r.Context(), h.users.userStore).GetByID(id)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusNotFound, "", "User not found"))
+		return nil, false
+	}
+	return user, true
+}
+
+// fromSCIMUser converts resource to a store user with id, rejecting
+// resources without an email or with one another user has
+func (h *SCIMHandler) fromSCIMUser(id int64, resource scim.User) (store.User, error) {
+	user := store.User{Name: resource.FullName(), Email: resource.PrimaryEmail()}
+	if user.Email == "" {
+		return store.User{}, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "userName or emails must hold an email address")
+	}
+	if taken, err := h.users.userStore.GetByEmail(user.Email); err == nil && taken.ID != id {
+		return store.User{}, errEmailTaken()
+	}
+	return user, nil
+}
+
+// errEmailTaken is the error for users whose email another user has,
+// found before writing them or, by stores enforcing unique emails, while
+// writing them
+func errEmailTaken() error {
+	return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "Email is already taken by another user")
+}
+
+// toSCIMUser converts user to a SCIM resource
+func toSCIMUser(user store.User) scim.User {
+	id := strconv.FormatInt(user.ID, 10)
+	active := true
+	return scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          id,
+		UserName:    user.Email,
+		Name:        &scim.Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     scimUsersPath + "/" + id,
+			Version:      versionETag(user),
+		},
+	}
+}
+
+// writeSCIM writes v as a SCIM response
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	body, err := encodeJSON(v, 0)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// writeSCIMError writes err as a SCIM error, as a 500 unless it is a
+// *scim.Error
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		scimErr = scim.NewError(http.StatusInternalServerError, "", err.Error())
+	}
+	writeSCIM(w, scimErr.StatusCode(), scimErr)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/materialized"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/stats"
+)
+
+// statsQuery holds the query parameters of GetUserStats
+type statsQuery struct {
+	Private bool `query:"private"`
+}
+
+type StatsHandler struct {
+	publisher *stats.Publisher
+	// materialized keeps the reports, when enabled
+	materialized *materialized.Store
+}
+
+func NewStatsHandler(publisher *stats.Publisher) *StatsHandler {
+	return &StatsHandler{
+		publisher: publisher,
+	}
+}
+
+// EnableMaterialized serves reports from results kept in results, rather
+// than aggregating every user on each request
+func (h *StatsHandler) EnableMaterialized(results *materialized.Store) {
+	h.materialized = results
+}
+
+// Routes returns the endpoints served by the handler
+func (h *StatsHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/stats/users", Handler: http.HandlerFunc(h.GetUserStats)},
+	}
+}
+
+// @Summary Get user statistics
+// @Description Get aggregate counts of users: the total, signups per month and users per email domain. Unless the caller is an admin, counts are protected by differential privacy when it is configured: buckets with too few users are suppressed and noise is added to every count. The noised counts stay the same until users change.
+// @Tags stats
+// @Accept json
+// @Produce json
+// @Param private query bool false "Get the counts other callers get, for admins"
+// @Success 200 {object} stats.Report
+// @Header 200 {string} X-Data-As-Of "When the counts were last known to be current, when reports are materialized"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/stats/users [get]
+func (h *StatsHandler) GetUserStats(w http.ResponseWriter, r *http.Request) {
+	var query statsQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+
+	key, get := "stats:private", h.publisher.Private
+	if principal, ok := reqctx.PrincipalFrom(r.Context()); ok && principal.HasRole(auth.RoleAdmin) && !query.Private {
+		key, get = "stats:exact", h.publisher.Exact
+	}
+	if h.materialized == nil {
+		report, err := get()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	result, err := h.materialized.Get(r.Context(), key, func(context.Context) (any, error) {
+		return get()
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setAsOf(w, result.AsOf)
+	writeJSON(w, http.StatusOK, result.Value)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/tenants"
+)
+
+type TenantHandler struct {
+	tenants *tenants.Store
+}
+
+func NewTenantHandler(tenantStore *tenants.Store) *TenantHandler {
+	return &TenantHandler{
+		tenants: tenantStore,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *TenantHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/tenants", Handler: http.HandlerFunc(h.GetTenants)},
+		{Method: http.MethodGet, Path: "/admin/tenants/{id}", Handler: http.HandlerFunc(h.GetTenant)},
+		{Method: http.MethodPut, Path: "/admin/tenants/{id}", Handler: http.HandlerFunc(h.SetTenant)},
+		{Method: http.MethodDelete, Path: "/admin/tenants/{id}", Handler: http.HandlerFunc(h.DeleteTenant)},
+	}
+}
+
+// @Summary List tenant overrides
+// @Description List the tenants that override the server's settings, by ID, without webhook secrets
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {array} tenants.Tenant
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/tenants [get]
+func (h *TenantHandler) GetTenants(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.tenants.List())
+}
+
+// @Summary Get a tenant's overrides
+// @Description Get the settings a tenant overrides, without webhook secrets
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} tenants.Settings
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/tenants/{id} [get]
+func (h *TenantHandler) GetTenant(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	settings, err := h.tenants.Get(r.PathValue("id"))
+	if err != nil {
+		writeTenantError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// @Summary Set a tenant's overrides
+// @Description Replace the settings a tenant overrides, applying to requests naming the tenant in X-Tenant-ID from then on: rate_profile is the rate limit profile they count against, features turns feature flags on or off, email_domains restricts the email addresses users can be given and webhooks receive the user events of the tenant's requests
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param settings body tenants.Settings true "Overrides"
+// @Success 200 {object} tenants.Settings
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/tenants/{id} [put]
+func (h *TenantHandler) SetTenant(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var settings tenants.Settings
+	if err := decodeJSON(r, &settings); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	settings, err := h.tenants.Put(r.PathValue("id"), settings)
+	if err != nil {
+		writeTenantError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// @Summary Delete a tenant's overrides
+// @Description Drop a tenant's overrides, so its requests get the server's settings
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/tenants/{id} [delete]
+func (h *TenantHandler) DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.tenants.Delete(r.PathValue("id")); err != nil {
+		writeTenantError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTenantError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, tenants.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// CreateUploadRequest declares a file about to be uploaded
+type CreateUploadRequest struct {
+	Purpose     string `json:"purpose" example:"avatar"`
+	ContentType string `json:"content_type" example:"image/png"`
+	// Size is the file's size in bytes
+	Size int64 `json:"size" example:"1048576"`
+}
+
+// CreateUploadResponse is a new upload and where to send its file
+type CreateUploadResponse struct {
+	Upload uploads.Upload `json:"upload"`
+	Target uploads.Target `json:"target"`
+}
+
+type UploadHandler struct {
+	uploads *uploads.Manager
+	// local receives files for local storage, if used
+	local *uploads.LocalStorage
+}
+
+// NewUploadHandler creates a handler for uploads. local is the storage to
+// receive files for when uploads are kept locally, otherwise nil.
+func NewUploadHandler(manager *uploads.Manager, local *uploads.LocalStorage) *UploadHandler {
+	return &UploadHandler{
+		uploads: manager,
+		local:   local,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *UploadHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/uploads", Handler: http.HandlerFunc(h.GetUploads)},
+		{Method: http.MethodPost, Path: "/api/v1/uploads", Handler: http.HandlerFunc(h.CreateUpload)},
+		{Method: http.MethodGet, Path: "/api/v1/uploads/{id}", Handler: http.HandlerFunc(h.GetUpload)},
+		{Method: http.MethodPost, Path: "/api/v1/uploads/{id}/complete", Handler: http.HandlerFunc(h.CompleteUpload)},
+	}
+}
+
+// ContentRoutes returns the endpoint receiving files for local storage,
+// if used. It is authorized by its one-time token rather than the caller,
+// and kept apart from the other routes so bodies are streamed to disk
+// without passing through API middleware.
+func (h *UploadHandler) ContentRoutes() []router.Route {
+	if h.local == nil {
+		return nil
+	}
+	return []router.Route{
+		{Method: http.MethodPut, Path: uploads.LocalContentPath + "{token}", Handler: http.HandlerFunc(h.PutContent)},
+	}
+}
+
+// @Summary List uploads
+// @Description List the caller's uploads, newest first
+// @Tags uploads
+// @Accept json
+// @Produce json
+// @Success 200 {array} uploads.Upload
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/uploads [get]
+func (h *UploadHandler) GetUploads(w http.ResponseWriter, r *http.Request) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.uploads.List(principal.Subject))
+}
+
+// @Summary Create an upload
+// @Description Declare a file to upload, such as an avatar or import, getting back where to send it: a presigned S3 URL, or a one-time URL on this API when files are kept locally. Send the file with the method and headers given before the upload expires, then complete it.
+// @Tags uploads
+// @Accept json
+// @Produce json
+// @Param upload body CreateUploadRequest true "File to upload"
+// @Success 201 {object} CreateUploadResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Router /api/v1/uploads [post]
+func (h *UploadHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	var req CreateUploadRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Size <= 0 {
+		writeError(w, r, http.StatusBadRequest, "size must be positive")
+		return
+	}
+
+	upload, target, err := h.uploads.Create(r.Context(), principal.Subject, req.Purpose, req.ContentType, req.Size)
+	switch {
+	case errors.Is(err, uploads.ErrUnknownPurpose):
+		writeError(w, r, http.StatusBadRequest, "Unknown purpose "+req.Purpose)
+	case errors.Is(err, uploads.ErrTooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusCreated, CreateUploadResponse{Upload: upload, Target: target})
+	}
+}
+
+// @Summary Get an upload
+// @Description Get one of the caller's uploads
+// @Tags uploads
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} uploads.Upload
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/uploads/{id} [get]
+func (h *UploadHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.visible(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, upload)
+}
+
+// @Summary Complete an upload
+// @Description Confirm an upload's file has been sent. The file is checked to be in storage at the declared size, and scanned, before the upload is marked completed and can be used. Infected files are rejected or quarantined.
+// @Tags uploads
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} uploads.Upload
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/uploads/{id}/complete [post]
+func (h *UploadHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.visible(w, r); !ok {
+		return
+	}
+	upload, err := h.uploads.Complete(r.Context(), r.PathValue("id"))
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Upload not found")
+	case errors.Is(err, uploads.ErrExpired):
+		writeError(w, r, http.StatusGone, err.Error())
+	case errors.Is(err, uploads.ErrNotUploaded), errors.Is(err, uploads.ErrSizeMismatch):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, uploads.ErrInfected):
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusBadGateway, "Failed to check upload: "+err.Error())
+	default:
+		writeJSON(w, http.StatusOK, upload)
+	}
+}
+
+// @Summary Upload a file
+// @Description Send the file of an upload kept locally, to the URL returned when it was created. Each URL can be used once.
+// @Tags uploads
+// @Accept octet-stream
+// @Produce json
+// @Param token path string true "Upload token"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Router /api/v1/uploads/content/{token} [put]
+func (h *UploadHandler) PutContent(w http.ResponseWriter, r *http.Request) {
+	err := h.local.Receive(r.PathValue("token"), r.Header.Get("Content-Type"), r.Body)
+	switch {
+	case errors.Is(err, uploads.ErrInvalidToken):
+		writeError(w, r, http.StatusForbidden, err.Error())
+	case errors.Is(err, uploads.ErrTooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// visible returns the upload named in r if its caller may see it,
+// otherwise writing 404. Others' uploads are reported as not found, so
+// their IDs cannot be probed.
+func (h *UploadHandler) visible(w http.ResponseWriter, r *http.Request) (uploads.Upload, bool) {
+	upload, err := h.uploads.Get(r.PathValue("id"))
+	if err != nil || auth.Authorize(r.Context(), upload.Owner) != nil {
+		writeError(w, r, http.StatusNotFound, "Upload not found")
+		return uploads.Upload{}, false
+	}
+	return upload, true
+}
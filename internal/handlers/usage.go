@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/usage"
+)
+
+// usageQuery selects the usage reported
+type usageQuery struct {
+	Since     time.Time `query:"since"`
+	Until     time.Time `query:"until"`
+	Principal string    `query:"principal"`
+}
+
+type UsageHandler struct {
+	meter *usage.Meter
+}
+
+func NewUsageHandler(meter *usage.Meter) *UsageHandler {
+	return &UsageHandler{
+		meter: meter,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *UsageHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/usage", Handler: http.HandlerFunc(h.GetUsage)},
+	}
+}
+
+// @Summary Get my usage
+// @Description Get the caller's use of the API over a period, in total and by operation: the requests made and the bytes of their request and response bodies. The period defaults to everything retained, up to the end of the current window. Admins can get another principal's usage.
+// @Tags usage
+// @Accept json
+// @Produce json
+// @Param since query string false "Only windows starting at or after this RFC 3339 time or date, e.g. 2024-01-01"
+// @Param until query string false "Only windows starting before this RFC 3339 time or date"
+// @Param principal query string false "Another principal's usage (admins only)"
+// @Success 200 {object} usage.Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/v1/usage [get]
+func (h *UsageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	query := usageQuery{Principal: principal.Subject}
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if !requireOwner(w, r, query.Principal, "Only admins can get another principal's usage") {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.meter.Report(query.Principal, query.Since, query.Until))
+}
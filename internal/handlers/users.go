@@ -1,41 +1,559 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/dazraf/go-api-example/internal/anonymize"
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/approval"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/avatars"
+	"github.com/dazraf/go-api-example/internal/codec"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/materialized"
+	"github.com/dazraf/go-api-example/internal/merge"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/plugins"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/scripts"
 	"github.com/dazraf/go-api-example/internal/store"
-	"github.com/gin-gonic/gin"
+	"github.com/dazraf/go-api-example/internal/surrogate"
+	"github.com/dazraf/go-api-example/internal/tenants"
+	"github.com/dazraf/go-api-example/internal/uploads"
+	"github.com/dazraf/go-api-example/internal/validation"
+	"github.com/dazraf/go-api-example/internal/views"
+	"github.com/dazraf/go-api-example/internal/visibility"
+	"github.com/dazraf/go-api-example/pkg/httpx"
+	"github.com/dazraf/go-api-example/pkg/plugin"
 )
 
+// UserState is a listed user, marked when it is deleted but can still be
+// restored
+type UserState struct {
+	store.User
+	PendingDeletion bool       `json:"pending_deletion,omitempty" example:"false"`
+	PurgeAt         *time.Time `json:"purge_at,omitempty" example:"2024-01-03T15:04:05Z"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error" example:"User not found"`
+	// Code says what went wrong, for clients to branch on; the codes are
+	// listed at /docs/errors.json
+	Code    apierrors.Code `json:"code" example:"USER_NOT_FOUND"`
+	TraceID string         `json:"trace_id,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736"`
+	// Errors lists the fields of an invalid request body and what is
+	// wrong with each
+	Errors []schemas.Problem `json:"errors,omitempty"`
+}
+
+// EnrichedUser is a read user with the attributes plugins and scripts
+// added to it
+type EnrichedUser struct {
+	store.User
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// UserListener is told about successful user writes, e.g. to send emails.
+// It is called synchronously, so slow work must be queued.
+type UserListener interface {
+	UserCreated(ctx context.Context, user store.User)
+	UserUpdated(ctx context.Context, before, after store.User)
 }
 
 type UserHandler struct {
 	userStore store.UserStore
+	listeners []UserListener
+	// approvals holds deletes for a second admin's approval, when required
+	approvals *approval.Workflow
+	// recycleBin keeps deleted users restorable for a while, when enabled
+	recycleBin *store.RecycleBin
+	// views holds saved list queries, when enabled
+	views *views.Store
+	// materialized keeps the results of materialized views, when enabled
+	materialized *materialized.Store
+	// fields hides user fields from callers without the roles to see them
+	fields *visibility.Policy
+	// operations runs writes requested with ?async=true, when enabled
+	operations *operations.Manager
+	// avatars stores users' avatars made from uploads, when enabled
+	avatars      *avatars.Processor
+	uploads      *uploads.Manager
+	avatarMaxAge time.Duration
+	// ownership limits regular users to changing themselves
+	ownership bool
+	// preconditions refuses writes to users without If-Match
+	preconditions bool
+	// purger purges cached responses about users as they change, when
+	// surrogate keys are enabled
+	purger surrogate.Purger
+	// plugins validate users before they are created and enrich them after
+	// they are read, when enabled
+	plugins *plugins.Runner
+	// scripts do the same as plugins, in process, after them
+	scripts *scripts.Engine
+	// anonymizer fakes users in exports with ?anonymized=true
+	anonymizer *anonymize.Anonymizer
+
+	// listCache holds the encoded full user list for stores that track
+	// revisions, so unfiltered list requests skip GetAll and marshalling
+	listCache atomic.Pointer[cachedList]
+}
+
+// timedStore returns userStore, timing its calls as spans of the request
+// in ctx when the request records timings, e.g. to break down slow requests
+func timedStore(ctx context.Context, userStore store.UserStore) store.UserStore {
+	timings, ok := reqctx.TimingsFrom(ctx)
+	if !ok {
+		return userStore
+	}
+	return store.NewTimedUserStore(userStore, func(op string) func() { return timings.Start("store." + op) })
 }
 
-func NewUserHandler(userStore store.UserStore) *UserHandler {
+// cachedList is an encoded user list valid for a single store revision
+type cachedList struct {
+	revision uint64
+	body     []byte
+	etag     string
+}
+
+func NewUserHandler(userStore store.UserStore, listeners ...UserListener) *UserHandler {
 	return &UserHandler{
-		userStore: userStore,
+		userStore:  userStore,
+		listeners:  listeners,
+		anonymizer: anonymize.New(""),
+	}
+}
+
+// SetAnonymizer sets how users are faked in exports with ?anonymized=true
+func (h *UserHandler) SetAnonymizer(anonymizer *anonymize.Anonymizer) {
+	h.anonymizer = anonymizer
+}
+
+// RequireDeleteApproval makes deletes wait for approval through approvals
+// rather than happening immediately
+func (h *UserHandler) RequireDeleteApproval(approvals *approval.Workflow) {
+	h.approvals = approvals
+	approvals.Register(approval.DeleteUser, func(ctx context.Context, target string) error {
+		id, err := store.ParseID(target)
+		if err != nil {
+			return err
+		}
+		return h.deleteUser(ctx, id, anyVersion)
+	})
+}
+
+// EnableUndelete keeps deleted users in bin, so they can be restored until
+// their undo window passes
+func (h *UserHandler) EnableUndelete(bin *store.RecycleBin) {
+	h.recycleBin = bin
+}
+
+// EnableViews lets list requests name a query saved in viewStore
+func (h *UserHandler) EnableViews(viewStore *views.Store) {
+	h.views = viewStore
+}
+
+// EnableMaterializedViews serves views saved as materialized from results
+// kept in results, which writes through the handler invalidate
+func (h *UserHandler) EnableMaterializedViews(results *materialized.Store) {
+	h.materialized = results
+}
+
+// EnableAsync lets clients ask for writes to run in the background as
+// operations of manager, polling GET /api/v1/operations/{id} for the outcome
+func (h *UserHandler) EnableAsync(manager *operations.Manager) {
+	h.operations = manager
+}
+
+// EnableAvatars lets users set avatars from images uploaded to manager,
+// processed by processor. Avatars are served to be cached for maxAge.
+func (h *UserHandler) EnableAvatars(processor *avatars.Processor, manager *uploads.Manager, maxAge time.Duration) {
+	h.avatars = processor
+	h.uploads = manager
+	h.avatarMaxAge = maxAge
+}
+
+// RequireOwnership lets only admins change or delete other users; regular
+// users manage themselves, also through /api/v1/me
+func (h *UserHandler) RequireOwnership() {
+	h.ownership = true
+}
+
+// RequirePreconditions refuses to change or delete users without an
+// If-Match header naming the version changed, with 428 Precondition
+// Required, so clients cannot overwrite changes they have not seen
+func (h *UserHandler) RequirePreconditions() {
+	h.preconditions = true
+}
+
+// EnableSurrogateKeys tags user responses with Surrogate-Key headers for
+// caching proxies, and purges the keys of each user written through purger
+func (h *UserHandler) EnableSurrogateKeys(purger surrogate.Purger) {
+	h.purger = purger
+}
+
+// RestrictFields hides the user fields policy restricts from callers who
+// may not see them, in every response that returns users
+func (h *UserHandler) RestrictFields(policy *visibility.Policy) {
+	h.fields = policy
+}
+
+// EnablePlugins runs runner's plugins on users created and read
+func (h *UserHandler) EnablePlugins(runner *plugins.Runner) {
+	h.plugins = runner
+}
+
+// EnableScripts runs engine's scripts on users created and read, after any
+// plugins
+func (h *UserHandler) EnableScripts(engine *scripts.Engine) {
+	h.scripts = engine
+}
+
+// Routes returns the endpoints served by the handler
+func (h *UserHandler) Routes() []router.Route {
+	routes := []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/users", Handler: http.HandlerFunc(h.GetUsers)},
+		{Method: http.MethodGet, Path: "/api/v1/users/export", Handler: http.HandlerFunc(h.ExportUsers)},
+		{Method: http.MethodGet, Path: "/api/v1/users/{id}", Handler: http.HandlerFunc(h.GetUser)},
+		{Method: http.MethodPost, Path: "/api/v1/users", Handler: http.HandlerFunc(h.CreateUser)},
+		{Method: http.MethodPost, Path: "/api/v1/users/validate", Handler: http.HandlerFunc(h.ValidateUser)},
+		{Method: http.MethodPost, Path: "/api/v1/users/batch", Handler: http.HandlerFunc(h.CreateUsers)},
+		{Method: http.MethodPut, Path: "/api/v1/users/{id}", Handler: http.HandlerFunc(h.UpdateUser)},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{id}", Handler: http.HandlerFunc(h.DeleteUser)},
+	}
+	if h.ownership {
+		routes = append(routes,
+			router.Route{Method: http.MethodGet, Path: "/api/v1/me", Handler: http.HandlerFunc(h.GetMe)},
+			router.Route{Method: http.MethodPut, Path: "/api/v1/me", Handler: http.HandlerFunc(h.UpdateMe)},
+			router.Route{Method: http.MethodDelete, Path: "/api/v1/me", Handler: http.HandlerFunc(h.DeleteMe)},
+		)
+	}
+	if h.recycleBin != nil {
+		routes = append(routes, router.Route{Method: http.MethodPost, Path: "/api/v1/users/{id}/undelete", Handler: http.HandlerFunc(h.UndeleteUser)})
+	}
+	if h.avatars != nil {
+		routes = append(routes,
+			router.Route{Method: http.MethodGet, Path: "/api/v1/users/{id}/avatar", Handler: http.HandlerFunc(h.GetAvatar)},
+			router.Route{Method: http.MethodPut, Path: "/api/v1/users/{id}/avatar", Handler: http.HandlerFunc(h.SetAvatar)},
+			router.Route{Method: http.MethodDelete, Path: "/api/v1/users/{id}/avatar", Handler: http.HandlerFunc(h.DeleteAvatar)},
+		)
+	}
+	return routes
+}
+
+// usersQuery holds the query parameters of GetUsers
+type usersQuery struct {
+	InactiveSince  *time.Duration `query:"inactive_since"`
+	IncludeDeleted bool           `query:"include_deleted"`
+	CreatedAfter   time.Time      `query:"created_after"`
+	CreatedBefore  time.Time      `query:"created_before"`
+	UpdatedWithin  *time.Duration `query:"updated_within"`
+	Name           string         `query:"name"`
+	Email          string         `query:"email"`
+	Q              string         `query:"q"`
+	Filter         string         `query:"filter"`
+	Sort           []string       `query:"sort"`
+	Collation      string         `query:"collation"`
+}
+
+// checkUsersQuery reports whether values are valid list parameters, for
+// queries saved as views
+func checkUsersQuery(values url.Values) error {
+	known := httpx.Params(usersQuery{})
+	for key := range values {
+		if !slices.Contains(known, key) {
+			return fmt.Errorf("unknown parameter %q, expected one of %s", key, strings.Join(known, ", "))
+		}
+	}
+	var query usersQuery
+	if err := httpx.BindValues(values, &query); err != nil {
+		return err
+	}
+	if _, err := store.ParseSort(query.Sort); err != nil {
+		return err
 	}
+	if _, err := store.ParseCondition(query.Filter); err != nil {
+		return err
+	}
+	_, err := store.ParseCollation(query.Collation)
+	return err
+}
+
+// filter returns the store filter for the query's time and text parameters.
+// Sorts and filter expressions are parsed separately, as they can be
+// invalid.
+func (q usersQuery) filter(now time.Time) store.UserFilter {
+	filter := store.UserFilter{CreatedAfter: q.CreatedAfter, CreatedBefore: q.CreatedBefore, Name: q.Name, Email: q.Email, Query: q.Q}
+	if q.UpdatedWithin != nil {
+		filter.UpdatedAfter = now.Add(-*q.UpdatedWithin)
+	}
+	return filter
 }
 
 // @Summary List users
-// @Description Get a list of all users
+// @Description Get a list of all users, optionally only those created or updated in a period, matching text, or not seen recently. Admins can include deleted users that can still be restored, which are marked pending_deletion.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Success 200 {array} store.User
+// @Param inactive_since query string false "Only users not seen within this period, e.g. 30d, 2w or 12h"
+// @Param include_deleted query bool false "Include users pending deletion (admins only)"
+// @Param created_after query string false "Only users created after this RFC 3339 time or date, e.g. 2024-01-31"
+// @Param created_before query string false "Only users created before this RFC 3339 time or date"
+// @Param updated_within query string false "Only users updated within this period, e.g. 24h or 7d"
+// @Param name query string false "Only users whose name contains this, ignoring case"
+// @Param email query string false "Only users whose email contains this, ignoring case"
+// @Param q query string false "Only users whose name or email contains this, ignoring case"
+// @Param filter query string false "Only users passing this RSQL filter, e.g. name=like=Jo*;created_at=ge=2024-01-01. Comparisons of id, name, email, created_at, updated_at and last_seen_at by ==, !=, =like= (with * wildcards), =lt=, =le=, =gt=, =ge=, =in= and =out= are joined by ; for and and , for or, and grouped in parentheses. Names and emails compare ignoring case and are not ordered. Send ; escaped as %3B."
+// @Param sort query string false "Comma separated fields to sort by, each descending when prefixed with -, e.g. name,-created_at. Fields are id, name, email, created_at, updated_at and last_seen_at."
+// @Param collation query string false "Language whose rules order sorted names and emails, e.g. de or sv. Defaults to the best match of Accept-Language, or byte order."
+// @Param Accept-Language header string false "Languages to collate sorted names and emails by, when collation is not given"
+// @Param view query string false "Apply the parameters of a view saved with POST /api/v1/views; parameters given alongside it take precedence"
+// @Param If-None-Match header string false "ETag of the users already held, answered with 304 Not Modified while it is current"
+// @Success 200 {array} UserState
+// @Header 200 {string} ETag "Version of the users listed"
+// @Header 200 {string} X-Data-As-Of "When the results of a materialized view were last known to be current"
+// @Success 304 "Not Modified"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Router /api/v1/users [get]
-func (h *UserHandler) GetUsers(c *gin.Context) {
-	users, err := h.userStore.GetAll()
+func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	h.tag(w, surrogate.UsersCollection)
+	values, view, ok := h.withView(w, r)
+	if !ok {
+		return
+	}
+	var query usersQuery
+	if !bindValues(w, r, values, &query) {
+		return
+	}
+	filter := query.filter(time.Now())
+	sort, err := store.ParseSort(query.Sort)
+	if err != nil {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, err.Error())
+		return
+	}
+	filter.Sort = sort
+	if filter.Where, err = store.ParseCondition(query.Filter); err != nil {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, err.Error())
+		return
+	}
+	if filter.Collation, ok = h.collation(w, r, query); !ok {
+		return
+	}
+	if query.InactiveSince != nil {
+		h.getInactiveUsers(w, r, *query.InactiveSince, filter)
+		return
+	}
+	if query.IncludeDeleted && h.recycleBin != nil {
+		h.getUsersIncludingDeleted(w, r, filter)
+		return
+	}
+	if view.Materialized && h.materialized != nil {
+		h.getMaterializedUsers(w, r, values, filter.Collation)
+		return
+	}
+	// The cache holds the full list in UTC, so callers with hidden fields or
+	// another time zone skip it
+	revisioner, cacheable := h.userStore.(store.Revisioner)
+	_, zoned := reqctx.Timezone(r.Context())
+	if !filter.IsZero() || !cacheable || h.fields.Restricts(r.Context()) || zoned || h.enriches() {
+		users, err := store.FindUsers(timedStore(r.Context(), h.userStore), filter)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h.writeReadUsers(w, r, users)
+		return
+	}
+
+	// Read the revision before the data: a write in between leaves an
+	// entry that is never served, rather than stale data under a new revision
+	revision := revisioner.Revision()
+	if cached := h.listCache.Load(); cached != nil && cached.revision == revision {
+		if !notModified(w, r, cached.etag) {
+			writeData(w, http.StatusOK, cached.body)
+		}
+		return
+	}
+
+	users, err := timedStore(r.Context(), h.userStore).GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		writeError(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, users)
+
+	body, err := encodeJSON(users, len(users)*estimatedUserJSONSize)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	etag := usersETag(r, h.fields, users)
+	h.listCache.Store(&cachedList{revision: revision, body: body, etag: etag})
+	if !notModified(w, r, etag) {
+		writeData(w, http.StatusOK, body)
+	}
+}
+
+// withView returns the request's query parameters, filling in those of the
+// saved view named by the view parameter under the ones given explicitly,
+// and the view, which is zero when none is named
+func (h *UserHandler) withView(w http.ResponseWriter, r *http.Request) (url.Values, views.View, bool) {
+	values := r.URL.Query()
+	name := values.Get("view")
+	if name == "" || h.views == nil {
+		return values, views.View{}, true
+	}
+
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required to use views")
+		return nil, views.View{}, false
+	}
+	view, err := h.views.Get(principal.Subject, name)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "View not found")
+		return nil, views.View{}, false
+	}
+
+	values.Del("view")
+	for key, saved := range view.Values() {
+		if !values.Has(key) {
+			values[key] = saved
+		}
+	}
+	return values, view, true
+}
+
+// getMaterializedUsers writes the users of a materialized view from the
+// results kept for its parameters, which are shared by every caller asking
+// for the same ones. Relative periods such as updated_within are resolved
+// when the results are refreshed, so they move with X-Data-As-Of.
+func (h *UserHandler) getMaterializedUsers(w http.ResponseWriter, r *http.Request, values url.Values, collation language.Tag) {
+	key := "users?" + values.Encode() + "#" + collation.String()
+	result, err := h.materialized.Get(r.Context(), key, func(ctx context.Context) (any, error) {
+		var query usersQuery
+		if err := httpx.BindValues(values, &query); err != nil {
+			return nil, err
+		}
+		filter := query.filter(time.Now())
+		// Already checked by GetUsers
+		filter.Sort, _ = store.ParseSort(query.Sort)
+		filter.Where, _ = store.ParseCondition(query.Filter)
+		filter.Collation = collation
+		return store.FindUsers(timedStore(ctx, h.userStore), filter)
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setAsOf(w, result.AsOf)
+	h.writeReadUsers(w, r, result.Value.([]store.User))
+}
+
+// collation returns the collation of a sorted query: the language given,
+// or the one most preferred by Accept-Language. Byte order is used when there
+// is neither, or the list is not sorted.
+func (h *UserHandler) collation(w http.ResponseWriter, r *http.Request, query usersQuery) (language.Tag, bool) {
+	collation, err := store.ParseCollation(query.Collation)
+	if err != nil {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, err.Error())
+		return language.Und, false
+	}
+	if query.Collation != "" || len(query.Sort) == 0 {
+		return collation, true
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	// Languages are in order of preference, so the first is the best match
+	preferred, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(preferred) == 0 {
+		return language.Und, true
+	}
+	return preferred[0], true
+}
+
+// getInactiveUsers lists users passing filter who have not been seen within
+// the inactive_since period, including those never seen
+func (h *UserHandler) getInactiveUsers(w http.ResponseWriter, r *http.Request, age time.Duration, filter store.UserFilter) {
+	users, err := store.FindUsers(timedStore(r.Context(), h.userStore), filter)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cutoff := time.Now().Add(-age)
+	inactive := make([]store.User, 0, len(users))
+	for _, user := range users {
+		if user.LastSeenAt == nil || user.LastSeenAt.Before(cutoff) {
+			inactive = append(inactive, user)
+		}
+	}
+	h.writeReadUsers(w, r, inactive)
+}
+
+// getUsersIncludingDeleted lists users passing filter along with those
+// pending deletion, for admins only
+func (h *UserHandler) getUsersIncludingDeleted(w http.ResponseWriter, r *http.Request, filter store.UserFilter) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	if !principal.HasRole(auth.RoleAdmin) {
+		writeCodedError(w, r, http.StatusForbidden, apierrors.AdminRequired, "Only admins can list deleted users")
+		return
+	}
+
+	users, err := store.FindUsers(timedStore(r.Context(), h.userStore), filter)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	pending := h.recycleBin.Pending()
+	states := make([]UserState, 0, len(users)+len(pending))
+	for _, user := range users {
+		states = append(states, UserState{User: user})
+	}
+	for _, deleted := range pending {
+		if !filter.Matches(deleted.User) {
+			continue
+		}
+		states = append(states, UserState{User: deleted.User, PendingDeletion: true, PurgeAt: &deleted.PurgeAt})
+	}
+	if len(filter.Sort) > 0 {
+		compare := filter.Sort.Collate(filter.Collation)
+		slices.SortFunc(states, func(a, b UserState) int { return compare(a.User, b.User) })
+	}
+	etag := hashETag(func(w io.Writer) {
+		for _, state := range states {
+			_, _ = fmt.Fprintf(w, "%d:%d:%t:", state.ID, state.Version, state.PendingDeletion)
+			writeVariant(w, r, h.fields, state.User)
+			_, _ = io.WriteString(w, ";")
+		}
+	})
+	if notModified(w, r, etag) {
+		return
+	}
+	writeUsers(w, r, h.fields, states, func(state UserState) int64 { return state.ID })
 }
 
 // @Summary Get a user
@@ -44,106 +562,761 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
+// @Param If-None-Match header string false "ETag of the user already held, answered with 304 Not Modified while it is current"
 // @Success 200 {object} store.User
+// @Header 200 {string} ETag "The user's version"
+// @Success 304 "Not Modified"
+// @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Router /api/v1/users/{id} [get]
-func (h *UserHandler) GetUser(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
 		return
 	}
+	// Users created later purge the key, so even a 404 can be cached
+	h.tag(w, surrogate.User(id))
 
-	user, err := h.userStore.GetByID(id)
+	user, err := timedStore(r.Context(), h.userStore).GetByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	h.writeReadUser(w, r, *user)
 }
 
 // @Summary Create a user
-// @Description Create a new user
+// @Description Create a new user from a JSON, URL-encoded or multipart form body. Clients that cannot send a body may give the user's fields as query parameters instead.
 // @Tags users
 // @Accept json
+// @Accept x-www-form-urlencoded
+// @Accept mpfd
 // @Produce json
-// @Param user body store.User true "User object"
+// @Param user body store.User false "User object"
+// @Param name query string false "Name of a user created without a body"
+// @Param email query string false "Email of a user created without a body"
+// @Param async query bool false "Create the user in the background, responding 202 with an operation to poll"
 // @Success 201 {object} store.User
+// @Header 201 {string} ETag "The user's version"
+// @Success 202 {object} operations.Operation
 // @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
 // @Router /api/v1/users [post]
-func (h *UserHandler) CreateUser(c *gin.Context) {
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var query asyncQuery
+	if !bindQuery(w, r, &query) {
+		return
+	}
+	if query.Async && h.operations == nil {
+		writeError(w, r, http.StatusBadRequest, "Async processing is not enabled")
+		return
+	}
+
 	var user store.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	if err := decodeResource(r, resourceSchemas["user"], &user); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	user = normalized(user)
+	// Activity is recorded by the server, never set by clients
+	user.LastSeenAt = nil
+	if !bound(w, r, user) || !allowedEmail(w, r, user.Email) || !h.validated(w, r, user) {
+		return
+	}
+
+	if query.Async {
+		submitOperation(w, r, h.operations, "user.create", func(ctx context.Context, _ operations.Progress) (any, error) {
+			createdUser, err := h.createUser(ctx, user)
+			if err != nil {
+				return nil, err
+			}
+			// Only the caller reads the result, so it is redacted for them
+			return visibility.Redact(createdUser, h.fields.Hidden(ctx, strconv.FormatInt(createdUser.ID, 10)))
+		})
+		return
+	}
+
+	createdUser, err := h.createUser(r.Context(), user)
+	switch {
+	case errors.Is(err, store.ErrDuplicateEmail):
+		writeDuplicateEmail(w, r)
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("ETag", userETag(r, h.fields, *createdUser))
+	writeUser(w, r, h.fields, http.StatusCreated, createdUser, createdUser.ID)
+}
+
+// createUser creates user and tells the listeners
+func (h *UserHandler) createUser(ctx context.Context, user store.User) (*store.User, error) {
+	createdUser, err := timedStore(ctx, h.userStore).Create(user)
+	if err != nil {
+		return nil, err
+	}
+	h.created(ctx, *createdUser)
+	return createdUser, nil
+}
+
+// normalized returns user with its name and email in Unicode NFC, so text
+// that looks alike is stored alike, and found by the same searches
+func normalized(user store.User) store.User {
+	user.Name = norm.NFC.String(user.Name)
+	user.Email = norm.NFC.String(user.Email)
+	return user
+}
+
+// created purges cached responses about a created user and tells the
+// listeners
+func (h *UserHandler) created(ctx context.Context, user store.User) {
+	h.purge(ctx, user.ID)
+	for _, listener := range h.listeners {
+		listener.UserCreated(ctx, user)
+	}
+}
+
+// maxBatchUsers is how many users a batch may create
+const maxBatchUsers = 1000
+
+// UserBatch is the outcome of creating users in a batch, with a result for
+// each user in the order given
+type UserBatch struct {
+	// Created is whether the users were created, which is all or none
+	Created bool              `json:"created"`
+	Results []UserBatchResult `json:"results"`
+}
+
+// UserBatchResult is the outcome of one user of a batch
+type UserBatchResult struct {
+	Index int `json:"index" example:"0"`
+	// ID is the ID of the created user
+	ID int64 `json:"id,omitempty" example:"1"`
+	// Errors lists why the user is invalid, by field where there is one
+	Errors []schemas.Problem `json:"errors,omitempty"`
+}
+
+// @Summary Create users in a batch
+// @Description Create up to 1000 users at once, all or nothing. Each user is checked as POST /api/v1/users/validate checks it; when any is invalid, none are created and a 400 lists the problems of each. Stores that cannot create users in batches, such as replicated ones, get a 501.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param users body []store.User true "Users to create"
+// @Success 201 {object} UserBatch
+// @Failure 400 {object} UserBatch
+// @Failure 409 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/users/batch [post]
+func (h *UserHandler) CreateUsers(w http.ResponseWriter, r *http.Request) {
+	var items []json.RawMessage
+	if err := decodeJSON(r, &items); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatchUsers {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("A batch must have between 1 and %d users", maxBatchUsers))
+		return
+	}
+
+	batch := UserBatch{Results: make([]UserBatchResult, len(items))}
+	users := make([]store.User, len(items))
+	valid := true
+	for i, item := range items {
+		user, problems, err := h.check(r.Context(), item)
+		if err != nil {
+			checkFailed(w, r, err)
+			return
+		}
+		// Activity is recorded by the server, never set by clients
+		user.LastSeenAt = nil
+		users[i] = user
+		batch.Results[i] = UserBatchResult{Index: i}
+		if len(problems) > 0 {
+			batch.Results[i].Errors = problems
+			valid = false
+		}
+	}
+	if !valid {
+		writeJSON(w, http.StatusBadRequest, batch)
+		return
+	}
+
+	created, err := store.CreateMany(timedStore(r.Context(), h.userStore), users)
+	switch {
+	case errors.Is(err, store.ErrBatchUnsupported):
+		writeError(w, r, http.StatusNotImplemented, "The user store cannot create users in batches")
+		return
+	case errors.Is(err, store.ErrDuplicateEmail):
+		writeCodedError(w, r, http.StatusConflict, apierrors.DuplicateEmail, "Emails must be unique within the batch and not in use by other users")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	batch.Created = true
+	for i, user := range created {
+		h.created(r.Context(), user)
+		batch.Results[i].ID = user.ID
+	}
+	writeJSON(w, http.StatusCreated, batch)
+}
+
+// UserValidation is the outcome of validating a user
+type UserValidation struct {
+	Valid bool `json:"valid" example:"false"`
+	// Errors lists why the user is invalid, by field where there is one
+	Errors []schemas.Problem `json:"errors"`
+}
+
+// @Summary Validate a user
+// @Description Check a user against its schema, served at /api/v1/schema/user, and everything creating it checks, without creating it, e.g. to validate forms as they are filled in. Invalid users get a 200 listing the problems.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param user body store.User true "User object"
+// @Success 200 {object} UserValidation
+// @Failure 400 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/users/validate [post]
+func (h *UserHandler) ValidateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		writeError(w, r, http.StatusBadRequest, "request body is empty")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	createdUser, err := h.userStore.Create(user)
+	if !json.Valid(data) {
+		writeError(w, r, http.StatusBadRequest, "request body is not JSON")
+		return
+	}
+	_, problems, err := h.check(r.Context(), data)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		checkFailed(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, UserValidation{Valid: len(problems) == 0, Errors: problems})
+}
+
+// check checks the JSON of a user, which must be valid JSON, against its
+// schema and everything creating it checks, returning the user and its
+// problems. Errors are failures to check, written by checkFailed.
+func (h *UserHandler) check(ctx context.Context, data []byte) (store.User, []schemas.Problem, error) {
+	var invalid *schemas.ValidationError
+	err := resourceSchemas["user"].ValidateJSON(data)
+	switch {
+	case errors.As(err, &invalid):
+		// The payload may not even decode as a user, so the checks made of
+		// users are left until it is fixed
+		return store.User{}, invalid.Problems, nil
+	case err != nil:
+		return store.User{}, nil, err
+	}
+
+	var user store.User
+	if err := codec.NewDecoder(bytes.NewReader(data)).Decode(&user); err != nil {
+		return store.User{}, []schemas.Problem{{Message: err.Error()}}, nil
+	}
+	user = normalized(user)
+	problems := validation.Struct(user)
+	if problems == nil {
+		problems = []schemas.Problem{}
+	}
+	if settings, ok := tenants.SettingsFrom(ctx); ok && !settings.AllowsEmail(user.Email) {
+		problems = append(problems, schemas.Problem{Field: "email", Message: "domain not allowed, expected one of " + strings.Join(settings.EmailDomains, ", ")})
+	}
+	err = h.validate(ctx, user)
+	switch {
+	case errors.Is(err, plugins.ErrRejected), errors.Is(err, scripts.ErrRejected):
+		problems = append(problems, schemas.Problem{Message: err.Error()})
+	case err != nil:
+		return store.User{}, nil, err
+	}
+	return user, problems, nil
+}
+
+// checkFailed writes a failure to check a user: a 500 for the server's own
+// scripts, or a 502 for plugins
+func checkFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errScript) {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeError(w, r, http.StatusBadGateway, err.Error())
+}
+
+// validated reports whether the validate-before-create plugins and
+// scripts accept creating user, writing an error otherwise
+func (h *UserHandler) validated(w http.ResponseWriter, r *http.Request, user store.User) bool {
+	err := h.validate(r.Context(), user)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, plugins.ErrRejected), errors.Is(err, scripts.ErrRejected):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errScript):
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		writeError(w, r, http.StatusBadGateway, err.Error())
+	}
+	return false
+}
+
+// errScript marks failures of scripts, which are the server's own, unlike
+// those of plugins
+var errScript = errors.New("script error")
+
+// validate runs the validate-before-create plugins, then scripts, on user
+func (h *UserHandler) validate(ctx context.Context, user store.User) error {
+	if err := h.plugins.ValidateBeforeCreate(ctx, user); err != nil {
+		return err
+	}
+	err := h.scripts.ValidateBeforeCreate(ctx, user)
+	if err != nil && !errors.Is(err, scripts.ErrRejected) {
+		return fmt.Errorf("%w: %w", errScript, err)
+	}
+	return err
+}
+
+// enriches reports whether users read are enriched by plugins or scripts
+func (h *UserHandler) enriches() bool {
+	return h.plugins.Handles(plugin.HookEnrichAfterRead) || h.scripts.Handles(plugin.HookEnrichAfterRead)
+}
+
+// writeReadUser writes user, read for the caller of r, with the attributes
+// the enrich-after-read plugins and scripts add, unless the caller already
+// has its version
+func (h *UserHandler) writeReadUser(w http.ResponseWriter, r *http.Request, user store.User) {
+	if notModified(w, r, userETag(r, h.fields, user)) {
+		return
+	}
+	if !h.enriches() {
+		writeUser(w, r, h.fields, http.StatusOK, user, user.ID)
+		return
+	}
+	enriched, ok := h.enrich(w, r, []store.User{user})
+	if !ok {
+		return
+	}
+	writeUser(w, r, h.fields, http.StatusOK, enriched[0], user.ID)
+}
+
+// writeReadUsers writes users, read for the caller of r, with the
+// attributes the enrich-after-read plugins and scripts add, unless the
+// caller already has them
+func (h *UserHandler) writeReadUsers(w http.ResponseWriter, r *http.Request, users []store.User) {
+	if notModified(w, r, usersETag(r, h.fields, users)) {
+		return
+	}
+	if !h.enriches() {
+		writeUsers(w, r, h.fields, users, userID)
+		return
+	}
+	enriched, ok := h.enrich(w, r, users)
+	if !ok {
 		return
 	}
+	writeUsers(w, r, h.fields, enriched, func(user EnrichedUser) int64 { return user.ID })
+}
 
-	c.JSON(http.StatusCreated, createdUser)
+// enrich returns users with the attributes the enrich-after-read plugins
+// and then scripts add, writing an error if one fails
+func (h *UserHandler) enrich(w http.ResponseWriter, r *http.Request, users []store.User) ([]EnrichedUser, bool) {
+	attributes, err := h.plugins.EnrichAfterRead(r.Context(), users)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, err.Error())
+		return nil, false
+	}
+	if h.scripts.Handles(plugin.HookEnrichAfterRead) {
+		if attributes, err = h.scripts.EnrichAfterRead(r.Context(), users, attributes); err != nil {
+			writeError(w, r, http.StatusInternalServerError, err.Error())
+			return nil, false
+		}
+	}
+	enriched := make([]EnrichedUser, len(users))
+	for i, user := range users {
+		enriched[i] = EnrichedUser{User: user, Attributes: attributes[i]}
+	}
+	return enriched, true
+}
+
+// allowedEmail reports whether the tenant of the request allows users to
+// have email, writing an error otherwise
+func allowedEmail(w http.ResponseWriter, r *http.Request, email string) bool {
+	if settings, ok := tenants.SettingsFrom(r.Context()); ok && !settings.AllowsEmail(email) {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.EmailDomainNotAllowed, "Email domain not allowed, expected one of "+strings.Join(settings.EmailDomains, ", "))
+		return false
+	}
+	return true
+}
+
+// writeDuplicateEmail writes a 409 for a user whose email another user
+// already has
+func writeDuplicateEmail(w http.ResponseWriter, r *http.Request) {
+	writeProblems(w, r, http.StatusConflict, apierrors.DuplicateEmail, "Email already in use", []schemas.Problem{
+		{Field: "email", Message: "is already in use by another user"},
+	})
 }
 
 // @Summary Update a user
 // @Description Update user by ID
 // @Tags users
 // @Accept json
+// @Accept x-www-form-urlencoded
+// @Accept mpfd
 // @Produce json
 // @Param id path int true "User ID"
 // @Param user body store.User true "User object"
+// @Param If-Match header string false "ETag of the user being updated; refused with 412 if the user has changed since, and required when preconditions are"
 // @Success 200 {object} store.User
+// @Header 200 {string} ETag "The updated user's version"
 // @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
 // @Router /api/v1/users/{id} [put]
-func (h *UserHandler) UpdateUser(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
 		return
 	}
+	if !h.mayModify(w, r, id) {
+		return
+	}
+	h.replaceUser(w, r, id)
+}
 
+// replaceUser replaces the user id with the one in the request body, from
+// the version If-Match names
+func (h *UserHandler) replaceUser(w http.ResponseWriter, r *http.Request, id int64) {
+	version, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
 	var user store.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	if err := decodeResource(r, resourceSchemas["user"], &user); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	updatedUser, err := h.userStore.Update(id, user)
-	if err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+	user = normalized(user)
+	if !bound(w, r, user) || !allowedEmail(w, r, user.Email) {
+		return
+	}
+	updatedUser, err := h.updateUser(r.Context(), id, version, user)
+	switch {
+	case errors.Is(err, store.ErrDuplicateEmail):
+		writeDuplicateEmail(w, r)
 		return
+	case errors.Is(err, store.ErrVersionConflict):
+		writePreconditionFailed(w, r)
+		return
+	case err != nil:
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(r, h.fields, *updatedUser))
+	writeUser(w, r, h.fields, http.StatusOK, updatedUser, updatedUser.ID)
+}
+
+// updateUser updates a user while it is at version, telling listeners what
+// changed
+func (h *UserHandler) updateUser(ctx context.Context, id, version int64, user store.User) (*store.User, error) {
+	// Listeners are told what changed, so read the user first
+	var before *store.User
+	if len(h.listeners) > 0 {
+		var err error
+		if before, err = timedStore(ctx, h.userStore).GetByID(id); err != nil {
+			return nil, err
+		}
 	}
 
-	c.JSON(http.StatusOK, updatedUser)
+	updatedUser, err := updateVersion(timedStore(ctx, h.userStore), id, version, user)
+	if err != nil {
+		return nil, err
+	}
+	h.purge(ctx, id)
+	for _, listener := range h.listeners {
+		listener.UserUpdated(ctx, *before, *updatedUser)
+	}
+	return updatedUser, nil
 }
 
 // @Summary Delete a user
-// @Description Delete user by ID
+// @Description Delete user by ID. When deletes require approval, a pending approval request is returned instead and the user is deleted once another admin approves it.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
+// @Param If-Match header string false "ETag of the user being deleted; refused with 412 if the user has changed since, and required when preconditions are"
 // @Success 204 "No Content"
+// @Success 202 {object} approval.Request
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
 // @Router /api/v1/users/{id} [delete]
-func (h *UserHandler) DeleteUser(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if !h.mayModify(w, r, id) {
+		return
+	}
+	h.removeUser(w, r, id)
+}
+
+// removeUser deletes the user id, or asks for approval to when required,
+// unless If-Match names a version other than the current one
+func (h *UserHandler) removeUser(w http.ResponseWriter, r *http.Request, id int64) {
+	version, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+	if h.approvals != nil {
+		h.requestDeletion(w, r, id, version)
+		return
+	}
+
+	err := h.deleteUser(r.Context(), id, version)
+	switch {
+	case errors.Is(err, store.ErrVersionConflict):
+		writePreconditionFailed(w, r)
+		return
+	case err != nil:
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUser deletes a user while it is at version, keeping it in the
+// recycle bin when enabled
+func (h *UserHandler) deleteUser(ctx context.Context, id, version int64) error {
+	if h.recycleBin == nil {
+		if err := deleteVersion(h.userStore, id, version); err != nil {
+			return err
+		}
+		h.purge(ctx, id)
+		return nil
+	}
+
+	user, err := h.userStore.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if version != anyVersion && user.Version != version {
+		return store.ErrVersionConflict
+	}
+	if err := deleteVersion(h.userStore, id, version); err != nil {
+		return err
+	}
+	h.recycleBin.Add(*user)
+	h.purge(ctx, id)
+	return nil
+}
+
+// MergeHooks returns hooks that make merges delete and update users as the
+// handler does, keeping duplicates restorable and purging cached responses
+func (h *UserHandler) MergeHooks() merge.Hooks {
+	return merge.Hooks{
+		Delete: func(ctx context.Context, id int64) error {
+			return h.deleteUser(ctx, id, anyVersion)
+		},
+		Updated: h.purge,
+	}
+}
+
+// tag adds keys to the response's Surrogate-Key header, when enabled
+func (h *UserHandler) tag(w http.ResponseWriter, keys ...string) {
+	if h.purger != nil {
+		surrogate.Add(w, keys...)
+	}
+}
+
+// purge purges the cached responses about the user id, when enabled, and
+// invalidates materialized views. The write has happened, so failing to
+// purge is logged rather than returned.
+func (h *UserHandler) purge(ctx context.Context, id int64) {
+	if h.materialized != nil {
+		h.materialized.Invalidate()
+	}
+	if h.purger == nil {
+		return
+	}
+	if err := h.purger.Purge(ctx, surrogate.UserChanged(id)...); err != nil {
+		logging.FromContext(ctx, logging.HTTP).Error("Failed to purge cached responses", "user_id", id, "error", err)
+	}
+}
+
+// @Summary Get me
+// @Description Get the authenticated user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param If-None-Match header string false "ETag of the user already held, answered with 304 Not Modified while it is current"
+// @Success 200 {object} store.User
+// @Header 200 {string} ETag "The user's version"
+// @Success 304 "Not Modified"
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/me [get]
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	id, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	h.tag(w, surrogate.User(id))
+
+	user, err := timedStore(r.Context(), h.userStore).GetByID(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
 		return
 	}
 
-	if err := h.userStore.Delete(id); err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+	h.writeReadUser(w, r, *user)
+}
+
+// @Summary Update me
+// @Description Update the authenticated user
+// @Tags users
+// @Accept json
+// @Accept x-www-form-urlencoded
+// @Accept mpfd
+// @Produce json
+// @Param user body store.User true "User object"
+// @Param If-Match header string false "ETag of the user being updated; refused with 412 if the user has changed since, and required when preconditions are"
+// @Success 200 {object} store.User
+// @Header 200 {string} ETag "The updated user's version"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Router /api/v1/me [put]
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	id, ok := currentUserID(w, r)
+	if !ok {
 		return
 	}
+	h.replaceUser(w, r, id)
+}
 
-	c.Status(http.StatusNoContent)
+// @Summary Delete me
+// @Description Delete the authenticated user. When deletes require approval, a pending approval request is returned instead.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param If-Match header string false "ETag of the user being deleted; refused with 412 if the user has changed since, and required when preconditions are"
+// @Success 204 "No Content"
+// @Success 202 {object} approval.Request
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Router /api/v1/me [delete]
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	id, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	h.removeUser(w, r, id)
+}
+
+// mayModify reports whether the caller may change or delete the user id,
+// writing an error response if not. Without RequireOwnership anyone may.
+func (h *UserHandler) mayModify(w http.ResponseWriter, r *http.Request, id int64) bool {
+	return !h.ownership || requireOwner(w, r, auth.UserOwner(id), "Not allowed to change this user")
+}
+
+// currentUserID returns the ID of the user making r, writing an error if
+// the caller is anonymous or not a user, such as an API key
+func currentUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return 0, false
+	}
+	userID, err := store.ParseID(principal.Subject)
+	if err != nil {
+		writeError(w, r, http.StatusForbidden, "The caller is not a user")
+		return 0, false
+	}
+	return userID, true
+}
+
+// @Summary Restore a deleted user
+// @Description Restore a user deleted within the undo window, with its original ID
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} store.User
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/users/{id}/undelete [post]
+func (h *UserHandler) UndeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	user, err := h.recycleBin.Undelete(id)
+	if errors.Is(err, store.ErrNotRecoverable) {
+		writeError(w, r, http.StatusNotFound, "User is not pending deletion")
+		return
+	}
+	if errors.Is(err, store.ErrDuplicateEmail) {
+		writeDuplicateEmail(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.purge(r.Context(), id)
+	writeUser(w, r, h.fields, http.StatusOK, user, user.ID)
+}
+
+// requestDeletion submits a user's deletion for approval
+func (h *UserHandler) requestDeletion(w http.ResponseWriter, r *http.Request, id, version int64) {
+	user, err := timedStore(r.Context(), h.userStore).GetByID(id)
+	if err != nil {
+		writeCodedError(w, r, http.StatusNotFound, apierrors.UserNotFound, "User not found")
+		return
+	}
+	// Approved deletes happen later, so If-Match applies to the request
+	if version != anyVersion && user.Version != version {
+		writePreconditionFailed(w, r)
+		return
+	}
+
+	var requestedBy string
+	if principal, ok := reqctx.PrincipalFrom(r.Context()); ok {
+		requestedBy = principal.Subject
+	}
+	request, err := h.approvals.Submit(approval.DeleteUser, strconv.FormatInt(id, 10), requestedBy)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, request)
 }
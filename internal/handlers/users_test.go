@@ -1,19 +1,76 @@
 package handlers
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/swaggo/swag"
 
+	_ "github.com/dazraf/go-api-example/api" // Load swagger docs
+	"github.com/dazraf/go-api-example/internal/anonymize"
+	"github.com/dazraf/go-api-example/internal/apidocs"
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/apikeys"
+	"github.com/dazraf/go-api-example/internal/approval"
+	"github.com/dazraf/go-api-example/internal/auth"
+	"github.com/dazraf/go-api-example/internal/avatars"
+	"github.com/dazraf/go-api-example/internal/backup"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/consent"
+	"github.com/dazraf/go-api-example/internal/contract"
+	"github.com/dazraf/go-api-example/internal/directory"
+	"github.com/dazraf/go-api-example/internal/graph"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/instances"
+	"github.com/dazraf/go-api-example/internal/materialized"
+	"github.com/dazraf/go-api-example/internal/merge"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/operations"
+	"github.com/dazraf/go-api-example/internal/orgs"
+	"github.com/dazraf/go-api-example/internal/plugins"
+	"github.com/dazraf/go-api-example/internal/preferences"
+	"github.com/dazraf/go-api-example/internal/ratelimit"
+	"github.com/dazraf/go-api-example/internal/replication"
+	"github.com/dazraf/go-api-example/internal/reports"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/rules"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/scim"
+	"github.com/dazraf/go-api-example/internal/scripts"
+	"github.com/dazraf/go-api-example/internal/stats"
 	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/surrogate"
+	"github.com/dazraf/go-api-example/internal/tenants"
+	"github.com/dazraf/go-api-example/internal/uploads"
+	"github.com/dazraf/go-api-example/internal/usage"
+	"github.com/dazraf/go-api-example/internal/views"
+	"github.com/dazraf/go-api-example/internal/visibility"
+	"github.com/dazraf/go-api-example/internal/webhooks"
+	"github.com/dazraf/go-api-example/pkg/client"
+	"github.com/dazraf/go-api-example/pkg/fixtures"
+	"github.com/dazraf/go-api-example/pkg/plugin"
 )
 
 // MockUserStore for testing
@@ -26,7 +83,12 @@ func (m *MockUserStore) GetAll() ([]store.User, error) {
 	return args.Get(0).([]store.User), args.Error(1)
 }
 
-func (m *MockUserStore) GetByID(id int) (*store.User, error) {
+func (m *MockUserStore) Count() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockUserStore) GetByID(id int64) (*store.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -34,6 +96,14 @@ func (m *MockUserStore) GetByID(id int) (*store.User, error) {
 	return args.Get(0).(*store.User), args.Error(1)
 }
 
+func (m *MockUserStore) GetByEmail(email string) (*store.User, error) {
+	args := m.Called(email)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*store.User), args.Error(1)
+}
+
 func (m *MockUserStore) Create(user store.User) (*store.User, error) {
 	args := m.Called(user)
 	if args.Get(0) == nil {
@@ -42,7 +112,7 @@ func (m *MockUserStore) Create(user store.User) (*store.User, error) {
 	return args.Get(0).(*store.User), args.Error(1)
 }
 
-func (m *MockUserStore) Update(id int, user store.User) (*store.User, error) {
+func (m *MockUserStore) Update(id int64, user store.User) (*store.User, error) {
 	args := m.Called(id, user)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -50,26 +120,16 @@ func (m *MockUserStore) Update(id int, user store.User) (*store.User, error) {
 	return args.Get(0).(*store.User), args.Error(1)
 }
 
-func (m *MockUserStore) Delete(id int) error {
+func (m *MockUserStore) Delete(id int64) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func setupTestRouter(userStore store.UserStore) *gin.Engine {
+func setupTestRouter(userStore store.UserStore) http.Handler {
 	gin.SetMode(gin.TestMode)
-	router := gin.Default()
-	handler := NewUserHandler(userStore)
-
-	v1 := router.Group("/api/v1")
-	{
-		v1.GET("/users", handler.GetUsers)
-		v1.GET("/users/:id", handler.GetUser)
-		v1.POST("/users", handler.CreateUser)
-		v1.PUT("/users/:id", handler.UpdateUser)
-		v1.DELETE("/users/:id", handler.DeleteUser)
-	}
-
-	return router
+	r := router.NewGin(gin.Default())
+	router.Mount(r, NewUserHandler(userStore).Routes())
+	return r
 }
 
 func TestUserHandler_GetUsers(t *testing.T) {
@@ -82,6 +142,7 @@ func TestUserHandler_GetUsers(t *testing.T) {
 		{
 			name: "successful get all users",
 			setupMock: func(m *MockUserStore) {
+				// Literals: the mock returns stored users, with IDs
 				users := []store.User{
 					{ID: 1, Name: "John Doe", Email: "john@example.com"},
 					{ID: 2, Name: "Jane Smith", Email: "jane@example.com"},
@@ -114,6 +175,7 @@ func TestUserHandler_GetUsers(t *testing.T) {
 			router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
 			tt.expectedBody(t, w.Body.String())
 
 			mockStore.AssertExpectations(t)
@@ -131,8 +193,10 @@ func TestUserHandler_CreateUser(t *testing.T) {
 	}{
 		{
 			name:    "successful user creation",
-			payload: store.User{Name: "John Doe", Email: "john@example.com"},
+			payload: store.User(fixtures.New(1).User().WithName("John Doe").WithEmail("john@example.com").Build()),
 			setupMock: func(m *MockUserStore) {
+				// Literals: the mock matches the exact user decoded from
+				// the payload, and returns it as stored
 				inputUser := store.User{Name: "John Doe", Email: "john@example.com"}
 				createdUser := &store.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
 				m.On("Create", inputUser).Return(createdUser, nil)
@@ -142,7 +206,7 @@ func TestUserHandler_CreateUser(t *testing.T) {
 				var user store.User
 				err := json.Unmarshal([]byte(body), &user)
 				require.NoError(t, err)
-				assert.Equal(t, 1, user.ID)
+				assert.Equal(t, int64(1), user.ID)
 				assert.Equal(t, "John Doe", user.Name)
 				assert.Equal(t, "john@example.com", user.Email)
 			},
@@ -181,14 +245,253 @@ func TestUserHandler_CreateUser(t *testing.T) {
 	}
 }
 
+func TestUserHandler_CreateUserPayloads(t *testing.T) {
+	multipartBody := func(fields map[string]string) (io.Reader, string) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for name, value := range fields {
+			require.NoError(t, writer.WriteField(name, value))
+		}
+		require.NoError(t, writer.Close())
+		return &body, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name           string
+		request        func() *http.Request
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name: "url-encoded form",
+			request: func() *http.Request {
+				req := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader("name=John+Doe&email=john%40example.com&extra=1"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "multipart form",
+			request: func() *http.Request {
+				body, contentType := multipartBody(map[string]string{"name": "John Doe", "email": "john@example.com"})
+				req := httptest.NewRequest("POST", "/api/v1/users", body)
+				req.Header.Set("Content-Type", contentType)
+				return req
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "query parameters",
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/api/v1/users?name=John+Doe&email=john%40example.com", nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "form value of the wrong type",
+			request: func() *http.Request {
+				req := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader("name=John+Doe&email=john%40example.com&id=abc"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "id: must be of type integer",
+		},
+		{
+			name: "empty name",
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"name":"","email":"john@example.com"}`))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `"errors":[{"field":"name","message":"must not be empty"}]`,
+		},
+		{
+			name: "malformed email in form",
+			request: func() *http.Request {
+				req := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader("name=John+Doe&email=john"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `"errors":[{"field":"email","message":"must be a valid email address, such as john@example.com"}]`,
+		},
+		{
+			name: "no body or query parameters",
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/api/v1/users", nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "request body is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupTestRouter(store.NewMemoryUserStore())
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.request())
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedError != "" {
+				assert.Contains(t, w.Body.String(), tt.expectedError)
+				return
+			}
+			var user store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+			assert.NotZero(t, user.ID)
+			assert.Equal(t, "John Doe", user.Name)
+			assert.Equal(t, "john@example.com", user.Email)
+		})
+	}
+}
+
+func TestUserHandler_DuplicateEmail(t *testing.T) {
+	router := setupTestRouter(store.NewMemoryUserStore())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@example.com"}`).Code)
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", `{"name":"Jane Doe","email":"jane@example.com"}`).Code)
+
+	for _, w := range []*httptest.ResponseRecorder{
+		do("POST", "/api/v1/users", `{"name":"Johnny","email":"JOHN@example.com"}`),
+		do("PUT", "/api/v1/users/2", `{"name":"Jane Doe","email":"john@example.com"}`),
+	} {
+		require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "Email already in use", response.Error)
+		assert.Equal(t, apierrors.DuplicateEmail, response.Code)
+		assert.Equal(t, []schemas.Problem{{Field: "email", Message: "is already in use by another user"}}, response.Errors)
+	}
+
+	// Users keep their own email
+	assert.Equal(t, http.StatusOK, do("PUT", "/api/v1/users/1", `{"name":"John Smith","email":"john@example.com"}`).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/api/v1/users/batch", `[{"name":"New","email":"new@example.com"},{"name":"Copy","email":"jane@example.com"}]`).Code)
+}
+
+func TestUserHandler_ErrorCodes(t *testing.T) {
+	router := setupTestRouter(store.NewMemoryUserStore())
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               apierrors.Code
+	}{
+		{"GET", "/api/v1/users/7", "", http.StatusNotFound, apierrors.UserNotFound},
+		{"GET", "/api/v1/users/abc", "", http.StatusBadRequest, apierrors.InvalidParameter},
+		{"GET", "/api/v1/users/users-7", "", http.StatusNotFound, apierrors.UserNotFound},
+		{"GET", "/api/v1/users/orgs-7", "", http.StatusBadRequest, apierrors.InvalidParameter},
+		{"GET", "/api/v1/users?sort=colour", "", http.StatusBadRequest, apierrors.InvalidParameter},
+		{"GET", "/api/v1/users?updated_within=soon", "", http.StatusBadRequest, apierrors.InvalidParameter},
+		{"POST", "/api/v1/users", `{"name":"John Doe","email":"john"}`, http.StatusBadRequest, apierrors.ValidationFailed},
+		{"POST", "/api/v1/users", `{"name":`, http.StatusBadRequest, apierrors.BadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			var response ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.code, response.Code)
+			assert.NotEmpty(t, response.Error)
+		})
+	}
+}
+
+func TestUserHandler_GetUserBySequenceID(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, err := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+	router := setupTestRouter(realStore)
+
+	// Paths take the ID as a number or in the string form of a sequence
+	for _, id := range []string{"1", "users-1"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/users/"+id, nil))
+		require.Equal(t, http.StatusOK, w.Code, id)
+		var got store.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, user.ID, got.ID)
+	}
+}
+
+func TestUserHandler_CreateUsers(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		userStore      store.UserStore
+		expectedStatus int
+		expectedBatch  UserBatch
+		expectedUsers  int
+	}{
+		{
+			name:           "created",
+			body:           `[{"name":"John Doe","email":"john@example.com"},{"name":"Jane Doe","email":"jane@example.com"}]`,
+			expectedStatus: http.StatusCreated,
+			expectedBatch:  UserBatch{Created: true, Results: []UserBatchResult{{Index: 0, ID: 1}, {Index: 1, ID: 2}}},
+			expectedUsers:  2,
+		},
+		{
+			name:           "one invalid user creates none",
+			body:           `[{"name":"John Doe","email":"john@example.com"},{"name":"Jane Doe","email":"jane"}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBatch: UserBatch{Results: []UserBatchResult{
+				{Index: 0},
+				{Index: 1, Errors: []schemas.Problem{{Field: "email", Message: "must be formatted as email"}}},
+			}},
+		},
+		{name: "empty", body: `[]`, expectedStatus: http.StatusBadRequest},
+		{name: "not an array", body: `{"name":"John Doe","email":"john@example.com"}`, expectedStatus: http.StatusBadRequest},
+		{
+			name:           "store without batches",
+			body:           `[{"name":"John Doe","email":"john@example.com"}]`,
+			userStore:      new(MockUserStore),
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := tt.userStore
+			if userStore == nil {
+				userStore = store.NewMemoryUserStore()
+			}
+			router := setupTestRouter(userStore)
+
+			req := httptest.NewRequest("POST", "/api/v1/users/batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedBatch.Results != nil {
+				var batch UserBatch
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &batch))
+				assert.Equal(t, tt.expectedBatch, batch)
+			}
+			if tt.userStore == nil {
+				count, err := userStore.Count()
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedUsers, count)
+			}
+		})
+	}
+}
+
 // Integration test with real store
 func TestUserHandler_Integration_FullCRUDWorkflow(t *testing.T) {
 	realStore := store.NewMemoryUserStore()
 	router := setupTestRouter(realStore)
 
+	users := fixtures.New(1)
+
 	// Create user
-	user := store.User{Name: "Integration Test User", Email: "integration@example.com"}
-	payload, _ := json.Marshal(user)
+	payload, _ := json.Marshal(users.User().Build())
 
 	req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBuffer(payload))
 	req.Header.Set("Content-Type", "application/json")
@@ -211,13 +514,12 @@ func TestUserHandler_Integration_FullCRUDWorkflow(t *testing.T) {
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
-	var users []store.User
-	_ = json.Unmarshal(w.Body.Bytes(), &users)
-	assert.Equal(t, 1, len(users))
+	var listed []store.User
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	assert.Equal(t, 1, len(listed))
 
 	// Update user
-	updatedUser := store.User{Name: "Updated Integration User", Email: "updated@example.com"}
-	payload, _ = json.Marshal(updatedUser)
+	payload, _ = json.Marshal(users.User().Build())
 	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/v1/users/%d", createdUser.ID), bytes.NewBuffer(payload))
 	req.Header.Set("Content-Type", "application/json")
 	w = httptest.NewRecorder()
@@ -236,3 +538,3167 @@ func TestUserHandler_Integration_FullCRUDWorkflow(t *testing.T) {
 	router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusNotFound, w.Code)
 }
+
+func TestUserHandler_ServesOnAnyRouter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	routers := map[string]router.Router{
+		router.EngineGin:    router.NewGin(gin.New()),
+		router.EngineChi:    router.NewChi(),
+		router.EngineStdlib: router.NewStdlib(),
+	}
+
+	for engine, r := range routers {
+		t.Run(engine, func(t *testing.T) {
+			realStore := store.NewMemoryUserStore()
+			user, _ := realStore.Create(store.User(fixtures.New(1).User().Build()))
+			router.Mount(r, NewUserHandler(realStore).Routes())
+
+			req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/users/%d", user.ID), nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var got store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, *user, got)
+
+			req, _ = http.NewRequest("DELETE", "/api/v1/users/abc", nil)
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestUserHandler_GetUsersCacheFollowsWrites(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	router := setupTestRouter(realStore)
+
+	list := func() []store.User {
+		req, _ := http.NewRequest("GET", "/api/v1/users", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+		var users []store.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		return users
+	}
+
+	assert.Empty(t, list())
+
+	users := fixtures.New(1)
+	user, _ := realStore.Create(store.User(users.User().Build()))
+	assert.Equal(t, []store.User{*user}, list())
+	// Served from the cache
+	assert.Equal(t, []store.User{*user}, list())
+
+	updated, _ := realStore.Update(user.ID, store.User(users.User().Build()))
+	assert.Equal(t, []store.User{*updated}, list())
+
+	require.NoError(t, realStore.Delete(user.ID))
+	assert.Empty(t, list())
+}
+
+// Benchmark tests
+func benchmarkGetUsers(b *testing.B, count int, cached bool) {
+	realStore := store.NewMemoryUserStore()
+	for _, user := range fixtures.New(1).Users(count) {
+		_, _ = realStore.Create(store.User(user))
+	}
+
+	// Keep request logging out of the benchmark output
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = io.Discard
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	var userStore store.UserStore = realStore
+	if !cached {
+		// Hide Revision so every request encodes the list
+		userStore = struct{ store.UserStore }{realStore}
+	}
+	router := setupTestRouter(userStore)
+	req, _ := http.NewRequest("GET", "/api/v1/users", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkUserHandler_GetUsers(b *testing.B) {
+	benchmarkGetUsers(b, 1000, false)
+}
+
+func BenchmarkUserHandler_GetUsersCached(b *testing.B) {
+	benchmarkGetUsers(b, 1000, true)
+}
+
+// BenchmarkUserHandler_GetUsers10k compares JSON codecs, see make benchmark-codecs
+func BenchmarkUserHandler_GetUsers10k(b *testing.B) {
+	benchmarkGetUsers(b, 10000, false)
+}
+
+func TestUserHandler_ErrorsCarryTraceID(t *testing.T) {
+	router := setupTestRouter(new(MockUserStore))
+
+	trace := reqctx.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	req := httptest.NewRequest("GET", "/api/v1/users/abc", nil)
+	req = req.WithContext(reqctx.WithTrace(req.Context(), trace))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"Invalid user ID","code":"INVALID_PARAMETER","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}`, w.Body.String())
+}
+
+func TestUserHandler_InvalidIDs(t *testing.T) {
+	// Mock calls would fail the test, so none of these reach the store
+	router := setupTestRouter(new(MockUserStore))
+	for _, id := range []string{"abc", "0", "-1", "1.5", "9223372036854775808", "99999999999999999999"} {
+		for _, method := range []string{"GET", "PUT", "DELETE"} {
+			t.Run(method+" "+id, func(t *testing.T) {
+				req := httptest.NewRequest(method, "/api/v1/users/"+id, strings.NewReader(`{"name":"John Doe","email":"john@example.com"}`))
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.JSONEq(t, `{"error":"Invalid user ID","code":"INVALID_PARAMETER"}`, w.Body.String())
+			})
+		}
+	}
+}
+
+// recordingListener records the user writes it is told about
+type recordingListener struct {
+	events []string
+}
+
+func (l *recordingListener) UserCreated(_ context.Context, user store.User) {
+	l.events = append(l.events, "created "+user.Name)
+}
+
+func (l *recordingListener) UserUpdated(_ context.Context, before, after store.User) {
+	l.events = append(l.events, "updated "+before.Name+" to "+after.Name)
+}
+
+func TestUserHandler_NotifiesListeners(t *testing.T) {
+	listener := &recordingListener{}
+	r := router.NewStdlib()
+	router.Mount(r, NewUserHandler(store.NewMemoryUserStore(), listener).Routes())
+
+	req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBufferString(`{"name":"John Doe","email":"john@example.com"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/v1/users/1", bytes.NewBufferString(`{"name":"Johnny","email":"john@example.com"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Failed writes are not reported
+	req, _ = http.NewRequest("PUT", "/api/v1/users/99", bytes.NewBufferString(`{"name":"Nobody","email":"nobody@example.com"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.Equal(t, []string{"created John Doe", "updated John Doe to Johnny"}, listener.events)
+}
+
+func TestUserHandler_GetUsersInactiveSince(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	active, _ := realStore.Create(store.User(users.User().WithName("Active").Build()))
+	idle, _ := realStore.Create(store.User(users.User().WithName("Idle").Build()))
+	_, _ = realStore.Create(store.User(users.User().WithName("Never Seen").Build()))
+	require.NoError(t, realStore.RecordActivity(map[int64]time.Time{
+		active.ID: time.Now().Add(-time.Hour),
+		idle.ID:   time.Now().Add(-45 * 24 * time.Hour),
+	}))
+	router := setupTestRouter(realStore)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{name: "thirty days", query: "30d", expectedStatus: http.StatusOK, expectedNames: []string{"Idle", "Never Seen"}},
+		{name: "ten weeks", query: "10w", expectedStatus: http.StatusOK, expectedNames: []string{"Never Seen"}},
+		{name: "minutes", query: "30m", expectedStatus: http.StatusOK, expectedNames: []string{"Active", "Idle", "Never Seen"}},
+		{name: "invalid", query: "soon", expectedStatus: http.StatusBadRequest},
+		{name: "negative", query: "-1d", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/users?inactive_since="+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var users []store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+			names := make([]string, 0, len(users))
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			assert.ElementsMatch(t, tt.expectedNames, names)
+		})
+	}
+}
+
+func TestUserHandler_GetUsersFilters(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	now := time.Now().UTC()
+	users := fixtures.New(1)
+	for _, user := range []client.User{
+		users.User().WithID(1).WithName("Old").WithEmail("user1@example.com").WithCreatedAt(now.AddDate(0, -2, 0)).Build(),
+		users.User().WithID(2).WithName("Recent").WithEmail("user2@example.com").WithCreatedAt(now.AddDate(0, 0, -3)).Build(),
+		users.User().WithID(3).WithName("Edited").WithEmail("user3@example.com").WithCreatedAt(now.AddDate(0, -1, 0)).WithUpdatedAt(now.Add(-time.Hour)).Build(),
+	} {
+		_, err := realStore.Restore(store.User(user))
+		require.NoError(t, err)
+	}
+	router := setupTestRouter(realStore)
+
+	weekAgo := now.AddDate(0, 0, -7).Format(time.DateOnly)
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{name: "created after", query: "created_after=" + weekAgo, expectedStatus: http.StatusOK, expectedNames: []string{"Recent"}},
+		{name: "created before", query: "created_before=" + weekAgo, expectedStatus: http.StatusOK, expectedNames: []string{"Old", "Edited"}},
+		{name: "updated within", query: "updated_within=24h", expectedStatus: http.StatusOK, expectedNames: []string{"Edited"}},
+		{name: "combined", query: "created_before=" + weekAgo + "&updated_within=7d", expectedStatus: http.StatusOK, expectedNames: []string{"Edited"}},
+		{name: "name", query: "name=REC", expectedStatus: http.StatusOK, expectedNames: []string{"Recent"}},
+		{name: "email", query: "email=user3@", expectedStatus: http.StatusOK, expectedNames: []string{"Edited"}},
+		{name: "query matches names", query: "q=old", expectedStatus: http.StatusOK, expectedNames: []string{"Old"}},
+		{name: "query matches emails", query: "q=user2%40example", expectedStatus: http.StatusOK, expectedNames: []string{"Recent"}},
+		{name: "text and time", query: "q=example.com&updated_within=24h", expectedStatus: http.StatusOK, expectedNames: []string{"Edited"}},
+		{name: "no match", query: "name=nobody", expectedStatus: http.StatusOK, expectedNames: []string{}},
+		{name: "filter", query: "filter=" + url.QueryEscape("name=like=*d*;(id==1,updated_at>="+weekAgo+")"), expectedStatus: http.StatusOK, expectedNames: []string{"Old", "Edited"}},
+		{name: "filter and text", query: "filter=id=out=(2)&q=D", expectedStatus: http.StatusOK, expectedNames: []string{"Old", "Edited"}},
+		{name: "filter of unknown field", query: "filter=status==active", expectedStatus: http.StatusBadRequest},
+		{name: "filter that does not parse", query: "filter=" + url.QueryEscape("name==a;"), expectedStatus: http.StatusBadRequest},
+		{name: "invalid time", query: "created_after=last-week", expectedStatus: http.StatusBadRequest},
+		{name: "invalid duration", query: "updated_within=-1h", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/users?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var users []store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+			names := make([]string, 0, len(users))
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			assert.ElementsMatch(t, tt.expectedNames, names)
+		})
+	}
+}
+
+func TestUserHandler_GetUsersSorted(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, user := range []client.User{
+		users.User().WithName("Bea").WithEmail("bea@example.org").Build(),
+		users.User().WithName("Adam").WithEmail("adam@example.com").Build(),
+		users.User().WithName("Bea").WithEmail("bea@example.com").Build(),
+	} {
+		_, err := realStore.Create(store.User(user))
+		require.NoError(t, err)
+	}
+	router := setupTestRouter(realStore)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []int64
+	}{
+		{name: "by ID", query: "sort=id", expectedStatus: http.StatusOK, expectedIDs: []int64{1, 2, 3}},
+		{name: "ascending", query: "sort=name", expectedStatus: http.StatusOK, expectedIDs: []int64{2, 1, 3}},
+		{name: "several fields", query: "sort=name,-email", expectedStatus: http.StatusOK, expectedIDs: []int64{2, 1, 3}},
+		{name: "repeated parameter", query: "sort=-name&sort=email", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 1, 2}},
+		{name: "sorted and filtered", query: "sort=-id&q=example.com", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 2}},
+		{name: "unknown field", query: "sort=password", expectedStatus: http.StatusBadRequest},
+		{name: "repeated field", query: "sort=name,-name", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/users?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var users []store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+			ids := make([]int64, 0, len(users))
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestUserHandler_GetUsersCollated(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, name := range []string{"Zoe", "Ärla", "Adam"} {
+		_, err := realStore.Create(store.User(users.User().WithName(name).Build()))
+		require.NoError(t, err)
+	}
+	router := setupTestRouter(realStore)
+
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		expectedStatus int
+		expectedIDs    []int64
+		expectedVary   bool
+	}{
+		{name: "byte order", query: "sort=name", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 1, 2}, expectedVary: true},
+		{name: "collation", query: "sort=name&collation=de", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 2, 1}},
+		{name: "collation of another language", query: "sort=name&collation=sv", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 1, 2}},
+		{name: "accept language", query: "sort=name", acceptLanguage: "de-CH, en;q=0.8", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 2, 1}, expectedVary: true},
+		{name: "collation over accept language", query: "sort=name&collation=sv", acceptLanguage: "de", expectedStatus: http.StatusOK, expectedIDs: []int64{3, 1, 2}},
+		{name: "invalid collation", query: "sort=name&collation=not+a+language", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/users?"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.expectedVary, slices.Contains(w.Header().Values("Vary"), "Accept-Language"))
+			var users []store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+			ids := make([]int64, 0, len(users))
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestAuthHandler_SessionWorkflow(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	john, _ := realStore.Create(store.User(users.User().WithEmail("john@example.com").Build()))
+	jane, _ := realStore.Create(store.User(users.User().Build()))
+	admin, _ := realStore.Create(store.User(users.User().WithEmail("admin@example.com").Build()))
+	passwords := auth.NewPasswords()
+	require.NoError(t, passwords.Set(admin.ID, "password0"))
+	service, err := auth.NewService(realStore, passwords, auth.Options{
+		Secret: []byte("0123456789abcdef0123456789abcdef"),
+		Admins: []int64{admin.ID},
+	})
+	require.NoError(t, err)
+
+	r := router.NewStdlib()
+	router.Mount(r, router.Wrap(NewAuthHandler(realStore, service).Routes(), service.Middleware))
+	do := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Even a user without a password needs an admin to set one, then
+	// logs in with it
+	path := fmt.Sprintf("/api/v1/users/%d", john.ID)
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", path+"/password", "", PasswordRequest{Password: "password1"}).Code)
+	adminToken, _, err := service.Login("admin@example.com", "password0", httptest.NewRequest("POST", "/api/v1/login", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, do("PUT", path+"/password", adminToken, PasswordRequest{Password: "password1"}).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("PUT", path+"/password", "", PasswordRequest{Password: "password2"}).Code)
+
+	w := do("POST", "/api/v1/login", "", LoginRequest{Email: "john@example.com", Password: "password1"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var login LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+
+	// Sessions and logins are only visible to the user themselves
+	assert.Equal(t, http.StatusUnauthorized, do("GET", path+"/sessions", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", fmt.Sprintf("/api/v1/users/%d/sessions", jane.ID), login.Token, nil).Code)
+
+	w = do("GET", path+"/sessions", login.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var sessions []auth.Session
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+	require.Len(t, sessions, 1)
+	assert.Equal(t, login.SessionID, sessions[0].ID)
+
+	w = do("GET", path+"/logins", login.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var logins []auth.LoginEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &logins))
+	require.Len(t, logins, 1)
+	assert.Equal(t, auth.OutcomeSuccess, logins[0].Outcome)
+
+	// Revoking the session logs the token out
+	assert.Equal(t, http.StatusNotFound, do("DELETE", path+"/sessions/unknown", login.Token, nil).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", path+"/sessions/"+login.SessionID, login.Token, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", path+"/sessions", login.Token, nil).Code)
+}
+
+func TestAuthHandler_Impersonate(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	john, _ := realStore.Create(store.User(users.User().Build()))
+	admin, _ := realStore.Create(store.User(users.User().WithEmail("jane@example.com").Build()))
+	passwords := auth.NewPasswords()
+	require.NoError(t, passwords.Set(admin.ID, "password1"))
+	service, err := auth.NewService(realStore, passwords, auth.Options{
+		Secret: []byte("0123456789abcdef0123456789abcdef"),
+		Admins: []int64{admin.ID},
+	})
+	require.NoError(t, err)
+	adminToken, _, err := service.Login("jane@example.com", "password1", httptest.NewRequest("POST", "/api/v1/login", nil))
+	require.NoError(t, err)
+
+	handler := NewAuthHandler(realStore, service)
+	r := router.NewStdlib()
+	router.Mount(r, router.Wrap(append(handler.Routes(), handler.AdminRoutes()...), service.Middleware))
+	impersonate := func(id int64, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/impersonate/%d", id), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, impersonate(john.ID, "").Code)
+	assert.Equal(t, http.StatusBadRequest, impersonate(admin.ID, adminToken).Code)
+	assert.Equal(t, http.StatusNotFound, impersonate(99, adminToken).Code)
+
+	w := impersonate(john.ID, adminToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, fmt.Sprintf("Impersonated by user %d", admin.ID), response.Banner)
+
+	// The impersonation token is scoped to the user, without admin rights
+	assert.Equal(t, http.StatusForbidden, impersonate(admin.ID, response.Token).Code)
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/users/%d/sessions", john.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+response.Token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestUserHandler_DeleteRequiresApproval(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, _ := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	approvals := approval.NewWorkflow(approval.Options{})
+	userHandler := NewUserHandler(realStore)
+	userHandler.RequireDeleteApproval(approvals)
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	router.Mount(r, NewApprovalHandler(approvals).Routes())
+	do := func(method, path string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	requester := &reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}}
+	approver := &reqctx.Principal{Subject: "3", Roles: []string{auth.RoleAdmin}}
+
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/users/99", requester).Code)
+
+	// The delete is held until someone else approves it
+	w := do("DELETE", fmt.Sprintf("/api/v1/users/%d", user.ID), requester)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var request approval.Request
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &request))
+	assert.Equal(t, approval.StatusPending, request.Status)
+	_, err := realStore.GetByID(user.ID)
+	require.NoError(t, err)
+
+	approve := "/admin/approvals/" + request.ID + "/approve"
+	assert.Equal(t, http.StatusUnauthorized, do("POST", approve, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", approve, &reqctx.Principal{Subject: "4"}).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", approve, requester).Code)
+	assert.Equal(t, http.StatusOK, do("POST", approve, approver).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", approve, approver).Code)
+
+	_, err = realStore.GetByID(user.ID)
+	assert.Error(t, err)
+
+	w = do("GET", "/admin/approvals", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var requests []approval.Request
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &requests))
+	require.Len(t, requests, 1)
+	assert.Equal(t, approval.StatusApproved, requests[0].Status)
+}
+
+func TestUserHandler_Undelete(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, _ := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	userHandler := NewUserHandler(realStore)
+	userHandler.EnableUndelete(store.NewRecycleBin(realStore, time.Hour, nil))
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	do := func(method, path string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	path := fmt.Sprintf("/api/v1/users/%d", user.ID)
+	admin := &reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}}
+
+	assert.Equal(t, http.StatusNotFound, do("POST", path+"/undelete", nil).Code)
+	require.Equal(t, http.StatusNoContent, do("DELETE", path, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", path, nil).Code)
+
+	// Only admins see users pending deletion
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/users?include_deleted=true", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/users?include_deleted=true", &reqctx.Principal{Subject: "1"}).Code)
+	w := do("GET", "/api/v1/users?include_deleted=true", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var states []UserState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &states))
+	require.Len(t, states, 1)
+	assert.True(t, states[0].PendingDeletion)
+	assert.Equal(t, user.ID, states[0].ID)
+
+	w = do("POST", path+"/undelete", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var restored store.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+	assert.Equal(t, *user, restored)
+	assert.Equal(t, http.StatusOK, do("GET", path, nil).Code)
+}
+
+func TestUserHandler_Ownership(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	alice, _ := realStore.Create(store.User(users.User().WithEmail("alice@example.com").Build()))
+	bob, _ := realStore.Create(store.User(users.User().WithEmail("bob@example.com").Build()))
+	userHandler := NewUserHandler(realStore)
+	userHandler.RequireOwnership()
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	asAlice := &reqctx.Principal{Subject: fmt.Sprint(alice.ID)}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+	bobPath := fmt.Sprintf("/api/v1/users/%d", bob.ID)
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		principal *reqctx.Principal
+		body      any
+		expected  int
+	}{
+		{"me requires authentication", "GET", "/api/v1/me", nil, nil, http.StatusUnauthorized},
+		{"me requires a user", "GET", "/api/v1/me", &reqctx.Principal{Subject: "apikey:k1"}, nil, http.StatusForbidden},
+		{"get me", "GET", "/api/v1/me", asAlice, nil, http.StatusOK},
+		{"update me", "PUT", "/api/v1/me", asAlice, users.User().WithName("Alice Smith").WithEmail("alice@example.com").Build(), http.StatusOK},
+		{"anonymous update", "PUT", bobPath, nil, users.User().WithEmail("bob@example.com").Build(), http.StatusUnauthorized},
+		{"update another user", "PUT", bobPath, asAlice, users.User().WithEmail("bob@example.com").Build(), http.StatusForbidden},
+		{"delete another user", "DELETE", bobPath, asAlice, nil, http.StatusForbidden},
+		{"admin updates another user", "PUT", bobPath, admin, users.User().WithEmail("bob@example.com").Build(), http.StatusOK},
+		{"admin deletes another user", "DELETE", bobPath, admin, nil, http.StatusNoContent},
+		{"delete me", "DELETE", "/api/v1/me", asAlice, nil, http.StatusNoContent},
+		{"me once deleted", "GET", "/api/v1/me", asAlice, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, tt.path, tt.principal, tt.body)
+		require.Equal(t, tt.expected, w.Code, tt.name)
+		if tt.name == "update me" {
+			var updated store.User
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+			assert.Equal(t, alice.ID, updated.ID)
+			assert.Equal(t, "Alice Smith", updated.Name)
+		}
+	}
+}
+
+func TestUserHandler_SurrogateKeys(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	var purged [][]string
+	userHandler := NewUserHandler(realStore)
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without surrogate keys, responses are not tagged
+	w := do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@example.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, do("GET", "/api/v1/users/1", "").Header().Get(surrogate.Header))
+
+	userHandler.EnableSurrogateKeys(surrogate.PurgerFunc(func(_ context.Context, keys ...string) error {
+		purged = append(purged, keys)
+		return nil
+	}))
+	assert.Equal(t, "users-collection", do("GET", "/api/v1/users", "").Header().Get(surrogate.Header))
+	assert.Equal(t, "user-1", do("GET", "/api/v1/users/1", "").Header().Get(surrogate.Header))
+	assert.Equal(t, "user-2", do("GET", "/api/v1/users/2", "").Header().Get(surrogate.Header))
+	assert.Empty(t, purged, "reads purge nothing")
+
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", `{"name":"Jane Doe","email":"jane@example.com"}`).Code)
+	require.Equal(t, http.StatusOK, do("PUT", "/api/v1/users/1", `{"name":"John Smith","email":"john@example.com"}`).Code)
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/users/2", "").Code)
+	require.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/users/2", "").Code)
+	assert.Equal(t, [][]string{
+		{"user-2", "users-collection"},
+		{"user-1", "users-collection"},
+		{"user-2", "users-collection"},
+	}, purged, "failed writes purge nothing")
+}
+
+func TestOwnershipRules(t *testing.T) {
+	const (
+		allowed = iota
+		unauthenticated
+		forbidden
+		hidden
+	)
+	owner := &reqctx.Principal{Subject: "1"}
+	roles := []struct {
+		name      string
+		principal *reqctx.Principal
+		// expected is the outcome for the role, by whether routes hide
+		// others' resources
+		expected, expectedHidden int
+	}{
+		{"anonymous", nil, unauthenticated, hidden},
+		{"owner", owner, allowed, allowed},
+		{"other user", &reqctx.Principal{Subject: "2"}, forbidden, hidden},
+		{"api key", &reqctx.Principal{Subject: "apikey:k1"}, forbidden, hidden},
+		{"admin", &reqctx.Principal{Subject: "3", Roles: []string{auth.RoleAdmin}}, allowed, allowed},
+	}
+	routes := []struct {
+		method, path string
+		// hides reports whether the route answers 404 for others' resources
+		hides bool
+	}{
+		{"PUT", "/api/v1/users/1", false},
+		{"DELETE", "/api/v1/users/1", false},
+		{"PUT", "/api/v1/users/1/avatar", false},
+		{"DELETE", "/api/v1/users/1/avatar", false},
+		{"PUT", "/api/v1/users/1/password", false},
+		{"GET", "/api/v1/users/1/logins", false},
+		{"GET", "/api/v1/users/1/sessions", false},
+		{"DELETE", "/api/v1/users/1/sessions", false},
+		{"DELETE", "/api/v1/users/1/sessions/unknown", false},
+		{"GET", "/api/v1/uploads/upload-1", true},
+		{"POST", "/api/v1/uploads/upload-1/complete", true},
+		{"GET", "/api/v1/operations/op-1", true},
+		{"POST", "/api/v1/operations/op-1/cancel", true},
+		{"DELETE", "/api/v1/operations/op-1", true},
+	}
+
+	// Passwords are slow to hash, so every router shares them
+	passwords := auth.NewPasswords()
+	require.NoError(t, passwords.Set(1, "password1"))
+	// serve returns a router over fresh state in which everything is
+	// owned by owner
+	serve := func(t *testing.T) http.Handler {
+		realStore := store.NewMemoryUserStore()
+		_, _ = realStore.Create(store.User(fixtures.New(1).User().Build()))
+		service, err := auth.NewService(realStore, passwords, auth.Options{Secret: []byte("0123456789abcdef0123456789abcdef")})
+		require.NoError(t, err)
+		local, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+		require.NoError(t, err)
+		uploadManager := uploads.NewManager(local, uploads.Options{Purposes: map[string]int64{"avatar": 1 << 20}, IDs: idgen.NewSequence("upload")})
+		_, _, err = uploadManager.Create(context.Background(), owner.Subject, "avatar", "", 1)
+		require.NoError(t, err)
+		operationManager := operations.NewManager(operations.Options{IDs: idgen.NewSequence("op")})
+		_, err = operationManager.Submit(context.Background(), "user.create", owner.Subject, func(context.Context, operations.Progress) (any, error) { return nil, nil })
+		require.NoError(t, err)
+
+		userHandler := NewUserHandler(realStore)
+		userHandler.RequireOwnership()
+		userHandler.EnableAvatars(avatars.NewProcessor(local, avatars.Options{Sizes: map[string]int{"thumb": 16}}), uploadManager, time.Hour)
+		r := router.NewStdlib()
+		router.Mount(r, slices.Concat(userHandler.Routes(), NewAuthHandler(realStore, service).Routes(), NewUploadHandler(uploadManager, local).Routes(), NewOperationHandler(operationManager).Routes()))
+		return r
+	}
+
+	for _, route := range routes {
+		for _, role := range roles {
+			t.Run(route.method+" "+route.path+" as "+role.name, func(t *testing.T) {
+				req, _ := http.NewRequest(route.method, route.path, strings.NewReader("{}"))
+				if role.principal != nil {
+					req = req.WithContext(reqctx.WithPrincipal(req.Context(), *role.principal))
+				}
+				w := httptest.NewRecorder()
+				serve(t).ServeHTTP(w, req)
+
+				expected := role.expected
+				if route.hides {
+					expected = role.expectedHidden
+				}
+				switch expected {
+				case allowed:
+					// Routes may still fail past the check, such as for a
+					// missing session
+					refused := []int{http.StatusUnauthorized, http.StatusForbidden}
+					if route.hides {
+						refused = append(refused, http.StatusNotFound)
+					}
+					assert.NotContains(t, refused, w.Code, w.Body.String())
+				case unauthenticated:
+					assert.Equal(t, http.StatusUnauthorized, w.Code)
+				case forbidden:
+					assert.Equal(t, http.StatusForbidden, w.Code)
+				case hidden:
+					assert.Equal(t, http.StatusNotFound, w.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestViewHandler_SavedViews(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	now := time.Now().UTC()
+	users := fixtures.New(1)
+	for _, user := range []client.User{
+		users.User().WithID(1).WithName("Old").WithEmail("user1@example.com").WithCreatedAt(now.AddDate(0, -2, 0)).Build(),
+		users.User().WithID(2).WithName("Recent").WithEmail("user2@example.com").WithCreatedAt(now.AddDate(0, 0, -3)).Build(),
+		users.User().WithID(3).WithName("Edited").WithEmail("user3@example.com").WithCreatedAt(now.AddDate(0, -1, 0)).WithUpdatedAt(now.Add(-time.Hour)).Build(),
+	} {
+		_, err := realStore.Restore(store.User(user))
+		require.NoError(t, err)
+	}
+	viewStore := views.NewStore(views.Options{MaxPerOwner: 2})
+	userHandler := NewUserHandler(realStore)
+	userHandler.EnableViews(viewStore)
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	router.Mount(r, NewViewHandler(viewStore).Routes())
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	names := func(w *httptest.ResponseRecorder) []string {
+		var users []store.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+	owner := &reqctx.Principal{Subject: "1"}
+	other := &reqctx.Principal{Subject: "2"}
+
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/views", nil, ViewRequest{Name: "recent", Query: "updated_within=7d"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/views", owner, ViewRequest{Name: "Recent!", Query: "updated_within=7d"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/views", owner, ViewRequest{Name: "recent", Query: "updated_within=soon"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/views", owner, ViewRequest{Name: "recent", Query: "colour=red"}).Code)
+
+	w := do("POST", "/api/v1/views", owner, ViewRequest{Name: "recent", Query: "updated_within=7d"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = do("POST", "/api/v1/views", owner, ViewRequest{Name: "recent", Query: "updated_within=7d&created_before=" + now.AddDate(0, 0, -7).Format(time.DateOnly)})
+	require.Equal(t, http.StatusOK, w.Code, "saving again replaces the query")
+
+	// The view's parameters apply, and explicit ones take precedence
+	w = do("GET", "/api/v1/users?view=recent", owner, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.ElementsMatch(t, []string{"Edited"}, names(w))
+	w = do("GET", "/api/v1/users?view=recent&created_before="+now.Format(time.DateOnly), owner, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.ElementsMatch(t, []string{"Recent", "Edited"}, names(w))
+
+	// Views are per caller
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/users?view=recent", other, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/users?view=recent", nil, nil).Code)
+	w = do("GET", "/api/v1/views", other, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/views", owner, ViewRequest{Name: "all"}).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/api/v1/views", owner, ViewRequest{Name: "third"}).Code)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/views/recent", owner, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/views/recent", owner, nil).Code)
+}
+
+func TestUserHandler_ConditionalRequests(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, err := realStore.Create(store.User(fixtures.New(1).User().WithEmail("john@example.com").Build()))
+	require.NoError(t, err)
+	userHandler := NewUserHandler(realStore)
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	do := func(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	path := "/api/v1/users/" + strconv.FormatInt(user.ID, 10)
+
+	// Reads carry the version as their ETag, and are not sent again while
+	// it is current
+	w := do("GET", path, nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), `"version":1`)
+	w = do("GET", path, map[string]string{"If-None-Match": `"7", "1"`}, "")
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	list := do("GET", "/api/v1/users", nil, "")
+	require.Equal(t, http.StatusOK, list.Code)
+	listETag := list.Header().Get("ETag")
+	require.NotEmpty(t, listETag)
+	assert.Equal(t, http.StatusNotModified, do("GET", "/api/v1/users", map[string]string{"If-None-Match": listETag}, "").Code)
+
+	// Writes from the current version apply, and stale ones are refused
+	w = do("PUT", path, map[string]string{"If-Match": `"1"`}, `{"name":"Johnny","email":"john@example.com"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	w = do("PUT", path, map[string]string{"If-Match": `"1"`}, `{"name":"Stale","email":"john@example.com"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"PRECONDITION_FAILED"`)
+	assert.Equal(t, http.StatusPreconditionFailed, do("PUT", path, map[string]string{"If-Match": `W/"2"`}, `{"name":"Weak","email":"john@example.com"}`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, do("DELETE", path, map[string]string{"If-Match": `"1"`}, "").Code)
+	// "0" is a version like any other, which this user is long past
+	assert.Equal(t, http.StatusPreconditionFailed, do("PUT", path, map[string]string{"If-Match": `"0"`}, `{"name":"Stale","email":"john@example.com"}`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, do("DELETE", path, map[string]string{"If-Match": `"0"`}, "").Code)
+
+	// The list changed with the user
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/users", map[string]string{"If-None-Match": listETag}, "").Code)
+	retrieved, err := realStore.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Johnny", retrieved.Name)
+
+	// Once required, writes need If-Match
+	userHandler.RequirePreconditions()
+	w = do("PUT", path, nil, `{"name":"Blind","email":"john@example.com"}`)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"PRECONDITION_REQUIRED"`)
+	assert.Equal(t, http.StatusPreconditionRequired, do("DELETE", path, nil, "").Code)
+	assert.Equal(t, http.StatusOK, do("PUT", path, map[string]string{"If-Match": "*"}, `{"name":"John","email":"john@example.com"}`).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", path, map[string]string{"If-Match": `"3"`}, "").Code)
+
+	// Users stored before versions were tracked have the ETag "0" until
+	// they are next written
+	legacy, err := realStore.Replace(store.User(fixtures.New(1).User().WithID(5).WithCreatedAt(time.Now().UTC()).Build()))
+	require.NoError(t, err)
+	require.Zero(t, legacy.Version)
+	w = do("PUT", "/api/v1/users/5", map[string]string{"If-Match": `"0"`}, `{"name":"Annie","email":"ann@example.com"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusPreconditionFailed, do("DELETE", "/api/v1/users/5", map[string]string{"If-Match": `"0"`}, "").Code)
+}
+
+func TestUserHandler_ETagsFollowTheBody(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, err := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+	userHandler := NewUserHandler(realStore)
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	path := "/api/v1/users/" + strconv.FormatInt(user.ID, 10)
+	get := func(path string, ctx func(context.Context) context.Context) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(ctx(req.Context()))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Header().Get("ETag")
+	}
+	plain := func(ctx context.Context) context.Context { return ctx }
+
+	// A user who has not been seen has only their version as the tag
+	require.Equal(t, `"1"`, get(path, plain))
+	list := get("/api/v1/users", plain)
+
+	// Being seen changes the body but not the version
+	require.NoError(t, realStore.RecordActivity(map[int64]time.Time{user.ID: time.Now()}))
+	seen := get(path, plain)
+	assert.True(t, strings.HasPrefix(seen, `"1-`), seen)
+	assert.NotEqual(t, list, get("/api/v1/users", plain))
+
+	// So do the caller's time zone and the fields hidden from them
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	zoned := get(path, func(ctx context.Context) context.Context { return reqctx.WithTimezone(ctx, tokyo) })
+	assert.NotEqual(t, seen, zoned)
+	userHandler.RestrictFields(visibility.NewPolicy(map[string][]string{"email": {auth.RoleAdmin}}))
+	hidden := get(path, plain)
+	assert.NotEqual(t, seen, hidden)
+	assert.NotEqual(t, zoned, hidden)
+
+	// If-Match compares only the version
+	req := httptest.NewRequest("PUT", path, strings.NewReader(`{"name":"Johnny","email":"johnny@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", hidden)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUserHandler_ConditionalDelete_RecycleBin(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, err := realStore.Create(store.User(fixtures.New(1).User().WithEmail("john@example.com").Build()))
+	require.NoError(t, err)
+	bin := store.NewRecycleBin(realStore, time.Hour, nil)
+	userHandler := NewUserHandler(realStore)
+	userHandler.EnableUndelete(bin)
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	del := func(etag string) int {
+		req := httptest.NewRequest("DELETE", "/api/v1/users/"+strconv.FormatInt(user.ID, 10), nil)
+		req.Header.Set("If-Match", etag)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	_, err = realStore.Update(user.ID, store.User(fixtures.New(1).User().WithName("Johnny").WithEmail(user.Email).Build()))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, del(`"1"`))
+	assert.Empty(t, bin.Pending(), "refused deletes keep nothing")
+	assert.Equal(t, http.StatusNoContent, del(`"2"`))
+	require.Len(t, bin.Pending(), 1)
+	assert.Equal(t, "Johnny", bin.Pending()[0].User.Name)
+}
+
+func TestUserHandler_MaterializedViews(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, name := range []string{"Bob", "Ann"} {
+		_, err := realStore.Create(store.User(users.User().WithName(name).Build()))
+		require.NoError(t, err)
+	}
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	results := materialized.NewStore(materialized.Options{Revision: realStore.Revision, Clock: clk})
+	viewStore := views.NewStore(views.Options{})
+	userHandler := NewUserHandler(realStore)
+	userHandler.EnableViews(viewStore)
+	userHandler.EnableMaterializedViews(results)
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	router.Mount(r, NewViewHandler(viewStore).Routes())
+	owner := &reqctx.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), *owner))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	names := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, w.Code)
+		var users []store.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	w := do("POST", "/api/v1/views", ViewRequest{Name: "sorted", Query: "sort=name", Materialized: true})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"materialized":true`)
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/views", ViewRequest{Name: "live", Query: "sort=name"}).Code)
+
+	w = do("GET", "/api/v1/users?view=sorted", nil)
+	assert.Equal(t, []string{"Ann", "Bob"}, names(w))
+	assert.Equal(t, "2024-01-01T09:00:00Z", w.Header().Get(materialized.AsOfHeader))
+
+	// Writes bypassing the handler are only seen once the results refresh,
+	// while views that are not materialized see them at once
+	_, err := realStore.Create(store.User(users.User().WithName("Cat").Build()))
+	require.NoError(t, err)
+	clk.Advance(time.Minute)
+	assert.Equal(t, []string{"Ann", "Bob"}, names(do("GET", "/api/v1/users?view=sorted", nil)))
+	w = do("GET", "/api/v1/users?view=live", nil)
+	assert.Equal(t, []string{"Ann", "Bob", "Cat"}, names(w))
+	assert.Empty(t, w.Header().Get(materialized.AsOfHeader))
+
+	assert.Equal(t, 1, results.Refresh(context.Background()))
+	w = do("GET", "/api/v1/users?view=sorted", nil)
+	assert.Equal(t, []string{"Ann", "Bob", "Cat"}, names(w))
+	assert.Equal(t, "2024-01-01T09:01:00Z", w.Header().Get(materialized.AsOfHeader))
+
+	// Parameters given alongside the view are materialized separately
+	assert.Equal(t, []string{"Cat", "Bob", "Ann"}, names(do("GET", "/api/v1/users?view=sorted&sort=-name", nil)))
+
+	// Writes through the handler invalidate every result
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/users/3", nil).Code)
+	assert.Equal(t, 2, results.Refresh(context.Background()))
+	assert.Equal(t, []string{"Ann", "Bob"}, names(do("GET", "/api/v1/users?view=sorted", nil)))
+}
+
+func TestUserHandler_RestrictFields(t *testing.T) {
+	realStore, err := store.NewChangeCapturingUserStore(store.NewMemoryUserStore(), store.ChangeLogOptions{})
+	require.NoError(t, err)
+	users := fixtures.New(1)
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		_, err := realStore.Create(store.User(users.User().WithEmail(email).Build()))
+		require.NoError(t, err)
+	}
+	policy := visibility.NewPolicy(map[string][]string{"email": {auth.RoleAdmin, visibility.RoleSelf}})
+	userHandler := NewUserHandler(realStore)
+	userHandler.RestrictFields(policy)
+	changeHandler := NewChangeHandler(realStore)
+	changeHandler.RestrictFields(policy)
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	router.Mount(r, changeHandler.Routes())
+	emails := func(t *testing.T, path string, principal *reqctx.Principal) []string {
+		req, _ := http.NewRequest("GET", path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var records []map[string]any
+		switch {
+		case path == "/api/v1/cdc":
+			var page struct {
+				Changes []struct {
+					User map[string]any `json:"user"`
+				} `json:"changes"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			for _, change := range page.Changes {
+				records = append(records, change.User)
+			}
+		case path == "/api/v1/users":
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+		default:
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &record))
+			records = append(records, record)
+		}
+		found := []string{}
+		for _, record := range records {
+			require.Contains(t, record, "name")
+			if email, ok := record["email"].(string); ok {
+				found = append(found, email)
+			}
+		}
+		return found
+	}
+
+	tests := []struct {
+		name      string
+		principal *reqctx.Principal
+		expected  []string
+	}{
+		{name: "anonymous", expected: []string{}},
+		{name: "self", principal: &reqctx.Principal{Subject: "1"}, expected: []string{"alice@example.com"}},
+		{name: "other", principal: &reqctx.Principal{Subject: "3"}, expected: []string{}},
+		{name: "admin", principal: &reqctx.Principal{Subject: "3", Roles: []string{auth.RoleAdmin}}, expected: []string{"alice@example.com", "bob@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ElementsMatch(t, tt.expected, emails(t, "/api/v1/users", tt.principal))
+			assert.ElementsMatch(t, tt.expected, emails(t, "/api/v1/cdc", tt.principal))
+			alice := emails(t, "/api/v1/users/1", tt.principal)
+			assert.Equal(t, slices.Contains(tt.expected, "alice@example.com"), len(alice) == 1)
+		})
+	}
+}
+
+func TestUserHandler_CreateUserAsync(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	userHandler := NewUserHandler(realStore)
+	adminHandler := NewAdminHandler(realStore)
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	router.Mount(r, adminHandler.Routes())
+	do := func(method, path string, principal *reqctx.Principal, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	alice := &reqctx.Principal{Subject: "alice"}
+	admin := &reqctx.Principal{Subject: "bob", Roles: []string{auth.RoleAdmin}}
+
+	w := do("POST", "/api/v1/users?async=true", alice, `{"name":"Async","email":"async@example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Async processing is not enabled")
+
+	manager := operations.NewManager(operations.Options{IDs: idgen.NewSequence("op")})
+	userHandler.EnableAsync(manager)
+	adminHandler.EnableAsync(manager)
+	router.Mount(r, NewOperationHandler(manager).Routes())
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/users?async=maybe", alice, `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/users?async=true", alice, `{`).Code)
+
+	// Accepted requests are only processed once the manager runs
+	w = do("POST", "/api/v1/users?async=true", alice, `{"name":"Async","email":"async@example.com"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/api/v1/operations/op-1", w.Header().Get("Location"))
+	var op operations.Operation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &op))
+	assert.Equal(t, "operations/op-1", op.Name)
+	assert.False(t, op.Done)
+	count, _ := realStore.Count()
+	assert.Zero(t, count)
+
+	// A second one is cancelled before it runs, then deleted
+	require.Equal(t, http.StatusAccepted, do("POST", "/api/v1/users?async=true", alice, `{"name":"Cancelled","email":"cancelled@example.com"}`).Code)
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/api/v1/operations/op-2", alice, "").Code)
+	w = do("POST", "/api/v1/operations/op-2/cancel", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &op))
+	assert.Equal(t, operations.StateCancelled, op.Metadata.State)
+	assert.Equal(t, http.StatusConflict, do("POST", "/api/v1/operations/op-2/cancel", alice, "").Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/operations/op-2", alice, "").Code)
+
+	// Repairs run as operations too
+	require.Equal(t, http.StatusAccepted, do("POST", "/admin/integrity/repair?async=true", admin, "").Code)
+
+	manager.Start()
+	require.NoError(t, manager.Stop(context.Background()))
+
+	w = do("GET", "/api/v1/operations/op-1", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var result struct {
+		Done     bool       `json:"done"`
+		Response store.User `json:"response"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.True(t, result.Done)
+	assert.Equal(t, "async@example.com", result.Response.Email)
+	created, err := realStore.GetByID(result.Response.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Async", created.Name)
+	count, _ = realStore.Count()
+	assert.Equal(t, 1, count)
+
+	w = do("GET", "/api/v1/operations/op-3", admin, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"kind":"integrity.repair"`)
+
+	// Operations are private to the caller that submitted them, and admins
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/operations/op-1", &reqctx.Principal{Subject: "carol"}, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/operations/op-1/cancel", &reqctx.Principal{Subject: "carol"}, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/operations/op-1", nil, "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/operations/op-1", admin, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/operations/op-2", alice, "").Code)
+
+	var listed []operations.Operation
+	w = do("GET", "/api/v1/operations", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/operations", nil, "").Code)
+
+	// Once stopped, the manager turns requests away
+	assert.Equal(t, http.StatusServiceUnavailable, do("POST", "/api/v1/users?async=true", alice, `{"name":"Late","email":"late@example.com"}`).Code)
+}
+
+func TestSCIMHandler_ProvisioningWorkflow(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	_, _ = realStore.Create(store.User(users.User().Build()))
+	listener := &recordingListener{}
+	userHandler := NewUserHandler(realStore, listener)
+	userHandler.EnableUndelete(store.NewRecycleBin(realStore, time.Hour, nil))
+
+	r := router.NewStdlib()
+	router.Mount(r, NewSCIMHandler(userHandler, "secret", 2).Routes())
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		assert.Equal(t, scim.ContentType, w.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+
+	// Providers authenticate with the bearer token
+	w := do("GET", "/scim/v2/Users", "", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	var scimErr scim.Error
+	decode(t, w, &scimErr)
+	assert.Equal(t, []string{scim.SchemaError}, scimErr.Schemas)
+	assert.Equal(t, "401", scimErr.Status)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/scim/v2/Users", "wrong", "").Code)
+
+	w = do("POST", "/scim/v2/Users", "secret", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "jane@example.com",
+		"name": {"givenName": "Jane", "familyName": "Roe"},
+		"emails": [{"value": "jane@example.com", "type": "work", "primary": true}],
+		"active": true
+	}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var jane scim.User
+	decode(t, w, &jane)
+	assert.Equal(t, "/scim/v2/Users/2", w.Header().Get("Location"))
+	assert.Equal(t, "2", jane.ID)
+	assert.Equal(t, "Jane Roe", jane.DisplayName)
+	assert.Equal(t, "User", jane.Meta.ResourceType)
+	assert.Equal(t, []string{"created Jane Roe"}, listener.events)
+
+	// Emails are unique, and users without one are rejected
+	w = do("POST", "/scim/v2/Users", "secret", `{"userName": "JANE@example.com"}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	decode(t, w, &scimErr)
+	assert.Equal(t, scim.ErrUniqueness, scimErr.ScimType)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/scim/v2/Users", "secret", `{"userName": "jane"}`).Code)
+
+	// Lists are filtered and paged
+	var list scim.ListResponse
+	w = do("GET", `/scim/v2/Users?filter=userName+eq+"Jane@Example.com"`, "secret", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	decode(t, w, &list)
+	assert.Equal(t, 1, list.TotalResults)
+	assert.Equal(t, "2", list.Resources[0].ID)
+
+	_, _ = realStore.Create(store.User(users.User().Build()))
+	w = do("GET", "/scim/v2/Users?startIndex=2&count=5", "secret", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	decode(t, w, &list)
+	assert.Equal(t, 3, list.TotalResults)
+	assert.Equal(t, 2, list.StartIndex)
+	assert.Equal(t, 2, list.ItemsPerPage, "count is capped at the maximum")
+	assert.Equal(t, []string{scim.SchemaListResponse}, list.Schemas)
+
+	w = do("GET", `/scim/v2/Users?filter=nickName+eq+"x"`, "secret", "")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	decode(t, w, &scimErr)
+	assert.Equal(t, scim.ErrInvalidFilter, scimErr.ScimType)
+
+	// PUT and PATCH update the user
+	w = do("PUT", "/scim/v2/Users/2", "secret", `{"userName": "jane.roe@example.com", "displayName": "Jane R"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(t, w, &jane)
+	assert.Equal(t, "jane.roe@example.com", jane.UserName)
+	assert.Equal(t, "Jane R", jane.DisplayName)
+
+	w = do("PATCH", "/scim/v2/Users/2", "secret", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "name.formatted", "value": "Jane Q. Roe"},
+			{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "jq@example.com"}
+		]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user, err := realStore.GetByID(2)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Q. Roe", user.Name)
+	assert.Equal(t, "jq@example.com", user.Email)
+	assert.Equal(t, []string{"created Jane Roe", "updated Jane Roe to Jane R", "updated Jane R to Jane Q. Roe"}, listener.events)
+
+	w = do("PATCH", "/scim/v2/Users/2", "secret", `{"Operations": [{"op": "replace", "path": "nickName", "value": "JQ"}]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	decode(t, w, &scimErr)
+	assert.Equal(t, scim.ErrInvalidPath, scimErr.ScimType)
+
+	// Deactivating deletes the user, which stays restorable
+	w = do("PATCH", "/scim/v2/Users/2", "secret", `{"Operations": [{"op": "replace", "value": {"active": "False"}}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decode(t, w, &jane)
+	assert.False(t, jane.IsActive())
+	assert.Equal(t, http.StatusNotFound, do("GET", "/scim/v2/Users/2", "secret", "").Code)
+	_, err = userHandler.recycleBin.Undelete(2)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/scim/v2/Users/3", "secret", "").Code)
+	w = do("DELETE", "/scim/v2/Users/3", "secret", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	decode(t, w, &scimErr)
+	assert.Equal(t, "404", scimErr.Status)
+}
+
+func TestSCIMHandler_Preconditions(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, err := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+	userHandler := NewUserHandler(realStore)
+	r := router.NewStdlib()
+	router.Mount(r, NewSCIMHandler(userHandler, "secret", 10).Routes())
+	do := func(method, etag, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/scim/v2/Users/"+strconv.FormatInt(user.ID, 10), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	rename := `{"Operations": [{"op": "replace", "path": "displayName", "value": "Johnny"}]}`
+
+	// Resources carry their version, which If-Match sends back
+	w := do("GET", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	var resource scim.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resource))
+	assert.Equal(t, `"1"`, resource.Meta.Version)
+
+	w = do("PATCH", `"1"`, rename)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		w = do(method, `"1"`, rename)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, method)
+		assert.Contains(t, w.Body.String(), `"status":"412"`, method)
+	}
+
+	// Once required, writes need If-Match
+	userHandler.RequirePreconditions()
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		assert.Equal(t, http.StatusPreconditionRequired, do(method, "", rename).Code, method)
+	}
+	assert.Equal(t, http.StatusNoContent, do("DELETE", `"2"`, "").Code)
+}
+
+func TestUploadHandler_LocalUploadWorkflow(t *testing.T) {
+	local, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+	require.NoError(t, err)
+	manager := uploads.NewManager(local, uploads.Options{Purposes: map[string]int64{"avatar": 10}})
+	h := NewUploadHandler(manager, local)
+
+	r := router.NewStdlib()
+	router.Mount(r, h.Routes())
+	router.Mount(r, h.ContentRoutes())
+	do := func(method, path string, principal *reqctx.Principal, header http.Header, body io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, body)
+		maps.Copy(req.Header, header)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(principal *reqctx.Principal, req CreateUploadRequest) *httptest.ResponseRecorder {
+		encoded, _ := json.Marshal(req)
+		return do("POST", "/api/v1/uploads", principal, nil, bytes.NewReader(encoded))
+	}
+	owner := &reqctx.Principal{Subject: "1"}
+	other := &reqctx.Principal{Subject: "2"}
+	admin := &reqctx.Principal{Subject: "3", Roles: []string{auth.RoleAdmin}}
+
+	assert.Equal(t, http.StatusUnauthorized, create(nil, CreateUploadRequest{Purpose: "avatar", Size: 5}).Code)
+	assert.Equal(t, http.StatusBadRequest, create(owner, CreateUploadRequest{Purpose: "avatar"}).Code)
+	assert.Equal(t, http.StatusBadRequest, create(owner, CreateUploadRequest{Purpose: "import", Size: 5}).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, create(owner, CreateUploadRequest{Purpose: "avatar", Size: 11}).Code)
+
+	w := create(owner, CreateUploadRequest{Purpose: "avatar", ContentType: "image/png", Size: 5})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreateUploadResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, uploads.StatePending, created.Upload.State)
+	assert.Equal(t, "PUT", created.Target.Method)
+	assert.True(t, strings.HasPrefix(created.Target.URL, uploads.LocalContentPath), created.Target.URL)
+	uploadPath := "/api/v1/uploads/" + created.Upload.ID
+
+	// Others cannot see or complete the upload
+	assert.Equal(t, http.StatusNotFound, do("GET", uploadPath, other, nil, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", uploadPath+"/complete", other, nil, nil).Code)
+	assert.Equal(t, http.StatusOK, do("GET", uploadPath, admin, nil, nil).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", uploadPath+"/complete", owner, nil, nil).Code)
+
+	header := http.Header{"Content-Type": {"image/png"}}
+	assert.Equal(t, http.StatusForbidden, do("PUT", uploads.LocalContentPath+"wrong", nil, header, strings.NewReader("hello")).Code)
+	assert.Equal(t, http.StatusNoContent, do("PUT", created.Target.URL, nil, header, strings.NewReader("hello")).Code)
+	assert.Equal(t, http.StatusForbidden, do("PUT", created.Target.URL, nil, header, strings.NewReader("hello")).Code, "upload URLs are single use")
+
+	w = do("POST", uploadPath+"/complete", owner, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var completed uploads.Upload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &completed))
+	assert.Equal(t, uploads.StateCompleted, completed.State)
+
+	w = do("GET", "/api/v1/uploads", owner, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []uploads.Upload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, created.Upload.ID, list[0].ID)
+
+	// A file larger than declared is rejected
+	w = create(owner, CreateUploadRequest{Purpose: "avatar", Size: 2})
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do("PUT", created.Target.URL, nil, nil, strings.NewReader("hello")).Code)
+}
+
+func TestUserHandler_Avatar(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	user, _ := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	local, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+	require.NoError(t, err)
+	manager := uploads.NewManager(local, uploads.Options{Purposes: map[string]int64{"avatar": 1 << 20, "import": 1 << 20}})
+	userHandler := NewUserHandler(realStore)
+	userHandler.EnableAvatars(avatars.NewProcessor(local, avatars.Options{Sizes: map[string]int{"thumb": 16, "medium": 48}, MinDimension: 8}), manager, time.Hour)
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	do := func(method, path string, principal *reqctx.Principal, header http.Header, body io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, body)
+		maps.Copy(req.Header, header)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	owner := &reqctx.Principal{Subject: fmt.Sprint(user.ID)}
+	other := &reqctx.Principal{Subject: "99"}
+	admin := &reqctx.Principal{Subject: "98", Roles: []string{auth.RoleAdmin}}
+	ctx := context.Background()
+	// upload stores data as a completed upload of owner
+	upload := func(owner, purpose string, data []byte) string {
+		created, _, err := manager.Create(ctx, owner, purpose, "", int64(len(data)))
+		require.NoError(t, err)
+		require.NoError(t, local.Put(ctx, created.Key, "", bytes.NewReader(data), int64(len(data))))
+		_, err = manager.Complete(ctx, created.ID)
+		require.NoError(t, err)
+		return created.ID
+	}
+	set := func(principal *reqctx.Principal, uploadID string) *httptest.ResponseRecorder {
+		encoded, _ := json.Marshal(SetAvatarRequest{UploadID: uploadID})
+		return do("PUT", fmt.Sprintf("/api/v1/users/%d/avatar", user.ID), principal, nil, bytes.NewReader(encoded))
+	}
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 100, 50))))
+	avatarPath := fmt.Sprintf("/api/v1/users/%d/avatar", user.ID)
+
+	assert.Equal(t, http.StatusNotFound, do("GET", avatarPath, nil, nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, set(nil, "x").Code)
+	assert.Equal(t, http.StatusForbidden, set(other, upload("99", "avatar", img.Bytes())).Code)
+	assert.Equal(t, http.StatusNotFound, set(owner, "missing").Code)
+	assert.Equal(t, http.StatusNotFound, set(owner, upload("99", "avatar", img.Bytes())).Code, "others' uploads cannot be used")
+	assert.Equal(t, http.StatusBadRequest, set(owner, upload(owner.Subject, "import", img.Bytes())).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, set(owner, upload(owner.Subject, "avatar", []byte("not an image"))).Code)
+
+	w := set(owner, upload(owner.Subject, "avatar", img.Bytes()))
+	require.Equal(t, http.StatusOK, w.Code)
+	var avatar avatars.Avatar
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &avatar))
+	require.Len(t, avatar.Variants, 2)
+	assert.Equal(t, 48, avatar.Variants[1].Width)
+
+	// The largest size is served by default, with caching headers
+	w = do("GET", avatarPath, nil, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, avatars.ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+	assert.Equal(t, avatar.Variants[1].Bytes, w.Body.Len())
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, http.StatusNotModified, do("GET", avatarPath, nil, http.Header{"If-None-Match": {etag}}, nil).Code)
+
+	w = do("GET", avatarPath+"?size=thumb", nil, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, avatar.Variants[0].Bytes, w.Body.Len())
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusBadRequest, do("GET", avatarPath+"?size=huge", nil, nil, nil).Code)
+
+	assert.Equal(t, http.StatusForbidden, do("DELETE", avatarPath, other, nil, nil).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", avatarPath, admin, nil, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", avatarPath, nil, nil, nil).Code)
+}
+
+func TestUserHandler_ExportUsers(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		_, err := realStore.Create(store.User(users.User().WithName("User, " + email[:3]).WithEmail(email).Build()))
+		require.NoError(t, err)
+	}
+	userHandler := NewUserHandler(realStore)
+	userHandler.RestrictFields(visibility.NewPolicy(map[string][]string{
+		"email":      {auth.RoleAdmin, visibility.RoleSelf},
+		"created_at": {auth.RoleAdmin},
+	}))
+
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+	export := func(path string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+
+	w := export("/api/v1/users/export", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "id,name,email,last_seen_at,created_at,updated_at", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], `1,"User, ali",alice@example.com,,20`), lines[1])
+
+	// Fields hidden even on the caller's own record are left out, and
+	// others are emptied where the caller may not see them
+	w = export("/api/v1/users/export", &reqctx.Principal{Subject: "2"})
+	require.Equal(t, http.StatusOK, w.Code)
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "id,name,email,last_seen_at,updated_at", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], `1,"User, ali",,,20`), lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], `2,"User, bob",bob@example.com,,20`), lines[2])
+
+	w = export("/api/v1/users/export?created_after="+time.Now().Add(time.Hour).Format(time.RFC3339), admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "id,name,email,last_seen_at,created_at,updated_at\n", w.Body.String())
+
+	w = export("/api/v1/users/export?filter=email=like=bob*", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[1], `2,"User, bob",bob@example.com,,20`), lines[1])
+	assert.Equal(t, http.StatusBadRequest, export("/api/v1/users/export?filter=email=lt=bob", admin).Code)
+
+	w = export("/api/v1/users/export?format=xlsx", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".xlsx")
+	workbook, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	sheet, err := workbook.Open("xl/worksheets/sheet1.xml")
+	require.NoError(t, err)
+	data, err := io.ReadAll(sheet)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "bob@example.com")
+
+	assert.Equal(t, http.StatusBadRequest, export("/api/v1/users/export?format=pdf", admin).Code)
+
+	// Anonymized exports fake names and emails, keeping IDs
+	// Literal: only the ID survives anonymization
+	fake := anonymize.New("staging.test").User(store.User{ID: 1})
+	userHandler.SetAnonymizer(anonymize.New("staging.test"))
+	w = export("/api/v1/users/export?anonymized=true", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "alice")
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "1,"+fake.Name+","+fake.Email+",,20"), lines[1])
+}
+
+func TestUserHandler_ExportUsersWithoutSnapshots(t *testing.T) {
+	// Capturing changes over a store without snapshots, as over MongoDB,
+	// still exports, reading the users directly
+	inner := store.NewTimedUserStore(store.NewMemoryUserStore(), func(string) func() { return func() {} })
+	realStore, err := store.NewChangeCapturingUserStore(inner, store.ChangeLogOptions{})
+	require.NoError(t, err)
+	_, err = store.Snapshot(realStore)
+	require.ErrorIs(t, err, store.ErrSnapshotsUnsupported)
+	_, err = realStore.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+
+	r := router.NewStdlib()
+	router.Mount(r, NewUserHandler(realStore).Routes())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/users/export", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 2)
+}
+
+func TestUserHandler_UnicodeUsers(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	router := setupTestRouter(realStore)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The name is sent decomposed, with combining accents, and stored
+	// precomposed
+	w := do("POST", "/api/v1/users", `{"name":"Jo\u0308rg Mu\u0308ller","email":"jörg@bücher.de"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created store.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "Jörg Müller", created.Name)
+	assert.Equal(t, "jörg@bücher.de", created.Email)
+
+	// The email is found in either form of its domain
+	found, err := realStore.GetByEmail("JÖRG@xn--bcher-kva.de")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+
+	for _, query := range []string{"name=m%C3%BCller", "name=mu%CC%88ller", "email=B%C3%9CCHER.DE"} {
+		w = do("GET", "/api/v1/users?"+query, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var users []store.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+		assert.Len(t, users, 1, query)
+	}
+
+	w = do("GET", "/api/v1/users/export?bom=true", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "\ufeffid,name,"), "CSV starts with a byte order mark")
+	assert.Contains(t, w.Body.String(), "Jörg Müller,jörg@bücher.de")
+	w = do("GET", "/api/v1/users/export", "")
+	assert.True(t, strings.HasPrefix(w.Body.String(), "id,name,"), "no byte order mark by default")
+
+	for body, valid := range map[string]bool{
+		`{"name":"Jörg","email":"jörg@bücher.de"}`:        true,
+		`{"name":"Jörg","email":"jörg@xn--bcher-kva.de"}`: true,
+		`{"name":"Jörg","email":"jörg@-bücher.de"}`:       false,
+	} {
+		w = do("POST", "/api/v1/users/validate", body)
+		require.Equal(t, http.StatusOK, w.Code)
+		var validation UserValidation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &validation))
+		assert.Equal(t, valid, validation.Valid, body)
+	}
+}
+
+func TestAdminHandler_Anonymize(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	created, err := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+	adminHandler := NewAdminHandler(realStore)
+	r := router.NewStdlib()
+	router.Mount(r, adminHandler.Routes())
+	do := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, do("/admin/anonymize").Code, "served only when enabled")
+
+	adminHandler.EnableAnonymization(realStore, anonymize.New(""))
+	r = router.NewStdlib()
+	router.Mount(r, adminHandler.Routes())
+	w := do("/admin/anonymize")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"users":1}`, w.Body.String())
+
+	got, err := realStore.GetByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, anonymize.New("").User(*created), *got)
+	assert.Equal(t, http.StatusBadRequest, do("/admin/anonymize?async=true").Code)
+}
+
+func TestAdminHandler_Backup(t *testing.T) {
+	source := store.NewMemoryUserStore()
+	created, err := source.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+	keys := apikeys.NewStore(apikeys.Options{})
+	_, secret, err := keys.Create(apikeys.Spec{Name: "ci-deployer"})
+	require.NoError(t, err)
+	adminHandler := NewAdminHandler(source)
+	adminHandler.EnableBackup(backup.New(backup.Options{Users: source, APIKeys: keys}))
+	r := router.NewStdlib()
+	router.Mount(r, adminHandler.Routes())
+	admin := reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+	do := func(r http.Handler, method, path, body string, principal reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), principal))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, do(r, "GET", "/admin/backup", "", reqctx.Principal{Subject: "1"}).Code)
+	w := do(r, "GET", "/admin/backup", "", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "backup-")
+	archive := w.Body.String()
+
+	// The archive clones the environment into one without API keys
+	target := store.NewMemoryUserStore()
+	targetHandler := NewAdminHandler(target)
+	targetHandler.EnableBackup(backup.New(backup.Options{Users: target}))
+	tr := router.NewStdlib()
+	router.Mount(tr, targetHandler.Routes())
+	w = do(tr, "POST", "/admin/restore", archive, admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"version":1,"restored":{"users":1},"skipped":["api_keys"]}`, w.Body.String())
+	got, err := target.GetByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.Email, got.Email)
+	assert.NotContains(t, archive, secret)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, do(tr, "POST", "/admin/restore", `{"version":99}`, admin).Code)
+	assert.Equal(t, http.StatusBadRequest, do(tr, "POST", "/admin/restore", `{"users":[]}`, admin).Code)
+}
+
+func TestAdminHandler_MergeUsers(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, user := range []client.User{
+		users.User().WithName("John Smith").WithEmail("john@example.com").Build(),
+		users.User().WithName("Jon Smith").WithEmail("john.smith@example.com").Build(),
+		users.User().WithName("Jane Doe").WithEmail("jane@example.com").Build(),
+	} {
+		_, err := realStore.Create(store.User(user))
+		require.NoError(t, err)
+	}
+	teams := orgs.NewStore(orgs.Options{IDs: idgen.NewSequence("org")})
+	team, err := teams.Create(orgs.Spec{Name: "Platform"})
+	require.NoError(t, err)
+	_, _, err = teams.SetMember(team.ID, 2, []string{"admin"})
+	require.NoError(t, err)
+	merger := merge.New(realStore)
+	merger.Remap("memberships", teams)
+	adminHandler := NewAdminHandler(realStore)
+	adminHandler.EnableMerge(realStore, merger)
+	r := router.NewStdlib()
+	router.Mount(r, adminHandler.Routes())
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path, body string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/admin/users/duplicates", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/admin/users/merge", `{"survivor":1,"duplicates":[2]}`, &reqctx.Principal{Subject: "1"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/admin/users/duplicates?threshold=0.1", "", admin).Code)
+
+	w := do("GET", "/admin/users/duplicates", "", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var candidates []merge.Candidate
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &candidates))
+	require.Len(t, candidates, 1)
+	assert.Equal(t, int64(1), candidates[0].Users[0].ID)
+	assert.Equal(t, int64(2), candidates[0].Users[1].ID)
+	assert.Equal(t, []string{merge.ReasonSimilarName}, candidates[0].Reasons)
+	assert.JSONEq(t, `[]`, do("GET", "/admin/users/duplicates?threshold=1", "", admin).Body.String())
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "not JSON", body: `{`, want: http.StatusBadRequest},
+		{name: "survivor as duplicate", body: `{"survivor":1,"duplicates":[1]}`, want: http.StatusBadRequest},
+		{name: "missing duplicate", body: `{"survivor":1,"duplicates":[9]}`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, do("POST", "/admin/users/merge", tt.body, admin).Code)
+		})
+	}
+
+	w = do("POST", "/admin/users/merge", `{"survivor":1,"duplicates":[2]}`, admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var report merge.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "john@example.com", report.Survivor.Email)
+	assert.Equal(t, []int64{2}, report.Merged)
+	assert.Equal(t, map[string]int{"memberships": 1}, report.Remapped)
+	assert.True(t, teams.IsMember(team.ID, 1))
+	_, err = realStore.GetByID(2)
+	assert.Error(t, err)
+	assert.JSONEq(t, `[]`, do("GET", "/admin/users/duplicates", "", admin).Body.String())
+}
+
+func TestAdminHandler_MergePurges(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, user := range []client.User{
+		users.User().WithName("John Doe").WithEmail("john@example.com").Build(),
+		users.User().WithName("Jon Doe").WithEmail("jon@example.com").Build(),
+	} {
+		_, err := realStore.Create(store.User(user))
+		require.NoError(t, err)
+	}
+	// The duplicate is older, so the survivor takes its creation time
+	older, err := realStore.GetByID(1)
+	require.NoError(t, err)
+	older.CreatedAt = time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	_, err = realStore.Replace(*older)
+	require.NoError(t, err)
+
+	var purged []string
+	userHandler := NewUserHandler(realStore)
+	userHandler.EnableSurrogateKeys(surrogate.PurgerFunc(func(_ context.Context, keys ...string) error {
+		purged = append(purged, keys...)
+		return nil
+	}))
+	bin := store.NewRecycleBin(realStore, time.Hour, nil)
+	userHandler.EnableUndelete(bin)
+	merger := merge.New(realStore)
+	merger.Hook(userHandler.MergeHooks())
+	adminHandler := NewAdminHandler(realStore)
+	adminHandler.EnableMerge(realStore, merger)
+	r := router.NewStdlib()
+	router.Mount(r, adminHandler.Routes())
+
+	req := httptest.NewRequest("POST", "/admin/users/merge", strings.NewReader(`{"survivor":2,"duplicates":[1]}`))
+	req = req.WithContext(reqctx.WithPrincipal(req.Context(), reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Contains(t, purged, "user-1")
+	assert.Contains(t, purged, "user-2")
+	assert.Contains(t, purged, "users-collection")
+	// The duplicate can be restored like any deleted user
+	require.Len(t, bin.Pending(), 1)
+	assert.Equal(t, int64(1), bin.Pending()[0].User.ID)
+}
+
+func TestAdminHandler_UsersReport(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	for _, user := range fixtures.New(1).Users(3) {
+		_, err := realStore.Create(store.User(user))
+		require.NoError(t, err)
+	}
+	local, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+	require.NoError(t, err)
+	adminHandler := NewAdminHandler(realStore)
+	adminHandler.EnableReports(realStore, reports.NewManager(local, reports.Options{IDs: idgen.NewSequence("report")}), 2)
+
+	r := router.NewStdlib()
+	router.Mount(r, adminHandler.Routes())
+	do := func(path string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+
+	assert.Equal(t, http.StatusUnauthorized, do("/admin/reports/users.pdf", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("/admin/reports/users.pdf", &reqctx.Principal{Subject: "1"}).Code)
+
+	// Without operations, even reports over the sync limit are rendered in
+	// the request
+	w := do("/admin/reports/users.pdf", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, reports.ContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".pdf")
+	assert.True(t, strings.HasPrefix(w.Body.String(), "%PDF-"))
+	assert.Equal(t, http.StatusBadRequest, do("/admin/reports/users.pdf?async=true", admin).Code)
+
+	manager := operations.NewManager(operations.Options{IDs: idgen.NewSequence("op")})
+	adminHandler.EnableAsync(manager)
+	router.Mount(r, NewOperationHandler(manager).Routes())
+	assert.Equal(t, http.StatusOK, do("/admin/reports/users.pdf?async=false", admin).Code)
+
+	w = do("/admin/reports/users.pdf", admin)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/api/v1/operations/op-1", w.Header().Get("Location"))
+	manager.Start()
+	require.NoError(t, manager.Stop(context.Background()))
+
+	w = do("/api/v1/operations/op-1", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var result struct {
+		Done     bool           `json:"done"`
+		Response reports.Report `json:"response"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.True(t, result.Done)
+	assert.Equal(t, reports.DownloadPath+"report-1", result.Response.URL)
+
+	assert.Equal(t, http.StatusForbidden, do(result.Response.URL, &reqctx.Principal{Subject: "1"}).Code)
+	w = do(result.Response.URL, admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, reports.ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, int(result.Response.Size), w.Body.Len())
+	assert.True(t, strings.HasPrefix(w.Body.String(), "%PDF-"))
+	assert.Equal(t, http.StatusNotFound, do(reports.DownloadPath+"missing", admin).Code)
+}
+
+func TestReplicationHandler(t *testing.T) {
+	jane := store.User(fixtures.New(1).User().WithID(2).WithName("Jane Smith").Build())
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []replication.Mutation{{
+			UserID:  2,
+			User:    &jane,
+			Version: replication.Version{Vector: replication.Vector{"b": 1}, Node: "b"},
+		}})
+	}))
+	defer peer.Close()
+	replicator, err := replication.New(store.NewMemoryUserStore(), replication.Options{
+		Node:   "a",
+		Nodes:  []replication.Node{{Name: "a"}, {Name: "b", URL: peer.URL}},
+		Secret: "secret",
+	})
+	require.NoError(t, err)
+
+	r := router.NewStdlib()
+	router.Mount(r, NewReplicationHandler(replicator).Routes())
+	admin := reqctx.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/admin/replication", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/admin/replication/reconcile", &reqctx.Principal{Subject: "2"}).Code)
+
+	w := do("POST", "/admin/replication/reconcile", &admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var results []replication.ReconcileResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Equal(t, []replication.ReconcileResult{{Node: "b", Received: 1, Applied: 1}}, results)
+	user, err := replicator.GetByID(2)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Smith", user.Name)
+
+	w = do("GET", "/admin/replication", &admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	var status replication.Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "a", status.Node)
+	assert.Equal(t, 1, status.Users)
+	assert.Equal(t, uint64(1), status.Applied)
+	require.Len(t, status.Peers, 1)
+	assert.Equal(t, "b", status.Peers[0].Node)
+}
+
+func TestInstanceHandler(t *testing.T) {
+	registry := instances.NewMemory(nil)
+	require.NoError(t, registry.Put(context.Background(), instances.Instance{ID: "api-1", Version: "v1", ConfigFingerprint: "f1", Ready: true}, time.Minute))
+	require.NoError(t, registry.Put(context.Background(), instances.Instance{ID: "api-2", Version: "v1", ConfigFingerprint: "f2"}, time.Minute))
+
+	r := router.NewStdlib()
+	router.Mount(r, NewInstanceHandler(registry).Routes())
+	do := func(principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/admin/instances", http.NoBody)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(&reqctx.Principal{Subject: "1"}).Code)
+
+	w := do(&reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var summary instances.Summary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+	require.Len(t, summary.Instances, 2)
+	assert.Equal(t, "api-1", summary.Instances[0].ID)
+	assert.True(t, summary.Instances[0].Ready)
+	assert.Equal(t, []string{"f1", "f2"}, summary.ConfigFingerprints)
+	assert.False(t, summary.Consistent)
+}
+
+func TestAPIKeyHandler_CRUD(t *testing.T) {
+	r := router.NewStdlib()
+	router.Mount(r, NewAPIKeyHandler(apikeys.NewStore(apikeys.Options{})).Routes())
+	admin := &reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/admin/api-keys", nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/admin/api-keys", &reqctx.Principal{Subject: "1"}, nil).Code)
+
+	w := do("POST", "/admin/api-keys", admin, APIKeyRequest{Name: "ci-deployer", Roles: []string{"admin"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreatedAPIKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Secret)
+	assert.Equal(t, http.StatusConflict, do("POST", "/admin/api-keys", admin, APIKeyRequest{Name: "ci-deployer"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/api-keys", admin, APIKeyRequest{Name: "CI"}).Code)
+
+	// Keys are imported by name, and never show their secret again
+	w = do("GET", "/admin/api-keys?name=ci-deployer", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+	var found []apikeys.Key
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &found))
+	assert.Equal(t, []apikeys.Key{created.Key}, found)
+
+	path := "/admin/api-keys/" + created.ID
+	w = do("PUT", path, admin, APIKeyRequest{Name: "ci-deployer", Roles: []string{"admin"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var same apikeys.Key
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &same))
+	assert.Equal(t, created.Key, same)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", path, admin, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", path, admin, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("PUT", path, admin, APIKeyRequest{Name: "ci-deployer"}).Code)
+}
+
+func TestWebhookHandler_CRUD(t *testing.T) {
+	r := router.NewStdlib()
+	router.Mount(r, NewWebhookHandler(webhooks.NewStore(webhooks.Options{})).Routes())
+	admin := &reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), *admin))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	disabled := false
+	req := WebhookRequest{Name: "crm-sync", URL: "https://crm.example.com/hooks/users", Events: []string{"user.created"}, Secret: "s3cret"}
+
+	w := do("POST", "/admin/webhooks", req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created webhooks.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, created.Enabled)
+	assert.True(t, created.HasSecret)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/webhooks", WebhookRequest{Name: "other", URL: "nowhere", Events: req.Events}).Code)
+
+	path := "/admin/webhooks/" + created.ID
+	w = do("PUT", path, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var same webhooks.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &same))
+	assert.Equal(t, created, same)
+
+	req.Enabled = &disabled
+	w = do("PUT", path, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var updated webhooks.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, created.ID, updated.ID)
+	assert.False(t, updated.Enabled)
+
+	w = do("GET", "/admin/webhooks?name=crm-sync", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var found []webhooks.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &found))
+	assert.Equal(t, []webhooks.Webhook{updated}, found)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", path, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", path, nil).Code)
+}
+
+func TestSchemaHandler(t *testing.T) {
+	registry, err := schemas.NewRegistry("https://api.example.com")
+	require.NoError(t, err)
+	r := router.NewStdlib()
+	router.Mount(r, NewSchemaHandler(registry).Routes())
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/schemas")
+	require.Equal(t, http.StatusOK, w.Code)
+	var refs []schemas.Ref
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&refs))
+	require.NotEmpty(t, refs)
+
+	w = get("/schemas/user.created/1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+	var schema schemas.Schema
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&schema))
+	assert.Equal(t, "https://api.example.com/schemas/user.created/1", schema.ID)
+	assert.Equal(t, "object", schema.Type)
+
+	assert.Equal(t, http.StatusNotFound, get("/schemas/user.created/99").Code)
+	assert.Equal(t, http.StatusNotFound, get("/schemas/user.created/latest").Code)
+	assert.Equal(t, http.StatusNotFound, get("/schemas/user.deleted/1").Code)
+
+	w = get("/api/v1/schema/user")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+	schema = schemas.Schema{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&schema))
+	assert.Equal(t, "User", schema.Title)
+	assert.Equal(t, []string{"name", "email"}, schema.Required)
+	assert.True(t, schema.Properties["id"].ReadOnly)
+	assert.Equal(t, "email", schema.Properties["email"].Format)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/schema/widget").Code)
+
+	// Without webhooks, only resource schemas are served
+	assert.Len(t, NewSchemaHandler(nil).Routes(), 1)
+}
+
+func TestUserHandler_ValidateUser(t *testing.T) {
+	engine, err := scripts.New([]scripts.Script{
+		{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: `if user.email:match("@example%.org$") then return "example.org addresses are not accepted" end`},
+	}, time.Second)
+	require.NoError(t, err)
+	userStore := store.NewMemoryUserStore()
+	userHandler := NewUserHandler(userStore)
+	userHandler.EnableScripts(engine)
+	r := router.NewStdlib()
+	router.Mount(r, userHandler.Routes())
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       UserValidation
+	}{
+		{name: "valid", body: `{"name":"John Doe","email":"john@example.com"}`, wantStatus: http.StatusOK, want: UserValidation{Valid: true, Errors: []schemas.Problem{}}},
+		{name: "read only fields are ignored", body: `{"id":9,"name":"John Doe","email":"john@example.com","created_at":"2024-01-01T09:00:00Z"}`, wantStatus: http.StatusOK, want: UserValidation{Valid: true, Errors: []schemas.Problem{}}},
+		{name: "schema", body: `{"name":7,"email":"john"}`, wantStatus: http.StatusOK, want: UserValidation{Errors: []schemas.Problem{
+			{Field: "email", Message: "must be formatted as email"},
+			{Field: "name", Message: "must be of type string"},
+		}}},
+		{name: "missing", body: `{}`, wantStatus: http.StatusOK, want: UserValidation{Errors: []schemas.Problem{
+			{Message: "name is required"},
+			{Message: "email is required"},
+		}}},
+		{name: "empty name", body: `{"name":"","email":"john@example.com"}`, wantStatus: http.StatusOK, want: UserValidation{Errors: []schemas.Problem{
+			{Field: "name", Message: "must not be empty"},
+		}}},
+		{name: "rejected by script", body: `{"name":"John Doe","email":"john@example.org"}`, wantStatus: http.StatusOK, want: UserValidation{Errors: []schemas.Problem{
+			{Message: "rejected by script policy: example.org addresses are not accepted"},
+		}}},
+		{name: "not JSON", body: `user`, wantStatus: http.StatusBadRequest},
+		{name: "empty", body: ``, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/api/v1/users/validate", strings.NewReader(tt.body))
+			if tt.body == "" {
+				req.Body = http.NoBody
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got UserValidation
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Nothing is created
+	users, err := userStore.GetAll()
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestRuleHandler_Workflow(t *testing.T) {
+	engine, err := rules.New([]rules.Rule{
+		{Name: "free-mail", Events: []string{notify.EventUserCreated}, Condition: `user.email:match("@gmail%.com$") ~= nil`, Actions: []rules.Action{{Type: rules.ActionTag, Tag: "free-mail"}}},
+		{Name: "disposable", Condition: `user.email:match("@mailinator%.com$") ~= nil`, Actions: []rules.Action{{Type: rules.ActionSuspend}}},
+	}, rules.Options{})
+	require.NoError(t, err)
+	userStore := store.NewMemoryUserStore()
+	r := router.NewStdlib()
+	router.Mount(r, slices.Concat(NewUserHandler(userStore, engine).Routes(), NewRuleHandler(userStore, engine).Routes()))
+	admin := &reqctx.Principal{Subject: "9", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), *admin))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@gmail.com"}`).Code)
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", `{"name":"Jane Smith","email":"jane@example.com"}`).Code)
+
+	// A dry run of an update says what would happen, without doing it
+	w := do("POST", "/admin/rules/evaluate", `{"event":"user.updated","user_id":2,"user":{"name":"Jane Smith","email":"jane@mailinator.com"}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var evaluation RuleEvaluationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &evaluation))
+	assert.Equal(t, []rules.Evaluation{{Rule: "disposable", Matched: true, Actions: []rules.Action{{Type: rules.ActionSuspend}}}}, evaluation.Rules)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/rules/evaluate", `{"event":"user.deleted","user_id":2}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/rules/evaluate", `{"event":"user.created"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/admin/rules/evaluate", `{"event":"user.created","user_id":99}`).Code)
+
+	var status rules.Status
+	w = do("GET", "/admin/rules/users/2", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Nil(t, status.Suspension)
+
+	require.Equal(t, http.StatusOK, do("PUT", "/api/v1/users/2", `{"name":"Jane Smith","email":"jane@mailinator.com"}`).Code)
+	w = do("GET", "/admin/rules/users/2", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.Suspension)
+	assert.Equal(t, "disposable", status.Suspension.Rule)
+	w = do("GET", "/admin/rules/users/1", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, []string{"free-mail"}, status.Tags)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/admin/rules/users/99", "").Code)
+
+	var audit []rules.Entry
+	w = do("GET", "/admin/rules/audit?user_id=2", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &audit))
+	require.Len(t, audit, 1)
+	assert.Equal(t, rules.ActionSuspend, audit[0].Action)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/admin/rules/audit?limit=0", "").Code)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/admin/rules/users/2/suspension", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/rules/users/2/suspension", "").Code)
+
+	admin.Roles = nil
+	assert.Equal(t, http.StatusForbidden, do("GET", "/admin/rules/audit", "").Code)
+}
+
+func TestStatsHandler_GetUserStats(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, email := range []string{"john@example.com", "jane@example.com", "bob@gmail.com"} {
+		_, err := realStore.Create(store.User(users.User().WithEmail(email).Build()))
+		require.NoError(t, err)
+	}
+	// Thresholding alone keeps the counts deterministic
+	r := router.NewStdlib()
+	router.Mount(r, NewStatsHandler(stats.NewPublisher(realStore, stats.Privacy{MinBucket: 2})).Routes())
+	do := func(path string, principal *reqctx.Principal) stats.Report {
+		req, _ := http.NewRequest("GET", path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var report stats.Report
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		return report
+	}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+	private := stats.Report{Total: 3, SignupsByMonth: []stats.Bucket{{Key: time.Now().UTC().Format("2006-01"), Count: 3}}, EmailDomains: []stats.Bucket{{Key: "example.com", Count: 2}}, Private: true, Suppressed: 1}
+
+	assert.Equal(t, private, do("/api/v1/stats/users", nil))
+	assert.Equal(t, private, do("/api/v1/stats/users", &reqctx.Principal{Subject: "1"}))
+	exact := do("/api/v1/stats/users", admin)
+	assert.False(t, exact.Private)
+	assert.Equal(t, []stats.Bucket{{Key: "example.com", Count: 2}, {Key: "gmail.com", Count: 1}}, exact.EmailDomains)
+	assert.Equal(t, private, do("/api/v1/stats/users?private=true", admin))
+}
+
+func TestStatsHandler_Materialized(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	_, err := realStore.Create(store.User(users.User().Build()))
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	results := materialized.NewStore(materialized.Options{Revision: realStore.Revision, Clock: clk})
+	statsHandler := NewStatsHandler(stats.NewPublisher(realStore, stats.Privacy{}))
+	statsHandler.EnableMaterialized(results)
+	r := router.NewStdlib()
+	router.Mount(r, statsHandler.Routes())
+	do := func() (stats.Report, string) {
+		req, _ := http.NewRequest("GET", "/api/v1/stats/users", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var report stats.Report
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		return report, w.Header().Get(materialized.AsOfHeader)
+	}
+
+	report, asOf := do()
+	assert.Equal(t, 1, report.Total)
+	assert.Equal(t, "2024-01-01T09:00:00Z", asOf)
+
+	_, err = realStore.Create(store.User(users.User().Build()))
+	require.NoError(t, err)
+	report, _ = do()
+	assert.Equal(t, 1, report.Total, "served from the materialized report")
+
+	clk.Advance(time.Minute)
+	results.Refresh(context.Background())
+	report, asOf = do()
+	assert.Equal(t, 2, report.Total)
+	assert.Equal(t, "2024-01-01T09:01:00Z", asOf)
+}
+
+func TestOrgHandler_Scoping(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	fakes := fixtures.New(1)
+	for _, name := range []string{"Ann", "Bob", "Cat"} {
+		_, err := realStore.Create(store.User(fakes.User().WithName(name).Build()))
+		require.NoError(t, err)
+	}
+	orgStore := orgs.NewStore(orgs.Options{IDs: idgen.NewSequence("org")})
+	r := router.NewStdlib()
+	router.Mount(r, NewOrgHandler(orgStore, realStore).Routes())
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+	ann, bob, cat := &reqctx.Principal{Subject: "1"}, &reqctx.Principal{Subject: "2"}, &reqctx.Principal{Subject: "3"}
+
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/orgs", admin, OrgRequest{Name: "Acme"}).Code)
+	require.Equal(t, http.StatusCreated, do("POST", "/api/v1/orgs", admin, OrgRequest{Name: "Europe", ParentID: "org-1"}).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/orgs", ann, OrgRequest{Name: "Asia"}).Code)
+	assert.Equal(t, http.StatusConflict, do("PUT", "/api/v1/orgs/org-1", admin, OrgRequest{Name: "Acme", ParentID: "org-2"}).Code)
+
+	// Ann administers Acme, so she can manage Europe's members too
+	assert.Equal(t, http.StatusCreated, do("PUT", "/api/v1/orgs/org-1/users/1", admin, MemberRequest{Roles: []string{"admin"}}).Code)
+	assert.Equal(t, http.StatusCreated, do("PUT", "/api/v1/orgs/org-2/users/2", ann, MemberRequest{Roles: []string{"viewer"}}).Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/api/v1/orgs/org-2/users/2", ann, MemberRequest{Roles: []string{"billing"}}).Code)
+	assert.Equal(t, http.StatusNotFound, do("PUT", "/api/v1/orgs/org-2/users/42", ann, MemberRequest{}).Code)
+	assert.Equal(t, http.StatusForbidden, do("PUT", "/api/v1/orgs/org-2/users/3", bob, MemberRequest{}).Code)
+
+	// Bob only sees Europe, and Cat sees nothing
+	w := do("GET", "/api/v1/orgs", bob, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var visible []orgs.Organization
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &visible))
+	require.Len(t, visible, 1)
+	assert.Equal(t, "org-2", visible[0].ID)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/orgs/org-1/users", bob, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/orgs/org-2", cat, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/orgs", nil, nil).Code)
+
+	w = do("GET", "/api/v1/orgs/org-1/users?descendants=true", ann, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var users []OrgUser
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+	require.Len(t, users, 2)
+	assert.Equal(t, "Bob", users[1].User.Name)
+	assert.Equal(t, []string{"billing"}, users[1].EffectiveRoles)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/orgs/org-1/users?descendants=maybe", ann, nil).Code)
+
+	// Ann's roles in Acme apply in Europe without a membership of it
+	w = do("GET", "/api/v1/orgs/org-2/users/1", bob, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var inherited OrgUser
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inherited))
+	assert.Empty(t, inherited.Roles)
+	assert.Equal(t, []string{"admin"}, inherited.EffectiveRoles)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/orgs/org-2/users/3", bob, nil).Code)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/orgs/org-2/users/2", ann, nil).Code)
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/api/v1/orgs/org-1", admin, nil).Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/orgs/org-2", admin, nil).Code)
+}
+
+func TestOrgHandler_RestrictFields(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, name := range []string{"Ann", "Bob"} {
+		_, err := realStore.Create(store.User(users.User().WithName(name).Build()))
+		require.NoError(t, err)
+	}
+	orgStore := orgs.NewStore(orgs.Options{IDs: idgen.NewSequence("org")})
+	org, err := orgStore.Create(orgs.Spec{Name: "Acme"})
+	require.NoError(t, err)
+	for _, id := range []int64{1, 2} {
+		_, _, err := orgStore.SetMember(org.ID, id, []string{"viewer"})
+		require.NoError(t, err)
+	}
+	handler := NewOrgHandler(orgStore, realStore)
+	handler.RestrictFields(visibility.NewPolicy(map[string][]string{"email": {auth.RoleAdmin, visibility.RoleSelf}}))
+	r := router.NewStdlib()
+	router.Mount(r, handler.Routes())
+	get := func(path string) []byte {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), reqctx.Principal{Subject: "1"}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.Bytes()
+	}
+
+	// Ann sees her own email but not Bob's
+	var members []OrgUser
+	require.NoError(t, json.Unmarshal(get("/api/v1/orgs/"+org.ID+"/users"), &members))
+	require.Len(t, members, 2)
+	ann, err := realStore.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, ann.Email, members[0].User.Email)
+	assert.Equal(t, "Bob", members[1].User.Name)
+	assert.Empty(t, members[1].User.Email)
+	assert.Equal(t, []string{"viewer"}, members[1].Roles)
+
+	var member OrgUser
+	require.NoError(t, json.Unmarshal(get("/api/v1/orgs/"+org.ID+"/users/2"), &member))
+	assert.Equal(t, "Bob", member.User.Name)
+	assert.Empty(t, member.User.Email)
+}
+
+func TestOrgHandler_UserGraph(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	users := fixtures.New(1)
+	for _, name := range []string{"Ann", "Bob"} {
+		_, err := realStore.Create(store.User(users.User().WithName(name).Build()))
+		require.NoError(t, err)
+	}
+	orgStore := orgs.NewStore(orgs.Options{IDs: idgen.NewSequence("org")})
+	acme, err := orgStore.Create(orgs.Spec{Name: "Acme"})
+	require.NoError(t, err)
+	europe, err := orgStore.Create(orgs.Spec{Name: "Europe", ParentID: acme.ID})
+	require.NoError(t, err)
+	_, _, err = orgStore.SetMember(acme.ID, 1, []string{orgs.RoleManager})
+	require.NoError(t, err)
+	_, _, err = orgStore.SetMember(europe.ID, 2, nil)
+	require.NoError(t, err)
+	r := router.NewStdlib()
+	router.Mount(r, NewOrgHandler(orgStore, realStore).Routes())
+	do := func(path string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+	bob := &reqctx.Principal{Subject: "2"}
+	nodeIDs := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var g graph.Graph
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &g))
+		ids := make([]string, len(g.Nodes))
+		for i, node := range g.Nodes {
+			ids[i] = node.ID
+		}
+		return ids
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("/api/v1/users/2/graph", nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("/api/v1/users/1/graph", bob).Code)
+	assert.Equal(t, http.StatusBadRequest, do("/api/v1/users/2/graph?depth=9", bob).Code)
+	assert.Equal(t, http.StatusNotFound, do("/api/v1/users/42/graph", admin).Code)
+
+	// Ann manages Bob through Acme, which only admins see as Bob is not a
+	// member of it
+	assert.Equal(t, []string{"user:2", "org:" + europe.ID, "user:1"}, nodeIDs(do("/api/v1/users/2/graph?depth=1", admin)))
+	assert.Equal(t, []string{"user:2", "org:" + europe.ID}, nodeIDs(do("/api/v1/users/2/graph", bob)))
+	assert.Equal(t, []string{"user:2", "org:" + europe.ID, "user:1", "org:" + acme.ID}, nodeIDs(do("/api/v1/users/2/graph", admin)))
+}
+
+func TestUsageHandler(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 30, 0, time.UTC))
+	meter := usage.NewMeter(nil, usage.Options{Window: time.Minute, Clock: clk})
+	r := router.NewStdlib()
+	// Only the user routes are metered, so reading usage leaves it as is
+	routes := NewUserHandler(store.NewMemoryUserStore()).Routes()
+	for i, route := range routes {
+		routes[i].Handler = meter.Handler(route.Method+" "+route.Path, route.Handler)
+	}
+	router.Mount(r, slices.Concat(routes, NewUsageHandler(meter).Routes()))
+	do := func(method, path string, principal *reqctx.Principal, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	alice := &reqctx.Principal{Subject: "1"}
+	admin := &reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/usage", nil, "").Code)
+	body := `{"name":"Alice","email":"alice@example.com"}`
+	created := do("POST", "/api/v1/users", alice, body)
+	require.Equal(t, http.StatusCreated, created.Code)
+	clk.Advance(time.Minute)
+	do("GET", "/api/v1/users", alice, "")
+
+	w := do("GET", "/api/v1/usage", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var report usage.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "1", report.Principal)
+	assert.Equal(t, int64(2), report.Requests)
+	require.Len(t, report.Operations, 2)
+	assert.Equal(t, usage.OperationUsage{Operation: "POST /api/v1/users", Requests: 1, BytesIn: int64(len(body)), BytesOut: int64(created.Body.Len())}, report.Operations[1])
+
+	// The period is filtered by window start
+	w = do("GET", "/api/v1/usage?since=2024-01-02T15:05:00Z", alice, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, int64(1), report.Requests)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/usage?since=yesterday", alice, "").Code)
+
+	// Only admins see others' usage
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/usage?principal=2", alice, "").Code)
+	w = do("GET", "/api/v1/usage?principal=1", admin, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, int64(2), report.Requests)
+}
+
+func TestLimitsHandler(t *testing.T) {
+	r := router.NewStdlib()
+	router.Mount(r, NewLimitsHandler([]Limit{{Name: "saved_views", Max: 50, Unit: "views"}}).Routes())
+	do := func(limited bool) LimitsResponse {
+		req, _ := http.NewRequest("GET", "/api/v1/limits", nil)
+		if limited {
+			req = req.WithContext(ratelimit.WithStatus(req.Context(), ratelimit.Status{
+				Limit:  ratelimit.Limit{Profile: "standard", Requests: 600, Window: time.Minute},
+				Result: ratelimit.Result{Allowed: true, Limit: 600, Remaining: 598, Reset: 41500 * time.Millisecond},
+				WarnAt: 0.8,
+			}))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response LimitsResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+
+	response := do(false)
+	assert.Nil(t, response.RateLimit)
+	require.Len(t, response.Limits, 2)
+	assert.Equal(t, Limit{Name: "batch_users", Max: maxBatchUsers, Unit: "users", Description: "Users a batch may create"}, response.Limits[0])
+	assert.Equal(t, "saved_views", response.Limits[1].Name)
+
+	response = do(true)
+	assert.Equal(t, &RateLimitStatus{Profile: "standard", Requests: 600, WindowSeconds: 60, Remaining: 598, ResetSeconds: 42, WarnAt: 0.8}, response.RateLimit)
+}
+
+func TestTenantHandler_CRUD(t *testing.T) {
+	r := router.NewStdlib()
+	router.Mount(r, NewTenantHandler(tenants.NewStore(tenants.Options{Profiles: []string{"default", "elevated"}})).Routes())
+	admin := &reqctx.Principal{Subject: "2", Roles: []string{auth.RoleAdmin}}
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/admin/tenants", nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("PUT", "/admin/tenants/acme", &reqctx.Principal{Subject: "1"}, tenants.Settings{}).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/admin/tenants/acme", admin, nil).Code)
+
+	settings := tenants.Settings{
+		RateProfile:  "elevated",
+		EmailDomains: []string{"acme.example.com"},
+		Webhooks:     []tenants.Webhook{{URL: "https://acme.example.com/hooks", Events: []string{"user.created"}, Secret: "s3cret"}},
+	}
+	w := do("PUT", "/admin/tenants/acme", admin, settings)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/admin/tenants/acme", admin, tenants.Settings{RateProfile: "unlimited"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/admin/tenants/Acme%20Corp", admin, settings).Code)
+
+	w = do("GET", "/admin/tenants/acme", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got tenants.Settings
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "elevated", got.RateProfile)
+	require.Len(t, got.Webhooks, 1)
+	assert.True(t, got.Webhooks[0].HasSecret)
+	assert.Empty(t, got.Webhooks[0].Secret)
+
+	w = do("GET", "/admin/tenants", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []tenants.Tenant
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "acme", list[0].ID)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/admin/tenants/acme", admin, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/tenants/acme", admin, nil).Code)
+}
+
+func TestUserHandler_TenantEmailDomains(t *testing.T) {
+	tenantStore := tenants.NewStore(tenants.Options{})
+	_, err := tenantStore.Put("acme", tenants.Settings{EmailDomains: []string{"acme.example.com"}})
+	require.NoError(t, err)
+	userStore := store.NewMemoryUserStore()
+	r := router.NewStdlib()
+	router.Mount(r, NewUserHandler(userStore).Routes())
+	handler := tenantStore.Middleware(tenants.DefaultHeader)(r)
+	fakes := fixtures.New(1)
+
+	do := func(method, path, tenant string, user client.User) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(user)
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tenant != "" {
+			req.Header.Set(tenants.DefaultHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/users", "acme", fakes.User().WithEmail("john@example.com").Build())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "acme.example.com")
+	assert.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", "acme", fakes.User().WithEmail("john@acme.example.com").Build()).Code)
+	// Other tenants, and requests for no tenant, allow any domain
+	assert.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", "globex", fakes.User().WithEmail("jane@example.com").Build()).Code)
+	assert.Equal(t, http.StatusCreated, do("POST", "/api/v1/users", "", fakes.User().WithEmail("jane.roe@example.com").Build()).Code)
+
+	users, err := userStore.GetAll()
+	require.NoError(t, err)
+	id := strconv.FormatInt(users[0].ID, 10)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/v1/users/"+id, "acme", fakes.User().WithEmail("john@example.com").Build()).Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/api/v1/users/"+id, "acme", fakes.User().WithEmail("jd@acme.example.com").Build()).Code)
+}
+
+// TestHelperPlugin is not a test: it is the plugin TestUserHandler_Plugins
+// runs, by running the test binary again
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv("HANDLERS_PLUGIN") != "1" {
+		return
+	}
+	plugin.Serve(plugin.HookFuncs{
+		ValidateBeforeCreate: func(user plugin.User) (string, error) {
+			if strings.HasSuffix(user.Email, "@example.org") {
+				return "example.org addresses are not accepted", nil
+			}
+			return "", nil
+		},
+		EnrichAfterRead: func(users []plugin.User) ([]plugin.User, error) {
+			for i := range users {
+				users[i].Attributes = map[string]string{"plan": "gold"}
+			}
+			return users, nil
+		},
+	})
+}
+
+func TestUserHandler_Plugins(t *testing.T) {
+	t.Setenv("HANDLERS_PLUGIN", "1")
+	runner, err := plugins.New([]plugins.Plugin{{
+		Name:    "policy",
+		Command: []string{os.Args[0], "-test.run=^TestHelperPlugin$"},
+		Hooks:   plugin.Hooks,
+	}}, time.Minute)
+	require.NoError(t, err)
+	userStore := store.NewMemoryUserStore()
+	userHandler := NewUserHandler(userStore)
+	userHandler.EnablePlugins(runner)
+	r := router.NewStdlib()
+	router.Mount(r, slices.Concat(userHandler.Routes(), NewSCIMHandler(userHandler, "secret", 2).Routes()))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@example.org"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "example.org addresses are not accepted")
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/scim/v2/Users", `{"userName":"jane@example.org","emails":[{"value":"jane@example.org","primary":true}]}`).Code)
+	users, err := userStore.GetAll()
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	w = do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@example.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created store.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// Reads are enriched with the plugin's attributes
+	var user EnrichedUser
+	w = do("GET", "/api/v1/users/"+strconv.FormatInt(created.ID, 10), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+	assert.Equal(t, EnrichedUser{User: created, Attributes: map[string]string{"plan": "gold"}}, user)
+
+	var list []EnrichedUser
+	w = do("GET", "/api/v1/users", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, map[string]string{"plan": "gold"}, list[0].Attributes)
+
+	// A failing plugin fails the request
+	failing, err := plugins.New([]plugins.Plugin{{Name: "broken", Command: []string{"/nonexistent/plugin"}, Hooks: plugin.Hooks}}, time.Minute)
+	require.NoError(t, err)
+	userHandler.EnablePlugins(failing)
+	assert.Equal(t, http.StatusBadGateway, do("GET", "/api/v1/users", "").Code)
+	assert.Equal(t, http.StatusBadGateway, do("POST", "/api/v1/users", `{"name":"Jane Doe","email":"jane@example.com"}`).Code)
+}
+
+func TestUserHandler_Scripts(t *testing.T) {
+	engine, err := scripts.New([]scripts.Script{
+		{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: `if user.email:match("@example%.org$") then return "example.org addresses are not accepted" end`},
+		{Name: "initials", Hook: plugin.HookEnrichAfterRead, Source: `return {initials = user.name:gsub("(%a)%a*%s*", "%1")}`},
+	}, time.Second)
+	require.NoError(t, err)
+	userStore := store.NewMemoryUserStore()
+	userHandler := NewUserHandler(userStore)
+	userHandler.EnableScripts(engine)
+	r := router.NewStdlib()
+	router.Mount(r, slices.Concat(userHandler.Routes(), NewSCIMHandler(userHandler, "secret", 2).Routes()))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@example.org"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "example.org addresses are not accepted")
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/scim/v2/Users", `{"userName":"jane@example.org","emails":[{"value":"jane@example.org","primary":true}]}`).Code)
+
+	w = do("POST", "/api/v1/users", `{"name":"John Doe","email":"john@example.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created store.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// Reads get the fields scripts compute
+	var user EnrichedUser
+	w = do("GET", "/api/v1/users/"+strconv.FormatInt(created.ID, 10), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+	assert.Equal(t, map[string]string{"initials": "JD"}, user.Attributes)
+
+	// Failing scripts are server errors, and reloading replaces them
+	require.NoError(t, engine.Reload([]scripts.Script{
+		{Name: "broken", Hook: plugin.HookValidateBeforeCreate, Source: `error("oops")`},
+		{Name: "broken", Hook: plugin.HookEnrichAfterRead, Source: `return user.missing.field`},
+	}))
+	assert.Equal(t, http.StatusInternalServerError, do("GET", "/api/v1/users", "").Code)
+	assert.Equal(t, http.StatusInternalServerError, do("POST", "/api/v1/users", `{"name":"Jane Doe","email":"jane@example.com"}`).Code)
+}
+
+func TestPreferenceHandler_AppliesPreferences(t *testing.T) {
+	realStore, err := store.NewChangeCapturingUserStore(store.NewMemoryUserStore(), store.ChangeLogOptions{})
+	require.NoError(t, err)
+	users := fixtures.New(1)
+	for _, email := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		_, err := realStore.Create(store.User(users.User().WithEmail(email).Build()))
+		require.NoError(t, err)
+	}
+	prefs := preferences.NewStore(preferences.Options{Profiles: []string{"elevated", "standard"}})
+	preferenceHandler := NewPreferenceHandler(prefs, realStore)
+
+	r := router.NewStdlib()
+	routes := slices.Concat(NewUserHandler(realStore).Routes(), NewChangeHandler(realStore).Routes(), preferenceHandler.Routes(), preferenceHandler.AdminRoutes())
+	router.Mount(r, router.Wrap(routes, prefs.Middleware))
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	alice := &reqctx.Principal{Subject: "1"}
+	admin := &reqctx.Principal{Subject: "99", Roles: []string{auth.RoleAdmin}}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/me/preferences", nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/me/preferences", &reqctx.Principal{Subject: "apikey:1"}, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/v1/me/preferences", alice, PreferencesRequest{Timezone: "Nowhere"}).Code)
+	w := do("PUT", "/api/v1/me/preferences", alice, PreferencesRequest{PageSize: 2, Timezone: "Asia/Tokyo", Locale: "ja-JP"})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Admins choose the rate limit profile, which users can only read
+	assert.Equal(t, http.StatusForbidden, do("PUT", "/admin/users/1/rate-profile", alice, RateProfileRequest{Profile: "elevated"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/admin/users/1/rate-profile", admin, RateProfileRequest{Profile: "unlimited"}).Code)
+	assert.Equal(t, http.StatusNotFound, do("PUT", "/admin/users/42/rate-profile", admin, RateProfileRequest{Profile: "elevated"}).Code)
+	require.Equal(t, http.StatusOK, do("PUT", "/admin/users/1/rate-profile", admin, RateProfileRequest{Profile: "elevated"}).Code)
+	w = do("GET", "/api/v1/me/preferences", alice, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got preferences.Preferences
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, preferences.Preferences{PageSize: 2, Timezone: "Asia/Tokyo", Locale: "ja-JP", RateProfile: "elevated"}, got)
+
+	// Timestamps are written in Alice's time zone, and pages are her size
+	w = do("GET", "/api/v1/users/2", alice, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ja-JP", w.Header().Get("Content-Language"))
+	assert.Contains(t, w.Body.String(), "+09:00")
+	w = do("GET", "/api/v1/users", alice, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "+09:00")
+	w = do("GET", "/api/v1/users", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "+09:00")
+
+	var page ChangesResponse
+	w = do("GET", "/api/v1/cdc", alice, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Changes, 2)
+	w = do("GET", "/api/v1/cdc?limit=3", alice, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Changes, 3)
+}
+
+func TestConsentHandler_Acceptance(t *testing.T) {
+	realStore := store.NewMemoryUserStore()
+	john, _ := realStore.Create(store.User(fixtures.New(1).User().Build()))
+	consentStore := consent.NewStore(consent.Options{
+		Documents: map[string]string{"terms": "2024-06", "privacy": "2024-01"},
+		Enforce:   true,
+		Exempt:    []string{"/api/v1/terms", "/api/v1/me/consents"},
+	})
+	consentHandler := NewConsentHandler(consentStore, realStore)
+
+	r := router.NewStdlib()
+	routes := slices.Concat(NewUserHandler(realStore).Routes(), consentHandler.Routes(), consentHandler.AdminRoutes())
+	router.Mount(r, router.Wrap(routes, consentStore.Middleware))
+	do := func(method, path string, principal *reqctx.Principal, body any) *httptest.ResponseRecorder {
+		var payload io.Reader = http.NoBody
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			payload = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, path, payload)
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	asJohn := &reqctx.Principal{Subject: fmt.Sprint(john.ID)}
+	admin := &reqctx.Principal{Subject: "apikey:k1", Roles: []string{auth.RoleAdmin}}
+
+	w := do("GET", "/api/v1/terms", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var current []consent.Document
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &current))
+	assert.Equal(t, []consent.Document{{Name: "privacy", Version: "2024-01"}, {Name: "terms", Version: "2024-06"}}, current)
+
+	// Users who have not accepted every current document are refused
+	w = do("GET", "/api/v1/users", asJohn, nil)
+	require.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	var refused consent.RequiredError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refused))
+	assert.Equal(t, consent.ErrorCode, refused.Code)
+	assert.Len(t, refused.Pending, 2)
+
+	tests := []struct {
+		name      string
+		principal *reqctx.Principal
+		body      AcceptRequest
+		expected  int
+	}{
+		{"anonymous", nil, AcceptRequest{Document: "terms", Version: "2024-06"}, http.StatusUnauthorized},
+		{"not a user", admin, AcceptRequest{Document: "terms", Version: "2024-06"}, http.StatusForbidden},
+		{"unknown document", asJohn, AcceptRequest{Document: "cookies", Version: "1"}, http.StatusBadRequest},
+		{"old version", asJohn, AcceptRequest{Document: "terms", Version: "2023-01"}, http.StatusConflict},
+		{"terms", asJohn, AcceptRequest{Document: "terms", Version: "2024-06"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, do("POST", "/api/v1/me/consents", tt.principal, tt.body).Code, tt.name)
+	}
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, do("GET", "/api/v1/users", asJohn, nil).Code)
+
+	require.Equal(t, http.StatusOK, do("POST", "/api/v1/me/consents", asJohn, AcceptRequest{Document: "privacy", Version: "2024-01"}).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/users", asJohn, nil).Code)
+
+	w = do("GET", "/api/v1/me/consents", asJohn, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var status consent.Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Len(t, status.Accepted, 2)
+	assert.Empty(t, status.Pending)
+
+	// Admins audit users' acceptances
+	assert.Equal(t, http.StatusForbidden, do("GET", fmt.Sprintf("/admin/users/%d/consents", john.ID), asJohn, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/admin/users/99/consents", admin, nil).Code)
+	w = do("GET", fmt.Sprintf("/admin/users/%d/consents", john.ID), admin, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Len(t, status.Accepted, 2)
+}
+
+// emptyDirectory is a directory with no one in it
+type emptyDirectory struct{}
+
+func (emptyDirectory) Users(context.Context) ([]directory.Entry, error) {
+	return nil, nil
+}
+
+// TestContract checks every route the handlers serve, with every optional
+// feature enabled, against the generated OpenAPI document: that each is
+// documented with its path parameters, and that every status it responds
+// with to a range of callers and requests is documented too
+func TestContract(t *testing.T) {
+	doc, err := swag.ReadDoc()
+	require.NoError(t, err)
+	spec, err := apidocs.Load(doc)
+	require.NoError(t, err)
+
+	realStore, err := store.NewChangeCapturingUserStore(store.NewMemoryUserStore(), store.ChangeLogOptions{})
+	require.NoError(t, err)
+	_, err = realStore.Create(store.User(fixtures.New(1).User().Build()))
+	require.NoError(t, err)
+	passwords := auth.NewPasswords()
+	require.NoError(t, passwords.Set(1, "password1"))
+	service, err := auth.NewService(realStore, passwords, auth.Options{Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	local, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+	require.NoError(t, err)
+	uploadManager := uploads.NewManager(local, uploads.Options{Purposes: map[string]int64{"avatar": 1 << 20}, IDs: idgen.NewSequence("upload")})
+	operationManager := operations.NewManager(operations.Options{IDs: idgen.NewSequence("op")})
+	approvals := approval.NewWorkflow(approval.Options{})
+	viewStore := views.NewStore(views.Options{})
+	preferenceHandler := NewPreferenceHandler(preferences.NewStore(preferences.Options{Profiles: []string{"standard"}}), realStore)
+	consentHandler := NewConsentHandler(consent.NewStore(consent.Options{Documents: map[string]string{"terms": "2024-06"}}), realStore)
+
+	userHandler := NewUserHandler(realStore)
+	userHandler.RequireOwnership()
+	userHandler.RequireDeleteApproval(approvals)
+	userHandler.EnableUndelete(store.NewRecycleBin(realStore, time.Hour, nil))
+	userHandler.EnableViews(viewStore)
+	userHandler.EnableAsync(operationManager)
+	userHandler.EnableAvatars(avatars.NewProcessor(local, avatars.Options{Sizes: map[string]int{"thumb": 16}}), uploadManager, time.Hour)
+	adminHandler := NewAdminHandler(realStore)
+	adminHandler.EnableAsync(operationManager)
+	adminHandler.EnableDirectorySync(directory.NewSyncer(emptyDirectory{}, realStore, nil))
+	adminHandler.EnableAnonymization(realStore, anonymize.New(""))
+	adminHandler.EnableReports(realStore, reports.NewManager(local, reports.Options{IDs: idgen.NewSequence("report")}), 2)
+	adminHandler.EnableBackup(backup.New(backup.Options{Users: realStore}))
+	adminHandler.EnableMerge(realStore, merge.New(realStore))
+	authHandler := NewAuthHandler(realStore, service)
+	uploadHandler := NewUploadHandler(uploadManager, local)
+	ruleEngine, err := rules.New(nil, rules.Options{})
+	require.NoError(t, err)
+	schemaRegistry, err := schemas.NewRegistry("")
+	require.NoError(t, err)
+	replicator, err := replication.New(store.NewMemoryUserStore(), replication.Options{Node: "a", Nodes: []replication.Node{{Name: "a"}}, Secret: "secret"})
+	require.NoError(t, err)
+
+	routes := slices.Concat(
+		userHandler.Routes(),
+		adminHandler.Routes(),
+		authHandler.Routes(),
+		authHandler.AdminRoutes(),
+		NewApprovalHandler(approvals).Routes(),
+		NewAPIKeyHandler(apikeys.NewStore(apikeys.Options{})).Routes(),
+		NewChangeHandler(realStore).Routes(),
+		consentHandler.Routes(),
+		consentHandler.AdminRoutes(),
+		NewDocsHandler(swag.ReadDoc, nil).Routes(),
+		NewInstanceHandler(instances.NewMemory(nil)).Routes(),
+		NewLimitsHandler(nil).Routes(),
+		NewTenantHandler(tenants.NewStore(tenants.Options{})).Routes(),
+		NewNotificationHandler(realStore, notify.NewMemoryPreferenceStore()).Routes(),
+		NewOperationHandler(operationManager).Routes(),
+		NewOrgHandler(orgs.NewStore(orgs.Options{IDs: idgen.NewSequence("org")}), realStore).Routes(),
+		preferenceHandler.Routes(),
+		preferenceHandler.AdminRoutes(),
+		NewReplicationHandler(replicator).Routes(),
+		NewSCIMHandler(userHandler, "secret", 2).Routes(),
+		uploadHandler.Routes(),
+		uploadHandler.ContentRoutes(),
+		NewUsageHandler(usage.NewMeter(nil, usage.Options{})).Routes(),
+		NewViewHandler(viewStore).Routes(),
+		NewWebhookHandler(webhooks.NewStore(webhooks.Options{})).Routes(),
+		NewRuleHandler(realStore, ruleEngine).Routes(),
+		NewStatsHandler(stats.NewPublisher(realStore, stats.Privacy{})).Routes(),
+		NewSchemaHandler(schemaRegistry).Routes(),
+	)
+	// The docs UI is HTML and JavaScript, so it is left out of the document
+	routes = slices.DeleteFunc(routes, func(route router.Route) bool {
+		return route.Path == "/docs" || strings.HasPrefix(route.Path, DocsAssetsPath)
+	})
+
+	// The app serves the health checks itself
+	served := append(slices.Clone(routes),
+		router.Route{Method: http.MethodGet, Path: "/health"},
+		router.Route{Method: http.MethodGet, Path: "/readyz"},
+	)
+	for _, problem := range contract.Check(spec, served) {
+		t.Error(problem)
+	}
+
+	recorder := contract.NewRecorder()
+	r := router.NewStdlib()
+	router.Mount(r, recorder.Wrap(routes))
+	callers := []struct {
+		principal *reqctx.Principal
+		token     string
+	}{
+		{},
+		{token: "secret"},
+		{principal: &reqctx.Principal{Subject: "2"}},
+		{principal: &reqctx.Principal{Subject: "1"}},
+		{principal: &reqctx.Principal{Subject: "3", Roles: []string{auth.RoleAdmin}}},
+	}
+	for _, route := range routes {
+		for _, param := range []string{"bad", "1"} {
+			path := route.Path
+			for strings.Contains(path, "{") {
+				start, end := strings.Index(path, "{"), strings.Index(path, "}")
+				path = path[:start] + param + path[end+1:]
+			}
+			for _, body := range []string{"not json", "{}"} {
+				for _, caller := range callers {
+					req, _ := http.NewRequest(route.Method, path, strings.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+					if caller.token != "" {
+						req.Header.Set("Authorization", "Bearer "+caller.token)
+					}
+					if caller.principal != nil {
+						req = req.WithContext(reqctx.WithPrincipal(req.Context(), *caller.principal))
+					}
+					r.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			}
+		}
+	}
+	for _, problem := range recorder.Undocumented(spec) {
+		t.Error(problem)
+	}
+}
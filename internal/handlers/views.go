@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/views"
+)
+
+// ViewRequest saves a list query under a name
+type ViewRequest struct {
+	Name string `json:"name" example:"recently-updated"`
+	// Query holds GET /api/v1/users parameters, URL encoded
+	Query string `json:"query" example:"updated_within=7d&created_after=2024-01-01"`
+	// Materialized keeps the view's results precomputed, for expensive
+	// queries that can be served slightly stale; it has no effect unless
+	// materialized views are enabled
+	Materialized bool `json:"materialized" example:"false"`
+}
+
+type ViewHandler struct {
+	views *views.Store
+}
+
+func NewViewHandler(viewStore *views.Store) *ViewHandler {
+	return &ViewHandler{
+		views: viewStore,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *ViewHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/api/v1/views", Handler: http.HandlerFunc(h.GetViews)},
+		{Method: http.MethodPost, Path: "/api/v1/views", Handler: http.HandlerFunc(h.SaveView)},
+		{Method: http.MethodGet, Path: "/api/v1/views/{name}", Handler: http.HandlerFunc(h.GetView)},
+		{Method: http.MethodDelete, Path: "/api/v1/views/{name}", Handler: http.HandlerFunc(h.DeleteView)},
+	}
+}
+
+// @Summary List saved views
+// @Description List the caller's saved list queries
+// @Tags views
+// @Accept json
+// @Produce json
+// @Success 200 {array} views.View
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/views [get]
+func (h *ViewHandler) GetViews(w http.ResponseWriter, r *http.Request) {
+	owner, ok := viewOwner(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.views.List(owner))
+}
+
+// @Summary Save a view
+// @Description Save GET /api/v1/users parameters under a name, for use as ?view=name. Saving an existing name replaces its query. Materialized views are served from results refreshed in the background, with an X-Data-As-Of header.
+// @Tags views
+// @Accept json
+// @Produce json
+// @Param view body ViewRequest true "View to save"
+// @Success 200 {object} views.View
+// @Success 201 {object} views.View
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/views [post]
+func (h *ViewHandler) SaveView(w http.ResponseWriter, r *http.Request) {
+	owner, ok := viewOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req ViewRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	query, err := url.ParseQuery(req.Query)
+	if err != nil {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, "Invalid query: "+err.Error())
+		return
+	}
+	if err := checkUsersQuery(query); err != nil {
+		writeCodedError(w, r, http.StatusBadRequest, apierrors.InvalidParameter, "Invalid query: "+err.Error())
+		return
+	}
+
+	view, err := h.views.Save(owner, req.Name, query, req.Materialized)
+	switch {
+	case errors.Is(err, views.ErrInvalidName):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, views.ErrLimitReached):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	case view.CreatedAt.Equal(view.UpdatedAt):
+		writeJSON(w, http.StatusCreated, view)
+	default:
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+// @Summary Get a saved view
+// @Description Get one of the caller's saved list queries
+// @Tags views
+// @Accept json
+// @Produce json
+// @Param name path string true "View name"
+// @Success 200 {object} views.View
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/views/{name} [get]
+func (h *ViewHandler) GetView(w http.ResponseWriter, r *http.Request) {
+	owner, ok := viewOwner(w, r)
+	if !ok {
+		return
+	}
+	view, err := h.views.Get(owner, r.PathValue("name"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "View not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// @Summary Delete a saved view
+// @Description Delete one of the caller's saved list queries
+// @Tags views
+// @Accept json
+// @Produce json
+// @Param name path string true "View name"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/views/{name} [delete]
+func (h *ViewHandler) DeleteView(w http.ResponseWriter, r *http.Request) {
+	owner, ok := viewOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.views.Delete(owner, r.PathValue("name")); err != nil {
+		writeError(w, r, http.StatusNotFound, "View not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// viewOwner returns the caller whose views a request works on. Views are
+// per caller, so anonymous requests are rejected.
+func viewOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	principal, ok := reqctx.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "Authentication required")
+		return "", false
+	}
+	return principal.Subject, true
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/visibility"
+)
+
+// redactUser returns a record about the user with ID owner, such as a
+// store.User, without the fields the caller of r may not see. An OrgUser
+// has the fields removed from the user it carries.
+func redactUser(r *http.Request, policy *visibility.Policy, record any, owner int64) (any, error) {
+	hidden := policy.Hidden(r.Context(), strconv.FormatInt(owner, 10))
+	if member, ok := record.(OrgUser); ok {
+		user, err := visibility.Redact(member.User, hidden)
+		if err != nil {
+			return nil, err
+		}
+		return redactedOrgUser{OrgUser: member, User: user}, nil
+	}
+	return visibility.Redact(record, hidden)
+}
+
+// redactedOrgUser is an OrgUser whose user has hidden fields removed. Its
+// User shadows the embedded one when encoded.
+type redactedOrgUser struct {
+	OrgUser
+	User any `json:"user"`
+}
+
+// writeUser writes a record about the user with ID owner, without the
+// fields the caller of r may not see and with its timestamps in the
+// caller's time zone
+func writeUser(w http.ResponseWriter, r *http.Request, policy *visibility.Policy, status int, record any, owner int64) {
+	if loc, ok := reqctx.Timezone(r.Context()); ok {
+		record = inZone(record, loc)
+	}
+	redacted, err := redactUser(r, policy, record, owner)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, status, redacted)
+}
+
+// writeUsers writes records about users, each without the fields the
+// caller of r may not see on it and with its timestamps in the caller's
+// time zone. owner returns the ID of a record's user.
+func writeUsers[T any](w http.ResponseWriter, r *http.Request, policy *visibility.Policy, records []T, owner func(T) int64) {
+	loc, zoned := reqctx.Timezone(r.Context())
+	restricted := policy.Restricts(r.Context())
+	if !restricted && !zoned {
+		writeSizedJSON(w, http.StatusOK, records, len(records)*estimatedUserJSONSize)
+		return
+	}
+
+	written := make([]any, len(records))
+	for i, record := range records {
+		written[i] = record
+		if zoned {
+			written[i] = inZone(written[i], loc)
+		}
+		if restricted {
+			var err error
+			if written[i], err = redactUser(r, policy, written[i], owner(record)); err != nil {
+				writeError(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	}
+	writeSizedJSON(w, http.StatusOK, written, len(written)*estimatedUserJSONSize)
+}
+
+// inZone returns a record about a user with its timestamps in loc. Records
+// of other types are returned unchanged.
+func inZone(record any, loc *time.Location) any {
+	switch record := record.(type) {
+	case store.User:
+		return record.In(loc)
+	case *store.User:
+		user := record.In(loc)
+		return &user
+	case EnrichedUser:
+		record.User = record.User.In(loc)
+		return record
+	case OrgUser:
+		record.User = record.User.In(loc)
+		return record
+	case UserState:
+		record.User = record.User.In(loc)
+		if record.PurgeAt != nil {
+			purgeAt := record.PurgeAt.In(loc)
+			record.PurgeAt = &purgeAt
+		}
+		return record
+	default:
+		return record
+	}
+}
+
+func userID(user store.User) int64 { return user.ID }
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/router"
+	"github.com/dazraf/go-api-example/internal/webhooks"
+)
+
+// WebhookRequest sets everything about a webhook subscription
+type WebhookRequest struct {
+	Name   string   `json:"name" example:"crm-sync"`
+	URL    string   `json:"url" example:"https://crm.example.com/hooks/users"`
+	Events []string `json:"events" example:"user.created,user.updated"`
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled,omitempty" example:"true"`
+	// Secret signs bodies in the X-Signature-256 header; omitting it sends
+	// them unsigned
+	Secret string `json:"secret,omitempty" example:"s3cret"`
+}
+
+func (req WebhookRequest) spec() webhooks.Spec {
+	enabled := req.Enabled == nil || *req.Enabled
+	return webhooks.Spec{Name: req.Name, URL: req.URL, Events: req.Events, Enabled: enabled, Secret: req.Secret}
+}
+
+type WebhookHandler struct {
+	webhooks *webhooks.Store
+}
+
+func NewWebhookHandler(webhookStore *webhooks.Store) *WebhookHandler {
+	return &WebhookHandler{
+		webhooks: webhookStore,
+	}
+}
+
+// Routes returns the endpoints served by the handler
+func (h *WebhookHandler) Routes() []router.Route {
+	return []router.Route{
+		{Method: http.MethodGet, Path: "/admin/webhooks", Handler: http.HandlerFunc(h.GetWebhooks)},
+		{Method: http.MethodPost, Path: "/admin/webhooks", Handler: http.HandlerFunc(h.CreateWebhook)},
+		{Method: http.MethodGet, Path: "/admin/webhooks/{id}", Handler: http.HandlerFunc(h.GetWebhook)},
+		{Method: http.MethodPut, Path: "/admin/webhooks/{id}", Handler: http.HandlerFunc(h.UpdateWebhook)},
+		{Method: http.MethodDelete, Path: "/admin/webhooks/{id}", Handler: http.HandlerFunc(h.DeleteWebhook)},
+	}
+}
+
+// @Summary List webhooks
+// @Description List webhook subscriptions by name, or find one by its name, e.g. to import it into Terraform
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param name query string false "Only the webhook with this name"
+// @Success 200 {array} webhooks.Webhook
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/webhooks [get]
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.webhooks.List(r.URL.Query().Get("name")))
+}
+
+// @Summary Create a webhook
+// @Description Subscribe a URL to user events under a unique name
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param webhook body WebhookRequest true "Webhook to create"
+// @Success 201 {object} webhooks.Webhook
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /admin/webhooks [post]
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req WebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	webhook, err := h.webhooks.Create(req.spec())
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+// @Summary Get a webhook
+// @Description Get a webhook subscription by its ID, without its secret
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} webhooks.Webhook
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/webhooks/{id} [get]
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	webhook, err := h.webhooks.Get(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// @Summary Update a webhook
+// @Description Replace everything about a webhook subscription, including its secret, keeping its ID. Sending what is already set changes nothing, including updated_at.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param webhook body WebhookRequest true "Webhook"
+// @Success 200 {object} webhooks.Webhook
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /admin/webhooks/{id} [put]
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req WebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	webhook, err := h.webhooks.Update(r.PathValue("id"), req.spec())
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// @Summary Delete a webhook
+// @Description Unsubscribe a webhook from every event
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := h.webhooks.Delete(r.PathValue("id")); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "Webhook not found")
+	case errors.Is(err, webhooks.ErrNameTaken):
+		writeError(w, r, http.StatusConflict, err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	}
+}
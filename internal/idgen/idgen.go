@@ -0,0 +1,53 @@
+// Package idgen abstracts the generation of opaque string identifiers, such
+// as request IDs and tokens, so tests can substitute predictable ones and
+// other strategies can be swapped in per backend.
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+)
+
+// Generator produces unique identifiers
+type Generator interface {
+	NewID() string
+}
+
+// IntGenerator produces unique positive integer identifiers, for stores
+// keyed by number such as the user store
+type IntGenerator interface {
+	NewInt() int64
+}
+
+type randomGenerator struct{}
+
+// NewRandom returns a Generator of random 128-bit hex identifiers
+func NewRandom() Generator {
+	return randomGenerator{}
+}
+
+func (randomGenerator) NewID() string {
+	var b [16]byte
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Sequence is a deterministic Generator producing prefix-1, prefix-2, and
+// so on. It is safe for concurrent use.
+type Sequence struct {
+	prefix string
+	next   atomic.Uint64
+}
+
+// NewSequence creates a sequence generator using prefix
+func NewSequence(prefix string) *Sequence {
+	return &Sequence{prefix: prefix}
+}
+
+// NewID returns the next identifier in the sequence
+func (s *Sequence) NewID() string {
+	return fmt.Sprintf("%s-%d", s.prefix, s.next.Add(1))
+}
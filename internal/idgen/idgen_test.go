@@ -0,0 +1,171 @@
+package idgen
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+func TestSequence(t *testing.T) {
+	seq := NewSequence("req")
+	assert.Equal(t, "req-1", seq.NewID())
+	assert.Equal(t, "req-2", seq.NewID())
+}
+
+func TestGenerators_ConcurrentIDsAreUnique(t *testing.T) {
+	snowflake, err := NewSnowflake(1, clock.Real())
+	require.NoError(t, err)
+	generators := map[string]Generator{
+		"random":    NewRandom(),
+		"sequence":  NewSequence("id"),
+		"ulid":      NewULID(clock.Real()),
+		"ksuid":     NewKSUID(clock.Real()),
+		"snowflake": snowflake,
+	}
+
+	for name, gen := range generators {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mutex sync.Mutex
+				seen  = make(map[string]struct{})
+				wg    sync.WaitGroup
+			)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						id := gen.NewID()
+						mutex.Lock()
+						seen[id] = struct{}{}
+						mutex.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Len(t, seen, 800)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, strategy := range Strategies {
+		gen, err := New(strategy, Options{Prefix: "org"})
+		require.NoError(t, err, strategy)
+		assert.NotEmpty(t, gen.NewID())
+	}
+	_, err := New("uuid", Options{})
+	assert.ErrorIs(t, err, ErrUnknownStrategy)
+	_, err = New(StrategySnowflake, Options{Node: MaxSnowflakeNode + 1})
+	assert.Error(t, err)
+}
+
+func TestGenerators_Ordering(t *testing.T) {
+	start := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		new  func(clk clock.Clock) Generator
+		// step is how far the clock must move for IDs to sort by time
+		step time.Duration
+		// ordered reports whether IDs in the same step are in order too
+		ordered bool
+		compare func(a, b string) int
+	}{
+		{"ulid", func(clk clock.Clock) Generator { return NewULID(clk) }, time.Millisecond, true, strings.Compare},
+		{"ksuid", func(clk clock.Clock) Generator { return NewKSUID(clk) }, time.Second, false, strings.Compare},
+		{"snowflake", func(clk clock.Clock) Generator {
+			gen, _ := NewSnowflake(3, clk)
+			return gen
+		}, time.Millisecond, true, func(a, b string) int {
+			x, _ := strconv.ParseInt(a, 10, 64)
+			y, _ := strconv.ParseInt(b, 10, 64)
+			return cmp.Compare(x, y)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			less := func(a, b string) bool { return tt.compare(a, b) < 0 }
+			clk := clock.NewFake(start)
+			gen := tt.new(clk)
+
+			var ids []string
+			for range 3 {
+				for range 100 {
+					ids = append(ids, gen.NewID())
+				}
+				clk.Advance(tt.step)
+			}
+			// Every ID sorts after those of earlier steps
+			for step := 1; step < 3; step++ {
+				earlier, later := ids[(step-1)*100:step*100], ids[step*100:(step+1)*100]
+				assert.True(t, less(slices.MaxFunc(earlier, tt.compare), slices.MinFunc(later, tt.compare)), "step %d", step)
+			}
+			if tt.ordered {
+				assert.True(t, slices.IsSortedFunc(ids, tt.compare))
+				// IDs keep increasing when the clock goes back
+				clk.Set(start)
+				assert.True(t, less(ids[len(ids)-1], gen.NewID()))
+			}
+		})
+	}
+}
+
+func TestULID_Format(t *testing.T) {
+	gen := NewULID(clock.NewFake(time.UnixMilli(1469918176385)))
+	id := gen.NewID()
+	assert.Len(t, id, 26)
+	// The first ten characters encode the time
+	assert.Equal(t, "01ARYZ6S41", id[:10])
+}
+
+func TestKSUID_Format(t *testing.T) {
+	gen := NewKSUID(clock.NewFake(time.Unix(ksuidEpoch, 0)))
+	id := gen.NewID()
+	assert.Len(t, id, 27)
+	assert.Less(t, id, NewKSUID(clock.NewFake(time.Unix(ksuidEpoch+1, 0))).NewID())
+}
+
+func TestSnowflake_Layout(t *testing.T) {
+	clk := clock.NewFake(time.UnixMilli(snowflakeEpoch + 5))
+	gen, err := NewSnowflake(7, clk)
+	require.NoError(t, err)
+
+	id, err := strconv.ParseInt(gen.NewID(), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), id>>22)
+	assert.Equal(t, int64(7), id>>12&MaxSnowflakeNode)
+	assert.Equal(t, int64(0), id&4095)
+
+	// Past 4096 IDs a millisecond, the next millisecond is borrowed
+	for range 4095 {
+		gen.NewID()
+	}
+	id, err = strconv.ParseInt(gen.NewID(), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), id>>22)
+	assert.Equal(t, int64(0), id&4095)
+	assert.Equal(t, id+1, gen.NewInt())
+}
+
+func TestParseInt(t *testing.T) {
+	id, err := ParseInt("42", "users")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+	id, err = ParseInt("users-42", "users")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	for _, invalid := range []string{"", "0", "-1", "users-", "users--1", "orgs-42", "01HZX3K6RQ4T7M8N9P0A1B2C3D"} {
+		_, err := ParseInt(invalid, "users")
+		assert.ErrorIs(t, err, ErrInvalidInt, invalid)
+	}
+}
@@ -0,0 +1,242 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// Strategies name the ways identifiers can be generated
+const (
+	// StrategyRandom generates 128-bit random hex identifiers, in no order
+	StrategyRandom = "random"
+	// StrategySequence generates prefix-1, prefix-2 and so on, in order
+	// within a process but starting over at each restart
+	StrategySequence = "sequence"
+	// StrategyULID generates ULIDs: 26 characters sorting by creation time
+	// to the millisecond, and in creation order from one generator
+	StrategyULID = "ulid"
+	// StrategyKSUID generates KSUIDs: 27 characters sorting by creation
+	// time to the second, in no order within a second
+	StrategyKSUID = "ksuid"
+	// StrategySnowflake generates Snowflake IDs: decimal 63-bit integers
+	// in creation order from one node, and roughly so across nodes
+	StrategySnowflake = "snowflake"
+)
+
+// Strategies are the known strategies, for listing in errors
+var Strategies = []string{StrategyRandom, StrategySequence, StrategyULID, StrategyKSUID, StrategySnowflake}
+
+// IntStrategies are the strategies whose IDs are integers, and so can
+// number users
+var IntStrategies = []string{StrategySequence, StrategySnowflake}
+
+// ErrUnknownStrategy is returned by New for strategies it does not know
+var ErrUnknownStrategy = errors.New("unknown ID strategy")
+
+// Options configures the Generator New creates
+type Options struct {
+	// Prefix starts sequence identifiers
+	Prefix string
+	// Node distinguishes the Snowflake IDs of each replica, from 0 to 1023.
+	// Replicas sharing a node may generate the same IDs.
+	Node  int
+	Clock clock.Clock
+}
+
+// New creates a Generator using strategy
+func New(strategy string, opts Options) (Generator, error) {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	switch strategy {
+	case StrategyRandom:
+		return NewRandom(), nil
+	case StrategySequence:
+		return NewSequence(opts.Prefix), nil
+	case StrategyULID:
+		return NewULID(opts.Clock), nil
+	case StrategyKSUID:
+		return NewKSUID(opts.Clock), nil
+	case StrategySnowflake:
+		return NewSnowflake(opts.Node, opts.Clock)
+	}
+	return nil, fmt.Errorf("%w %q, expected one of %v", ErrUnknownStrategy, strategy, Strategies)
+}
+
+// crockford is the alphabet ULIDs are written in, leaving out I, L, O and
+// U so they cannot be misread
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID is a Generator of ULIDs, 48 bits of milliseconds followed by 80
+// random bits. IDs generated in the same millisecond increment the random
+// bits of the last, so they stay in order. It is safe for concurrent use.
+type ULID struct {
+	clock clock.Clock
+
+	mutex sync.Mutex
+	last  uint64
+	// entropy is the random part of the last ID, high 16 bits then low 64
+	entropyHigh uint16
+	entropyLow  uint64
+}
+
+// NewULID creates a ULID generator timed by clk
+func NewULID(clk clock.Clock) *ULID {
+	return &ULID{clock: clk}
+}
+
+// NewID returns the next ULID
+func (u *ULID) NewID() string {
+	now := uint64(u.clock.Now().UnixMilli())
+
+	u.mutex.Lock()
+	// A clock going back keeps the last time, so IDs never go backwards
+	if now <= u.last {
+		u.entropyLow++
+		if u.entropyLow == 0 {
+			u.entropyHigh++
+		}
+		if u.entropyHigh == 0 && u.entropyLow == 0 {
+			// The random bits overflowed, so borrow the next millisecond
+			u.last++
+			u.randomize()
+		}
+	} else {
+		u.last = now
+		u.randomize()
+	}
+	high := u.last<<16 | uint64(u.entropyHigh)
+	low := u.entropyLow
+	u.mutex.Unlock()
+
+	var b [26]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = crockford[low&31]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return string(b[:])
+}
+
+// randomize draws new random bits. The caller holds the mutex.
+func (u *ULID) randomize() {
+	var b [10]byte
+	_, _ = rand.Read(b[:])
+	u.entropyHigh = binary.BigEndian.Uint16(b[:2])
+	u.entropyLow = binary.BigEndian.Uint64(b[2:])
+}
+
+// ksuidEpoch is the KSUID epoch, 2014-05-13T16:53:20Z, extending the range
+// of its 32-bit timestamps to 2150
+const ksuidEpoch = 1400000000
+
+// base62 is the alphabet KSUIDs are written in, in sort order
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+type ksuidGenerator struct {
+	clock clock.Clock
+}
+
+// NewKSUID returns a Generator of KSUIDs, 32 bits of seconds since the
+// KSUID epoch followed by 128 random bits
+func NewKSUID(clk clock.Clock) Generator {
+	return ksuidGenerator{clock: clk}
+}
+
+func (g ksuidGenerator) NewID() string {
+	var b [20]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(g.clock.Now().Unix()-ksuidEpoch))
+	_, _ = rand.Read(b[4:])
+
+	var out [27]byte
+	n, base, rem := new(big.Int).SetBytes(b[:]), big.NewInt(62), new(big.Int)
+	for i := len(out) - 1; i >= 0; i-- {
+		n.QuoRem(n, base, rem)
+		out[i] = base62[rem.Int64()]
+	}
+	return string(out[:])
+}
+
+// Snowflake layout: 41 bits of milliseconds since snowflakeEpoch, 10 of
+// node and 12 of sequence within the millisecond
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	// MaxSnowflakeNode is the highest node a Snowflake generator can have
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+)
+
+// snowflakeEpoch is 2024-01-01T00:00:00Z, leaving room until 2093
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+// Snowflake is a Generator of Snowflake IDs for one node. Up to 4096 IDs
+// are generated a millisecond; more borrow from the next millisecond rather
+// than waiting. It is safe for concurrent use.
+type Snowflake struct {
+	clock clock.Clock
+	node  int64
+
+	mutex    sync.Mutex
+	last     int64
+	sequence int64
+}
+
+// NewSnowflake creates a Snowflake generator for node, timed by clk
+func NewSnowflake(node int, clk clock.Clock) (*Snowflake, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake node %d is not between 0 and %d", node, MaxSnowflakeNode)
+	}
+	return &Snowflake{clock: clk, node: int64(node), last: -1}, nil
+}
+
+// NewID returns the next Snowflake ID
+func (s *Snowflake) NewID() string {
+	return strconv.FormatInt(s.NewInt(), 10)
+}
+
+// NewInt returns the next Snowflake ID as an integer
+func (s *Snowflake) NewInt() int64 {
+	now := s.clock.Now().UnixMilli() - snowflakeEpoch
+
+	s.mutex.Lock()
+	// A clock going back keeps the last time, so IDs never go backwards
+	if now <= s.last {
+		s.sequence++
+		if s.sequence == 1<<snowflakeSequenceBits {
+			s.last++
+			s.sequence = 0
+		}
+	} else {
+		s.last = now
+		s.sequence = 0
+	}
+	id := s.last<<(snowflakeNodeBits+snowflakeSequenceBits) | s.node<<snowflakeSequenceBits | s.sequence
+	s.mutex.Unlock()
+
+	return id
+}
+
+// ErrInvalidInt is returned by ParseInt for IDs that are not a positive
+// integer
+var ErrInvalidInt = errors.New("not an integer ID")
+
+// ParseInt parses an integer ID written as a number, as Snowflake IDs and
+// stores' own counters are, or in the string form of a sequence with
+// prefix, such as users-42
+func ParseInt(id, prefix string) (int64, error) {
+	number := strings.TrimPrefix(id, prefix+"-")
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidInt, id)
+	}
+	return n, nil
+}
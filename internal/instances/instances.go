@@ -0,0 +1,231 @@
+// Package instances keeps a registry of the running replicas. Each instance
+// registers itself and sends heartbeats, and drops out of the registry when
+// they stop, so operators can list the replicas serving traffic, check a
+// rollout reached all of them and spot replicas running a different config.
+package instances
+
+import (
+	"cmp"
+	"context"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// logger logs heartbeats that fail
+var logger = logging.Named(logging.Jobs)
+
+// Instance describes a running replica
+type Instance struct {
+	ID       string `json:"id" example:"api-7f9c4d-8080"`
+	Hostname string `json:"hostname" example:"api-7f9c4d"`
+	// Address is where the instance listens
+	Address string `json:"address" example:"10.0.0.7:8080"`
+	// Version and Revision identify the build, when it was built from a
+	// module version or a VCS checkout
+	Version   string `json:"version" example:"v1.4.0"`
+	Revision  string `json:"revision,omitempty" example:"9c0e8a1d4b7c"`
+	GoVersion string `json:"go_version" example:"go1.25.5"`
+	// ConfigFingerprint changes with the instance's config, leaving out
+	// what differs between replicas by design
+	ConfigFingerprint string    `json:"config_fingerprint" example:"3f2b9c0e8a1d4b7c"`
+	Ready             bool      `json:"ready"`
+	StartedAt         time.Time `json:"started_at"`
+	HeartbeatAt       time.Time `json:"heartbeat_at"`
+}
+
+// Registry stores the instances that sent a heartbeat recently
+type Registry interface {
+	// Put registers instance until ttl passes without another Put
+	Put(ctx context.Context, instance Instance, ttl time.Duration) error
+	// List returns the registered instances by ID
+	List(ctx context.Context) ([]Instance, error)
+	// Remove deregisters the instance id
+	Remove(ctx context.Context, id string) error
+}
+
+// Build returns the version and VCS revision of the running binary, as far
+// as the Go toolchain recorded them
+func Build() (version, revision string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown", ""
+	}
+	version = info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			revision = setting.Value
+		}
+	}
+	return version, revision
+}
+
+// GoVersion returns the version of Go the binary was built with
+func GoVersion() string {
+	return runtime.Version()
+}
+
+// Summary describes the registered instances, and whether they agree
+type Summary struct {
+	Instances []Instance `json:"instances"`
+	// Versions and ConfigFingerprints are the distinct values across the
+	// instances, sorted
+	Versions           []string `json:"versions"`
+	ConfigFingerprints []string `json:"config_fingerprints"`
+	// Consistent is true when every instance runs the same build and
+	// config
+	Consistent bool `json:"consistent"`
+}
+
+// Summarize describes instances
+func Summarize(instances []Instance) Summary {
+	summary := Summary{Instances: instances, Versions: []string{}, ConfigFingerprints: []string{}}
+	for _, instance := range instances {
+		version := instance.Version
+		if instance.Revision != "" {
+			version += "+" + instance.Revision
+		}
+		if !slices.Contains(summary.Versions, version) {
+			summary.Versions = append(summary.Versions, version)
+		}
+		if !slices.Contains(summary.ConfigFingerprints, instance.ConfigFingerprint) {
+			summary.ConfigFingerprints = append(summary.ConfigFingerprints, instance.ConfigFingerprint)
+		}
+	}
+	slices.Sort(summary.Versions)
+	slices.Sort(summary.ConfigFingerprints)
+	summary.Consistent = len(summary.Versions) <= 1 && len(summary.ConfigFingerprints) <= 1
+	return summary
+}
+
+// Memory is a Registry in process memory. It only ever lists the instance
+// itself, for running a single replica or in tests.
+type Memory struct {
+	clock clock.Clock
+
+	mutex     sync.Mutex
+	instances map[string]Instance
+	expiries  map[string]time.Time
+}
+
+// NewMemory creates an empty registry expiring instances with clk
+func NewMemory(clk clock.Clock) *Memory {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &Memory{clock: clk, instances: make(map[string]Instance), expiries: make(map[string]time.Time)}
+}
+
+func (m *Memory) Put(_ context.Context, instance Instance, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.instances[instance.ID] = instance
+	m.expiries[instance.ID] = m.clock.Now().Add(ttl)
+	return nil
+}
+
+func (m *Memory) List(context.Context) ([]Instance, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.clock.Now()
+	instances := make([]Instance, 0, len(m.instances))
+	for id, instance := range m.instances {
+		if !now.Before(m.expiries[id]) {
+			delete(m.instances, id)
+			delete(m.expiries, id)
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	slices.SortFunc(instances, byID)
+	return instances, nil
+}
+
+func (m *Memory) Remove(_ context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.instances, id)
+	delete(m.expiries, id)
+	return nil
+}
+
+func byID(a, b Instance) int {
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// HeartbeatOptions configures a Heartbeat
+type HeartbeatOptions struct {
+	// Interval is how often the instance is registered again
+	Interval time.Duration
+	// TTL is how long the instance stays registered without a heartbeat,
+	// a few intervals so one missed heartbeat does not drop it
+	TTL   time.Duration
+	Clock clock.Clock
+}
+
+// Heartbeat keeps an instance registered while it runs
+type Heartbeat struct {
+	registry Registry
+	describe func() Instance
+	opts     HeartbeatOptions
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewHeartbeat creates a heartbeat registering the instance describe returns,
+// called before every heartbeat so readiness is current
+func NewHeartbeat(registry Registry, describe func() Instance, opts HeartbeatOptions) *Heartbeat {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.TTL <= opts.Interval {
+		opts.TTL = 3 * opts.Interval
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Heartbeat{registry: registry, describe: describe, opts: opts, stop: make(chan struct{})}
+}
+
+// Start registers the instance and keeps it registered until Stop. Failed
+// heartbeats are logged and retried at the next interval, so an outage of
+// the registry does not stop the instance from serving.
+func (h *Heartbeat) Start(ctx context.Context) {
+	h.beat(ctx)
+	h.done.Go(func() {
+		ticker := time.NewTicker(h.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.beat(context.Background())
+			case <-h.stop:
+				return
+			}
+		}
+	})
+}
+
+func (h *Heartbeat) beat(ctx context.Context) {
+	instance := h.describe()
+	instance.HeartbeatAt = h.opts.Clock.Now().UTC()
+	if err := h.registry.Put(ctx, instance, h.opts.TTL); err != nil {
+		logger.WarnContext(ctx, "Failed to register instance", "instance", instance.ID, "error", err)
+	}
+}
+
+// Stop stops the heartbeats and deregisters the instance
+func (h *Heartbeat) Stop(ctx context.Context) error {
+	close(h.stop)
+	h.done.Wait()
+	return h.registry.Remove(ctx, h.describe().ID)
+}
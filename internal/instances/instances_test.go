@@ -0,0 +1,158 @@
+package instances
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+func TestRegistry(t *testing.T) {
+	// Each registry is returned with a func passing time for its expiry
+	registries := map[string]func(t *testing.T) (Registry, func(time.Duration)){
+		"memory": func(t *testing.T) (Registry, func(time.Duration)) {
+			clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC))
+			return NewMemory(clk), clk.Advance
+		},
+		"redis": func(t *testing.T) (Registry, func(time.Duration)) {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { _ = client.Close() })
+			return NewRedis(client, "instances:"), server.FastForward
+		},
+	}
+
+	for name, newRegistry := range registries {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			registry, advance := newRegistry(t)
+
+			require.NoError(t, registry.Put(ctx, Instance{ID: "b", Version: "v1"}, 30*time.Second))
+			require.NoError(t, registry.Put(ctx, Instance{ID: "a", Version: "v1"}, 10*time.Second))
+			list, err := registry.List(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Instance{{ID: "a", Version: "v1"}, {ID: "b", Version: "v1"}}, list)
+
+			// A heartbeat replaces the instance and extends its TTL
+			advance(5 * time.Second)
+			require.NoError(t, registry.Put(ctx, Instance{ID: "a", Version: "v2"}, 10*time.Second))
+			advance(8 * time.Second)
+			list, err = registry.List(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Instance{{ID: "a", Version: "v2"}, {ID: "b", Version: "v1"}}, list)
+
+			// Without one it expires
+			advance(5 * time.Second)
+			list, err = registry.List(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Instance{{ID: "b", Version: "v1"}}, list)
+
+			require.NoError(t, registry.Remove(ctx, "b"))
+			list, err = registry.List(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, list)
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []Instance
+		want      Summary
+	}{
+		{
+			name: "none",
+			want: Summary{Versions: []string{}, ConfigFingerprints: []string{}, Consistent: true},
+		},
+		{
+			name: "consistent",
+			instances: []Instance{
+				{ID: "a", Version: "v1", Revision: "abc", ConfigFingerprint: "f1"},
+				{ID: "b", Version: "v1", Revision: "abc", ConfigFingerprint: "f1"},
+			},
+			want: Summary{Versions: []string{"v1+abc"}, ConfigFingerprints: []string{"f1"}, Consistent: true},
+		},
+		{
+			name: "rollout in progress",
+			instances: []Instance{
+				{ID: "a", Version: "v2", ConfigFingerprint: "f1"},
+				{ID: "b", Version: "v1", ConfigFingerprint: "f1"},
+			},
+			want: Summary{Versions: []string{"v1", "v2"}, ConfigFingerprints: []string{"f1"}},
+		},
+		{
+			name: "config drifted",
+			instances: []Instance{
+				{ID: "a", Version: "v1", ConfigFingerprint: "f2"},
+				{ID: "b", Version: "v1", ConfigFingerprint: "f1"},
+			},
+			want: Summary{Versions: []string{"v1"}, ConfigFingerprints: []string{"f1", "f2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Instances = tt.instances
+			assert.Equal(t, tt.want, Summarize(tt.instances))
+		})
+	}
+}
+
+// failingRegistry fails every Put
+type failingRegistry struct {
+	*Memory
+	puts atomic.Int32
+}
+
+func (r *failingRegistry) Put(context.Context, Instance, time.Duration) error {
+	r.puts.Add(1)
+	return errors.New("registry unavailable")
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemory(nil)
+	var ready atomic.Bool
+	describe := func() Instance {
+		return Instance{ID: "api-1", Ready: ready.Load()}
+	}
+	heartbeat := NewHeartbeat(registry, describe, HeartbeatOptions{Interval: 10 * time.Millisecond})
+
+	// Registered as soon as it starts
+	heartbeat.Start(ctx)
+	list, err := registry.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.False(t, list[0].Ready)
+	assert.False(t, list[0].HeartbeatAt.IsZero())
+
+	// Later heartbeats report the current readiness
+	ready.Store(true)
+	assert.Eventually(t, func() bool {
+		list, err := registry.List(ctx)
+		return err == nil && len(list) == 1 && list[0].Ready
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, heartbeat.Stop(ctx))
+	list, err = registry.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestHeartbeat_RegistryFailing(t *testing.T) {
+	registry := &failingRegistry{Memory: NewMemory(nil)}
+	heartbeat := NewHeartbeat(registry, func() Instance { return Instance{ID: "api-1"} }, HeartbeatOptions{Interval: 10 * time.Millisecond})
+
+	// Failed heartbeats are retried rather than stopping the instance
+	heartbeat.Start(context.Background())
+	assert.Eventually(t, func() bool { return registry.puts.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, heartbeat.Stop(context.Background()))
+}
@@ -0,0 +1,101 @@
+package instances
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Registry shared by the instances through Redis. Each instance
+// is a key expiring with its TTL, and a set indexes the keys so listing
+// does not scan the keyspace.
+type Redis struct {
+	client redis.Cmdable
+	prefix string
+}
+
+// NewRedis creates a registry storing instances through client under keys
+// starting with prefix
+func NewRedis(client redis.Cmdable, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (r *Redis) key(id string) string {
+	return r.prefix + id
+}
+
+func (r *Redis) index() string {
+	return r.prefix + "index"
+}
+
+func (r *Redis) Put(ctx context.Context, instance Instance, ttl time.Duration) error {
+	value, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, r.key(instance.ID), value, ttl)
+	pipe.SAdd(ctx, r.index(), instance.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to register instance in Redis: %w", err)
+	}
+	return nil
+}
+
+// List returns the registered instances, dropping from the index those
+// whose key expired
+func (r *Redis) List(ctx context.Context) ([]Instance, error) {
+	ids, err := r.client.SMembers(ctx, r.index()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances in Redis: %w", err)
+	}
+	if len(ids) == 0 {
+		return []Instance{}, nil
+	}
+	slices.Sort(ids)
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = r.key(id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instances from Redis: %w", err)
+	}
+
+	instances := make([]Instance, 0, len(ids))
+	var expired []any
+	for i, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var instance Instance
+		if err := json.Unmarshal([]byte(encoded), &instance); err != nil {
+			return nil, fmt.Errorf("failed to decode instance %s: %w", ids[i], err)
+		}
+		instances = append(instances, instance)
+	}
+	if len(expired) > 0 {
+		// An instance registering again in the meantime adds itself back
+		if err := r.client.SRem(ctx, r.index(), expired...).Err(); err != nil {
+			logger.WarnContext(ctx, "Failed to drop expired instances", "error", err)
+		}
+	}
+	return instances, nil
+}
+
+func (r *Redis) Remove(ctx context.Context, id string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, r.key(id))
+	pipe.SRem(ctx, r.index(), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to deregister instance from Redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// backupTimeFormat stamps rotated files, sorting in the order they were
+// rotated
+const backupTimeFormat = "20060102T150405.000000000"
+
+// FileOptions configures a RotatingFile
+type FileOptions struct {
+	Path string
+	// MaxSize is the size in bytes at which the file is rotated; zero
+	// never rotates by size
+	MaxSize int64
+	// MaxAge is how long the file is written before it is rotated; zero
+	// never rotates by age
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept; zero keeps them all
+	MaxBackups int
+	Clock      clock.Clock
+}
+
+// RotatingFile appends log lines to a file, moving it aside to a
+// timestamped backup when it grows too large or too old
+type RotatingFile struct {
+	opts   FileOptions
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotatingFile opens opts.Path for appending, creating it and its
+// directory if needed
+func NewRotatingFile(opts FileOptions) (*RotatingFile, error) {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f := &RotatingFile{opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file, counting what it already holds towards its size.
+// The age of a file counts from when it was opened.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), f.opts.Clock.Now()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it over its
+// maximum size or it has reached its maximum age. It is called from a
+// single goroutine by Output.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	now := f.opts.Clock.Now()
+	tooLarge := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	tooOld := f.opts.MaxAge > 0 && now.Sub(f.opened) >= f.opts.MaxAge
+	if tooLarge || tooOld {
+		if err := f.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, opens a new one and removes the oldest
+// backups beyond the number kept
+func (f *RotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	if err := os.Rename(f.opts.Path, f.opts.Path+"."+now.UTC().Format(backupTimeFormat)); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	if f.opts.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups[:max(len(backups)-f.opts.MaxBackups, 0)] {
+		if err := os.Remove(backup); err != nil {
+			return fmt.Errorf("failed to remove old log file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Backups returns the paths of the rotated files, oldest first
+func (f *RotatingFile) Backups() ([]string, error) {
+	matches, err := filepath.Glob(f.opts.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := slices.DeleteFunc(matches, func(path string) bool {
+		_, err := time.Parse(backupTimeFormat, strings.TrimPrefix(path, f.opts.Path+"."))
+		return err != nil
+	})
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	return f.file.Close()
+}
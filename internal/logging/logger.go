@@ -0,0 +1,177 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// Components with loggers of their own, whose levels are set separately
+const (
+	// HTTP logs requests, their failures and panics
+	HTTP = "http"
+	// Store logs reads and writes of users and their persistence
+	Store = "store"
+	// Auth logs logins, provisioning on first login and impersonation
+	Auth = "auth"
+	// Jobs logs background work: operations, syncs, cleanups and flushes
+	Jobs = "jobs"
+	// Events logs notifications and emails sent about users
+	Events = "events"
+)
+
+// Components lists the named loggers
+var Components = []string{HTTP, Store, Auth, Jobs, Events}
+
+// Options configures logging
+type Options struct {
+	// Level is the level of the default logger, and of named loggers
+	// without their own
+	Level string
+	// Format is json or text
+	Format string
+	// Levels maps components to their own levels
+	Levels map[string]string
+}
+
+var (
+	// base is the handler every logger writes through, replaced by
+	// Configure. Loggers only hold levels, so they can be created before
+	// logging is configured.
+	base atomic.Pointer[slog.Handler]
+	// rootLevel is the level of the default logger
+	rootLevel = new(slog.LevelVar)
+	// levels holds the level of each named logger
+	levels = make(map[string]*slog.LevelVar, len(Components))
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	base.Store(&h)
+	for _, component := range Components {
+		levels[component] = new(slog.LevelVar)
+	}
+}
+
+// Named returns the logger of component, one of Components. Its records
+// carry the component as "logger".
+func Named(component string) *slog.Logger {
+	level, ok := levels[component]
+	if !ok {
+		panic(fmt.Sprintf("logging: unknown logger %q", component))
+	}
+	return slog.New(&handler{level: level}).With("logger", component)
+}
+
+// FromContext returns the logger of component, carrying the attributes of
+// the request-scoped logger in ctx, such as the request and trace IDs
+func FromContext(ctx context.Context, component string) *slog.Logger {
+	level, ok := levels[component]
+	if !ok {
+		panic(fmt.Sprintf("logging: unknown logger %q", component))
+	}
+	h := &handler{level: level}
+	switch scoped := reqctx.Logger(ctx).Handler().(type) {
+	case *handler:
+		h.wrap = scoped.wrap
+	default:
+		// Logging has not been configured, as in tests
+		h.inner = scoped
+	}
+	return slog.New(h).With("logger", component)
+}
+
+// Enabled reports whether component's logger writes records at level
+func Enabled(component string, level slog.Level) bool {
+	return level >= levels[component].Level()
+}
+
+// Configure writes logs to w in opts.Format and sets the level of each
+// logger. It also becomes the default slog logger, which the log package
+// writes through at info level.
+func Configure(w io.Writer, opts Options) error {
+	var root slog.Level
+	if err := root.UnmarshalText([]byte(opts.Level)); err != nil {
+		return fmt.Errorf("invalid logging level %q", opts.Level)
+	}
+	own := make(map[string]slog.Level, len(opts.Levels))
+	for component, text := range opts.Levels {
+		if !slices.Contains(Components, component) {
+			return fmt.Errorf("unknown logger %q in logging levels, expected one of %s", component, strings.Join(Components, ", "))
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(text)); err != nil {
+			return fmt.Errorf("invalid level %q for logger %s", text, component)
+		}
+		own[component] = level
+	}
+
+	// Loggers filter by their own level, so the handler passes everything
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch opts.Format {
+	case "json":
+		h = slog.NewJSONHandler(w, handlerOpts)
+	case "text":
+		h = slog.NewTextHandler(w, handlerOpts)
+	default:
+		return fmt.Errorf("invalid logging format %q, expected json or text", opts.Format)
+	}
+
+	base.Store(&h)
+	rootLevel.Set(root)
+	for _, component := range Components {
+		level, ok := own[component]
+		if !ok {
+			level = root
+		}
+		levels[component].Set(level)
+	}
+	slog.SetDefault(slog.New(&handler{level: rootLevel}))
+	return nil
+}
+
+// handler filters records by a logger's level and writes the rest through
+// the base handler, with the attributes and groups added to the logger
+type handler struct {
+	level slog.Leveler
+	// inner replaces the base handler, when set
+	inner slog.Handler
+	// wrap adds the logger's attributes and groups to the base handler, in
+	// the order they were added
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	next := h.inner
+	if next == nil {
+		next = *base.Load()
+	}
+	for _, wrap := range h.wrap {
+		next = wrap(next)
+	}
+	return next.Handle(ctx, record)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	return &handler{level: h.level, inner: h.inner, wrap: append(slices.Clip(h.wrap), wrap)}
+}
@@ -0,0 +1,172 @@
+// Package logging ships log output to sinks beyond standard error: rotating
+// files, syslog and an OTLP collector. Each sink gets lines through its own
+// buffer, and lines that do not fit are dropped and counted, so a slow or
+// unreachable sink never stalls the code that logs.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuffer is the number of lines buffered for each sink when none is
+// given
+const DefaultBuffer = 1024
+
+// Output is an io.Writer for log output that writes each line to the
+// console, then hands it to every sink without waiting for them
+type Output struct {
+	console io.Writer
+	buffer  int
+	sinks   []*buffered
+}
+
+// NewOutput creates an output writing to console, which may be nil, and
+// buffering up to buffer lines for each sink added
+func NewOutput(console io.Writer, buffer int) *Output {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Output{console: console, buffer: buffer}
+}
+
+// Add sends lines to sink, named name in drop counts, until Close. Sinks
+// must be added before the output is written to.
+func (o *Output) Add(name string, sink io.WriteCloser) {
+	b := &buffered{
+		name:  name,
+		sink:  sink,
+		lines: make(chan []byte, o.buffer),
+		done:  make(chan struct{}),
+	}
+	go b.drain()
+	o.sinks = append(o.sinks, b)
+}
+
+// Write writes p to the console and queues it for each sink. The log
+// package calls it once per line.
+func (o *Output) Write(p []byte) (int, error) {
+	for _, sink := range o.sinks {
+		sink.enqueue(p)
+	}
+	if o.console == nil {
+		return len(p), nil
+	}
+	return o.console.Write(p)
+}
+
+// Sinks returns the names of the sinks, in the order they were added
+func (o *Output) Sinks() []string {
+	names := make([]string, len(o.sinks))
+	for i, sink := range o.sinks {
+		names[i] = sink.name
+	}
+	return names
+}
+
+// dropCounter is implemented by sinks that drop lines themselves, such as
+// an exporter losing a batch
+type dropCounter interface {
+	Dropped() uint64
+}
+
+// Dropped returns how many lines the named sink has dropped, because its
+// buffer was full or writing to it failed
+func (o *Output) Dropped(name string) uint64 {
+	for _, sink := range o.sinks {
+		if sink.name == name {
+			return sink.droppedTotal()
+		}
+	}
+	return 0
+}
+
+// Close writes the lines still buffered and closes the sinks. Later lines
+// only reach the console.
+func (o *Output) Close() error {
+	var errs []error
+	for _, sink := range o.sinks {
+		if err := sink.close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.name, err))
+		}
+		if dropped := sink.droppedTotal(); dropped > 0 && o.console != nil {
+			fmt.Fprintf(o.console, "Dropped %d log line(s) for %s\n", dropped, sink.name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// buffered hands lines to a sink from its own goroutine
+type buffered struct {
+	name    string
+	sink    io.WriteCloser
+	lines   chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	// mu guards closing lines against lines being queued
+	mu     sync.RWMutex
+	closed bool
+	// reported is set once a failing sink has been reported, so a sink
+	// that keeps failing does not flood standard error
+	reported atomic.Bool
+}
+
+// droppedTotal counts the lines dropped here and by the sink itself
+func (b *buffered) droppedTotal() uint64 {
+	dropped := b.dropped.Load()
+	if counter, ok := b.sink.(dropCounter); ok {
+		dropped += counter.Dropped()
+	}
+	return dropped
+}
+
+// enqueue queues a copy of line, dropping it if the buffer is full
+func (b *buffered) enqueue(line []byte) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		b.dropped.Add(1)
+		return
+	}
+	select {
+	case b.lines <- append([]byte(nil), line...):
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// drain writes queued lines to the sink until the queue is closed
+func (b *buffered) drain() {
+	defer close(b.done)
+	for line := range b.lines {
+		if _, err := b.sink.Write(line); err != nil {
+			b.dropped.Add(1)
+			// Logging the failure would queue it for this sink again
+			if !b.reported.Swap(true) {
+				fmt.Fprintf(os.Stderr, "Failed to write log line to %s: %v\n", b.name, err)
+			}
+			continue
+		}
+		b.reported.Store(false)
+	}
+}
+
+// close stops queueing, waits for the queue to drain and closes the sink
+func (b *buffered) close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.lines)
+	b.mu.Unlock()
+
+	<-b.done
+	return b.sink.Close()
+}
@@ -0,0 +1,290 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// memorySink records the lines written to it, blocking writes while held
+type memorySink struct {
+	mu     sync.Mutex
+	lines  []string
+	hold   chan struct{}
+	err    error
+	closed bool
+}
+
+func (s *memorySink) Write(p []byte) (int, error) {
+	if s.hold != nil {
+		<-s.hold
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.lines = append(s.lines, string(p))
+	return len(p), nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestOutput(t *testing.T) {
+	var console bytes.Buffer
+	output := NewOutput(&console, 10)
+	first, second := &memorySink{}, &memorySink{}
+	output.Add("first", first)
+	output.Add("second", second)
+	assert.Equal(t, []string{"first", "second"}, output.Sinks())
+
+	_, err := output.Write([]byte("one\n"))
+	require.NoError(t, err)
+	_, err = output.Write([]byte("two\n"))
+	require.NoError(t, err)
+	require.NoError(t, output.Close())
+
+	// Every line reaches the console and, once closed, every sink
+	assert.Equal(t, "one\ntwo\n", console.String())
+	for _, sink := range []*memorySink{first, second} {
+		assert.Equal(t, []string{"one\n", "two\n"}, sink.lines)
+		assert.True(t, sink.closed)
+	}
+
+	// Lines after closing only reach the console
+	_, err = output.Write([]byte("three\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nthree\n", console.String())
+	assert.Equal(t, uint64(1), output.Dropped("first"))
+}
+
+func TestOutput_DropsWhenFull(t *testing.T) {
+	output := NewOutput(nil, 2)
+	slow := &memorySink{hold: make(chan struct{})}
+	failing := &memorySink{err: errors.New("disk full")}
+	output.Add("slow", slow)
+	output.Add("failing", failing)
+
+	// Writes return straight away while the slow sink is stuck, dropping
+	// what its buffer cannot hold
+	written := make(chan struct{})
+	go func() {
+		for range 10 {
+			_, _ = output.Write([]byte("line\n"))
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("writes blocked on a slow sink")
+	}
+
+	close(slow.hold)
+	require.NoError(t, output.Close())
+	// At most one line was being written and two were buffered, and every
+	// line was either written or counted
+	assert.LessOrEqual(t, len(slow.lines), 3)
+	assert.Equal(t, uint64(10-len(slow.lines)), output.Dropped("slow"))
+	assert.Equal(t, uint64(10), output.Dropped("failing"))
+	assert.Equal(t, uint64(0), output.Dropped("missing"))
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "api.log")
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	file, err := NewRotatingFile(FileOptions{Path: path, MaxSize: 10, MaxAge: time.Hour, MaxBackups: 2, Clock: clk})
+	require.NoError(t, err)
+
+	write := func(line string) {
+		t.Helper()
+		clk.Advance(time.Second)
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	read := func(path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Lines that would take the file over its size go to a new file
+	write("12345\n")
+	write("1234\n")
+	write("123\n")
+	backups, err := file.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, "12345\n", read(backups[0]))
+	assert.Equal(t, "1234\n123\n", read(path))
+
+	// Files are rotated when they get too old, however small
+	clk.Advance(time.Hour)
+	write("a\n")
+	assert.Equal(t, "a\n", read(path))
+
+	// Only the newest backups are kept
+	write("bbbbbbbbb\n")
+	backups, err = file.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, "1234\n123\n", read(backups[0]))
+	assert.Equal(t, "a\n", read(backups[1]))
+	assert.Equal(t, "bbbbbbbbb\n", read(path))
+	require.NoError(t, file.Close())
+
+	// Reopening appends, counting what the file already holds
+	file, err = NewRotatingFile(FileOptions{Path: path, MaxSize: 10, Clock: clk})
+	require.NoError(t, err)
+	write("c\n")
+	assert.Equal(t, "c\n", read(path))
+	require.NoError(t, file.Close())
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []otlpLogs
+		fail     bool
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var logs otlpLogs
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&logs))
+		requests = append(requests, logs)
+	}))
+	defer collector.Close()
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	exporter := NewOTLPExporter(OTLPOptions{
+		Endpoint:      collector.URL + "/v1/logs",
+		Headers:       map[string]string{"X-Api-Key": "secret"},
+		ServiceName:   "users",
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		Clock:         clock.NewFake(now),
+	})
+
+	// Full batches are sent straight away
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		_, err := exporter.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	mu.Lock()
+	require.Len(t, requests, 1)
+	resource := requests[0].ResourceLogs[0]
+	assert.Equal(t, []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: "users"}}}, resource.Resource.Attributes)
+	records := resource.ScopeLogs[0].LogRecords
+	require.Len(t, records, 2)
+	assert.Equal(t, "one", records[0].Body.StringValue)
+	assert.Equal(t, "1709294400000000000", records[0].TimeUnixNano)
+
+	// Batches that fail are dropped and counted
+	fail = true
+	mu.Unlock()
+	_, err := exporter.Write([]byte("four\n"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), exporter.Dropped())
+
+	// Closing sends what is left
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	_, err = exporter.Write([]byte("five\n"))
+	require.NoError(t, err)
+	require.NoError(t, exporter.Close())
+	require.Len(t, requests, 2)
+	assert.Equal(t, "five", requests[1].ResourceLogs[0].ScopeLogs[0].LogRecords[0].Body.StringValue)
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, Configure(os.Stderr, Options{Level: "debug", Format: "text"}))
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, Configure(&out, Options{
+		Level:  "info",
+		Format: "json",
+		Levels: map[string]string{Store: "debug", HTTP: "warn"},
+	}))
+
+	// Each logger writes at its own level, or at the default one
+	Named(Store).Debug("Writing user", "id", 1)
+	Named(HTTP).Info("GET /users")
+	Named(Auth).Debug("Checking token")
+	Named(Auth).Info("Created user")
+	assert.True(t, Enabled(Store, slog.LevelDebug))
+	assert.False(t, Enabled(HTTP, slog.LevelInfo))
+
+	var records []map[string]any
+	for line := range strings.SplitSeq(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	assert.Equal(t, "Writing user", records[0]["msg"])
+	assert.Equal(t, "store", records[0]["logger"])
+	assert.Equal(t, float64(1), records[0]["id"])
+	assert.Equal(t, "Created user", records[1]["msg"])
+	assert.Equal(t, "auth", records[1]["logger"])
+
+	// Loggers of a request carry its attributes
+	out.Reset()
+	require.NoError(t, Configure(&out, Options{Level: "info", Format: "text"}))
+	ctx := reqctx.WithLogger(context.Background(), slog.Default().With("request_id", "abc"))
+	FromContext(ctx, Events).Info("Sent email")
+	assert.Contains(t, out.String(), "msg=\"Sent email\" request_id=abc logger=events")
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		err  string
+	}{
+		{"bad level", Options{Level: "loud", Format: "json"}, `invalid logging level "loud"`},
+		{"bad format", Options{Level: "info", Format: "xml"}, `invalid logging format "xml"`},
+		{"unknown logger", Options{Level: "info", Format: "json", Levels: map[string]string{"db": "debug"}}, `unknown logger "db"`},
+		{"bad logger level", Options{Level: "info", Format: "json", Levels: map[string]string{Store: "chatty"}}, `invalid level "chatty" for logger store`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Configure(io.Discard, tt.opts)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// OTLPOptions configures an OTLPExporter
+type OTLPOptions struct {
+	// Endpoint is the collector's OTLP/HTTP logs URL, usually ending in
+	// /v1/logs
+	Endpoint string
+	// Headers are sent with every request, such as an API key
+	Headers     map[string]string
+	ServiceName string
+	// BatchSize is how many lines are sent in one request
+	BatchSize int
+	// FlushInterval is how often lines are sent when a batch is not full
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Clock         clock.Clock
+}
+
+// OTLPExporter sends log lines in batches to an OpenTelemetry collector,
+// as OTLP/HTTP JSON
+type OTLPExporter struct {
+	opts   OTLPOptions
+	client *http.Client
+
+	mu    sync.Mutex
+	batch []otlpRecord
+
+	dropped  atomic.Uint64
+	reported atomic.Bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// otlpRecord is a log line waiting to be sent
+type otlpRecord struct {
+	time time.Time
+	body string
+}
+
+// NewOTLPExporter creates an exporter, which sends batches that are not
+// full every opts.FlushInterval until closed
+func NewOTLPExporter(opts OTLPOptions) *OTLPExporter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	e := &OTLPExporter{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Write adds a line to the batch, sending the batch once it is full.
+// Failed batches are dropped, so Write never fails.
+func (e *OTLPExporter) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	e.batch = append(e.batch, otlpRecord{time: e.opts.Clock.Now(), body: strings.TrimSuffix(string(p), "\n")})
+	var full []otlpRecord
+	if len(e.batch) >= e.opts.BatchSize {
+		full, e.batch = e.batch, nil
+	}
+	e.mu.Unlock()
+
+	if full != nil {
+		e.send(full)
+	}
+	return len(p), nil
+}
+
+// run sends the batch every flush interval until Close
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// flush sends the lines batched so far
+func (e *OTLPExporter) flush() {
+	e.mu.Lock()
+	batch := e.batch
+	e.batch = nil
+	e.mu.Unlock()
+
+	if len(batch) > 0 {
+		e.send(batch)
+	}
+}
+
+// send posts records to the collector, counting them as dropped if it
+// cannot be reached or refuses them
+func (e *OTLPExporter) send(records []otlpRecord) {
+	if err := e.post(records); err != nil {
+		e.dropped.Add(uint64(len(records)))
+		// Logging the failure would add it to the batch that failed
+		if !e.reported.Swap(true) {
+			fmt.Fprintf(os.Stderr, "Failed to export logs: %v\n", err)
+		}
+		return
+	}
+	e.reported.Store(false)
+}
+
+func (e *OTLPExporter) post(records []otlpRecord) error {
+	body, err := json.Marshal(e.payload(records))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/HTTP JSON encoding of logs, only as much of it as lines need
+type (
+	otlpLogs struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		// Timestamps are nanoseconds since the epoch, as strings since
+		// they are 64-bit integers
+		TimeUnixNano         string    `json:"timeUnixNano"`
+		ObservedTimeUnixNano string    `json:"observedTimeUnixNano"`
+		Body                 otlpValue `json:"body"`
+	}
+)
+
+// payload returns the request body for records
+func (e *OTLPExporter) payload(records []otlpRecord) otlpLogs {
+	logRecords := make([]otlpLogRecord, len(records))
+	for i, record := range records {
+		nanos := strconv.FormatInt(record.time.UnixNano(), 10)
+		logRecords[i] = otlpLogRecord{TimeUnixNano: nanos, ObservedTimeUnixNano: nanos, Body: otlpValue{StringValue: record.body}}
+	}
+	return otlpLogs{ResourceLogs: []otlpResourceLogs{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: e.opts.ServiceName}},
+		}},
+		ScopeLogs: []otlpScopeLogs{{
+			Scope:      otlpScope{Name: "github.com/dazraf/go-api-example"},
+			LogRecords: logRecords,
+		}},
+	}}}
+}
+
+// Dropped returns how many lines were in batches that failed to send
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Close stops the periodic sends and sends the remaining lines
+func (e *OTLPExporter) Close() error {
+	close(e.stop)
+	<-e.done
+	e.flush()
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// NewSyslog connects to the syslog daemon at address over network, or to
+// the local daemon when both are empty. Lines are sent as informational
+// messages from the daemon facility, tagged with tag.
+func NewSyslog(network, address, tag string) (io.WriteCloser, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return writer, nil
+}
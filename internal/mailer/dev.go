@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+type devSender struct {
+	dir   string
+	count atomic.Uint64
+}
+
+// NewDev returns a Sender for development that logs each email instead of
+// sending it and, when dir is set, saves it there as an .eml file
+func NewDev(dir string) Sender {
+	return &devSender{dir: dir}
+}
+
+func (s *devSender) Send(ctx context.Context, msg Message) error {
+	if s.dir == "" {
+		logger.InfoContext(ctx, "Email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%d.eml", now.UTC().Format("20060102T150405"), s.count.Add(1))
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, format(msg, now), 0o644); err != nil {
+		return err
+	}
+	logger.InfoContext(ctx, "Email saved", "to", msg.To, "subject", msg.Subject, "path", path)
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package mailer sends transactional emails, such as a welcome message to
+// new users, through SMTP or, in development, to disk and the log. Emails
+// are queued and sent in the background with retries, so a slow or failing
+// mail server never delays a request.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// logger logs emails that fail to send
+var logger = logging.Named(logging.Events)
+
+// ErrQueueFull is returned when an email cannot be queued
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Message is a plain text email
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers a single email
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// QueueOptions configures a Queue
+type QueueOptions struct {
+	// Size is the number of emails that can wait to be sent
+	Size int
+	// MaxAttempts is how many times sending an email is tried
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubling after
+	// each further failure
+	InitialBackoff time.Duration
+}
+
+// Queue sends emails in the background, retrying failures
+type Queue struct {
+	sender Sender
+	opts   QueueOptions
+	jobs   chan Message
+
+	// stopping is cancelled to abandon retries when Stop runs out of time
+	stopping context.Context
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+}
+
+// NewQueue creates a queue that delivers through sender once started
+func NewQueue(sender Sender, opts QueueOptions) *Queue {
+	if opts.Size <= 0 {
+		opts.Size = 100
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+
+	stopping, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		sender:   sender,
+		opts:     opts,
+		jobs:     make(chan Message, opts.Size),
+		stopping: stopping,
+		cancel:   cancel,
+	}
+}
+
+// Enqueue queues msg for sending without waiting for it
+func (q *Queue) Enqueue(msg Message) error {
+	select {
+	case q.jobs <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Start starts sending queued emails
+func (q *Queue) Start() {
+	q.workers.Go(func() {
+		for msg := range q.jobs {
+			q.deliver(msg)
+		}
+	})
+}
+
+// Stop sends the emails already queued, giving up on those left when ctx
+// expires. Nothing may be queued once Stop has been called.
+func (q *Queue) Stop(ctx context.Context) error {
+	close(q.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return fmt.Errorf("failed to send queued emails: %w", ctx.Err())
+	}
+}
+
+// deliver sends msg, retrying with exponential backoff
+func (q *Queue) deliver(msg Message) {
+	backoff := q.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := q.sender.Send(q.stopping, msg)
+		if err == nil {
+			return
+		}
+		if attempt >= q.opts.MaxAttempts || q.stopping.Err() != nil {
+			logger.Error("Failed to send email, dropping it", "subject", msg.Subject, "to", msg.To, "attempts", attempt, "error", err)
+			return
+		}
+
+		logger.Warn("Failed to send email, retrying", "subject", msg.Subject, "to", msg.To, "attempt", attempt, "backoff", backoff, "error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-q.stopping.Done():
+			timer.Stop()
+		}
+		backoff *= 2
+	}
+}
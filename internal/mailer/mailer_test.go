@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// fakeSender records sent emails, failing the first failures attempts
+type fakeSender struct {
+	mutex    sync.Mutex
+	failures int
+	attempts int
+	sent     []Message
+}
+
+func (s *fakeSender) Send(_ context.Context, msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("connection refused")
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestQueue_RetriesFailedSends(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int
+		expectedSent  int
+		expectedTries int
+	}{
+		{name: "sends first time", failures: 0, expectedSent: 1, expectedTries: 1},
+		{name: "retries until sent", failures: 2, expectedSent: 1, expectedTries: 3},
+		{name: "drops after max attempts", failures: 5, expectedSent: 0, expectedTries: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{failures: tt.failures}
+			queue := NewQueue(sender, QueueOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+			queue.Start()
+
+			require.NoError(t, queue.Enqueue(Message{To: "john@example.com", Subject: "Hello"}))
+			require.NoError(t, queue.Stop(context.Background()))
+
+			assert.Len(t, sender.sent, tt.expectedSent)
+			assert.Equal(t, tt.expectedTries, sender.attempts)
+		})
+	}
+}
+
+func TestQueue_FullAndStopDeadline(t *testing.T) {
+	sender := &fakeSender{failures: 1000}
+	queue := NewQueue(sender, QueueOptions{Size: 1, MaxAttempts: 1000, InitialBackoff: time.Hour})
+
+	require.NoError(t, queue.Enqueue(Message{To: "a@example.com"}))
+	assert.ErrorIs(t, queue.Enqueue(Message{To: "b@example.com"}), ErrQueueFull)
+
+	// The retry is abandoned when the deadline passes
+	queue.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, queue.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestRender(t *testing.T) {
+	subject, body, err := Render(TemplateWelcome, struct{ User store.User }{store.User{Name: "John Doe", Email: "john@example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome, John Doe", subject)
+	assert.Contains(t, body, "john@example.com")
+
+	_, _, err = Render("missing", nil)
+	assert.Error(t, err)
+}
+
+func TestUserEmails(t *testing.T) {
+	sender := &fakeSender{}
+	queue := NewQueue(sender, QueueOptions{})
+	queue.Start()
+	emails := NewUserEmails(queue, "no-reply@example.com")
+
+	before := store.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+	after := store.User{ID: 1, Name: "John Doe", Email: "johnny@example.com"}
+	emails.UserCreated(context.Background(), before)
+	emails.UserUpdated(context.Background(), before, after)
+	require.NoError(t, queue.Stop(context.Background()))
+
+	require.Len(t, sender.sent, 3)
+	assert.Equal(t, "john@example.com", sender.sent[0].To)
+	assert.Equal(t, "no-reply@example.com", sender.sent[0].From)
+	assert.Equal(t, "Welcome, John Doe", sender.sent[0].Subject)
+
+	// The change is reported to both the new and the old address
+	assert.Equal(t, "johnny@example.com", sender.sent[1].To)
+	assert.Equal(t, "john@example.com", sender.sent[2].To)
+	assert.Contains(t, sender.sent[1].Body, "Email: john@example.com -> johnny@example.com")
+	assert.NotContains(t, sender.sent[1].Body, "Name:")
+}
+
+func TestDevSender_SavesEmails(t *testing.T) {
+	dir := t.TempDir()
+	sender := NewDev(dir)
+
+	err := sender.Send(context.Background(), Message{From: "a@example.com", To: "b@example.com", Subject: "Hi", Body: "Line 1\nLine 2\n"})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\n"))
+	assert.True(t, strings.HasSuffix(string(data), "\r\n\r\nLine 1\r\nLine 2\r\n"))
+}
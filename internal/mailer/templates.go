@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Email templates. Each defines a "subject" and a "body" template.
+const (
+	TemplateWelcome        = "welcome"
+	TemplateProfileChanged = "profile_changed"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// templates holds each parsed template by name
+var templates = func() map[string]*template.Template {
+	names := []string{TemplateWelcome, TemplateProfileChanged}
+	parsed := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		parsed[name] = template.Must(template.ParseFS(templateFiles, "templates/"+name+".tmpl"))
+	}
+	return parsed
+}()
+
+// Render renders the named template with data into the subject and body of
+// an email
+func Render(name string, data any) (subject, body string, err error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subjectBuf, bodyBuf strings.Builder
+	if err := tmpl.ExecuteTemplate(&subjectBuf, "subject", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	if err := tmpl.ExecuteTemplate(&bodyBuf, "body", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s body: %w", name, err)
+	}
+	return strings.TrimSpace(subjectBuf.String()), bodyBuf.String(), nil
+}
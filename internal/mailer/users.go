@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// UserEmails queues a welcome email for each new user and a notice when a
+// user's profile changes. It is a handlers.UserListener.
+type UserEmails struct {
+	queue *Queue
+	from  string
+}
+
+// NewUserEmails creates user emails sent from the given address
+func NewUserEmails(queue *Queue, from string) *UserEmails {
+	return &UserEmails{queue: queue, from: from}
+}
+
+// UserCreated welcomes the new user
+func (e *UserEmails) UserCreated(ctx context.Context, user store.User) {
+	e.send(ctx, TemplateWelcome, user.Email, struct{ User store.User }{user})
+}
+
+// UserUpdated tells the user their profile changed, at the previous address
+// too when the email changed, so a hijacked account is noticed
+func (e *UserEmails) UserUpdated(ctx context.Context, before, after store.User) {
+	data := struct{ Before, After store.User }{before, after}
+	e.send(ctx, TemplateProfileChanged, after.Email, data)
+	if before.Email != after.Email {
+		e.send(ctx, TemplateProfileChanged, before.Email, data)
+	}
+}
+
+// send renders and queues an email, logging failures as the user write has
+// already succeeded
+func (e *UserEmails) send(ctx context.Context, name, to string, data any) {
+	logger := logging.FromContext(ctx, logging.Events)
+	if to == "" {
+		return
+	}
+
+	subject, body, err := Render(name, data)
+	if err != nil {
+		logger.Error("Failed to render email", "template", name, "error", err)
+		return
+	}
+	if err := e.queue.Enqueue(Message{From: e.from, To: to, Subject: subject, Body: body}); err != nil {
+		logger.Error("Failed to queue email", "template", name, "to", to, "error", err)
+	}
+}
@@ -0,0 +1,219 @@
+// Package materialized keeps the results of expensive reads, such as large
+// sorted user lists and statistics, so requests are served from memory
+// rather than recomputed. Results are refreshed in the background, both
+// periodically and shortly after writes, and carry the time they were known
+// to be current so responses can say how stale they may be.
+package materialized
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// AsOfHeader tells clients when a response served from a materialized
+// result was last known to be current
+const AsOfHeader = "X-Data-As-Of"
+
+// logger logs failed refreshes
+var logger = logging.Named(logging.Jobs)
+
+// Compute computes a result from scratch
+type Compute func(ctx context.Context) (any, error)
+
+// Result is a computed value and the time it was known to be current
+type Result struct {
+	Value any
+	AsOf  time.Time
+}
+
+// Options configures a Store
+type Options struct {
+	// RefreshDelay is how long after Invalidate results are refreshed, so a
+	// burst of writes causes a single refresh; zero leaves them to the
+	// periodic refresh
+	RefreshDelay time.Duration
+	// IdleTimeout drops results that have not been read for this long,
+	// rather than keep refreshing them; zero keeps them
+	IdleTimeout time.Duration
+	// Revision returns a counter that changes whenever the underlying data
+	// does, when it is known. Refreshes then skip results computed at the
+	// current revision instead of recomputing them.
+	Revision func() uint64
+	Clock    clock.Clock
+}
+
+// entry is a materialized result and how to recompute it
+type entry struct {
+	compute  Compute
+	result   Result
+	revision uint64
+	readAt   time.Time
+	// stale is set by Invalidate, forcing the next refresh to recompute
+	stale bool
+}
+
+// Store holds materialized results by key
+type Store struct {
+	opts Options
+
+	mutex   sync.Mutex
+	entries map[string]*entry
+	timer   *time.Timer
+
+	// refreshing serializes refreshes, so a slow one is not overtaken
+	refreshing sync.Mutex
+}
+
+// NewStore creates an empty store
+func NewStore(opts Options) *Store {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Store{opts: opts, entries: make(map[string]*entry)}
+}
+
+// Get returns the result materialized under key, computing and keeping it
+// with compute on first use. Later calls return the kept result, however
+// stale, while refreshes replace it in the background; compute must give
+// the same result for the same key.
+func (s *Store) Get(ctx context.Context, key string, compute Compute) (Result, error) {
+	now := s.opts.Clock.Now()
+	s.mutex.Lock()
+	if e, ok := s.entries[key]; ok {
+		e.readAt = now
+		s.mutex.Unlock()
+		return e.result, nil
+	}
+	s.mutex.Unlock()
+
+	// Concurrent first reads of a key each compute it; the newest result
+	// is kept
+	e, err := s.compute(ctx, compute)
+	if err != nil {
+		return Result{}, err
+	}
+	e.readAt = now
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.entries[key]; ok && existing.result.AsOf.After(e.result.AsOf) {
+		existing.readAt = now
+		return existing.result, nil
+	}
+	s.entries[key] = e
+	return e.result, nil
+}
+
+// compute returns a new entry computed with compute. The revision is read
+// before the data, so a write in between leaves the entry to be recomputed
+// rather than kept stale under a new revision.
+func (s *Store) compute(ctx context.Context, compute Compute) (*entry, error) {
+	e := &entry{compute: compute}
+	e.result.AsOf = s.opts.Clock.Now()
+	if s.opts.Revision != nil {
+		e.revision = s.opts.Revision()
+	}
+	value, err := compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.result.Value = value
+	return e, nil
+}
+
+// Invalidate marks every result stale after a write, and schedules a
+// refresh after the refresh delay unless one is already scheduled
+func (s *Store) Invalidate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, e := range s.entries {
+		e.stale = true
+	}
+	if s.opts.RefreshDelay <= 0 || s.timer != nil || len(s.entries) == 0 {
+		return
+	}
+	s.timer = time.AfterFunc(s.opts.RefreshDelay, func() {
+		s.mutex.Lock()
+		s.timer = nil
+		s.mutex.Unlock()
+		s.Refresh(context.Background())
+	})
+}
+
+// Refresh recomputes the results that may have changed since they were
+// computed, dropping those that have been idle too long, and returns how
+// many it recomputed. A result that fails to recompute is kept as it was.
+func (s *Store) Refresh(ctx context.Context) int {
+	s.refreshing.Lock()
+	defer s.refreshing.Unlock()
+
+	now := s.opts.Clock.Now()
+	var revision uint64
+	if s.opts.Revision != nil {
+		revision = s.opts.Revision()
+	}
+
+	due := make(map[string]Compute)
+	s.mutex.Lock()
+	for key, e := range s.entries {
+		switch {
+		case s.opts.IdleTimeout > 0 && now.Sub(e.readAt) >= s.opts.IdleTimeout:
+			delete(s.entries, key)
+		case e.stale || s.opts.Revision == nil || e.revision != revision:
+			e.stale = false
+			due[key] = e.compute
+		default:
+			// Unchanged since it was computed, so still current
+			e.result.AsOf = now
+		}
+	}
+	s.mutex.Unlock()
+
+	refreshed := 0
+	for key, compute := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		e, err := s.compute(ctx, compute)
+		if err != nil {
+			logger.Error("Failed to refresh materialized view", "key", key, "error", err)
+			continue
+		}
+
+		s.mutex.Lock()
+		// Invalidations while computing carry over to the new result
+		if existing, ok := s.entries[key]; ok && !existing.result.AsOf.After(e.result.AsOf) {
+			e.readAt, e.stale = existing.readAt, existing.stale
+			s.entries[key] = e
+			refreshed++
+		}
+		s.mutex.Unlock()
+	}
+	return refreshed
+}
+
+// Run refreshes every interval until ctx is cancelled
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Refresh(ctx)
+		case <-ctx.Done():
+			s.mutex.Lock()
+			if s.timer != nil {
+				s.timer.Stop()
+				s.timer = nil
+			}
+			s.mutex.Unlock()
+			return
+		}
+	}
+}
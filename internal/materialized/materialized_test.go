@@ -0,0 +1,154 @@
+package materialized
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// counter returns a Compute giving how many times it has been called
+func counter() (Compute, *atomic.Int64) {
+	var calls atomic.Int64
+	return func(context.Context) (any, error) {
+		return calls.Add(1), nil
+	}, &calls
+}
+
+func TestStore_GetKeepsResult(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	s := NewStore(Options{Clock: clk})
+	compute, calls := counter()
+
+	result, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Value: int64(1), AsOf: start}, result)
+
+	clk.Advance(time.Minute)
+	result, err = s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Value: int64(1), AsOf: start}, result, "a kept result is served as of when it was computed")
+
+	_, err = s.Get(context.Background(), "b", compute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestStore_GetError(t *testing.T) {
+	s := NewStore(Options{})
+	failing := func(context.Context) (any, error) { return nil, errors.New("boom") }
+
+	_, err := s.Get(context.Background(), "a", failing)
+	assert.EqualError(t, err, "boom")
+
+	compute, _ := counter()
+	result, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Value, "failures are not kept")
+}
+
+func TestStore_Refresh(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	s := NewStore(Options{Clock: clk})
+	compute, _ := counter()
+	_, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+
+	clk.Advance(time.Minute)
+	assert.Equal(t, 1, s.Refresh(context.Background()))
+	result, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Value: int64(2), AsOf: start.Add(time.Minute)}, result)
+}
+
+func TestStore_RefreshSkipsUnchangedRevision(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	var revision atomic.Uint64
+	s := NewStore(Options{Clock: clk, Revision: revision.Load})
+	compute, calls := counter()
+	_, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+
+	clk.Advance(time.Minute)
+	assert.Zero(t, s.Refresh(context.Background()))
+	result, _ := s.Get(context.Background(), "a", compute)
+	assert.Equal(t, Result{Value: int64(1), AsOf: start.Add(time.Minute)}, result, "an unchanged result is current as of the refresh")
+
+	revision.Add(1)
+	assert.Equal(t, 1, s.Refresh(context.Background()))
+	assert.Equal(t, int64(2), calls.Load())
+
+	s.Invalidate()
+	assert.Equal(t, 1, s.Refresh(context.Background()), "invalidated results are recomputed even at the same revision")
+}
+
+func TestStore_RefreshKeepsResultOnError(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	s := NewStore(Options{Clock: clk})
+	fail := false
+	compute := func(context.Context) (any, error) {
+		if fail {
+			return nil, errors.New("boom")
+		}
+		return "ok", nil
+	}
+	_, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+
+	fail = true
+	clk.Advance(time.Minute)
+	assert.Zero(t, s.Refresh(context.Background()))
+	result, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Value: "ok", AsOf: start}, result)
+}
+
+func TestStore_RefreshDropsIdle(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	s := NewStore(Options{Clock: clk, IdleTimeout: time.Hour})
+	compute, calls := counter()
+	_, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+
+	clk.Advance(30 * time.Minute)
+	_, _ = s.Get(context.Background(), "a", compute)
+	clk.Advance(45 * time.Minute)
+	assert.Equal(t, 1, s.Refresh(context.Background()), "reads keep results alive")
+
+	clk.Advance(time.Hour)
+	assert.Zero(t, s.Refresh(context.Background()))
+	result, _ := s.Get(context.Background(), "a", compute)
+	assert.Equal(t, int64(3), result.Value, "dropped results are computed again")
+	assert.Equal(t, int64(3), calls.Load())
+}
+
+func TestStore_InvalidateRefreshesAfterDelay(t *testing.T) {
+	s := NewStore(Options{RefreshDelay: 10 * time.Millisecond})
+	compute, calls := counter()
+
+	s.Invalidate()
+	time.Sleep(30 * time.Millisecond)
+	assert.Zero(t, calls.Load(), "nothing to refresh")
+
+	_, err := s.Get(context.Background(), "a", compute)
+	require.NoError(t, err)
+	for range 5 {
+		s.Invalidate()
+	}
+	assert.Eventually(t, func() bool {
+		result, _ := s.Get(context.Background(), "a", compute)
+		return result.Value == int64(2)
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int64(2), calls.Load(), "a burst of writes causes one refresh")
+}
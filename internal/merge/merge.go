@@ -0,0 +1,371 @@
+// Package merge finds users who are likely the same person, by how similar
+// their names and emails are, and merges them into one: the survivor. What
+// refers to the duplicates, such as team memberships and audit entries, is
+// moved over to the survivor before the duplicates are deleted.
+package merge
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/dazraf/go-api-example/internal/email"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// DefaultThreshold is the score from which users are candidate duplicates
+const DefaultThreshold = 0.8
+
+// MaxDuplicates is the most users merged into a survivor at once
+const MaxDuplicates = 100
+
+// Reasons users are candidate duplicates
+const (
+	ReasonSameEmail    = "same email"
+	ReasonSimilarEmail = "similar email"
+	ReasonSameName     = "same name"
+	ReasonSimilarName  = "similar name"
+)
+
+var (
+	// ErrInvalid is returned for merges that name no duplicates, name the
+	// survivor as a duplicate or name a user twice
+	ErrInvalid = errors.New("invalid merge")
+	// ErrNotFound is returned for merges of users that do not exist
+	ErrNotFound = errors.New("user not found")
+)
+
+// Candidate is a pair of users who are likely duplicates
+type Candidate struct {
+	// Users are the pair, the older (lower ID) first
+	Users []store.User `json:"users"`
+	// Score is how similar the users are, from 0 to 1
+	Score float64 `json:"score" example:"0.92"`
+	// Reasons says what about the users is similar
+	Reasons []string `json:"reasons" example:"similar name,same email"`
+}
+
+// Find returns the pairs of users scoring at least threshold, most similar
+// first. Names are compared ignoring case, accents and word order; emails
+// ignoring case, how their domain is written and +tags. Users with the same
+// email are always candidates.
+func Find(users []store.User, threshold float64) []Candidate {
+	type key struct{ name, email string }
+	keys := make([]key, len(users))
+	for i, user := range users {
+		keys[i] = key{name: foldName(user.Name), email: canonicalEmail(user.Email)}
+	}
+
+	var candidates []Candidate
+	for i := range users {
+		for j := i + 1; j < len(users); j++ {
+			names := nameSimilarity(keys[i].name, keys[j].name)
+			emails := similarity(keys[i].email, keys[j].email)
+			score := (names + emails) / 2
+			if emails == 1 {
+				score = 1
+			}
+			if score < threshold {
+				continue
+			}
+
+			var reasons []string
+			switch {
+			case names == 1:
+				reasons = append(reasons, ReasonSameName)
+			case names >= threshold:
+				reasons = append(reasons, ReasonSimilarName)
+			}
+			switch {
+			case emails == 1:
+				reasons = append(reasons, ReasonSameEmail)
+			case emails >= threshold:
+				reasons = append(reasons, ReasonSimilarEmail)
+			}
+			pair := []store.User{users[i], users[j]}
+			if pair[1].ID < pair[0].ID {
+				pair[0], pair[1] = pair[1], pair[0]
+			}
+			candidates = append(candidates, Candidate{Users: pair, Score: round(score), Reasons: reasons})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b Candidate) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(a.Users[0].ID, b.Users[0].ID),
+			cmp.Compare(a.Users[1].ID, b.Users[1].ID),
+		)
+	})
+	return candidates
+}
+
+// folder strips accents, so "José" and "Jose" compare equal
+var folder = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// foldName returns name without accents, in lower case and with single
+// spaces between its words
+func foldName(name string) string {
+	folded, _, err := transform.String(folder, name)
+	if err != nil {
+		folded = name
+	}
+	return strings.Join(strings.Fields(strings.ToLower(folded)), " ")
+}
+
+// nameSimilarity is the similarity of folded names, in their order or with
+// their words sorted, whichever is higher
+func nameSimilarity(a, b string) float64 {
+	sortWords := func(name string) string {
+		words := strings.Fields(name)
+		slices.Sort(words)
+		return strings.Join(words, " ")
+	}
+	return max(similarity(a, b), similarity(sortWords(a), sortWords(b)))
+}
+
+// canonicalEmail returns the normalized address without a +tag, which
+// routes to the same mailbox
+func canonicalEmail(address string) string {
+	address = email.Normalize(address)
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return address
+	}
+	local, domain := address[:at], address[at:]
+	if plus := strings.IndexByte(local, '+'); plus > 0 {
+		local = local[:plus]
+	}
+	return local + domain
+}
+
+// similarity is 1 less the edit distance between a and b relative to the
+// longer of them, so 1 for equal strings and 0 for nothing in common
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(distance(ra, rb))/float64(longest)
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			substitution := previous[j-1]
+			if a[i-1] != b[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// round rounds score to two decimals, as finer differences mean nothing
+func round(score float64) float64 {
+	return float64(int(score*100+0.5)) / 100
+}
+
+// Remapper is implemented by whatever refers to users by ID, so merges can
+// move its references from duplicates to survivors
+type Remapper interface {
+	// RemapUser makes what refers to the user from refer to the user to
+	// instead, returning the number of references moved
+	RemapUser(from, to int64) int
+}
+
+// Request names the users to merge
+type Request struct {
+	// Survivor is the ID of the user kept
+	Survivor int64 `json:"survivor" example:"1"`
+	// Duplicates are the IDs of the users merged into the survivor
+	Duplicates []int64 `json:"duplicates" example:"2,3"`
+}
+
+// Report is the outcome of a merge
+type Report struct {
+	// Survivor is the merged user
+	Survivor store.User `json:"survivor"`
+	// Merged are the IDs of the duplicates, now deleted
+	Merged []int64 `json:"merged" example:"2,3"`
+	// Remapped is the number of references moved to the survivor, by what
+	// holds them
+	Remapped map[string]int `json:"remapped"`
+}
+
+// Merger merges users in a store
+type Merger struct {
+	users     store.UserStore
+	remappers map[string]Remapper
+	hooks     Hooks
+}
+
+// Hooks let merges write users the way the rest of the API does, such as
+// keeping deleted users restorable and purging cached responses
+type Hooks struct {
+	// Delete deletes a duplicate, in place of deleting it from the store
+	Delete func(ctx context.Context, id int64) error
+	// Updated is called with the survivor's ID once it is updated
+	Updated func(ctx context.Context, id int64)
+}
+
+// New creates a merger of the users in users
+func New(users store.UserStore) *Merger {
+	return &Merger{users: users, remappers: make(map[string]Remapper)}
+}
+
+// Remap makes merges move the references held by remapper, reporting them
+// under name
+func (m *Merger) Remap(name string, remapper Remapper) {
+	m.remappers[name] = remapper
+}
+
+// Hook makes merges delete and update users through hooks
+func (m *Merger) Hook(hooks Hooks) {
+	m.hooks = hooks
+}
+
+// Merge merges the duplicates into the survivor. The survivor keeps its
+// name and email, and takes the earliest creation and latest activity of
+// them all when the store can record them. References are remapped before
+// the duplicates are deleted, so a failure leaves nothing pointing at a
+// missing user. It returns ErrNotFound when any user is missing.
+func (m *Merger) Merge(ctx context.Context, req Request) (Report, error) {
+	if err := req.check(); err != nil {
+		return Report{}, err
+	}
+	survivor, err := m.users.GetByID(req.Survivor)
+	if err != nil {
+		return Report{}, fmt.Errorf("%w: survivor %d", ErrNotFound, req.Survivor)
+	}
+	duplicates := make([]store.User, len(req.Duplicates))
+	for i, id := range req.Duplicates {
+		duplicate, err := m.users.GetByID(id)
+		if err != nil {
+			return Report{}, fmt.Errorf("%w: duplicate %d", ErrNotFound, id)
+		}
+		duplicates[i] = *duplicate
+	}
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Remapped: make(map[string]int, len(m.remappers))}
+	for name, remapper := range m.remappers {
+		report.Remapped[name] = 0
+		for _, id := range req.Duplicates {
+			report.Remapped[name] += remapper.RemapUser(id, req.Survivor)
+		}
+	}
+
+	merged, err := m.absorb(ctx, *survivor, duplicates)
+	if err != nil {
+		return report, err
+	}
+	report.Survivor = merged
+	for _, id := range req.Duplicates {
+		if err := m.delete(ctx, id); err != nil {
+			return report, fmt.Errorf("failed to delete duplicate %d: %w", id, err)
+		}
+		report.Merged = append(report.Merged, id)
+	}
+	return report, nil
+}
+
+// delete deletes a duplicate with the Delete hook, or from the store
+// without one
+func (m *Merger) delete(ctx context.Context, id int64) error {
+	if m.hooks.Delete != nil {
+		return m.hooks.Delete(ctx, id)
+	}
+	return m.users.Delete(id)
+}
+
+// absorb gives survivor the earliest creation and latest activity of the
+// duplicates, using the store's Replacer or ActivityRecorder when it has
+// one
+func (m *Merger) absorb(ctx context.Context, survivor store.User, duplicates []store.User) (store.User, error) {
+	created, seen := survivor.CreatedAt, survivor.LastSeenAt
+	for _, duplicate := range duplicates {
+		if !duplicate.CreatedAt.IsZero() && (created.IsZero() || duplicate.CreatedAt.Before(created)) {
+			created = duplicate.CreatedAt
+		}
+		if duplicate.LastSeenAt != nil && (seen == nil || duplicate.LastSeenAt.After(*seen)) {
+			seen = duplicate.LastSeenAt
+		}
+	}
+	if created.Equal(survivor.CreatedAt) && seen == survivor.LastSeenAt {
+		return survivor, nil
+	}
+
+	switch s := m.users.(type) {
+	case store.Replacer:
+		survivor.CreatedAt, survivor.LastSeenAt = created, seen
+		replaced, err := s.Replace(survivor)
+		if err != nil {
+			return survivor, fmt.Errorf("failed to update survivor: %w", err)
+		}
+		m.updated(ctx, survivor.ID)
+		return *replaced, nil
+	case store.ActivityRecorder:
+		if seen == survivor.LastSeenAt {
+			return survivor, nil
+		}
+		if err := s.RecordActivity(map[int64]time.Time{survivor.ID: *seen}); err != nil {
+			return survivor, fmt.Errorf("failed to update survivor: %w", err)
+		}
+		m.updated(ctx, survivor.ID)
+		updated, err := m.users.GetByID(survivor.ID)
+		if err != nil {
+			return survivor, err
+		}
+		return *updated, nil
+	}
+	return survivor, nil
+}
+
+// updated calls the Updated hook, if any
+func (m *Merger) updated(ctx context.Context, id int64) {
+	if m.hooks.Updated != nil {
+		m.hooks.Updated(ctx, id)
+	}
+}
+
+// check validates the request without looking the users up
+func (r Request) check() error {
+	if len(r.Duplicates) == 0 {
+		return fmt.Errorf("%w: no duplicates", ErrInvalid)
+	}
+	if len(r.Duplicates) > MaxDuplicates {
+		return fmt.Errorf("%w: at most %d duplicates can be merged at once", ErrInvalid, MaxDuplicates)
+	}
+	seen := make(map[int64]bool, len(r.Duplicates))
+	for _, id := range r.Duplicates {
+		switch {
+		case id == r.Survivor:
+			return fmt.Errorf("%w: the survivor %d is not its own duplicate", ErrInvalid, id)
+		case seen[id]:
+			return fmt.Errorf("%w: duplicate %d is named twice", ErrInvalid, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package merge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    store.User
+		want    bool
+		reasons []string
+	}{
+		{
+			name:    "same email in another case and with a tag",
+			a:       store.User{ID: 1, Name: "John Smith", Email: "john@example.com"},
+			b:       store.User{ID: 2, Name: "J. Smith", Email: "John+news@Example.com"},
+			want:    true,
+			reasons: []string{ReasonSameEmail},
+		},
+		{
+			name:    "same internationalized email",
+			a:       store.User{ID: 1, Name: "Anna Müller", Email: "anna@bücher.de"},
+			b:       store.User{ID: 2, Name: "Anna Mueller", Email: "anna@xn--bcher-kva.de"},
+			want:    true,
+			reasons: []string{ReasonSimilarName, ReasonSameEmail},
+		},
+		{
+			name:    "accents and word order",
+			a:       store.User{ID: 1, Name: "José García", Email: "jose@example.com"},
+			b:       store.User{ID: 2, Name: "garcia jose", Email: "jgarcia@example.com"},
+			want:    true,
+			reasons: []string{ReasonSameName},
+		},
+		{
+			name:    "typo in name and longer email",
+			a:       store.User{ID: 1, Name: "John Smith", Email: "john@example.com"},
+			b:       store.User{ID: 2, Name: "Jon Smith", Email: "john.smith@example.com"},
+			want:    true,
+			reasons: []string{ReasonSimilarName},
+		},
+		{
+			name: "same name only",
+			a:    store.User{ID: 1, Name: "John Smith", Email: "john@example.com"},
+			b:    store.User{ID: 2, Name: "John Smith", Email: "smithy@other.org"},
+		},
+		{
+			name: "different people",
+			a:    store.User{ID: 1, Name: "John Smith", Email: "john@example.com"},
+			b:    store.User{ID: 2, Name: "Jane Doe", Email: "jane@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Pairs are listed the older first, whatever the order given
+			candidates := Find([]store.User{tt.b, tt.a}, DefaultThreshold)
+			if !tt.want {
+				assert.Empty(t, candidates)
+				return
+			}
+			require.Len(t, candidates, 1)
+			assert.Equal(t, []store.User{tt.a, tt.b}, candidates[0].Users)
+			assert.Equal(t, tt.reasons, candidates[0].Reasons)
+			assert.GreaterOrEqual(t, candidates[0].Score, DefaultThreshold)
+		})
+	}
+
+	users := []store.User{
+		{ID: 1, Name: "John Smith", Email: "john@example.com"},
+		{ID: 2, Name: "Jon Smith", Email: "john.smith@example.com"},
+		{ID: 3, Name: "John Smith", Email: "john+work@example.com"},
+	}
+	candidates := Find(users, DefaultThreshold)
+	require.Len(t, candidates, 3)
+	assert.Equal(t, 1.0, candidates[0].Score, "most similar first")
+	assert.Equal(t, []int64{1, 3}, []int64{candidates[0].Users[0].ID, candidates[0].Users[1].ID})
+	assert.Len(t, Find(users, 1), 1)
+}
+
+// remapper records the references it was asked to move
+type remapper map[int64]int64
+
+func (r remapper) RemapUser(from, to int64) int {
+	r[from] = to
+	return 2
+}
+
+// updateOnly hides the Replacer and ActivityRecorder of a store
+type updateOnly struct {
+	store.UserStore
+}
+
+// activityOnly hides the Replacer of a store, keeping its ActivityRecorder
+type activityOnly struct {
+	store.UserStore
+}
+
+func (s activityOnly) RecordActivity(seen map[int64]time.Time) error {
+	return s.UserStore.(store.ActivityRecorder).RecordActivity(seen)
+}
+
+func TestMerger_Merge(t *testing.T) {
+	early := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	late := early.AddDate(1, 0, 0)
+	seen := late.Add(time.Hour)
+	tests := []struct {
+		name        string
+		wrap        func(store.UserStore) store.UserStore
+		wantCreated time.Time
+		wantSeen    bool
+	}{
+		{name: "replacer", wrap: func(s store.UserStore) store.UserStore { return s }, wantCreated: early, wantSeen: true},
+		{name: "activity recorder", wrap: func(s store.UserStore) store.UserStore { return activityOnly{s} }, wantCreated: late, wantSeen: true},
+		{name: "neither", wrap: func(s store.UserStore) store.UserStore { return updateOnly{s} }, wantCreated: late},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := store.NewMemoryUserStore()
+			for _, user := range []store.User{
+				{ID: 1, Name: "John Smith", Email: "john@example.com", CreatedAt: late, UpdatedAt: late},
+				{ID: 2, Name: "Jon Smith", Email: "john.smith@example.com", CreatedAt: early, UpdatedAt: early, LastSeenAt: &seen},
+				{ID: 3, Name: "J. Smith", Email: "john+work@example.com", CreatedAt: late, UpdatedAt: late},
+			} {
+				_, err := memory.Replace(user)
+				require.NoError(t, err)
+			}
+			memberships := remapper{}
+			merger := New(tt.wrap(memory))
+			merger.Remap("memberships", memberships)
+
+			report, err := merger.Merge(context.Background(), Request{Survivor: 1, Duplicates: []int64{2, 3}})
+			require.NoError(t, err)
+			assert.Equal(t, []int64{2, 3}, report.Merged)
+			assert.Equal(t, map[string]int{"memberships": 4}, report.Remapped)
+			assert.Equal(t, remapper{2: 1, 3: 1}, memberships)
+			assert.Equal(t, "john@example.com", report.Survivor.Email, "the survivor keeps its email")
+			assert.Equal(t, tt.wantCreated, report.Survivor.CreatedAt.UTC())
+			if tt.wantSeen {
+				require.NotNil(t, report.Survivor.LastSeenAt)
+				assert.Equal(t, seen, *report.Survivor.LastSeenAt)
+			}
+
+			count, err := memory.Count()
+			require.NoError(t, err)
+			assert.Equal(t, 1, count)
+		})
+	}
+}
+
+func TestMerger_MergeInvalid(t *testing.T) {
+	memory := store.NewMemoryUserStore()
+	for _, user := range []store.User{
+		{Name: "John Smith", Email: "john@example.com"},
+		{Name: "Jon Smith", Email: "john.smith@example.com"},
+	} {
+		_, err := memory.Create(user)
+		require.NoError(t, err)
+	}
+	merger := New(memory)
+
+	tests := []struct {
+		name string
+		req  Request
+		want error
+	}{
+		{name: "no duplicates", req: Request{Survivor: 1}, want: ErrInvalid},
+		{name: "survivor as duplicate", req: Request{Survivor: 1, Duplicates: []int64{1}}, want: ErrInvalid},
+		{name: "duplicate named twice", req: Request{Survivor: 1, Duplicates: []int64{2, 2}}, want: ErrInvalid},
+		{name: "missing survivor", req: Request{Survivor: 9, Duplicates: []int64{2}}, want: ErrNotFound},
+		{name: "missing duplicate", req: Request{Survivor: 1, Duplicates: []int64{2, 9}}, want: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := merger.Merge(context.Background(), tt.req)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	// Failed merges leave every user in place
+	count, err := memory.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DashboardOptions configures a generated dashboard
+type DashboardOptions struct {
+	Title string
+	// UID identifies the dashboard in Grafana, so importing it again
+	// replaces it rather than making a copy
+	UID string
+}
+
+// Grafana dashboard model, only as much of it as the dashboard uses
+type (
+	dashboard struct {
+		UID           string     `json:"uid"`
+		Title         string     `json:"title"`
+		Tags          []string   `json:"tags"`
+		Timezone      string     `json:"timezone"`
+		Refresh       string     `json:"refresh"`
+		SchemaVersion int        `json:"schemaVersion"`
+		Time          timeRange  `json:"time"`
+		Templating    templating `json:"templating"`
+		Panels        []panel    `json:"panels"`
+	}
+	timeRange struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	templating struct {
+		List []variable `json:"list"`
+	}
+	variable struct {
+		Name       string      `json:"name"`
+		Label      string      `json:"label"`
+		Type       string      `json:"type"`
+		Query      string      `json:"query"`
+		Datasource *datasource `json:"datasource,omitempty"`
+		Refresh    int         `json:"refresh,omitempty"`
+		Multi      bool        `json:"multi,omitempty"`
+		IncludeAll bool        `json:"includeAll,omitempty"`
+		AllValue   string      `json:"allValue,omitempty"`
+	}
+	datasource struct {
+		Type string `json:"type"`
+		UID  string `json:"uid"`
+	}
+	panel struct {
+		ID          int         `json:"id"`
+		Title       string      `json:"title"`
+		Description string      `json:"description,omitempty"`
+		Type        string      `json:"type"`
+		Datasource  datasource  `json:"datasource"`
+		GridPos     gridPos     `json:"gridPos"`
+		FieldConfig fieldConfig `json:"fieldConfig"`
+		Targets     []target    `json:"targets"`
+	}
+	gridPos struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+		W int `json:"w"`
+		H int `json:"h"`
+	}
+	fieldConfig struct {
+		Defaults  fieldDefaults `json:"defaults"`
+		Overrides []any         `json:"overrides"`
+	}
+	fieldDefaults struct {
+		Unit string `json:"unit,omitempty"`
+	}
+	target struct {
+		RefID        string `json:"refId"`
+		Expr         string `json:"expr"`
+		LegendFormat string `json:"legendFormat,omitempty"`
+		Format       string `json:"format,omitempty"`
+		Exemplar     bool   `json:"exemplar,omitempty"`
+	}
+)
+
+// promDatasource is the datasource picked with the dashboard's variable
+var promDatasource = datasource{Type: "prometheus", UID: "${datasource}"}
+
+// Dashboard returns a Grafana dashboard of the RED metrics recorded by
+// Metrics, as JSON ready to import or provision
+func Dashboard(opts DashboardOptions) ([]byte, error) {
+	if opts.Title == "" {
+		opts.Title = "User API"
+	}
+	if opts.UID == "" {
+		opts.UID = "user-api-red"
+	}
+
+	selector := `job=~"$job", route=~"$route"`
+	rate := fmt.Sprintf(`sum(rate(%s{%s}[$__rate_interval]))`, RequestsTotal, selector)
+	errorRate := fmt.Sprintf(`sum(rate(%s{%s, status=~"5.."}[$__rate_interval]))`, RequestsTotal, selector)
+	quantile := func(q float64, by string) string {
+		return fmt.Sprintf(`histogram_quantile(%g, sum by (%s) (rate(%s_bucket{%s}[$__rate_interval])))`, q, by, RequestDuration, selector)
+	}
+
+	d := dashboard{
+		UID:           opts.UID,
+		Title:         opts.Title,
+		Tags:          []string{"red", "http"},
+		Timezone:      "browser",
+		Refresh:       "30s",
+		SchemaVersion: 39,
+		Time:          timeRange{From: "now-1h", To: "now"},
+		Templating: templating{List: []variable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{Name: "job", Label: "Job", Type: "query", Datasource: &promDatasource, Refresh: 2, Multi: true, IncludeAll: true, AllValue: ".*",
+				Query: fmt.Sprintf("label_values(%s, job)", RequestsTotal)},
+			{Name: "route", Label: "Route", Type: "query", Datasource: &promDatasource, Refresh: 2, Multi: true, IncludeAll: true, AllValue: ".*",
+				Query: fmt.Sprintf(`label_values(%s{job=~"$job"}, route)`, RequestsTotal)},
+		}},
+	}
+
+	// Headline numbers across the top, then rate, errors and duration by
+	// route, one row each
+	add := func(p panel, x, y, w, h int) {
+		p.ID = len(d.Panels) + 1
+		p.Datasource = promDatasource
+		p.GridPos = gridPos{X: x, Y: y, W: w, H: h}
+		p.FieldConfig.Overrides = []any{}
+		for i := range p.Targets {
+			p.Targets[i].RefID = string(rune('A' + i))
+		}
+		d.Panels = append(d.Panels, p)
+	}
+	add(panel{Title: "Request rate", Type: "stat", FieldConfig: unit("reqps"),
+		Targets: []target{{Expr: rate}}}, 0, 0, 6, 4)
+	add(panel{Title: "Error ratio", Description: "Share of requests answered with a 5xx status", Type: "stat", FieldConfig: unit("percentunit"),
+		Targets: []target{{Expr: errorRate + " / " + rate}}}, 6, 0, 6, 4)
+	add(panel{Title: "p95 latency", Type: "stat", FieldConfig: unit("s"),
+		Targets: []target{{Expr: quantile(0.95, "le")}}}, 12, 0, 6, 4)
+	add(panel{Title: "In flight", Type: "stat", FieldConfig: unit("short"),
+		Targets: []target{{Expr: fmt.Sprintf(`sum(%s{job=~"$job"})`, RequestsInFlight)}}}, 18, 0, 6, 4)
+
+	add(panel{Title: "Rate by route", Type: "timeseries", FieldConfig: unit("reqps"),
+		Targets: []target{{
+			Expr:         fmt.Sprintf(`sum by (method, route) (rate(%s{%s}[$__rate_interval]))`, RequestsTotal, selector),
+			LegendFormat: "{{method}} {{route}}",
+		}}}, 0, 4, 12, 8)
+	add(panel{Title: "Responses by status", Type: "timeseries", FieldConfig: unit("reqps"),
+		Targets: []target{{
+			Expr:         fmt.Sprintf(`sum by (status) (rate(%s{%s}[$__rate_interval]))`, RequestsTotal, selector),
+			LegendFormat: "{{status}}",
+		}}}, 12, 4, 12, 8)
+
+	add(panel{Title: "Errors by route", Description: "Requests answered with a 5xx status", Type: "timeseries", FieldConfig: unit("reqps"),
+		Targets: []target{{
+			Expr:         fmt.Sprintf(`sum by (method, route) (rate(%s{%s, status=~"5.."}[$__rate_interval]))`, RequestsTotal, selector),
+			LegendFormat: "{{method}} {{route}}",
+		}}}, 0, 12, 12, 8)
+	add(panel{Title: "Client errors by route", Description: "Requests answered with a 4xx status", Type: "timeseries", FieldConfig: unit("reqps"),
+		Targets: []target{{
+			Expr:         fmt.Sprintf(`sum by (method, route) (rate(%s{%s, status=~"4.."}[$__rate_interval]))`, RequestsTotal, selector),
+			LegendFormat: "{{method}} {{route}}",
+		}}}, 12, 12, 12, 8)
+
+	// Exemplars are shown on the latency panels, linking to traces
+	add(panel{Title: "Latency", Description: "Percentiles across the selected routes; points are exemplars linking to traces", Type: "timeseries", FieldConfig: unit("s"),
+		Targets: []target{
+			{Expr: quantile(0.5, "le"), LegendFormat: "p50", Exemplar: true},
+			{Expr: quantile(0.95, "le"), LegendFormat: "p95", Exemplar: true},
+			{Expr: quantile(0.99, "le"), LegendFormat: "p99", Exemplar: true},
+		}}, 0, 20, 12, 8)
+	add(panel{Title: "p95 latency by route", Type: "timeseries", FieldConfig: unit("s"),
+		Targets: []target{{Expr: quantile(0.95, "le, method, route"), LegendFormat: "{{method}} {{route}}", Exemplar: true}}}, 12, 20, 12, 8)
+	add(panel{Title: "Latency distribution", Type: "heatmap", FieldConfig: unit("s"),
+		Targets: []target{{
+			Expr:         fmt.Sprintf(`sum by (le) (increase(%s_bucket{%s}[$__rate_interval]))`, RequestDuration, selector),
+			LegendFormat: "{{le}}",
+			Format:       "heatmap",
+			Exemplar:     true,
+		}}}, 0, 28, 24, 8)
+
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// unit returns field config showing values in unit
+func unit(unit string) fieldConfig {
+	return fieldConfig{Defaults: fieldDefaults{Unit: unit}}
+}
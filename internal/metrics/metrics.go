@@ -0,0 +1,62 @@
+// Package metrics records RED metrics for API routes: the rate of requests,
+// how many are errors and how long they take. They are kept for Prometheus
+// to scrape, or sent to a StatsD or DogStatsD agent, through the same Sink
+// interface.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Sink receives the metrics of requests to API routes
+type Sink interface {
+	// Started records that a request began being served
+	Started()
+	// Finished records a request to the route with path pattern route that
+	// was answered with status after elapsed. ctx is the request's context.
+	Finished(ctx context.Context, method, route string, status int, elapsed time.Duration)
+}
+
+// Handler records requests to the route with path pattern route in sink,
+// rather than their paths, so IDs in paths do not each become a series
+func Handler(sink Sink, method, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sink.Started()
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		sink.Finished(r.Context(), method, route, status, time.Since(start))
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// scrape returns what p exposes, in the format asked for with accept
+func scrape(t *testing.T, p *Prometheus, accept string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	p.Exposition().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPrometheus(t *testing.T) {
+	tests := []struct {
+		name      string
+		exemplars bool
+		sampled   bool
+		exemplar  bool
+	}{
+		{name: "sampled trace", exemplars: true, sampled: true, exemplar: true},
+		{name: "unsampled trace", exemplars: true, sampled: false, exemplar: false},
+		{name: "exemplars disabled", exemplars: false, sampled: true, exemplar: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrometheus(PrometheusOptions{Buckets: []float64{0.1, 1}, Exemplars: tt.exemplars})
+			handler := Handler(p, http.MethodGet, "/api/v1/users/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/v1/users/2" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write([]byte("{}"))
+			}))
+			for _, path := range []string{"/api/v1/users/1", "/api/v1/users/2"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				trace := reqctx.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: tt.sampled}
+				handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(reqctx.WithTrace(req.Context(), trace)))
+			}
+
+			// Requests are counted by route, not path
+			text := scrape(t, p, "text/plain")
+			assert.Contains(t, text, `http_requests_total{method="GET",route="/api/v1/users/{id}",status="200"} 1`)
+			assert.Contains(t, text, `http_requests_total{method="GET",route="/api/v1/users/{id}",status="404"} 1`)
+			assert.Contains(t, text, `http_request_duration_seconds_count{method="GET",route="/api/v1/users/{id}"} 2`)
+			assert.Contains(t, text, "http_requests_in_flight 0")
+			assert.NotContains(t, text, "trace_id")
+
+			// Exemplars are only in OpenMetrics
+			open := scrape(t, p, "application/openmetrics-text; version=1.0.0")
+			if tt.exemplar {
+				assert.Contains(t, open, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`)
+			} else {
+				assert.NotContains(t, open, "trace_id")
+			}
+		})
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  StatsDOptions
+		lines []string
+	}{
+		{
+			name: "dogstatsd",
+			opts: StatsDOptions{Namespace: "api", Tags: []string{"env:test"}},
+			lines: []string{
+				"api.http.requests.in_flight:1|g|#env:test",
+				"api.http.requests.in_flight:0|g|#env:test",
+				"api.http.requests:1|c|#env:test,method:GET,route:/api/v1/users/{id},status:404",
+				"api.http.request.duration:",
+			},
+		},
+		{
+			name: "statsd",
+			opts: StatsDOptions{Format: FormatStatsD},
+			lines: []string{
+				"http.requests.in_flight:1|g",
+				"http.requests.in_flight:0|g",
+				"http.requests.GET.api_v1_users_id.404:1|c",
+				"http.request.duration.GET.api_v1_users_id:",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer agent.Close()
+
+			tt.opts.Address = agent.LocalAddr().String()
+			s, err := NewStatsD(tt.opts)
+			require.NoError(t, err)
+			handler := Handler(s, http.MethodGet, "/api/v1/users/{id}", http.NotFoundHandler())
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil))
+			require.NoError(t, s.Close())
+
+			// Lines are batched into one packet
+			buf := make([]byte, maxPacketSize)
+			require.NoError(t, agent.SetReadDeadline(time.Now().Add(time.Second)))
+			n, _, err := agent.ReadFrom(buf)
+			require.NoError(t, err)
+			lines := strings.Split(string(buf[:n]), "\n")
+			require.Len(t, lines, len(tt.lines))
+			for i, want := range tt.lines {
+				assert.True(t, strings.HasPrefix(lines[i], want), "line %q does not start with %q", lines[i], want)
+			}
+			assert.Regexp(t, `:\d+\.\d{3}\|ms`, lines[3])
+		})
+	}
+
+	_, err := NewStatsD(StatsDOptions{Address: "127.0.0.1:8125", Format: "graphite"})
+	assert.Error(t, err)
+}
+
+func TestStatsD_PacketSize(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agent.Close()
+
+	s, err := NewStatsD(StatsDOptions{Address: agent.LocalAddr().String()})
+	require.NoError(t, err)
+	for range 100 {
+		s.Finished(context.Background(), http.MethodGet, "/api/v1/users", http.StatusOK, time.Millisecond)
+	}
+	require.NoError(t, s.Close())
+
+	// Full packets are sent as lines are added, each within the limit
+	packets := 0
+	buf := make([]byte, 2*maxPacketSize)
+	for {
+		require.NoError(t, agent.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		assert.LessOrEqual(t, n, maxPacketSize)
+		packets++
+	}
+	assert.Greater(t, packets, 1)
+}
+
+func TestNameSegment(t *testing.T) {
+	assert.Equal(t, "api_v1_users_id", nameSegment("/api/v1/users/{id}"))
+	assert.Equal(t, "GET", nameSegment("GET"))
+	assert.Equal(t, "a-b_c", nameSegment("a-b..c/"))
+}
+
+func TestDashboard(t *testing.T) {
+	data, err := Dashboard(DashboardOptions{Title: "Users", UID: "users"})
+	require.NoError(t, err)
+
+	var d struct {
+		UID        string `json:"uid"`
+		Title      string `json:"title"`
+		Templating struct {
+			List []struct {
+				Name string `json:"name"`
+			} `json:"list"`
+		} `json:"templating"`
+		Panels []struct {
+			ID      int `json:"id"`
+			GridPos struct {
+				X, W int
+			} `json:"gridPos"`
+			Targets []struct {
+				RefID    string `json:"refId"`
+				Expr     string `json:"expr"`
+				Exemplar bool   `json:"exemplar"`
+			} `json:"targets"`
+		} `json:"panels"`
+	}
+	require.NoError(t, json.Unmarshal(data, &d))
+	assert.Equal(t, "users", d.UID)
+	assert.Equal(t, "Users", d.Title)
+
+	var variables []string
+	for _, v := range d.Templating.List {
+		variables = append(variables, v.Name)
+	}
+	assert.Equal(t, []string{"datasource", "job", "route"}, variables)
+
+	// Every panel has a unique ID, fits the grid and queries the metrics
+	// recorded; latency panels show exemplars
+	exemplars := 0
+	for i, p := range d.Panels {
+		assert.Equal(t, i+1, p.ID)
+		assert.LessOrEqual(t, p.GridPos.X+p.GridPos.W, 24)
+		require.NotEmpty(t, p.Targets)
+		for j, target := range p.Targets {
+			assert.Equal(t, string(rune('A'+j)), target.RefID)
+			assert.Regexp(t, RequestsTotal+"|"+RequestDuration+"|"+RequestsInFlight, target.Expr)
+			if target.Exemplar {
+				assert.Contains(t, target.Expr, RequestDuration)
+				exemplars++
+			}
+		}
+	}
+	assert.Positive(t, exemplars)
+
+	// Defaults are filled in
+	data, err = Dashboard(DashboardOptions{})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &d))
+	assert.Equal(t, "user-api-red", d.UID)
+	assert.Equal(t, "User API", d.Title)
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// Names of the Prometheus metrics recorded, shared with the generated
+// dashboards
+const (
+	RequestsTotal    = "http_requests_total"
+	RequestDuration  = "http_request_duration_seconds"
+	RequestsInFlight = "http_requests_in_flight"
+	LogLinesDropped  = "log_lines_dropped_total"
+	WatchdogAlerts   = "watchdog_alerts_total"
+
+	ReplicationConflicts = "replication_conflicts_total"
+	ReplicationDropped   = "replication_writes_dropped_total"
+	UsageDropped         = "usage_records_dropped_total"
+)
+
+// ExemplarLabel is the exemplar label holding trace IDs. Latencies of
+// sampled traces carry one, so a slow bucket on a dashboard links straight
+// to a trace that landed in it.
+const ExemplarLabel = "trace_id"
+
+// DefaultBuckets are the latency histogram buckets, in seconds, used when
+// none are configured
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PrometheusOptions configures Prometheus
+type PrometheusOptions struct {
+	// Buckets are the upper bounds of the latency histogram, in seconds
+	Buckets []float64
+	// Exemplars attaches the trace IDs of sampled requests to latencies
+	Exemplars bool
+}
+
+// Prometheus keeps metrics for Prometheus to scrape
+type Prometheus struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	inFlight  prometheus.Gauge
+	exemplars bool
+}
+
+// NewPrometheus creates metrics in their own registry, along with the Go
+// runtime and process metrics
+func NewPrometheus(opts PrometheusOptions) *Prometheus {
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = DefaultBuckets
+	}
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: RequestsTotal,
+			Help: "Requests to API routes, by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    RequestDuration,
+			Help:    "Time taken to serve requests to API routes, by method and route.",
+			Buckets: opts.Buckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: RequestsInFlight,
+			Help: "Requests to API routes being served.",
+		}),
+		exemplars: opts.Exemplars,
+	}
+	p.registry.MustRegister(
+		p.requests,
+		p.duration,
+		p.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return p
+}
+
+// Started implements Sink
+func (p *Prometheus) Started() {
+	p.inFlight.Inc()
+}
+
+// Finished implements Sink
+func (p *Prometheus) Finished(ctx context.Context, method, route string, status int, elapsed time.Duration) {
+	p.inFlight.Dec()
+	p.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	duration := p.duration.WithLabelValues(method, route)
+	if trace, ok := reqctx.Trace(ctx); ok && trace.Sampled && p.exemplars {
+		duration.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed.Seconds(), prometheus.Labels{ExemplarLabel: trace.TraceID})
+		return
+	}
+	duration.Observe(elapsed.Seconds())
+}
+
+// CounterFunc exposes a counter kept elsewhere, reading it from value when
+// scraped. Counters may share a name if their labels differ.
+func (p *Prometheus) CounterFunc(name, help string, labels map[string]string, value func() float64) {
+	p.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+	}, value))
+}
+
+// Exposition serves the recorded metrics for scraping. Exemplars are only
+// part of the OpenMetrics format, which Prometheus asks for when exemplar
+// storage is enabled.
+func (p *Prometheus) Exposition() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+		Registry:          p.registry,
+	})
+}
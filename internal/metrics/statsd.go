@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// logger logs failures to send metrics
+var logger = logging.Named(logging.Jobs)
+
+// Formats of StatsD lines
+const (
+	// FormatDogStatsD sends labels as DogStatsD tags
+	FormatDogStatsD = "dogstatsd"
+	// FormatStatsD appends labels to metric names, for agents without tags
+	FormatStatsD = "statsd"
+)
+
+// Names of the StatsD metrics sent, after the namespace
+const (
+	StatsDRequests         = "http.requests"
+	StatsDRequestDuration  = "http.request.duration"
+	StatsDRequestsInFlight = "http.requests.in_flight"
+)
+
+// maxPacketSize keeps packets within a typical MTU, so they are not
+// fragmented or dropped on the way to the agent
+const maxPacketSize = 1432
+
+// StatsDOptions configures StatsD
+type StatsDOptions struct {
+	// Address is the agent's UDP host and port
+	Address string
+	// Namespace prefixes every metric name
+	Namespace string
+	// Tags are added to every metric as "key:value", with FormatDogStatsD
+	Tags []string
+	// Format is FormatDogStatsD or FormatStatsD
+	Format string
+}
+
+// StatsD sends metrics to a StatsD or DogStatsD agent. Lines are batched
+// into packets, sent when full and by Flush.
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	dogstatsd bool
+	inFlight  atomic.Int64
+
+	mu     sync.Mutex
+	packet []byte
+}
+
+// NewStatsD creates a sink sending to the agent at opts.Address
+func NewStatsD(opts StatsDOptions) (*StatsD, error) {
+	if opts.Format == "" {
+		opts.Format = FormatDogStatsD
+	}
+	if opts.Format != FormatDogStatsD && opts.Format != FormatStatsD {
+		return nil, fmt.Errorf("unknown statsd format %q", opts.Format)
+	}
+	// Dialling UDP sends nothing, so an agent that is not running yet
+	// only loses the packets sent before it starts
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve statsd agent: %w", err)
+	}
+	s := &StatsD{
+		conn:      conn,
+		tags:      opts.Tags,
+		dogstatsd: opts.Format == FormatDogStatsD,
+		packet:    make([]byte, 0, maxPacketSize),
+	}
+	if opts.Namespace != "" {
+		s.prefix = strings.TrimSuffix(opts.Namespace, ".") + "."
+	}
+	return s, nil
+}
+
+// Started implements Sink
+func (s *StatsD) Started() {
+	s.gauge(StatsDRequestsInFlight, s.inFlight.Add(1))
+}
+
+// Finished implements Sink
+func (s *StatsD) Finished(_ context.Context, method, route string, status int, elapsed time.Duration) {
+	s.gauge(StatsDRequestsInFlight, s.inFlight.Add(-1))
+	code := strconv.Itoa(status)
+	ms := strconv.FormatFloat(float64(elapsed)/float64(time.Millisecond), 'f', 3, 64)
+	s.send(StatsDRequests, "1|c", "method", method, "route", route, "status", code)
+	s.send(StatsDRequestDuration, ms+"|ms", "method", method, "route", route)
+}
+
+// gauge sends the current value of a gauge
+func (s *StatsD) gauge(name string, value int64) {
+	s.send(name, strconv.FormatInt(value, 10)+"|g")
+}
+
+// send adds a line for metric name with value, which includes its type, and
+// labels given as name and value pairs
+func (s *StatsD) send(name, value string, labels ...string) {
+	var line strings.Builder
+	line.WriteString(s.prefix)
+	line.WriteString(name)
+	if !s.dogstatsd {
+		for i := 1; i < len(labels); i += 2 {
+			line.WriteByte('.')
+			line.WriteString(nameSegment(labels[i]))
+		}
+	}
+	line.WriteByte(':')
+	line.WriteString(value)
+	if s.dogstatsd && len(s.tags)+len(labels) > 0 {
+		line.WriteString("|#")
+		tags := append([]string(nil), s.tags...)
+		for i := 0; i+1 < len(labels); i += 2 {
+			tags = append(tags, labels[i]+":"+tagValue(labels[i+1]))
+		}
+		line.WriteString(strings.Join(tags, ","))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.packet) > 0 && len(s.packet)+1+line.Len() > maxPacketSize {
+		_ = s.flushLocked()
+	}
+	if len(s.packet) > 0 {
+		s.packet = append(s.packet, '\n')
+	}
+	s.packet = append(s.packet, line.String()...)
+}
+
+// Flush sends the lines not sent yet
+func (s *StatsD) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *StatsD) flushLocked() error {
+	if len(s.packet) == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.packet)
+	s.packet = s.packet[:0]
+	return err
+}
+
+// Run flushes every interval until ctx is done, then flushes once more
+func (s *StatsD) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				logger.Warn("Failed to send metrics", "error", err)
+			}
+		case <-ctx.Done():
+			if err := s.Flush(); err != nil {
+				logger.Warn("Failed to send metrics", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// Close flushes and closes the connection to the agent
+func (s *StatsD) Close() error {
+	err := s.Flush()
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// nameSegment turns a label value into part of a dotted metric name, so
+// "/api/v1/users/{id}" becomes "api_v1_users_id"
+func nameSegment(value string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range value {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			underscore = false
+			b.WriteRune(r)
+			continue
+		}
+		underscore = true
+	}
+	return b.String()
+}
+
+// tagValue replaces the characters that delimit DogStatsD tags
+func tagValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
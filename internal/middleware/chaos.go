@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+)
+
+// ChaosOptions configures fault injection
+type ChaosOptions struct {
+	// Latency is added before every request is handled
+	Latency time.Duration
+	// ErrorRate is the fraction of requests, from 0 to 1, failed with a 503
+	ErrorRate float64
+}
+
+// Chaos injects latency and failures to exercise clients' timeouts and
+// retries. It must never be enabled in production.
+func Chaos(opts ChaosOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if opts.Latency > 0 {
+				timer := time.NewTimer(opts.Latency)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+
+			if opts.ErrorRate > 0 && rand.Float64() < opts.ErrorRate {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(`{"error":"Injected failure","code":"` + string(apierrors.Unavailable) + `"}` + "\n"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+const (
+	// DuplicateHeader is set on responses replayed for duplicate requests
+	DuplicateHeader = "X-Duplicate-Request"
+
+	// maxDedupeBody is the largest request or response body deduplicated;
+	// larger requests are always handled, and duplicates of requests with
+	// larger responses are rejected rather than replayed
+	maxDedupeBody = 1 << 20
+)
+
+// Deduper treats identical requests from the same caller arriving within a
+// window as one, protecting against double clicks and resubmitted forms.
+// The first request is handled; duplicates wait for it to finish and get its
+// response replayed with DuplicateHeader set.
+type Deduper struct {
+	clock clock.Clock
+
+	mutex     sync.Mutex
+	requests  map[string]*dedupeEntry
+	lastSweep time.Time
+}
+
+// dedupeEntry is a request handled within its window, and its response once
+// complete
+type dedupeEntry struct {
+	expires time.Time
+	done    chan struct{}
+
+	status    int
+	header    http.Header
+	body      []byte
+	truncated bool
+	failed    bool
+}
+
+// NewDeduper creates a Deduper timing windows with clk
+func NewDeduper(clk clock.Clock) *Deduper {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &Deduper{clock: clk, requests: make(map[string]*dedupeEntry)}
+}
+
+// Middleware deduplicates requests to next arriving within window of an
+// identical one. Requests are identical when they have the same caller,
+// method, URL and body; callers are principals, or client addresses when
+// anonymous.
+func (d *Deduper) Middleware(window time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxDedupeBody+1))
+			if err != nil {
+				writeDedupeError(w, http.StatusBadRequest, apierrors.BadRequest, "Failed to read request body")
+				return
+			}
+			r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+			if len(body) > maxDedupeBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := dedupeKey(r, body)
+			entry, duplicate := d.claim(key, window)
+			if duplicate {
+				if !d.replay(w, r, entry) {
+					next.ServeHTTP(w, r)
+				}
+				return
+			}
+
+			// A panicking handler leaves no response to replay, so its
+			// duplicates are handled instead
+			completed := false
+			defer func() {
+				if !completed {
+					entry.failed = true
+					d.forget(key, entry)
+				} else if entry.status == 0 {
+					entry.status = http.StatusOK
+				}
+				close(entry.done)
+			}()
+			next.ServeHTTP(&dedupeRecorder{ResponseWriter: w, entry: entry}, r)
+			completed = true
+		})
+	}
+}
+
+// claim returns the unexpired entry for key and true, or registers a new one
+// expiring after window and returns false
+func (d *Deduper) claim(key string, window time.Duration) (*dedupeEntry, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := d.clock.Now()
+	if entry, ok := d.requests[key]; ok && now.Before(entry.expires) {
+		return entry, true
+	}
+	if now.Sub(d.lastSweep) >= time.Second {
+		for k, entry := range d.requests {
+			if !now.Before(entry.expires) {
+				delete(d.requests, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	entry := &dedupeEntry{expires: now.Add(window), done: make(chan struct{})}
+	d.requests[key] = entry
+	return entry, false
+}
+
+// forget removes key's entry, if it is still entry
+func (d *Deduper) forget(key string, entry *dedupeEntry) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.requests[key] == entry {
+		delete(d.requests, key)
+	}
+}
+
+// replay writes the response to the request entry was registered for, once
+// it completes. It returns false, writing nothing, if that request failed
+// without a response.
+func (d *Deduper) replay(w http.ResponseWriter, r *http.Request, entry *dedupeEntry) bool {
+	select {
+	case <-entry.done:
+	case <-r.Context().Done():
+		return true
+	}
+
+	if entry.failed {
+		return false
+	}
+	if entry.truncated {
+		writeDedupeError(w, http.StatusConflict, apierrors.DuplicateRequest, "Duplicate request")
+		return true
+	}
+	// Headers the duplicate already has, such as its request ID, are its own
+	for name, values := range entry.header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(DuplicateHeader, "true")
+	w.WriteHeader(entry.status)
+	_, _ = w.Write(entry.body)
+	return true
+}
+
+// dedupeKey identifies a request by its caller, tenant, method, URL and
+// body
+func dedupeKey(r *http.Request, body []byte) string {
+	caller := "anonymous:" + r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		caller = "anonymous:" + host
+	}
+	if principal, ok := reqctx.PrincipalFrom(r.Context()); ok {
+		caller = "principal:" + principal.Subject
+	}
+
+	tenant, _ := reqctx.Tenant(r.Context())
+
+	hash := sha256.New()
+	for _, part := range []string{caller, tenant, r.Method, r.URL.RequestURI()} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func writeDedupeError(w http.ResponseWriter, status int, code apierrors.Code, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`{"error":"` + message + `","code":"` + string(code) + `"}` + "\n"))
+}
+
+// readCloser reads from Reader and closes Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// dedupeRecorder copies the response into entry as it is written, up to
+// maxDedupeBody bytes
+type dedupeRecorder struct {
+	http.ResponseWriter
+	entry *dedupeEntry
+}
+
+func (r *dedupeRecorder) WriteHeader(status int) {
+	if r.entry.status == 0 {
+		r.entry.status = status
+		r.entry.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *dedupeRecorder) Write(b []byte) (int, error) {
+	if r.entry.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.entry.truncated {
+		if len(r.entry.body)+len(b) > maxDedupeBody {
+			r.entry.truncated = true
+			r.entry.body = nil
+		} else {
+			r.entry.body = append(r.entry.body, b...)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *dedupeRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
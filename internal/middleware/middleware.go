@@ -0,0 +1,82 @@
+// Package middleware provides net/http middleware that works with any of the
+// routers in the router package.
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// Middleware wraps an http.Handler with additional behaviour
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps handler with middleware so the first one listed runs first
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Logger logs the method, path, status and latency of every request
+func Logger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// The request-scoped logger carries the request and trace IDs
+		logging.FromContext(r.Context(), logging.HTTP).Info("Request",
+			"method", r.Method, "path", r.URL.Path, "status", status, "duration", time.Since(start))
+	})
+}
+
+// Recovery turns a panicking handler into a 500 response instead of a
+// dropped connection
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				logging.FromContext(r.Context(), logging.HTTP).Error("Panic serving request",
+					"method", r.Method, "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
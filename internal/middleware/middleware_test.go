@@ -0,0 +1,357 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func TestChain_RunsInOrder(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), tag("first"), tag("second"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "reuses client ID", header: "abc-123", expected: "abc-123"},
+		{name: "generates missing ID", expected: "req-1"},
+		{name: "replaces ID with whitespace", header: "abc 123", expected: "req-1"},
+		{name: "replaces oversized ID", header: strings.Repeat("a", maxRequestIDLength+1), expected: "req-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(idgen.NewSequence("req"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id, ok := reqctx.RequestID(r.Context())
+				require.True(t, ok)
+				seen = id
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, seen)
+			assert.Equal(t, tt.expected, w.Header().Get(RequestIDHeader))
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	var seen reqctx.TraceContext
+	handler := Tracing()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace, ok := reqctx.Trace(r.Context())
+		require.True(t, ok)
+		seen = trace
+	}))
+
+	t.Run("joins the caller's trace", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", seen.TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", seen.ParentSpanID)
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+seen.SpanID+"-01", w.Header().Get("traceresponse"))
+	})
+
+	t.Run("starts a trace", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Len(t, seen.TraceID, 32)
+		assert.Empty(t, seen.ParentSpanID)
+		assert.Contains(t, w.Header().Get("traceresponse"), seen.TraceID)
+	})
+}
+
+func TestChaos(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         ChaosOptions
+		expectedCode int
+	}{
+		{name: "disabled faults pass through", opts: ChaosOptions{}, expectedCode: http.StatusOK},
+		{name: "latency only", opts: ChaosOptions{Latency: time.Millisecond}, expectedCode: http.StatusOK},
+		{name: "always fail", opts: ChaosOptions{ErrorRate: 1}, expectedCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Chaos(tt.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.GreaterOrEqual(t, time.Since(start), tt.opts.Latency)
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	var tracker Tracker
+	release := make(chan struct{})
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return tracker.InFlight() == 1 }, time.Second, time.Millisecond)
+	assert.Zero(t, tracker.Served())
+
+	close(release)
+	<-done
+	assert.Zero(t, tracker.InFlight())
+	assert.Equal(t, uint64(1), tracker.Served())
+}
+
+func TestDeduper(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	deduper := NewDeduper(clk)
+	var handled atomic.Int32
+	release := make(chan struct{})
+	handler := deduper.Middleware(2 * time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("slow") {
+			<-release
+		}
+		if r.URL.Query().Has("panic") {
+			panic("failed")
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Created", strconv.Itoa(int(handled.Add(1))))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	}))
+	send := func(path, body string, principal *reqctx.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if principal != nil {
+			req = req.WithContext(reqctx.WithPrincipal(req.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	alice := &reqctx.Principal{Subject: "1"}
+	bob := &reqctx.Principal{Subject: "2"}
+
+	first := send("/users", `{"name":"A"}`, alice)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(DuplicateHeader))
+
+	// The duplicate gets the first response, without reaching the handler
+	duplicate := send("/users", `{"name":"A"}`, alice)
+	assert.Equal(t, http.StatusCreated, duplicate.Code)
+	assert.Equal(t, "true", duplicate.Header().Get(DuplicateHeader))
+	assert.Equal(t, "1", duplicate.Header().Get("X-Created"))
+	assert.Equal(t, `{"name":"A"}`, duplicate.Body.String())
+
+	// Other bodies, URLs and callers are not duplicates
+	assert.Empty(t, send("/users", `{"name":"B"}`, alice).Header().Get(DuplicateHeader))
+	assert.Empty(t, send("/users?x=1", `{"name":"A"}`, alice).Header().Get(DuplicateHeader))
+	assert.Empty(t, send("/users", `{"name":"A"}`, bob).Header().Get(DuplicateHeader))
+	assert.Empty(t, send("/users", `{"name":"A"}`, nil).Header().Get(DuplicateHeader))
+	assert.Equal(t, int32(5), handled.Load())
+
+	// Nor are the same caller's requests for another tenant
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"A"}`))
+	req = req.WithContext(reqctx.WithTenant(reqctx.WithPrincipal(req.Context(), *alice), "acme"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get(DuplicateHeader))
+	assert.Equal(t, int32(6), handled.Load())
+
+	// The window starts with the first request
+	clk.Advance(2 * time.Second)
+	assert.Empty(t, send("/users", `{"name":"A"}`, alice).Header().Get(DuplicateHeader))
+	assert.Equal(t, int32(7), handled.Load())
+
+	// Duplicates of a request in flight wait for its response
+	pending := func() int {
+		deduper.mutex.Lock()
+		defer deduper.mutex.Unlock()
+		return len(deduper.requests)
+	}
+	before := pending()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send("/users?slow", `{}`, alice) }()
+	assert.Eventually(t, func() bool { return pending() == before+1 }, time.Second, time.Millisecond)
+	go func() { done <- send("/users?slow", `{}`, alice) }()
+	close(release)
+	responses := []*httptest.ResponseRecorder{<-done, <-done}
+	assert.Equal(t, responses[0].Header().Get("X-Created"), responses[1].Header().Get("X-Created"))
+	assert.Equal(t, int32(8), handled.Load())
+
+	// Duplicates of a request that panicked are handled afresh
+	assert.Panics(t, func() { send("/users?panic", `{}`, alice) })
+	assert.Panics(t, func() { send("/users?panic", `{}`, alice) })
+}
+
+func TestSlowRequests(t *testing.T) {
+	slow := NewSlowRequests(SlowRequestOptions{Threshold: 5 * time.Millisecond})
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond)
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := slow.Handler(http.MethodGet, "/users/{id}", Timed("auth", auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stop := reqctx.Time(r.Context(), "store.GetByID")
+		if r.URL.Query().Has("slow") {
+			time.Sleep(10 * time.Millisecond)
+		}
+		stop()
+		w.WriteHeader(http.StatusNotFound)
+	})))
+	send := func(path string) []map[string]any {
+		var logs bytes.Buffer
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(reqctx.WithLogger(req.Context(), slog.New(slog.NewJSONHandler(&logs, nil))))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		var records []map[string]any
+		decoder := json.NewDecoder(&logs)
+		for decoder.More() {
+			var record map[string]any
+			require.NoError(t, decoder.Decode(&record))
+			records = append(records, record)
+		}
+		return records
+	}
+
+	// Fast requests are not logged
+	assert.Empty(t, send("/users/1"))
+
+	// Slow ones are logged with the time spent in each part
+	records := send("/users/1?slow")
+	require.Len(t, records, 1)
+	record := records[0]
+	assert.Equal(t, "Slow request", record["msg"])
+	assert.Equal(t, "/users/{id}", record["route"])
+	assert.Equal(t, float64(http.StatusNotFound), record["status"])
+	spans := record["breakdown"].(map[string]any)
+	assert.Equal(t, []string{"middleware.auth", "other", "store.GetByID"}, slices.Sorted(maps.Keys(spans)))
+	store := spans["store.GetByID"].(map[string]any)
+	assert.Equal(t, float64(1), store["calls"])
+	assert.GreaterOrEqual(t, store["duration"], float64(10*time.Millisecond))
+	authSpan := spans["middleware.auth"].(map[string]any)
+	assert.GreaterOrEqual(t, authSpan["duration"], float64(time.Millisecond))
+	assert.Less(t, authSpan["duration"], float64(10*time.Millisecond))
+}
+
+func TestSlowRequests_DumpsGoroutines(t *testing.T) {
+	dir := t.TempDir()
+	slow := NewSlowRequests(SlowRequestOptions{
+		Threshold: time.Hour,
+		Dump:      GoroutineDumpOptions{Dir: dir, P99: time.Millisecond, Window: 2, Breaches: 2, MaxDumps: 2},
+	})
+	handler := slow.Handler(http.MethodGet, "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("slow") {
+			time.Sleep(2 * time.Millisecond)
+		}
+	}))
+	send := func(path string, n int) {
+		for range n {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+	}
+	dumps := func() []string {
+		paths, err := filepath.Glob(filepath.Join(dir, "goroutines-*.txt"))
+		require.NoError(t, err)
+		return paths
+	}
+
+	// A window within the p99 resets the breaches
+	send("/?slow", 2)
+	send("/", 2)
+	send("/?slow", 2)
+	assert.Empty(t, dumps())
+
+	// Breaching windows in a row dump goroutines
+	send("/?slow", 2)
+	require.Len(t, dumps(), 1)
+	data, err := os.ReadFile(dumps()[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "goroutine ")
+
+	// Only the newest dumps are kept
+	send("/?slow", 8)
+	assert.Len(t, dumps(), 2)
+}
+
+func TestVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		expected []string
+	}{
+		{name: "adds headers", expected: []string{"Accept, Authorization"}},
+		{name: "keeps other headers", existing: []string{"Origin"}, expected: []string{"Origin", "Accept, Authorization"}},
+		{name: "skips listed headers", existing: []string{"origin, authorization"}, expected: []string{"origin, authorization", "Accept"}},
+		{name: "adds nothing when all are listed", existing: []string{"Accept", "Authorization"}, expected: []string{"Accept", "Authorization"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Vary("Accept", "Authorization")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			w := httptest.NewRecorder()
+			for _, value := range tt.existing {
+				w.Header().Add("Vary", value)
+			}
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			assert.Equal(t, tt.expected, w.Header().Values("Vary"))
+		})
+	}
+}
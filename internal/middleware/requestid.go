@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// RequestIDHeader carries the request ID to and from clients
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied IDs so they cannot bloat logs
+const maxRequestIDLength = 128
+
+// RequestID tags each request with an ID, reusing a well-formed one from the
+// client or taking one from ids, and stores it and a logger carrying it in
+// the request context
+func RequestID(ids idgen.Generator) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = ids.NewID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := reqctx.WithRequestID(r.Context(), id)
+			ctx = reqctx.WithLogger(ctx, reqctx.Logger(ctx).With("request_id", id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validRequestID reports whether a client-supplied ID is safe to reuse
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
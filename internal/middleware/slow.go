@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// logger logs goroutine dumps, which are not taken for any one request
+var logger = logging.Named(logging.HTTP)
+
+// SlowRequestOptions configures slow request detection
+type SlowRequestOptions struct {
+	// Threshold is the latency above which a request is logged with a
+	// breakdown of where its time went
+	Threshold time.Duration
+	Dump      GoroutineDumpOptions
+}
+
+// GoroutineDumpOptions configures goroutine dumps taken when the p99
+// latency stays high. The p99 is taken over each Window requests in turn,
+// and a dump is written once Breaches windows in a row exceed P99.
+type GoroutineDumpOptions struct {
+	// Dir is where dumps are written. Dumps are off when it is empty.
+	Dir string
+	// P99 is the 99th percentile latency breached, defaulting to the slow
+	// request threshold
+	P99      time.Duration
+	Window   int
+	Breaches int
+	// Cooldown is the least time between dumps
+	Cooldown time.Duration
+	// MaxDumps is how many dumps are kept, removing the oldest
+	MaxDumps int
+}
+
+// SlowRequests logs requests slower than a threshold, along with the time
+// spent in each timed part of the request, and dumps goroutines when the
+// p99 latency is breached repeatedly
+type SlowRequests struct {
+	opts SlowRequestOptions
+
+	mu        sync.Mutex
+	window    []time.Duration
+	breaches  int
+	lastDump  time.Time
+	dumpCount int
+}
+
+// NewSlowRequests creates slow request detection with opts
+func NewSlowRequests(opts SlowRequestOptions) *SlowRequests {
+	if opts.Dump.P99 <= 0 {
+		opts.Dump.P99 = opts.Threshold
+	}
+	if opts.Dump.Window <= 0 {
+		opts.Dump.Window = 100
+	}
+	if opts.Dump.Breaches <= 0 {
+		opts.Dump.Breaches = 3
+	}
+	if opts.Dump.MaxDumps <= 0 {
+		opts.Dump.MaxDumps = 10
+	}
+	return &SlowRequests{opts: opts, window: make([]time.Duration, 0, opts.Dump.Window)}
+}
+
+// Handler times requests to the route method and route, served by next.
+// Requests carry timings for the parts of them to time themselves in, such
+// as middleware wrapped by Timed and store calls.
+func (s *SlowRequests) Handler(method, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, timings := reqctx.WithTimings(r.Context())
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		elapsed := time.Since(start)
+
+		if elapsed > s.opts.Threshold {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			logging.FromContext(r.Context(), logging.HTTP).Warn("Slow request",
+				"method", method, "route", route, "path", r.URL.Path, "status", status,
+				"duration", elapsed, "threshold", s.opts.Threshold, "breakdown", breakdown(timings.Spans(), elapsed))
+		}
+		if s.opts.Dump.Dir != "" {
+			s.observe(elapsed)
+		}
+	})
+}
+
+// breakdown groups the time spent in each span, with the rest of elapsed
+// as "other"
+func breakdown(spans []reqctx.Span, elapsed time.Duration) slog.Value {
+	attrs := make([]slog.Attr, 0, len(spans)+1)
+	other := elapsed
+	for _, span := range spans {
+		attrs = append(attrs, slog.Group(span.Name, "duration", span.Duration, "calls", span.Calls))
+		other -= span.Duration
+	}
+	attrs = append(attrs, slog.Duration("other", other))
+	return slog.GroupValue(attrs...)
+}
+
+// observe adds a latency to the window, checking the p99 of each full one
+func (s *SlowRequests) observe(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.window = append(s.window, elapsed)
+	if len(s.window) < s.opts.Dump.Window {
+		return
+	}
+	slices.Sort(s.window)
+	p99 := s.window[int(math.Ceil(0.99*float64(len(s.window))))-1]
+	s.window = s.window[:0]
+
+	if p99 <= s.opts.Dump.P99 {
+		s.breaches = 0
+		return
+	}
+	s.breaches++
+	if s.breaches < s.opts.Dump.Breaches || time.Since(s.lastDump) < s.opts.Dump.Cooldown {
+		return
+	}
+	s.breaches = 0
+	s.lastDump = time.Now()
+	path, err := s.dump()
+	if err != nil {
+		logger.Error("Failed to dump goroutines", "error", err)
+		return
+	}
+	logger.Warn("Dumped goroutines after repeated p99 breaches",
+		"p99", p99, "objective", s.opts.Dump.P99, "windows", s.opts.Dump.Breaches, "path", path)
+}
+
+// dump writes every goroutine's stack to a new file in the dump directory,
+// removing the oldest dumps beyond the most kept
+func (s *SlowRequests) dump() (string, error) {
+	if err := os.MkdirAll(s.opts.Dump.Dir, 0o755); err != nil {
+		return "", err
+	}
+	// The count keeps names unique and ordered within a second
+	s.dumpCount++
+	name := fmt.Sprintf("goroutines-%s-%04d.txt", time.Now().UTC().Format("20060102T150405"), s.dumpCount)
+	path := filepath.Join(s.opts.Dump.Dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := pprof.Lookup("goroutine").WriteTo(file, 2); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	dumps, err := filepath.Glob(filepath.Join(s.opts.Dump.Dir, "goroutines-*.txt"))
+	if err != nil {
+		return "", err
+	}
+	slices.Sort(dumps)
+	for _, old := range dumps[:max(len(dumps)-s.opts.Dump.MaxDumps, 0)] {
+		if err := os.Remove(old); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// Timed times the middleware m as the span "middleware.<name>" of requests
+// recording timings, leaving out the time spent in the handlers it wraps
+func Timed(name string, m Middleware) Middleware {
+	span := "middleware." + name
+	return func(next http.Handler) http.Handler {
+		inner := m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timings, ok := reqctx.TimingsFrom(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			timings.Exclude(span, time.Since(start))
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer reqctx.Time(r.Context(), span)()
+			inner.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/tracing"
+)
+
+// Tracing joins the caller's trace from W3C traceparent or B3 headers, or
+// starts a new one, and gives the request its own span. The trace context
+// and a logger carrying its IDs are stored in the request context, and the
+// span is returned to the caller in the traceresponse header.
+func Tracing() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			trace := tracing.StartSpan(tracing.Extract(r.Header))
+			w.Header().Set(tracing.TraceResponseHeader, tracing.FormatTraceParent(trace))
+
+			ctx := reqctx.WithTrace(r.Context(), trace)
+			ctx = reqctx.WithLogger(ctx, reqctx.Logger(ctx).With("trace_id", trace.TraceID, "span_id", trace.SpanID))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
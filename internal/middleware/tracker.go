@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Tracker counts requests in flight and served, e.g. to report what was
+// drained during shutdown
+type Tracker struct {
+	inFlight atomic.Int64
+	served   atomic.Uint64
+}
+
+// Middleware counts each request passing through next
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.inFlight.Add(1)
+		defer func() {
+			t.inFlight.Add(-1)
+			t.served.Add(1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InFlight returns the number of requests being handled
+func (t *Tracker) InFlight() int64 {
+	return t.inFlight.Load()
+}
+
+// Served returns the number of requests completed
+func (t *Tracker) Served() uint64 {
+	return t.served.Load()
+}
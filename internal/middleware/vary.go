@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Vary adds headers to the Vary header of every response, telling caches
+// which request headers the response depends on, e.g. Authorization so one
+// caller's response is never served to another. Headers already listed
+// are not added again.
+func Vary(headers ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var listed []string
+			for _, value := range w.Header().Values("Vary") {
+				for _, header := range strings.Split(value, ",") {
+					listed = append(listed, http.CanonicalHeaderKey(strings.TrimSpace(header)))
+				}
+			}
+			var missing []string
+			for _, header := range headers {
+				if !slices.Contains(listed, http.CanonicalHeaderKey(header)) {
+					missing = append(missing, header)
+					listed = append(listed, http.CanonicalHeaderKey(header))
+				}
+			}
+			if len(missing) > 0 {
+				w.Header().Add("Vary", strings.Join(missing, ", "))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
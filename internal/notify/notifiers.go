@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// logger logs notifications that are only logged
+var logger = logging.Named(logging.Events)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook body, keyed with
+// the shared secret
+const SignatureHeader = "X-Signature-256"
+
+type logNotifier struct{}
+
+// NewLog returns a Notifier that logs notifications, for development
+func NewLog() Notifier {
+	return logNotifier{}
+}
+
+func (logNotifier) Notify(ctx context.Context, n Notification) error {
+	logger.InfoContext(ctx, "Notification", "event", n.Event, "user_id", n.UserID, "message", n.Message)
+	return nil
+}
+
+type webhookNotifier struct {
+	url    string
+	secret string
+}
+
+// NewWebhook returns a Notifier that POSTs each notification as JSON to
+// url, signed with secret when one is set
+func NewWebhook(url, secret string) Notifier {
+	return &webhookNotifier{url: url, secret: secret}
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature of a webhook body, so receivers can check it
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type twilioStub struct {
+	from string
+}
+
+// NewTwilioStub returns an SMS Notifier with the shape of a Twilio client
+// that logs messages instead of calling the Twilio API, standing in until a
+// real account is configured
+func NewTwilioStub(from string) Notifier {
+	return &twilioStub{from: from}
+}
+
+func (t *twilioStub) Notify(ctx context.Context, n Notification) error {
+	if n.Phone == "" {
+		return ErrNoRecipient
+	}
+	logger.InfoContext(ctx, "SMS (Twilio stub, not sent)", "from", t.from, "to", n.Phone, "message", n.Message)
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package notify delivers short notifications about domain events to users
+// over channels such as SMS or a webhook. Which channels an event uses is
+// configured per event type, and users can opt out of channels.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// Events that trigger notifications
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+)
+
+// Channels notifications can be sent over
+const (
+	ChannelLog     = "log"
+	ChannelWebhook = "webhook"
+	ChannelSMS     = "sms"
+)
+
+// notifyTimeout bounds a single delivery
+const notifyTimeout = 10 * time.Second
+
+// ErrNoRecipient is returned by notifiers that cannot reach the user, e.g.
+// SMS to a user without a phone number
+var ErrNoRecipient = errors.New("user has no address for this channel")
+
+// Notification is a message about an event, addressed to a user
+type Notification struct {
+	Event   string    `json:"event" example:"user.updated"`
+	UserID  int64     `json:"user_id" example:"1"`
+	Email   string    `json:"email,omitempty" example:"john@example.com"`
+	Phone   string    `json:"phone,omitempty" example:"+447700900123"`
+	Message string    `json:"message" example:"Your profile was updated"`
+	Time    time.Time `json:"time" example:"2024-01-02T15:04:05Z"`
+}
+
+// Notifier delivers notifications over one channel
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Dispatcher turns user events into notifications, sending each over the
+// channels configured for its event type that the user has not opted out
+// of. It is a handlers.UserListener.
+type Dispatcher struct {
+	channels    map[string]Notifier
+	routes      map[string][]string
+	preferences PreferenceStore
+	now         func() time.Time
+
+	pending sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher sending each event type in routes over
+// the named channels
+func NewDispatcher(channels map[string]Notifier, routes map[string][]string, preferences PreferenceStore) (*Dispatcher, error) {
+	for event, names := range routes {
+		for _, name := range names {
+			if _, ok := channels[name]; !ok {
+				return nil, fmt.Errorf("event %s uses unknown notification channel %q", event, name)
+			}
+		}
+	}
+	return &Dispatcher{channels: channels, routes: routes, preferences: preferences, now: time.Now}, nil
+}
+
+// UserCreated notifies the new user
+func (d *Dispatcher) UserCreated(ctx context.Context, user store.User) {
+	d.Dispatch(ctx, EventUserCreated, user, fmt.Sprintf("Welcome, %s", user.Name))
+}
+
+// UserUpdated notifies the user that their profile changed
+func (d *Dispatcher) UserUpdated(ctx context.Context, _, after store.User) {
+	d.Dispatch(ctx, EventUserUpdated, after, "Your profile was updated")
+}
+
+// Dispatch sends message about event to user in the background
+func (d *Dispatcher) Dispatch(ctx context.Context, event string, user store.User, message string) {
+	prefs, err := d.preferences.Get(user.ID)
+	if err != nil {
+		reqctx.Logger(ctx).Error("Failed to load notification preferences", "user_id", user.ID, "error", err)
+		return
+	}
+
+	n := Notification{
+		Event:   event,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Phone:   prefs.Phone,
+		Message: message,
+		Time:    d.now().UTC(),
+	}
+	logger := logging.FromContext(ctx, logging.Events)
+	for _, name := range d.routes[event] {
+		if !prefs.Allows(name) {
+			continue
+		}
+		notifier := d.channels[name]
+		// Delivery outlives the request that triggered it
+		d.pending.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, n); err != nil && !errors.Is(err, ErrNoRecipient) {
+				logger.Error("Failed to send notification", "event", event, "channel", name, "user_id", user.ID, "error", err)
+			}
+		})
+	}
+}
+
+// Wait waits for notifications being sent, until ctx expires
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// recordingNotifier records the notifications it is sent
+type recordingNotifier struct {
+	mutex sync.Mutex
+	sent  []Notification
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, n Notification) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestDispatcher_RoutesEventsAndHonoursPreferences(t *testing.T) {
+	logs, sms := &recordingNotifier{}, &recordingNotifier{}
+	preferences := NewMemoryPreferenceStore()
+	require.NoError(t, preferences.Set(2, Preferences{Phone: "+447700900123", DisabledChannels: []string{ChannelLog}}))
+
+	dispatcher, err := NewDispatcher(
+		map[string]Notifier{ChannelLog: logs, ChannelSMS: sms},
+		map[string][]string{
+			EventUserCreated: {ChannelLog},
+			EventUserUpdated: {ChannelLog, ChannelSMS},
+		},
+		preferences,
+	)
+	require.NoError(t, err)
+
+	john := store.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+	jane := store.User{ID: 2, Name: "Jane Smith", Email: "jane@example.com"}
+	dispatcher.UserCreated(context.Background(), john)
+	dispatcher.UserUpdated(context.Background(), jane, jane)
+	require.NoError(t, dispatcher.Wait(context.Background()))
+
+	// Jane opted out of log notifications
+	require.Len(t, logs.sent, 1)
+	assert.Equal(t, EventUserCreated, logs.sent[0].Event)
+	assert.Equal(t, "Welcome, John Doe", logs.sent[0].Message)
+
+	require.Len(t, sms.sent, 1)
+	assert.Equal(t, EventUserUpdated, sms.sent[0].Event)
+	assert.Equal(t, "+447700900123", sms.sent[0].Phone)
+}
+
+func TestNewDispatcher_RejectsUnknownChannel(t *testing.T) {
+	_, err := NewDispatcher(map[string]Notifier{ChannelLog: NewLog()}, map[string][]string{EventUserCreated: {ChannelWebhook}}, NewMemoryPreferenceStore())
+	assert.ErrorContains(t, err, `unknown notification channel "webhook"`)
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		received  Notification
+		signature string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		assert.Equal(t, Sign("secret", body), signature)
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	n := Notification{Event: EventUserUpdated, UserID: 1, Message: "Your profile was updated"}
+	require.NoError(t, NewWebhook(server.URL, "secret").Notify(context.Background(), n))
+	assert.Equal(t, n.Message, received.Message)
+	assert.NotEmpty(t, signature)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewWebhook(failing.URL, "").Notify(context.Background(), n))
+}
+
+func TestTwilioStub_NeedsPhone(t *testing.T) {
+	sms := NewTwilioStub("+15005550006")
+	assert.ErrorIs(t, sms.Notify(context.Background(), Notification{UserID: 1}), ErrNoRecipient)
+	assert.NoError(t, sms.Notify(context.Background(), Notification{UserID: 1, Phone: "+447700900123"}))
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"slices"
+	"sync"
+)
+
+// Preferences are a user's notification settings
+type Preferences struct {
+	// Phone receives SMS notifications
+	Phone string `json:"phone,omitempty" example:"+447700900123"`
+	// DisabledChannels are channels the user has opted out of
+	DisabledChannels []string `json:"disabled_channels" example:"sms"`
+}
+
+// Allows reports whether the user accepts notifications over channel
+func (p Preferences) Allows(channel string) bool {
+	return !slices.Contains(p.DisabledChannels, channel)
+}
+
+// PreferenceStore holds users' notification preferences
+type PreferenceStore interface {
+	// Get returns a user's preferences, the defaults if none were set
+	Get(userID int64) (Preferences, error)
+	Set(userID int64, prefs Preferences) error
+}
+
+// MemoryPreferenceStore keeps preferences in memory
+type MemoryPreferenceStore struct {
+	mutex sync.RWMutex
+	prefs map[int64]Preferences
+}
+
+// NewMemoryPreferenceStore creates an empty preference store
+func NewMemoryPreferenceStore() *MemoryPreferenceStore {
+	return &MemoryPreferenceStore{prefs: make(map[int64]Preferences)}
+}
+
+// Get returns a user's preferences, which allow every channel by default
+func (s *MemoryPreferenceStore) Get(userID int64) (Preferences, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	prefs := s.prefs[userID]
+	prefs.DisabledChannels = slices.Clone(prefs.DisabledChannels)
+	if prefs.DisabledChannels == nil {
+		prefs.DisabledChannels = []string{}
+	}
+	return prefs, nil
+}
+
+// Set replaces a user's preferences
+func (s *MemoryPreferenceStore) Set(userID int64, prefs Preferences) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prefs.DisabledChannels = slices.Clone(prefs.DisabledChannels)
+	s.prefs[userID] = prefs
+	return nil
+}
@@ -0,0 +1,410 @@
+// Package operations runs long-running work in the background and tracks it
+// as operations that clients poll, cancel and delete, following the shape of
+// Google's long-running operations: a name, metadata describing progress,
+// done, and either an error or a response once finished. Operations run on
+// a fixed pool of workers and are forgotten a while after they finish.
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// logger logs operations that fail and their cleanup
+var logger = logging.Named(logging.Jobs)
+
+// NamePrefix starts every operation's name, followed by its ID
+const NamePrefix = "operations/"
+
+// Operation states
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// Error codes, from google.rpc.Code
+const (
+	CodeCancelled = 1
+	CodeUnknown   = 2
+	CodeAborted   = 10
+)
+
+var (
+	// ErrNotFound is returned for unknown operations, including those no
+	// longer retained
+	ErrNotFound = errors.New("operation not found")
+	// ErrQueueFull is returned when an operation cannot be queued
+	ErrQueueFull = errors.New("operation queue is full")
+	// ErrStopped is returned when submitting to a stopped manager
+	ErrStopped = errors.New("operation manager is stopped")
+	// ErrDone is returned when cancelling an operation that has finished
+	ErrDone = errors.New("operation is already done")
+	// ErrNotDone is returned when deleting an operation that has not
+	// finished
+	ErrNotDone = errors.New("operation is not done")
+)
+
+// Operation is background work and, once done, its outcome
+type Operation struct {
+	Name     string   `json:"name" example:"operations/3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Metadata Metadata `json:"metadata"`
+	Done     bool     `json:"done" example:"true"`
+	// Error is why the operation failed or was cancelled
+	Error *Status `json:"error,omitempty"`
+	// Response is what the operation produced, once it has succeeded
+	Response any `json:"response,omitempty"`
+	// Owner is the caller that submitted the operation, if any
+	Owner string `json:"-"`
+}
+
+// ID returns the operation's name without NamePrefix
+func (o Operation) ID() string {
+	return o.Name[len(NamePrefix):]
+}
+
+// Metadata describes an operation's kind and progress
+type Metadata struct {
+	Kind            string     `json:"kind" example:"user.create"`
+	State           string     `json:"state" example:"running"`
+	ProgressPercent int        `json:"progress_percent" example:"40"`
+	CreateTime      time.Time  `json:"create_time" example:"2024-01-02T15:04:05Z"`
+	UpdateTime      time.Time  `json:"update_time" example:"2024-01-02T15:04:06Z"`
+	EndTime         *time.Time `json:"end_time,omitempty" example:"2024-01-02T15:04:07Z"`
+	// CancelRequested is set once cancelling a running operation was
+	// asked for; it may still finish
+	CancelRequested bool `json:"cancel_requested,omitempty" example:"false"`
+}
+
+// Status is an operation's error
+type Status struct {
+	Code    int    `json:"code" example:"2"`
+	Message string `json:"message" example:"store unavailable"`
+}
+
+// Progress reports how far through its work an operation is, from 0 to 100
+type Progress func(percent int)
+
+// Func does an operation's work, returning its response. It should return
+// promptly with ctx's error once ctx is cancelled.
+type Func func(ctx context.Context, progress Progress) (any, error)
+
+// Options configures a Manager
+type Options struct {
+	// Workers is the number of operations run at once
+	Workers int
+	// Size is the number of operations that can wait to run
+	Size int
+	// TTL is how long finished operations are kept
+	TTL time.Duration
+	// CleanupInterval is how often operations past their TTL are removed
+	CleanupInterval time.Duration
+	Clock           clock.Clock
+	IDs             idgen.Generator
+}
+
+// Manager runs submitted operations in the background and keeps them until
+// their TTL passes
+type Manager struct {
+	opts  Options
+	queue chan *entry
+
+	mutex      sync.Mutex
+	operations map[string]*entry
+	stopped    bool
+
+	// stopping is cancelled to abort running operations when Stop runs out
+	// of time, and stops the cleanup loop
+	stopping context.Context
+	abort    context.CancelFunc
+	workers  sync.WaitGroup
+	cleaner  sync.WaitGroup
+	done     chan struct{}
+}
+
+// entry is an operation and what is needed to run and cancel it
+type entry struct {
+	op     Operation
+	ctx    context.Context
+	fn     Func
+	cancel context.CancelFunc
+}
+
+// NewManager creates a manager that runs operations once started
+func NewManager(opts Options) *Manager {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Size <= 0 {
+		opts.Size = 100
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Hour
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Minute
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+
+	stopping, abort := context.WithCancel(context.Background())
+	return &Manager{
+		opts:       opts,
+		queue:      make(chan *entry, opts.Size),
+		operations: make(map[string]*entry),
+		stopping:   stopping,
+		abort:      abort,
+		done:       make(chan struct{}),
+	}
+}
+
+// Submit queues fn to run as an operation of kind on behalf of owner. fn
+// runs with ctx's values, but not its cancellation, so it can outlive the
+// request that submitted it.
+func (m *Manager) Submit(ctx context.Context, kind, owner string, fn Func) (Operation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.stopped {
+		return Operation{}, ErrStopped
+	}
+	now := m.opts.Clock.Now().UTC()
+	e := &entry{
+		op: Operation{
+			Name:     NamePrefix + m.opts.IDs.NewID(),
+			Metadata: Metadata{Kind: kind, State: StatePending, CreateTime: now, UpdateTime: now},
+			Owner:    owner,
+		},
+		ctx: context.WithoutCancel(ctx),
+		fn:  fn,
+	}
+	select {
+	case m.queue <- e:
+	default:
+		return Operation{}, ErrQueueFull
+	}
+	m.operations[e.op.ID()] = e
+	return e.op, nil
+}
+
+// Get returns the operation with id
+func (m *Manager) Get(id string) (Operation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.operations[id]
+	if !ok {
+		return Operation{}, ErrNotFound
+	}
+	return e.op, nil
+}
+
+// List returns the operations owned by owner, or every operation when all
+// is set, newest first
+func (m *Manager) List(owner string, all bool) []Operation {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := []Operation{}
+	for _, e := range m.operations {
+		if all || e.op.Owner == owner {
+			list = append(list, e.op)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Metadata.CreateTime.After(list[j].Metadata.CreateTime)
+	})
+	return list
+}
+
+// Cancel cancels the operation with id. A pending operation is cancelled
+// straight away; a running one is asked to stop, and is cancelled if it
+// then fails.
+func (m *Manager) Cancel(id string) (Operation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.operations[id]
+	switch {
+	case !ok:
+		return Operation{}, ErrNotFound
+	case e.op.Done:
+		return e.op, ErrDone
+	}
+
+	now := m.opts.Clock.Now().UTC()
+	e.op.Metadata.CancelRequested = true
+	e.op.Metadata.UpdateTime = now
+	if e.op.Metadata.State == StatePending {
+		m.finish(e, now, nil, &Status{Code: CodeCancelled, Message: "cancelled before it started"})
+	} else {
+		e.cancel()
+	}
+	return e.op, nil
+}
+
+// Delete forgets the finished operation with id
+func (m *Manager) Delete(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.operations[id]
+	switch {
+	case !ok:
+		return ErrNotFound
+	case !e.op.Done:
+		return ErrNotDone
+	}
+	delete(m.operations, id)
+	return nil
+}
+
+// Start starts running queued operations and removing those past their TTL
+func (m *Manager) Start() {
+	for range m.opts.Workers {
+		m.workers.Go(func() {
+			for e := range m.queue {
+				m.run(e)
+			}
+		})
+	}
+	m.cleaner.Go(func() {
+		ticker := time.NewTicker(m.opts.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				if removed := m.Cleanup(); removed > 0 {
+					logger.Info("Removed finished operations past their TTL", "count", removed)
+				}
+			}
+		}
+	})
+}
+
+// Stop runs the operations already queued, aborting those left when ctx
+// expires. Submit fails once Stop has been called.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mutex.Lock()
+	m.stopped = true
+	close(m.queue)
+	m.mutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		m.abort()
+		<-finished
+		err = fmt.Errorf("failed to finish queued operations: %w", ctx.Err())
+	}
+	close(m.done)
+	m.cleaner.Wait()
+	return err
+}
+
+// Cleanup removes operations that finished at least the TTL ago, returning
+// how many
+func (m *Manager) Cleanup() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.opts.Clock.Now()
+	removed := 0
+	for id, e := range m.operations {
+		if e.op.Done && now.Sub(*e.op.Metadata.EndTime) >= m.opts.TTL {
+			delete(m.operations, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// run runs a queued operation unless it was cancelled while pending,
+// recording its outcome
+func (m *Manager) run(e *entry) {
+	m.mutex.Lock()
+	if e.op.Done {
+		m.mutex.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(e.ctx)
+	e.cancel = cancel
+	e.op.Metadata.State = StateRunning
+	e.op.Metadata.UpdateTime = m.opts.Clock.Now().UTC()
+	m.mutex.Unlock()
+
+	stop := context.AfterFunc(m.stopping, cancel)
+	response, err := e.fn(ctx, func(percent int) { m.progress(e, percent) })
+	aborted := !stop()
+	cancel()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := m.opts.Clock.Now().UTC()
+	switch {
+	case err == nil:
+		m.finish(e, now, response, nil)
+	case aborted:
+		m.finish(e, now, nil, &Status{Code: CodeAborted, Message: "aborted by server shutdown: " + err.Error()})
+	case e.op.Metadata.CancelRequested && errors.Is(err, context.Canceled):
+		m.finish(e, now, nil, &Status{Code: CodeCancelled, Message: "cancelled"})
+	default:
+		logger.Error("Failed to run operation", "kind", e.op.Metadata.Kind, "operation", e.op.ID(), "error", err)
+		m.finish(e, now, nil, &Status{Code: CodeUnknown, Message: err.Error()})
+	}
+}
+
+// finish marks e done with its response or error. The caller holds the
+// mutex.
+func (m *Manager) finish(e *entry, now time.Time, response any, status *Status) {
+	e.op.Done = true
+	e.op.Metadata.UpdateTime = now
+	e.op.Metadata.EndTime = &now
+	switch {
+	case status == nil:
+		e.op.Metadata.State = StateSucceeded
+		e.op.Metadata.ProgressPercent = 100
+		e.op.Response = response
+	case status.Code == CodeCancelled:
+		e.op.Metadata.State = StateCancelled
+		e.op.Error = status
+	default:
+		e.op.Metadata.State = StateFailed
+		e.op.Error = status
+	}
+}
+
+// progress records that e is percent done
+func (m *Manager) progress(e *entry, percent int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if e.op.Done {
+		return
+	}
+	e.op.Metadata.ProgressPercent = min(max(percent, 0), 100)
+	e.op.Metadata.UpdateTime = m.opts.Clock.Now().UTC()
+}
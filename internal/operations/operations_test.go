@@ -0,0 +1,184 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func noop(context.Context, Progress) (any, error) {
+	return nil, nil
+}
+
+func TestManager_RunsOperations(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	clk := clock.NewFake(start)
+	manager := NewManager(Options{Workers: 2, Clock: clk, IDs: idgen.NewSequence("op")})
+
+	// Operations keep the submitting request's values after it is cancelled
+	ctx, cancel := context.WithCancel(reqctx.WithRequestID(context.Background(), "req-1"))
+	succeeded, err := manager.Submit(ctx, "user.create", "alice", func(ctx context.Context, progress Progress) (any, error) {
+		progress(50)
+		id, _ := reqctx.RequestID(ctx)
+		return id, ctx.Err()
+	})
+	require.NoError(t, err)
+	cancel()
+	assert.Equal(t, Operation{
+		Name:     "operations/op-1",
+		Metadata: Metadata{Kind: "user.create", State: StatePending, CreateTime: start, UpdateTime: start},
+		Owner:    "alice",
+	}, succeeded)
+	assert.Equal(t, "op-1", succeeded.ID())
+
+	failed, err := manager.Submit(context.Background(), "user.create", "", func(context.Context, Progress) (any, error) {
+		return nil, errors.New("store unavailable")
+	})
+	require.NoError(t, err)
+
+	manager.Start()
+	require.NoError(t, manager.Stop(context.Background()))
+
+	succeeded, err = manager.Get("op-1")
+	require.NoError(t, err)
+	assert.True(t, succeeded.Done)
+	assert.Equal(t, StateSucceeded, succeeded.Metadata.State)
+	assert.Equal(t, 100, succeeded.Metadata.ProgressPercent)
+	assert.Equal(t, &start, succeeded.Metadata.EndTime)
+	assert.Equal(t, "req-1", succeeded.Response)
+	assert.Nil(t, succeeded.Error)
+
+	failed, err = manager.Get(failed.ID())
+	require.NoError(t, err)
+	assert.True(t, failed.Done)
+	assert.Equal(t, StateFailed, failed.Metadata.State)
+	assert.Equal(t, &Status{Code: CodeUnknown, Message: "store unavailable"}, failed.Error)
+	assert.Nil(t, failed.Response)
+
+	_, err = manager.Submit(context.Background(), "user.create", "", noop)
+	assert.ErrorIs(t, err, ErrStopped)
+	_, err = manager.Get("op-3")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManager_Cancel(t *testing.T) {
+	manager := NewManager(Options{IDs: idgen.NewSequence("op")})
+	started := make(chan struct{})
+	running, err := manager.Submit(context.Background(), "import", "", func(ctx context.Context, progress Progress) (any, error) {
+		progress(140)
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	require.NoError(t, err)
+	pending, err := manager.Submit(context.Background(), "import", "", noop)
+	require.NoError(t, err)
+
+	// Pending operations are cancelled before they start
+	pending, err = manager.Cancel(pending.ID())
+	require.NoError(t, err)
+	assert.True(t, pending.Done)
+	assert.Equal(t, StateCancelled, pending.Metadata.State)
+	assert.Equal(t, CodeCancelled, pending.Error.Code)
+
+	// Running ones are asked to stop
+	manager.Start()
+	<-started
+	running, err = manager.Get(running.ID())
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, running.Metadata.State)
+	assert.Equal(t, 100, running.Metadata.ProgressPercent)
+	running, err = manager.Cancel(running.ID())
+	require.NoError(t, err)
+	assert.True(t, running.Metadata.CancelRequested)
+	require.NoError(t, manager.Stop(context.Background()))
+
+	running, err = manager.Get(running.ID())
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelled, running.Metadata.State)
+	assert.Equal(t, &Status{Code: CodeCancelled, Message: "cancelled"}, running.Error)
+
+	_, err = manager.Cancel(running.ID())
+	assert.ErrorIs(t, err, ErrDone)
+	_, err = manager.Cancel("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManager_ListAndDelete(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	clk := clock.NewFake(start)
+	manager := NewManager(Options{Clock: clk, IDs: idgen.NewSequence("op")})
+
+	for _, owner := range []string{"alice", "bob", "alice"} {
+		_, err := manager.Submit(context.Background(), "user.create", owner, noop)
+		require.NoError(t, err)
+		clk.Advance(time.Second)
+	}
+	names := func(ops []Operation) []string {
+		names := []string{}
+		for _, op := range ops {
+			names = append(names, op.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"operations/op-3", "operations/op-1"}, names(manager.List("alice", false)))
+	assert.Equal(t, []string{"operations/op-3", "operations/op-2", "operations/op-1"}, names(manager.List("", true)))
+
+	// Only finished operations can be deleted
+	assert.ErrorIs(t, manager.Delete("op-1"), ErrNotDone)
+	manager.Start()
+	require.NoError(t, manager.Stop(context.Background()))
+	require.NoError(t, manager.Delete("op-1"))
+	assert.ErrorIs(t, manager.Delete("op-1"), ErrNotFound)
+}
+
+func TestManager_Cleanup(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	clk := clock.NewFake(start)
+	manager := NewManager(Options{TTL: time.Hour, Clock: clk, IDs: idgen.NewSequence("op")})
+
+	op, err := manager.Submit(context.Background(), "user.create", "", noop)
+	require.NoError(t, err)
+	assert.Zero(t, manager.Cleanup(), "unfinished operations are kept")
+
+	manager.Start()
+	require.NoError(t, manager.Stop(context.Background()))
+	clk.Advance(time.Hour - time.Second)
+	assert.Zero(t, manager.Cleanup())
+	clk.Advance(time.Second)
+	assert.Equal(t, 1, manager.Cleanup())
+	_, err = manager.Get(op.ID())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManager_FullAndStopDeadline(t *testing.T) {
+	manager := NewManager(Options{Size: 1})
+	block := func(ctx context.Context, _ Progress) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	op, err := manager.Submit(context.Background(), "wait", "", block)
+	require.NoError(t, err)
+	_, err = manager.Submit(context.Background(), "wait", "", block)
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// Operations still running when Stop runs out of time are aborted
+	manager.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, manager.Stop(ctx), context.DeadlineExceeded)
+
+	op, err = manager.Get(op.ID())
+	require.NoError(t, err)
+	assert.Equal(t, StateFailed, op.Metadata.State)
+	assert.Equal(t, CodeAborted, op.Error.Code)
+}
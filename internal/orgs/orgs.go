@@ -0,0 +1,456 @@
+// Package orgs arranges users into a hierarchy of organizations, as B2B
+// deployments need. Users are members of organizations with roles, and the
+// roles granted in an organization also apply in every organization below
+// it, so an admin of a company is an admin of each of its departments.
+package orgs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+var (
+	// ErrNotFound is returned for organizations that do not exist
+	ErrNotFound = errors.New("organization not found")
+	// ErrInvalidName is returned for empty or overlong names
+	ErrInvalidName = errors.New("organization names are 1-100 characters")
+	// ErrCycle is returned when an organization would become its own
+	// ancestor
+	ErrCycle = errors.New("an organization cannot be below itself")
+	// ErrHasChildren is returned when deleting an organization with
+	// organizations below it
+	ErrHasChildren = errors.New("organization has child organizations")
+	// ErrNotMember is returned for users who are not members of an
+	// organization
+	ErrNotMember = errors.New("user is not a member of the organization")
+)
+
+// RoleManager makes members the managers of the other members of the
+// organization and of those below it
+const RoleManager = "manager"
+
+var rolePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Organization is a node in the hierarchy
+type Organization struct {
+	ID   string `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Name string `json:"name" example:"Acme Europe"`
+	// ParentID is empty for top-level organizations
+	ParentID  string    `json:"parent_id,omitempty" example:"9c0e8a1d4b7c9e6f5a4b3c2d1e0f3f2b"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2024-01-02T15:04:05Z"`
+}
+
+// Spec is what is set on an organization
+type Spec struct {
+	Name     string
+	ParentID string
+}
+
+// Member is a user's membership of an organization
+type Member struct {
+	UserID int64  `json:"user_id" example:"1"`
+	OrgID  string `json:"org_id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	// Roles are granted in the organization and those below it
+	Roles []string `json:"roles" example:"admin"`
+}
+
+// Options configures a Store
+type Options struct {
+	Clock clock.Clock
+	IDs   idgen.Generator
+}
+
+// Store holds organizations and their members in memory
+type Store struct {
+	mutex sync.Mutex
+	orgs  map[string]*Organization
+	// members maps organization IDs to their members' roles
+	members map[string]map[int64][]string
+	opts    Options
+}
+
+// NewStore creates a store without organizations
+func NewStore(opts Options) *Store {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+	return &Store{
+		orgs:    make(map[string]*Organization),
+		members: make(map[string]map[int64][]string),
+		opts:    opts,
+	}
+}
+
+// Create adds an organization, below its parent when one is set
+func (s *Store) Create(spec Spec) (Organization, error) {
+	if err := validName(spec.Name); err != nil {
+		return Organization{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if spec.ParentID != "" && s.orgs[spec.ParentID] == nil {
+		return Organization{}, fmt.Errorf("parent: %w", ErrNotFound)
+	}
+	now := s.opts.Clock.Now().UTC()
+	org := &Organization{
+		ID:        s.opts.IDs.NewID(),
+		Name:      spec.Name,
+		ParentID:  spec.ParentID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.orgs[org.ID] = org
+	return *org, nil
+}
+
+// Get returns the organization with id
+func (s *Store) Get(id string) (Organization, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	org, ok := s.orgs[id]
+	if !ok {
+		return Organization{}, ErrNotFound
+	}
+	return *org, nil
+}
+
+// List returns the organizations ordered by name
+func (s *Store) List() []Organization {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.collect(func(*Organization) bool { return true })
+}
+
+// Children returns the organizations directly below the one with id,
+// ordered by name
+func (s *Store) Children(id string) ([]Organization, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return nil, ErrNotFound
+	}
+	return s.collect(func(org *Organization) bool { return org.ParentID == id }), nil
+}
+
+// Update renames the organization with id or moves it below another
+// parent, along with the organizations below it
+func (s *Store) Update(id string, spec Spec) (Organization, error) {
+	if err := validName(spec.Name); err != nil {
+		return Organization{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	org, ok := s.orgs[id]
+	if !ok {
+		return Organization{}, ErrNotFound
+	}
+	if spec.ParentID != "" {
+		if s.orgs[spec.ParentID] == nil {
+			return Organization{}, fmt.Errorf("parent: %w", ErrNotFound)
+		}
+		if slices.Contains(s.lineage(spec.ParentID), id) {
+			return Organization{}, ErrCycle
+		}
+	}
+	if org.Name != spec.Name || org.ParentID != spec.ParentID {
+		org.Name, org.ParentID = spec.Name, spec.ParentID
+		org.UpdatedAt = s.opts.Clock.Now().UTC()
+	}
+	return *org, nil
+}
+
+// Delete removes the organization with id and its memberships. Only
+// organizations without children can be deleted.
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return ErrNotFound
+	}
+	for _, org := range s.orgs {
+		if org.ParentID == id {
+			return ErrHasChildren
+		}
+	}
+	delete(s.orgs, id)
+	delete(s.members, id)
+	return nil
+}
+
+// SetMember makes the user a member of the organization with id with
+// roles, replacing the roles of an existing membership. It reports whether
+// the user was not a member before.
+func (s *Store) SetMember(id string, userID int64, roles []string) (Member, bool, error) {
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		return Member{}, false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return Member{}, false, ErrNotFound
+	}
+	members, ok := s.members[id]
+	if !ok {
+		members = make(map[int64][]string)
+		s.members[id] = members
+	}
+	_, existed := members[userID]
+	members[userID] = roles
+	return Member{UserID: userID, OrgID: id, Roles: slices.Clone(roles)}, !existed, nil
+}
+
+// RemoveMember ends the user's membership of the organization with id
+func (s *Store) RemoveMember(id string, userID int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return ErrNotFound
+	}
+	if _, ok := s.members[id][userID]; !ok {
+		return ErrNotMember
+	}
+	delete(s.members[id], userID)
+	return nil
+}
+
+// RemapUser moves the memberships of the user from to the user to, e.g.
+// when merging duplicate users. Where both are members, to keeps the roles
+// of both. It returns the number of memberships moved.
+func (s *Store) RemapUser(from, to int64) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	moved := 0
+	for _, members := range s.members {
+		roles, ok := members[from]
+		if !ok {
+			continue
+		}
+		merged := slices.Concat(members[to], roles)
+		slices.Sort(merged)
+		members[to] = slices.Compact(merged)
+		delete(members, from)
+		moved++
+	}
+	return moved
+}
+
+// Members returns the members of the organization with id ordered by user
+// ID, including those of the organizations below it when descendants is
+// set. Each membership is listed with the roles granted with it.
+func (s *Store) Members(id string, descendants bool) ([]Member, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return nil, ErrNotFound
+	}
+	orgIDs := []string{id}
+	if descendants {
+		for _, org := range s.orgs {
+			if org.ID != id && slices.Contains(s.lineage(org.ID), id) {
+				orgIDs = append(orgIDs, org.ID)
+			}
+		}
+	}
+
+	var members []Member
+	for _, orgID := range orgIDs {
+		for userID, roles := range s.members[orgID] {
+			members = append(members, Member{UserID: userID, OrgID: orgID, Roles: slices.Clone(roles)})
+		}
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+// Memberships returns the memberships of the users, of the organizations
+// they are direct members of, ordered by user ID then organization ID
+func (s *Store) Memberships(userIDs []int64) []Member {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	memberships := []Member{}
+	for orgID, members := range s.members {
+		for _, userID := range userIDs {
+			if roles, ok := members[userID]; ok {
+				memberships = append(memberships, Member{UserID: userID, OrgID: orgID, Roles: slices.Clone(roles)})
+			}
+		}
+	}
+	sortMembers(memberships)
+	return slices.CompactFunc(memberships, func(a, b Member) bool {
+		return a.UserID == b.UserID && a.OrgID == b.OrgID
+	})
+}
+
+// Managers returns the managers of each of the users: the other members
+// with RoleManager in an organization the user is a member of or one above
+// it. Each manager is listed with the membership granting the role.
+func (s *Store) Managers(userIDs []int64) map[int64][]Member {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	managers := make(map[int64][]Member)
+	for _, userID := range userIDs {
+		type grant struct {
+			userID int64
+			orgID  string
+		}
+		seen := make(map[grant]bool)
+		var found []Member
+		for orgID, members := range s.members {
+			if _, ok := members[userID]; !ok {
+				continue
+			}
+			for _, ancestorID := range s.lineage(orgID) {
+				for managerID, roles := range s.members[ancestorID] {
+					key := grant{userID: managerID, orgID: ancestorID}
+					if managerID == userID || seen[key] || !slices.Contains(roles, RoleManager) {
+						continue
+					}
+					seen[key] = true
+					found = append(found, Member{UserID: managerID, OrgID: ancestorID, Roles: slices.Clone(roles)})
+				}
+			}
+		}
+		if len(found) > 0 {
+			sortMembers(found)
+			managers[userID] = found
+		}
+	}
+	return managers
+}
+
+// Member returns the user's membership of the organization with id itself,
+// not one above it
+func (s *Store) Member(id string, userID int64) (Member, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return Member{}, ErrNotFound
+	}
+	roles, ok := s.members[id][userID]
+	if !ok {
+		return Member{}, ErrNotMember
+	}
+	return Member{UserID: userID, OrgID: id, Roles: slices.Clone(roles)}, nil
+}
+
+// Roles returns the roles the user has in the organization with id: those
+// granted there and in every organization above it
+func (s *Store) Roles(id string, userID int64) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.orgs[id] == nil {
+		return nil, ErrNotFound
+	}
+	roles := []string{}
+	for _, orgID := range s.lineage(id) {
+		for _, role := range s.members[orgID][userID] {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	slices.Sort(roles)
+	return roles, nil
+}
+
+// IsMember reports whether the user is a member of the organization with
+// id or one above it
+func (s *Store) IsMember(id string, userID int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, orgID := range s.lineage(id) {
+		if _, ok := s.members[orgID][userID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lineage returns the IDs of the organization with id and those above it,
+// from the bottom
+func (s *Store) lineage(id string) []string {
+	var ids []string
+	for org := s.orgs[id]; org != nil; org = s.orgs[org.ParentID] {
+		ids = append(ids, org.ID)
+	}
+	return ids
+}
+
+func (s *Store) collect(keep func(*Organization) bool) []Organization {
+	orgs := make([]Organization, 0, len(s.orgs))
+	for _, org := range s.orgs {
+		if keep(org) {
+			orgs = append(orgs, *org)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].ID < orgs[j].ID
+	})
+	return orgs
+}
+
+// sortMembers orders members by user ID, then organization ID
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].UserID != members[j].UserID {
+			return members[i].UserID < members[j].UserID
+		}
+		return members[i].OrgID < members[j].OrgID
+	})
+}
+
+func validName(name string) error {
+	if name == "" || len(name) > 100 {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// normalizeRoles validates roles, sorting them and dropping duplicates
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !rolePattern.MatchString(role) {
+			return nil, fmt.Errorf("invalid role %q: roles are lowercase slugs", role)
+		}
+		if !slices.Contains(normalized, role) {
+			normalized = append(normalized, role)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
@@ -0,0 +1,178 @@
+package orgs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+// newTestStore returns a store holding acme > europe > france
+func newTestStore(t *testing.T) (*Store, Organization, Organization, Organization) {
+	t.Helper()
+	orgs := NewStore(Options{
+		Clock: clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)),
+		IDs:   idgen.NewSequence("org"),
+	})
+	acme, err := orgs.Create(Spec{Name: "Acme"})
+	require.NoError(t, err)
+	europe, err := orgs.Create(Spec{Name: "Europe", ParentID: acme.ID})
+	require.NoError(t, err)
+	france, err := orgs.Create(Spec{Name: "France", ParentID: europe.ID})
+	require.NoError(t, err)
+	return orgs, acme, europe, france
+}
+
+func TestStore_Hierarchy(t *testing.T) {
+	orgs, acme, europe, france := newTestStore(t)
+
+	_, err := orgs.Create(Spec{Name: "Asia", ParentID: "missing"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = orgs.Create(Spec{})
+	assert.ErrorIs(t, err, ErrInvalidName)
+
+	children, err := orgs.Children(acme.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []Organization{europe}, children)
+
+	tests := []struct {
+		name   string
+		id     string
+		parent string
+		want   error
+	}{
+		{name: "below itself", id: europe.ID, parent: europe.ID, want: ErrCycle},
+		{name: "below a descendant", id: acme.ID, parent: france.ID, want: ErrCycle},
+		{name: "missing parent", id: france.ID, parent: "missing", want: ErrNotFound},
+		{name: "missing organization", id: "missing", parent: acme.ID, want: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := orgs.Update(tt.id, Spec{Name: "Moved", ParentID: tt.parent})
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	moved, err := orgs.Update(france.ID, Spec{Name: "France", ParentID: acme.ID})
+	require.NoError(t, err)
+	assert.Equal(t, acme.ID, moved.ParentID)
+
+	assert.ErrorIs(t, orgs.Delete(acme.ID), ErrHasChildren)
+	require.NoError(t, orgs.Delete(france.ID))
+	assert.ErrorIs(t, orgs.Delete(france.ID), ErrNotFound)
+}
+
+func TestStore_Roles(t *testing.T) {
+	orgs, acme, europe, france := newTestStore(t)
+
+	_, created, err := orgs.SetMember(acme.ID, 1, []string{"admin"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	_, _, err = orgs.SetMember(europe.ID, 1, []string{"billing", "billing"})
+	require.NoError(t, err)
+	member, created, err := orgs.SetMember(france.ID, 2, []string{"viewer"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, Member{UserID: 2, OrgID: france.ID, Roles: []string{"viewer"}}, member)
+	_, _, err = orgs.SetMember(france.ID, 2, []string{"Viewer"})
+	assert.Error(t, err)
+
+	tests := []struct {
+		name   string
+		org    Organization
+		userID int64
+		want   []string
+		member bool
+	}{
+		{name: "granted", org: acme, userID: 1, want: []string{"admin"}, member: true},
+		{name: "granted and inherited", org: europe, userID: 1, want: []string{"admin", "billing"}, member: true},
+		{name: "inherited", org: france, userID: 1, want: []string{"admin", "billing"}, member: true},
+		{name: "not inherited upwards", org: europe, userID: 2, want: []string{}},
+		{name: "no membership", org: acme, userID: 3, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, err := orgs.Roles(tt.org.ID, tt.userID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, roles)
+			assert.Equal(t, tt.member, orgs.IsMember(tt.org.ID, tt.userID))
+		})
+	}
+
+	members, err := orgs.Members(europe.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, []Member{{UserID: 1, OrgID: europe.ID, Roles: []string{"billing"}}}, members)
+	members, err = orgs.Members(acme.ID, true)
+	require.NoError(t, err)
+	assert.Len(t, members, 3)
+
+	require.NoError(t, orgs.RemoveMember(acme.ID, 1))
+	assert.ErrorIs(t, orgs.RemoveMember(acme.ID, 1), ErrNotMember)
+	roles, err := orgs.Roles(france.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"billing"}, roles)
+}
+
+func TestStore_RemapUser(t *testing.T) {
+	orgs, acme, europe, france := newTestStore(t)
+	for _, m := range []struct {
+		org    string
+		userID int64
+		roles  []string
+	}{
+		{org: acme.ID, userID: 1, roles: []string{"billing"}},
+		{org: acme.ID, userID: 2, roles: []string{"admin", "billing"}},
+		{org: france.ID, userID: 2, roles: []string{"sales"}},
+		{org: europe.ID, userID: 3, roles: []string{"admin"}},
+	} {
+		_, _, err := orgs.SetMember(m.org, m.userID, m.roles)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, orgs.RemapUser(2, 1))
+	acmeMember, err := orgs.Member(acme.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "billing"}, acmeMember.Roles, "the roles of both are kept")
+	franceMember, err := orgs.Member(france.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sales"}, franceMember.Roles)
+	assert.False(t, orgs.IsMember(acme.ID, 2))
+	assert.True(t, orgs.IsMember(europe.ID, 3), "other users are left alone")
+	assert.Zero(t, orgs.RemapUser(2, 1))
+}
+
+func TestStore_MembershipsAndManagers(t *testing.T) {
+	orgs, acme, europe, france := newTestStore(t)
+	for _, m := range []struct {
+		org    string
+		userID int64
+		roles  []string
+	}{
+		{org: acme.ID, userID: 1, roles: []string{RoleManager}},
+		{org: europe.ID, userID: 2, roles: []string{"billing", RoleManager}},
+		{org: france.ID, userID: 3, roles: []string{"sales"}},
+		{org: acme.ID, userID: 3},
+	} {
+		_, _, err := orgs.SetMember(m.org, m.userID, m.roles)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []Member{
+		{UserID: 2, OrgID: europe.ID, Roles: []string{"billing", RoleManager}},
+		{UserID: 3, OrgID: acme.ID, Roles: []string{}},
+		{UserID: 3, OrgID: france.ID, Roles: []string{"sales"}},
+	}, orgs.Memberships([]int64{3, 2, 3, 9}))
+
+	// Managers of an organization manage those below it, but not themselves
+	managers := orgs.Managers([]int64{1, 2, 3})
+	assert.NotContains(t, managers, int64(1))
+	assert.Equal(t, []Member{{UserID: 1, OrgID: acme.ID, Roles: []string{RoleManager}}}, managers[2])
+	assert.Equal(t, []Member{
+		{UserID: 1, OrgID: acme.ID, Roles: []string{RoleManager}},
+		{UserID: 2, OrgID: europe.ID, Roles: []string{"billing", RoleManager}},
+	}, managers[3])
+}
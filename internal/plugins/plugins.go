@@ -0,0 +1,204 @@
+// Package plugins runs external plugins on user lifecycle events. Each
+// plugin is a command, run for every call of the hooks it handles with the
+// contract of pkg/plugin: a gob-encoded request on stdin and response on
+// stdout. Plugins can reject users before they are created and add
+// attributes to users read for responses.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/pkg/plugin"
+)
+
+// ErrRejected is returned for users a plugin rejects
+var ErrRejected = errors.New("rejected by plugin")
+
+// Plugin is a command handling some hooks
+type Plugin struct {
+	Name string
+	// Command is the executable and its arguments
+	Command []string
+	// Hooks are the hooks the command is run for
+	Hooks []string
+	// Timeout bounds each run of the command
+	Timeout time.Duration
+	// FailOpen ignores the plugin's failures, logging them, rather than
+	// failing the request
+	FailOpen bool
+}
+
+// Runner runs plugins for the hooks they handle, in order
+type Runner struct {
+	plugins []Plugin
+}
+
+// New creates a runner for plugins, checking that each names a command and
+// known hooks. Plugins without a timeout get timeout.
+func New(plugins []Plugin, timeout time.Duration) (*Runner, error) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	runner := &Runner{plugins: make([]Plugin, len(plugins))}
+	for i, p := range plugins {
+		if p.Name == "" {
+			return nil, fmt.Errorf("plugin %d has no name", i+1)
+		}
+		if len(p.Command) == 0 {
+			return nil, fmt.Errorf("plugin %s has no command", p.Name)
+		}
+		if len(p.Hooks) == 0 {
+			return nil, fmt.Errorf("plugin %s handles no hooks, expected some of %s", p.Name, strings.Join(plugin.Hooks, ", "))
+		}
+		for _, hook := range p.Hooks {
+			if !slices.Contains(plugin.Hooks, hook) {
+				return nil, fmt.Errorf("plugin %s: unknown hook %q, expected one of %s", p.Name, hook, strings.Join(plugin.Hooks, ", "))
+			}
+		}
+		if p.Timeout <= 0 {
+			p.Timeout = timeout
+		}
+		p.Command = slices.Clone(p.Command)
+		p.Hooks = slices.Clone(p.Hooks)
+		runner.plugins[i] = p
+	}
+	return runner, nil
+}
+
+// Handles reports whether any plugin handles hook; it is false for a nil
+// runner
+func (r *Runner) Handles(hook string) bool {
+	if r == nil {
+		return false
+	}
+	return slices.ContainsFunc(r.plugins, func(p Plugin) bool {
+		return slices.Contains(p.Hooks, hook)
+	})
+}
+
+// ValidateBeforeCreate runs the validate-before-create plugins on user,
+// returning an error wrapping ErrRejected with the reason if one rejects it
+func (r *Runner) ValidateBeforeCreate(ctx context.Context, user store.User) error {
+	for _, p := range r.handling(plugin.HookValidateBeforeCreate) {
+		response, err := r.call(ctx, p, plugin.HookValidateBeforeCreate, []plugin.User{toPlugin(user, nil)})
+		if err != nil {
+			if p.FailOpen {
+				logging.FromContext(ctx, logging.Events).Warn("Ignoring failed plugin", "plugin", p.Name, "hook", plugin.HookValidateBeforeCreate, "error", err)
+				continue
+			}
+			return err
+		}
+		if response.Rejection != "" {
+			return fmt.Errorf("%w %s: %s", ErrRejected, p.Name, response.Rejection)
+		}
+	}
+	return nil
+}
+
+// EnrichAfterRead runs the enrich-after-read plugins on users, returning
+// the attributes they added to each, in the same order. Users without
+// attributes have nil.
+func (r *Runner) EnrichAfterRead(ctx context.Context, users []store.User) ([]map[string]string, error) {
+	attributes := make([]map[string]string, len(users))
+	for _, p := range r.handling(plugin.HookEnrichAfterRead) {
+		request := make([]plugin.User, len(users))
+		for i, user := range users {
+			request[i] = toPlugin(user, attributes[i])
+		}
+		response, err := r.call(ctx, p, plugin.HookEnrichAfterRead, request)
+		if err == nil && len(response.Users) != len(users) {
+			err = fmt.Errorf("plugin %s returned %d users for %d", p.Name, len(response.Users), len(users))
+		}
+		if err != nil {
+			if p.FailOpen {
+				logging.FromContext(ctx, logging.Events).Warn("Ignoring failed plugin", "plugin", p.Name, "hook", plugin.HookEnrichAfterRead, "error", err)
+				continue
+			}
+			return nil, err
+		}
+		for i, enriched := range response.Users {
+			if len(enriched.Attributes) > 0 {
+				attributes[i] = maps.Clone(enriched.Attributes)
+			}
+		}
+	}
+	return attributes, nil
+}
+
+// handling returns the plugins handling hook, in order
+func (r *Runner) handling(hook string) []Plugin {
+	if r == nil {
+		return nil
+	}
+	var plugins []Plugin
+	for _, p := range r.plugins {
+		if slices.Contains(p.Hooks, hook) {
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
+}
+
+// call runs p's command for a call of hook with users
+func (r *Runner) call(ctx context.Context, p Plugin, hook string, users []plugin.User) (plugin.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var stdin, stdout, stderr bytes.Buffer
+	if err := gob.NewEncoder(&stdin).Encode(plugin.Request{Version: plugin.ProtocolVersion, Hook: hook, Users: users}); err != nil {
+		return plugin.Response{}, fmt.Errorf("failed to encode request for plugin %s: %w", p.Name, err)
+	}
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children the plugin leaves holding its output open are not waited for
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return plugin.Response{}, fmt.Errorf("plugin %s timed out after %v", p.Name, p.Timeout)
+		}
+		return plugin.Response{}, fmt.Errorf("plugin %s failed: %w%s", p.Name, err, detail(&stderr))
+	}
+
+	var response plugin.Response
+	if err := gob.NewDecoder(&stdout).Decode(&response); err != nil {
+		return plugin.Response{}, fmt.Errorf("plugin %s wrote an invalid response: %w%s", p.Name, err, detail(&stderr))
+	}
+	if response.Error != "" {
+		return plugin.Response{}, fmt.Errorf("plugin %s failed: %s", p.Name, response.Error)
+	}
+	return response, nil
+}
+
+// detail returns what a plugin wrote to stderr, to append to its errors
+func detail(stderr *bytes.Buffer) string {
+	text := strings.TrimSpace(stderr.String())
+	if text == "" {
+		return ""
+	}
+	return ": " + text
+}
+
+// toPlugin returns user as plugins see it, with attributes
+func toPlugin(user store.User, attributes map[string]string) plugin.User {
+	return plugin.User{
+		ID:         user.ID,
+		Name:       user.Name,
+		Email:      user.Email,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		Attributes: maps.Clone(attributes),
+	}
+}
@@ -0,0 +1,176 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/pkg/plugin"
+)
+
+// TestHelperPlugin is not a test: it is the plugin the tests run, by
+// running the test binary again with the behaviour after "--"
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv("PLUGINS_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	behaviour := strings.Join(args[1:], " ")
+
+	switch behaviour {
+	case "crash":
+		fmt.Fprintln(os.Stderr, "out of memory")
+		os.Exit(2)
+	case "hang":
+		time.Sleep(time.Minute)
+	case "garbage":
+		fmt.Print("not gob")
+		os.Exit(0)
+	}
+	plugin.Serve(plugin.HookFuncs{
+		ValidateBeforeCreate: func(user plugin.User) (string, error) {
+			if behaviour == "reject" && strings.HasSuffix(user.Email, "@example.org") {
+				return "example.org addresses are not accepted", nil
+			}
+			return "", nil
+		},
+		EnrichAfterRead: func(users []plugin.User) ([]plugin.User, error) {
+			if behaviour == "drop" {
+				return users[1:], nil
+			}
+			for i, user := range users {
+				if users[i].Attributes == nil {
+					users[i].Attributes = make(map[string]string)
+				}
+				key, value, _ := strings.Cut(behaviour, "=")
+				users[i].Attributes[key] = value + ":" + user.Name
+			}
+			return users, nil
+		},
+	})
+}
+
+// helper returns a plugin running TestHelperPlugin with behaviour
+func helper(t *testing.T, name, behaviour string, hooks ...string) Plugin {
+	t.Setenv("PLUGINS_HELPER", "1")
+	return Plugin{
+		Name:    name,
+		Command: []string{os.Args[0], "-test.run=^TestHelperPlugin$", "--", behaviour},
+		Hooks:   hooks,
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins []Plugin
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "valid", plugins: []Plugin{{Name: "policy", Command: []string{"policy"}, Hooks: plugin.Hooks}}},
+		{name: "no name", plugins: []Plugin{{Command: []string{"policy"}, Hooks: plugin.Hooks}}, wantErr: "no name"},
+		{name: "no command", plugins: []Plugin{{Name: "policy", Hooks: plugin.Hooks}}, wantErr: "no command"},
+		{name: "no hooks", plugins: []Plugin{{Name: "policy", Command: []string{"policy"}}}, wantErr: "no hooks"},
+		{name: "unknown hook", plugins: []Plugin{{Name: "policy", Command: []string{"policy"}, Hooks: []string{"validate-before-delete"}}}, wantErr: "unknown hook"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.plugins, time.Second)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRunner_Handles(t *testing.T) {
+	var none *Runner
+	assert.False(t, none.Handles(plugin.HookValidateBeforeCreate))
+	assert.NoError(t, none.ValidateBeforeCreate(context.Background(), store.User{}))
+
+	runner, err := New([]Plugin{{Name: "policy", Command: []string{"policy"}, Hooks: []string{plugin.HookValidateBeforeCreate}}}, 0)
+	require.NoError(t, err)
+	assert.True(t, runner.Handles(plugin.HookValidateBeforeCreate))
+	assert.False(t, runner.Handles(plugin.HookEnrichAfterRead))
+}
+
+func TestRunner_ValidateBeforeCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		plugin   Plugin
+		user     store.User
+		rejected bool
+		wantErr  string
+	}{
+		{name: "accepted", plugin: helper(t, "policy", "reject", plugin.HookValidateBeforeCreate), user: store.User{Email: "john@example.com"}},
+		{name: "rejected", plugin: helper(t, "policy", "reject", plugin.HookValidateBeforeCreate), user: store.User{Email: "john@example.org"}, rejected: true, wantErr: "policy: example.org addresses are not accepted"},
+		{name: "crashed", plugin: helper(t, "policy", "crash", plugin.HookValidateBeforeCreate), wantErr: "out of memory"},
+		{name: "invalid response", plugin: helper(t, "policy", "garbage", plugin.HookValidateBeforeCreate), wantErr: "invalid response"},
+		{name: "timed out", plugin: Plugin{Name: "policy", Command: helper(t, "", "hang").Command, Hooks: []string{plugin.HookValidateBeforeCreate}, Timeout: 200 * time.Millisecond}, wantErr: "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, err := New([]Plugin{tt.plugin}, time.Minute)
+			require.NoError(t, err)
+
+			err = runner.ValidateBeforeCreate(context.Background(), tt.user)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Equal(t, tt.rejected, errors.Is(err, ErrRejected))
+
+			// Failing open ignores failures, but not rejections
+			tt.plugin.FailOpen = true
+			runner, err = New([]Plugin{tt.plugin}, time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, tt.rejected, runner.ValidateBeforeCreate(context.Background(), tt.user) != nil)
+		})
+	}
+}
+
+func TestRunner_EnrichAfterRead(t *testing.T) {
+	users := []store.User{{ID: 1, Name: "John"}, {ID: 2, Name: "Jane"}}
+
+	// Later plugins see the attributes of earlier ones
+	runner, err := New([]Plugin{
+		helper(t, "plans", "plan=gold", plugin.HookEnrichAfterRead),
+		helper(t, "policy", "reject", plugin.HookValidateBeforeCreate),
+		helper(t, "regions", "region=eu", plugin.HookEnrichAfterRead),
+	}, time.Minute)
+	require.NoError(t, err)
+	attributes, err := runner.EnrichAfterRead(context.Background(), users)
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"plan": "gold:John", "region": "eu:John"},
+		{"plan": "gold:Jane", "region": "eu:Jane"},
+	}, attributes)
+
+	// Plugins must return every user
+	runner, err = New([]Plugin{helper(t, "plans", "drop", plugin.HookEnrichAfterRead)}, time.Minute)
+	require.NoError(t, err)
+	_, err = runner.EnrichAfterRead(context.Background(), users)
+	assert.ErrorContains(t, err, "returned 1 users for 2")
+
+	failOpen := helper(t, "plans", "crash", plugin.HookEnrichAfterRead)
+	failOpen.FailOpen = true
+	runner, err = New([]Plugin{failOpen, helper(t, "regions", "region=eu", plugin.HookEnrichAfterRead)}, time.Minute)
+	require.NoError(t, err)
+	attributes, err = runner.EnrichAfterRead(context.Background(), users)
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"region": "eu:John"}, {"region": "eu:Jane"}}, attributes)
+}
@@ -0,0 +1,162 @@
+// Package preferences keeps per-user API preferences, applied to each of
+// the user's requests by Middleware: the default page size, the time zone
+// timestamps are written in and the locale. Admins also set which rate
+// limit profile each user's requests are counted against.
+package preferences
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+	// Time zones are embedded, as the container image has no zoneinfo
+	_ "time/tzdata"
+
+	"golang.org/x/text/language"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+var (
+	// ErrInvalid is returned for preferences with a bad value
+	ErrInvalid = errors.New("invalid preferences")
+	// ErrUnknownProfile is returned for rate limit profiles that are not
+	// configured
+	ErrUnknownProfile = errors.New("unknown rate limit profile")
+)
+
+// Preferences are a user's API preferences. Unset fields use the server's
+// defaults.
+type Preferences struct {
+	// PageSize is the number of items paged endpoints return when the
+	// request does not say
+	PageSize int `json:"page_size,omitempty" example:"50"`
+	// Timezone is the IANA time zone user timestamps are written in
+	Timezone string `json:"timezone,omitempty" example:"Europe/London"`
+	// Locale is a BCP 47 language tag, returned as Content-Language
+	Locale string `json:"locale,omitempty" example:"en-GB"`
+	// RateProfile is the rate limit profile the user's requests count
+	// against, set by admins
+	RateProfile string `json:"rate_profile,omitempty" example:"elevated"`
+}
+
+// Options configures a Store
+type Options struct {
+	// MaxPageSize caps the page size users can choose
+	MaxPageSize int
+	// Profiles are the rate limit profiles admins can choose from
+	Profiles []string
+}
+
+// entry is a user's preferences with their time zone loaded
+type entry struct {
+	Preferences
+	location *time.Location
+}
+
+// Store holds preferences in memory, keyed by user ID
+type Store struct {
+	mutex   sync.RWMutex
+	entries map[int64]entry
+	opts    Options
+}
+
+// NewStore creates a store in which every user has the defaults
+func NewStore(opts Options) *Store {
+	if opts.MaxPageSize <= 0 {
+		opts.MaxPageSize = 1000
+	}
+	return &Store{entries: make(map[int64]entry), opts: opts}
+}
+
+// Get returns the user's preferences
+func (s *Store) Get(userID int64) Preferences {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.entries[userID].Preferences
+}
+
+// Set replaces the user's preferences, keeping the rate limit profile
+// admins set
+func (s *Store) Set(userID int64, prefs Preferences) (Preferences, error) {
+	if prefs.PageSize < 0 || prefs.PageSize > s.opts.MaxPageSize {
+		return Preferences{}, fmt.Errorf("%w: page_size must be between 1 and %d, or 0 for the default", ErrInvalid, s.opts.MaxPageSize)
+	}
+	var location *time.Location
+	if prefs.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(prefs.Timezone); err != nil || prefs.Timezone == "Local" {
+			return Preferences{}, fmt.Errorf("%w: unknown timezone %q, expected an IANA name such as Europe/London", ErrInvalid, prefs.Timezone)
+		}
+	}
+	if prefs.Locale != "" {
+		tag, err := language.Parse(prefs.Locale)
+		if err != nil {
+			return Preferences{}, fmt.Errorf("%w: locale %q is not a BCP 47 language tag such as en-GB", ErrInvalid, prefs.Locale)
+		}
+		prefs.Locale = tag.String()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prefs.RateProfile = s.entries[userID].RateProfile
+	s.entries[userID] = entry{Preferences: prefs, location: location}
+	return prefs, nil
+}
+
+// SetRateProfile sets the rate limit profile the user's requests count
+// against; an empty profile restores the default
+func (s *Store) SetRateProfile(userID int64, profile string) (Preferences, error) {
+	if profile != "" && !slices.Contains(s.opts.Profiles, profile) {
+		return Preferences{}, fmt.Errorf("%w %q", ErrUnknownProfile, profile)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e := s.entries[userID]
+	e.RateProfile = profile
+	s.entries[userID] = e
+	return e.Preferences, nil
+}
+
+// Middleware applies the preferences of the user making each request:
+// their page size and time zone are put in the request context for
+// handlers, and their locale too, also returned as Content-Language.
+// Requests from anyone other than a user pass through untouched.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := reqctx.PrincipalFrom(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := store.ParseID(principal.Subject)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.mutex.RLock()
+		e := s.entries[userID]
+		s.mutex.RUnlock()
+
+		ctx := r.Context()
+		if e.PageSize > 0 {
+			ctx = reqctx.WithPageSize(ctx, e.PageSize)
+		}
+		if e.location != nil {
+			ctx = reqctx.WithTimezone(ctx, e.location)
+		}
+		if e.Locale != "" {
+			ctx = reqctx.WithLocale(ctx, e.Locale)
+			w.Header().Set("Content-Language", e.Locale)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,105 @@
+package preferences
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func TestStore_Set(t *testing.T) {
+	prefs := NewStore(Options{MaxPageSize: 500, Profiles: []string{"elevated", "standard"}})
+
+	tests := []struct {
+		name    string
+		prefs   Preferences
+		want    Preferences
+		wantErr bool
+	}{
+		{name: "defaults", prefs: Preferences{}, want: Preferences{}},
+		{name: "all set", prefs: Preferences{PageSize: 50, Timezone: "Europe/London", Locale: "en-gb"}, want: Preferences{PageSize: 50, Timezone: "Europe/London", Locale: "en-GB"}},
+		{name: "page size too large", prefs: Preferences{PageSize: 501}, wantErr: true},
+		{name: "negative page size", prefs: Preferences{PageSize: -1}, wantErr: true},
+		{name: "unknown timezone", prefs: Preferences{Timezone: "Mars/Olympus"}, wantErr: true},
+		{name: "host timezone", prefs: Preferences{Timezone: "Local"}, wantErr: true},
+		{name: "bad locale", prefs: Preferences{Locale: "english please"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prefs.Set(1, tt.prefs)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Users keep the rate limit profile admins chose when they change
+	// their preferences
+	_, err := prefs.SetRateProfile(1, "elevated")
+	require.NoError(t, err)
+	_, err = prefs.SetRateProfile(1, "unlimited")
+	assert.ErrorIs(t, err, ErrUnknownProfile)
+	got, err := prefs.Set(1, Preferences{PageSize: 20, RateProfile: "standard"})
+	require.NoError(t, err)
+	assert.Equal(t, Preferences{PageSize: 20, RateProfile: "elevated"}, got)
+	assert.Equal(t, got, prefs.Get(1))
+	assert.Equal(t, Preferences{}, prefs.Get(2))
+}
+
+func TestStore_Middleware(t *testing.T) {
+	prefs := NewStore(Options{})
+	_, err := prefs.Set(1, Preferences{PageSize: 25, Timezone: "Asia/Tokyo", Locale: "fr-CA"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		principal *reqctx.Principal
+		wantApply bool
+	}{
+		{name: "user with preferences", principal: &reqctx.Principal{Subject: "1"}, wantApply: true},
+		{name: "user without preferences", principal: &reqctx.Principal{Subject: "2"}},
+		{name: "api key", principal: &reqctx.Principal{Subject: "apikey:1"}},
+		{name: "anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				size     int
+				timezone string
+				locale   string
+			)
+			handler := prefs.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				size, _ = reqctx.PageSize(r.Context())
+				if loc, ok := reqctx.Timezone(r.Context()); ok {
+					timezone = loc.String()
+				}
+				locale, _ = reqctx.Locale(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/api/v1/users", nil)
+			if tt.principal != nil {
+				req = req.WithContext(reqctx.WithPrincipal(req.Context(), *tt.principal))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if tt.wantApply {
+				assert.Equal(t, 25, size)
+				assert.Equal(t, "Asia/Tokyo", timezone)
+				assert.Equal(t, "fr-CA", locale)
+				assert.Equal(t, "fr-CA", w.Header().Get("Content-Language"))
+			} else {
+				assert.Zero(t, size)
+				assert.Empty(t, timezone)
+				assert.Empty(t, locale)
+			}
+		})
+	}
+}
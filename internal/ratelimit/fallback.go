@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// Fallback is a Limiter counting requests with a shared limiter, such as
+// Redis, and in memory while the shared one fails, so each replica limits
+// on its own until it recovers. The shared limiter is not tried again for
+// a while after failing, so an outage does not slow down every request.
+type Fallback struct {
+	shared Limiter
+	local  Limiter
+	retry  time.Duration
+	clock  clock.Clock
+
+	mutex sync.Mutex
+	// until is when the shared limiter is next tried
+	until time.Time
+}
+
+// NewFallback creates a limiter counting with local for retry each time
+// shared fails
+func NewFallback(shared, local Limiter, retry time.Duration, clk clock.Clock) *Fallback {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &Fallback{shared: shared, local: local, retry: retry, clock: clk}
+}
+
+// Allow counts a request by key against limit, unless it is refused
+func (f *Fallback) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := f.clock.Now()
+	f.mutex.Lock()
+	failing := now.Before(f.until)
+	f.mutex.Unlock()
+	if failing {
+		return f.local.Allow(ctx, key, limit)
+	}
+
+	result, err := f.shared.Allow(ctx, key, limit)
+	if err == nil {
+		return result, nil
+	}
+
+	f.mutex.Lock()
+	f.until = now.Add(f.retry)
+	f.mutex.Unlock()
+	logging.FromContext(ctx, logging.HTTP).Warn("Shared rate limiter failed, counting requests locally", "retry", f.retry, "error", err)
+	return f.local.Allow(ctx, key, limit)
+}
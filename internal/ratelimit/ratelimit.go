@@ -0,0 +1,296 @@
+// Package ratelimit limits how many requests each caller makes in a window.
+// Windows slide: the count of the previous fixed window is weighted by how
+// much of it still overlaps the sliding one, which smooths the bursts fixed
+// windows allow at their edges without keeping a log of every request.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/middleware"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// Limit allows Requests in any Window
+type Limit struct {
+	// Profile names the limit, for callers describing their limits
+	Profile  string
+	Requests int
+	Window   time.Duration
+}
+
+// Result is the outcome of counting a request against a limit
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is how many more requests the window allows
+	Remaining int
+	// Reset is how long until the window allows another request
+	Reset time.Duration
+}
+
+// Limiter counts requests by key
+type Limiter interface {
+	// Allow counts a request by key against limit, unless it is refused
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// window counts the requests of a key in the current fixed window and the
+// one before it
+type window struct {
+	size     time.Duration
+	start    time.Time
+	current  int
+	previous int
+}
+
+// Memory is a Limiter counting requests in process memory, so each replica
+// limits on its own
+type Memory struct {
+	clock clock.Clock
+
+	mutex     sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+// NewMemory creates a Memory limiter timing windows with clk
+func NewMemory(clk clock.Clock) *Memory {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &Memory{clock: clk, windows: make(map[string]*window)}
+}
+
+// Allow counts a request by key against limit, unless it is refused
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	now := m.clock.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sweep(now, limit.Window)
+	w, ok := m.windows[key]
+	if !ok {
+		w = &window{size: limit.Window, start: now.Truncate(limit.Window)}
+		m.windows[key] = w
+	}
+	w.advance(now, limit.Window)
+
+	count, reset := w.estimate(now, limit)
+	if count >= float64(limit.Requests) {
+		return newResult(limit, count, reset, false), nil
+	}
+	w.current++
+	return newResult(limit, count, reset, true), nil
+}
+
+// newResult describes counting a request against limit, when count
+// requests were already in the sliding window
+func newResult(limit Limit, count float64, reset time.Duration, allowed bool) Result {
+	if !allowed {
+		return Result{Limit: limit.Requests, Reset: reset}
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     limit.Requests,
+		Remaining: max(0, limit.Requests-int(math.Ceil(count))-1),
+		Reset:     reset,
+	}
+}
+
+// sweep forgets keys idle for two of their windows, at most once a window
+func (m *Memory) sweep(now time.Time, size time.Duration) {
+	if now.Sub(m.lastSweep) < size {
+		return
+	}
+	m.lastSweep = now
+	for key, w := range m.windows {
+		if now.Sub(w.start) >= 2*w.size {
+			delete(m.windows, key)
+		}
+	}
+}
+
+// advance moves w to the fixed window of size containing now, starting
+// over when the size changed
+func (w *window) advance(now time.Time, size time.Duration) {
+	start := now.Truncate(size)
+	switch {
+	case size != w.size:
+		w.size, w.start, w.previous, w.current = size, start, 0, 0
+	case start.Equal(w.start):
+	case start.Sub(w.start) == size:
+		w.start, w.previous, w.current = start, w.current, 0
+	default:
+		w.start, w.previous, w.current = start, 0, 0
+	}
+}
+
+// estimate returns the requests counted in the sliding window ending at
+// now, and how long until it has room for another
+func (w *window) estimate(now time.Time, limit Limit) (float64, time.Duration) {
+	elapsed := now.Sub(w.start)
+	count := float64(w.previous)*(1-float64(elapsed)/float64(limit.Window)) + float64(w.current)
+
+	// Room opens as the previous window slides out, or once the current
+	// one becomes the previous window
+	reset := limit.Window - elapsed
+	if room := float64(limit.Requests - 1 - w.current); count >= float64(limit.Requests) && room >= 0 && w.previous > 0 {
+		free := time.Duration((1 - room/float64(w.previous)) * float64(limit.Window))
+		reset = min(reset, free-elapsed)
+	}
+	return count, max(reset, time.Second)
+}
+
+// Caller returns the key a request's caller is counted by: its principal,
+// or its address when anonymous
+func Caller(r *http.Request) string {
+	if principal, ok := reqctx.PrincipalFrom(r.Context()); ok {
+		return "principal:" + principal.Subject
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "anonymous:" + host
+	}
+	return "anonymous:" + r.RemoteAddr
+}
+
+// Failure is what happens to requests when the limiter fails
+type Failure string
+
+const (
+	// FailOpen lets requests through, so an outage of a shared limiter
+	// does not take the API down with it
+	FailOpen Failure = "open"
+	// FailClosed refuses requests with 503 Service Unavailable, so limits
+	// hold even when they cannot be counted
+	FailClosed Failure = "closed"
+)
+
+// DefaultWarnAt is the share of a limit used after which responses warn
+const DefaultWarnAt = 0.8
+
+// Options configure Middleware
+type Options struct {
+	// Failure is what happens to requests when the limiter fails
+	Failure Failure
+	// WarnAt is the share of its limit a caller uses before responses warn
+	// it is close, defaulting to DefaultWarnAt
+	WarnAt float64
+	// Describedby is a link to the description of the caller's limits,
+	// advertised on responses that warn or refuse
+	Describedby string
+}
+
+// Status is the caller's standing against its limit, as of its request
+type Status struct {
+	Limit  Limit
+	Result Result
+	// WarnAt is the share of the limit used after which responses warn
+	WarnAt float64
+}
+
+// statusKey is the context key of the request's Status
+type statusKey struct{}
+
+// WithStatus returns a context carrying the caller's standing against its
+// limit
+func WithStatus(ctx context.Context, status Status) context.Context {
+	return context.WithValue(ctx, statusKey{}, status)
+}
+
+// StatusFrom returns the caller's standing against its limit, if its
+// request was counted
+func StatusFrom(ctx context.Context) (Status, bool) {
+	status, ok := ctx.Value(statusKey{}).(Status)
+	return status, ok
+}
+
+// Warning describes result when the caller has used at least warnAt of its
+// limit, so clients can slow down before they are refused
+func Warning(limit Limit, result Result, warnAt float64) (string, bool) {
+	if result.Limit <= 0 {
+		return "", false
+	}
+	used := float64(result.Limit-result.Remaining) / float64(result.Limit)
+	if used < warnAt {
+		return "", false
+	}
+	return fmt.Sprintf("%d%% of %d requests per %ds used", int(math.Floor(used*100)), result.Limit, int(limit.Window.Seconds())), true
+}
+
+// Middleware refuses requests once the caller has used up its limit, with
+// 429 Too Many Requests and a Retry-After header. resolve returns the key
+// requests are counted by, usually the caller, and its limit; requests it
+// returns no limit for are not counted. Every counted response carries
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset, and
+// once the caller has used opts.WarnAt of its limit, X-RateLimit-Warning
+// and a Link to opts.Describedby, so clients can slow down before they are
+// refused. Handlers get the caller's standing from StatusFrom.
+//
+// When the limiter fails, requests are let through or refused as
+// opts.Failure says.
+func Middleware(limiter Limiter, resolve func(r *http.Request) (string, Limit, bool), opts Options) middleware.Middleware {
+	if opts.WarnAt <= 0 {
+		opts.WarnAt = DefaultWarnAt
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, limit, ok := resolve(r)
+			if !ok || limit.Requests <= 0 || limit.Window <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), key, limit)
+			if err != nil && opts.Failure == FailClosed {
+				logging.FromContext(r.Context(), logging.HTTP).Error("Rate limiter failed, refusing request", "error", err)
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "Rate limiter unavailable", "code": string(apierrors.Unavailable)})
+				return
+			}
+			if err != nil {
+				logging.FromContext(r.Context(), logging.HTTP).Error("Rate limiter failed, allowing request", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reset := strconv.Itoa(int(math.Ceil(result.Reset.Seconds())))
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", reset)
+			if !result.Allowed {
+				advertise(w, opts.Describedby)
+				w.Header().Set("Retry-After", reset)
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "Rate limit exceeded", "code": string(apierrors.RateLimited)})
+				return
+			}
+			if warning, ok := Warning(limit, result, opts.WarnAt); ok {
+				w.Header().Set("X-RateLimit-Warning", warning)
+				advertise(w, opts.Describedby)
+			}
+			next.ServeHTTP(w, r.WithContext(WithStatus(r.Context(), Status{Limit: limit, Result: result, WarnAt: opts.WarnAt})))
+		})
+	}
+}
+
+// advertise links the response to the description of the caller's limits
+func advertise(w http.ResponseWriter, describedby string) {
+	if describedby != "" {
+		w.Header().Add("Link", "<"+describedby+">; rel=\"describedby\"")
+	}
+}
@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func TestAllow(t *testing.T) {
+	limiters := map[string]func(t *testing.T, clk clock.Clock) Limiter{
+		"memory": func(t *testing.T, clk clock.Clock) Limiter {
+			return NewMemory(clk)
+		},
+		"redis": func(t *testing.T, clk clock.Clock) Limiter {
+			client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			t.Cleanup(func() { _ = client.Close() })
+			return NewRedis(client, RedisOptions{Prefix: "ratelimit:", Clock: clk})
+		},
+	}
+
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC))
+			limiter := newLimiter(t, clk)
+			limit := Limit{Requests: 3, Window: time.Minute}
+
+			for remaining := 2; remaining >= 0; remaining-- {
+				result, err := limiter.Allow(context.Background(), "alice", limit)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, remaining, result.Remaining)
+			}
+			result, err := limiter.Allow(context.Background(), "alice", limit)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, time.Minute, result.Reset)
+
+			// Other callers have their own windows
+			result, err = limiter.Allow(context.Background(), "bob", limit)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			// Halfway into the next window, half of the previous one still counts
+			clk.Advance(90 * time.Second)
+			for range 2 {
+				result, err = limiter.Allow(context.Background(), "alice", limit)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+			}
+			result, err = limiter.Allow(context.Background(), "alice", limit)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			// Windows later, earlier ones no longer count
+			clk.Advance(2 * time.Minute)
+			for range 3 {
+				result, err = limiter.Allow(context.Background(), "alice", limit)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+			}
+		})
+	}
+}
+
+func TestRedis_SharedByReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC))
+	limit := Limit{Requests: 4, Window: time.Minute}
+
+	var replicas []Limiter
+	for range 2 {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		replicas = append(replicas, NewRedis(client, RedisOptions{Prefix: "ratelimit:", Clock: clk}))
+	}
+
+	allowed := 0
+	for i := range 10 {
+		result, err := replicas[i%2].Allow(context.Background(), "alice", limit)
+		require.NoError(t, err)
+		if result.Allowed {
+			allowed++
+		}
+	}
+	assert.Equal(t, 4, allowed, "replicas count against one limit")
+
+	// Counts expire once they no longer count
+	assert.Equal(t, 2*time.Minute, server.TTL("ratelimit:{alice}:60000:"+strconv.FormatInt(clk.Now().UnixMilli(), 10)))
+}
+
+func TestFallback(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC))
+	limit := Limit{Requests: 2, Window: time.Minute}
+	local := NewMemory(clk)
+	limiter := NewFallback(NewRedis(client, RedisOptions{Clock: clk}), local, 10*time.Second, clk)
+
+	result, err := limiter.Allow(context.Background(), "alice", limit)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Remaining)
+
+	// While Redis is down, requests are counted locally from scratch
+	server.Close()
+	for remaining := 1; remaining >= 0; remaining-- {
+		result, err = limiter.Allow(context.Background(), "alice", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, remaining, result.Remaining)
+	}
+	result, err = limiter.Allow(context.Background(), "alice", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// Redis is tried again once the retry has passed
+	require.NoError(t, server.Restart())
+	clk.Advance(5 * time.Second)
+	result, err = limiter.Allow(context.Background(), "alice", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "still counted locally")
+	clk.Advance(5 * time.Second)
+	result, err = limiter.Allow(context.Background(), "alice", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining, "counted in Redis again, which kept its count")
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := NewMemory(clock.NewFake(time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)))
+	resolve := func(r *http.Request) (string, Limit, bool) {
+		return Caller(r), Limit{Requests: 1, Window: time.Minute}, r.URL.Path != "/unlimited"
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		limiter    Limiter
+		failure    Failure
+		path       string
+		principal  string
+		wantStatus int
+		wantHeader bool
+	}{
+		{name: "first request", limiter: limiter, path: "/users", wantStatus: http.StatusOK, wantHeader: true},
+		{name: "second request", limiter: limiter, path: "/users", wantStatus: http.StatusTooManyRequests, wantHeader: true},
+		{name: "another caller", limiter: limiter, path: "/users", principal: "1", wantStatus: http.StatusOK, wantHeader: true},
+		{name: "not limited", limiter: limiter, path: "/unlimited", wantStatus: http.StatusOK},
+		{name: "limiter failure", limiter: failingLimiter{}, path: "/users", wantStatus: http.StatusOK},
+		{name: "limiter failure failing open", limiter: failingLimiter{}, failure: FailOpen, path: "/users", wantStatus: http.StatusOK},
+		{name: "limiter failure failing closed", limiter: failingLimiter{}, failure: FailClosed, path: "/users", wantStatus: http.StatusServiceUnavailable},
+		{name: "limiter failure on an unlimited path", limiter: failingLimiter{}, failure: FailClosed, path: "/unlimited", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.principal != "" {
+				req = req.WithContext(reqctx.WithPrincipal(req.Context(), reqctx.Principal{Subject: tt.principal}))
+			}
+			w := httptest.NewRecorder()
+			Middleware(tt.limiter, resolve, Options{Failure: tt.failure})(ok).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantHeader {
+				assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+			} else {
+				assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+			}
+			if tt.wantStatus == http.StatusTooManyRequests {
+				assert.Equal(t, "60", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestMiddleware_Warning(t *testing.T) {
+	limiter := NewMemory(clock.NewFake(time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)))
+	resolve := func(r *http.Request) (string, Limit, bool) {
+		return Caller(r), Limit{Profile: "standard", Requests: 5, Window: time.Minute}, true
+	}
+	var status Status
+	handler := Middleware(limiter, resolve, Options{Describedby: "/api/v1/limits"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ = StatusFrom(r.Context())
+	}))
+
+	wantWarnings := []string{"", "", "", "80% of 5 requests per 60s used", "100% of 5 requests per 60s used"}
+	for i, want := range wantWarnings {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, want, w.Header().Get("X-RateLimit-Warning"), "request %d", i+1)
+		if want == "" {
+			assert.Empty(t, w.Header().Get("Link"))
+		} else {
+			assert.Equal(t, `</api/v1/limits>; rel="describedby"`, w.Header().Get("Link"))
+		}
+		assert.Equal(t, "standard", status.Limit.Profile)
+		assert.Equal(t, 4-i, status.Result.Remaining)
+		assert.Equal(t, DefaultWarnAt, status.WarnAt)
+	}
+
+	// Refusals link to the limits too
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Warning"))
+	assert.Equal(t, `</api/v1/limits>; rel="describedby"`, w.Header().Get("Link"))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// allowScript counts a request in the current fixed window unless the
+// sliding window is full, atomically so replicas sharing the counts never
+// let more requests through than the limit. It returns whether the request
+// was allowed, and the counts of the current window before it and of the
+// previous window.
+//
+// KEYS[1] and KEYS[2] count the current and previous windows. ARGV holds the
+// limit, the share of the previous window still in the sliding one, and how
+// long counts are kept, in milliseconds.
+var allowScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+if previous * tonumber(ARGV[2]) + current >= tonumber(ARGV[1]) then
+	return {0, current, previous}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {1, current, previous}
+`)
+
+// RedisOptions configures a Redis limiter
+type RedisOptions struct {
+	// Prefix is prepended to the keys counts are stored under
+	Prefix string
+	// Clock times windows. Replicas sharing counts should keep their
+	// clocks in sync, as they do with NTP.
+	Clock clock.Clock
+}
+
+// Redis is a Limiter counting requests in Redis, so replicas sharing it
+// limit together
+type Redis struct {
+	client redis.Scripter
+	opts   RedisOptions
+}
+
+// NewRedis creates a limiter counting requests through client
+func NewRedis(client redis.Scripter, opts RedisOptions) *Redis {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Redis{client: client, opts: opts}
+}
+
+// Allow counts a request by key against limit, unless it is refused
+func (l *Redis) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := l.opts.Clock.Now()
+	start := now.Truncate(limit.Window)
+	elapsed := now.Sub(start)
+
+	// The windows of a key share a hash tag, so Redis Cluster keeps them on
+	// one node where the script can reach both
+	prefix := l.opts.Prefix + "{" + key + "}:" + strconv.FormatInt(limit.Window.Milliseconds(), 10) + ":"
+	keys := []string{
+		prefix + strconv.FormatInt(start.UnixMilli(), 10),
+		prefix + strconv.FormatInt(start.Add(-limit.Window).UnixMilli(), 10),
+	}
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	ttl := 2 * limit.Window
+
+	reply, err := allowScript.Run(ctx, l.client, keys, limit.Requests, strconv.FormatFloat(weight, 'f', -1, 64), ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to count request in Redis: %w", err)
+	}
+	if len(reply) != 3 {
+		return Result{}, fmt.Errorf("unexpected reply from Redis: %v", reply)
+	}
+
+	w := window{size: limit.Window, start: start, current: int(reply[1]), previous: int(reply[2])}
+	count, reset := w.estimate(now, limit)
+	return newResult(limit, count, reset, reply[0] == 1), nil
+}
@@ -0,0 +1,56 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/router"
+)
+
+// maxMutationsBody bounds the size of a batch of writes received
+const maxMutationsBody = 32 << 20
+
+// Routes returns the endpoints the other instances replicate through. They
+// are authenticated with the shared secret rather than as API callers.
+func (s *Store) Routes() []router.Route {
+	routes := []router.Route{
+		{Method: http.MethodPost, Path: MutationsPath, Handler: http.HandlerFunc(s.receive)},
+		{Method: http.MethodGet, Path: StatePath, Handler: http.HandlerFunc(s.serveState)},
+	}
+	return router.Wrap(routes, s.authenticate)
+}
+
+// authenticate rejects requests without the shared secret
+func (s *Store) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(s.opts.Secret)) != 1 {
+			http.Error(w, "A valid replication secret is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// receive applies writes sent by another instance
+func (s *Store) receive(w http.ResponseWriter, r *http.Request) {
+	var mutations []Mutation
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMutationsBody)).Decode(&mutations); err != nil {
+		http.Error(w, "Invalid writes: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.Apply(mutations); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to apply replicated writes", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveState responds with the latest write of every user
+func (s *Store) serveState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.State())
+}
@@ -0,0 +1,264 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths served to the other instances by Routes
+const (
+	MutationsPath = "/replication/mutations"
+	StatePath     = "/replication/state"
+)
+
+// PeerStatus describes replication to another instance
+type PeerStatus struct {
+	Node string `json:"node"`
+	URL  string `json:"url"`
+	// Queued is the number of writes waiting to be sent
+	Queued int `json:"queued"`
+	// LastContact is when the instance last answered, if it has
+	LastContact *time.Time `json:"last_contact,omitempty"`
+	// LastError is why the last request to the instance failed, if it did
+	LastError string `json:"last_error,omitempty"`
+}
+
+// peer is another instance, with the writes waiting to be sent to it
+type peer struct {
+	Node
+	mutations chan Mutation
+
+	mutex       sync.Mutex
+	lastContact time.Time
+	lastError   string
+}
+
+func newPeer(node Node, size int) *peer {
+	node.URL = strings.TrimSuffix(node.URL, "/")
+	return &peer{Node: node, mutations: make(chan Mutation, size)}
+}
+
+// enqueue queues mutation to be sent, reporting whether there was room
+func (p *peer) enqueue(mutation Mutation) bool {
+	select {
+	case p.mutations <- mutation:
+		return true
+	default:
+		return false
+	}
+}
+
+// contacted records the outcome of a request to the instance
+func (p *peer) contacted(at time.Time, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err != nil {
+		p.lastError = err.Error()
+		return
+	}
+	p.lastContact = at
+	p.lastError = ""
+}
+
+func (p *peer) status() PeerStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := PeerStatus{Node: p.Name, URL: p.URL, Queued: len(p.mutations), LastError: p.lastError}
+	if !p.lastContact.IsZero() {
+		lastContact := p.lastContact
+		status.LastContact = &lastContact
+	}
+	return status
+}
+
+// Start starts sending writes to the other instances, and reconciling with
+// them now and then every ReconcileInterval
+func (s *Store) Start() {
+	for _, p := range s.peers {
+		s.workers.Go(func() {
+			for mutation := range p.mutations {
+				s.send(p, s.batch(p, mutation))
+			}
+		})
+	}
+
+	s.workers.Go(func() {
+		s.reconcileAll()
+		if s.opts.ReconcileInterval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(s.opts.ReconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.reconcileAll()
+			case <-s.stopping.Done():
+				return
+			}
+		}
+	})
+}
+
+// reconcileAll reconciles with every instance, logging failures
+func (s *Store) reconcileAll() {
+	for _, result := range s.Reconcile(s.stopping) {
+		if result.Error != "" {
+			logger.Warn("Failed to reconcile with a replication node", "node", result.Node, "error", result.Error)
+		}
+	}
+}
+
+// batch adds the writes already waiting for p to mutation, in order
+func (s *Store) batch(p *peer, mutation Mutation) []Mutation {
+	batch := []Mutation{mutation}
+	for len(batch) < s.opts.QueueSize {
+		select {
+		case more, ok := <-p.mutations:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, more)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// Stop sends the writes already queued, giving up on those left when ctx
+// expires. Nothing may be written once Stop has been called.
+func (s *Store) Stop(ctx context.Context) error {
+	for _, p := range s.peers {
+		close(p.mutations)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return fmt.Errorf("failed to send queued writes: %w", ctx.Err())
+	}
+}
+
+// send sends a batch of writes to p, retrying with exponential backoff.
+// Writes that cannot be sent are left for reconciliation.
+func (s *Store) send(p *peer, batch []Mutation) {
+	backoff := s.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.post(s.stopping, p, batch)
+		p.contacted(s.clock.Now(), err)
+		if err == nil {
+			return
+		}
+		if attempt >= s.opts.MaxAttempts || s.stopping.Err() != nil {
+			s.dropped.Add(uint64(len(batch)))
+			logger.Error("Failed to replicate writes, leaving them to reconciliation", "node", p.Name, "writes", len(batch), "attempts", attempt, "error", err)
+			return
+		}
+
+		logger.Warn("Failed to replicate writes, retrying", "node", p.Name, "writes", len(batch), "attempt", attempt, "backoff", backoff, "error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-s.stopping.Done():
+			timer.Stop()
+		}
+		backoff *= 2
+	}
+}
+
+// post sends writes to p
+func (s *Store) post(ctx context.Context, p *peer, batch []Mutation) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL+MutationsPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create replication request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.opts.Secret)
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send writes: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+	return nil
+}
+
+// ReconcileResult is the outcome of reconciling with another instance
+type ReconcileResult struct {
+	Node string `json:"node"`
+	// Received is the number of users in the instance's state, and Applied
+	// how many of them were newer than ours
+	Received int    `json:"received"`
+	Applied  int    `json:"applied"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Reconcile pulls the state of every other instance and applies whatever
+// this instance missed, returning the outcome for each
+func (s *Store) Reconcile(ctx context.Context) []ReconcileResult {
+	results := make([]ReconcileResult, 0, len(s.peers))
+	for _, p := range s.peers {
+		result := ReconcileResult{Node: p.Name}
+		state, err := s.fetch(ctx, p)
+		p.contacted(s.clock.Now(), err)
+		if err == nil {
+			result.Received = len(state)
+			result.Applied, err = s.Apply(state)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// fetch gets the state of p
+func (s *Store) fetch(ctx context.Context, p *peer) ([]Mutation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+StatePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replication request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.opts.Secret)
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node returned %s", resp.Status)
+	}
+
+	var state []Mutation
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	return state, nil
+}
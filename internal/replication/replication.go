@@ -0,0 +1,494 @@
+// Package replication replicates the users of a store between instances, so
+// the demo can run highly available without an external database.
+//
+// Every instance accepts writes. Each write is versioned with a vector clock
+// and streamed to the other instances, which apply it unless they already
+// have a later version. Writes made concurrently on two instances conflict;
+// the last writer wins, by the time of the write and then the name of the
+// node, so every instance settles on the same user. Instances periodically
+// pull each other's state, repairing any write the stream lost.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// logger logs replication between instances
+var logger = logging.Named(logging.Store)
+
+// Node is an instance taking part in replication
+type Node struct {
+	Name string
+	// URL is where the other instances reach the node
+	URL string
+}
+
+// Options configures replication
+type Options struct {
+	// Node is the name of this instance, one of Nodes
+	Node string
+	// Nodes are every instance, in the same order on each. New user IDs
+	// are striped by position, so instances never create the same ID.
+	Nodes []Node
+	// Secret is shared by the instances to authenticate each other
+	Secret string
+	// QueueSize is the number of writes that can wait to be sent to each
+	// instance
+	QueueSize int
+	// MaxAttempts is how many times sending writes is tried
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubling after
+	// each further failure
+	InitialBackoff time.Duration
+	// ReconcileInterval is how often the state of the other instances is
+	// pulled, or 0 to only pull it on start and on demand
+	ReconcileInterval time.Duration
+	Client            *http.Client
+	Clock             clock.Clock
+}
+
+// Version identifies a write of a user
+type Version struct {
+	Vector Vector `json:"vector"`
+	// Node made the write, at At
+	Node string    `json:"node"`
+	At   time.Time `json:"at"`
+}
+
+// wins reports whether v replaces other when they conflict: the later
+// write wins, and the greater node name breaks ties
+func (v Version) wins(other Version) bool {
+	if !v.At.Equal(other.At) {
+		return v.At.After(other.At)
+	}
+	return v.Node > other.Node
+}
+
+// Mutation is a write of a user, sent between instances
+type Mutation struct {
+	UserID int64 `json:"user_id"`
+	// User is the user written, or nil when it was deleted
+	User    *store.User `json:"user,omitempty"`
+	Version Version     `json:"version"`
+}
+
+// Status describes replication on an instance
+type Status struct {
+	Node  string       `json:"node"`
+	Peers []PeerStatus `json:"peers"`
+	// Users is the number of users with a version, Tombstones of them
+	// deleted
+	Users      int `json:"users"`
+	Tombstones int `json:"tombstones"`
+	// Applied counts writes received from other instances and applied
+	Applied uint64 `json:"applied"`
+	// Conflicts counts writes received that were concurrent with the
+	// version held, whichever won. A conflict is counted by the instance
+	// that detects it; the version it settles on supersedes both writes.
+	Conflicts uint64 `json:"conflicts"`
+	// Dropped counts writes that could not be sent to an instance, left
+	// for reconciliation to repair
+	Dropped uint64 `json:"dropped"`
+}
+
+// Store decorates a UserStore, replicating its writes to the other instances
+// and applying theirs
+type Store struct {
+	store.UserStore
+
+	replacer store.Replacer
+	opts     Options
+	clock    clock.Clock
+	peers    []*peer
+
+	// mutex serialises writes, keeping versions in step with the store
+	mutex    sync.Mutex
+	versions map[int64]Version
+	// nextID is the next ID in this instance's stripe, stride apart
+	nextID int64
+	stride int64
+
+	writes    atomic.Uint64
+	applied   atomic.Uint64
+	conflicts atomic.Uint64
+	dropped   atomic.Uint64
+
+	// stopping is cancelled to abandon sends and reconciliation on Stop
+	stopping context.Context
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+}
+
+// New wraps inner, which must replace users, with replication. Users
+// already in inner are given an initial version.
+func New(inner store.UserStore, opts Options) (*Store, error) {
+	replacer, ok := inner.(store.Replacer)
+	if !ok {
+		return nil, errors.New("store does not support replication")
+	}
+	if opts.Secret == "" {
+		return nil, errors.New("replication requires a shared secret")
+	}
+	position := -1
+	for i, node := range opts.Nodes {
+		if slices.ContainsFunc(opts.Nodes[:i], func(other Node) bool { return other.Name == node.Name }) {
+			return nil, fmt.Errorf("replication node %q is listed more than once", node.Name)
+		}
+		if node.Name == opts.Node {
+			position = i
+		}
+	}
+	if position < 0 {
+		return nil, fmt.Errorf("replication node %q is not one of the nodes", opts.Node)
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 500 * time.Millisecond
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+
+	stopping, cancel := context.WithCancel(context.Background())
+	s := &Store{
+		UserStore: inner,
+		replacer:  replacer,
+		opts:      opts,
+		clock:     opts.Clock,
+		versions:  make(map[int64]Version),
+		nextID:    int64(position) + 1,
+		stride:    int64(len(opts.Nodes)),
+		stopping:  stopping,
+		cancel:    cancel,
+	}
+	for _, node := range opts.Nodes {
+		if node.Name != opts.Node {
+			s.peers = append(s.peers, newPeer(node, opts.QueueSize))
+		}
+	}
+
+	users, err := inner.GetAll()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to version existing users: %w", err)
+	}
+	for _, user := range users {
+		s.versions[user.ID] = Version{Vector: Vector{}, Node: opts.Node, At: user.UpdatedAt}
+		s.observe(user.ID)
+	}
+	return s, nil
+}
+
+// observe moves nextID past id, keeping it in the stripe. The caller must
+// hold the mutex.
+func (s *Store) observe(id int64) {
+	for s.nextID <= id {
+		s.nextID += s.stride
+	}
+}
+
+// write versions a local write of the user id and queues it for every
+// other instance. The caller must hold the mutex.
+func (s *Store) write(id int64, user *store.User) {
+	version := Version{
+		Vector: s.versions[id].Vector.Tick(s.opts.Node),
+		Node:   s.opts.Node,
+		At:     s.clock.Now().UTC(),
+	}
+	s.versions[id] = version
+	s.writes.Add(1)
+
+	mutation := Mutation{UserID: id, Version: version}
+	if user != nil {
+		copied := *user
+		mutation.User = &copied
+	}
+	for _, p := range s.peers {
+		if !p.enqueue(mutation) {
+			s.dropped.Add(1)
+			logger.Warn("Replication queue is full, leaving the write to reconciliation", "node", p.Name, "user_id", id)
+		}
+	}
+}
+
+// Create creates a user with the next ID in this instance's stripe and
+// replicates it
+func (s *Store) Create(user store.User) (*store.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user.ID = s.nextID
+	user.CreatedAt = s.clock.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	user.LastSeenAt = nil
+	user.Version = 1
+	created, err := s.replacer.Replace(user)
+	if err != nil {
+		return nil, err
+	}
+	s.observe(created.ID)
+	s.write(created.ID, created)
+	return created, nil
+}
+
+// Update updates a user and replicates it
+func (s *Store) Update(id int64, user store.User) (*store.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated, err := s.UserStore.Update(id, user)
+	if err != nil {
+		return nil, err
+	}
+	s.write(id, updated)
+	return updated, nil
+}
+
+// UpdateVersion updates a user while it is at version and replicates it
+func (s *Store) UpdateVersion(id, version int64, user store.User) (*store.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated, err := store.UpdateVersion(s.UserStore, id, version, user)
+	if err != nil {
+		return nil, err
+	}
+	s.write(id, updated)
+	return updated, nil
+}
+
+// Delete deletes a user and replicates the deletion
+func (s *Store) Delete(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.UserStore.Delete(id); err != nil {
+		return err
+	}
+	s.write(id, nil)
+	return nil
+}
+
+// DeleteVersion deletes a user while it is at version and replicates the
+// deletion
+func (s *Store) DeleteVersion(id, version int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := store.DeleteVersion(s.UserStore, id, version); err != nil {
+		return err
+	}
+	s.write(id, nil)
+	return nil
+}
+
+// Restore restores a user through the wrapped store and replicates it
+func (s *Store) Restore(user store.User) (*store.User, error) {
+	recoverer, ok := s.UserStore.(store.Recoverer)
+	if !ok {
+		return nil, errors.New("store does not restore users")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	restored, err := recoverer.Restore(user)
+	if err != nil {
+		return nil, err
+	}
+	s.observe(restored.ID)
+	s.write(restored.ID, restored)
+	return restored, nil
+}
+
+// Apply applies writes received from another instance, skipping those
+// older than the versions held. It returns how many were applied.
+func (s *Store) Apply(mutations []Mutation) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	applied := 0
+	for _, mutation := range mutations {
+		ok, err := s.apply(mutation)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply write of user %d: %w", mutation.UserID, err)
+		}
+		if ok {
+			applied++
+		}
+	}
+	s.applied.Add(uint64(applied))
+	return applied, nil
+}
+
+// apply applies a single write. The caller must hold the mutex.
+func (s *Store) apply(mutation Mutation) (bool, error) {
+	incoming := mutation.Version
+	if current, exists := s.versions[mutation.UserID]; exists {
+		switch incoming.Vector.Compare(current.Vector) {
+		case Equal, Before:
+			return false, nil
+		case Concurrent:
+			s.conflicts.Add(1)
+			merged := incoming.Vector.Merge(current.Vector)
+			if !incoming.wins(current) {
+				logger.Info("Replicated write conflicts, keeping ours", "user_id", mutation.UserID, "node", incoming.Node)
+				current.Vector = merged
+				s.versions[mutation.UserID] = current
+				return false, nil
+			}
+			logger.Info("Replicated write conflicts, taking theirs", "user_id", mutation.UserID, "node", incoming.Node)
+			incoming.Vector = merged
+		}
+	}
+
+	if mutation.User != nil {
+		user := *mutation.User
+		user.ID = mutation.UserID
+		if _, err := s.replacer.Replace(user); err != nil {
+			return false, err
+		}
+		s.observe(user.ID)
+	} else if _, err := s.UserStore.GetByID(mutation.UserID); err == nil {
+		if err := s.UserStore.Delete(mutation.UserID); err != nil {
+			return false, err
+		}
+	}
+	s.versions[mutation.UserID] = incoming
+	s.writes.Add(1)
+	return true, nil
+}
+
+// State returns the latest write of every user, deletions included, for
+// another instance to reconcile with
+func (s *Store) State() []Mutation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ids := make([]int64, 0, len(s.versions))
+	for id := range s.versions {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	state := make([]Mutation, 0, len(ids))
+	for _, id := range ids {
+		mutation := Mutation{UserID: id, Version: s.versions[id]}
+		if user, err := s.UserStore.GetByID(id); err == nil {
+			mutation.User = user
+		}
+		state = append(state, mutation)
+	}
+	return state
+}
+
+// Status describes replication on this instance
+func (s *Store) Status() Status {
+	s.mutex.Lock()
+	users := len(s.versions)
+	tombstones := 0
+	for id := range s.versions {
+		if _, err := s.UserStore.GetByID(id); err != nil {
+			tombstones++
+		}
+	}
+	s.mutex.Unlock()
+
+	peers := make([]PeerStatus, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p.status())
+	}
+	return Status{
+		Node:       s.opts.Node,
+		Peers:      peers,
+		Users:      users,
+		Tombstones: tombstones,
+		Applied:    s.applied.Load(),
+		Conflicts:  s.conflicts.Load(),
+		Dropped:    s.dropped.Load(),
+	}
+}
+
+// Conflicts returns the number of conflicting writes received
+func (s *Store) Conflicts() uint64 {
+	return s.conflicts.Load()
+}
+
+// Dropped returns the number of writes that could not be sent
+func (s *Store) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// RecordActivity delegates to the wrapped store when it records activity.
+// Activity is local to each instance, so it is not replicated.
+func (s *Store) RecordActivity(seen map[int64]time.Time) error {
+	recorder, ok := s.UserStore.(store.ActivityRecorder)
+	if !ok {
+		return errors.New("store does not record activity")
+	}
+	return recorder.RecordActivity(seen)
+}
+
+// Find delegates to the wrapped store, so it can use its indexes
+func (s *Store) Find(filter store.UserFilter) ([]store.User, error) {
+	return store.FindUsers(s.UserStore, filter)
+}
+
+// GetByIDs delegates to the wrapped store, so it can look the users up at
+// once
+func (s *Store) GetByIDs(ids []int64) ([]store.User, error) {
+	return store.GetUsers(s.UserStore, ids)
+}
+
+// Verify delegates to the wrapped store when it supports verification
+func (s *Store) Verify(repair bool) (*store.IntegrityReport, error) {
+	verifier, ok := s.UserStore.(store.Verifier)
+	if !ok {
+		return nil, errors.New("store does not support verification")
+	}
+	return verifier.Verify(repair)
+}
+
+// Snapshot implements store.Snapshotter, failing with
+// store.ErrSnapshotsUnsupported when the wrapped store does not
+func (s *Store) Snapshot() (store.UserSnapshot, error) {
+	return store.Snapshot(s.UserStore)
+}
+
+// Revision delegates to the wrapped store, falling back to counting the
+// writes made through replication when the wrapped store does not track
+// revisions
+func (s *Store) Revision() uint64 {
+	if revisioner, ok := s.UserStore.(store.Revisioner); ok {
+		return revisioner.Revision()
+	}
+	return s.writes.Load()
+}
+
+// Close closes the wrapped store if it holds resources
+func (s *Store) Close() error {
+	if closer, ok := s.UserStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package replication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+func TestVector_Compare(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        Vector
+		other    Vector
+		expected Ordering
+	}{
+		{name: "empty", v: Vector{}, other: nil, expected: Equal},
+		{name: "same", v: Vector{"a": 1, "b": 2}, other: Vector{"a": 1, "b": 2}, expected: Equal},
+		{name: "zero counts are missing counts", v: Vector{"a": 1, "b": 0}, other: Vector{"a": 1}, expected: Equal},
+		{name: "before", v: Vector{"a": 1}, other: Vector{"a": 1, "b": 1}, expected: Before},
+		{name: "after", v: Vector{"a": 2, "b": 1}, other: Vector{"a": 1, "b": 1}, expected: After},
+		{name: "concurrent", v: Vector{"a": 2, "b": 1}, other: Vector{"a": 1, "b": 2}, expected: Concurrent},
+		{name: "concurrent with missing nodes", v: Vector{"a": 1}, other: Vector{"b": 1}, expected: Concurrent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.v.Compare(tt.other))
+		})
+	}
+}
+
+func TestVector_MergeAndTick(t *testing.T) {
+	v := Vector{"a": 2, "b": 1}
+	merged := v.Merge(Vector{"b": 3, "c": 1})
+	assert.Equal(t, Vector{"a": 2, "b": 3, "c": 1}, merged)
+
+	ticked := merged.Tick("a")
+	assert.Equal(t, Vector{"a": 3, "b": 3, "c": 1}, ticked)
+	assert.Equal(t, After, ticked.Compare(merged))
+	assert.Equal(t, Vector{"a": 2, "b": 1}, v, "merging and ticking copy the vector")
+}
+
+// newCluster creates a replicated store for each node, serving each other
+// over HTTP. The stores are not started.
+func newCluster(t *testing.T, clk clock.Clock, names ...string) []*Store {
+	t.Helper()
+	muxes := make([]*http.ServeMux, len(names))
+	nodes := make([]Node, len(names))
+	for i, name := range names {
+		muxes[i] = http.NewServeMux()
+		server := httptest.NewServer(muxes[i])
+		t.Cleanup(server.Close)
+		nodes[i] = Node{Name: name, URL: server.URL}
+	}
+
+	stores := make([]*Store, len(names))
+	for i, name := range names {
+		s, err := New(store.NewMemoryUserStore(), Options{
+			Node:           name,
+			Nodes:          nodes,
+			Secret:         "secret",
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			Clock:          clk,
+		})
+		require.NoError(t, err)
+		for _, route := range s.Routes() {
+			muxes[i].Handle(route.Method+" "+route.Path, route.Handler)
+		}
+		stores[i] = s
+	}
+	return stores
+}
+
+func TestNew(t *testing.T) {
+	nodes := []Node{{Name: "a"}, {Name: "b"}}
+	tests := []struct {
+		name    string
+		inner   store.UserStore
+		opts    Options
+		wantErr string
+	}{
+		{name: "valid", inner: store.NewMemoryUserStore(), opts: Options{Node: "a", Nodes: nodes, Secret: "secret"}},
+		{name: "no secret", inner: store.NewMemoryUserStore(), opts: Options{Node: "a", Nodes: nodes}, wantErr: "shared secret"},
+		{name: "unknown node", inner: store.NewMemoryUserStore(), opts: Options{Node: "c", Nodes: nodes, Secret: "secret"}, wantErr: "not one of the nodes"},
+		{name: "duplicate node", inner: store.NewMemoryUserStore(), opts: Options{Node: "a", Nodes: append(nodes, Node{Name: "a"}), Secret: "secret"}, wantErr: "more than once"},
+		{name: "store cannot replace users", inner: struct{ store.UserStore }{store.NewMemoryUserStore()}, opts: Options{Node: "a", Nodes: nodes, Secret: "secret"}, wantErr: "does not support replication"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.inner, tt.opts)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStore_StripesIDs(t *testing.T) {
+	inner := store.NewMemoryUserStore()
+	_, _ = inner.Create(store.User{Name: "Existing", Email: "existing@example.com"})
+	s, err := New(inner, Options{Node: "b", Nodes: []Node{{Name: "a"}, {Name: "b"}, {Name: "c"}}, Secret: "secret"})
+	require.NoError(t, err)
+
+	var ids []int64
+	for range 3 {
+		user, err := s.Create(store.User{Name: "User", Email: "user@example.com"})
+		require.NoError(t, err)
+		ids = append(ids, user.ID)
+	}
+	assert.Equal(t, []int64{2, 5, 8}, ids, "node b creates the second ID of every three, after existing users")
+
+	_, err = s.Apply([]Mutation{{UserID: 10, User: &store.User{Name: "Remote"}, Version: Version{Vector: Vector{"a": 1}, Node: "a"}}})
+	require.NoError(t, err)
+	user, err := s.Create(store.User{Name: "User", Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), user.ID, "IDs continue after replicated users")
+}
+
+func TestStore_Streams(t *testing.T) {
+	stores := newCluster(t, clock.Real(), "a", "b")
+	a, b := stores[0], stores[1]
+	a.Start()
+	b.Start()
+	defer func() {
+		assert.NoError(t, a.Stop(t.Context()))
+		assert.NoError(t, b.Stop(t.Context()))
+	}()
+
+	created, err := a.Create(store.User{Name: "User", Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		replicated, err := b.GetByID(created.ID)
+		if assert.NoError(c, err) {
+			assert.Equal(c, created, replicated)
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = b.Update(created.ID, store.User{Name: "Renamed", Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		replicated, err := a.GetByID(created.ID)
+		if assert.NoError(c, err) {
+			assert.Equal(c, "Renamed", replicated.Name)
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, a.Delete(created.ID))
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		_, err := b.GetByID(created.ID)
+		assert.Error(c, err)
+	}, time.Second, 10*time.Millisecond)
+	assert.Zero(t, a.Conflicts())
+	assert.Zero(t, b.Conflicts())
+}
+
+func TestStore_ConflictsConverge(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	stores := newCluster(t, clk, "a", "b")
+	a, b := stores[0], stores[1]
+
+	created, err := a.Create(store.User{Name: "User", Email: "user@example.com"})
+	require.NoError(t, err)
+	results := b.Reconcile(t.Context())
+	assert.Equal(t, []ReconcileResult{{Node: "a", Received: 1, Applied: 1}}, results)
+
+	// Both nodes update the user without seeing the other's update; b's is
+	// later, so it wins on both
+	_, err = a.Update(created.ID, store.User{Name: "Written by a", Email: "user@example.com"})
+	require.NoError(t, err)
+	clk.Advance(time.Second)
+	_, err = b.Update(created.ID, store.User{Name: "Written by b", Email: "user@example.com"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []ReconcileResult{{Node: "a", Received: 1, Applied: 0}}, b.Reconcile(t.Context()))
+	assert.Equal(t, []ReconcileResult{{Node: "b", Received: 1, Applied: 1}}, a.Reconcile(t.Context()))
+
+	for _, s := range stores {
+		user, err := s.GetByID(created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Written by b", user.Name)
+	}
+	assert.Equal(t, a.State(), b.State(), "both nodes hold the same versions")
+
+	// b detected the conflict; the version it settled on has seen a's write,
+	// so it simply replaces a's
+	assert.Equal(t, uint64(1), b.Conflicts())
+	assert.Zero(t, a.Conflicts())
+	assert.Equal(t, []ReconcileResult{{Node: "b", Received: 1, Applied: 0}}, a.Reconcile(t.Context()))
+}
+
+func TestStore_DeletesAreNotUndone(t *testing.T) {
+	stores := newCluster(t, clock.Real(), "a", "b")
+	a, b := stores[0], stores[1]
+
+	created, err := a.Create(store.User{Name: "User", Email: "user@example.com"})
+	require.NoError(t, err)
+	b.Reconcile(t.Context())
+	stale := b.State()
+
+	require.NoError(t, a.Delete(created.ID))
+	b.Reconcile(t.Context())
+	_, err = b.GetByID(created.ID)
+	assert.Error(t, err, "the deletion is reconciled")
+
+	// The write deleted is older than the deletion, so it is skipped
+	applied, err := b.Apply(stale)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+	_, err = b.GetByID(created.ID)
+	assert.Error(t, err)
+	assert.Equal(t, 1, b.Status().Tombstones)
+}
+
+func TestStore_ReconcilesDroppedWrites(t *testing.T) {
+	stores := newCluster(t, clock.Real(), "a", "b")
+	a, b := stores[0], stores[1]
+
+	// a is not started, so its writes wait in the queue until b pulls them
+	created, err := a.Create(store.User{Name: "User", Email: "user@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.Status().Peers[0].Queued)
+
+	b.Reconcile(t.Context())
+	replicated, err := b.GetByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created, replicated)
+
+	status := b.Status()
+	assert.Equal(t, "b", status.Node)
+	assert.Equal(t, uint64(1), status.Applied)
+	require.Len(t, status.Peers, 1)
+	assert.Equal(t, "a", status.Peers[0].Node)
+	assert.NotNil(t, status.Peers[0].LastContact)
+	assert.Empty(t, status.Peers[0].LastError)
+}
+
+func TestStore_UnreachablePeer(t *testing.T) {
+	s, err := New(store.NewMemoryUserStore(), Options{
+		Node:           "a",
+		Nodes:          []Node{{Name: "a"}, {Name: "b", URL: "http://127.0.0.1:1"}},
+		Secret:         "secret",
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+	s.Start()
+
+	_, err = s.Create(store.User{Name: "User", Email: "user@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, s.Stop(t.Context()))
+
+	assert.Equal(t, uint64(1), s.Dropped())
+	assert.NotEmpty(t, s.Status().Peers[0].LastError)
+}
+
+func TestStore_Routes_RequireSecret(t *testing.T) {
+	s := newCluster(t, clock.Real(), "a", "b")[0]
+	mux := http.NewServeMux()
+	for _, route := range s.Routes() {
+		mux.Handle(route.Method+" "+route.Path, route.Handler)
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		body          string
+		expected      int
+	}{
+		{name: "state without secret", method: http.MethodGet, path: StatePath, expected: http.StatusUnauthorized},
+		{name: "state with wrong secret", method: http.MethodGet, path: StatePath, authorization: "Bearer wrong", expected: http.StatusUnauthorized},
+		{name: "state", method: http.MethodGet, path: StatePath, authorization: "Bearer secret", expected: http.StatusOK},
+		{name: "writes without secret", method: http.MethodPost, path: MutationsPath, body: "[]", expected: http.StatusUnauthorized},
+		{name: "invalid writes", method: http.MethodPost, path: MutationsPath, authorization: "Bearer secret", body: "not json", expected: http.StatusBadRequest},
+		{name: "writes", method: http.MethodPost, path: MutationsPath, authorization: "Bearer secret", body: "[]", expected: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
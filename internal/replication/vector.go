@@ -0,0 +1,83 @@
+package replication
+
+// Vector is a vector clock: how many writes to a user each node has made
+// or seen. Comparing the vectors of two writes tells whether one was made
+// knowing about the other, or whether they were made concurrently.
+type Vector map[string]uint64
+
+// Ordering is how two vectors compare
+type Ordering int
+
+const (
+	// Equal vectors describe the same history
+	Equal Ordering = iota
+	// Before means the first vector's history is part of the second's
+	Before
+	// After means the second vector's history is part of the first's
+	After
+	// Concurrent vectors each saw writes the other did not, so the writes
+	// conflict
+	Concurrent
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	default:
+		return "concurrent"
+	}
+}
+
+// Compare orders v against other
+func (v Vector) Compare(other Vector) Ordering {
+	less, greater := false, false
+	for node, count := range v {
+		if count > other[node] {
+			greater = true
+		} else if count < other[node] {
+			less = true
+		}
+	}
+	for node, count := range other {
+		if _, ok := v[node]; !ok && count > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// Merge returns a vector that has seen every write either has
+func (v Vector) Merge(other Vector) Vector {
+	merged := make(Vector, len(v))
+	for node, count := range v {
+		merged[node] = count
+	}
+	for node, count := range other {
+		if count > merged[node] {
+			merged[node] = count
+		}
+	}
+	return merged
+}
+
+// Tick returns a copy of v counting one more write by node
+func (v Vector) Tick(node string) Vector {
+	ticked := v.Merge(nil)
+	ticked[node]++
+	return ticked
+}
@@ -0,0 +1,211 @@
+package reports
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// Page layout, in millimetres
+const (
+	margin    = 15.0
+	rowHeight = 6.0
+	// footerHeight is kept free at the bottom of pages for page numbers
+	footerHeight = 10.0
+)
+
+// column is a column of a table in a report
+type column struct {
+	title string
+	width float64
+	align string
+}
+
+var (
+	summaryColumns = []column{{"", 70, "L"}, {"Users", 25, "R"}}
+	userColumns    = []column{{"ID", 15, "R"}, {"Name", 50, "L"}, {"Email", 65, "L"}, {"Created", 25, "L"}, {"Last seen", 25, "L"}}
+)
+
+// summary holds the counts at the top of a users report
+type summary struct {
+	total, created7, created30, seen30, neverSeen int
+}
+
+// summarize counts users as of now
+func summarize(users []store.User, now time.Time) summary {
+	s := summary{total: len(users)}
+	for _, user := range users {
+		if user.CreatedAt.After(now.AddDate(0, 0, -7)) {
+			s.created7++
+		}
+		if user.CreatedAt.After(now.AddDate(0, 0, -30)) {
+			s.created30++
+		}
+		switch {
+		case user.LastSeenAt == nil:
+			s.neverSeen++
+		case user.LastSeenAt.After(now.AddDate(0, 0, -30)):
+			s.seen30++
+		}
+	}
+	return s
+}
+
+// writeUsersPDF renders a report about users as of now to w: a summary,
+// the latest recent signups, then a table of every user over as many pages
+// as it takes, its header repeated on each
+func writeUsersPDF(w io.Writer, users []store.User, now time.Time, recent int, progress func(int)) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	// Pages are broken by the tables, so they can repeat their headers
+	pdf.SetAutoPageBreak(false, margin)
+	pdf.SetTitle("Users report", true)
+	pdf.SetCreationDate(now)
+	pdf.SetModificationDate(now)
+	pdf.AliasNbPages("")
+
+	generated := "Generated " + now.Format("2006-01-02 15:04 UTC")
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(100, 8, "Users report", "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(0, 8, generated, "", 1, "R", false, 0, "")
+		pdf.Ln(4)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-margin)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	})
+	pdf.AddPage()
+	// The core fonts are encoded in Windows-1252, so text is converted and
+	// characters outside it are replaced
+	r := &renderer{pdf: pdf, translate: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	s := summarize(users, now)
+	r.heading("Summary")
+	r.table(summaryColumns, [][]string{
+		{"Total", strconv.Itoa(s.total)},
+		{"Signed up in the last 7 days", strconv.Itoa(s.created7)},
+		{"Signed up in the last 30 days", strconv.Itoa(s.created30)},
+		{"Seen in the last 30 days", strconv.Itoa(s.seen30)},
+		{"Never seen", strconv.Itoa(s.neverSeen)},
+	}, nil)
+
+	latest := slices.DeleteFunc(slices.Clone(users), func(user store.User) bool { return user.CreatedAt.IsZero() })
+	slices.SortStableFunc(latest, func(a, b store.User) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	r.heading("Recent signups")
+	r.table(userColumns, userRows(latest[:min(recent, len(latest))]), nil)
+
+	all := slices.SortedStableFunc(slices.Values(users), func(a, b store.User) int { return cmp.Compare(a.ID, b.ID) })
+	r.heading("All users")
+	r.table(userColumns, userRows(all), progress)
+
+	return pdf.Output(w)
+}
+
+// userRows returns the cells of users for userColumns
+func userRows(users []store.User) [][]string {
+	rows := make([][]string, len(users))
+	for i, user := range users {
+		created, seen := "", "Never"
+		if !user.CreatedAt.IsZero() {
+			created = user.CreatedAt.UTC().Format(time.DateOnly)
+		}
+		if user.LastSeenAt != nil {
+			seen = user.LastSeenAt.UTC().Format(time.DateOnly)
+		}
+		rows[i] = []string{strconv.FormatInt(user.ID, 10), user.Name, user.Email, created, seen}
+	}
+	return rows
+}
+
+// renderer draws the sections of a report
+type renderer struct {
+	pdf       *fpdf.Fpdf
+	translate func(string) string
+}
+
+// heading starts a section, on a new page if there is no room for its
+// title, table header and first row
+func (r *renderer) heading(title string) {
+	r.ensureRoom(10 + 2*rowHeight)
+	r.pdf.Ln(2)
+	r.pdf.SetFont("Helvetica", "B", 12)
+	r.pdf.CellFormat(0, 8, title, "", 1, "L", false, 0, "")
+}
+
+// table draws rows under a header of columns, starting new pages as needed.
+// progress, if not nil, is told the percentage of rows drawn.
+func (r *renderer) table(columns []column, rows [][]string, progress func(int)) {
+	if len(rows) == 0 {
+		r.pdf.SetFont("Helvetica", "I", 9)
+		r.pdf.CellFormat(0, rowHeight, "None", "", 1, "L", false, 0, "")
+		return
+	}
+	r.tableHeader(columns)
+	r.pdf.SetFont("Helvetica", "", 9)
+	reported := -1
+	for i, row := range rows {
+		if r.ensureRoom(rowHeight) {
+			r.tableHeader(columns)
+			r.pdf.SetFont("Helvetica", "", 9)
+		}
+		// Shade every other row to guide the eye across wide tables
+		r.pdf.SetFillColor(242, 242, 242)
+		for j, c := range columns {
+			r.pdf.CellFormat(c.width, rowHeight, r.fit(row[j], c.width), "", 0, c.align, i%2 == 1, 0, "")
+		}
+		r.pdf.Ln(-1)
+		if percent := (i + 1) * 100 / len(rows); progress != nil && percent != reported {
+			progress(percent)
+			reported = percent
+		}
+	}
+}
+
+// tableHeader draws the header row of a table
+func (r *renderer) tableHeader(columns []column) {
+	r.pdf.SetFont("Helvetica", "B", 9)
+	r.pdf.SetFillColor(217, 225, 242)
+	for _, c := range columns {
+		r.pdf.CellFormat(c.width, rowHeight+1, c.title, "B", 0, c.align, true, 0, "")
+	}
+	r.pdf.Ln(-1)
+}
+
+// ensureRoom starts a new page if height does not fit above the footer,
+// reporting whether it did
+func (r *renderer) ensureRoom(height float64) bool {
+	_, pageHeight := r.pdf.GetPageSize()
+	if r.pdf.GetY()+height <= pageHeight-margin-footerHeight {
+		return false
+	}
+	r.pdf.AddPage()
+	return true
+}
+
+// fit converts text for the current font, shortening it with an ellipsis
+// to fit within width
+func (r *renderer) fit(text string, width float64) string {
+	text = r.translate(text)
+	// Leave room for the cell's padding
+	width -= 2 * r.pdf.GetCellMargin()
+	if r.pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	const ellipsis = "\x85" // … in Windows-1252
+	for len(text) > 0 && r.pdf.GetStringWidth(text+ellipsis) > width {
+		text = text[:len(text)-1]
+	}
+	return text + ellipsis
+}
@@ -0,0 +1,191 @@
+// Package reports renders PDF reports about users. Reports are written
+// straight to clients, or, when generated in the background, kept in blob
+// storage until they expire.
+package reports
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// logger logs cleanups of expired reports
+var logger = logging.Named(logging.Jobs)
+
+// ContentType is the type reports are served as
+const ContentType = "application/pdf"
+
+// DownloadPath is where stored reports are downloaded from, followed by
+// their ID
+const DownloadPath = "/admin/reports/"
+
+var (
+	// ErrNotFound is returned for unknown or expired reports
+	ErrNotFound = errors.New("report not found")
+	// ErrNoStorage is returned when storing reports without storage
+	ErrNoStorage = errors.New("report storage is not configured")
+)
+
+// Options configures a Manager
+type Options struct {
+	// Recent is the number of the latest signups listed
+	Recent int
+	// Retention is how long stored reports can be downloaded
+	Retention time.Duration
+	Clock     clock.Clock
+	IDs       idgen.Generator
+}
+
+// Report is a report kept in storage
+type Report struct {
+	ID        string    `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	URL       string    `json:"url" example:"/admin/reports/3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Size      int64     `json:"size" example:"48213"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	ExpiresAt time.Time `json:"expires_at" example:"2024-01-03T15:04:05Z"`
+}
+
+// Manager renders reports and keeps those stored until they expire
+type Manager struct {
+	storage uploads.Storage
+	opts    Options
+
+	mutex   sync.Mutex
+	reports map[string]Report
+}
+
+// NewManager creates a manager storing reports in storage, which may be nil
+// if reports are only written straight to clients
+func NewManager(storage uploads.Storage, opts Options) *Manager {
+	if opts.Recent <= 0 {
+		opts.Recent = 10
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+	return &Manager{storage: storage, opts: opts, reports: make(map[string]Report)}
+}
+
+// CanStore reports whether the manager has storage to keep reports in
+func (m *Manager) CanStore() bool {
+	return m.storage != nil
+}
+
+// WriteUsers renders a report about users to w. progress, if not nil, is
+// told the percentage of users rendered.
+func (m *Manager) WriteUsers(w io.Writer, users []store.User, progress func(int)) error {
+	return writeUsersPDF(w, users, m.opts.Clock.Now().UTC(), m.opts.Recent, progress)
+}
+
+// StoreUsers renders a report about users into storage, where it can be
+// opened until it expires
+func (m *Manager) StoreUsers(ctx context.Context, users []store.User, progress func(int)) (Report, error) {
+	if m.storage == nil {
+		return Report{}, ErrNoStorage
+	}
+	var buf bytes.Buffer
+	if err := m.WriteUsers(&buf, users, progress); err != nil {
+		return Report{}, fmt.Errorf("failed to render report: %w", err)
+	}
+
+	now := m.opts.Clock.Now().UTC()
+	id := m.opts.IDs.NewID()
+	report := Report{
+		ID:        id,
+		URL:       DownloadPath + id,
+		Size:      int64(buf.Len()),
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.opts.Retention),
+	}
+	if err := m.storage.Put(ctx, key(id), ContentType, &buf, report.Size); err != nil {
+		return Report{}, fmt.Errorf("failed to store report: %w", err)
+	}
+
+	m.mutex.Lock()
+	m.reports[id] = report
+	m.mutex.Unlock()
+	return report, nil
+}
+
+// Open opens a stored report for reading
+func (m *Manager) Open(ctx context.Context, id string) (Report, io.ReadCloser, error) {
+	m.mutex.Lock()
+	report, ok := m.reports[id]
+	m.mutex.Unlock()
+	if !ok || !m.opts.Clock.Now().Before(report.ExpiresAt) {
+		return Report{}, nil, ErrNotFound
+	}
+	file, err := m.storage.Open(ctx, key(id))
+	if errors.Is(err, uploads.ErrNotUploaded) {
+		return Report{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Report{}, nil, err
+	}
+	return report, file, nil
+}
+
+// Cleanup deletes expired reports from storage, returning how many were
+// removed
+func (m *Manager) Cleanup(ctx context.Context) int {
+	now := m.opts.Clock.Now()
+	var expired []string
+	m.mutex.Lock()
+	for id, report := range m.reports {
+		if !now.Before(report.ExpiresAt) {
+			expired = append(expired, id)
+		}
+	}
+	m.mutex.Unlock()
+
+	removed := 0
+	for _, id := range expired {
+		if err := m.storage.Delete(ctx, key(id)); err != nil {
+			logger.ErrorContext(ctx, "Failed to delete report", "report", id, "error", err)
+			continue
+		}
+		m.mutex.Lock()
+		delete(m.reports, id)
+		m.mutex.Unlock()
+		removed++
+	}
+	return removed
+}
+
+// Run cleans up every interval until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := m.Cleanup(ctx); removed > 0 {
+				logger.Info("Removed expired reports", "count", removed)
+			}
+		}
+	}
+}
+
+// key returns where the report with id is stored
+func key(id string) string {
+	return "reports/" + id + ".pdf"
+}
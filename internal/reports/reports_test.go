@@ -0,0 +1,108 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// testUsers returns n users, one created each day before testNow and every
+// other one seen the day after they were created
+func testUsers(n int) []store.User {
+	users := make([]store.User, n)
+	for i := range users {
+		created := testNow.AddDate(0, 0, -i-1)
+		users[i] = store.User{ID: int64(i) + 1, Name: fmt.Sprintf("User %d", i+1), Email: fmt.Sprintf("user%d@example.com", i+1), CreatedAt: created}
+		if i%2 == 0 {
+			seen := created.AddDate(0, 0, 1)
+			users[i].LastSeenAt = &seen
+		}
+	}
+	return users
+}
+
+// pages counts the pages of a PDF
+func pages(data []byte) int {
+	return len(regexp.MustCompile(`/Type /Page\b[^s]`).FindAll(data, -1))
+}
+
+func TestSummarize(t *testing.T) {
+	s := summarize(testUsers(40), testNow)
+	assert.Equal(t, summary{total: 40, created7: 6, created30: 29, seen30: 15, neverSeen: 20}, s)
+}
+
+func TestManager_WriteUsers(t *testing.T) {
+	manager := NewManager(nil, Options{Clock: clock.NewFake(testNow)})
+
+	var small bytes.Buffer
+	require.NoError(t, manager.WriteUsers(&small, testUsers(3), nil))
+	assert.True(t, bytes.HasPrefix(small.Bytes(), []byte("%PDF-")))
+	assert.Equal(t, 1, pages(small.Bytes()))
+
+	// Long tables run over several pages, and progress is reported as
+	// they are drawn
+	var large bytes.Buffer
+	var reported []int
+	users := testUsers(200)
+	users[0].Name = "Zoë with a name far too long to fit in its column of the table"
+	require.NoError(t, manager.WriteUsers(&large, users, func(percent int) { reported = append(reported, percent) }))
+	assert.Greater(t, pages(large.Bytes()), 3)
+	require.NotEmpty(t, reported)
+	assert.Equal(t, 100, reported[len(reported)-1])
+
+	// An empty store still gets a report
+	var empty bytes.Buffer
+	require.NoError(t, manager.WriteUsers(&empty, nil, nil))
+	assert.Equal(t, 1, pages(empty.Bytes()))
+}
+
+func TestManager_StoreUsers(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewManager(nil, Options{}).StoreUsers(ctx, testUsers(1), nil)
+	assert.ErrorIs(t, err, ErrNoStorage)
+
+	storage, err := uploads.NewLocalStorage(t.TempDir(), "", nil)
+	require.NoError(t, err)
+	clk := clock.NewFake(testNow)
+	manager := NewManager(storage, Options{Retention: time.Hour, Clock: clk, IDs: idgen.NewSequence("report")})
+	assert.True(t, manager.CanStore())
+
+	report, err := manager.StoreUsers(ctx, testUsers(5), nil)
+	require.NoError(t, err)
+	assert.Equal(t, Report{ID: "report-1", URL: DownloadPath + "report-1", Size: report.Size, CreatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)}, report)
+
+	opened, file, err := manager.Open(ctx, report.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	file.Close()
+	assert.Equal(t, report, opened)
+	assert.Len(t, data, int(report.Size))
+	assert.True(t, bytes.HasPrefix(data, []byte("%PDF-")))
+
+	_, _, err = manager.Open(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Expired reports cannot be opened and are deleted by cleanup
+	clk.Advance(time.Hour)
+	_, _, err = manager.Open(ctx, report.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, manager.Cleanup(ctx))
+	_, err = storage.Stat(ctx, key(report.ID))
+	assert.ErrorIs(t, err, uploads.ErrNotUploaded)
+	assert.Equal(t, 0, manager.Cleanup(ctx))
+}
@@ -0,0 +1,152 @@
+// Package reqctx stores request-scoped values in a context.Context behind
+// typed accessors, so middleware and handlers share values without
+// stringly-typed keys or type assertions at every call site.
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Principal identifies the authenticated caller of a request
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+// HasRole reports whether the principal has been granted role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TraceContext identifies the trace a request belongs to and this
+// service's span within it
+type TraceContext struct {
+	// TraceID is 32 lowercase hex digits
+	TraceID string
+	// SpanID is this service's span, 16 lowercase hex digits
+	SpanID string
+	// ParentSpanID is the caller's span, empty for a root span
+	ParentSpanID string
+	Sampled      bool
+}
+
+// key is a context key holding a value of type T. Keys are compared by
+// pointer, so values cannot collide with other packages' keys.
+type key[T any] struct {
+	name string
+}
+
+func (k *key[T]) with(ctx context.Context, value T) context.Context {
+	return context.WithValue(ctx, k, value)
+}
+
+func (k *key[T]) from(ctx context.Context) (T, bool) {
+	value, ok := ctx.Value(k).(T)
+	return value, ok
+}
+
+func (k *key[T]) String() string {
+	return "reqctx." + k.name
+}
+
+var (
+	requestIDKey = &key[string]{name: "request_id"}
+	principalKey = &key[Principal]{name: "principal"}
+	tenantKey    = &key[string]{name: "tenant"}
+	loggerKey    = &key[*slog.Logger]{name: "logger"}
+	localeKey    = &key[string]{name: "locale"}
+	timezoneKey  = &key[*time.Location]{name: "timezone"}
+	pageSizeKey  = &key[int]{name: "page_size"}
+	traceKey     = &key[TraceContext]{name: "trace"}
+)
+
+// WithRequestID returns a context carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return requestIDKey.with(ctx, id)
+}
+
+// RequestID returns the request ID, if one was set
+func RequestID(ctx context.Context) (string, bool) {
+	return requestIDKey.from(ctx)
+}
+
+// WithPrincipal returns a context carrying the authenticated caller
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return principalKey.with(ctx, principal)
+}
+
+// PrincipalFrom returns the authenticated caller, if any
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	return principalKey.from(ctx)
+}
+
+// WithTenant returns a context carrying the tenant the request is for
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return tenantKey.with(ctx, tenant)
+}
+
+// Tenant returns the tenant the request is for, if known
+func Tenant(ctx context.Context) (string, bool) {
+	return tenantKey.from(ctx)
+}
+
+// WithLogger returns a context carrying a request-scoped logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return loggerKey.with(ctx, logger)
+}
+
+// Logger returns the request-scoped logger, falling back to slog.Default
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := loggerKey.from(ctx); ok && logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithLocale returns a context carrying the caller's preferred locale
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return localeKey.with(ctx, locale)
+}
+
+// Locale returns the caller's preferred locale, if known
+func Locale(ctx context.Context) (string, bool) {
+	return localeKey.from(ctx)
+}
+
+// WithTimezone returns a context carrying the time zone the caller wants
+// timestamps in
+func WithTimezone(ctx context.Context, loc *time.Location) context.Context {
+	return timezoneKey.with(ctx, loc)
+}
+
+// Timezone returns the time zone the caller wants timestamps in, if known
+func Timezone(ctx context.Context) (*time.Location, bool) {
+	return timezoneKey.from(ctx)
+}
+
+// WithPageSize returns a context carrying the caller's default page size
+func WithPageSize(ctx context.Context, size int) context.Context {
+	return pageSizeKey.with(ctx, size)
+}
+
+// PageSize returns the caller's default page size, if known
+func PageSize(ctx context.Context) (int, bool) {
+	return pageSizeKey.from(ctx)
+}
+
+// WithTrace returns a context carrying the request's trace context
+func WithTrace(ctx context.Context, trace TraceContext) context.Context {
+	return traceKey.with(ctx, trace)
+}
+
+// Trace returns the request's trace context, if known
+func Trace(ctx context.Context) (TraceContext, bool) {
+	return traceKey.from(ctx)
+}
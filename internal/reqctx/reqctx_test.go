@@ -0,0 +1,101 @@
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessors_RoundTrip(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	principal := Principal{Subject: "user-1", Roles: []string{"admin"}}
+	trace := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+
+	ctx := context.Background()
+	ctx = WithRequestID(ctx, "req-1")
+	ctx = WithPrincipal(ctx, principal)
+	ctx = WithTenant(ctx, "acme")
+	ctx = WithLogger(ctx, logger)
+	ctx = WithLocale(ctx, "en-GB")
+	ctx = WithTrace(ctx, trace)
+
+	requestID, ok := RequestID(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "req-1", requestID)
+
+	got, ok := PrincipalFrom(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, principal, got)
+	assert.True(t, got.HasRole("admin"))
+	assert.False(t, got.HasRole("auditor"))
+
+	tenant, ok := Tenant(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenant)
+
+	assert.Same(t, logger, Logger(ctx))
+
+	locale, ok := Locale(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "en-GB", locale)
+
+	gotTrace, ok := Trace(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, trace, gotTrace)
+}
+
+func TestAccessors_Missing(t *testing.T) {
+	ctx := context.Background()
+
+	_, ok := RequestID(ctx)
+	assert.False(t, ok)
+	_, ok = PrincipalFrom(ctx)
+	assert.False(t, ok)
+	_, ok = Tenant(ctx)
+	assert.False(t, ok)
+	_, ok = Locale(ctx)
+	assert.False(t, ok)
+	_, ok = Trace(ctx)
+	assert.False(t, ok)
+	assert.Same(t, slog.Default(), Logger(ctx))
+}
+
+func TestAccessors_KeysDoNotCollide(t *testing.T) {
+	// Request ID and tenant are both strings but must not share a key
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	_, ok := Tenant(ctx)
+	assert.False(t, ok)
+	_, ok = Locale(ctx)
+	assert.False(t, ok)
+}
+
+func TestTimings(t *testing.T) {
+	// Timing without timings in the context does nothing
+	Time(context.Background(), "store.GetAll")()
+
+	ctx, timings := WithTimings(context.Background())
+	got, ok := TimingsFrom(ctx)
+	assert.True(t, ok)
+	assert.Same(t, timings, got)
+
+	stopAuth := Time(ctx, "middleware.auth")
+	for range 2 {
+		stopStore := Time(ctx, "store.GetByID")
+		time.Sleep(time.Millisecond)
+		stopStore()
+	}
+	timings.Exclude("middleware.auth", 2*time.Millisecond)
+	stopAuth()
+
+	spans := timings.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "middleware.auth", spans[0].Name)
+	assert.Equal(t, 1, spans[0].Calls)
+	assert.Equal(t, "store.GetByID", spans[1].Name)
+	assert.Equal(t, 2, spans[1].Calls)
+	assert.GreaterOrEqual(t, spans[1].Duration, 2*time.Millisecond)
+}
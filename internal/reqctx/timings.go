@@ -0,0 +1,92 @@
+package reqctx
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Span is the time a request spent in one of its parts, such as a
+// middleware or a kind of store call, over every call to it
+type Span struct {
+	Name     string
+	Calls    int
+	Duration time.Duration
+}
+
+// Timings breaks a request's latency down into spans. It is safe for
+// concurrent use, so parts run in parallel can time themselves.
+type Timings struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+var timingsKey = &key[*Timings]{name: "timings"}
+
+// WithTimings returns a context recording the timings of the parts of a
+// request
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	timings := new(Timings)
+	return timingsKey.with(ctx, timings), timings
+}
+
+// TimingsFrom returns the request's timings, if they are being recorded
+func TimingsFrom(ctx context.Context) (*Timings, bool) {
+	return timingsKey.from(ctx)
+}
+
+// Time times a call to the part of the request in ctx named name, until
+// the returned function is called. It does nothing when ctx does not
+// record timings.
+func Time(ctx context.Context, name string) func() {
+	timings, ok := TimingsFrom(ctx)
+	if !ok {
+		return func() {}
+	}
+	return timings.Start(name)
+}
+
+// Start times a call to name until the returned function is called. Spans
+// are listed in the order they were first started.
+func (t *Timings) Start(name string) func() {
+	t.mu.Lock()
+	t.span(name)
+	t.mu.Unlock()
+
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		span := t.span(name)
+		span.Calls++
+		span.Duration += elapsed
+	}
+}
+
+// Exclude takes elapsed, spent in parts nested in name and timed as spans
+// of their own, off name's duration
+func (t *Timings) Exclude(name string, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.span(name).Duration -= elapsed
+}
+
+// Spans returns the spans recorded so far
+func (t *Timings) Spans() []Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.spans)
+}
+
+// span returns the span named name, adding it if it is new
+func (t *Timings) span(name string) *Span {
+	for i := range t.spans {
+		if t.spans[i].Name == name {
+			return &t.spans[i]
+		}
+	}
+	t.spans = append(t.spans, Span{Name: name})
+	return &t.spans[len(t.spans)-1]
+}
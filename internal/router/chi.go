@@ -0,0 +1,42 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type chiRouter struct {
+	mux *chi.Mux
+}
+
+// NewChi creates a Router backed by chi
+func NewChi() Router {
+	return &chiRouter{mux: chi.NewRouter()}
+}
+
+// Handle registers handler. chi sets path values itself, except that its
+// catch-all is always named "*", so that value is copied to the route's name.
+func (c *chiRouter) Handle(method, path string, handler http.Handler) {
+	segments := parse(path)
+	chiPath := format(segments, func(s segment) string {
+		if s.wildcard {
+			return "*"
+		}
+		return "{" + s.param + "}"
+	})
+
+	if wildcard := wildcardParam(segments); wildcard != "" {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.SetPathValue(wildcard, chi.URLParam(r, "*"))
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	c.mux.Method(method, chiPath, handler)
+}
+
+func (c *chiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
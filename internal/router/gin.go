@@ -0,0 +1,47 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ginRouter struct {
+	engine *gin.Engine
+}
+
+// NewGin creates a Router backed by a gin engine. The engine keeps its own
+// middleware and options, such as gin.Default's logger and recovery.
+func NewGin(engine *gin.Engine) Router {
+	return &ginRouter{engine: engine}
+}
+
+// Handle registers handler, copying gin's parameters into the request so
+// handlers can read them with r.PathValue
+func (g *ginRouter) Handle(method, path string, handler http.Handler) {
+	segments := parse(path)
+	wildcard := wildcardParam(segments)
+	ginPath := format(segments, func(s segment) string {
+		if s.wildcard {
+			return "*" + s.param
+		}
+		return ":" + s.param
+	})
+
+	g.engine.Handle(method, ginPath, func(c *gin.Context) {
+		for _, param := range c.Params {
+			value := param.Value
+			if param.Key == wildcard {
+				// gin includes the leading slash in catch-all values
+				value = strings.TrimPrefix(value, "/")
+			}
+			c.Request.SetPathValue(param.Key, value)
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	})
+}
+
+func (g *ginRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.engine.ServeHTTP(w, r)
+}
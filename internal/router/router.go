@@ -0,0 +1,109 @@
+// Package router mounts plain net/http handlers on interchangeable HTTP
+// routers, so handler code does not depend on a particular framework.
+//
+// Route paths use net/http ServeMux pattern syntax, e.g. /api/v1/users/{id}
+// or /swagger/{any...}, and handlers read parameters with r.PathValue
+// whichever router serves them.
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Router engines selectable through configuration
+const (
+	EngineGin    = "gin"
+	EngineChi    = "chi"
+	EngineStdlib = "stdlib"
+)
+
+// Route is a single endpoint served by a handler
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.Handler
+}
+
+// Router registers routes on an underlying HTTP router
+type Router interface {
+	http.Handler
+	Handle(method, path string, handler http.Handler)
+}
+
+// Mount registers routes on r
+func Mount(r Router, routes []Route) {
+	for _, route := range routes {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+}
+
+// Wrap returns routes with each handler wrapped by middleware
+func Wrap(routes []Route, middleware func(http.Handler) http.Handler) []Route {
+	wrapped := make([]Route, len(routes))
+	for i, route := range routes {
+		route.Handler = middleware(route.Handler)
+		wrapped[i] = route
+	}
+	return wrapped
+}
+
+// Validate reports whether engine names a supported router
+func Validate(engine string) error {
+	switch engine {
+	case EngineGin, EngineChi, EngineStdlib:
+		return nil
+	default:
+		return fmt.Errorf("unknown router %q, expected %s, %s or %s", engine, EngineGin, EngineChi, EngineStdlib)
+	}
+}
+
+// segment is one part of a route path
+type segment struct {
+	literal  string
+	param    string
+	wildcard bool
+}
+
+// parse splits a ServeMux-style path into segments
+func parse(path string) []segment {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	segments := make([]segment, 0, len(parts))
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			segments = append(segments, segment{literal: part})
+			continue
+		}
+		name := part[1 : len(part)-1]
+		if trimmed, ok := strings.CutSuffix(name, "..."); ok {
+			segments = append(segments, segment{param: trimmed, wildcard: true})
+			continue
+		}
+		segments = append(segments, segment{param: name})
+	}
+	return segments
+}
+
+// format renders segments back into a path, using param to render
+// parameter segments in the target router's syntax
+func format(segments []segment, param func(segment) string) string {
+	var b strings.Builder
+	for _, s := range segments {
+		b.WriteByte('/')
+		if s.param == "" {
+			b.WriteString(s.literal)
+			continue
+		}
+		b.WriteString(param(s))
+	}
+	return b.String()
+}
+
+// wildcardParam returns the name of the trailing wildcard parameter, if any
+func wildcardParam(segments []segment) string {
+	if len(segments) > 0 && segments[len(segments)-1].wildcard {
+		return segments[len(segments)-1].param
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRouters() map[string]Router {
+	gin.SetMode(gin.TestMode)
+	return map[string]Router{
+		EngineGin:    NewGin(gin.New()),
+		EngineChi:    NewChi(),
+		EngineStdlib: NewStdlib(),
+	}
+}
+
+func TestRouters_ServeRoutes(t *testing.T) {
+	echo := func(params ...string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, r.Method)
+			for _, param := range params {
+				_, _ = fmt.Fprintf(w, " %s=%s", param, r.PathValue(param))
+			}
+		})
+	}
+	routes := []Route{
+		{Method: http.MethodGet, Path: "/health", Handler: echo()},
+		{Method: http.MethodGet, Path: "/api/v1/users/{id}", Handler: echo("id")},
+		{Method: http.MethodDelete, Path: "/api/v1/users/{id}", Handler: echo("id")},
+		{Method: http.MethodGet, Path: "/files/{path...}", Handler: echo("path")},
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "static path", method: http.MethodGet, path: "/health", expectedCode: http.StatusOK, expectedBody: "GET"},
+		{name: "path parameter", method: http.MethodGet, path: "/api/v1/users/42", expectedCode: http.StatusOK, expectedBody: "GET id=42"},
+		{name: "method selects route", method: http.MethodDelete, path: "/api/v1/users/7", expectedCode: http.StatusOK, expectedBody: "DELETE id=7"},
+		{name: "wildcard parameter", method: http.MethodGet, path: "/files/docs/index.html", expectedCode: http.StatusOK, expectedBody: "GET path=docs/index.html"},
+		{name: "unknown path", method: http.MethodGet, path: "/missing", expectedCode: http.StatusNotFound},
+	}
+
+	for engine, r := range newTestRouters() {
+		Mount(r, routes)
+
+		for _, tt := range tests {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(tt.method, tt.path, nil)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				assert.Equal(t, tt.expectedCode, w.Code)
+				if tt.expectedBody != "" {
+					assert.Equal(t, tt.expectedBody, w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(EngineGin))
+	assert.NoError(t, Validate(EngineChi))
+	assert.NoError(t, Validate(EngineStdlib))
+	assert.Error(t, Validate("echo"))
+}
@@ -0,0 +1,23 @@
+package router
+
+import (
+	"net/http"
+)
+
+type stdlibRouter struct {
+	mux *http.ServeMux
+}
+
+// NewStdlib creates a Router backed by net/http's ServeMux
+func NewStdlib() Router {
+	return &stdlibRouter{mux: http.NewServeMux()}
+}
+
+// Handle registers handler using ServeMux's method-qualified patterns
+func (s *stdlibRouter) Handle(method, path string, handler http.Handler) {
+	s.mux.Handle(method+" "+path, handler)
+}
+
+func (s *stdlibRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,481 @@
+// Package rules runs rules from the configuration on user events: when a
+// rule's condition holds for an event, its actions run, tagging or
+// suspending the user, sending a notification or posting to a webhook.
+// Conditions are Lua expressions run in the scripts sandbox. Every action a
+// rule triggers is written to the audit log and kept in a bounded list of
+// recent entries, and rules can be evaluated against a user without running
+// their actions, to try them out.
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/scripts"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// logger logs triggered actions, and failed deliveries which outlive the
+// requests that caused them
+var logger = logging.Named(logging.Events)
+
+// Events lists the events rules can react to
+var Events = []string{notify.EventUserCreated, notify.EventUserUpdated}
+
+// Actions rules can take
+const (
+	ActionTag     = "tag"
+	ActionSuspend = "suspend"
+	ActionNotify  = "notify"
+	ActionWebhook = "webhook"
+)
+
+// ActionTypes lists the actions rules can take
+var ActionTypes = []string{ActionTag, ActionSuspend, ActionNotify, ActionWebhook}
+
+// deliveryTimeout bounds a single notification or webhook post
+const deliveryTimeout = 10 * time.Second
+
+// ErrUnknownEvent is returned when evaluating rules for an event they
+// cannot react to
+var ErrUnknownEvent = errors.New("unknown event")
+
+// Action is something a rule does to the user an event is about
+type Action struct {
+	Type string `json:"type" example:"tag"`
+	// Tag is added to the user by tag actions
+	Tag string `json:"tag,omitempty" example:"vip"`
+	// Channel is the notification channel of notify actions, and Message
+	// what is sent over it
+	Channel string `json:"channel,omitempty" example:"log"`
+	Message string `json:"message,omitempty" example:"Welcome aboard"`
+	// URL receives webhook actions, signed with Secret when one is set
+	URL    string `json:"url,omitempty" example:"https://crm.example.com/hooks/rules"`
+	Secret string `json:"-"`
+}
+
+// Rule runs actions on the events it reacts to when its condition holds
+type Rule struct {
+	Name string
+	// Events are the events the rule reacts to; all of them when empty
+	Events []string
+	// Condition is a Lua expression about event, user and before; the rule
+	// always matches when it is empty
+	Condition string
+	Actions   []Action
+}
+
+// Options configures an Engine
+type Options struct {
+	// Notifiers are the channels notify actions can use
+	Notifiers map[string]notify.Notifier
+	// Timeout bounds each evaluation of a condition
+	Timeout time.Duration
+	// AuditSize is the number of audit entries kept
+	AuditSize int
+	Client    *http.Client
+	Clock     clock.Clock
+}
+
+// Evaluation is the outcome of a rule for an event
+type Evaluation struct {
+	Rule    string `json:"rule" example:"flag-free-mail"`
+	Matched bool   `json:"matched" example:"true"`
+	// Actions are the actions the rule triggers, when it matched
+	Actions []Action `json:"actions,omitempty"`
+	// Error is why the condition could not be evaluated
+	Error string `json:"error,omitempty" example:""`
+}
+
+// Entry is an audit entry for an action a rule triggered
+type Entry struct {
+	Time   time.Time `json:"time" example:"2024-01-02T15:04:05Z"`
+	Rule   string    `json:"rule" example:"flag-free-mail"`
+	Event  string    `json:"event" example:"user.created"`
+	Action string    `json:"action" example:"tag"`
+	UserID int64     `json:"user_id" example:"1"`
+	// Detail is the tag, channel or URL of the action
+	Detail string `json:"detail,omitempty" example:"free-mail"`
+}
+
+// Status is what rules have done to a user
+type Status struct {
+	UserID int64    `json:"user_id" example:"1"`
+	Tags   []string `json:"tags" example:"free-mail"`
+	// Suspension is set while the user is suspended
+	Suspension *Suspension `json:"suspension,omitempty"`
+}
+
+// Suspension says when and by which rule a user was suspended
+type Suspension struct {
+	Rule string    `json:"rule" example:"block-disposable"`
+	Time time.Time `json:"time" example:"2024-01-02T15:04:05Z"`
+}
+
+// compiled is a rule ready to evaluate
+type compiled struct {
+	Rule
+	condition *scripts.Condition
+}
+
+// Engine evaluates rules on user events and runs their actions. It is a
+// handlers.UserListener, and tells the auth service which users are
+// suspended.
+type Engine struct {
+	rules []compiled
+	opts  Options
+
+	mutex     sync.Mutex
+	tags      map[int64][]string
+	suspended map[int64]Suspension
+	// audit holds the newest entries, oldest first
+	audit []Entry
+
+	pending sync.WaitGroup
+}
+
+// New creates an engine for rules, compiling their conditions
+func New(rules []Rule, opts Options) (*Engine, error) {
+	if opts.AuditSize <= 0 {
+		opts.AuditSize = 1000
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+
+	engine := &Engine{
+		opts:      opts,
+		tags:      make(map[int64][]string),
+		suspended: make(map[int64]Suspension),
+	}
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		if err := engine.check(rule); err != nil {
+			return nil, err
+		}
+		condition, err := scripts.NewCondition(rule.Name, rule.Condition, opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		engine.rules = append(engine.rules, compiled{Rule: rule, condition: condition})
+	}
+	return engine, nil
+}
+
+// check validates the events and actions of rule
+func (e *Engine) check(rule Rule) error {
+	for _, event := range rule.Events {
+		if !slices.Contains(Events, event) {
+			return fmt.Errorf("rule %s: unknown event %q, expected one of %s", rule.Name, event, strings.Join(Events, ", "))
+		}
+	}
+	if len(rule.Actions) == 0 {
+		return fmt.Errorf("rule %s has no actions", rule.Name)
+	}
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case ActionTag:
+			if action.Tag == "" {
+				return fmt.Errorf("rule %s: tag action has no tag", rule.Name)
+			}
+		case ActionSuspend:
+		case ActionNotify:
+			if _, ok := e.opts.Notifiers[action.Channel]; !ok {
+				return fmt.Errorf("rule %s: notify action uses unknown notification channel %q", rule.Name, action.Channel)
+			}
+		case ActionWebhook:
+			if u, err := url.Parse(action.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("rule %s: webhook action needs an http or https URL", rule.Name)
+			}
+		default:
+			return fmt.Errorf("rule %s: unknown action %q, expected one of %s", rule.Name, action.Type, strings.Join(ActionTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// UserCreated runs the rules for user.created
+func (e *Engine) UserCreated(ctx context.Context, user store.User) {
+	e.react(ctx, notify.EventUserCreated, user, nil)
+}
+
+// UserUpdated runs the rules for user.updated
+func (e *Engine) UserUpdated(ctx context.Context, before, after store.User) {
+	e.react(ctx, notify.EventUserUpdated, after, &before)
+}
+
+// react runs the actions of the rules that match event. Rules whose
+// condition fails are logged and skipped.
+func (e *Engine) react(ctx context.Context, event string, user store.User, before *store.User) {
+	for _, evaluation := range e.evaluate(ctx, event, user, before) {
+		if evaluation.Error != "" {
+			logging.FromContext(ctx, logging.Events).Error("Failed to evaluate rule", "rule", evaluation.Rule, "event", event, "user_id", user.ID, "error", evaluation.Error)
+			continue
+		}
+		for _, action := range evaluation.Actions {
+			e.run(ctx, evaluation.Rule, event, user, action)
+		}
+	}
+}
+
+// Evaluate evaluates the rules for event about user, without running their
+// actions. before is the user before an update, or nil.
+func (e *Engine) Evaluate(ctx context.Context, event string, user store.User, before *store.User) ([]Evaluation, error) {
+	if !slices.Contains(Events, event) {
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownEvent, event, strings.Join(Events, ", "))
+	}
+	return e.evaluate(ctx, event, user, before), nil
+}
+
+// evaluate evaluates the rules reacting to event, in order
+func (e *Engine) evaluate(ctx context.Context, event string, user store.User, before *store.User) []Evaluation {
+	evaluations := make([]Evaluation, 0, len(e.rules))
+	for _, rule := range e.rules {
+		if len(rule.Events) > 0 && !slices.Contains(rule.Events, event) {
+			continue
+		}
+		evaluation := Evaluation{Rule: rule.Name}
+		matched, err := rule.condition.Holds(ctx, event, user, before)
+		switch {
+		case err != nil:
+			evaluation.Error = err.Error()
+		case matched:
+			evaluation.Matched = true
+			evaluation.Actions = rule.Actions
+		}
+		evaluations = append(evaluations, evaluation)
+	}
+	return evaluations
+}
+
+// run runs action for the rule called rule, auditing it. Notifications and
+// webhooks are sent in the background.
+func (e *Engine) run(ctx context.Context, rule, event string, user store.User, action Action) {
+	entry := Entry{Time: e.opts.Clock.Now().UTC(), Rule: rule, Event: event, Action: action.Type, UserID: user.ID}
+	switch action.Type {
+	case ActionTag:
+		entry.Detail = action.Tag
+		e.mutex.Lock()
+		if !slices.Contains(e.tags[user.ID], action.Tag) {
+			e.tags[user.ID] = append(e.tags[user.ID], action.Tag)
+		}
+		e.mutex.Unlock()
+	case ActionSuspend:
+		e.mutex.Lock()
+		if _, ok := e.suspended[user.ID]; !ok {
+			e.suspended[user.ID] = Suspension{Rule: rule, Time: entry.Time}
+		}
+		e.mutex.Unlock()
+	case ActionNotify:
+		entry.Detail = action.Channel
+		n := notify.Notification{Event: event, UserID: user.ID, Email: user.Email, Message: action.Message, Time: entry.Time}
+		notifier := e.opts.Notifiers[action.Channel]
+		// Delivery outlives the request that triggered it
+		e.pending.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, n); err != nil && !errors.Is(err, notify.ErrNoRecipient) {
+				logger.Error("Failed to send rule notification", "rule", rule, "channel", action.Channel, "user_id", user.ID, "error", err)
+			}
+		})
+	case ActionWebhook:
+		entry.Detail = action.URL
+		body, err := json.Marshal(webhookEvent{Rule: rule, Event: event, Time: entry.Time, User: user})
+		if err != nil {
+			logging.FromContext(ctx, logging.Events).Error("Failed to encode rule webhook", "rule", rule, "error", err)
+			return
+		}
+		e.pending.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+			defer cancel()
+			if err := e.post(ctx, action, body); err != nil {
+				logger.Error("Failed to deliver rule webhook", "rule", rule, "user_id", user.ID, "error", err)
+			}
+		})
+	}
+
+	logging.FromContext(ctx, logging.Events).Info("Audit: rule triggered",
+		"rule", rule, "event", event, "action", action.Type, "user_id", user.ID, "detail", entry.Detail)
+	e.record(entry)
+}
+
+// webhookEvent is the body posted by webhook actions
+type webhookEvent struct {
+	Rule  string     `json:"rule"`
+	Event string     `json:"event"`
+	Time  time.Time  `json:"time"`
+	User  store.User `json:"user"`
+}
+
+func (e *Engine) post(ctx context.Context, action Action, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, action.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if action.Secret != "" {
+		req.Header.Set(notify.SignatureHeader, notify.Sign(action.Secret, body))
+	}
+
+	resp, err := e.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// record adds entry to the audit entries, dropping the oldest beyond the
+// configured size
+func (e *Engine) record(entry Entry) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.audit = append(e.audit, entry)
+	if over := len(e.audit) - e.opts.AuditSize; over > 0 {
+		e.audit = slices.Delete(e.audit, 0, over)
+	}
+}
+
+// Audit returns up to limit of the newest audit entries, newest first, or
+// all of them when limit is not positive. Entries about a user only are
+// returned when userID is positive.
+func (e *Engine) Audit(userID int64, limit int) []Entry {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	entries := []Entry{}
+	for _, entry := range slices.Backward(e.audit) {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		if userID <= 0 || entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ImportAudit adds entries to the audit entries, skipping those already
+// there, and keeps the newest up to the configured size
+func (e *Engine) ImportAudit(entries []Entry) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, entry := range entries {
+		// Times are compared by instant, as archived ones lose their location
+		known := slices.ContainsFunc(e.audit, func(other Entry) bool {
+			same := other.Time.Equal(entry.Time)
+			other.Time = entry.Time
+			return same && other == entry
+		})
+		if !known {
+			e.audit = append(e.audit, entry)
+		}
+	}
+	slices.SortStableFunc(e.audit, func(a, b Entry) int { return a.Time.Compare(b.Time) })
+	if over := len(e.audit) - e.opts.AuditSize; over > 0 {
+		e.audit = slices.Delete(e.audit, 0, over)
+	}
+}
+
+// Status returns the tags and suspension rules gave a user
+func (e *Engine) Status(userID int64) Status {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	status := Status{UserID: userID, Tags: slices.Clone(e.tags[userID])}
+	if status.Tags == nil {
+		status.Tags = []string{}
+	}
+	if suspension, ok := e.suspended[userID]; ok {
+		status.Suspension = &suspension
+	}
+	return status
+}
+
+// Suspended reports whether a rule suspended the user
+func (e *Engine) Suspended(userID int64) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, ok := e.suspended[userID]
+	return ok
+}
+
+// Lift lifts the suspension of a user, reporting whether they were
+// suspended
+func (e *Engine) Lift(userID int64) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, ok := e.suspended[userID]
+	delete(e.suspended, userID)
+	return ok
+}
+
+// RemapUser moves what rules did to the user from to the user to, e.g.
+// when merging duplicate users: audit entries, tags and a suspension, unless
+// to is suspended already. It returns the number of them moved.
+func (e *Engine) RemapUser(from, to int64) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	moved := 0
+	for i := range e.audit {
+		if e.audit[i].UserID == from {
+			e.audit[i].UserID = to
+			moved++
+		}
+	}
+	for _, tag := range e.tags[from] {
+		if !slices.Contains(e.tags[to], tag) {
+			e.tags[to] = append(e.tags[to], tag)
+		}
+		moved++
+	}
+	delete(e.tags, from)
+	if suspension, ok := e.suspended[from]; ok {
+		if _, suspended := e.suspended[to]; !suspended {
+			e.suspended[to] = suspension
+		}
+		delete(e.suspended, from)
+		moved++
+	}
+	return moved
+}
+
+// Wait waits for notifications and webhooks being sent, until ctx expires
+func (e *Engine) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// recordingNotifier records the notifications it is sent
+type recordingNotifier struct {
+	mutex sync.Mutex
+	sent  []notify.Notification
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, notification notify.Notification) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	notifiers := map[string]notify.Notifier{notify.ChannelLog: notify.NewLog()}
+	tag := []Action{{Type: ActionTag, Tag: "vip"}}
+
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "every action", rules: []Rule{{Name: "all", Events: Events, Condition: `user.id > 0`, Actions: []Action{
+			{Type: ActionTag, Tag: "vip"},
+			{Type: ActionSuspend},
+			{Type: ActionNotify, Channel: notify.ChannelLog, Message: "Hello"},
+			{Type: ActionWebhook, URL: "https://crm.example.com/hooks"},
+		}}}},
+		{name: "no name", rules: []Rule{{Actions: tag}}, wantErr: "rule 1 has no name"},
+		{name: "defined twice", rules: []Rule{{Name: "vip", Actions: tag}, {Name: "vip", Actions: tag}}, wantErr: "defined twice"},
+		{name: "unknown event", rules: []Rule{{Name: "vip", Events: []string{"user.deleted"}, Actions: tag}}, wantErr: "unknown event"},
+		{name: "no actions", rules: []Rule{{Name: "vip"}}, wantErr: "has no actions"},
+		{name: "unknown action", rules: []Rule{{Name: "vip", Actions: []Action{{Type: "delete"}}}}, wantErr: "unknown action"},
+		{name: "tag without tag", rules: []Rule{{Name: "vip", Actions: []Action{{Type: ActionTag}}}}, wantErr: "has no tag"},
+		{name: "unknown channel", rules: []Rule{{Name: "vip", Actions: []Action{{Type: ActionNotify, Channel: notify.ChannelSMS}}}}, wantErr: "unknown notification channel"},
+		{name: "webhook without URL", rules: []Rule{{Name: "vip", Actions: []Action{{Type: ActionWebhook, URL: "crm"}}}}, wantErr: "needs an http or https URL"},
+		{name: "invalid condition", rules: []Rule{{Name: "vip", Condition: `user.id ==`, Actions: tag}}, wantErr: "invalid condition vip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.rules, Options{Notifiers: notifiers})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestEngine_UserEvents(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	notifier := &recordingNotifier{}
+	posted := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		posted <- r
+	}))
+	defer server.Close()
+
+	engine, err := New([]Rule{
+		{Name: "free-mail", Events: []string{notify.EventUserCreated}, Condition: `user.email:match("@gmail%.com$") ~= nil`, Actions: []Action{
+			{Type: ActionTag, Tag: "free-mail"},
+			{Type: ActionNotify, Channel: "test", Message: "Consider a work address"},
+		}},
+		{Name: "disposable", Condition: `user.email:match("@mailinator%.com$") ~= nil`, Actions: []Action{{Type: ActionSuspend}}},
+		{Name: "email-changed", Events: []string{notify.EventUserUpdated}, Condition: `before.email ~= user.email`, Actions: []Action{
+			{Type: ActionWebhook, URL: server.URL, Secret: "s3cret"},
+		}},
+		{Name: "broken", Condition: `user.missing.field`, Actions: []Action{{Type: ActionTag, Tag: "never"}}},
+	}, Options{Notifiers: map[string]notify.Notifier{"test": notifier}, Clock: clk})
+	require.NoError(t, err)
+
+	john := store.User{ID: 1, Name: "John Doe", Email: "john@gmail.com"}
+	engine.UserCreated(context.Background(), john)
+	jane := store.User{ID: 2, Name: "Jane Smith", Email: "jane@example.com"}
+	engine.UserCreated(context.Background(), jane)
+	// Rules only react to the events they list
+	engine.UserUpdated(context.Background(), john, john)
+	require.NoError(t, engine.Wait(context.Background()))
+
+	assert.Equal(t, Status{UserID: 1, Tags: []string{"free-mail"}}, engine.Status(1))
+	assert.Equal(t, Status{UserID: 2, Tags: []string{}}, engine.Status(2))
+	require.Len(t, notifier.sent, 1)
+	assert.Equal(t, notify.Notification{Event: notify.EventUserCreated, UserID: 1, Email: "john@gmail.com", Message: "Consider a work address", Time: clk.Now()}, notifier.sent[0])
+
+	clk.Advance(time.Minute)
+	changed := jane
+	changed.Email = "jane@mailinator.com"
+	engine.UserUpdated(context.Background(), jane, changed)
+	require.NoError(t, engine.Wait(context.Background()))
+
+	assert.True(t, engine.Suspended(2))
+	assert.Equal(t, &Suspension{Rule: "disposable", Time: clk.Now()}, engine.Status(2).Suspension)
+	r := <-posted
+	assert.Equal(t, notify.Sign("s3cret", body), r.Header.Get(notify.SignatureHeader))
+	var event webhookEvent
+	require.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, webhookEvent{Rule: "email-changed", Event: notify.EventUserUpdated, Time: clk.Now(), User: changed}, event)
+
+	// Every triggered action is audited, newest first
+	assert.Equal(t, []Entry{
+		{Time: clk.Now(), Rule: "email-changed", Event: notify.EventUserUpdated, Action: ActionWebhook, UserID: 2, Detail: server.URL},
+		{Time: clk.Now(), Rule: "disposable", Event: notify.EventUserUpdated, Action: ActionSuspend, UserID: 2},
+		{Time: clk.Now().Add(-time.Minute), Rule: "free-mail", Event: notify.EventUserCreated, Action: ActionNotify, UserID: 1, Detail: "test"},
+		{Time: clk.Now().Add(-time.Minute), Rule: "free-mail", Event: notify.EventUserCreated, Action: ActionTag, UserID: 1, Detail: "free-mail"},
+	}, engine.Audit(0, 0))
+	assert.Len(t, engine.Audit(1, 0), 2)
+	assert.Len(t, engine.Audit(0, 1), 1)
+
+	assert.True(t, engine.Lift(2))
+	assert.False(t, engine.Lift(2))
+	assert.False(t, engine.Suspended(2))
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	engine, err := New([]Rule{
+		{Name: "vip", Condition: `user.name == "John Doe"`, Actions: []Action{{Type: ActionTag, Tag: "vip"}, {Type: ActionSuspend}}},
+		{Name: "renamed", Events: []string{notify.EventUserUpdated}, Condition: `before.name ~= user.name`, Actions: []Action{{Type: ActionTag, Tag: "renamed"}}},
+		{Name: "broken", Condition: `user.missing.field`, Actions: []Action{{Type: ActionTag, Tag: "never"}}},
+	}, Options{})
+	require.NoError(t, err)
+	john := store.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+
+	evaluations, err := engine.Evaluate(context.Background(), notify.EventUserCreated, john, nil)
+	require.NoError(t, err)
+	require.Len(t, evaluations, 2)
+	assert.Equal(t, Evaluation{Rule: "vip", Matched: true, Actions: []Action{{Type: ActionTag, Tag: "vip"}, {Type: ActionSuspend}}}, evaluations[0])
+	assert.Equal(t, "broken", evaluations[1].Rule)
+	assert.False(t, evaluations[1].Matched)
+	assert.Contains(t, evaluations[1].Error, "broken failed")
+
+	before := john
+	before.Name = "Johnny"
+	evaluations, err = engine.Evaluate(context.Background(), notify.EventUserUpdated, john, &before)
+	require.NoError(t, err)
+	assert.Equal(t, Evaluation{Rule: "renamed", Matched: true, Actions: []Action{{Type: ActionTag, Tag: "renamed"}}}, evaluations[1])
+
+	// Dry runs run no actions
+	assert.Equal(t, Status{UserID: 1, Tags: []string{}}, engine.Status(1))
+	assert.Empty(t, engine.Audit(0, 0))
+
+	_, err = engine.Evaluate(context.Background(), "user.deleted", john, nil)
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestEngine_AuditIsBounded(t *testing.T) {
+	engine, err := New([]Rule{{Name: "vip", Actions: []Action{{Type: ActionTag, Tag: "vip"}}}}, Options{AuditSize: 3})
+	require.NoError(t, err)
+
+	for id := range int64(5) {
+		engine.UserCreated(context.Background(), store.User{ID: id + 1})
+	}
+
+	audit := engine.Audit(0, 0)
+	require.Len(t, audit, 3)
+	assert.Equal(t, int64(5), audit[0].UserID)
+	assert.Equal(t, int64(3), audit[2].UserID)
+}
+
+func TestEngine_RemapUser(t *testing.T) {
+	engine, err := New([]Rule{
+		{Name: "free-mail", Condition: `user.email:match("@gmail%.com$") ~= nil`, Actions: []Action{{Type: ActionTag, Tag: "free-mail"}}},
+		{Name: "vip", Condition: `user.name == "John Doe"`, Actions: []Action{{Type: ActionTag, Tag: "vip"}, {Type: ActionSuspend}}},
+	}, Options{})
+	require.NoError(t, err)
+	engine.UserCreated(context.Background(), store.User{ID: 1, Name: "John", Email: "john@gmail.com"})
+	engine.UserCreated(context.Background(), store.User{ID: 2, Name: "John Doe", Email: "john.doe@gmail.com"})
+
+	// Three audit entries, two tags and a suspension move to the survivor
+	assert.Equal(t, 6, engine.RemapUser(2, 1))
+	assert.Equal(t, []string{"free-mail", "vip"}, engine.Status(1).Tags)
+	assert.True(t, engine.Suspended(1))
+	assert.Equal(t, Status{UserID: 2, Tags: []string{}}, engine.Status(2))
+	assert.Len(t, engine.Audit(1, 0), 4)
+	assert.Empty(t, engine.Audit(2, 0))
+	assert.Zero(t, engine.RemapUser(2, 1))
+}
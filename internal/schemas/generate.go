@@ -0,0 +1,162 @@
+package schemas
+
+import (
+	"errors"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema dialect of the server's schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Generate returns the JSON Schema of the JSON encoding of v's type, so the
+// schemas of resources follow their Go types. Fields are named by their
+// json tags, and are required unless they are omitted when empty or read
+// only. A format tag sets the format of a string field, and a readonly:"true"
+// tag marks fields the server sets, which clients need not send.
+func Generate(v any) *Schema {
+	t := reflect.TypeOf(v)
+	schema := generate(t)
+	schema.Schema = Draft
+	schema.Title = t.Name()
+	return schema
+}
+
+func generate(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		// Byte slices encode as base64
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"}
+		}
+		return &Schema{Type: "array", Items: generate(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addFields(schema, t)
+		return schema
+	}
+	// Anything else, such as interfaces, may be any JSON value
+	return &Schema{}
+}
+
+// addFields adds the fields of the struct type t to schema, with those of
+// embedded structs, which encoding/json inlines
+func addFields(schema *Schema, t reflect.Type) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(schema, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := generate(field.Type)
+		if format := field.Tag.Get("format"); format != "" {
+			property.Format = format
+		}
+		property.ReadOnly = field.Tag.Get("readonly") == "true"
+		schema.Properties[name] = property
+
+		omitted := false
+		for option := range strings.SplitSeq(options, ",") {
+			omitted = omitted || option == "omitempty" || option == "omitzero"
+		}
+		if !omitted && !property.ReadOnly {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// Coerce converts form or query values, which are all strings, into the
+// JSON value the schema's properties expect, so they decode as a JSON body
+// would: integers, numbers and booleans are parsed, arrays take every value
+// given and other properties take the first. Values that are not properties
+// are left out, and the first that does not parse is returned as a
+// *ValidationError.
+func (s *Schema) Coerce(values url.Values) (map[string]any, error) {
+	object := make(map[string]any)
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		property, ok := s.Properties[name]
+		if !ok || len(values[name]) == 0 {
+			continue
+		}
+		var value any
+		var err error
+		if property.Type == "array" {
+			items := make([]any, len(values[name]))
+			for i, text := range values[name] {
+				if items[i], err = coerce(property.Items, text); err != nil {
+					break
+				}
+			}
+			value = items
+		} else {
+			value, err = coerce(property, values[name][0])
+		}
+		if err != nil {
+			return nil, &ValidationError{Problems: []Problem{{Field: name, Message: err.Error()}}}
+		}
+		object[name] = value
+	}
+	return object, nil
+}
+
+// coerce converts text into the JSON value schema expects
+func coerce(schema *Schema, text string) (any, error) {
+	if schema == nil {
+		return text, nil
+	}
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be of type integer")
+		}
+		return n, nil
+	case "number":
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, errors.New("must be of type number")
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, errors.New("must be of type boolean")
+		}
+		return b, nil
+	}
+	return text, nil
+}
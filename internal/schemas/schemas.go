@@ -0,0 +1,283 @@
+// Package schemas holds the versioned JSON Schemas of the event payloads
+// the server sends, so consumers can validate what they receive. Each
+// schema is embedded from events/{type}/{version}.json. A payload's shape
+// never changes within a version: changes that could break consumers get a
+// new version, and events declare the version they conform to.
+package schemas
+
+import (
+	"cmp"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/email"
+)
+
+//go:embed events
+var events embed.FS
+
+// Path is where schemas are served, followed by {type}/{version}
+const Path = "/schemas/"
+
+// ErrNotFound is returned for schema types and versions that do not exist
+var ErrNotFound = errors.New("schema not found")
+
+// Schema is a JSON Schema, limited to the keywords the server's schemas
+// use
+type Schema struct {
+	Schema      string   `json:"$schema,omitempty"`
+	ID          string   `json:"$id,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	Const       any      `json:"const,omitempty"`
+	Enum        []any    `json:"enum,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	// ReadOnly marks properties the server sets, which clients need not
+	// send
+	ReadOnly   bool               `json:"readOnly,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	// AdditionalProperties allows properties Properties leaves out when
+	// it is nil or true
+	AdditionalProperties *bool   `json:"additionalProperties,omitempty"`
+	Items                *Schema `json:"items,omitempty"`
+}
+
+// Problem is a way a value violates a schema
+type Problem struct {
+	// Field is the path of the offending value, such as user.email, or
+	// empty for the value itself
+	Field   string `json:"field,omitempty" example:"email"`
+	Message string `json:"message" example:"must be formatted as email"`
+}
+
+// ValidationError lists every way a value violates a schema
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = cmp.Or(problem.Field, "payload") + ": " + problem.Message
+	}
+	return "invalid payload: " + strings.Join(problems, "; ")
+}
+
+// Validate checks a value decoded from JSON against the schema, returning
+// a *ValidationError listing every violation
+func (s *Schema) Validate(value any) error {
+	var problems []Problem
+	s.validate(value, "", &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateJSON checks a JSON document against the schema
+func (s *Schema) ValidateJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return s.Validate(value)
+}
+
+func (s *Schema) validate(value any, at string, problems *[]Problem) {
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, Problem{Field: at, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(value, s.Const) {
+		fail("must be %v", s.Const)
+		return
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(v any) bool { return reflect.DeepEqual(v, value) }) {
+		fail("must be one of %v", s.Enum)
+		return
+	}
+	if s.Type != "" && !hasType(value, s.Type) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		if !hasFormat(v, s.Format) {
+			fail("must be formatted as %s", s.Format)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, at+"["+strconv.Itoa(i)+"]", problems)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("%s is required", name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			property, ok := s.Properties[name]
+			switch {
+			case ok:
+				property.validate(v[name], join(at, name), problems)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				fail("%s is not allowed", name)
+			}
+		}
+	}
+}
+
+// hasType reports whether a value decoded from JSON has the JSON Schema
+// type typ
+func hasType(value any, typ string) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == float64(int64(v)))
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
+
+// hasFormat reports whether s is formatted as format. Unknown formats are
+// not checked, as JSON Schema allows.
+func hasFormat(s, format string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	case "email":
+		return email.Valid(s)
+	}
+	return true
+}
+
+func join(at, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+// Ref identifies a schema
+type Ref struct {
+	Type    string `json:"type" example:"user.created"`
+	Version int    `json:"version" example:"1"`
+	// URI is where the schema is served, which events give as their
+	// dataschema
+	URI string `json:"uri" example:"https://api.example.com/schemas/user.created/1"`
+}
+
+// Registry holds the schemas of every event type
+type Registry struct {
+	baseURL string
+	schemas map[string]map[int]*Schema
+}
+
+// NewRegistry loads the embedded schemas, which are identified by URIs
+// under baseURL, the server's public URL
+func NewRegistry(baseURL string) (*Registry, error) {
+	r := &Registry{baseURL: strings.TrimSuffix(baseURL, "/"), schemas: make(map[string]map[int]*Schema)}
+	err := fs.WalkDir(events, "events", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		typ := path.Base(path.Dir(name))
+		version, err := strconv.Atoi(strings.TrimSuffix(path.Base(name), ".json"))
+		if err != nil || version < 1 {
+			return fmt.Errorf("schema %s is not named {version}.json", name)
+		}
+		data, err := events.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var schema Schema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return fmt.Errorf("invalid schema %s: %w", name, err)
+		}
+		schema.ID = r.URI(typ, version)
+		if r.schemas[typ] == nil {
+			r.schemas[typ] = make(map[int]*Schema)
+		}
+		r.schemas[typ][version] = &schema
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// URI returns the URI of a schema
+func (r *Registry) URI(typ string, version int) string {
+	return r.baseURL + Path + typ + "/" + strconv.Itoa(version)
+}
+
+// List returns every schema, ordered by type and version
+func (r *Registry) List() []Ref {
+	var refs []Ref
+	for _, typ := range slices.Sorted(maps.Keys(r.schemas)) {
+		for _, version := range slices.Sorted(maps.Keys(r.schemas[typ])) {
+			refs = append(refs, Ref{Type: typ, Version: version, URI: r.URI(typ, version)})
+		}
+	}
+	return refs
+}
+
+// Get returns version of the schema of typ
+func (r *Registry) Get(typ string, version int) (*Schema, error) {
+	schema, ok := r.schemas[typ][version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s version %d", ErrNotFound, typ, version)
+	}
+	return schema, nil
+}
+
+// Latest returns the newest version of the schema of typ, which events of
+// that type are sent as
+func (r *Registry) Latest(typ string) (Ref, error) {
+	versions := r.schemas[typ]
+	if len(versions) == 0 {
+		return Ref{}, fmt.Errorf("%w: %s", ErrNotFound, typ)
+	}
+	version := slices.Max(slices.Collect(maps.Keys(versions)))
+	return Ref{Type: typ, Version: version, URI: r.URI(typ, version)}, nil
+}
+
+// Validate checks the JSON payload of an event against the schema ref
+// names
+func (r *Registry) Validate(ref Ref, payload []byte) error {
+	schema, err := r.Get(ref.Type, ref.Version)
+	if err != nil {
+		return err
+	}
+	return schema.ValidateJSON(payload)
+}
@@ -0,0 +1,179 @@
+package schemas
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry, err := NewRegistry("https://api.example.com/")
+	require.NoError(t, err)
+
+	assert.Equal(t, []Ref{
+		{Type: "user.created", Version: 1, URI: "https://api.example.com/schemas/user.created/1"},
+		{Type: "user.updated", Version: 1, URI: "https://api.example.com/schemas/user.updated/1"},
+	}, registry.List())
+
+	latest, err := registry.Latest("user.created")
+	require.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+	schema, err := registry.Get("user.created", 1)
+	require.NoError(t, err)
+	assert.Equal(t, latest.URI, schema.ID)
+
+	_, err = registry.Get("user.created", 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = registry.Latest("user.deleted")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSchema_ValidateJSON(t *testing.T) {
+	registry, err := NewRegistry("")
+	require.NoError(t, err)
+	schema, err := registry.Get("user.updated", 1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "valid", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05.123Z","user":{"id":1,"name":"John Doe","email":"john@example.com","created_at":"2024-01-01T09:00:00Z"}}`},
+		{name: "other event", payload: `{"event":"user.created","time":"2024-01-02T15:04:05Z","user":{"id":1,"name":"John Doe","email":"john@example.com"}}`, wantErr: "event: must be user.updated"},
+		{name: "missing user", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z"}`, wantErr: "payload: user is required"},
+		{name: "bad time", payload: `{"event":"user.updated","time":"yesterday","user":{"id":1,"name":"John Doe","email":"john@example.com"}}`, wantErr: "time: must be formatted as date-time"},
+		{name: "fractional id", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z","user":{"id":1.5,"name":"John Doe","email":"john@example.com"}}`, wantErr: "user.id: must be of type integer"},
+		{name: "zero id", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z","user":{"id":0,"name":"John Doe","email":"john@example.com"}}`, wantErr: "user.id: must be at least 1"},
+		{name: "bad email", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z","user":{"id":1,"name":"John Doe","email":"John <john@example.com>"}}`, wantErr: "user.email: must be formatted as email"},
+		{name: "internationalized email", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z","user":{"id":1,"name":"Jörg Müller","email":"jörg@bücher.de"}}`},
+		{name: "bad email domain", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z","user":{"id":1,"name":"John Doe","email":"john@exa_mple.com"}}`, wantErr: "user.email: must be formatted as email"},
+		{name: "unknown field", payload: `{"event":"user.updated","time":"2024-01-02T15:04:05Z","user":{"id":1,"name":"John Doe","email":"john@example.com","role":"admin"}}`, wantErr: "user: role is not allowed"},
+		{name: "not JSON", payload: `user`, wantErr: "invalid payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.ValidateJSON([]byte(tt.payload))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	type Base struct {
+		ID int `json:"id" readonly:"true"`
+	}
+	type Item struct {
+		Base
+		Name     string            `json:"name"`
+		Email    string            `json:"email" format:"email"`
+		Tags     []string          `json:"tags,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Score    *float64          `json:"score,omitempty"`
+		Active   bool              `json:"active"`
+		Created  time.Time         `json:"created_at,omitzero" readonly:"true"`
+		Internal string            `json:"-"`
+		hidden   string
+	}
+	schema := Generate(Item{})
+	assert.Equal(t, Draft, schema.Schema)
+	assert.Equal(t, "Item", schema.Title)
+	assert.Equal(t, "object", schema.Type)
+	assert.Equal(t, []string{"name", "email", "active"}, schema.Required)
+	assert.ElementsMatch(t, []string{"id", "name", "email", "tags", "labels", "score", "active", "created_at"}, slices.Collect(maps.Keys(schema.Properties)))
+	assert.Equal(t, &Schema{Type: "integer", ReadOnly: true}, schema.Properties["id"])
+	assert.Equal(t, &Schema{Type: "string", Format: "email"}, schema.Properties["email"])
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Type: "string"}}, schema.Properties["tags"])
+	assert.Equal(t, &Schema{Type: "number"}, schema.Properties["score"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time", ReadOnly: true}, schema.Properties["created_at"])
+
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "valid", payload: `{"name":"John Doe","email":"john@example.com","active":true,"tags":["a"]}`},
+		{name: "read only fields may be sent", payload: `{"id":1,"name":"John Doe","email":"john@example.com","active":true}`},
+		{name: "missing name", payload: `{"email":"john@example.com","active":true}`, wantErr: "payload: name is required"},
+		{name: "bad tag", payload: `{"name":"John Doe","email":"john@example.com","active":true,"tags":[1]}`, wantErr: "tags[0]: must be of type string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.ValidateJSON([]byte(tt.payload))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	var invalid *ValidationError
+	require.ErrorAs(t, schema.ValidateJSON([]byte(`{"name":1,"email":"john","active":true}`)), &invalid)
+	assert.Equal(t, []Problem{{Field: "email", Message: "must be formatted as email"}, {Field: "name", Message: "must be of type string"}}, invalid.Problems)
+}
+
+func TestSchema_Coerce(t *testing.T) {
+	type Item struct {
+		ID     int      `json:"id"`
+		Name   string   `json:"name"`
+		Score  float64  `json:"score"`
+		Active bool     `json:"active"`
+		Tags   []string `json:"tags"`
+		Sizes  []int    `json:"sizes"`
+	}
+	schema := Generate(Item{})
+
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name:   "types are parsed",
+			values: url.Values{"id": {"7"}, "name": {"John Doe"}, "score": {"1.5"}, "active": {"true"}},
+			want:   map[string]any{"id": int64(7), "name": "John Doe", "score": 1.5, "active": true},
+		},
+		{
+			name:   "arrays take every value",
+			values: url.Values{"tags": {"a", "b"}, "sizes": {"1", "2"}},
+			want:   map[string]any{"tags": []any{"a", "b"}, "sizes": []any{int64(1), int64(2)}},
+		},
+		{
+			name:   "other properties take the first value",
+			values: url.Values{"name": {"John", "Jane"}},
+			want:   map[string]any{"name": "John"},
+		},
+		{
+			name:   "unknown values are left out",
+			values: url.Values{"name": {"John"}, "async": {"true"}},
+			want:   map[string]any{"name": "John"},
+		},
+		{name: "bad integer", values: url.Values{"id": {"abc"}}, wantErr: "id: must be of type integer"},
+		{name: "bad boolean", values: url.Values{"active": {"maybe"}}, wantErr: "active: must be of type boolean"},
+		{name: "bad array item", values: url.Values{"sizes": {"1", "x"}}, wantErr: "sizes: must be of type integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.Coerce(tt.values)
+			if tt.wantErr != "" {
+				var invalid *ValidationError
+				require.ErrorAs(t, err, &invalid)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter selects users, parsed from a filter expression such as
+// userName eq "john@example.com" and active eq true
+type Filter struct {
+	root node
+}
+
+// ParseFilter parses a filter over the User attributes id, externalId,
+// userName, displayName, name.formatted, name.givenName, name.familyName,
+// emails, emails.value, emails.type, active, meta.created and
+// meta.lastModified. It supports the operators eq, ne, co, sw, ew, gt, ge,
+// lt, le and pr, combined with and, or, not and parentheses. Comparisons
+// ignore case, except of ids.
+func ParseFilter(expr string) (*Filter, error) {
+	root, err := parseExpr(expr, userAttrs)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{root: root}, nil
+}
+
+// Matches reports whether u passes the filter
+func (f *Filter) Matches(u User) bool {
+	return f.root.match(u.values)
+}
+
+// userAttrs lists the filterable User attributes, lowercased
+var userAttrs = map[string]bool{
+	"id": true, "externalid": true, "username": true, "displayname": true,
+	"name.formatted": true, "name.givenname": true, "name.familyname": true,
+	"emails": true, "emails.value": true, "emails.type": true,
+	"active": true, "meta.created": true, "meta.lastmodified": true,
+}
+
+// emailAttrs lists the attributes of an email, for value filters in paths
+// such as emails[type eq "work"]
+var emailAttrs = map[string]bool{"value": true, "type": true, "primary": true}
+
+// caseExact lists the attributes compared with case
+var caseExact = map[string]bool{"id": true, "externalid": true}
+
+// values returns the values u holds for attr, which is lowercased
+func (u User) values(attr string) []string {
+	var values []string
+	add := func(v string) {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	switch attr {
+	case "id":
+		add(u.ID)
+	case "externalid":
+		add(u.ExternalID)
+	case "username":
+		add(u.UserName)
+	case "displayname":
+		add(u.DisplayName)
+	case "name.formatted", "name.givenname", "name.familyname":
+		if u.Name != nil {
+			add(map[string]string{
+				"name.formatted":  u.Name.Formatted,
+				"name.givenname":  u.Name.GivenName,
+				"name.familyname": u.Name.FamilyName,
+			}[attr])
+		}
+	case "emails", "emails.value":
+		for _, e := range u.Emails {
+			add(e.Value)
+		}
+	case "emails.type":
+		for _, e := range u.Emails {
+			add(e.Type)
+		}
+	case "active":
+		add(strconv.FormatBool(u.IsActive()))
+	case "meta.created", "meta.lastmodified":
+		if u.Meta != nil {
+			at := u.Meta.Created
+			if attr == "meta.lastmodified" {
+				at = u.Meta.LastModified
+			}
+			if !at.IsZero() {
+				add(at.UTC().Format("2006-01-02T15:04:05Z07:00"))
+			}
+		}
+	}
+	return values
+}
+
+// values returns the values e holds for attr, which is lowercased
+func (e Email) values(attr string) []string {
+	switch attr {
+	case "value":
+		return []string{e.Value}
+	case "type":
+		return []string{e.Type}
+	case "primary":
+		return []string{strconv.FormatBool(e.Primary)}
+	}
+	return nil
+}
+
+// node is a parsed filter expression, matched against a resource's values
+type node interface {
+	match(values func(attr string) []string) bool
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n logical) match(values func(string) []string) bool {
+	if n.and {
+		return n.left.match(values) && n.right.match(values)
+	}
+	return n.left.match(values) || n.right.match(values)
+}
+
+type negation struct {
+	inner node
+}
+
+func (n negation) match(values func(string) []string) bool {
+	return !n.inner.match(values)
+}
+
+type comparison struct {
+	attr, op, value string
+}
+
+func (n comparison) match(values func(string) []string) bool {
+	found := values(n.attr)
+	if n.op == "pr" {
+		return len(found) > 0
+	}
+	if n.op == "ne" {
+		return !comparison{attr: n.attr, op: "eq", value: n.value}.match(values)
+	}
+	want := n.value
+	if !caseExact[n.attr] {
+		want = strings.ToLower(want)
+	}
+	for _, v := range found {
+		if !caseExact[n.attr] {
+			v = strings.ToLower(v)
+		}
+		if compare(v, n.op, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(v, op, want string) bool {
+	switch op {
+	case "eq":
+		return v == want
+	case "co":
+		return strings.Contains(v, want)
+	case "sw":
+		return strings.HasPrefix(v, want)
+	case "ew":
+		return strings.HasSuffix(v, want)
+	case "gt":
+		return v > want
+	case "ge":
+		return v >= want
+	case "lt":
+		return v < want
+	case "le":
+		return v <= want
+	}
+	return false
+}
+
+// operators lists the comparison operators
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// token is a lexed word, string or parenthesis
+type token struct {
+	text   string
+	quoted bool
+}
+
+// parser parses filter expressions by recursive descent, with and binding
+// tighter than or
+type parser struct {
+	tokens []token
+	pos    int
+	attrs  map[string]bool
+}
+
+// parseExpr parses expr, whose attributes must be among attrs
+func parseExpr(expr string, attrs map[string]bool) (node, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, attrs: attrs}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalid(ErrInvalidFilter, fmt.Sprintf("unexpected %q", p.tokens[p.pos].text))
+	}
+	return root, nil
+}
+
+func (p *parser) peek(word string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, word)
+}
+
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, invalid(ErrInvalidFilter, "unexpected end of filter")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.peek("or") {
+		p.pos++
+		var right node
+		if right, err = p.and(); err == nil {
+			left = logical{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.factor()
+	for err == nil && p.peek("and") {
+		p.pos++
+		var right node
+		if right, err = p.factor(); err == nil {
+			left = logical{and: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) factor() (node, error) {
+	negate := p.peek("not")
+	if negate {
+		p.pos++
+		if !p.peek("(") {
+			return nil, invalid(ErrInvalidFilter, "not must be followed by a parenthesized filter")
+		}
+	}
+	if p.peek("(") {
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, invalid(ErrInvalidFilter, "missing )")
+		}
+		p.pos++
+		if negate {
+			return negation{inner: inner}, nil
+		}
+		return inner, nil
+	}
+
+	attr, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(strings.TrimPrefix(attr.text, SchemaUser+":"))
+	if attr.quoted || !p.attrs[name] {
+		return nil, invalid(ErrInvalidFilter, fmt.Sprintf("unknown attribute %q", attr.text))
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	opName := strings.ToLower(op.text)
+	if op.quoted || !operators[opName] {
+		return nil, invalid(ErrInvalidFilter, fmt.Sprintf("unknown operator %q", op.text))
+	}
+	if opName == "pr" {
+		return comparison{attr: name, op: opName}, nil
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !value.quoted {
+		switch v := strings.ToLower(value.text); {
+		case v == "true" || v == "false":
+			value.text = v
+		case v == "null" || v == "(" || v == ")":
+			return nil, invalid(ErrInvalidFilter, fmt.Sprintf("unsupported value %q", value.text))
+		default:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return nil, invalid(ErrInvalidFilter, fmt.Sprintf("values must be quoted, got %s", value.text))
+			}
+		}
+	}
+	return comparison{attr: name, op: opName, value: value.text}, nil
+}
+
+// lex splits expr into words, JSON strings and parentheses
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, invalid(ErrInvalidFilter, "unterminated string")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(expr[i:end+1]), &s); err != nil {
+				return nil, invalid(ErrInvalidFilter, fmt.Sprintf("invalid string %s", expr[i:end+1]))
+			}
+			tokens = append(tokens, token{text: s, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t()\"", rune(expr[end])) {
+				end++
+			}
+			tokens = append(tokens, token{text: expr[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, invalid(ErrInvalidFilter, "empty filter")
+	}
+	return tokens, nil
+}
@@ -0,0 +1,270 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PatchOp is a PATCH request, a list of operations applied in order
+type PatchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation adds, replaces or removes the attribute at Path, or with no
+// path, the attributes in Value
+type Operation struct {
+	Op    string          `json:"op" example:"replace"`
+	Path  string          `json:"path,omitempty" example:"name.formatted"`
+	Value json.RawMessage `json:"value,omitempty" swaggertype:"object"`
+}
+
+// Apply applies the operations to u in order, stopping at the first that
+// fails. It supports the paths userName, displayName, externalId, active,
+// name, name.formatted, name.givenName, name.familyName, emails and value
+// filters on emails such as emails[type eq "work"].value.
+func (p PatchOp) Apply(u *User) error {
+	if len(p.Operations) == 0 {
+		return invalid(ErrInvalidSyntax, "no operations")
+	}
+	for _, op := range p.Operations {
+		kind := strings.ToLower(op.Op)
+		if kind != "add" && kind != "replace" && kind != "remove" {
+			return invalid(ErrInvalidSyntax, fmt.Sprintf("unknown op %q", op.Op))
+		}
+		if err := apply(u, kind, op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply applies a single operation of kind, which is lowercased
+func apply(u *User, kind, path string, value json.RawMessage) error {
+	if path == "" {
+		if kind == "remove" {
+			return invalid(ErrNoTarget, "remove needs a path")
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attrs); err != nil {
+			return invalid(ErrInvalidValue, "value must be an object of attributes when there is no path")
+		}
+		for attr, v := range attrs {
+			if err := apply(u, kind, attr, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path = strings.TrimPrefix(path, SchemaUser+":")
+	if open := strings.IndexByte(path, '['); open >= 0 {
+		return applyEmails(u, kind, path, open, value)
+	}
+
+	attr := strings.ToLower(path)
+	if kind == "remove" {
+		return remove(u, attr, path)
+	}
+	switch attr {
+	case "username", "displayname", "externalid", "name.formatted", "name.givenname", "name.familyname":
+		s, err := decodeString(path, value)
+		if err != nil {
+			return err
+		}
+		*stringAttr(u, attr) = s
+	case "active":
+		active, err := decodeBool(path, value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+	case "name":
+		var name Name
+		if err := json.Unmarshal(value, &name); err != nil {
+			return invalid(ErrInvalidValue, "name must be an object")
+		}
+		if kind == "replace" || u.Name == nil {
+			u.Name = &name
+			return nil
+		}
+		for _, field := range []struct {
+			to   *string
+			from string
+		}{
+			{&u.Name.Formatted, name.Formatted},
+			{&u.Name.GivenName, name.GivenName},
+			{&u.Name.FamilyName, name.FamilyName},
+		} {
+			if field.from != "" {
+				*field.to = field.from
+			}
+		}
+	case "emails":
+		var emails []Email
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return invalid(ErrInvalidValue, "emails must be an array of emails")
+		}
+		if kind == "add" {
+			emails = append(u.Emails, emails...)
+		}
+		u.Emails = emails
+	default:
+		return invalid(ErrInvalidPath, fmt.Sprintf("unsupported path %q", path))
+	}
+	return nil
+}
+
+// remove removes the attribute at path, lowercased as attr
+func remove(u *User, attr, path string) error {
+	switch attr {
+	case "displayname", "externalid", "name.formatted", "name.givenname", "name.familyname":
+		*stringAttr(u, attr) = ""
+	case "name":
+		u.Name = nil
+	case "emails":
+		u.Emails = nil
+	case "username", "active":
+		return invalid(ErrInvalidPath, fmt.Sprintf("%s cannot be removed", path))
+	default:
+		return invalid(ErrInvalidPath, fmt.Sprintf("unsupported path %q", path))
+	}
+	return nil
+}
+
+// stringAttr returns the string attribute attr of u, creating its name if
+// needed
+func stringAttr(u *User, attr string) *string {
+	switch attr {
+	case "username":
+		return &u.UserName
+	case "displayname":
+		return &u.DisplayName
+	case "externalid":
+		return &u.ExternalID
+	}
+	if u.Name == nil {
+		u.Name = &Name{}
+	}
+	switch attr {
+	case "name.givenname":
+		return &u.Name.GivenName
+	case "name.familyname":
+		return &u.Name.FamilyName
+	default:
+		return &u.Name.Formatted
+	}
+}
+
+// applyEmails applies an operation to the emails selected by the value
+// filter in path, which opens at index open. Adding to or replacing a
+// sub-attribute of emails of a type the user has none of adds one.
+func applyEmails(u *User, kind, path string, open int, value json.RawMessage) error {
+	closing := strings.LastIndexByte(path, ']')
+	if closing < open || !strings.EqualFold(path[:open], "emails") {
+		return invalid(ErrInvalidPath, fmt.Sprintf("unsupported path %q", path))
+	}
+	filter, err := parseExpr(path[open+1:closing], emailAttrs)
+	if err != nil {
+		return invalid(ErrInvalidPath, fmt.Sprintf("invalid filter in path %q: %v", path, err))
+	}
+	sub := strings.ToLower(strings.TrimPrefix(path[closing+1:], "."))
+	if sub != "" && !emailAttrs[sub] {
+		return invalid(ErrInvalidPath, fmt.Sprintf("unsupported path %q", path))
+	}
+
+	var selected []int
+	for i, e := range u.Emails {
+		if filter.match(e.values) {
+			selected = append(selected, i)
+		}
+	}
+
+	if kind == "remove" {
+		if sub != "" {
+			return invalid(ErrInvalidPath, fmt.Sprintf("%s cannot be removed; remove the email instead", path))
+		}
+		kept := u.Emails[:0]
+		for i, e := range u.Emails {
+			if !slices.Contains(selected, i) {
+				kept = append(kept, e)
+			}
+		}
+		u.Emails = kept
+		return nil
+	}
+
+	if sub == "" {
+		var email Email
+		if err := json.Unmarshal(value, &email); err != nil {
+			return invalid(ErrInvalidValue, "value must be an email")
+		}
+		if len(selected) == 0 {
+			return invalid(ErrNoTarget, fmt.Sprintf("no email matches %q", path))
+		}
+		for _, i := range selected {
+			u.Emails[i] = email
+		}
+		return nil
+	}
+
+	if len(selected) == 0 {
+		typed, ok := filter.(comparison)
+		if !ok || typed.attr != "type" || typed.op != "eq" {
+			return invalid(ErrNoTarget, fmt.Sprintf("no email matches %q", path))
+		}
+		u.Emails = append(u.Emails, Email{Type: typed.value})
+		selected = []int{len(u.Emails) - 1}
+	}
+	for _, i := range selected {
+		email := &u.Emails[i]
+		switch sub {
+		case "value", "type":
+			s, err := decodeString(path, value)
+			if err != nil {
+				return err
+			}
+			if sub == "value" {
+				email.Value = s
+			} else {
+				email.Type = s
+			}
+		case "primary":
+			primary, err := decodeBool(path, value)
+			if err != nil {
+				return err
+			}
+			email.Primary = primary
+		}
+	}
+	return nil
+}
+
+func decodeString(path string, value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", invalid(ErrInvalidValue, fmt.Sprintf("%s must be a string", path))
+	}
+	return s, nil
+}
+
+// decodeBool decodes a boolean, also accepting "True" and "False" strings
+// as sent by some providers
+func decodeBool(path string, value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, invalid(ErrInvalidValue, fmt.Sprintf("%s must be a boolean", path))
+}
@@ -0,0 +1,196 @@
+// Package scim holds the SCIM 2.0 (RFC 7643 and RFC 7644) resources and
+// messages used to provision users, with filter parsing and PATCH
+// operations for the subset of the User schema this service stores.
+package scim
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema URNs
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// Error types, from RFC 7644 section 3.12
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidValue  = "invalidValue"
+	ErrNoTarget      = "noTarget"
+	ErrUniqueness    = "uniqueness"
+)
+
+// User is a SCIM User resource
+type User struct {
+	Schemas     []string `json:"schemas,omitempty"`
+	ID          string   `json:"id,omitempty" example:"1"`
+	ExternalID  string   `json:"externalId,omitempty" example:"00u1a2b3c4"`
+	UserName    string   `json:"userName" example:"john@example.com"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty" example:"John Doe"`
+	Emails      []Email  `json:"emails,omitempty"`
+	// Active is absent from some providers, meaning active
+	Active *bool `json:"active,omitempty" example:"true"`
+	Meta   *Meta `json:"meta,omitempty"`
+}
+
+// Name is a user's name
+type Name struct {
+	Formatted  string `json:"formatted,omitempty" example:"John Doe"`
+	GivenName  string `json:"givenName,omitempty" example:"John"`
+	FamilyName string `json:"familyName,omitempty" example:"Doe"`
+}
+
+// Email is one of a user's email addresses
+type Email struct {
+	Value   string `json:"value" example:"john@example.com"`
+	Type    string `json:"type,omitempty" example:"work"`
+	Primary bool   `json:"primary,omitempty" example:"true"`
+}
+
+// Meta describes a resource
+type Meta struct {
+	ResourceType string    `json:"resourceType" example:"User"`
+	Created      time.Time `json:"created,omitzero" example:"2024-01-01T09:00:00Z"`
+	LastModified time.Time `json:"lastModified,omitzero" example:"2024-01-02T10:30:00Z"`
+	Location     string    `json:"location,omitempty" example:"/scim/v2/Users/1"`
+	// Version is the resource's ETag, sent back in If-Match to change it
+	// only while it is unchanged
+	Version string `json:"version,omitempty" example:"\"3\""`
+}
+
+// IsActive reports whether the user is active
+func (u User) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// PrimaryEmail returns the user's primary email, their first, or their
+// userName when it is an email address
+func (u User) PrimaryEmail() string {
+	var email string
+	for _, e := range u.Emails {
+		if email == "" || e.Primary {
+			email = e.Value
+		}
+	}
+	if email == "" && strings.Contains(u.UserName, "@") {
+		email = u.UserName
+	}
+	return email
+}
+
+// FullName returns the most complete name the user has
+func (u User) FullName() string {
+	switch {
+	case u.Name != nil && u.Name.Formatted != "":
+		return u.Name.Formatted
+	case u.Name != nil && (u.Name.GivenName != "" || u.Name.FamilyName != ""):
+		return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+	case u.DisplayName != "":
+		return u.DisplayName
+	default:
+		return u.UserName
+	}
+}
+
+// ListResponse is a page of users
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults" example:"1"`
+	StartIndex   int      `json:"startIndex" example:"1"`
+	ItemsPerPage int      `json:"itemsPerPage" example:"1"`
+	Resources    []User   `json:"Resources"`
+}
+
+// NewListResponse returns the page of users starting at the 1-based
+// startIndex out of total
+func NewListResponse(users []User, startIndex, total int) ListResponse {
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	}
+}
+
+// Error is a SCIM error response, and an error
+type Error struct {
+	Schemas []string `json:"schemas"`
+	// Status is the HTTP status code, as a string
+	Status   string `json:"status" example:"400"`
+	ScimType string `json:"scimType,omitempty" example:"invalidFilter"`
+	Detail   string `json:"detail" example:"unknown attribute \"nickName\""`
+}
+
+// NewError returns an error responded with status, of scimType if set
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Schemas: []string{SchemaError}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail}
+}
+
+// invalid returns a 400 error of scimType
+func invalid(scimType, detail string) *Error {
+	return NewError(http.StatusBadRequest, scimType, detail)
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status code to respond with
+func (e *Error) StatusCode() int {
+	code, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return code
+}
+
+// ServiceProviderConfig describes the SCIM features a service supports
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  Bulk                   `json:"bulk"`
+	Filter                Filtering              `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+}
+
+// Supported reports whether a feature is supported
+type Supported struct {
+	Supported bool `json:"supported" example:"true"`
+}
+
+// Bulk describes support for bulk operations
+type Bulk struct {
+	Supported      bool `json:"supported" example:"false"`
+	MaxOperations  int  `json:"maxOperations" example:"0"`
+	MaxPayloadSize int  `json:"maxPayloadSize" example:"0"`
+}
+
+// Filtering describes support for filters
+type Filtering struct {
+	Supported  bool `json:"supported" example:"true"`
+	MaxResults int  `json:"maxResults" example:"200"`
+}
+
+// AuthenticationScheme is a way of authenticating to the service
+type AuthenticationScheme struct {
+	Type        string `json:"type" example:"oauthbearertoken"`
+	Name        string `json:"name" example:"Bearer token"`
+	Description string `json:"description" example:"A static bearer token in the Authorization header"`
+}
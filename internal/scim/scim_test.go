@@ -0,0 +1,203 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func testUser() User {
+	return User{
+		ID:          "7",
+		ExternalID:  "Ext-1",
+		UserName:    "Jane@Example.com",
+		Name:        &Name{Formatted: "Jane Roe", GivenName: "Jane", FamilyName: "Roe"},
+		DisplayName: "Jane",
+		Emails: []Email{
+			{Value: "jane@example.com", Type: "work", Primary: true},
+			{Value: "jane@home.example", Type: "home"},
+		},
+		Meta: &Meta{ResourceType: "User", Created: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		matches bool
+	}{
+		{`userName eq "jane@example.com"`, true},
+		{`USERNAME Eq "JANE@EXAMPLE.COM"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "jane@example.com"`, true},
+		{`userName ne "jane@example.com"`, false},
+		{`id eq "7"`, true},
+		{`externalId eq "ext-1"`, false},
+		{`emails co "home"`, true},
+		{`emails.value ew ".example"`, true},
+		{`emails.type eq "other"`, false},
+		{`name.familyName sw "R"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`meta.created gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.lastModified pr`, false},
+		{`displayName pr and name.givenName eq "Jane"`, true},
+		{`displayName eq "x" or id eq "7"`, true},
+		{`displayName eq "x" or id eq "7" and active eq false`, false},
+		{`(displayName eq "x" or id eq "7") and active eq true`, true},
+		{`not (id eq "7")`, false},
+		{`displayName eq "Say \"hi\""`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.matches, filter.Matches(testUser()))
+		})
+	}
+}
+
+func TestFilter_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"":                           "empty filter",
+		`nickName eq "x"`:            `unknown attribute "nickName"`,
+		`userName like "x"`:          `unknown operator "like"`,
+		`userName eq`:                "unexpected end of filter",
+		`userName eq jane`:           "values must be quoted, got jane",
+		`userName eq null`:           `unsupported value "null"`,
+		`userName eq "x`:             "unterminated string",
+		`(userName pr`:               "missing )",
+		`not userName pr`:            "not must be followed by a parenthesized filter",
+		`userName pr userName pr`:    `unexpected "userName"`,
+		`"userName" eq "jane"`:       `unknown attribute "userName"`,
+		`userName eq "x" and`:        "unexpected end of filter",
+		`userName eq "x" or or`:      `unknown attribute "or"`,
+		`emails[type eq "work"] pr`:  `unknown attribute "emails[type"`,
+		`userName eq "\x"`:           `invalid string "\x"`,
+		`userName eq "a" and "b" pr`: `unknown attribute "b"`,
+	}
+	for filter, detail := range tests {
+		t.Run(filter, func(t *testing.T) {
+			_, err := ParseFilter(filter)
+			var scimErr *Error
+			require.ErrorAs(t, err, &scimErr)
+			assert.Equal(t, ErrInvalidFilter, scimErr.ScimType)
+			assert.Equal(t, 400, scimErr.StatusCode())
+			assert.Equal(t, detail, scimErr.Detail)
+		})
+	}
+}
+
+func TestPatchOp_Apply(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations string
+		check      func(t *testing.T, u User)
+		scimType   string
+	}{
+		{
+			name:       "replace attribute",
+			operations: `[{"op": "Replace", "path": "displayName", "value": "J"}]`,
+			check:      func(t *testing.T, u User) { assert.Equal(t, "J", u.DisplayName) },
+		},
+		{
+			name:       "no path",
+			operations: `[{"op": "replace", "value": {"active": false, "name.givenName": "Janet"}}]`,
+			check: func(t *testing.T, u User) {
+				assert.Equal(t, ptr(false), u.Active)
+				assert.Equal(t, "Janet", u.Name.GivenName)
+			},
+		},
+		{
+			name:       "active as a string",
+			operations: `[{"op": "replace", "path": "active", "value": "False"}]`,
+			check:      func(t *testing.T, u User) { assert.False(t, u.IsActive()) },
+		},
+		{
+			name:       "add merges name",
+			operations: `[{"op": "add", "path": "name", "value": {"familyName": "Doe"}}]`,
+			check: func(t *testing.T, u User) {
+				assert.Equal(t, &Name{Formatted: "Jane Roe", GivenName: "Jane", FamilyName: "Doe"}, u.Name)
+			},
+		},
+		{
+			name:       "replace replaces name",
+			operations: `[{"op": "replace", "path": "name", "value": {"familyName": "Doe"}}]`,
+			check:      func(t *testing.T, u User) { assert.Equal(t, &Name{FamilyName: "Doe"}, u.Name) },
+		},
+		{
+			name:       "add appends emails",
+			operations: `[{"op": "add", "path": "emails", "value": [{"value": "j@other.example"}]}]`,
+			check:      func(t *testing.T, u User) { assert.Len(t, u.Emails, 3) },
+		},
+		{
+			name:       "email value by type",
+			operations: `[{"op": "replace", "path": "emails[type eq \"home\"].value", "value": "jane@new.example"}]`,
+			check:      func(t *testing.T, u User) { assert.Equal(t, "jane@new.example", u.Emails[1].Value) },
+		},
+		{
+			name:       "email of a new type",
+			operations: `[{"op": "add", "path": "emails[type eq \"other\"].value", "value": "jane@other.example"}]`,
+			check: func(t *testing.T, u User) {
+				assert.Equal(t, Email{Value: "jane@other.example", Type: "other"}, u.Emails[2])
+			},
+		},
+		{
+			name:       "remove email",
+			operations: `[{"op": "remove", "path": "emails[value co \"home\"]"}]`,
+			check:      func(t *testing.T, u User) { assert.Equal(t, []Email{testUser().Emails[0]}, u.Emails) },
+		},
+		{
+			name:       "remove attribute",
+			operations: `[{"op": "remove", "path": "name.formatted"}, {"op": "remove", "path": "externalId"}]`,
+			check: func(t *testing.T, u User) {
+				assert.Empty(t, u.Name.Formatted)
+				assert.Empty(t, u.ExternalID)
+				assert.Equal(t, "Jane Roe", u.FullName())
+			},
+		},
+		{name: "no operations", operations: `[]`, scimType: ErrInvalidSyntax},
+		{name: "unknown op", operations: `[{"op": "move", "path": "displayName"}]`, scimType: ErrInvalidSyntax},
+		{name: "unknown path", operations: `[{"op": "replace", "path": "nickName", "value": "J"}]`, scimType: ErrInvalidPath},
+		{name: "remove required", operations: `[{"op": "remove", "path": "userName"}]`, scimType: ErrInvalidPath},
+		{name: "remove without path", operations: `[{"op": "remove"}]`, scimType: ErrNoTarget},
+		{name: "wrong type", operations: `[{"op": "replace", "path": "userName", "value": 1}]`, scimType: ErrInvalidValue},
+		{name: "no matching email", operations: `[{"op": "replace", "path": "emails[primary eq false and type eq \"x\"].value", "value": "x"}]`, scimType: ErrNoTarget},
+		{name: "bad value filter", operations: `[{"op": "replace", "path": "emails[kind eq \"x\"].value", "value": "x"}]`, scimType: ErrInvalidPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch PatchOp
+			require.NoError(t, json.Unmarshal([]byte(`{"Operations": `+tt.operations+`}`), &patch))
+			u := testUser()
+			err := patch.Apply(&u)
+			if tt.scimType != "" {
+				var scimErr *Error
+				require.ErrorAs(t, err, &scimErr)
+				assert.Equal(t, tt.scimType, scimErr.ScimType)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, u)
+		})
+	}
+}
+
+func TestUser_Accessors(t *testing.T) {
+	u := User{UserName: "sam@example.com", DisplayName: "Sam"}
+	assert.Equal(t, "sam@example.com", u.PrimaryEmail())
+	assert.Equal(t, "Sam", u.FullName())
+	assert.True(t, u.IsActive())
+
+	u = User{UserName: "sam", Emails: []Email{{Value: "a@example.com"}, {Value: "b@example.com", Primary: true}}, Active: ptr(false)}
+	assert.Equal(t, "b@example.com", u.PrimaryEmail())
+	assert.Equal(t, "sam", u.FullName())
+	assert.False(t, u.IsActive())
+	assert.Empty(t, User{UserName: "sam"}.PrimaryEmail())
+}
@@ -0,0 +1,60 @@
+package scripts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// Condition is a Lua expression about a user event, run in the same sandbox
+// as scripts. It sees the event's name as the global event, the user as
+// user and, for updates, the user before the change as before; it holds
+// unless it evaluates to nil or false.
+type Condition struct {
+	script compiled
+}
+
+// NewCondition compiles expression as the condition called name. An empty
+// expression always holds.
+func NewCondition(name, expression string, timeout time.Duration) (*Condition, error) {
+	if timeout <= 0 {
+		timeout = 100 * time.Millisecond
+	}
+	if strings.TrimSpace(expression) == "" {
+		expression = "true"
+	}
+	chunk, err := parse.Parse(strings.NewReader("return "+expression), name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %s: %w", name, err)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %s: %w", name, err)
+	}
+	return &Condition{script: compiled{name: name, proto: proto, timeout: timeout}}, nil
+}
+
+// Holds evaluates the condition for event about user; before is the user
+// before an update, or nil
+func (c *Condition) Holds(ctx context.Context, event string, user store.User, before *store.User) (bool, error) {
+	var holds bool
+	err := c.script.run(ctx, func(L *lua.LState) error {
+		L.SetGlobal("event", lua.LString(event))
+		if before != nil {
+			L.SetGlobal("before", toLua(L, *before, nil))
+		}
+		result, err := c.script.call(L, user, nil)
+		if err != nil {
+			return err
+		}
+		holds = lua.LVAsBool(result)
+		return nil
+	})
+	return holds, err
+}
@@ -0,0 +1,339 @@
+// Package scripts runs small Lua scripts on user lifecycle events, a lighter
+// alternative to plugins for validating users before they are created and
+// computing fields of users read for responses. Scripts run in a sandbox
+// without access to files, the network or other scripts, bounded by a
+// timeout, and can be reloaded while requests are served.
+//
+// Each script sees the user as the global table user, with id, name, email,
+// created_at and updated_at (RFC 3339) and the attributes added so far. A
+// validate-before-create script returns a string to reject the user, and
+// nothing or true to accept it; false rejects it without a reason. An
+// enrich-after-read script returns a table of fields to add to the user's
+// attributes.
+package scripts
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/pkg/plugin"
+)
+
+// ErrRejected is returned for users a script rejects
+var ErrRejected = errors.New("rejected by script")
+
+// Hooks lists the hooks scripts can handle, the same as plugins
+var Hooks = plugin.Hooks
+
+// Script is Lua code run for a hook
+type Script struct {
+	Name string
+	Hook string
+	// Source is the code; File is read for it when empty
+	Source string
+	File   string
+	// Timeout bounds each run of the script
+	Timeout time.Duration
+}
+
+// compiled is a script ready to run
+type compiled struct {
+	name    string
+	hook    string
+	proto   *lua.FunctionProto
+	timeout time.Duration
+}
+
+// Engine runs scripts for the hooks they handle, in order
+type Engine struct {
+	timeout time.Duration
+	scripts atomic.Pointer[[]compiled]
+}
+
+// New creates an engine for scripts, compiling them. Scripts without a
+// timeout get timeout.
+func New(scripts []Script, timeout time.Duration) (*Engine, error) {
+	if timeout <= 0 {
+		timeout = 100 * time.Millisecond
+	}
+	engine := &Engine{timeout: timeout}
+	if err := engine.Reload(scripts); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// Reload replaces the engine's scripts, reading their files again. Calls
+// already running finish with the previous scripts. If a script fails to
+// compile, the error is returned and the previous scripts stay in place.
+func (e *Engine) Reload(scripts []Script) error {
+	loaded := make([]compiled, len(scripts))
+	for i, s := range scripts {
+		c, err := e.compile(i, s)
+		if err != nil {
+			return err
+		}
+		loaded[i] = c
+	}
+	e.scripts.Store(&loaded)
+	return nil
+}
+
+func (e *Engine) compile(i int, s Script) (compiled, error) {
+	if s.Name == "" {
+		return compiled{}, fmt.Errorf("script %d has no name", i+1)
+	}
+	if !slices.Contains(Hooks, s.Hook) {
+		return compiled{}, fmt.Errorf("script %s: unknown hook %q, expected one of %s", s.Name, s.Hook, strings.Join(Hooks, ", "))
+	}
+	source := s.Source
+	switch {
+	case source != "" && s.File != "":
+		return compiled{}, fmt.Errorf("script %s has both source and a file", s.Name)
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return compiled{}, fmt.Errorf("failed to read script %s: %w", s.Name, err)
+		}
+		source = string(data)
+	case source == "":
+		return compiled{}, fmt.Errorf("script %s has no source", s.Name)
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(source), s.Name)
+	if err != nil {
+		return compiled{}, fmt.Errorf("invalid script %s: %w", s.Name, err)
+	}
+	proto, err := lua.Compile(chunk, s.Name)
+	if err != nil {
+		return compiled{}, fmt.Errorf("invalid script %s: %w", s.Name, err)
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = e.timeout
+	}
+	return compiled{name: s.Name, hook: s.Hook, proto: proto, timeout: timeout}, nil
+}
+
+// Handles reports whether any script handles hook; it is false for a nil
+// engine
+func (e *Engine) Handles(hook string) bool {
+	return len(e.handling(hook)) > 0
+}
+
+// ValidateBeforeCreate runs the validate-before-create scripts on user,
+// returning an error wrapping ErrRejected with the reason if one rejects it
+func (e *Engine) ValidateBeforeCreate(ctx context.Context, user store.User) error {
+	for _, s := range e.handling(plugin.HookValidateBeforeCreate) {
+		var rejection string
+		err := s.run(ctx, func(L *lua.LState) error {
+			result, err := s.call(L, user, nil)
+			if err != nil {
+				return err
+			}
+			switch result := result.(type) {
+			case *lua.LNilType:
+			case lua.LBool:
+				if !result {
+					rejection = "rejected"
+				}
+			case lua.LString:
+				rejection = string(result)
+			default:
+				return fmt.Errorf("script %s returned a %s, expected a rejection string or nothing", s.name, result.Type())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if rejection != "" {
+			return fmt.Errorf("%w %s: %s", ErrRejected, s.name, rejection)
+		}
+	}
+	return nil
+}
+
+// EnrichAfterRead runs the enrich-after-read scripts on users, adding the
+// fields they return to attributes, which holds each user's attributes in
+// the same order or is nil. It returns the attributes; users without any
+// have nil.
+func (e *Engine) EnrichAfterRead(ctx context.Context, users []store.User, attributes []map[string]string) ([]map[string]string, error) {
+	enriched := make([]map[string]string, len(users))
+	for i := range min(len(attributes), len(users)) {
+		enriched[i] = maps.Clone(attributes[i])
+	}
+	for _, s := range e.handling(plugin.HookEnrichAfterRead) {
+		// One interpreter serves every user of the call
+		err := s.run(ctx, func(L *lua.LState) error {
+			for i, user := range users {
+				result, err := s.call(L, user, enriched[i])
+				if err != nil {
+					return err
+				}
+				fields, err := s.fields(result)
+				if err != nil {
+					return err
+				}
+				if len(fields) == 0 {
+					continue
+				}
+				if enriched[i] == nil {
+					enriched[i] = make(map[string]string, len(fields))
+				}
+				maps.Copy(enriched[i], fields)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return enriched, nil
+}
+
+// handling returns the scripts handling hook, in order
+func (e *Engine) handling(hook string) []compiled {
+	if e == nil {
+		return nil
+	}
+	var scripts []compiled
+	for _, s := range *e.scripts.Load() {
+		if s.hook == hook {
+			scripts = append(scripts, s)
+		}
+	}
+	return scripts
+}
+
+// run calls fn with a sandboxed interpreter that stops when s's timeout is
+// exceeded
+func (s compiled) run(ctx context.Context, fn func(L *lua.LState) error) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	L := sandbox()
+	defer L.Close()
+	L.SetContext(ctx)
+
+	err := fn(L)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("script %s timed out after %v", s.name, s.timeout)
+	}
+	return err
+}
+
+// call runs s in L for user with attributes, returning its result
+func (s compiled) call(L *lua.LState, user store.User, attributes map[string]string) (lua.LValue, error) {
+	L.SetGlobal("user", toLua(L, user, attributes))
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		return nil, fmt.Errorf("script %s failed: %w", s.name, err)
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return result, nil
+}
+
+// fields returns the fields an enrich-after-read script returned as
+// attributes
+func (s compiled) fields(result lua.LValue) (map[string]string, error) {
+	if result == lua.LNil {
+		return nil, nil
+	}
+	table, ok := result.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("script %s returned a %s, expected a table of fields", s.name, result.Type())
+	}
+	fields := make(map[string]string)
+	var err error
+	table.ForEach(func(key, value lua.LValue) {
+		name, ok := key.(lua.LString)
+		if !ok {
+			err = cmp.Or(err, fmt.Errorf("script %s returned a field named by a %s, expected a string", s.name, key.Type()))
+			return
+		}
+		switch value := value.(type) {
+		case lua.LString, lua.LNumber, lua.LBool:
+			fields[string(name)] = value.String()
+		default:
+			err = cmp.Or(err, fmt.Errorf("script %s returned field %s as a %s, expected a string, number or boolean", s.name, name, value.Type()))
+		}
+	})
+	return fields, err
+}
+
+// sandboxed lists the libraries scripts can use, and the functions removed
+// from them as they reach outside the sandbox or allocate without bound
+var sandboxed = []struct {
+	name    string
+	open    lua.LGFunction
+	removed []string
+}{
+	{lua.BaseLibName, lua.OpenBase, []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "print", "getfenv", "setfenv", "_printregs"}},
+	{lua.TabLibName, lua.OpenTable, nil},
+	{lua.StringLibName, lua.OpenString, []string{"rep", "dump"}},
+	{lua.MathLibName, lua.OpenMath, []string{"randomseed"}},
+}
+
+// sandbox returns an interpreter with only the sandboxed libraries
+func sandbox() *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   64,
+		RegistrySize:    256,
+		RegistryMaxSize: 64 * 1024,
+	})
+	for _, lib := range sandboxed {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+
+		module := L.GetGlobal(lib.name)
+		if lib.name == lua.BaseLibName {
+			module = L.Get(lua.GlobalsIndex)
+		}
+		if table, ok := module.(*lua.LTable); ok {
+			for _, name := range lib.removed {
+				table.RawSetString(name, lua.LNil)
+			}
+		}
+	}
+	return L
+}
+
+// toLua returns user as scripts see it, with attributes
+func toLua(L *lua.LState, user store.User, attributes map[string]string) *lua.LTable {
+	table := L.NewTable()
+	table.RawSetString("id", lua.LNumber(user.ID))
+	table.RawSetString("name", lua.LString(user.Name))
+	table.RawSetString("email", lua.LString(user.Email))
+	table.RawSetString("created_at", lua.LString(timestamp(user.CreatedAt)))
+	table.RawSetString("updated_at", lua.LString(timestamp(user.UpdatedAt)))
+	attrs := L.NewTable()
+	for key, value := range attributes {
+		attrs.RawSetString(key, lua.LString(value))
+	}
+	table.RawSetString("attributes", attrs)
+	return table
+}
+
+// timestamp formats t for scripts, empty when unset
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
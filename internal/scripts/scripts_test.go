@@ -0,0 +1,193 @@
+package scripts
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/pkg/plugin"
+)
+
+func TestNew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.lua")
+	require.NoError(t, os.WriteFile(file, []byte(`return nil`), 0o600))
+
+	tests := []struct {
+		name    string
+		scripts []Script
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "source", scripts: []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: `return nil`}}},
+		{name: "file", scripts: []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, File: file}}},
+		{name: "no name", scripts: []Script{{Hook: plugin.HookValidateBeforeCreate, Source: `return nil`}}, wantErr: "no name"},
+		{name: "unknown hook", scripts: []Script{{Name: "policy", Hook: "validate-before-delete", Source: `return nil`}}, wantErr: "unknown hook"},
+		{name: "no source", scripts: []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate}}, wantErr: "no source"},
+		{name: "source and file", scripts: []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: `return nil`, File: file}}, wantErr: "both"},
+		{name: "missing file", scripts: []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, File: file + ".missing"}}, wantErr: "failed to read"},
+		{name: "syntax error", scripts: []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: `return (`}}, wantErr: "invalid script policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.scripts, time.Second)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestEngine_ValidateBeforeCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		user     store.User
+		rejected bool
+		wantErr  string
+	}{
+		{
+			name:   "accepted",
+			source: `if user.email:match("@example%.org$") then return "example.org addresses are not accepted" end`,
+			user:   store.User{Email: "john@example.com"},
+		},
+		{
+			name:     "rejected",
+			source:   `if user.email:match("@example%.org$") then return "example.org addresses are not accepted" end`,
+			user:     store.User{Email: "john@example.org"},
+			rejected: true,
+			wantErr:  "policy: example.org addresses are not accepted",
+		},
+		{name: "true accepts", source: `return #user.name > 1`, user: store.User{Name: "John"}},
+		{name: "false rejects", source: `return #user.name > 1`, user: store.User{Name: "J"}, rejected: true, wantErr: "policy: rejected"},
+		{name: "raises", source: `error("directory unavailable")`, wantErr: "directory unavailable"},
+		{name: "returns a table", source: `return {}`, wantErr: "returned a table"},
+		{name: "loops forever", source: `while true do end`, wantErr: "timed out"},
+		{name: "reads files", source: `return io.open("/etc/passwd")`, wantErr: "policy failed"},
+		{name: "runs commands", source: `return os.execute("true")`, wantErr: "policy failed"},
+		{name: "loads code", source: `return load("return 1")()`, wantErr: "policy failed"},
+		{name: "allocates without bound", source: `return string.rep("x", 1e12)`, wantErr: "policy failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New([]Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: tt.source, Timeout: 100 * time.Millisecond}}, 0)
+			require.NoError(t, err)
+
+			err = engine.ValidateBeforeCreate(context.Background(), tt.user)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Equal(t, tt.rejected, errors.Is(err, ErrRejected))
+		})
+	}
+}
+
+func TestEngine_EnrichAfterRead(t *testing.T) {
+	users := []store.User{
+		{ID: 1, Name: "John", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{ID: 2, Name: "Jane"},
+	}
+
+	// Later scripts see the fields of earlier ones and of plugins
+	engine, err := New([]Script{
+		{Name: "initials", Hook: plugin.HookEnrichAfterRead, Source: `return {initial = user.name:sub(1, 1), since = user.created_at}`},
+		{Name: "policy", Hook: plugin.HookValidateBeforeCreate, Source: `return "rejected"`},
+		{Name: "labels", Hook: plugin.HookEnrichAfterRead, Source: `
+			if user.attributes.plan == nil then return nil end
+			return {label = user.attributes.plan .. "-" .. user.attributes.initial, vip = user.id == 1, rank = user.id * 10}`},
+	}, time.Second)
+	require.NoError(t, err)
+	assert.True(t, engine.Handles(plugin.HookEnrichAfterRead))
+
+	plugins := []map[string]string{{"plan": "gold"}, nil}
+	attributes, err := engine.EnrichAfterRead(context.Background(), users, plugins)
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"plan": "gold", "initial": "J", "since": "2024-01-02T03:04:05Z", "label": "gold-J", "vip": "true", "rank": "10"},
+		{"initial": "J", "since": ""},
+	}, attributes)
+	assert.Equal(t, []map[string]string{{"plan": "gold"}, nil}, plugins, "attributes passed in are not changed")
+
+	// Fields must be strings, numbers or booleans
+	engine, err = New([]Script{{Name: "nested", Hook: plugin.HookEnrichAfterRead, Source: `return {address = {}}`}}, time.Second)
+	require.NoError(t, err)
+	_, err = engine.EnrichAfterRead(context.Background(), users, nil)
+	assert.ErrorContains(t, err, "returned field address as a table")
+}
+
+func TestEngine_Reload(t *testing.T) {
+	var none *Engine
+	assert.False(t, none.Handles(plugin.HookValidateBeforeCreate))
+	assert.NoError(t, none.ValidateBeforeCreate(context.Background(), store.User{}))
+
+	file := filepath.Join(t.TempDir(), "policy.lua")
+	require.NoError(t, os.WriteFile(file, []byte(`return "closed"`), 0o600))
+	scripts := []Script{{Name: "policy", Hook: plugin.HookValidateBeforeCreate, File: file}}
+	engine, err := New(scripts, time.Second)
+	require.NoError(t, err)
+	assert.ErrorIs(t, engine.ValidateBeforeCreate(context.Background(), store.User{}), ErrRejected)
+
+	// Reloading reads files again
+	require.NoError(t, os.WriteFile(file, []byte(`return nil`), 0o600))
+	require.NoError(t, engine.Reload(scripts))
+	assert.NoError(t, engine.ValidateBeforeCreate(context.Background(), store.User{}))
+
+	// Scripts that fail to compile leave the previous ones in place
+	require.NoError(t, os.WriteFile(file, []byte(`return (`), 0o600))
+	assert.Error(t, engine.Reload(scripts))
+	assert.NoError(t, engine.ValidateBeforeCreate(context.Background(), store.User{}))
+
+	require.NoError(t, engine.Reload(nil))
+	assert.False(t, engine.Handles(plugin.HookValidateBeforeCreate))
+}
+
+func TestCondition_Holds(t *testing.T) {
+	user := store.User{ID: 1, Name: "John Doe", Email: "john@gmail.com"}
+	before := store.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+
+	tests := []struct {
+		name       string
+		expression string
+		before     *store.User
+		holds      bool
+		wantErr    string
+	}{
+		{name: "empty always holds", holds: true},
+		{name: "holds", expression: `user.email:match("@gmail%.com$") ~= nil`, holds: true},
+		{name: "does not hold", expression: `user.id > 1`},
+		{name: "nil does not hold", expression: `user.attributes.plan`},
+		{name: "sees the event", expression: `event == "user.updated"`, holds: true},
+		{name: "sees the user before", expression: `before.email ~= user.email`, before: &before, holds: true},
+		{name: "no user before on create", expression: `before == nil`, holds: true},
+		{name: "raises", expression: `before.email`, wantErr: "flag failed"},
+		{name: "loops forever", expression: `(function() while true do end end)()`, wantErr: "timed out"},
+		{name: "escapes the sandbox", expression: `os.execute("true")`, wantErr: "flag failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, err := NewCondition("flag", tt.expression, 100*time.Millisecond)
+			require.NoError(t, err)
+
+			holds, err := condition.Holds(context.Background(), "user.updated", user, tt.before)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.holds, holds)
+		})
+	}
+
+	_, err := NewCondition("flag", `user.id ==`, time.Second)
+	assert.ErrorContains(t, err, "invalid condition flag")
+}
@@ -0,0 +1,194 @@
+// Package stats aggregates users into counts, such as signups per month,
+// for consumers that don't need individual users. Counts published to less
+// trusted consumers can be protected with differential privacy: buckets of
+// fewer than k users are suppressed, and Laplace noise is added to every
+// count, so a report does not reveal whether any one user is in the store.
+package stats
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+// Bucket is the number of users sharing a key
+type Bucket struct {
+	Key   string `json:"key" example:"2024-01"`
+	Count int    `json:"count" example:"42"`
+}
+
+// Report holds aggregate counts of users
+type Report struct {
+	Total int `json:"total" example:"1234"`
+	// SignupsByMonth counts users by the month they were created, oldest
+	// first; users created before that was recorded are left out
+	SignupsByMonth []Bucket `json:"signups_by_month"`
+	// EmailDomains counts users by the domain of their email, largest first
+	EmailDomains []Bucket `json:"email_domains"`
+	// Private says whether the counts are noised and small buckets
+	// suppressed
+	Private bool `json:"private" example:"true"`
+	// Suppressed is the number of buckets left out for having too few users
+	Suppressed int `json:"suppressed" example:"3"`
+}
+
+// Aggregate counts users
+func Aggregate(users []store.User) Report {
+	months := make(map[string]int)
+	domains := make(map[string]int)
+	for _, user := range users {
+		if !user.CreatedAt.IsZero() {
+			months[user.CreatedAt.UTC().Format("2006-01")]++
+		}
+		if _, domain, ok := strings.Cut(user.Email, "@"); ok {
+			domains[strings.ToLower(domain)]++
+		}
+	}
+
+	report := Report{Total: len(users), SignupsByMonth: buckets(months), EmailDomains: buckets(domains)}
+	slices.SortFunc(report.EmailDomains, byCount)
+	return report
+}
+
+// buckets returns counts as buckets sorted by key
+func buckets(counts map[string]int) []Bucket {
+	buckets := make([]Bucket, 0, len(counts))
+	for key, count := range counts {
+		buckets = append(buckets, Bucket{Key: key, Count: count})
+	}
+	slices.SortFunc(buckets, func(a, b Bucket) int { return strings.Compare(a.Key, b.Key) })
+	return buckets
+}
+
+// byCount orders buckets largest first, then by key
+func byCount(a, b Bucket) int {
+	return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Key, b.Key))
+}
+
+// Privacy configures how reports are protected for less trusted consumers
+type Privacy struct {
+	// MinBucket suppresses buckets of fewer users; 0 keeps them all
+	MinBucket int
+	// Epsilon is the privacy budget of each count: the Laplace noise added
+	// has scale 1/Epsilon, so lower values add more. 0 adds none.
+	Epsilon float64
+}
+
+// Enabled reports whether the privacy protects anything
+func (p Privacy) Enabled() bool {
+	return p.MinBucket > 0 || p.Epsilon > 0
+}
+
+// Apply returns report with noise from uniform, which returns numbers in
+// [0, 1), added to each count, and the buckets whose noised count is below
+// the minimum suppressed. Counts never go below zero.
+func (p Privacy) Apply(report Report, uniform func() float64) Report {
+	private := Report{Total: p.noised(report.Total, uniform), SignupsByMonth: []Bucket{}, EmailDomains: []Bucket{}, Private: true}
+	for _, b := range report.SignupsByMonth {
+		if noised, ok := p.bucket(b.Count, uniform); ok {
+			private.SignupsByMonth = append(private.SignupsByMonth, Bucket{Key: b.Key, Count: noised})
+		} else {
+			private.Suppressed++
+		}
+	}
+	for _, b := range report.EmailDomains {
+		if noised, ok := p.bucket(b.Count, uniform); ok {
+			private.EmailDomains = append(private.EmailDomains, Bucket{Key: b.Key, Count: noised})
+		} else {
+			private.Suppressed++
+		}
+	}
+	// Noise can reorder domains
+	slices.SortFunc(private.EmailDomains, byCount)
+	return private
+}
+
+// bucket returns the noised count of a bucket, and whether it is published.
+// The threshold applies to the noised count, so whether a bucket is
+// suppressed does not reveal its exact size.
+func (p Privacy) bucket(count int, uniform func() float64) (int, bool) {
+	noised := p.noised(count, uniform)
+	return noised, noised > 0 && noised >= p.MinBucket
+}
+
+// noised returns count with Laplace noise added, rounded and at least zero
+func (p Privacy) noised(count int, uniform func() float64) int {
+	if p.Epsilon <= 0 {
+		return count
+	}
+	// Inverse transform sampling of Laplace(0, 1/epsilon)
+	u := uniform() - 0.5
+	// uniform returning exactly 0 would make the noise infinite
+	noise := -math.Copysign(1/p.Epsilon, u) * math.Log(max(1-2*math.Abs(u), math.SmallestNonzeroFloat64))
+	return max(0, int(math.Round(float64(count)+noise)))
+}
+
+// Publisher publishes reports about the users in a store. Private reports
+// are kept until the store's revision changes, so consumers cannot average
+// the noise away by asking again; stores without revisions get fresh noise
+// on every request.
+type Publisher struct {
+	users   store.UserStore
+	privacy Privacy
+	uniform func() float64
+
+	mutex    sync.Mutex
+	private  *Report
+	revision uint64
+}
+
+// NewPublisher creates a publisher of reports about users, protected by
+// privacy when they are private
+func NewPublisher(users store.UserStore, privacy Privacy) *Publisher {
+	return &Publisher{users: users, privacy: privacy, uniform: rand.Float64}
+}
+
+// Privacy returns the privacy of private reports
+func (p *Publisher) Privacy() Privacy {
+	return p.privacy
+}
+
+// Exact returns a report with exact counts
+func (p *Publisher) Exact() (Report, error) {
+	users, err := p.users.GetAll()
+	if err != nil {
+		return Report{}, err
+	}
+	return Aggregate(users), nil
+}
+
+// Private returns a report protected by the publisher's privacy, or an
+// exact one when it has none
+func (p *Publisher) Private() (Report, error) {
+	if !p.privacy.Enabled() {
+		return p.Exact()
+	}
+
+	revisioner, cacheable := p.users.(store.Revisioner)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// The revision is read before the users, so a write in between leaves
+	// the report to be replaced on the next request rather than kept stale
+	var revision uint64
+	if cacheable {
+		revision = revisioner.Revision()
+		if p.private != nil && p.revision == revision {
+			return *p.private, nil
+		}
+	}
+
+	exact, err := p.Exact()
+	if err != nil {
+		return Report{}, err
+	}
+	private := p.privacy.Apply(exact, p.uniform)
+	if cacheable {
+		p.private, p.revision = &private, revision
+	}
+	return private, nil
+}
@@ -0,0 +1,150 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+func TestAggregate(t *testing.T) {
+	january := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	february := time.Date(2024, 2, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600))
+	report := Aggregate([]store.User{
+		{ID: 1, Email: "john@example.com", CreatedAt: january},
+		{ID: 2, Email: "jane@Example.com", CreatedAt: january},
+		{ID: 3, Email: "bob@gmail.com", CreatedAt: february},
+		{ID: 4, Email: "anon@acme.io"},
+	})
+
+	assert.Equal(t, Report{
+		Total:          4,
+		SignupsByMonth: []Bucket{{Key: "2024-01", Count: 3}},
+		EmailDomains:   []Bucket{{Key: "example.com", Count: 2}, {Key: "acme.io", Count: 1}, {Key: "gmail.com", Count: 1}},
+	}, report)
+
+	assert.Equal(t, Report{SignupsByMonth: []Bucket{}, EmailDomains: []Bucket{}}, Aggregate(nil))
+}
+
+// constant returns a uniform source always returning u
+func constant(u float64) func() float64 {
+	return func() float64 { return u }
+}
+
+func TestPrivacy_Apply(t *testing.T) {
+	report := Report{
+		Total:          12,
+		SignupsByMonth: []Bucket{{Key: "2024-01", Count: 9}, {Key: "2024-02", Count: 3}},
+		EmailDomains:   []Bucket{{Key: "example.com", Count: 10}, {Key: "gmail.com", Count: 2}},
+	}
+
+	tests := []struct {
+		name    string
+		privacy Privacy
+		uniform float64
+		want    Report
+	}{
+		{name: "threshold only", privacy: Privacy{MinBucket: 3}, uniform: 0.5, want: Report{
+			Total:          12,
+			SignupsByMonth: []Bucket{{Key: "2024-01", Count: 9}, {Key: "2024-02", Count: 3}},
+			EmailDomains:   []Bucket{{Key: "example.com", Count: 10}},
+			Private:        true,
+			Suppressed:     1,
+		}},
+		// u - 0.5 = 0.3 gives noise ln(1/0.4) ≈ 0.92 at epsilon 1
+		{name: "noise up", privacy: Privacy{Epsilon: 1}, uniform: 0.8, want: Report{
+			Total:          13,
+			SignupsByMonth: []Bucket{{Key: "2024-01", Count: 10}, {Key: "2024-02", Count: 4}},
+			EmailDomains:   []Bucket{{Key: "example.com", Count: 11}, {Key: "gmail.com", Count: 3}},
+			Private:        true,
+		}},
+		// and -4.6 at epsilon 0.2, enough to suppress small buckets
+		{name: "noise down", privacy: Privacy{MinBucket: 2, Epsilon: 0.2}, uniform: 0.2, want: Report{
+			Total:          7,
+			SignupsByMonth: []Bucket{{Key: "2024-01", Count: 4}},
+			EmailDomains:   []Bucket{{Key: "example.com", Count: 5}},
+			Private:        true,
+			Suppressed:     2,
+		}},
+		{name: "never negative", privacy: Privacy{Epsilon: 0.01}, uniform: 0, want: Report{
+			SignupsByMonth: []Bucket{},
+			EmailDomains:   []Bucket{},
+			Private:        true,
+			Suppressed:     4,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.privacy.Apply(report, constant(tt.uniform)))
+		})
+	}
+}
+
+func TestPrivacy_Enabled(t *testing.T) {
+	assert.False(t, Privacy{}.Enabled())
+	assert.True(t, Privacy{MinBucket: 5}.Enabled())
+	assert.True(t, Privacy{Epsilon: 1}.Enabled())
+}
+
+// noRevisions hides the Revisioner of a store
+type noRevisions struct {
+	store.UserStore
+}
+
+func TestPublisher(t *testing.T) {
+	memory := store.NewMemoryUserStore()
+	for _, email := range []string{"john@example.com", "jane@example.com"} {
+		_, err := memory.Create(store.User{Name: "Someone", Email: email})
+		require.NoError(t, err)
+	}
+
+	t.Run("without privacy", func(t *testing.T) {
+		publisher := NewPublisher(memory, Privacy{})
+		exact, err := publisher.Exact()
+		require.NoError(t, err)
+		assert.Equal(t, 2, exact.Total)
+		private, err := publisher.Private()
+		require.NoError(t, err)
+		assert.Equal(t, exact, private)
+	})
+
+	t.Run("cached per revision", func(t *testing.T) {
+		publisher := NewPublisher(memory, Privacy{Epsilon: 1})
+		calls := 0
+		publisher.uniform = func() float64 { calls++; return 0.8 }
+
+		first, err := publisher.Private()
+		require.NoError(t, err)
+		assert.True(t, first.Private)
+		drawn := calls
+
+		// Asking again gives the same noise rather than fresh samples
+		again, err := publisher.Private()
+		require.NoError(t, err)
+		assert.Equal(t, first, again)
+		assert.Equal(t, drawn, calls)
+
+		_, err = memory.Create(store.User{Name: "Bob", Email: "bob@example.com"})
+		require.NoError(t, err)
+		changed, err := publisher.Private()
+		require.NoError(t, err)
+		assert.Greater(t, calls, drawn)
+		assert.Equal(t, first.Total+1, changed.Total)
+	})
+
+	t.Run("fresh noise without revisions", func(t *testing.T) {
+		publisher := NewPublisher(noRevisions{memory}, Privacy{Epsilon: 1})
+		calls := 0
+		publisher.uniform = func() float64 { calls++; return 0.8 }
+
+		_, err := publisher.Private()
+		require.NoError(t, err)
+		drawn := calls
+		_, err = publisher.Private()
+		require.NoError(t, err)
+		assert.Equal(t, 2*drawn, calls)
+	})
+}
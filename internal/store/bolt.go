@@ -0,0 +1,697 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+// boltVersion is the layout of the store's buckets, recorded in the meta
+// bucket so later layouts can migrate files written by this one
+const boltVersion = 1
+
+var (
+	// boltUsers holds each user's record keyed by its big-endian ID; the
+	// bucket's sequence is the last ID assigned
+	boltUsers = []byte("users")
+	// boltEmails indexes users by normalised email, with keys of the email
+	// key, a zero byte and the big-endian ID, so the lowest ID sorts first
+	boltEmails = []byte("emails")
+	// boltMeta holds the layout version
+	boltMeta    = []byte("meta")
+	boltVersKey = []byte("version")
+)
+
+// BoltOptions configures a BoltUserStore
+type BoltOptions struct {
+	// Path is the database file, created with its directory if missing
+	Path string
+	// Timeout bounds waiting for another process to release the file
+	Timeout time.Duration
+	Clock   clock.Clock
+	// IDs numbers new users when set, instead of the bucket's sequence
+	IDs idgen.IntGenerator
+}
+
+// BoltUserStore keeps users in a single bbolt file, for persistence without
+// an external database. Only one process can open the file at a time.
+type BoltUserStore struct {
+	db    *bolt.DB
+	clock clock.Clock
+	ids   idgen.IntGenerator
+
+	// revision is incremented on every write
+	revision atomic.Uint64
+}
+
+// boltRecord is a user as stored, with the checksum of its fields at the
+// time it was written
+type boltRecord struct {
+	User     User   `json:"user"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// NewBoltUserStore opens, or creates, the store's file and sets up its
+// buckets
+func NewBoltUserStore(opts BoltOptions) (*BoltUserStore, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if dir := filepath.Dir(opts.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", opts.Path, err)
+		}
+	}
+
+	db, err := bolt.Open(opts.Path, 0o600, &bolt.Options{Timeout: opts.Timeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("failed to open %s: in use by another process", opts.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", opts.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltUsers, boltEmails, boltMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(boltMeta)
+		if stored := meta.Get(boltVersKey); stored != nil {
+			if version := binary.BigEndian.Uint64(stored); version != boltVersion {
+				return fmt.Errorf("unsupported layout version %d, expected %d", version, boltVersion)
+			}
+			return nil
+		}
+		return meta.Put(boltVersKey, binary.BigEndian.AppendUint64(nil, boltVersion))
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to set up %s: %w", opts.Path, err)
+	}
+	return &BoltUserStore{db: db, clock: opts.Clock, ids: opts.IDs}, nil
+}
+
+// Close closes the file
+func (b *BoltUserStore) Close() error {
+	return b.db.Close()
+}
+
+// Revision returns a counter that changes whenever the store is written to
+func (b *BoltUserStore) Revision() uint64 {
+	return b.revision.Load()
+}
+
+// boltID encodes id as a key that sorts in ID order
+func boltID(id int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// boltEmail returns the email index key of the user with id and email key
+func boltEmail(key string, id int64) []byte {
+	return append(append([]byte(key), 0), boltID(id)...)
+}
+
+// boltGet reads the user with id in tx, or nil if there is none
+func boltGet(tx *bolt.Tx, id int64) (*User, error) {
+	data := tx.Bucket(boltUsers).Get(boltID(id))
+	if data == nil {
+		return nil, nil
+	}
+	var record boltRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode user %d: %w", id, err)
+	}
+	return &record.User, nil
+}
+
+// boltPut writes user in tx, moving its email index entry from previous
+func boltPut(tx *bolt.Tx, previous *User, user User) error {
+	data, err := json.Marshal(boltRecord{User: user, Checksum: checksum(user)})
+	if err != nil {
+		return fmt.Errorf("failed to encode user %d: %w", user.ID, err)
+	}
+	if err := tx.Bucket(boltUsers).Put(boltID(user.ID), data); err != nil {
+		return err
+	}
+	emails := tx.Bucket(boltEmails)
+	if previous != nil && emailKey(previous.Email) != "" {
+		if err := emails.Delete(boltEmail(emailKey(previous.Email), previous.ID)); err != nil {
+			return err
+		}
+	}
+	if key := emailKey(user.Email); key != "" {
+		return emails.Put(boltEmail(key, user.ID), nil)
+	}
+	return nil
+}
+
+// boltAdvance moves the ID sequence to at least id, so new users never take it
+func boltAdvance(tx *bolt.Tx, id int64) error {
+	users := tx.Bucket(boltUsers)
+	if uint64(id) > users.Sequence() {
+		return users.SetSequence(uint64(id))
+	}
+	return nil
+}
+
+// newID returns the ID of the next user created in tx, keeping the sequence
+// past IDs taken from ids
+func (b *BoltUserStore) newID(tx *bolt.Tx) (int64, error) {
+	if b.ids != nil {
+		id := b.ids.NewInt()
+		return id, boltAdvance(tx, id)
+	}
+	id, err := tx.Bucket(boltUsers).NextSequence()
+	return int64(id), err
+}
+
+// update runs fn in a write transaction, counting the write if it commits
+func (b *BoltUserStore) update(fn func(tx *bolt.Tx) error) error {
+	if err := b.db.Update(fn); err != nil {
+		return err
+	}
+	b.revision.Add(1)
+	return nil
+}
+
+// GetAll returns all users, ordered by ID
+func (b *BoltUserStore) GetAll() ([]User, error) {
+	var users []User
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		users, err = boltAll(tx)
+		return err
+	})
+	return users, err
+}
+
+// boltAll reads every user in tx, ordered by ID
+func boltAll(tx *bolt.Tx) ([]User, error) {
+	bucket := tx.Bucket(boltUsers)
+	users := make([]User, 0, bucket.Stats().KeyN)
+	err := bucket.ForEach(func(key, data []byte) error {
+		var record boltRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed to decode user %d: %w", binary.BigEndian.Uint64(key), err)
+		}
+		users = append(users, record.User)
+		return nil
+	})
+	return users, err
+}
+
+// Count returns the number of users
+func (b *BoltUserStore) Count() (int, error) {
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltUsers).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+// GetByID returns a user by ID
+func (b *BoltUserStore) GetByID(id int64) (*User, error) {
+	var user *User
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		user, err = boltGet(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// GetByIDs returns the users with ids ordered by ID, in one transaction
+func (b *BoltUserStore) GetByIDs(ids []int64) ([]User, error) {
+	users := make([]User, 0, len(ids))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, id := range sortedIDs(ids) {
+			user, err := boltGet(tx, id)
+			if err != nil {
+				return err
+			}
+			if user != nil {
+				users = append(users, *user)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetByEmail returns the user with the given email address, compared
+// case-insensitively. If several users share the address, the one with the
+// lowest ID is returned.
+func (b *BoltUserStore) GetByEmail(email string) (*User, error) {
+	key := emailKey(email)
+	if key == "" {
+		return nil, errors.New("user not found")
+	}
+	prefix := append([]byte(key), 0)
+
+	var user *User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		entry, _ := tx.Bucket(boltEmails).Cursor().Seek(prefix)
+		if entry == nil || !bytes.HasPrefix(entry, prefix) || len(entry) != len(prefix)+8 {
+			return nil
+		}
+		var err error
+		user, err = boltGet(tx, int64(binary.BigEndian.Uint64(entry[len(prefix):])))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// Find returns the users passing filter in its sort order, or otherwise in
+// time order: by creation time, or by update time when only that is
+// filtered. Users are scanned from the file, which has no time indexes.
+func (b *BoltUserStore) Find(filter UserFilter) ([]User, error) {
+	users, err := b.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]User, 0, len(users))
+	for _, user := range users {
+		if filter.Matches(user) {
+			matched = append(matched, user)
+		}
+	}
+
+	if len(filter.Sort) > 0 {
+		filter.SortUsers(matched)
+		return matched, nil
+	}
+	byUpdate := filter.CreatedAfter.IsZero() && filter.CreatedBefore.IsZero() && !filter.UpdatedAfter.IsZero()
+	sort.SliceStable(matched, func(i, j int) bool {
+		if byUpdate {
+			return matched[i].UpdatedAt.Before(matched[j].UpdatedAt)
+		}
+		return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+	})
+	return matched, nil
+}
+
+// Create adds a new user and returns the created user with assigned ID
+func (b *BoltUserStore) Create(user User) (*User, error) {
+	err := b.update(func(tx *bolt.Tx) error {
+		id, err := b.newID(tx)
+		if err != nil {
+			return err
+		}
+		user.ID = id
+		user.CreatedAt = b.clock.Now().UTC()
+		user.UpdatedAt = user.CreatedAt
+		user.Version = 1
+		return boltPut(tx, nil, user)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return &user, nil
+}
+
+// CreateMany adds users in a single transaction, so either all of them are
+// created or none are
+func (b *BoltUserStore) CreateMany(users []User) ([]User, error) {
+	created := make([]User, len(users))
+	err := b.update(func(tx *bolt.Tx) error {
+		now := b.clock.Now().UTC()
+		for i, user := range users {
+			id, err := b.newID(tx)
+			if err != nil {
+				return err
+			}
+			user.ID = id
+			user.CreatedAt = now
+			user.UpdatedAt = now
+			user.Version = 1
+			if err := boltPut(tx, nil, user); err != nil {
+				return err
+			}
+			created[i] = user
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create users: %w", err)
+	}
+	return created, nil
+}
+
+// Update modifies an existing user, keeping its LastSeenAt and CreatedAt
+func (b *BoltUserStore) Update(id int64, user User) (*User, error) {
+	return b.updateUser(id, nil, user)
+}
+
+// UpdateVersion is Update, returning ErrVersionConflict unless the user is
+// at version
+func (b *BoltUserStore) UpdateVersion(id, version int64, user User) (*User, error) {
+	return b.updateUser(id, &version, user)
+}
+
+// updateUser updates the user id, unless version is set and the user is at
+// another
+func (b *BoltUserStore) updateUser(id int64, version *int64, user User) (*User, error) {
+	err := b.update(func(tx *bolt.Tx) error {
+		existing, err := boltGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return errNotFound
+		}
+		if version != nil && *version != existing.Version {
+			return ErrVersionConflict
+		}
+		user.ID = id
+		user.LastSeenAt = existing.LastSeenAt
+		user.CreatedAt = existing.CreatedAt
+		user.UpdatedAt = b.clock.Now().UTC()
+		user.Version = existing.Version + 1
+		return boltPut(tx, existing, user)
+	})
+	if errors.Is(err, errNotFound) {
+		return nil, errNotFound
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		return nil, ErrVersionConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return &user, nil
+}
+
+// errNotFound ends transactions on users that do not exist
+var errNotFound = errors.New("user not found")
+
+// RecordActivity moves each user's LastSeenAt forward to the given time,
+// skipping users that no longer exist
+func (b *BoltUserStore) RecordActivity(seen map[int64]time.Time) error {
+	if len(seen) == 0 {
+		return nil
+	}
+	err := b.update(func(tx *bolt.Tx) error {
+		for id, at := range seen {
+			user, err := boltGet(tx, id)
+			if err != nil {
+				return err
+			}
+			if user == nil || (user.LastSeenAt != nil && !at.After(*user.LastSeenAt)) {
+				continue
+			}
+			previous := *user
+			at := at.UTC()
+			user.LastSeenAt = &at
+			if err := boltPut(tx, &previous, *user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a user by ID
+func (b *BoltUserStore) Delete(id int64) error {
+	return b.deleteUser(id, nil)
+}
+
+// DeleteVersion is Delete, returning ErrVersionConflict unless the user is
+// at version
+func (b *BoltUserStore) DeleteVersion(id, version int64) error {
+	return b.deleteUser(id, &version)
+}
+
+// deleteUser deletes the user id, unless version is set and the user is at
+// another
+func (b *BoltUserStore) deleteUser(id int64, version *int64) error {
+	err := b.update(func(tx *bolt.Tx) error {
+		user, err := boltGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return errNotFound
+		}
+		if version != nil && *version != user.Version {
+			return ErrVersionConflict
+		}
+		if key := emailKey(user.Email); key != "" {
+			if err := tx.Bucket(boltEmails).Delete(boltEmail(key, id)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltUsers).Delete(boltID(id))
+	})
+	if errors.Is(err, errNotFound) {
+		return errNotFound
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// Restore puts a deleted user back under its original ID
+func (b *BoltUserStore) Restore(user User) (*User, error) {
+	exists := errors.New("exists")
+	err := b.update(func(tx *bolt.Tx) error {
+		existing, err := boltGet(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return exists
+		}
+		if err := boltAdvance(tx, user.ID); err != nil {
+			return err
+		}
+		return boltPut(tx, nil, user)
+	})
+	if errors.Is(err, exists) {
+		return nil, fmt.Errorf("user %d already exists", user.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+	return &user, nil
+}
+
+// Replace stores a user exactly as given, creating it or overwriting the
+// user with its ID
+func (b *BoltUserStore) Replace(user User) (*User, error) {
+	err := b.update(func(tx *bolt.Tx) error {
+		existing, err := boltGet(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := boltAdvance(tx, user.ID); err != nil {
+			return err
+		}
+		return boltPut(tx, existing, user)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace user: %w", err)
+	}
+	return &user, nil
+}
+
+// Snapshot returns the users as they are now, read in one transaction
+func (b *BoltUserStore) Snapshot() (UserSnapshot, error) {
+	snapshot := &MemorySnapshot{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		users, err := boltAll(tx)
+		if err != nil {
+			return err
+		}
+		snapshot.users = make(map[int64]User, len(users))
+		for _, user := range users {
+			snapshot.users[user.ID] = user
+		}
+		snapshot.nextID = int64(tx.Bucket(boltUsers).Sequence()) + 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Verify recomputes the checksum of each record, and checks that records
+// are stored under their ID, that the email index matches them and that the
+// ID sequence is past every user. When repair is true, inconsistencies are
+// fixed in place and flagged as repaired in the report.
+func (b *BoltUserStore) Verify(repair bool) (*IntegrityReport, error) {
+	report := &IntegrityReport{Issues: []IntegrityIssue{}}
+	computed := make(map[int64]uint32)
+
+	verify := func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsers)
+		var repaired []User
+		var maxID int64
+		// expected holds the email index entries the records call for
+		expected := make(map[string]bool)
+		err := users.ForEach(func(key, data []byte) error {
+			id := int64(binary.BigEndian.Uint64(key))
+			var record boltRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return fmt.Errorf("failed to decode user %d: %w", id, err)
+			}
+			report.Records++
+			maxID = max(maxID, id)
+
+			user := record.User
+			fix := false
+			if user.ID != id {
+				report.Issues = append(report.Issues, IntegrityIssue{
+					UserID:   id,
+					Kind:     IssueIDMismatch,
+					Detail:   fmt.Sprintf("record stored under key %d has ID %d", id, user.ID),
+					Repaired: repair,
+				})
+				user.ID = id
+				fix = true
+			}
+			sum := checksum(user)
+			computed[id] = sum
+			if record.Checksum != sum && !fix {
+				report.Issues = append(report.Issues, IntegrityIssue{
+					UserID:   id,
+					Kind:     IssueChecksumMismatch,
+					Detail:   fmt.Sprintf("stored checksum %08x does not match computed %08x", record.Checksum, sum),
+					Repaired: repair,
+				})
+				fix = true
+			}
+			if fix {
+				repaired = append(repaired, user)
+			}
+			if email := emailKey(user.Email); email != "" {
+				expected[string(boltEmail(email, id))] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		emails := tx.Bucket(boltEmails)
+		var stale [][]byte
+		err = emails.ForEach(func(entry, _ []byte) error {
+			if expected[string(entry)] {
+				delete(expected, string(entry))
+				return nil
+			}
+			stale = append(stale, bytes.Clone(entry))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		var missing [][]byte
+		for entry := range expected {
+			missing = append(missing, []byte(entry))
+		}
+		for _, entry := range append(stale, missing...) {
+			report.Issues = append(report.Issues, IntegrityIssue{
+				Kind:     IssueIndexMismatch,
+				Detail:   fmt.Sprintf("%s index entry %q does not match the stored records", IndexEmail, entry[:max(0, len(entry)-9)]),
+				Repaired: repair,
+			})
+		}
+
+		if sequence := int64(users.Sequence()); sequence < maxID {
+			report.Issues = append(report.Issues, IntegrityIssue{
+				UserID:   maxID,
+				Kind:     IssueSequenceBehind,
+				Detail:   fmt.Sprintf("next ID %d would collide with existing ID %d", sequence+1, maxID),
+				Repaired: repair,
+			})
+		}
+
+		if !repair {
+			return nil
+		}
+		for _, user := range repaired {
+			data, err := json.Marshal(boltRecord{User: user, Checksum: checksum(user)})
+			if err != nil {
+				return err
+			}
+			if err := users.Put(boltID(user.ID), data); err != nil {
+				return err
+			}
+		}
+		for _, entry := range stale {
+			if err := emails.Delete(entry); err != nil {
+				return err
+			}
+		}
+		for _, entry := range missing {
+			if err := emails.Put(entry, nil); err != nil {
+				return err
+			}
+		}
+		return boltAdvance(tx, maxID)
+	}
+
+	var err error
+	if repair {
+		err = b.update(verify)
+	} else {
+		err = b.db.View(verify)
+	}
+	if err != nil {
+		if repair {
+			return nil, fmt.Errorf("failed to persist repairs: %w", err)
+		}
+		return nil, err
+	}
+
+	sort.Slice(report.Issues, func(i, j int) bool {
+		if report.Issues[i].UserID != report.Issues[j].UserID {
+			return report.Issues[i].UserID < report.Issues[j].UserID
+		}
+		if report.Issues[i].Kind != report.Issues[j].Kind {
+			return report.Issues[i].Kind < report.Issues[j].Kind
+		}
+		return report.Issues[i].Detail < report.Issues[j].Detail
+	})
+	report.Checksum = combinedChecksum(computed)
+	return report, nil
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// newBoltStore opens a store on a new file, closed after the test
+func newBoltStore(t *testing.T, clk clock.Clock) *BoltUserStore {
+	s, err := NewBoltUserStore(BoltOptions{Path: filepath.Join(t.TempDir(), "data", "users.db"), Clock: clk})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestBoltUserStoreCompliance(t *testing.T) {
+	suite.Run(t, &UserStoreTestSuite{newStore: func(t *testing.T) UserStore { return newBoltStore(t, nil) }})
+}
+
+func TestBoltUserStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	s, err := NewBoltUserStore(BoltOptions{Path: path})
+	require.NoError(t, err)
+	john, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+	require.NoError(t, err)
+	jane, err := s.Create(User{Name: "Jane Doe", Email: "jane@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(jane.ID))
+
+	// Only one process may hold the file
+	_, err = NewBoltUserStore(BoltOptions{Path: path, Timeout: 50 * time.Millisecond})
+	assert.ErrorContains(t, err, "in use by another process")
+	require.NoError(t, s.Close())
+
+	s, err = NewBoltUserStore(BoltOptions{Path: path})
+	require.NoError(t, err)
+	defer s.Close()
+	users, err := s.GetAll()
+	require.NoError(t, err)
+	assert.Equal(t, []User{*john}, users)
+	got, err := s.GetByEmail("john@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, john, got)
+
+	// IDs of deleted users are not reused after reopening
+	next, err := s.Create(User{Name: "Next", Email: "next@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), next.ID)
+}
+
+func TestBoltUserStore_IDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	s, err := NewBoltUserStore(BoltOptions{Path: path, IDs: &countingIDs{next: 1000}})
+	require.NoError(t, err)
+	user, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1001), user.ID)
+	created, err := s.CreateMany([]User{{Name: "Jane Doe", Email: "jane@example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1002), created[0].ID)
+	require.NoError(t, s.Close())
+
+	// Without IDs, the sequence carries on past those given
+	s, err = NewBoltUserStore(BoltOptions{Path: path})
+	require.NoError(t, err)
+	defer s.Close()
+	next, err := s.Create(User{Name: "Next", Email: "next@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1003), next.ID)
+}
+
+func TestNewBoltUserStore_UnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := bolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket(boltMeta)
+		if err != nil {
+			return err
+		}
+		return meta.Put(boltVersKey, binary.BigEndian.AppendUint64(nil, boltVersion+1))
+	}))
+	require.NoError(t, db.Close())
+
+	_, err = NewBoltUserStore(BoltOptions{Path: path})
+	assert.ErrorContains(t, err, "unsupported layout version 2")
+}
+
+func TestBoltUserStore_Timestamps(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 123456789, time.UTC))
+	s := newBoltStore(t, clk)
+
+	created, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), created.ID)
+	assert.Equal(t, clk.Now(), created.CreatedAt)
+
+	clk.Advance(time.Minute)
+	revision := s.Revision()
+	require.NoError(t, s.RecordActivity(map[int64]time.Time{created.ID: clk.Now(), 99: clk.Now()}))
+	assert.Greater(t, s.Revision(), revision)
+	// Activity only moves forward
+	require.NoError(t, s.RecordActivity(map[int64]time.Time{created.ID: clk.Now().Add(-time.Hour)}))
+	updated, err := s.Update(created.ID, User{Name: "John Smith", Email: "john@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+	assert.Equal(t, created.CreatedAt.Add(time.Minute), updated.UpdatedAt)
+	require.NotNil(t, updated.LastSeenAt)
+	assert.Equal(t, updated.UpdatedAt, *updated.LastSeenAt)
+
+	got, err := s.GetByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, updated, got)
+
+	_, err = s.Update(99, User{Name: "Nobody"})
+	assert.ErrorContains(t, err, "user not found")
+	assert.ErrorContains(t, s.Delete(99), "user not found")
+}
+
+func TestBoltUserStore_Email(t *testing.T) {
+	s := newBoltStore(t, nil)
+	first, err := s.Create(User{Name: "John Doe", Email: "John@Example.com"})
+	require.NoError(t, err)
+	second, err := s.Create(User{Name: "John Again", Email: "john@example.com"})
+	require.NoError(t, err)
+
+	got, err := s.GetByEmail(" JOHN@example.COM")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, got.ID)
+
+	// Changing an email moves its index entry
+	_, err = s.Update(first.ID, User{Name: "John Doe", Email: "johnny@example.com"})
+	require.NoError(t, err)
+	got, err = s.GetByEmail("john@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, got.ID)
+	got, err = s.GetByEmail("johnny@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, got.ID)
+
+	require.NoError(t, s.Delete(second.ID))
+	_, err = s.GetByEmail("john@example.com")
+	assert.Error(t, err)
+	// A prefix of an address is not a match
+	_, err = s.GetByEmail("johnny@example")
+	assert.Error(t, err)
+}
+
+func TestBoltUserStore_Find(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := newBoltStore(t, clk)
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := s.Create(User{Name: name, Email: name + "@example.com"})
+		require.NoError(t, err)
+		clk.Advance(time.Hour)
+	}
+	_, err := s.Update(1, User{Name: "a", Email: "a@example.com"})
+	require.NoError(t, err)
+
+	names := func(users []User) []string {
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+	users, err := s.Find(UserFilter{CreatedAfter: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, names(users))
+	users, err = s.Find(UserFilter{UpdatedAfter: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, names(users))
+	users, err = s.Find(UserFilter{UpdatedAfter: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), Sort: UserSort{{Field: SortName}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, names(users))
+}
+
+func TestBoltUserStore_RestoreAndReplace(t *testing.T) {
+	s := newBoltStore(t, nil)
+	created, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+	require.NoError(t, err)
+	_, err = s.Restore(*created)
+	assert.ErrorContains(t, err, "user 1 already exists")
+
+	require.NoError(t, s.Delete(created.ID))
+	restored, err := s.Restore(*created)
+	require.NoError(t, err)
+	assert.Equal(t, created, restored)
+
+	_, err = s.Replace(User{ID: 10, Name: "Jane Doe", Email: "jane@example.com"})
+	require.NoError(t, err)
+	// New users never take a replaced user's ID
+	next, err := s.Create(User{Name: "Next", Email: "next@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), next.ID)
+
+	snapshot, err := s.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(next.ID))
+	count, err := snapshot.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count, "snapshot changed after a write")
+}
+
+func TestBoltUserStore_Verify(t *testing.T) {
+	s := newBoltStore(t, nil)
+	_, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+	require.NoError(t, err)
+
+	report, err := s.Verify(false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Records)
+	assert.Empty(t, report.Issues)
+	checksum := report.Checksum
+
+	// Corrupt the file behind the store's back: a record with a stale
+	// checksum under the wrong key, a stale email entry and an ID sequence
+	// behind the records
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(boltRecord{User: User{ID: 7, Name: "Jane Doe", Email: "jane@example.com"}, Checksum: 1})
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltUsers).Put(boltID(5), data); err != nil {
+			return err
+		}
+		return tx.Bucket(boltEmails).Put(boltEmail("gone@example.com", 3), nil)
+	}))
+
+	report, err = s.Verify(false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Records)
+	var kinds []string
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	assert.Equal(t, []string{IssueIndexMismatch, IssueIndexMismatch, IssueIDMismatch, IssueSequenceBehind}, kinds)
+	assert.False(t, report.Healthy())
+
+	report, err = s.Verify(true)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+	report, err = s.Verify(false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Issues)
+	assert.NotEqual(t, checksum, report.Checksum)
+
+	got, err := s.GetByEmail("jane@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), got.ID)
+	next, err := s.Create(User{Name: "Next", Email: "next@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), next.ID)
+}
+
+func TestBoltUserStore_ConcurrentAccess(t *testing.T) {
+	s := newBoltStore(t, nil)
+	const writers, perWriter = 8, 25
+
+	var wg sync.WaitGroup
+	ids := make(chan int64, writers*perWriter)
+	for w := range writers {
+		wg.Go(func() {
+			for i := range perWriter {
+				user, err := s.Create(User{Name: "User", Email: fmt.Sprintf("user-%d-%d@example.com", w, i)})
+				if !assert.NoError(t, err) {
+					return
+				}
+				ids <- user.ID
+				_, err = s.Update(user.ID, User{Name: "Updated", Email: user.Email})
+				assert.NoError(t, err)
+				assert.NoError(t, s.RecordActivity(map[int64]time.Time{user.ID: time.Now()}))
+			}
+		})
+	}
+	// Readers see whole users while the writers run
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 4 {
+		readers.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				users, err := s.GetAll()
+				if !assert.NoError(t, err) {
+					return
+				}
+				for _, user := range users {
+					assert.NotEmpty(t, user.Email)
+					got, err := s.GetByEmail(user.Email)
+					if assert.NoError(t, err) {
+						assert.Equal(t, user.ID, got.ID)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+	close(ids)
+
+	seen := make(map[int64]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "ID %d assigned twice", id)
+		seen[id] = true
+	}
+	count, err := s.Count()
+	require.NoError(t, err)
+	assert.Equal(t, writers*perWriter, count)
+	report, err := s.Verify(false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Issues)
+}
@@ -0,0 +1,400 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// Change operations
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ErrChangesExpired is returned when a consumer asks for changes that have
+// already been dropped from the retained history
+var ErrChangesExpired = errors.New("requested changes are no longer retained")
+
+// Change is a single mutation captured from the store
+type Change struct {
+	Seq    uint64    `json:"seq" example:"42"`
+	Op     string    `json:"op" example:"update"`
+	UserID int64     `json:"user_id" example:"1"`
+	User   *User     `json:"user,omitempty"`
+	Time   time.Time `json:"time" example:"2024-01-02T15:04:05Z"`
+}
+
+// ChangeFeed is implemented by stores that expose their change stream
+type ChangeFeed interface {
+	Changes(fromSeq uint64, limit int) ([]Change, error)
+	LastSeq() uint64
+}
+
+// ChangeLogOptions configures a ChangeCapturingUserStore
+type ChangeLogOptions struct {
+	// OutboxPath is an optional file changes are appended to, so sequence
+	// numbers and history survive restarts
+	OutboxPath string
+	// Retention is the number of most recent changes kept for consumers
+	Retention int
+	// Clock timestamps changes, defaulting to the real clock
+	Clock clock.Clock
+}
+
+// ChangeCapturingUserStore decorates a UserStore and records every
+// successful mutation as an ordered, sequence-numbered change
+type ChangeCapturingUserStore struct {
+	UserStore
+
+	opts    ChangeLogOptions
+	mutex   sync.Mutex
+	seq     uint64
+	changes []Change
+	outbox  *os.File
+}
+
+// NewChangeCapturingUserStore wraps a store with change data capture,
+// restoring history from the outbox when one is configured
+func NewChangeCapturingUserStore(inner UserStore, opts ChangeLogOptions) (*ChangeCapturingUserStore, error) {
+	if opts.Retention <= 0 {
+		opts.Retention = 10000
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+
+	s := &ChangeCapturingUserStore{UserStore: inner, opts: opts}
+	if opts.OutboxPath == "" {
+		return s, nil
+	}
+
+	if err := s.openOutbox(); err != nil {
+		return nil, fmt.Errorf("failed to open change outbox: %w", err)
+	}
+	return s, nil
+}
+
+// openOutbox loads retained changes and reopens the outbox for appending
+func (s *ChangeCapturingUserStore) openOutbox() error {
+	if err := os.MkdirAll(filepath.Dir(s.opts.OutboxPath), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.opts.OutboxPath)
+	switch {
+	case err == nil:
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var change Change
+			if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+				// Stop at the first unreadable record, e.g. a torn final write
+				break
+			}
+			s.retain(change)
+		}
+		_ = file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	// Rewrite the outbox with only the retained changes, which also drops
+	// any torn trailing record
+	tmpPath := s.opts.OutboxPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := writeChanges(tmp, s.changes); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.opts.OutboxPath); err != nil {
+		return err
+	}
+
+	s.outbox, err = os.OpenFile(s.opts.OutboxPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// writeChanges encodes changes as JSON lines
+func writeChanges(w io.Writer, changes []Change) error {
+	encoder := json.NewEncoder(w)
+	for _, change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retain appends a change to the in-memory history, dropping the oldest
+// entries beyond the retention limit
+func (s *ChangeCapturingUserStore) retain(change Change) {
+	s.seq = change.Seq
+	s.changes = append(s.changes, change)
+	if excess := len(s.changes) - s.opts.Retention; excess > 0 {
+		s.changes = append(s.changes[:0:0], s.changes[excess:]...)
+	}
+}
+
+// capture assigns the next sequence number to a change and persists it. The
+// caller must hold the mutex.
+func (s *ChangeCapturingUserStore) capture(op string, userID int64, user *User) {
+	change := Change{
+		Seq:    s.seq + 1,
+		Op:     op,
+		UserID: userID,
+		User:   user,
+		Time:   s.opts.Clock.Now().UTC(),
+	}
+
+	if s.outbox != nil {
+		// The mutation has already been applied, so a failed outbox write is
+		// logged rather than reported to the caller
+		if err := writeChanges(s.outbox, []Change{change}); err != nil {
+			logger.Error("Failed to write change to outbox", "seq", change.Seq, "error", err)
+		}
+	}
+	s.retain(change)
+}
+
+// Create creates a user and records the change
+func (s *ChangeCapturingUserStore) Create(user User) (*User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	created, err := s.UserStore.Create(user)
+	if err != nil {
+		return nil, err
+	}
+	recorded := *created
+	s.capture(ChangeCreate, created.ID, &recorded)
+	return created, nil
+}
+
+// CreateMany creates users through the wrapped store and records a change
+// for each
+func (s *ChangeCapturingUserStore) CreateMany(users []User) ([]User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	created, err := CreateMany(s.UserStore, users)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range created {
+		recorded := user
+		s.capture(ChangeCreate, user.ID, &recorded)
+	}
+	return created, nil
+}
+
+// Update updates a user and records the change
+func (s *ChangeCapturingUserStore) Update(id int64, user User) (*User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated, err := s.UserStore.Update(id, user)
+	if err != nil {
+		return nil, err
+	}
+	recorded := *updated
+	s.capture(ChangeUpdate, updated.ID, &recorded)
+	return updated, nil
+}
+
+// UpdateVersion implements VersionedWriter, failing with
+// ErrVersionsUnsupported when the inner store does not
+func (s *ChangeCapturingUserStore) UpdateVersion(id, version int64, user User) (*User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated, err := UpdateVersion(s.UserStore, id, version, user)
+	if err != nil {
+		return nil, err
+	}
+	recorded := *updated
+	s.capture(ChangeUpdate, updated.ID, &recorded)
+	return updated, nil
+}
+
+// Delete deletes a user and records the change
+func (s *ChangeCapturingUserStore) Delete(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.UserStore.Delete(id); err != nil {
+		return err
+	}
+	s.capture(ChangeDelete, id, nil)
+	return nil
+}
+
+// DeleteVersion implements VersionedWriter, failing with
+// ErrVersionsUnsupported when the inner store does not
+func (s *ChangeCapturingUserStore) DeleteVersion(id, version int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := DeleteVersion(s.UserStore, id, version); err != nil {
+		return err
+	}
+	s.capture(ChangeDelete, id, nil)
+	return nil
+}
+
+// Restore restores a user through the wrapped store and records it as a
+// create, since consumers saw the user deleted
+func (s *ChangeCapturingUserStore) Restore(user User) (*User, error) {
+	recoverer, ok := s.UserStore.(Recoverer)
+	if !ok {
+		return nil, errors.New("store does not restore users")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	restored, err := recoverer.Restore(user)
+	if err != nil {
+		return nil, err
+	}
+	recorded := *restored
+	s.capture(ChangeCreate, restored.ID, &recorded)
+	return restored, nil
+}
+
+// Replace replaces a user through the wrapped store and records it as a
+// create or an update, depending on whether the user existed
+func (s *ChangeCapturingUserStore) Replace(user User) (*User, error) {
+	replacer, ok := s.UserStore.(Replacer)
+	if !ok {
+		return nil, errors.New("store does not replace users")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kind := ChangeCreate
+	if _, err := s.UserStore.GetByID(user.ID); err == nil {
+		kind = ChangeUpdate
+	}
+	replaced, err := replacer.Replace(user)
+	if err != nil {
+		return nil, err
+	}
+	recorded := *replaced
+	s.capture(kind, replaced.ID, &recorded)
+	return replaced, nil
+}
+
+// RecordActivity delegates to the wrapped store when it records activity.
+// Activity is not captured as a change, as it is not an edit of the user.
+func (s *ChangeCapturingUserStore) RecordActivity(seen map[int64]time.Time) error {
+	recorder, ok := s.UserStore.(ActivityRecorder)
+	if !ok {
+		return errors.New("store does not record activity")
+	}
+	return recorder.RecordActivity(seen)
+}
+
+// Find delegates to the wrapped store, so it can use its indexes
+func (s *ChangeCapturingUserStore) Find(filter UserFilter) ([]User, error) {
+	return FindUsers(s.UserStore, filter)
+}
+
+// GetByIDs delegates to the wrapped store, so it can look the users up at
+// once
+func (s *ChangeCapturingUserStore) GetByIDs(ids []int64) ([]User, error) {
+	return GetUsers(s.UserStore, ids)
+}
+
+// Changes returns up to limit changes with a sequence number greater than
+// fromSeq, in order. It returns ErrChangesExpired if changes after fromSeq
+// have already been dropped, in which case the consumer must resynchronise.
+func (s *ChangeCapturingUserStore) Changes(fromSeq uint64, limit int) ([]Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// A consumer ahead of the stream has seen history that was lost, e.g.
+	// after a restart without an outbox, so it must resynchronise too
+	if fromSeq > s.seq || (len(s.changes) > 0 && fromSeq+1 < s.changes[0].Seq) {
+		return nil, ErrChangesExpired
+	}
+
+	changes := make([]Change, 0)
+	for _, change := range s.changes {
+		if change.Seq <= fromSeq {
+			continue
+		}
+		if limit > 0 && len(changes) >= limit {
+			break
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// LastSeq returns the sequence number of the most recent change
+func (s *ChangeCapturingUserStore) LastSeq() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.seq
+}
+
+// Verify delegates to the wrapped store when it supports verification
+func (s *ChangeCapturingUserStore) Verify(repair bool) (*IntegrityReport, error) {
+	verifier, ok := s.UserStore.(Verifier)
+	if !ok {
+		return nil, errors.New("store does not support verification")
+	}
+	return verifier.Verify(repair)
+}
+
+// Snapshot implements Snapshotter, failing with ErrSnapshotsUnsupported
+// when the inner store does not
+func (s *ChangeCapturingUserStore) Snapshot() (UserSnapshot, error) {
+	return Snapshot(s.UserStore)
+}
+
+// Revision delegates to the wrapped store, falling back to the change
+// sequence when the wrapped store does not track revisions
+func (s *ChangeCapturingUserStore) Revision() uint64 {
+	if revisioner, ok := s.UserStore.(Revisioner); ok {
+		return revisioner.Revision()
+	}
+	return s.LastSeq()
+}
+
+// Close closes the outbox and the wrapped store
+func (s *ChangeCapturingUserStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+	if s.outbox != nil {
+		err = s.outbox.Close()
+		s.outbox = nil
+	}
+	if closer, ok := s.UserStore.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
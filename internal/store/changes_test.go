@@ -0,0 +1,140 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+func TestChangeCapturingUserStore_RecordsMutationsInOrder(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store, err := NewChangeCapturingUserStore(NewMemoryUserStore(), ChangeLogOptions{Clock: fake})
+	require.NoError(t, err)
+
+	user, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	fake.Advance(time.Minute)
+	_, _ = store.Update(user.ID, User{Name: "Updated", Email: "updated@example.com"})
+	fake.Advance(time.Minute)
+	require.NoError(t, store.Delete(user.ID))
+
+	// Failed mutations are not captured
+	_, err = store.Update(999, User{Name: "Missing"})
+	assert.Error(t, err)
+	assert.Error(t, store.Delete(999))
+
+	changes, err := store.Changes(0, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+
+	expected := []struct {
+		seq uint64
+		op  string
+	}{
+		{1, ChangeCreate},
+		{2, ChangeUpdate},
+		{3, ChangeDelete},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e.seq, changes[i].Seq)
+		assert.Equal(t, e.op, changes[i].Op)
+		assert.Equal(t, user.ID, changes[i].UserID)
+		assert.Equal(t, start.Add(time.Duration(i)*time.Minute), changes[i].Time)
+	}
+	assert.Equal(t, "Updated", changes[1].User.Name)
+	assert.Nil(t, changes[2].User)
+	assert.Equal(t, uint64(3), store.LastSeq())
+}
+
+func TestChangeCapturingUserStore_Changes(t *testing.T) {
+	store, err := NewChangeCapturingUserStore(NewMemoryUserStore(), ChangeLogOptions{Retention: 5})
+	require.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		_, _ = store.Create(User{Name: "User", Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+
+	tests := []struct {
+		name        string
+		fromSeq     uint64
+		limit       int
+		expectedSeq []uint64
+		expectError error
+	}{
+		{
+			name:        "resume from retained sequence",
+			fromSeq:     5,
+			expectedSeq: []uint64{6, 7, 8},
+		},
+		{
+			name:        "limit the page size",
+			fromSeq:     3,
+			limit:       2,
+			expectedSeq: []uint64{4, 5},
+		},
+		{
+			name:        "caught up",
+			fromSeq:     8,
+			expectedSeq: []uint64{},
+		},
+		{
+			name:        "changes dropped by retention",
+			fromSeq:     1,
+			expectError: ErrChangesExpired,
+		},
+		{
+			name:        "consumer ahead of the stream",
+			fromSeq:     20,
+			expectError: ErrChangesExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := store.Changes(tt.fromSeq, tt.limit)
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			seqs := make([]uint64, 0, len(changes))
+			for _, change := range changes {
+				seqs = append(seqs, change.Seq)
+			}
+			assert.Equal(t, tt.expectedSeq, seqs)
+		})
+	}
+}
+
+func TestChangeCapturingUserStore_OutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.outbox")
+
+	store, err := NewChangeCapturingUserStore(NewMemoryUserStore(), ChangeLogOptions{OutboxPath: path, Retention: 3})
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, _ = store.Create(User{Name: "User", Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	require.NoError(t, store.Close())
+
+	reopened, err := NewChangeCapturingUserStore(NewMemoryUserStore(), ChangeLogOptions{OutboxPath: path, Retention: 3})
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, uint64(4), reopened.LastSeq())
+	changes, err := reopened.Changes(1, 0)
+	require.NoError(t, err)
+	assert.Len(t, changes, 3)
+
+	// Sequence numbers continue after a restart
+	_, _ = reopened.Create(User{Name: "User 5", Email: "user5@example.com"})
+	changes, err = reopened.Changes(4, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, uint64(5), changes[0].Seq)
+}
@@ -0,0 +1,436 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/dazraf/go-api-example/pkg/httpx"
+)
+
+// ErrInvalidCondition is returned for filter expressions that do not parse,
+// or that compare unknown fields, with operators they do not support, or
+// with values of the wrong type
+var ErrInvalidCondition = errors.New("invalid filter")
+
+// Condition selects users, parsed from an RSQL filter expression by
+// ParseCondition. It is All or Any of other conditions, or a Comparison,
+// which stores translate to their own queries.
+type Condition interface {
+	Matches(user User) bool
+}
+
+// All selects users passing every condition
+type All []Condition
+
+// Matches reports whether user passes every condition
+func (c All) Matches(user User) bool {
+	for _, condition := range c {
+		if !condition.Matches(user) {
+			return false
+		}
+	}
+	return true
+}
+
+// Any selects users passing at least one condition
+type Any []Condition
+
+// Matches reports whether user passes at least one condition
+func (c Any) Matches(user User) bool {
+	for _, condition := range c {
+		if condition.Matches(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// Operator compares a field with values
+type Operator string
+
+// Operators of comparisons. Names and emails are compared for equality
+// ignoring case and Unicode normalization, as they are searched, and are
+// not ordered. Like matches them against patterns with * wildcards.
+const (
+	OpEqual          Operator = "=="
+	OpNotEqual       Operator = "!="
+	OpLike           Operator = "=like="
+	OpLess           Operator = "=lt="
+	OpLessOrEqual    Operator = "=le="
+	OpGreater        Operator = "=gt="
+	OpGreaterOrEqual Operator = "=ge="
+	OpIn             Operator = "=in="
+	OpOut            Operator = "=out="
+)
+
+// operatorAliases maps the symbolic spellings of ordering operators to
+// their names
+var operatorAliases = map[string]Operator{
+	"<": OpLess, "<=": OpLessOrEqual, ">": OpGreater, ">=": OpGreaterOrEqual,
+}
+
+// Comparison selects users whose field compares with values by the
+// operator. In and Out take any number of values, the others exactly one.
+// Values are int64 for id, strings for name and email, and time.Time for
+// created_at, updated_at and last_seen_at. Users never seen have no
+// last_seen_at, so only pass != and =out= comparisons of it.
+type Comparison struct {
+	Field  string
+	Op     Operator
+	Values []any
+}
+
+// Matches reports whether user's field compares with the values
+func (c Comparison) Matches(user User) bool {
+	value, ok := fieldValue(user, c.Field)
+	switch c.Op {
+	case OpNotEqual, OpOut:
+		return !ok || !slices.ContainsFunc(c.Values, func(v any) bool { return compareValues(value, v) == 0 })
+	case OpEqual, OpIn:
+		return ok && slices.ContainsFunc(c.Values, func(v any) bool { return compareValues(value, v) == 0 })
+	case OpLike:
+		return ok && matchesWildcard(fold(value.(string)), fold(c.Values[0].(string)))
+	}
+	if !ok {
+		return false
+	}
+	order := compareValues(value, c.Values[0])
+	switch c.Op {
+	case OpLess:
+		return order < 0
+	case OpLessOrEqual:
+		return order <= 0
+	case OpGreater:
+		return order > 0
+	case OpGreaterOrEqual:
+		return order >= 0
+	}
+	return false
+}
+
+// fieldValue returns user's value of field, and false when it has none
+func fieldValue(user User, field string) (any, bool) {
+	switch field {
+	case SortID:
+		return user.ID, true
+	case SortName:
+		return user.Name, true
+	case SortEmail:
+		return user.Email, true
+	case SortCreatedAt:
+		return user.CreatedAt, true
+	case SortUpdatedAt:
+		return user.UpdatedAt, true
+	case SortLastSeenAt:
+		if user.LastSeenAt == nil {
+			return nil, false
+		}
+		return *user.LastSeenAt, true
+	}
+	return nil, false
+}
+
+// compareValues orders two values of the same field
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case string:
+		return strings.Compare(fold(a), fold(b.(string)))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+// fold returns s in the form its comparisons ignoring case and Unicode
+// normalization are made in
+func fold(s string) string {
+	return strings.ToLower(norm.NFC.String(s))
+}
+
+// matchesWildcard reports whether s matches pattern, in which each *
+// matches any run of characters
+func matchesWildcard(s, pattern string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
+
+// conditionField is the type of a field conditions compare, and the
+// operators it supports
+type conditionField struct {
+	parse     func(value string) (any, error)
+	operators []Operator
+}
+
+var (
+	orderedOperators = []Operator{OpEqual, OpNotEqual, OpLess, OpLessOrEqual, OpGreater, OpGreaterOrEqual, OpIn, OpOut}
+	textOperators    = []Operator{OpEqual, OpNotEqual, OpLike, OpIn, OpOut}
+
+	timeField = conditionField{
+		parse:     func(value string) (any, error) { return httpx.ParseTime(value) },
+		operators: orderedOperators,
+	}
+	textField = conditionField{
+		parse:     func(value string) (any, error) { return norm.NFC.String(value), nil },
+		operators: textOperators,
+	}
+)
+
+// conditionFields lists the fields conditions can compare
+var conditionFields = map[string]conditionField{
+	SortID: {
+		parse: func(value string) (any, error) {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an integer", value)
+			}
+			return id, nil
+		},
+		operators: orderedOperators,
+	},
+	SortName:       textField,
+	SortEmail:      textField,
+	SortCreatedAt:  timeField,
+	SortUpdatedAt:  timeField,
+	SortLastSeenAt: timeField,
+}
+
+// maxConditionDepth bounds the nesting of parenthesized conditions
+const maxConditionDepth = 16
+
+// ParseCondition parses an RSQL filter expression such as
+// name=like=Jo*;created_at=ge=2024-01-01, comparing the fields id, name,
+// email, created_at, updated_at and last_seen_at. Comparisons are joined by
+// ; for and and , for or, with and binding tighter, and can be grouped in
+// parentheses. The operators are ==, !=, =like=, =lt=, =le=, =gt=, =ge=
+// (also written <, <=, > and >=), =in= and =out=, the last two taking a
+// parenthesized list of values such as id=in=(1,2,3). Values with spaces
+// or reserved characters are quoted with " or '. Times are RFC 3339 times
+// or dates. An empty expression gives a nil Condition, selecting every
+// user.
+func ParseCondition(expr string) (Condition, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	p := &conditionParser{expr: expr}
+	condition, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.expr) {
+		return nil, p.fail("unexpected %q", p.expr[p.pos:p.pos+1])
+	}
+	return condition, nil
+}
+
+// conditionParser parses RSQL by recursive descent
+type conditionParser struct {
+	expr string
+	pos  int
+}
+
+func (p *conditionParser) fail(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at position %d", ErrInvalidCondition, fmt.Sprintf(format, args...), p.pos+1)
+}
+
+// skip reports whether the next character is c, moving past it if so
+func (p *conditionParser) skip(c byte) bool {
+	if p.pos < len(p.expr) && p.expr[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) or(depth int) (Condition, error) {
+	var anyOf Any
+	for {
+		condition, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		anyOf = append(anyOf, condition)
+		if !p.skip(',') {
+			break
+		}
+	}
+	if len(anyOf) == 1 {
+		return anyOf[0], nil
+	}
+	return anyOf, nil
+}
+
+func (p *conditionParser) and(depth int) (Condition, error) {
+	var allOf All
+	for {
+		condition, err := p.constraint(depth)
+		if err != nil {
+			return nil, err
+		}
+		allOf = append(allOf, condition)
+		if !p.skip(';') {
+			break
+		}
+	}
+	if len(allOf) == 1 {
+		return allOf[0], nil
+	}
+	return allOf, nil
+}
+
+func (p *conditionParser) constraint(depth int) (Condition, error) {
+	if p.skip('(') {
+		if depth == maxConditionDepth {
+			return nil, p.fail("conditions are nested too deeply")
+		}
+		condition, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !p.skip(')') {
+			return nil, p.fail("missing )")
+		}
+		return condition, nil
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (Condition, error) {
+	start := p.pos
+	for p.pos < len(p.expr) && (p.expr[p.pos] == '_' || 'a' <= p.expr[p.pos] && p.expr[p.pos] <= 'z') {
+		p.pos++
+	}
+	name := p.expr[start:p.pos]
+	if name == "" {
+		return nil, p.fail("expected a field")
+	}
+	field, ok := conditionFields[name]
+	if !ok {
+		p.pos = start
+		return nil, p.fail("unknown field %q, expected one of %s", name, strings.Join(SortFields, ", "))
+	}
+
+	opStart := p.pos
+	op, err := p.operator()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(field.operators, op) {
+		p.pos = opStart
+		return nil, p.fail("field %q does not support %s", name, op)
+	}
+
+	var raw []string
+	if op == OpIn || op == OpOut {
+		if !p.skip('(') {
+			return nil, p.fail("%s takes a parenthesized list of values", op)
+		}
+		for {
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, value)
+			if !p.skip(',') {
+				break
+			}
+		}
+		if !p.skip(')') {
+			return nil, p.fail("missing )")
+		}
+	} else {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, value)
+	}
+
+	values := make([]any, len(raw))
+	for i, value := range raw {
+		if values[i], err = field.parse(value); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCondition, name, err)
+		}
+	}
+	return Comparison{Field: name, Op: op, Values: values}, nil
+}
+
+func (p *conditionParser) operator() (Operator, error) {
+	rest := p.expr[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "=="), strings.HasPrefix(rest, "!="):
+		p.pos += 2
+		return Operator(rest[:2]), nil
+	case strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="):
+		p.pos += 2
+		return operatorAliases[rest[:2]], nil
+	case strings.HasPrefix(rest, "<"), strings.HasPrefix(rest, ">"):
+		p.pos++
+		return operatorAliases[rest[:1]], nil
+	case strings.HasPrefix(rest, "="):
+		end := strings.IndexByte(rest[1:], '=')
+		if end > 0 {
+			op := Operator(rest[:end+2])
+			switch op {
+			case OpLike, OpLess, OpLessOrEqual, OpGreater, OpGreaterOrEqual, OpIn, OpOut:
+				p.pos += len(op)
+				return op, nil
+			}
+		}
+	}
+	return "", p.fail("expected an operator such as == or =like=")
+}
+
+// reserved lists the characters values must be quoted to contain
+const reserved = "\"'();,=!~<> "
+
+func (p *conditionParser) value() (string, error) {
+	if p.pos < len(p.expr) && (p.expr[p.pos] == '"' || p.expr[p.pos] == '\'') {
+		quote := p.expr[p.pos]
+		var value strings.Builder
+		for i := p.pos + 1; i < len(p.expr); i++ {
+			switch c := p.expr[i]; {
+			case c == quote:
+				p.pos = i + 1
+				return value.String(), nil
+			case c == '\\' && i+1 < len(p.expr):
+				i++
+				value.WriteByte(p.expr[i])
+			default:
+				value.WriteByte(c)
+			}
+		}
+		return "", p.fail("unterminated string")
+	}
+	start := p.pos
+	for p.pos < len(p.expr) && !strings.ContainsRune(reserved, rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.fail("expected a value")
+	}
+	return p.expr[start:p.pos], nil
+}
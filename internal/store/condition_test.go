@@ -0,0 +1,121 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr    string
+		want    Condition
+		wantErr string
+	}{
+		{expr: "", want: nil},
+		{expr: "name=like=Jo*", want: Comparison{Field: SortName, Op: OpLike, Values: []any{"Jo*"}}},
+		{expr: "id==7", want: Comparison{Field: SortID, Op: OpEqual, Values: []any{int64(7)}}},
+		{expr: "created_at=ge=2024-01-01", want: Comparison{Field: SortCreatedAt, Op: OpGreaterOrEqual, Values: []any{jan}}},
+		{expr: "updated_at<2024-01-01T00:00:00Z", want: Comparison{Field: SortUpdatedAt, Op: OpLess, Values: []any{jan}}},
+		{expr: "id=out=(1,2)", want: Comparison{Field: SortID, Op: OpOut, Values: []any{int64(1), int64(2)}}},
+		{expr: `name=="John Doe"`, want: Comparison{Field: SortName, Op: OpEqual, Values: []any{"John Doe"}}},
+		{expr: `name=='O\'Brien'`, want: Comparison{Field: SortName, Op: OpEqual, Values: []any{"O'Brien"}}},
+		{
+			expr: "name==a;email==b,id>3",
+			want: Any{
+				All{
+					Comparison{Field: SortName, Op: OpEqual, Values: []any{"a"}},
+					Comparison{Field: SortEmail, Op: OpEqual, Values: []any{"b"}},
+				},
+				Comparison{Field: SortID, Op: OpGreater, Values: []any{int64(3)}},
+			},
+		},
+		{
+			expr: "name==a;(email==b,id>3)",
+			want: All{
+				Comparison{Field: SortName, Op: OpEqual, Values: []any{"a"}},
+				Any{
+					Comparison{Field: SortEmail, Op: OpEqual, Values: []any{"b"}},
+					Comparison{Field: SortID, Op: OpGreater, Values: []any{int64(3)}},
+				},
+			},
+		},
+		{expr: "status==active", wantErr: `unknown field "status"`},
+		{expr: "name=lt=a", wantErr: `field "name" does not support =lt=`},
+		{expr: "id=like=1*", wantErr: `field "id" does not support =like=`},
+		{expr: "id==abc", wantErr: `id: "abc" is not an integer`},
+		{expr: "created_at>yesterday", wantErr: "is not an RFC 3339 time or a date"},
+		{expr: "name=foo=a", wantErr: "expected an operator"},
+		{expr: "name==", wantErr: "expected a value"},
+		{expr: "id=in=1", wantErr: "takes a parenthesized list"},
+		{expr: "(name==a", wantErr: "missing )"},
+		{expr: "name==a)", wantErr: `unexpected ")"`},
+		{expr: `name=="a`, wantErr: "unterminated string"},
+		{expr: "name==a;", wantErr: "expected a field"},
+		{expr: "((((((((((((((((((name==a))))))))))))))))))", wantErr: "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseCondition(tt.expr)
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrInvalidCondition)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCondition_Matches(t *testing.T) {
+	seen := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	john := User{ID: 1, Name: "John Doe", Email: "John@Example.com", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), LastSeenAt: &seen}
+	jose := User{ID: 2, Name: "José", Email: "jose@example.org", CreatedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		expr string
+		want []int64
+	}{
+		{expr: "name=like=jo*", want: []int64{1, 2}},
+		{expr: "name=like=*doe", want: []int64{1}},
+		{expr: "email=like=*@example.*", want: []int64{1, 2}},
+		{expr: "email=like=j*n@*", want: []int64{1}},
+		{expr: "email=like=john", want: nil},
+		{expr: "name==JOSÉ", want: []int64{2}},
+		{expr: "email==john@example.com", want: []int64{1}},
+		{expr: "email=in=(jose@example.org,nobody@example.com)", want: []int64{2}},
+		{expr: "id!=1", want: []int64{2}},
+		{expr: "id=le=1,id=gt=1", want: []int64{1, 2}},
+		{expr: "created_at>2024-02-01;created_at<=2024-03-01", want: []int64{2}},
+		{expr: "last_seen_at>=2024-01-01", want: []int64{1}},
+		{expr: "last_seen_at!=2024-02-01", want: []int64{2}},
+		{expr: "last_seen_at=out=(2024-01-01)", want: []int64{1, 2}},
+		{expr: "name=like=j*;(id==2,email=like=*.com)", want: []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			condition, err := ParseCondition(tt.expr)
+			require.NoError(t, err)
+			var got []int64
+			for _, user := range []User{john, jose} {
+				if condition.Matches(user) {
+					got = append(got, user.ID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMatchesWildcard(t *testing.T) {
+	for pattern, want := range map[string]bool{
+		"abc": true, "*": true, "a*": true, "*c": true, "a*c": true, "*b*": true,
+		"a**c": true, "abcd": false, "ab*bc": false, "*d": false, "b*": false,
+	} {
+		assert.Equal(t, want, matchesWildcard("abc", pattern), pattern)
+	}
+}
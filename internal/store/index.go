@@ -0,0 +1,246 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/email"
+)
+
+// Names of the secondary indexes maintained by MemoryUserStore
+const (
+	IndexEmail     = "email"
+	IndexCreatedAt = "created_at"
+	IndexUpdatedAt = "updated_at"
+)
+
+// index maps keys derived from a user to the IDs of the users having them.
+// A user may produce several keys (e.g. one per tag) or none.
+type index struct {
+	name    string
+	keys    func(User) []string
+	entries map[string]map[int64]struct{}
+}
+
+// newIndex creates an empty index using keys to derive entries from users
+func newIndex(name string, keys func(User) []string) *index {
+	return &index{
+		name:    name,
+		keys:    keys,
+		entries: make(map[string]map[int64]struct{}),
+	}
+}
+
+// add indexes a user under all of its keys
+func (ix *index) add(user User) {
+	for _, key := range ix.keys(user) {
+		ids, exists := ix.entries[key]
+		if !exists {
+			ids = make(map[int64]struct{})
+			ix.entries[key] = ids
+		}
+		ids[user.ID] = struct{}{}
+	}
+}
+
+// remove drops a user from all of its keys
+func (ix *index) remove(user User) {
+	for _, key := range ix.keys(user) {
+		ids := ix.entries[key]
+		delete(ids, user.ID)
+		if len(ids) == 0 {
+			delete(ix.entries, key)
+		}
+	}
+}
+
+// lookup returns the IDs indexed under key in ascending order
+func (ix *index) lookup(key string) []int64 {
+	ids := make([]int64, 0, len(ix.entries[key]))
+	for id := range ix.entries[key] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// rebuild recreates the index from scratch
+func (ix *index) rebuild(users map[int64]User) {
+	ix.entries = make(map[string]map[int64]struct{})
+	for id, user := range users {
+		user.ID = id // Index under the key the record is stored at
+		ix.add(user)
+	}
+}
+
+// diff returns the keys whose entries differ from those a fresh rebuild from
+// users would produce
+func (ix *index) diff(users map[int64]User) []string {
+	expected := newIndex(ix.name, ix.keys)
+	expected.rebuild(users)
+
+	var keys []string
+	for key, ids := range ix.entries {
+		if !sameIDs(ids, expected.entries[key]) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range expected.entries {
+		if _, exists := ix.entries[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameIDs reports whether two ID sets are equal
+func sameIDs(a, b map[int64]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if _, exists := b[id]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// emailKey normalises an email address for lookups ignoring case, Unicode
+// normalization and whether its domain is written in punycode
+func emailKey(address string) string {
+	if strings.TrimSpace(address) == "" {
+		return ""
+	}
+	return email.Normalize(address)
+}
+
+// emailKeys indexes users by normalised email, skipping empty addresses
+func emailKeys(user User) []string {
+	key := emailKey(user.Email)
+	if key == "" {
+		return nil
+	}
+	return []string{key}
+}
+
+// timeIndex orders users by a timestamp so time ranges can be scanned
+// without visiting every user
+type timeIndex struct {
+	name string
+	at   func(User) time.Time
+	// entries are sorted by time, then ID
+	entries []timeEntry
+}
+
+type timeEntry struct {
+	at time.Time
+	id int64
+}
+
+func (e timeEntry) before(other timeEntry) bool {
+	if !e.at.Equal(other.at) {
+		return e.at.Before(other.at)
+	}
+	return e.id < other.id
+}
+
+// newTimeIndex creates an empty index ordering users by at
+func newTimeIndex(name string, at func(User) time.Time) *timeIndex {
+	return &timeIndex{name: name, at: at}
+}
+
+// search returns the position of entry, or where it would be inserted
+func (ix *timeIndex) search(entry timeEntry) int {
+	return sort.Search(len(ix.entries), func(i int) bool {
+		return !ix.entries[i].before(entry)
+	})
+}
+
+// add indexes a user. Users are mostly added in time order, so this is
+// usually an append.
+func (ix *timeIndex) add(user User) {
+	entry := timeEntry{at: ix.at(user), id: user.ID}
+	i := ix.search(entry)
+	ix.entries = append(ix.entries, timeEntry{})
+	copy(ix.entries[i+1:], ix.entries[i:])
+	ix.entries[i] = entry
+}
+
+// remove drops a user
+func (ix *timeIndex) remove(user User) {
+	entry := timeEntry{at: ix.at(user), id: user.ID}
+	if i := ix.search(entry); i < len(ix.entries) && ix.entries[i] == entry {
+		ix.entries = append(ix.entries[:i], ix.entries[i+1:]...)
+	}
+}
+
+// between returns the IDs of users whose time is strictly after from and
+// strictly before to, in time order. A zero from or to leaves that end open.
+func (ix *timeIndex) between(from, to time.Time) []int64 {
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(ix.entries), func(i int) bool {
+			return ix.entries[i].at.After(from)
+		})
+	}
+	end := len(ix.entries)
+	if !to.IsZero() {
+		end = sort.Search(len(ix.entries), func(i int) bool {
+			return !ix.entries[i].at.Before(to)
+		})
+	}
+
+	ids := make([]int64, 0, max(end-start, 0))
+	for _, entry := range ix.entries[start:max(start, end)] {
+		ids = append(ids, entry.id)
+	}
+	return ids
+}
+
+// rebuild recreates the index from scratch
+func (ix *timeIndex) rebuild(users map[int64]User) {
+	ix.entries = make([]timeEntry, 0, len(users))
+	for id, user := range users {
+		ix.entries = append(ix.entries, timeEntry{at: ix.at(user), id: id})
+	}
+	sort.Slice(ix.entries, func(i, j int) bool {
+		return ix.entries[i].before(ix.entries[j])
+	})
+}
+
+// diff returns the entries, written as ID@time, that differ from those a
+// fresh rebuild from users would produce
+func (ix *timeIndex) diff(users map[int64]User) []string {
+	expected := newTimeIndex(ix.name, ix.at)
+	expected.rebuild(users)
+
+	seen := make(map[timeEntry]bool, len(ix.entries))
+	for _, entry := range ix.entries {
+		seen[entry] = true
+	}
+	var keys []string
+	for _, entry := range expected.entries {
+		if !seen[entry] {
+			keys = append(keys, entry.String())
+		}
+		delete(seen, entry)
+	}
+	for entry := range seen {
+		keys = append(keys, entry.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e timeEntry) String() string {
+	return fmt.Sprintf("%d@%s", e.id, e.at.Format(time.RFC3339Nano))
+}
+
+func createdAt(user User) time.Time { return user.CreatedAt }
+
+func updatedAt(user User) time.Time { return user.UpdatedAt }
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Fsync policies supported by the journal
+const (
+	FsyncAlways   = "always"
+	FsyncInterval = "interval"
+	FsyncNever    = "never"
+)
+
+// Journal operations
+const (
+	journalOpCreate = "create"
+	journalOpUpdate = "update"
+	journalOpDelete = "delete"
+	// journalOpCreateMany creates a batch of users in one record, so a
+	// torn write loses the whole batch rather than part of it
+	journalOpCreateMany = "create_many"
+)
+
+// JournalOptions configures the write-ahead journal of a MemoryUserStore
+type JournalOptions struct {
+	// Path of the append-only journal file
+	Path string
+	// SnapshotPath of the compacted snapshot, defaults to Path + ".snapshot"
+	SnapshotPath string
+	// Fsync is one of FsyncAlways, FsyncInterval or FsyncNever
+	Fsync string
+	// FsyncInterval is how often the journal is synced under FsyncInterval
+	FsyncInterval time.Duration
+	// CompactInterval is how often the journal is folded into a snapshot,
+	// zero disables periodic compaction
+	CompactInterval time.Duration
+}
+
+// journalRecord is a single mutation, stored as one JSON line
+type journalRecord struct {
+	Op   string `json:"op"`
+	ID   int64  `json:"id"`
+	User *User  `json:"user,omitempty"`
+	// Users are the users of a batch
+	Users []User `json:"users,omitempty"`
+}
+
+// journalSnapshot is the compacted state of the store
+type journalSnapshot struct {
+	NextID int64  `json:"next_id"`
+	Users  []User `json:"users"`
+}
+
+// journal appends mutation records to a file according to an fsync policy
+type journal struct {
+	opts  JournalOptions
+	file  *os.File
+	mutex sync.Mutex
+	dirty bool
+
+	// compacting serialises rotate/compact cycles
+	compacting sync.Mutex
+}
+
+// openJournal opens (or creates) the journal file for appending
+func openJournal(opts JournalOptions) (*journal, error) {
+	switch opts.Fsync {
+	case "":
+		opts.Fsync = FsyncInterval
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", opts.Fsync)
+	}
+	if opts.FsyncInterval <= 0 {
+		opts.FsyncInterval = time.Second
+	}
+	if opts.SnapshotPath == "" {
+		opts.SnapshotPath = opts.Path + ".snapshot"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{opts: opts, file: file}, nil
+}
+
+// append writes a record, syncing immediately under FsyncAlways
+func (j *journal) append(record journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if j.opts.Fsync == FsyncAlways {
+		return j.file.Sync()
+	}
+	j.dirty = true
+	return nil
+}
+
+// sync flushes pending writes to disk if any were made since the last sync
+func (j *journal) sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.dirty {
+		return nil
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+// replay reads the snapshot and journals, calling apply for every record.
+// A rotated journal left behind by an interrupted compaction is replayed
+// between the snapshot and the live journal.
+func (j *journal) replay(restore func(journalSnapshot), apply func(journalRecord)) error {
+	data, err := os.ReadFile(j.opts.SnapshotPath)
+	switch {
+	case err == nil:
+		var snapshot journalSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		restore(snapshot)
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	rotated, err := os.OpenFile(j.rotatedPath(), os.O_RDWR, 0)
+	switch {
+	case err == nil:
+		err = replayFile(rotated, apply)
+		_ = rotated.Close()
+		if err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return replayFile(j.file, apply)
+}
+
+// replayFile applies every complete record in a journal file
+func replayFile(file *os.File, apply func(journalRecord)) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) == 0 {
+				return nil
+			}
+			// A trailing line without a newline is a torn write from a crash;
+			// it was never acknowledged, so drop it before appending again
+			return file.Truncate(offset)
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("corrupt record on line %d of %s: %w", line, file.Name(), err)
+		}
+		apply(record)
+	}
+}
+
+// rotatedPath is where the journal is moved while it is being compacted
+func (j *journal) rotatedPath() string {
+	return j.opts.Path + ".compacting"
+}
+
+// rotate moves the live journal aside so it can be folded into a snapshot
+// while new records are appended to a fresh file. The caller must prevent
+// concurrent appends.
+func (j *journal) rotate() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.dirty = false
+
+	rotated, err := os.OpenFile(j.rotatedPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		// Common case: no earlier compaction is pending
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(j.opts.Path, j.rotatedPath()); err != nil {
+			return err
+		}
+		j.file, err = os.OpenFile(j.opts.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer rotated.Close()
+
+	// An earlier compaction failed, so add the live journal to the pending
+	// one rather than overwrite records that are not in any snapshot yet
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(rotated, j.file); err != nil {
+		return err
+	}
+	if err := rotated.Sync(); err != nil {
+		return err
+	}
+	return j.file.Truncate(0)
+}
+
+// compact atomically writes a snapshot covering the rotated journal and then
+// removes the rotated journal
+func (j *journal) compact(snapshot journalSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := j.opts.SnapshotPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, j.opts.SnapshotPath); err != nil {
+		return err
+	}
+
+	if err := os.Remove(j.rotatedPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// close syncs and closes the journal file
+func (j *journal) close() error {
+	if err := j.sync(); err != nil {
+		_ = j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
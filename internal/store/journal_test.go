@@ -0,0 +1,232 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+func openTestJournaledStore(t *testing.T, path string) *MemoryUserStore {
+	t.Helper()
+	store, err := NewJournaledMemoryUserStore(JournalOptions{Path: path, Fsync: FsyncAlways})
+	require.NoError(t, err)
+	return store
+}
+
+func TestJournaledMemoryUserStore_ReplaysMutations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.journal")
+
+	store := openTestJournaledStore(t, path)
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(created)
+	store.clock = clk
+	user1, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	user2, _ := store.Create(User{Name: "User 2", Email: "user2@example.com"})
+	clk.Advance(time.Hour)
+	_, err := store.Update(user1.ID, User{Name: "Updated User 1", Email: "updated1@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(user2.ID))
+	require.NoError(t, store.Close())
+
+	reopened := openTestJournaledStore(t, path)
+	defer reopened.Close()
+
+	users, err := reopened.GetAll()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, User{
+		ID:        user1.ID,
+		Name:      "Updated User 1",
+		Email:     "updated1@example.com",
+		CreatedAt: created,
+		UpdatedAt: created.Add(time.Hour),
+		Version:   2,
+	}, users[0])
+
+	// IDs keep increasing across restarts, even past deleted records
+	user3, err := reopened.Create(User{Name: "User 3", Email: "user3@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), user3.ID)
+
+	report, err := reopened.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+}
+
+func TestJournaledMemoryUserStore_ReplaysBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.journal")
+
+	store := openTestJournaledStore(t, path)
+	created, err := store.CreateMany([]User{
+		{Name: "User 1", Email: "user1@example.com"},
+		{Name: "User 2", Email: "user2@example.com"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	reopened := openTestJournaledStore(t, path)
+	defer reopened.Close()
+
+	users, err := reopened.GetAll()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, created, users)
+	next, err := reopened.Create(User{Name: "User 3", Email: "user3@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), next.ID)
+}
+
+func TestJournaledMemoryUserStore_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.journal")
+
+	store := openTestJournaledStore(t, path)
+	for i := 0; i < 5; i++ {
+		_, _ = store.Create(User{Name: "User", Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	require.NoError(t, store.Delete(5))
+	require.NoError(t, store.Compact())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "journal should be truncated after compaction")
+
+	// Mutations after compaction are journaled on top of the snapshot
+	_, err = store.Update(1, User{Name: "After Compaction", Email: "after@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	reopened := openTestJournaledStore(t, path)
+	defer reopened.Close()
+
+	users, err := reopened.GetAll()
+	require.NoError(t, err)
+	assert.Len(t, users, 4)
+
+	user, err := reopened.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "After Compaction", user.Name)
+
+	created, err := reopened.Create(User{Name: "User 6", Email: "user6@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), created.ID)
+}
+
+func TestJournaledMemoryUserStore_DropsTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.journal")
+
+	store := openTestJournaledStore(t, path)
+	_, _ = store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	require.NoError(t, store.Close())
+
+	// Simulate a crash part way through writing a record
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"op":"create","id":2,"user":{"id":2,"na`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened := openTestJournaledStore(t, path)
+	users, _ := reopened.GetAll()
+	assert.Len(t, users, 1)
+
+	_, err = reopened.Create(User{Name: "User 2", Email: "user2@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, reopened.Close())
+
+	// The torn record must not corrupt records appended after it
+	again := openTestJournaledStore(t, path)
+	defer again.Close()
+	users, _ = again.GetAll()
+	assert.Len(t, users, 2)
+}
+
+func TestJournaledMemoryUserStore_ReplaysInterruptedCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.journal")
+
+	store := openTestJournaledStore(t, path)
+	_, _ = store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	_, _ = store.Create(User{Name: "User 2", Email: "user2@example.com"})
+
+	// Rotate without writing the snapshot, as if the process died mid-compaction
+	store.mutex.Lock()
+	require.NoError(t, store.journal.rotate())
+	store.mutex.Unlock()
+
+	_, _ = store.Create(User{Name: "User 3", Email: "user3@example.com"})
+
+	// A second rotation must keep the records of the pending one
+	store.mutex.Lock()
+	require.NoError(t, store.journal.rotate())
+	store.mutex.Unlock()
+
+	require.NoError(t, store.Delete(1))
+	require.NoError(t, store.Close())
+
+	reopened := openTestJournaledStore(t, path)
+	users, _ := reopened.GetAll()
+	assert.Len(t, users, 2)
+
+	require.NoError(t, reopened.Compact())
+	_, err := os.Stat(path + ".compacting")
+	assert.True(t, os.IsNotExist(err), "rotated journal should be removed after compaction")
+	require.NoError(t, reopened.Close())
+
+	again := openTestJournaledStore(t, path)
+	defer again.Close()
+	users, _ = again.GetAll()
+	assert.Len(t, users, 2)
+}
+
+func TestNewJournaledMemoryUserStore_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name  string
+		setup func(path string)
+		opts  func(path string) JournalOptions
+	}{
+		{
+			name:  "unknown fsync policy",
+			setup: func(path string) {},
+			opts: func(path string) JournalOptions {
+				return JournalOptions{Path: path, Fsync: "sometimes"}
+			},
+		},
+		{
+			name: "corrupt record in the middle of the journal",
+			setup: func(path string) {
+				_ = os.WriteFile(path, []byte("not json\n{\"op\":\"delete\",\"id\":1}\n"), 0o644)
+			},
+			opts: func(path string) JournalOptions {
+				return JournalOptions{Path: path}
+			},
+		},
+		{
+			name: "corrupt snapshot",
+			setup: func(path string) {
+				_ = os.WriteFile(path+".snapshot", []byte("{"), 0o644)
+			},
+			opts: func(path string) JournalOptions {
+				return JournalOptions{Path: path}
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "journal", string(rune('a'+i)))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			tt.setup(path)
+
+			store, err := NewJournaledMemoryUserStore(tt.opts(path))
+			assert.Error(t, err)
+			assert.Nil(t, store)
+		})
+	}
+}
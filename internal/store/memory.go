@@ -2,24 +2,310 @@ package store
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/logging"
 )
 
+// logger logs writes to the store and upkeep of its journal
+var logger = logging.Named(logging.Store)
+
 // MemoryUserStore is an in-memory implementation of UserStore
 type MemoryUserStore struct {
-	users  map[int]User
-	nextID int
-	mutex  sync.RWMutex
+	users     map[int64]User
+	checksums map[int64]uint32
+	nextID    int64
+	mutex     sync.RWMutex
+
+	// indexes and timeIndexes are secondary indexes kept consistent with
+	// users under mutex
+	indexes     map[string]*index
+	timeIndexes map[string]*timeIndex
+
+	// clock stamps CreatedAt and UpdatedAt
+	clock clock.Clock
+	// ids numbers new users when set, instead of nextID
+	ids idgen.IntGenerator
+
+	// revision is incremented on every write
+	revision atomic.Uint64
+
+	// shared is set while a snapshot references the current maps; the next
+	// write copies them first so the snapshot stays unchanged
+	shared bool
+
+	// journal is nil unless the store was opened with journaling enabled
+	journal *journal
+	stop    chan struct{}
+	done    sync.WaitGroup
 }
 
 // NewMemoryUserStore creates a new in-memory user store
 func NewMemoryUserStore() *MemoryUserStore {
 	return &MemoryUserStore{
-		users:  make(map[int]User),
-		nextID: 1,
+		users:     make(map[int64]User),
+		checksums: make(map[int64]uint32),
+		nextID:    1,
+		indexes: map[string]*index{
+			IndexEmail: newIndex(IndexEmail, emailKeys),
+		},
+		timeIndexes: map[string]*timeIndex{
+			IndexCreatedAt: newTimeIndex(IndexCreatedAt, createdAt),
+			IndexUpdatedAt: newTimeIndex(IndexUpdatedAt, updatedAt),
+		},
+		clock: clock.Real(),
+	}
+}
+
+// SetIDs numbers new users with ids rather than counting up from the
+// highest ID. Call it before the store is used.
+func (m *MemoryUserStore) SetIDs(ids idgen.IntGenerator) {
+	m.ids = ids
+}
+
+// newID returns the ID of the next user created. The caller holds the
+// write lock.
+func (m *MemoryUserStore) newID() int64 {
+	if m.ids != nil {
+		return m.ids.NewInt()
+	}
+	return m.nextID
+}
+
+// advance moves nextID past id, so counting never reuses it. The caller
+// holds the write lock.
+func (m *MemoryUserStore) advance(id int64) {
+	if id >= m.nextID {
+		m.nextID = id + 1
+	}
+}
+
+// NewJournaledMemoryUserStore creates an in-memory user store that records
+// every mutation in an append-only journal. Existing state is replayed from
+// the snapshot and journal before the store is returned.
+func NewJournaledMemoryUserStore(opts JournalOptions) (*MemoryUserStore, error) {
+	j, err := openJournal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	m := NewMemoryUserStore()
+	if err := j.replay(m.restore, m.apply); err != nil {
+		_ = j.close()
+		return nil, fmt.Errorf("failed to replay journal: %w", err)
+	}
+
+	m.journal = j
+	m.stop = make(chan struct{})
+	m.done.Add(1)
+	go m.maintain()
+	return m, nil
+}
+
+// restore replaces the store contents with a compacted snapshot
+func (m *MemoryUserStore) restore(snapshot journalSnapshot) {
+	m.users = make(map[int64]User, len(snapshot.Users))
+	m.checksums = make(map[int64]uint32, len(snapshot.Users))
+	for _, ix := range m.indexes {
+		ix.rebuild(nil)
+	}
+	for _, ix := range m.timeIndexes {
+		ix.rebuild(nil)
+	}
+	for _, user := range snapshot.Users {
+		m.put(user)
+	}
+	m.nextID = snapshot.NextID
+}
+
+// apply replays a single journal record
+func (m *MemoryUserStore) apply(record journalRecord) {
+	switch record.Op {
+	case journalOpCreate, journalOpUpdate:
+		if record.User == nil {
+			return
+		}
+		m.put(*record.User)
+		m.advance(record.ID)
+	case journalOpCreateMany:
+		for _, user := range record.Users {
+			m.put(user)
+			m.advance(user.ID)
+		}
+	case journalOpDelete:
+		m.remove(record.ID)
 	}
 }
 
+// put stores a user, keeping checksums and indexes up to date. The caller
+// must hold the write lock and have called writable.
+func (m *MemoryUserStore) put(user User) {
+	if previous, exists := m.users[user.ID]; exists {
+		for _, ix := range m.indexes {
+			ix.remove(previous)
+		}
+		for _, ix := range m.timeIndexes {
+			ix.remove(previous)
+		}
+	}
+
+	m.users[user.ID] = user
+	m.checksums[user.ID] = checksum(user)
+	for _, ix := range m.indexes {
+		ix.add(user)
+	}
+	for _, ix := range m.timeIndexes {
+		ix.add(user)
+	}
+	m.revision.Add(1)
+}
+
+// remove deletes a user, keeping checksums and indexes up to date. The caller
+// must hold the write lock and have called writable.
+func (m *MemoryUserStore) remove(id int64) {
+	if previous, exists := m.users[id]; exists {
+		for _, ix := range m.indexes {
+			ix.remove(previous)
+		}
+		for _, ix := range m.timeIndexes {
+			ix.remove(previous)
+		}
+	}
+
+	delete(m.users, id)
+	delete(m.checksums, id)
+	m.revision.Add(1)
+}
+
+// record writes a mutation to the journal ahead of applying it
+func (m *MemoryUserStore) record(op string, id int64, user *User) error {
+	logger.Debug("Writing user", "op", op, "user_id", id, "journaled", m.journal != nil)
+	if m.journal == nil {
+		return nil
+	}
+	return m.journal.append(journalRecord{Op: op, ID: id, User: user})
+}
+
+// maintain periodically syncs and compacts the journal until Close is called
+func (m *MemoryUserStore) maintain() {
+	defer m.done.Done()
+
+	syncTicker := time.NewTicker(m.journal.opts.FsyncInterval)
+	defer syncTicker.Stop()
+
+	var compactC <-chan time.Time
+	if m.journal.opts.CompactInterval > 0 {
+		compactTicker := time.NewTicker(m.journal.opts.CompactInterval)
+		defer compactTicker.Stop()
+		compactC = compactTicker.C
+	}
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-syncTicker.C:
+			if m.journal.opts.Fsync != FsyncInterval {
+				continue
+			}
+			if err := m.journal.sync(); err != nil {
+				logger.Error("Failed to sync journal", "error", err)
+			}
+		case <-compactC:
+			if err := m.Compact(); err != nil {
+				logger.Error("Failed to compact journal", "error", err)
+			}
+		}
+	}
+}
+
+// Compact folds the journal into a snapshot and truncates it. It is a no-op
+// for stores without a journal.
+func (m *MemoryUserStore) Compact() error {
+	if m.journal == nil {
+		return nil
+	}
+
+	m.journal.compacting.Lock()
+	defer m.journal.compacting.Unlock()
+
+	// Rotate the journal and take a snapshot under the lock, then write the
+	// snapshot without blocking writers
+	m.mutex.Lock()
+	snapshot := m.snapshot()
+	err := m.journal.rotate()
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := m.journal.compact(journalSnapshotOf(snapshot)); err != nil {
+		return err
+	}
+	logger.Debug("Compacted journal", "users", len(snapshot.users))
+	return nil
+}
+
+// Snapshot returns a read-only, point-in-time view of the store. Taking a
+// snapshot is O(1); the store copies its data on the next write instead.
+func (m *MemoryUserStore) Snapshot() (UserSnapshot, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.snapshot(), nil
+}
+
+// snapshot marks the current maps as shared and wraps them in a view. The
+// caller must hold the write lock.
+func (m *MemoryUserStore) snapshot() *MemorySnapshot {
+	m.shared = true
+	return &MemorySnapshot{users: m.users, nextID: m.nextID}
+}
+
+// writable copies the maps if a snapshot still references them. The caller
+// must hold the write lock.
+func (m *MemoryUserStore) writable() {
+	if !m.shared {
+		return
+	}
+
+	users := make(map[int64]User, len(m.users))
+	for id, user := range m.users {
+		users[id] = user
+	}
+	checksums := make(map[int64]uint32, len(m.checksums))
+	for id, sum := range m.checksums {
+		checksums[id] = sum
+	}
+
+	m.users = users
+	m.checksums = checksums
+	m.shared = false
+}
+
+// journalSnapshotOf converts a snapshot into its persisted form
+func journalSnapshotOf(snapshot *MemorySnapshot) journalSnapshot {
+	users, _ := snapshot.GetAll()
+	return journalSnapshot{NextID: snapshot.nextID, Users: users}
+}
+
+// Close stops background journal maintenance and closes the journal file
+func (m *MemoryUserStore) Close() error {
+	if m.journal == nil {
+		return nil
+	}
+
+	close(m.stop)
+	m.done.Wait()
+	return m.journal.close()
+}
+
 // GetAll returns all users
 func (m *MemoryUserStore) GetAll() ([]User, error) {
 	m.mutex.RLock()
@@ -32,8 +318,21 @@ func (m *MemoryUserStore) GetAll() ([]User, error) {
 	return users, nil
 }
 
+// Revision returns a counter that changes whenever the store is written to
+func (m *MemoryUserStore) Revision() uint64 {
+	return m.revision.Load()
+}
+
+// Count returns the number of users
+func (m *MemoryUserStore) Count() (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return len(m.users), nil
+}
+
 // GetByID returns a user by ID
-func (m *MemoryUserStore) GetByID(id int) (*User, error) {
+func (m *MemoryUserStore) GetByID(id int64) (*User, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
@@ -44,40 +343,429 @@ func (m *MemoryUserStore) GetByID(id int) (*User, error) {
 	return &user, nil
 }
 
-// Create adds a new user and returns the created user with assigned ID
+// GetByIDs returns the users with ids ordered by ID, under one lock
+func (m *MemoryUserStore) GetByIDs(ids []int64) ([]User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	users := make([]User, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		if user, exists := m.users[id]; exists {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// GetByEmail returns the user with the given email address, compared
+// case-insensitively. If several users share the address, the one with the
+// lowest ID is returned.
+func (m *MemoryUserStore) GetByEmail(email string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ids := m.indexes[IndexEmail].lookup(emailKey(email))
+	if len(ids) == 0 {
+		return nil, errors.New("user not found")
+	}
+
+	user := m.users[ids[0]]
+	return &user, nil
+}
+
+// Find returns the users passing filter in its sort order, or otherwise
+// in time order, scanning the created_at or updated_at index rather than
+// every user
+func (m *MemoryUserStore) Find(filter UserFilter) ([]User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var ids []int64
+	switch {
+	case !filter.CreatedAfter.IsZero() || !filter.CreatedBefore.IsZero():
+		ids = m.timeIndexes[IndexCreatedAt].between(filter.CreatedAfter, filter.CreatedBefore)
+	case !filter.UpdatedAfter.IsZero():
+		ids = m.timeIndexes[IndexUpdatedAt].between(filter.UpdatedAfter, time.Time{})
+	default:
+		ids = m.timeIndexes[IndexCreatedAt].between(time.Time{}, time.Time{})
+	}
+
+	users := make([]User, 0, len(ids))
+	for _, id := range ids {
+		if user := m.users[id]; filter.Matches(user) {
+			users = append(users, user)
+		}
+	}
+	filter.SortUsers(users)
+	return users, nil
+}
+
+// emailTaken reports whether a user other than id has address, looked up
+// in the email index. Empty addresses are never taken. The caller must
+// hold the lock.
+func (m *MemoryUserStore) emailTaken(address string, id int64) bool {
+	key := emailKey(address)
+	if key == "" {
+		return false
+	}
+	for _, other := range m.indexes[IndexEmail].lookup(key) {
+		if other != id {
+			return true
+		}
+	}
+	return false
+}
+
+// Create adds a new user and returns the created user with assigned ID,
+// or ErrDuplicateEmail when another user has its email
 func (m *MemoryUserStore) Create(user User) (*User, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	user.ID = m.nextID
-	m.nextID++
-	m.users[user.ID] = user
+	if m.emailTaken(user.Email, 0) {
+		return nil, ErrDuplicateEmail
+	}
+	user.ID = m.newID()
+	user.CreatedAt = m.clock.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	user.Version = 1
+	if err := m.record(journalOpCreate, user.ID, &user); err != nil {
+		return nil, err
+	}
+
+	m.writable()
+	m.advance(user.ID)
+	m.put(user)
 	return &user, nil
 }
 
-// Update modifies an existing user
-func (m *MemoryUserStore) Update(id int, user User) (*User, error) {
+// CreateMany adds users with IDs in the order given, consecutive unless
+// numbered by SetIDs, journaled as a single record
+// so the batch is replayed whole or not at all. No user is created when
+// any has an email that is taken or repeated in the batch.
+func (m *MemoryUserStore) CreateMany(users []User) ([]User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Emails must be free, and not repeated within the batch either
+	emails := make(map[string]bool, len(users))
+	for _, user := range users {
+		key := emailKey(user.Email)
+		if key != "" && (emails[key] || m.emailTaken(user.Email, 0)) {
+			return nil, ErrDuplicateEmail
+		}
+		emails[key] = true
+	}
+
+	now := m.clock.Now().UTC()
+	created := make([]User, len(users))
+	for i, user := range users {
+		user.ID = m.nextID + int64(i)
+		if m.ids != nil {
+			user.ID = m.ids.NewInt()
+		}
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		user.Version = 1
+		created[i] = user
+	}
+	logger.Debug("Writing users", "op", journalOpCreateMany, "users", len(created), "journaled", m.journal != nil)
+	if m.journal != nil {
+		if err := m.journal.append(journalRecord{Op: journalOpCreateMany, Users: created}); err != nil {
+			return nil, err
+		}
+	}
+
+	m.writable()
+	for _, user := range created {
+		m.advance(user.ID)
+		m.put(user)
+	}
+	return created, nil
+}
+
+// Update modifies an existing user, keeping its LastSeenAt and CreatedAt.
+// It returns ErrDuplicateEmail when another user has the new email.
+func (m *MemoryUserStore) Update(id int64, user User) (*User, error) {
+	return m.update(id, nil, user)
+}
+
+// UpdateVersion is Update, returning ErrVersionConflict unless the user is
+// at version
+func (m *MemoryUserStore) UpdateVersion(id, version int64, user User) (*User, error) {
+	return m.update(id, &version, user)
+}
+
+// update updates the user id, unless version is set and the user is at
+// another
+func (m *MemoryUserStore) update(id int64, version *int64, user User) (*User, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if _, exists := m.users[id]; !exists {
+	existing, exists := m.users[id]
+	if !exists {
 		return nil, errors.New("user not found")
 	}
+	if version != nil && *version != existing.Version {
+		return nil, ErrVersionConflict
+	}
+	if m.emailTaken(user.Email, id) {
+		return nil, ErrDuplicateEmail
+	}
 
 	user.ID = id // Ensure ID matches the parameter
-	m.users[id] = user
+	user.LastSeenAt = existing.LastSeenAt
+	user.CreatedAt = existing.CreatedAt
+	user.UpdatedAt = m.clock.Now().UTC()
+	user.Version = existing.Version + 1
+	if err := m.record(journalOpUpdate, id, &user); err != nil {
+		return nil, err
+	}
+
+	m.writable()
+	m.put(user)
 	return &user, nil
 }
 
+// RecordActivity moves each user's LastSeenAt forward to the given time,
+// skipping users that no longer exist
+func (m *MemoryUserStore) RecordActivity(seen map[int64]time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.writable()
+	for id, at := range seen {
+		user, exists := m.users[id]
+		if !exists || (user.LastSeenAt != nil && !at.After(*user.LastSeenAt)) {
+			continue
+		}
+
+		at := at.UTC()
+		user.LastSeenAt = &at
+		if err := m.record(journalOpUpdate, id, &user); err != nil {
+			return err
+		}
+		m.put(user)
+	}
+	return nil
+}
+
 // Delete removes a user by ID
-func (m *MemoryUserStore) Delete(id int) error {
+func (m *MemoryUserStore) Delete(id int64) error {
+	return m.delete(id, nil)
+}
+
+// DeleteVersion is Delete, returning ErrVersionConflict unless the user is
+// at version
+func (m *MemoryUserStore) DeleteVersion(id, version int64) error {
+	return m.delete(id, &version)
+}
+
+// delete deletes the user id, unless version is set and the user is at
+// another
+func (m *MemoryUserStore) delete(id int64, version *int64) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if _, exists := m.users[id]; !exists {
+	existing, exists := m.users[id]
+	if !exists {
 		return errors.New("user not found")
 	}
+	if version != nil && *version != existing.Version {
+		return ErrVersionConflict
+	}
 
-	delete(m.users, id)
+	if err := m.record(journalOpDelete, id, nil); err != nil {
+		return err
+	}
+
+	m.writable()
+	m.remove(id)
 	return nil
 }
+
+// Restore puts a deleted user back under its original ID, unless another
+// user has taken its email since
+func (m *MemoryUserStore) Restore(user User) (*User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.users[user.ID]; exists {
+		return nil, fmt.Errorf("user %d already exists", user.ID)
+	}
+	if m.emailTaken(user.Email, user.ID) {
+		return nil, ErrDuplicateEmail
+	}
+	if err := m.record(journalOpCreate, user.ID, &user); err != nil {
+		return nil, err
+	}
+
+	m.writable()
+	m.advance(user.ID)
+	m.put(user)
+	return &user, nil
+}
+
+// Replace stores a user exactly as given, creating it or overwriting the
+// user with its ID. Emails are not checked, so replicas and backups hold
+// whatever their source held.
+func (m *MemoryUserStore) Replace(user User) (*User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	op := journalOpCreate
+	if _, exists := m.users[user.ID]; exists {
+		op = journalOpUpdate
+	}
+	if err := m.record(op, user.ID, &user); err != nil {
+		return nil, err
+	}
+
+	m.writable()
+	m.advance(user.ID)
+	m.put(user)
+	return &user, nil
+}
+
+// Verify recomputes per-record checksums and checks the store's internal
+// bookkeeping. When repair is true, inconsistencies are fixed in place and
+// flagged as repaired in the report.
+func (m *MemoryUserStore) Verify(repair bool) (*IntegrityReport, error) {
+	if repair {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.writable()
+	} else {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+	}
+
+	report := &IntegrityReport{Records: len(m.users), Issues: []IntegrityIssue{}}
+	computed := make(map[int64]uint32, len(m.users))
+	var maxID int64
+
+	for id, user := range m.users {
+		if user.ID != id {
+			report.Issues = append(report.Issues, IntegrityIssue{
+				UserID:   id,
+				Kind:     IssueIDMismatch,
+				Detail:   fmt.Sprintf("record stored under key %d has ID %d", id, user.ID),
+				Repaired: repair,
+			})
+			if repair {
+				user.ID = id
+				m.users[id] = user
+			}
+		}
+
+		sum := checksum(user)
+		computed[id] = sum
+		if id > maxID {
+			maxID = id
+		}
+
+		stored, exists := m.checksums[id]
+		switch {
+		case !exists:
+			report.Issues = append(report.Issues, IntegrityIssue{
+				UserID:   id,
+				Kind:     IssueMissingChecksum,
+				Detail:   "record has no stored checksum",
+				Repaired: repair,
+			})
+		case stored != sum:
+			report.Issues = append(report.Issues, IntegrityIssue{
+				UserID:   id,
+				Kind:     IssueChecksumMismatch,
+				Detail:   fmt.Sprintf("stored checksum %08x does not match computed %08x", stored, sum),
+				Repaired: repair,
+			})
+		}
+	}
+
+	for id := range m.checksums {
+		if _, exists := m.users[id]; !exists {
+			report.Issues = append(report.Issues, IntegrityIssue{
+				UserID:   id,
+				Kind:     IssueOrphanChecksum,
+				Detail:   "checksum stored for a record that does not exist",
+				Repaired: repair,
+			})
+		}
+	}
+
+	for _, name := range m.indexNames() {
+		var keys []string
+		if ix, ok := m.indexes[name]; ok {
+			keys = ix.diff(m.users)
+		} else {
+			keys = m.timeIndexes[name].diff(m.users)
+		}
+		for _, key := range keys {
+			report.Issues = append(report.Issues, IntegrityIssue{
+				Kind:     IssueIndexMismatch,
+				Detail:   fmt.Sprintf("%s index entry %q does not match the stored records", name, key),
+				Repaired: repair,
+			})
+		}
+	}
+
+	if m.nextID <= maxID {
+		report.Issues = append(report.Issues, IntegrityIssue{
+			UserID:   maxID,
+			Kind:     IssueSequenceBehind,
+			Detail:   fmt.Sprintf("next ID %d would collide with existing ID %d", m.nextID, maxID),
+			Repaired: repair,
+		})
+		if repair {
+			m.nextID = maxID + 1
+		}
+	}
+
+	if repair {
+		m.checksums = computed
+		for _, ix := range m.indexes {
+			ix.rebuild(m.users)
+		}
+		for _, ix := range m.timeIndexes {
+			ix.rebuild(m.users)
+		}
+		m.revision.Add(1)
+		// Journal repaired records so the fixes survive a restart; checksums
+		// and the ID sequence are rebuilt on replay
+		for _, issue := range report.Issues {
+			user, exists := m.users[issue.UserID]
+			if !exists || issue.Kind == IssueSequenceBehind {
+				continue
+			}
+			if err := m.record(journalOpUpdate, user.ID, &user); err != nil {
+				return nil, fmt.Errorf("failed to persist repairs: %w", err)
+			}
+		}
+	}
+
+	sort.Slice(report.Issues, func(i, j int) bool {
+		if report.Issues[i].UserID != report.Issues[j].UserID {
+			return report.Issues[i].UserID < report.Issues[j].UserID
+		}
+		if report.Issues[i].Kind != report.Issues[j].Kind {
+			return report.Issues[i].Kind < report.Issues[j].Kind
+		}
+		return report.Issues[i].Detail < report.Issues[j].Detail
+	})
+	report.Checksum = combinedChecksum(computed)
+	return report, nil
+}
+
+// indexNames returns the names of the store's indexes in a stable order
+func (m *MemoryUserStore) indexNames() []string {
+	names := make([]string, 0, len(m.indexes)+len(m.timeIndexes))
+	for name := range m.indexes {
+		names = append(names, name)
+	}
+	for name := range m.timeIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
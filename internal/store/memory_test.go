@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/pkg/fixtures"
 )
 
 func TestNewMemoryUserStore(t *testing.T) {
@@ -15,7 +19,7 @@ func TestNewMemoryUserStore(t *testing.T) {
 
 	assert.NotNil(t, store)
 	assert.NotNil(t, store.users)
-	assert.Equal(t, 1, store.nextID)
+	assert.Equal(t, int64(1), store.nextID)
 	assert.Equal(t, 0, len(store.users))
 }
 
@@ -23,7 +27,7 @@ func TestMemoryUserStore_Create(t *testing.T) {
 	tests := []struct {
 		name        string
 		user        User
-		expectedID  int
+		expectedID  int64
 		expectError bool
 	}{
 		{
@@ -81,7 +85,7 @@ func TestMemoryUserStore_GetByID(t *testing.T) {
 
 	tests := []struct {
 		name        string
-		id          int
+		id          int64
 		expected    *User
 		expectError bool
 		errorMsg    string
@@ -208,13 +212,32 @@ func TestMemoryUserStore_GetAll(t *testing.T) {
 	}
 }
 
+func TestMemoryUserStore_Count(t *testing.T) {
+	store := NewMemoryUserStore()
+
+	count, err := store.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	user, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	_, _ = store.Create(User{Name: "User 2", Email: "user2@example.com"})
+	count, err = store.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	require.NoError(t, store.Delete(user.ID))
+	count, err = store.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
 func TestMemoryUserStore_Update(t *testing.T) {
 	store := NewMemoryUserStore()
 	existingUser, _ := store.Create(User{Name: "Original User", Email: "original@example.com"})
 
 	tests := []struct {
 		name        string
-		id          int
+		id          int64
 		updateUser  User
 		expectError bool
 		errorMsg    string
@@ -264,6 +287,28 @@ func TestMemoryUserStore_Update(t *testing.T) {
 	}
 }
 
+func TestMemoryUserStore_RecordActivity(t *testing.T) {
+	store := NewMemoryUserStore()
+	user, _ := store.Create(User{Name: "John Doe", Email: "john@example.com"})
+	seen := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	require.NoError(t, store.RecordActivity(map[int64]time.Time{user.ID: seen, 999: seen}))
+	retrieved, _ := store.GetByID(user.ID)
+	require.NotNil(t, retrieved.LastSeenAt)
+	assert.Equal(t, seen, *retrieved.LastSeenAt)
+
+	// Activity only moves forward
+	require.NoError(t, store.RecordActivity(map[int64]time.Time{user.ID: seen.Add(-time.Hour)}))
+	retrieved, _ = store.GetByID(user.ID)
+	assert.Equal(t, seen, *retrieved.LastSeenAt)
+
+	// Updates keep the activity, which clients cannot set
+	updated, err := store.Update(user.ID, User{Name: "Johnny", Email: "john@example.com"})
+	require.NoError(t, err)
+	require.NotNil(t, updated.LastSeenAt)
+	assert.Equal(t, seen, *updated.LastSeenAt)
+}
+
 func TestMemoryUserStore_Delete(t *testing.T) {
 	store := NewMemoryUserStore()
 	user1, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
@@ -271,7 +316,7 @@ func TestMemoryUserStore_Delete(t *testing.T) {
 
 	tests := []struct {
 		name        string
-		id          int
+		id          int64
 		expectError bool
 		errorMsg    string
 	}{
@@ -312,6 +357,203 @@ func TestMemoryUserStore_Delete(t *testing.T) {
 	assert.Equal(t, user2.ID, retrieved.ID)
 }
 
+func TestMemoryUserStore_Restore(t *testing.T) {
+	store := NewMemoryUserStore()
+	user, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	require.NoError(t, store.Delete(user.ID))
+
+	restored, err := store.Restore(*user)
+	require.NoError(t, err)
+	assert.Equal(t, user, restored)
+
+	retrieved, err := store.GetByEmail("user1@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, retrieved.ID)
+
+	_, err = store.Restore(*user)
+	assert.Error(t, err, "a user that exists cannot be restored")
+
+	// Restoring does not make the ID available again
+	created, _ := store.Create(User{Name: "User 2", Email: "user2@example.com"})
+	assert.Equal(t, user.ID+1, created.ID)
+
+	report, err := store.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+}
+
+// countingIDs numbers users from a base, standing in for Snowflake IDs
+type countingIDs struct {
+	next int64
+}
+
+func (c *countingIDs) NewInt() int64 {
+	c.next++
+	return c.next
+}
+
+func TestMemoryUserStore_SetIDs(t *testing.T) {
+	store := NewMemoryUserStore()
+	store.SetIDs(&countingIDs{next: 1000})
+
+	user, err := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1001), user.ID)
+	created, err := store.CreateMany([]User{{Name: "User 2", Email: "user2@example.com"}, {Name: "User 3", Email: "user3@example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1002), created[0].ID)
+	assert.Equal(t, int64(1003), created[1].ID)
+
+	// Counting carries on past the IDs given
+	store.SetIDs(nil)
+	user, err = store.Create(User{Name: "User 4", Email: "user4@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1004), user.ID)
+
+	report, err := store.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+}
+
+func TestMemoryUserStore_Replace(t *testing.T) {
+	store := NewMemoryUserStore()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := User{ID: 7, Name: "User 7", Email: "user7@example.com", CreatedAt: at, UpdatedAt: at}
+
+	replaced, err := store.Replace(user)
+	require.NoError(t, err)
+	assert.Equal(t, user, *replaced, "a missing user is created exactly as given")
+
+	user.Email = "renamed7@example.com"
+	user.UpdatedAt = at.Add(time.Hour)
+	_, err = store.Replace(user)
+	require.NoError(t, err)
+	retrieved, err := store.GetByEmail("renamed7@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, user, *retrieved, "an existing user is overwritten, timestamps included")
+	_, err = store.GetByEmail("user7@example.com")
+	assert.Error(t, err)
+
+	// Later users are created after the replaced one
+	created, _ := store.Create(User{Name: "User 8", Email: "user8@example.com"})
+	assert.Equal(t, int64(8), created.ID)
+
+	report, err := store.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+}
+
+func TestMemoryUserStore_UniqueEmails(t *testing.T) {
+	store := NewMemoryUserStore()
+	john, err := store.Create(User{Name: "John Doe", Email: "john@bücher.de"})
+	require.NoError(t, err)
+	jane, err := store.Create(User{Name: "Jane Doe", Email: "jane@example.com"})
+	require.NoError(t, err)
+
+	// Emails are compared as they are looked up
+	for _, email := range []string{"john@bücher.de", "JOHN@Bücher.DE", "john@xn--bcher-kva.de"} {
+		_, err = store.Create(User{Name: "Copy", Email: email})
+		assert.ErrorIs(t, err, ErrDuplicateEmail, email)
+	}
+	_, err = store.Update(jane.ID, User{Name: "Jane Doe", Email: "John@bücher.de"})
+	assert.ErrorIs(t, err, ErrDuplicateEmail)
+	_, err = store.CreateMany([]User{{Name: "New", Email: "new@example.com"}, {Name: "Copy", Email: "jane@example.com"}})
+	assert.ErrorIs(t, err, ErrDuplicateEmail)
+	_, err = store.CreateMany([]User{{Name: "New", Email: "new@example.com"}, {Name: "Again", Email: "NEW@example.com"}})
+	assert.ErrorIs(t, err, ErrDuplicateEmail)
+	count, err := store.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "nothing is written when an email is taken")
+
+	// Users keep their own email, and freed emails can be taken
+	_, err = store.Update(john.ID, User{Name: "John Smith", Email: "John@bücher.de"})
+	require.NoError(t, err)
+	_, err = store.Update(jane.ID, User{Name: "Jane Doe", Email: "jane.doe@example.com"})
+	require.NoError(t, err)
+	_, err = store.Create(User{Name: "Other Jane", Email: "jane@example.com"})
+	require.NoError(t, err)
+
+	// Deleted users cannot be restored once their email is taken
+	require.NoError(t, store.Delete(jane.ID))
+	_, err = store.Create(User{Name: "Newer Jane", Email: "jane.doe@example.com"})
+	require.NoError(t, err)
+	deleted := *jane
+	deleted.Email = "jane.doe@example.com"
+	_, err = store.Restore(deleted)
+	assert.ErrorIs(t, err, ErrDuplicateEmail)
+
+	// Users without an email do not clash
+	_, err = store.CreateMany([]User{{Name: "No email"}, {Name: "No email either"}})
+	require.NoError(t, err)
+}
+
+func TestMemoryUserStore_Find(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	store := NewMemoryUserStore()
+	store.clock = clk
+
+	// One user created per day; the first is updated on the last day
+	for i := 1; i <= 5; i++ {
+		_, err := store.Create(User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+		require.NoError(t, err)
+		clk.Advance(24 * time.Hour)
+	}
+	_, err := store.Update(1, User{Name: "User 1", Email: "renamed1@example.com"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		filter   UserFilter
+		expected []int64
+	}{
+		{name: "no filter", filter: UserFilter{}, expected: []int64{1, 2, 3, 4, 5}},
+		{name: "created after, exclusive", filter: UserFilter{CreatedAfter: start.AddDate(0, 0, 2)}, expected: []int64{4, 5}},
+		{name: "created before, exclusive", filter: UserFilter{CreatedBefore: start.AddDate(0, 0, 2)}, expected: []int64{1, 2}},
+		{name: "created between", filter: UserFilter{CreatedAfter: start, CreatedBefore: start.AddDate(0, 0, 4)}, expected: []int64{2, 3, 4}},
+		{name: "updated after", filter: UserFilter{UpdatedAfter: start.AddDate(0, 0, 3)}, expected: []int64{5, 1}},
+		{
+			name:     "created and updated",
+			filter:   UserFilter{CreatedBefore: start.AddDate(0, 0, 1), UpdatedAfter: start.AddDate(0, 0, 3)},
+			expected: []int64{1},
+		},
+		{name: "empty range", filter: UserFilter{CreatedAfter: start.AddDate(0, 0, 3), CreatedBefore: start.AddDate(0, 0, 1)}, expected: []int64{}},
+		{name: "name", filter: UserFilter{Name: "user 3"}, expected: []int64{3}},
+		{name: "email", filter: UserFilter{Email: "RENAMED"}, expected: []int64{1}},
+		{name: "query", filter: UserFilter{Query: "user5@"}, expected: []int64{5}},
+		{name: "query matches either field", filter: UserFilter{Query: "user 1"}, expected: []int64{1}},
+		{name: "text and time", filter: UserFilter{CreatedAfter: start.AddDate(0, 0, 2), Email: "@example.com"}, expected: []int64{4, 5}},
+		{name: "where", filter: UserFilter{Where: Any{Comparison{Field: SortID, Op: OpIn, Values: []any{int64(2), int64(4)}}, Comparison{Field: SortEmail, Op: OpLike, Values: []any{"renamed*"}}}}, expected: []int64{1, 2, 4}},
+		{name: "where and time", filter: UserFilter{CreatedAfter: start.AddDate(0, 0, 2), Where: Comparison{Field: SortName, Op: OpNotEqual, Values: []any{"USER 4"}}}, expected: []int64{5}},
+		{name: "sorted", filter: UserFilter{Sort: UserSort{{Field: SortEmail}}}, expected: []int64{1, 2, 3, 4, 5}},
+		{name: "sorted descending", filter: UserFilter{Sort: UserSort{{Field: SortUpdatedAt, Descending: true}}}, expected: []int64{1, 5, 4, 3, 2}},
+		{name: "sorted and filtered", filter: UserFilter{CreatedAfter: start, Sort: UserSort{{Field: SortName, Descending: true}}}, expected: []int64{5, 4, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := store.Find(tt.filter)
+			require.NoError(t, err)
+			ids := make([]int64, 0, len(users))
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	// The generic path gives the same results for stores without an index
+	users, err := FindUsers(struct{ UserStore }{store}, UserFilter{CreatedAfter: start.AddDate(0, 0, 2)})
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	// Updates keep the creation time
+	user, err := store.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, start, user.CreatedAt)
+	assert.Equal(t, start.AddDate(0, 0, 5), user.UpdatedAt)
+}
+
 func TestMemoryUserStore_ConcurrentAccess(t *testing.T) {
 	store := NewMemoryUserStore()
 	var wg sync.WaitGroup
@@ -320,13 +562,12 @@ func TestMemoryUserStore_ConcurrentAccess(t *testing.T) {
 	numGoroutines := 100
 	wg.Add(numGoroutines)
 
-	for i := 0; i < numGoroutines; i++ {
-		go func(id int) {
+	for _, user := range fixtures.New(1).Users(numGoroutines) {
+		go func() {
 			defer wg.Done()
-			user := User{Name: fmt.Sprintf("User %d", id), Email: fmt.Sprintf("user%d@example.com", id)}
-			_, err := store.Create(user)
+			_, err := store.Create(User(user))
 			assert.NoError(t, err)
-		}(i)
+		}()
 	}
 
 	wg.Wait()
@@ -350,7 +591,7 @@ func TestMemoryUserStore_ConcurrentAccess(t *testing.T) {
 		// Concurrent updates
 		go func(id int) {
 			defer wg.Done()
-			userID := (id % numGoroutines) + 1 // Use existing user IDs
+			userID := int64(id%numGoroutines) + 1 // Use existing user IDs
 			user := User{Name: fmt.Sprintf("Updated User %d", id), Email: fmt.Sprintf("updated%d@example.com", id)}
 			_, err := store.Update(userID, user)
 			assert.NoError(t, err)
@@ -360,13 +601,273 @@ func TestMemoryUserStore_ConcurrentAccess(t *testing.T) {
 	wg.Wait()
 }
 
+func TestMemoryUserStore_Verify(t *testing.T) {
+	tests := []struct {
+		name         string
+		corrupt      func(*MemoryUserStore)
+		expectedKind []string
+	}{
+		{
+			name:         "consistent store",
+			corrupt:      func(m *MemoryUserStore) {},
+			expectedKind: []string{},
+		},
+		{
+			name: "record modified behind the store's back",
+			corrupt: func(m *MemoryUserStore) {
+				user := m.users[1]
+				user.Email = "tampered@example.com"
+				m.users[1] = user
+			},
+			expectedKind: []string{IssueChecksumMismatch, IssueIndexMismatch, IssueIndexMismatch},
+		},
+		{
+			name: "missing and orphaned checksums",
+			corrupt: func(m *MemoryUserStore) {
+				delete(m.checksums, 1)
+				m.checksums[99] = 0
+			},
+			expectedKind: []string{IssueMissingChecksum, IssueOrphanChecksum},
+		},
+		{
+			name: "record stored under the wrong key",
+			corrupt: func(m *MemoryUserStore) {
+				user := m.users[2]
+				user.ID = 7
+				m.users[2] = user
+			},
+			expectedKind: []string{IssueChecksumMismatch, IssueIDMismatch},
+		},
+		{
+			name: "stale email index entry",
+			corrupt: func(m *MemoryUserStore) {
+				m.indexes[IndexEmail].add(User{ID: 2, Email: "stale@example.com"})
+			},
+			expectedKind: []string{IssueIndexMismatch},
+		},
+		{
+			name: "missing created_at index entry",
+			corrupt: func(m *MemoryUserStore) {
+				m.timeIndexes[IndexCreatedAt].remove(m.users[1])
+			},
+			expectedKind: []string{IssueIndexMismatch},
+		},
+		{
+			name: "sequence behind existing records",
+			corrupt: func(m *MemoryUserStore) {
+				m.nextID = 2
+			},
+			expectedKind: []string{IssueSequenceBehind},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryUserStore()
+			_, _ = store.Create(User{Name: "User 1", Email: "user1@example.com"})
+			_, _ = store.Create(User{Name: "User 2", Email: "user2@example.com"})
+			tt.corrupt(store)
+
+			report, err := store.Verify(false)
+			require.NoError(t, err)
+			assert.Equal(t, 2, report.Records)
+
+			kinds := make([]string, 0, len(report.Issues))
+			for _, issue := range report.Issues {
+				assert.False(t, issue.Repaired)
+				kinds = append(kinds, issue.Kind)
+			}
+			assert.ElementsMatch(t, tt.expectedKind, kinds)
+			assert.Equal(t, len(tt.expectedKind) == 0, report.Healthy())
+
+			// Repair and verify the store is consistent afterwards
+			repaired, err := store.Verify(true)
+			require.NoError(t, err)
+			assert.True(t, repaired.Healthy())
+
+			after, err := store.Verify(false)
+			require.NoError(t, err)
+			assert.Empty(t, after.Issues)
+			assert.Equal(t, repaired.Checksum, after.Checksum)
+		})
+	}
+}
+
+func TestMemoryUserStore_VerifyChecksumIsOrderIndependent(t *testing.T) {
+	first := NewMemoryUserStore()
+	second := NewMemoryUserStore()
+	// Timestamps are checksummed too, so both stores need the same clock
+	clk := clock.NewFake(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	first.clock, second.clock = clk, clk
+	for _, user := range fixtures.New(1).Users(10) {
+		_, _ = first.Create(User(user))
+		_, _ = second.Create(User(user))
+	}
+
+	firstReport, err := first.Verify(false)
+	require.NoError(t, err)
+	secondReport, err := second.Verify(false)
+	require.NoError(t, err)
+	assert.Equal(t, firstReport.Checksum, secondReport.Checksum)
+
+	_, _ = second.Update(3, User{Name: "Changed", Email: "changed@example.com"})
+	secondReport, err = second.Verify(false)
+	require.NoError(t, err)
+	assert.NotEqual(t, firstReport.Checksum, secondReport.Checksum)
+}
+
+func TestMemoryUserStore_GetByEmail(t *testing.T) {
+	store := NewMemoryUserStore()
+	user1, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	user2, _ := store.Create(User{Name: "User 2", Email: "user2@example.com"})
+	shared1, _ := store.Create(User{Name: "Shared 1", Email: "shared@example.com"})
+	_, _ = store.Create(User{Name: "Shared 2", Email: "shared@example.com"})
+	_, _ = store.Create(User{Name: "No Email", Email: ""})
+
+	// Index entries follow updates and deletes
+	_, _ = store.Update(user2.ID, User{Name: "User 2", Email: "moved@example.com"})
+
+	tests := []struct {
+		name        string
+		email       string
+		expectedID  int64
+		expectError bool
+	}{
+		{
+			name:       "exact match",
+			email:      "user1@example.com",
+			expectedID: user1.ID,
+		},
+		{
+			name:       "case-insensitive match",
+			email:      "  USER1@Example.com ",
+			expectedID: user1.ID,
+		},
+		{
+			name:       "updated email",
+			email:      "moved@example.com",
+			expectedID: user2.ID,
+		},
+		{
+			name:        "previous email no longer matches",
+			email:       "user2@example.com",
+			expectError: true,
+		},
+		{
+			name:       "shared email returns lowest ID",
+			email:      "shared@example.com",
+			expectedID: shared1.ID,
+		},
+		{
+			name:        "empty email is not indexed",
+			email:       "",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := store.GetByEmail(tt.email)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, result)
+				assert.Contains(t, err.Error(), "user not found")
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedID, result.ID)
+			}
+		})
+	}
+
+	require.NoError(t, store.Delete(user1.ID))
+	_, err := store.GetByEmail("user1@example.com")
+	assert.Error(t, err)
+
+	report, err := store.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+}
+
+func TestMemoryUserStore_Snapshot(t *testing.T) {
+	store := NewMemoryUserStore()
+	user1, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	user2, _ := store.Create(User{Name: "User 2", Email: "user2@example.com"})
+
+	snapshot, err := store.Snapshot()
+	require.NoError(t, err)
+
+	// Mutate the store after the snapshot was taken
+	_, _ = store.Update(user1.ID, User{Name: "Changed", Email: "changed@example.com"})
+	require.NoError(t, store.Delete(user2.ID))
+	_, _ = store.Create(User{Name: "User 3", Email: "user3@example.com"})
+
+	count, err := snapshot.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	users, err := snapshot.GetAll()
+	require.NoError(t, err)
+	assert.Equal(t, []User{*user1, *user2}, users)
+
+	retrieved, err := snapshot.GetByID(user1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "User 1", retrieved.Name)
+
+	_, err = snapshot.GetByID(3)
+	assert.Error(t, err)
+
+	// The store itself sees every write
+	current, _ := store.GetAll()
+	assert.Len(t, current, 2)
+	report, err := store.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+}
+
+func TestMemoryUserStore_SnapshotConcurrentWrites(t *testing.T) {
+	store := NewMemoryUserStore()
+	for _, user := range fixtures.New(1).Users(50) {
+		_, _ = store.Create(User(user))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := int64(1); i <= 50; i++ {
+			_, _ = store.Update(i, User{Name: "Updated", Email: "updated@example.com"})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			snapshot, err := store.Snapshot()
+			assert.NoError(t, err)
+			first, _ := snapshot.GetAll()
+			second, _ := snapshot.GetAll()
+			assert.Equal(t, first, second, "snapshot changed while being read")
+		}
+	}()
+
+	wg.Wait()
+}
+
 // Test suite for interface compliance
 type UserStoreTestSuite struct {
 	suite.Suite
 	store UserStore
+	// newStore creates an empty store for each test, or a memory store when
+	// nil
+	newStore func(t *testing.T) UserStore
 }
 
 func (suite *UserStoreTestSuite) SetupTest() {
+	if suite.newStore != nil {
+		suite.store = suite.newStore(suite.T())
+		return
+	}
 	suite.store = NewMemoryUserStore()
 }
 
@@ -433,6 +934,129 @@ func (suite *UserStoreTestSuite) TestGetAllAfterOperations() {
 	suite.Equal(user2.ID, users[0].ID)
 }
 
+func (suite *UserStoreTestSuite) TestCreateMany() {
+	existing, err := suite.store.Create(User{Name: "Existing", Email: "existing@example.com"})
+	suite.Require().NoError(err)
+
+	created, err := CreateMany(suite.store, []User{
+		{Name: "User 1", Email: "user1@example.com"},
+		{Name: "User 2", Email: "user2@example.com"},
+	})
+	suite.Require().NoError(err)
+	suite.Require().Len(created, 2)
+	suite.Equal([]int64{existing.ID + 1, existing.ID + 2}, []int64{created[0].ID, created[1].ID})
+	suite.Equal("User 2", created[1].Name)
+	suite.False(created[0].CreatedAt.IsZero())
+
+	users, err := suite.store.GetAll()
+	suite.Require().NoError(err)
+	suite.Len(users, 3)
+	retrieved, err := suite.store.GetByID(created[1].ID)
+	suite.Require().NoError(err)
+	suite.Equal("user2@example.com", retrieved.Email)
+
+	// Later users follow the batch
+	next, err := suite.store.Create(User{Name: "Next", Email: "next@example.com"})
+	suite.Require().NoError(err)
+	suite.Equal(existing.ID+3, next.ID)
+}
+
+func (suite *UserStoreTestSuite) TestGetUsers() {
+	var ids []int64
+	for i := range 3 {
+		created, err := suite.store.Create(User{Name: fmt.Sprintf("User %d", i+1), Email: fmt.Sprintf("user%d@example.com", i+1)})
+		suite.Require().NoError(err)
+		ids = append(ids, created.ID)
+	}
+
+	// Missing and repeated IDs are skipped, and users come ordered by ID
+	users, err := GetUsers(suite.store, []int64{ids[2], 999, ids[0], ids[2]})
+	suite.Require().NoError(err)
+	suite.Require().Len(users, 2)
+	suite.Equal([]int64{ids[0], ids[2]}, []int64{users[0].ID, users[1].ID})
+	suite.Equal("User 3", users[1].Name)
+
+	users, err = GetUsers(suite.store, nil)
+	suite.Require().NoError(err)
+	suite.Empty(users)
+}
+
+func (suite *UserStoreTestSuite) TestVersions() {
+	created, err := suite.store.Create(User{Name: "John Doe", Email: "john@example.com"})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), created.Version)
+
+	// Updates apply whatever the version, even one sent along
+	updated, err := suite.store.Update(created.ID, User{Name: "Johnny", Email: "john@example.com", Version: 7})
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), updated.Version)
+
+	// Versioned writes apply only while the user is at the version
+	_, err = UpdateVersion(suite.store, created.ID, 1, User{Name: "Stale", Email: "john@example.com"})
+	suite.ErrorIs(err, ErrVersionConflict)
+	updated, err = UpdateVersion(suite.store, created.ID, 2, User{Name: "John", Email: "john@example.com"})
+	suite.Require().NoError(err)
+	suite.Equal(int64(3), updated.Version)
+
+	retrieved, err := suite.store.GetByID(created.ID)
+	suite.Require().NoError(err)
+	suite.Equal("John", retrieved.Name)
+	suite.Equal(int64(3), retrieved.Version)
+
+	suite.ErrorIs(DeleteVersion(suite.store, created.ID, 2), ErrVersionConflict)
+	_, err = suite.store.GetByID(created.ID)
+	suite.Require().NoError(err)
+	suite.Require().NoError(DeleteVersion(suite.store, created.ID, 3))
+	_, err = suite.store.GetByID(created.ID)
+	suite.Error(err)
+
+	_, err = UpdateVersion(suite.store, 999, 1, User{Name: "Nobody", Email: "nobody@example.com"})
+	suite.Error(err)
+	suite.NotErrorIs(err, ErrVersionConflict)
+	err = DeleteVersion(suite.store, 999, 1)
+	suite.Error(err)
+	suite.NotErrorIs(err, ErrVersionConflict)
+}
+
+func (suite *UserStoreTestSuite) TestVersions_Untracked() {
+	replacer, ok := suite.store.(Replacer)
+	if !ok {
+		suite.T().Skip("store does not replace users")
+	}
+	// Users stored before versions were tracked are at version 0
+	legacy, err := replacer.Replace(User{ID: 1, Name: "John Doe", Email: "john@example.com", CreatedAt: time.Now().UTC()})
+	suite.Require().NoError(err)
+	suite.Zero(legacy.Version)
+
+	_, err = UpdateVersion(suite.store, legacy.ID, 1, User{Name: "Stale", Email: "john@example.com"})
+	suite.ErrorIs(err, ErrVersionConflict)
+	updated, err := UpdateVersion(suite.store, legacy.ID, 0, User{Name: "John", Email: "john@example.com"})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), updated.Version)
+	suite.ErrorIs(DeleteVersion(suite.store, legacy.ID, 0), ErrVersionConflict)
+}
+
+func (suite *UserStoreTestSuite) TestUnicode() {
+	created, err := suite.store.Create(User{Name: "Jörg Müller", Email: "Jörg@Bücher.de"})
+	suite.Require().NoError(err)
+	suite.Equal("Jörg Müller", created.Name)
+
+	// Emails are found ignoring case, Unicode normalization and whether
+	// the domain is written in punycode
+	for _, address := range []string{"jörg@bücher.de", "JÖRG@BÜCHER.DE", "jo\u0308rg@bu\u0308cher.de", "jörg@xn--bcher-kva.de"} {
+		found, err := suite.store.GetByEmail(address)
+		suite.Require().NoError(err, address)
+		suite.Equal(created.ID, found.ID)
+		suite.Equal("Jörg@Bücher.de", found.Email, "emails are stored as given")
+	}
+
+	for _, filter := range []UserFilter{{Name: "MÜLLER"}, {Name: "mu\u0308ller"}, {Query: "bücher"}} {
+		users, err := FindUsers(suite.store, filter)
+		suite.Require().NoError(err)
+		suite.Len(users, 1, filter)
+	}
+}
+
 func TestUserStoreCompliance(t *testing.T) {
 	suite.Run(t, new(UserStoreTestSuite))
 }
@@ -461,8 +1085,8 @@ func BenchmarkMemoryUserStore_GetByID(b *testing.B) {
 func BenchmarkMemoryUserStore_GetAll(b *testing.B) {
 	store := NewMemoryUserStore()
 	// Create 1000 users for realistic benchmark
-	for i := 0; i < 1000; i++ {
-		_, _ = store.Create(User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	for _, user := range fixtures.New(1).Users(1000) {
+		_, _ = store.Create(User(user))
 	}
 
 	b.ResetTimer()
@@ -471,6 +1095,41 @@ func BenchmarkMemoryUserStore_GetAll(b *testing.B) {
 	}
 }
 
+func BenchmarkMemoryUserStore_GetByEmail(b *testing.B) {
+	store := NewMemoryUserStore()
+	users := fixtures.New(1).Users(10000)
+	for _, user := range users {
+		_, _ = store.Create(User(user))
+	}
+	last := users[len(users)-1].Email
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = store.GetByEmail(last)
+	}
+}
+
+// BenchmarkMemoryUserStore_GetByEmailScan is the full-scan baseline the email
+// index replaces
+func BenchmarkMemoryUserStore_GetByEmailScan(b *testing.B) {
+	store := NewMemoryUserStore()
+	users := fixtures.New(1).Users(10000)
+	for _, user := range users {
+		_, _ = store.Create(User(user))
+	}
+	last := users[len(users)-1].Email
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		users, _ := store.GetAll()
+		for _, user := range users {
+			if user.Email == last {
+				break
+			}
+		}
+	}
+}
+
 func BenchmarkMemoryUserStore_ConcurrentReads(b *testing.B) {
 	store := NewMemoryUserStore()
 	// Setup test data
@@ -0,0 +1,712 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+// MongoOptions configures a MongoUserStore
+type MongoOptions struct {
+	// URI is the connection string, e.g. mongodb://localhost:27017
+	URI        string
+	Database   string
+	Collection string
+	// Timeout bounds connecting and each operation
+	Timeout time.Duration
+	Clock   clock.Clock
+	// IDs numbers new users when set, instead of the counter
+	IDs idgen.IntGenerator
+}
+
+// MongoUserStore keeps users in a MongoDB collection, so several instances
+// can share them. User IDs come from a counter document in the "counters"
+// collection of the same database, unless numbered by MongoOptions.IDs.
+type MongoUserStore struct {
+	client   *mongo.Client
+	users    *mongo.Collection
+	counters *mongo.Collection
+	// counter is the ID of the users' counter document
+	counter string
+	timeout time.Duration
+	clock   clock.Clock
+	ids     idgen.IntGenerator
+}
+
+// mongoUser is a user as stored in MongoDB, keyed by its ID
+type mongoUser struct {
+	ID    int64  `bson:"_id"`
+	Name  string `bson:"name"`
+	Email string `bson:"email"`
+	// EmailKey is the normalised email, indexed for GetByEmail
+	EmailKey   string     `bson:"email_key"`
+	LastSeenAt *time.Time `bson:"last_seen_at,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at"`
+	UpdatedAt  time.Time  `bson:"updated_at"`
+	Version    int64      `bson:"version,omitempty"`
+}
+
+func toMongo(user User) mongoUser {
+	return mongoUser{
+		ID:         user.ID,
+		Name:       user.Name,
+		Email:      user.Email,
+		EmailKey:   emailKey(user.Email),
+		LastSeenAt: user.LastSeenAt,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		Version:    user.Version,
+	}
+}
+
+func (u mongoUser) user() User {
+	return User{
+		ID:         u.ID,
+		Name:       u.Name,
+		Email:      u.Email,
+		LastSeenAt: u.LastSeenAt,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+		Version:    u.Version,
+	}
+}
+
+// NewMongoUserStore connects to MongoDB and indexes the users collection by
+// email, creation and update time
+func NewMongoUserStore(opts MongoOptions) (*MongoUserStore, error) {
+	if opts.Database == "" {
+		opts.Database = "userapi"
+	}
+	if opts.Collection == "" {
+		opts.Collection = "users"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(opts.URI).SetTimeout(opts.Timeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	db := client.Database(opts.Database)
+	m := &MongoUserStore{
+		client:   client,
+		users:    db.Collection(opts.Collection),
+		counters: db.Collection("counters"),
+		counter:  opts.Collection,
+		timeout:  opts.Timeout,
+		clock:    opts.Clock,
+		ids:      opts.IDs,
+	}
+
+	ctx, cancel := m.context()
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to reach MongoDB: %w", err)
+	}
+	_, err = m.users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email_key", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName(IndexEmail)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName(IndexCreatedAt)},
+		{Keys: bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName(IndexUpdatedAt)},
+	})
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to index users: %w", err)
+	}
+	return m, nil
+}
+
+// context returns a context bounded by the store's timeout
+func (m *MongoUserStore) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), m.timeout)
+}
+
+// now returns the current time at the millisecond precision MongoDB stores
+func (m *MongoUserStore) now() time.Time {
+	return m.clock.Now().UTC().Truncate(time.Millisecond)
+}
+
+// Close disconnects from MongoDB
+func (m *MongoUserStore) Close() error {
+	ctx, cancel := m.context()
+	defer cancel()
+	return m.client.Disconnect(ctx)
+}
+
+// GetAll returns all users, ordered by ID
+func (m *MongoUserStore) GetAll() ([]User, error) {
+	return m.find(bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// find returns the users matching filter, in the order opts sort them
+func (m *MongoUserStore) find(filter bson.D, opts *options.FindOptionsBuilder) ([]User, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	cursor, err := m.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	var docs []mongoUser
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	users := make([]User, len(docs))
+	for i, doc := range docs {
+		users[i] = doc.user()
+	}
+	return users, nil
+}
+
+// Count returns the number of users
+func (m *MongoUserStore) Count() (int, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	count, err := m.users.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return int(count), nil
+}
+
+// GetByID returns a user by ID
+func (m *MongoUserStore) GetByID(id int64) (*User, error) {
+	return m.findOne(bson.D{{Key: "_id", Value: id}})
+}
+
+// GetByIDs returns the users with ids ordered by ID, in one query
+func (m *MongoUserStore) GetByIDs(ids []int64) ([]User, error) {
+	if len(ids) == 0 {
+		return []User{}, nil
+	}
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: sortedIDs(ids)}}}}
+	return m.find(filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// GetByEmail returns the user with the given email address, compared
+// case-insensitively. If several users share the address, the one with the
+// lowest ID is returned.
+func (m *MongoUserStore) GetByEmail(email string) (*User, error) {
+	return m.findOne(bson.D{{Key: "email_key", Value: emailKey(email)}})
+}
+
+// findOne returns the user with the lowest ID matching filter
+func (m *MongoUserStore) findOne(filter bson.D) (*User, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	var doc mongoUser
+	err := m.users.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.New("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	user := doc.user()
+	return &user, nil
+}
+
+// Find returns the users passing filter in its sort order, or otherwise
+// in time order, using the created_at or updated_at index
+func (m *MongoUserStore) Find(filter UserFilter) ([]User, error) {
+	query := bson.D{}
+	created := bson.D{}
+	if !filter.CreatedAfter.IsZero() {
+		created = append(created, bson.E{Key: "$gt", Value: filter.CreatedAfter})
+	}
+	if !filter.CreatedBefore.IsZero() {
+		created = append(created, bson.E{Key: "$lt", Value: filter.CreatedBefore})
+	}
+	if len(created) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: created})
+	}
+	if !filter.UpdatedAfter.IsZero() {
+		query = append(query, bson.E{Key: "updated_at", Value: bson.D{{Key: "$gt", Value: filter.UpdatedAfter}}})
+	}
+	if filter.Name != "" {
+		query = append(query, bson.E{Key: "name", Value: containsRegex(filter.Name)})
+	}
+	if filter.Email != "" {
+		query = append(query, bson.E{Key: "email", Value: containsRegex(filter.Email)})
+	}
+	if filter.Query != "" {
+		query = append(query, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "name", Value: containsRegex(filter.Query)}},
+			bson.D{{Key: "email", Value: containsRegex(filter.Query)}},
+		}})
+	}
+	if filter.Where != nil {
+		query = append(query, bson.E{Key: "$and", Value: bson.A{mongoCondition(filter.Where)}})
+	}
+
+	if len(filter.Sort) > 0 {
+		opts := options.Find().SetSort(mongoSort(filter.Sort))
+		if filter.Collation != language.Und {
+			opts.SetCollation(mongoCollation(filter.Collation))
+		}
+		return m.find(query, opts)
+	}
+	order := "created_at"
+	if len(created) == 0 && !filter.UpdatedAfter.IsZero() {
+		order = "updated_at"
+	}
+	return m.find(query, options.Find().SetSort(bson.D{{Key: order, Value: 1}, {Key: "_id", Value: 1}}))
+}
+
+// mongoSort returns the sort document for sort, with ties broken by ID
+func mongoSort(sort UserSort) bson.D {
+	doc := bson.D{}
+	for _, key := range sort {
+		field := key.Field
+		if field == SortID {
+			field = "_id"
+		}
+		order := 1
+		if key.Descending {
+			order = -1
+		}
+		doc = append(doc, bson.E{Key: field, Value: order})
+	}
+	if !slices.ContainsFunc(sort, func(k SortKey) bool { return k.Field == SortID }) {
+		doc = append(doc, bson.E{Key: "_id", Value: 1})
+	}
+	return doc
+}
+
+// mongoCollation returns the collation of a language. MongoDB knows few
+// regional locales, so only the base language is given. It rejects the
+// rare languages it has no collation for.
+func mongoCollation(tag language.Tag) *options.Collation {
+	base, _ := tag.Base()
+	return &options.Collation{Locale: base.String()}
+}
+
+// containsRegex matches strings containing substr, ignoring case. Names
+// and emails are stored in NFC, so substr is normalized to match them.
+func containsRegex(substr string) bson.Regex {
+	return bson.Regex{Pattern: regexp.QuoteMeta(norm.NFC.String(substr)), Options: "i"}
+}
+
+// mongoCondition returns the query document for condition. Names and
+// emails are matched by case-insensitive regular expressions, as searches
+// match them.
+func mongoCondition(condition Condition) bson.D {
+	switch c := condition.(type) {
+	case All:
+		return bson.D{{Key: "$and", Value: mongoConditions(c)}}
+	case Any:
+		return bson.D{{Key: "$or", Value: mongoConditions(c)}}
+	case Comparison:
+		return mongoComparison(c)
+	}
+	panic(fmt.Sprintf("unknown condition %T", condition))
+}
+
+func mongoConditions(conditions []Condition) bson.A {
+	docs := make(bson.A, len(conditions))
+	for i, condition := range conditions {
+		docs[i] = mongoCondition(condition)
+	}
+	return docs
+}
+
+// mongoComparison returns the query document for c. Comparisons of fields
+// a document lacks fail, except by $ne and $nin, as they do in Matches.
+func mongoComparison(c Comparison) bson.D {
+	field := c.Field
+	if field == SortID {
+		field = "_id"
+	}
+	values := bson.A(c.Values)
+	if c.Field == SortName || c.Field == SortEmail {
+		values = make(bson.A, len(c.Values))
+		for i, value := range c.Values {
+			pattern := regexp.QuoteMeta(value.(string))
+			if c.Op == OpLike {
+				pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+			}
+			values[i] = bson.Regex{Pattern: "^" + pattern + "$", Options: "i"}
+		}
+	}
+
+	var operator string
+	switch c.Op {
+	case OpEqual, OpLike:
+		return bson.D{{Key: field, Value: values[0]}}
+	case OpNotEqual:
+		if regex, ok := values[0].(bson.Regex); ok {
+			return bson.D{{Key: field, Value: bson.D{{Key: "$not", Value: regex}}}}
+		}
+		operator = "$ne"
+	case OpLess:
+		operator = "$lt"
+	case OpLessOrEqual:
+		operator = "$lte"
+	case OpGreater:
+		operator = "$gt"
+	case OpGreaterOrEqual:
+		operator = "$gte"
+	case OpIn:
+		return bson.D{{Key: field, Value: bson.D{{Key: "$in", Value: values}}}}
+	case OpOut:
+		return bson.D{{Key: field, Value: bson.D{{Key: "$nin", Value: values}}}}
+	}
+	return bson.D{{Key: field, Value: bson.D{{Key: operator, Value: values[0]}}}}
+}
+
+// Create adds a new user and returns the created user with assigned ID
+func (m *MongoUserStore) Create(user User) (*User, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	id, err := m.nextID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user.ID = id
+	user.CreatedAt = m.now()
+	user.UpdatedAt = user.CreatedAt
+	user.Version = 1
+	if _, err := m.users.InsertOne(ctx, toMongo(user)); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return &user, nil
+}
+
+// CreateMany inserts users with a range of IDs taken from the counter at
+// once, or from MongoOptions.IDs. MongoDB has transactions only on replica sets, so when an insert
+// fails those already made are deleted again; readers may briefly see
+// part of a batch that fails.
+func (m *MongoUserStore) CreateMany(users []User) ([]User, error) {
+	if len(users) == 0 {
+		return []User{}, nil
+	}
+	ctx, cancel := m.context()
+	defer cancel()
+
+	newIDs, err := m.newIDs(ctx, len(users))
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	created := make([]User, len(users))
+	docs := make([]mongoUser, len(users))
+	ids := make(bson.A, len(users))
+	for i, user := range users {
+		user.ID = newIDs[i]
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		user.Version = 1
+		created[i] = user
+		docs[i] = toMongo(user)
+		ids[i] = user.ID
+	}
+	if _, err := m.users.InsertMany(ctx, docs); err != nil {
+		if _, undoErr := m.users.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}); undoErr != nil {
+			return nil, fmt.Errorf("failed to create users: %w, and to delete those created: %w", err, undoErr)
+		}
+		return nil, fmt.Errorf("failed to create users: %w", err)
+	}
+	return created, nil
+}
+
+// nextID takes the next ID from the counter
+func (m *MongoUserStore) nextID(ctx context.Context) (int64, error) {
+	ids, err := m.newIDs(ctx, 1)
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// newIDs returns n IDs in order, from ids when set, keeping the counter past
+// them, or otherwise taken from the counter at once
+func (m *MongoUserStore) newIDs(ctx context.Context, n int) ([]int64, error) {
+	ids := make([]int64, n)
+	if m.ids != nil {
+		for i := range ids {
+			ids[i] = m.ids.NewInt()
+		}
+		return ids, m.advance(ctx, ids[n-1])
+	}
+	last, err := m.reserveIDs(ctx, int64(n))
+	if err != nil {
+		return nil, err
+	}
+	for i := range ids {
+		ids[i] = last - int64(n-1-i)
+	}
+	return ids, nil
+}
+
+// reserveIDs takes n IDs from the counter, returning the last of them
+func (m *MongoUserStore) reserveIDs(ctx context.Context, n int64) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := m.counters.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: m.counter}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: n}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate user ID: %w", err)
+	}
+	return counter.Seq, nil
+}
+
+// advance moves the counter to at least id, so new users never take it
+func (m *MongoUserStore) advance(ctx context.Context, id int64) error {
+	_, err := m.counters.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: m.counter}},
+		bson.D{{Key: "$max", Value: bson.D{{Key: "seq", Value: id}}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to advance user IDs: %w", err)
+	}
+	return nil
+}
+
+// Update modifies an existing user, keeping its LastSeenAt and CreatedAt
+func (m *MongoUserStore) Update(id int64, user User) (*User, error) {
+	return m.update(id, nil, user)
+}
+
+// UpdateVersion is Update, returning ErrVersionConflict unless the user is
+// at version. The version is matched in the same update, so no other write
+// can come between checking and applying it.
+func (m *MongoUserStore) UpdateVersion(id, version int64, user User) (*User, error) {
+	return m.update(id, &version, user)
+}
+
+// update updates the user id, unless version is set and the user is at
+// another
+func (m *MongoUserStore) update(id int64, version *int64, user User) (*User, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	filter := versionFilter(id, version)
+	var doc mongoUser
+	err := m.users.FindOneAndUpdate(ctx,
+		filter,
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "name", Value: user.Name},
+				{Key: "email", Value: user.Email},
+				{Key: "email_key", Value: emailKey(user.Email)},
+				{Key: "updated_at", Value: m.now()},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, m.missed(ctx, id, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	updated := doc.user()
+	return &updated, nil
+}
+
+// versionFilter matches the user id, and when version is set, only while it
+// is at that version. Users stored before versions were tracked have none,
+// which is version 0.
+func versionFilter(id int64, version *int64) bson.D {
+	filter := bson.D{{Key: "_id", Value: id}}
+	switch {
+	case version == nil:
+	case *version == 0:
+		filter = append(filter, bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{int64(0), nil}}}})
+	default:
+		filter = append(filter, bson.E{Key: "version", Value: *version})
+	}
+	return filter
+}
+
+// missed returns why a write matching versionFilter(id, version) matched
+// nothing: ErrVersionConflict when the user exists at another version,
+// otherwise that it does not exist
+func (m *MongoUserStore) missed(ctx context.Context, id int64, version *int64) error {
+	if version != nil {
+		if count, err := m.users.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}}); err == nil && count > 0 {
+			return ErrVersionConflict
+		}
+	}
+	return errors.New("user not found")
+}
+
+// RecordActivity moves each user's LastSeenAt forward to the given time,
+// skipping users that no longer exist
+func (m *MongoUserStore) RecordActivity(seen map[int64]time.Time) error {
+	if len(seen) == 0 {
+		return nil
+	}
+	ctx, cancel := m.context()
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(seen))
+	for id, at := range seen {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}}).
+			SetUpdate(bson.D{{Key: "$max", Value: bson.D{{Key: "last_seen_at", Value: at.UTC().Truncate(time.Millisecond)}}}}))
+	}
+	if _, err := m.users.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a user by ID
+func (m *MongoUserStore) Delete(id int64) error {
+	return m.delete(id, nil)
+}
+
+// DeleteVersion is Delete, returning ErrVersionConflict unless the user is
+// at version
+func (m *MongoUserStore) DeleteVersion(id, version int64) error {
+	return m.delete(id, &version)
+}
+
+// delete deletes the user id, unless version is set and the user is at
+// another
+func (m *MongoUserStore) delete(id int64, version *int64) error {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	result, err := m.users.DeleteOne(ctx, versionFilter(id, version))
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return m.missed(ctx, id, version)
+	}
+	return nil
+}
+
+// Restore puts a deleted user back under its original ID
+func (m *MongoUserStore) Restore(user User) (*User, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	if _, err := m.users.InsertOne(ctx, toMongo(user)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("user %d already exists", user.ID)
+		}
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+	if err := m.advance(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Replace stores a user exactly as given, creating it or overwriting the
+// user with its ID
+func (m *MongoUserStore) Replace(user User) (*User, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	_, err := m.users.ReplaceOne(ctx, bson.D{{Key: "_id", Value: user.ID}}, toMongo(user), options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace user: %w", err)
+	}
+	if err := m.advance(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Verify checks that each user's indexed email matches its address and
+// that the ID counter is past every user. MongoDB keeps its own indexes
+// consistent, so records are otherwise only checksummed for the report.
+// When repair is true, inconsistencies are fixed in place and flagged as
+// repaired in the report.
+func (m *MongoUserStore) Verify(repair bool) (*IntegrityReport, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	cursor, err := m.users.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	var docs []mongoUser
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+
+	report := &IntegrityReport{Records: len(docs), Issues: []IntegrityIssue{}}
+	computed := make(map[int64]uint32, len(docs))
+	var maxID int64
+	for _, doc := range docs {
+		computed[doc.ID] = checksum(doc.user())
+		maxID = max(maxID, doc.ID)
+
+		if key := emailKey(doc.Email); doc.EmailKey != key {
+			report.Issues = append(report.Issues, IntegrityIssue{
+				UserID:   doc.ID,
+				Kind:     IssueIndexMismatch,
+				Detail:   fmt.Sprintf("%s index entry %q does not match the stored records", IndexEmail, doc.EmailKey),
+				Repaired: repair,
+			})
+			if repair {
+				_, err := m.users.UpdateOne(ctx, bson.D{{Key: "_id", Value: doc.ID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "email_key", Value: key}}}})
+				if err != nil {
+					return nil, fmt.Errorf("failed to persist repairs: %w", err)
+				}
+			}
+		}
+	}
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err = m.counters.FindOne(ctx, bson.D{{Key: "_id", Value: m.counter}}).Decode(&counter)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to read user IDs: %w", err)
+	}
+	if counter.Seq < maxID {
+		report.Issues = append(report.Issues, IntegrityIssue{
+			UserID:   maxID,
+			Kind:     IssueSequenceBehind,
+			Detail:   fmt.Sprintf("next ID %d would collide with existing ID %d", counter.Seq+1, maxID),
+			Repaired: repair,
+		})
+		if repair {
+			if err := m.advance(ctx, maxID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Slice(report.Issues, func(i, j int) bool {
+		if report.Issues[i].UserID != report.Issues[j].UserID {
+			return report.Issues[i].UserID < report.Issues[j].UserID
+		}
+		return report.Issues[i].Kind < report.Issues[j].Kind
+	})
+	report.Checksum = combinedChecksum(computed)
+	return report, nil
+}
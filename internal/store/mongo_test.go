@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// newMongoStore opens a store on a collection of its own in the MongoDB at
+// MONGO_URI, dropped after the test. Tests using it are skipped unless
+// MONGO_URI is set, e.g. to mongodb://localhost:27017 after
+// docker compose -f deployments/docker-compose.yml up -d mongo
+func newMongoStore(t *testing.T, clk clock.Clock) *MongoUserStore {
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" || testing.Short() {
+		t.Skip("set MONGO_URI to test against a live MongoDB")
+	}
+	collection := fmt.Sprintf("users_%d", time.Now().UnixNano())
+	s, err := NewMongoUserStore(MongoOptions{URI: uri, Database: "userapi_test", Collection: collection, Clock: clk})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_ = s.users.Drop(ctx)
+		_, _ = s.counters.DeleteOne(ctx, bson.D{{Key: "_id", Value: collection}})
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestNewMongoUserStore_Unreachable(t *testing.T) {
+	_, err := NewMongoUserStore(MongoOptions{URI: "mongodb://127.0.0.1:1", Timeout: 200 * time.Millisecond})
+	assert.ErrorContains(t, err, "failed to reach MongoDB")
+}
+
+func TestMongoUserStore_Integration(t *testing.T) {
+	newMongoStore(t, nil)
+
+	t.Run("compliance", func(t *testing.T) {
+		suite.Run(t, &UserStoreTestSuite{newStore: func(t *testing.T) UserStore { return newMongoStore(t, nil) }})
+	})
+
+	t.Run("timestamps", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 123456789, time.UTC))
+		s := newMongoStore(t, clk)
+
+		created, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), created.ID)
+		assert.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC), created.CreatedAt)
+
+		clk.Advance(time.Minute)
+		require.NoError(t, s.RecordActivity(map[int64]time.Time{created.ID: clk.Now(), 99: clk.Now()}))
+		// Activity only moves forward
+		require.NoError(t, s.RecordActivity(map[int64]time.Time{created.ID: clk.Now().Add(-time.Hour)}))
+		updated, err := s.Update(created.ID, User{Name: "John Smith", Email: "john@example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+		assert.Equal(t, created.CreatedAt.Add(time.Minute), updated.UpdatedAt)
+		require.NotNil(t, updated.LastSeenAt)
+		assert.Equal(t, updated.UpdatedAt, *updated.LastSeenAt)
+
+		got, err := s.GetByID(created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, updated, got)
+
+		_, err = s.Update(99, User{Name: "Nobody"})
+		assert.Error(t, err)
+		assert.Error(t, s.Delete(99))
+	})
+
+	t.Run("email", func(t *testing.T) {
+		s := newMongoStore(t, nil)
+		first, err := s.Create(User{Name: "John Doe", Email: "John@Example.com"})
+		require.NoError(t, err)
+		_, err = s.Create(User{Name: "John Again", Email: "john@example.com"})
+		require.NoError(t, err)
+
+		got, err := s.GetByEmail(" JOHN@example.COM")
+		require.NoError(t, err)
+		assert.Equal(t, first.ID, got.ID)
+		_, err = s.GetByEmail("jane@example.com")
+		assert.Error(t, err)
+	})
+
+	t.Run("find", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		s := newMongoStore(t, clk)
+		for _, name := range []string{"a", "b", "c"} {
+			_, err := s.Create(User{Name: name, Email: name + "@example.com"})
+			require.NoError(t, err)
+			clk.Advance(time.Hour)
+		}
+		_, err := s.Update(1, User{Name: "a", Email: "a@example.com"})
+		require.NoError(t, err)
+
+		names := func(users []User) []string {
+			var names []string
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			return names
+		}
+		users, err := s.Find(UserFilter{CreatedAfter: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b", "c"}, names(users))
+		users, err = s.Find(UserFilter{UpdatedAfter: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "a"}, names(users))
+		users, err = s.Find(UserFilter{Query: "A@EXAMPLE"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, names(users))
+		users, err = s.Find(UserFilter{Name: "b", Email: "example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, names(users))
+		users, err = s.Find(UserFilter{Sort: UserSort{{Field: SortName, Descending: true}}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "b", "a"}, names(users))
+		for expr, want := range map[string][]string{
+			"name==A": {"a"},
+			"name!=a": {"b", "c"},
+			"email=like=*@EXAMPLE.com;name=out=(a,c)":     {"b"},
+			"id=in=(1,3),created_at>2024-01-01T01:00:00Z": {"a", "c"},
+			"(id<2,id>=3);last_seen_at!=2024-01-01":       {"a", "c"},
+			"last_seen_at=ge=2024-01-01":                  nil,
+		} {
+			where, err := ParseCondition(expr)
+			require.NoError(t, err)
+			users, err = s.Find(UserFilter{Where: where, Sort: UserSort{{Field: SortName}}})
+			require.NoError(t, err)
+			assert.Equal(t, want, names(users), expr)
+		}
+	})
+
+	t.Run("restore and replace", func(t *testing.T) {
+		s := newMongoStore(t, nil)
+		created, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+		require.NoError(t, err)
+		_, err = s.Restore(*created)
+		assert.ErrorContains(t, err, "already exists")
+
+		_, err = s.Replace(User{ID: 10, Name: "Jane Doe", Email: "jane@example.com"})
+		require.NoError(t, err)
+		// New users never take a replaced user's ID
+		next, err := s.Create(User{Name: "Next", Email: "next@example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(11), next.ID)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		s := newMongoStore(t, nil)
+		_, err := s.Create(User{Name: "John Doe", Email: "john@example.com"})
+		require.NoError(t, err)
+		ctx := context.Background()
+		_, err = s.users.InsertOne(ctx, mongoUser{ID: 5, Name: "Jane Doe", Email: "Jane@example.com", EmailKey: "wrong"})
+		require.NoError(t, err)
+
+		report, err := s.Verify(false)
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Records)
+		require.Len(t, report.Issues, 2)
+		assert.Equal(t, IssueIndexMismatch, report.Issues[0].Kind)
+		assert.Equal(t, IssueSequenceBehind, report.Issues[1].Kind)
+
+		report, err = s.Verify(true)
+		require.NoError(t, err)
+		assert.True(t, report.Healthy())
+		report, err = s.Verify(false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Issues)
+		got, err := s.GetByEmail("jane@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), got.ID)
+	})
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// ErrNotRecoverable is returned when undeleting a user that was not deleted
+// or whose undo window has passed
+var ErrNotRecoverable = errors.New("user is not pending deletion")
+
+// DeletedUser is a deleted user that can still be restored
+type DeletedUser struct {
+	User      User      `json:"user"`
+	DeletedAt time.Time `json:"deleted_at" example:"2024-01-02T15:04:05Z"`
+	// PurgeAt is when the user can no longer be restored
+	PurgeAt time.Time `json:"purge_at" example:"2024-01-03T15:04:05Z"`
+}
+
+// RecycleBin keeps deleted users for an undo window, after which they are
+// purged for good
+type RecycleBin struct {
+	recoverer Recoverer
+	window    time.Duration
+	clock     clock.Clock
+
+	mutex   sync.Mutex
+	deleted map[int64]DeletedUser
+}
+
+// NewRecycleBin creates a recycle bin that restores users through recoverer
+func NewRecycleBin(recoverer Recoverer, window time.Duration, clk clock.Clock) *RecycleBin {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &RecycleBin{
+		recoverer: recoverer,
+		window:    window,
+		clock:     clk,
+		deleted:   make(map[int64]DeletedUser),
+	}
+}
+
+// Add keeps a user that has just been deleted
+func (b *RecycleBin) Add(user User) DeletedUser {
+	now := b.clock.Now().UTC()
+	deleted := DeletedUser{User: user, DeletedAt: now, PurgeAt: now.Add(b.window)}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.deleted[user.ID] = deleted
+	return deleted
+}
+
+// Undelete restores a user deleted within the undo window
+func (b *RecycleBin) Undelete(id int64) (*User, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	deleted, ok := b.deleted[id]
+	if !ok || !b.clock.Now().Before(deleted.PurgeAt) {
+		return nil, ErrNotRecoverable
+	}
+
+	restored, err := b.recoverer.Restore(deleted.User)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.deleted, id)
+	return restored, nil
+}
+
+// Pending returns the users that can still be restored, most recently
+// deleted first
+func (b *RecycleBin) Pending() []DeletedUser {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.clock.Now()
+	pending := make([]DeletedUser, 0, len(b.deleted))
+	for _, deleted := range b.deleted {
+		if now.Before(deleted.PurgeAt) {
+			pending = append(pending, deleted)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].DeletedAt.After(pending[j].DeletedAt) })
+	return pending
+}
+
+// Purge drops users whose undo window has passed, returning how many
+func (b *RecycleBin) Purge() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.clock.Now()
+	purged := 0
+	for id, deleted := range b.deleted {
+		if !now.Before(deleted.PurgeAt) {
+			delete(b.deleted, id)
+			purged++
+		}
+	}
+	return purged
+}
+
+// Run purges expired users every interval until ctx is done
+func (b *RecycleBin) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged := b.Purge(); purged > 0 {
+				logger.Info("Purged deleted users after the undo window", "count", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+func TestRecycleBin(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store, err := NewChangeCapturingUserStore(NewMemoryUserStore(), ChangeLogOptions{Clock: fake})
+	require.NoError(t, err)
+	bin := NewRecycleBin(store, time.Hour, fake)
+
+	user1, _ := store.Create(User{Name: "User 1", Email: "user1@example.com"})
+	user2, _ := store.Create(User{Name: "User 2", Email: "user2@example.com"})
+	for _, user := range []*User{user1, user2} {
+		require.NoError(t, store.Delete(user.ID))
+		bin.Add(*user)
+		fake.Advance(time.Minute)
+	}
+
+	pending := bin.Pending()
+	require.Len(t, pending, 2)
+	assert.Equal(t, DeletedUser{User: *user2, DeletedAt: start.Add(time.Minute), PurgeAt: start.Add(time.Hour + time.Minute)}, pending[0])
+
+	restored, err := bin.Undelete(user1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user1, restored)
+	_, err = bin.Undelete(user1.ID)
+	assert.ErrorIs(t, err, ErrNotRecoverable)
+
+	// The restore is seen by change consumers as a create
+	changes, err := store.Changes(0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, ChangeCreate, changes[len(changes)-1].Op)
+	assert.Equal(t, user1.ID, changes[len(changes)-1].UserID)
+
+	// Once the window passes the user cannot be restored and is purged
+	fake.Advance(time.Hour)
+	_, err = bin.Undelete(user2.ID)
+	assert.ErrorIs(t, err, ErrNotRecoverable)
+	assert.Empty(t, bin.Pending())
+	assert.Equal(t, 1, bin.Purge())
+	assert.Equal(t, 0, bin.Purge())
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"errors"
+	"sort"
+)
+
+// UserSnapshot is a read-only, point-in-time view of the users in a store
+type UserSnapshot interface {
+	GetAll() ([]User, error)
+	GetByID(id int64) (*User, error)
+	Count() (int, error)
+}
+
+// ErrSnapshotsUnsupported is returned by Snapshot for stores that cannot
+// provide consistent views
+var ErrSnapshotsUnsupported = errors.New("store does not support snapshots")
+
+// Snapshotter is implemented by stores that can provide consistent views for
+// long-running reads such as exports, so they never observe torn data
+type Snapshotter interface {
+	Snapshot() (UserSnapshot, error)
+}
+
+// Snapshot returns a consistent view of s, returning
+// ErrSnapshotsUnsupported when s is not a Snapshotter
+func Snapshot(s UserStore) (UserSnapshot, error) {
+	snapshotter, ok := s.(Snapshotter)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+	return snapshotter.Snapshot()
+}
+
+// MemorySnapshot is a point-in-time view of a MemoryUserStore. It shares
+// storage with the store until the store's next write.
+type MemorySnapshot struct {
+	users  map[int64]User
+	nextID int64
+}
+
+// GetAll returns all users in the snapshot ordered by ID
+func (s *MemorySnapshot) GetAll() ([]User, error) {
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// GetByID returns a user by ID as it was when the snapshot was taken
+func (s *MemorySnapshot) GetByID(id int64) (*User, error) {
+	user, exists := s.users[id]
+	if !exists {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+
+// Count returns the number of users in the snapshot
+func (s *MemorySnapshot) Count() (int, error) {
+	return len(s.users), nil
+}
@@ -0,0 +1,85 @@
+package store
+
+// TimedUserStore decorates a UserStore, timing each call, e.g. to break
+// down the latency of slow requests. Calls are named after the method.
+type TimedUserStore struct {
+	UserStore
+
+	// time starts timing a call to op, until the function it returns is
+	// called
+	time func(op string) func()
+}
+
+// NewTimedUserStore wraps inner, timing its calls with time
+func NewTimedUserStore(inner UserStore, time func(op string) func()) *TimedUserStore {
+	return &TimedUserStore{UserStore: inner, time: time}
+}
+
+func (s *TimedUserStore) GetAll() ([]User, error) {
+	defer s.time("GetAll")()
+	return s.UserStore.GetAll()
+}
+
+func (s *TimedUserStore) Count() (int, error) {
+	defer s.time("Count")()
+	return s.UserStore.Count()
+}
+
+func (s *TimedUserStore) GetByID(id int64) (*User, error) {
+	defer s.time("GetByID")()
+	return s.UserStore.GetByID(id)
+}
+
+func (s *TimedUserStore) GetByEmail(email string) (*User, error) {
+	defer s.time("GetByEmail")()
+	return s.UserStore.GetByEmail(email)
+}
+
+func (s *TimedUserStore) Create(user User) (*User, error) {
+	defer s.time("Create")()
+	return s.UserStore.Create(user)
+}
+
+// CreateMany implements BatchCreator, failing with ErrBatchUnsupported
+// when the inner store does not
+func (s *TimedUserStore) CreateMany(users []User) ([]User, error) {
+	defer s.time("CreateMany")()
+	return CreateMany(s.UserStore, users)
+}
+
+func (s *TimedUserStore) Update(id int64, user User) (*User, error) {
+	defer s.time("Update")()
+	return s.UserStore.Update(id, user)
+}
+
+func (s *TimedUserStore) Delete(id int64) error {
+	defer s.time("Delete")()
+	return s.UserStore.Delete(id)
+}
+
+// UpdateVersion implements VersionedWriter, failing with
+// ErrVersionsUnsupported when the inner store does not
+func (s *TimedUserStore) UpdateVersion(id, version int64, user User) (*User, error) {
+	defer s.time("UpdateVersion")()
+	return UpdateVersion(s.UserStore, id, version, user)
+}
+
+// DeleteVersion implements VersionedWriter, failing with
+// ErrVersionsUnsupported when the inner store does not
+func (s *TimedUserStore) DeleteVersion(id, version int64) error {
+	defer s.time("DeleteVersion")()
+	return DeleteVersion(s.UserStore, id, version)
+}
+
+// GetByIDs implements BatchGetter, letting the inner store look the users
+// up at once when it can
+func (s *TimedUserStore) GetByIDs(ids []int64) ([]User, error) {
+	defer s.time("GetByIDs")()
+	return GetUsers(s.UserStore, ids)
+}
+
+// Find implements Finder, letting the inner store apply filter when it can
+func (s *TimedUserStore) Find(filter UserFilter) ([]User, error) {
+	defer s.time("Find")()
+	return FindUsers(s.UserStore, filter)
+}
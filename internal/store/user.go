@@ -1,17 +1,429 @@
 package store
 
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/dazraf/go-api-example/internal/idgen"
+)
+
+// ErrInvalidID is returned for user IDs that are not positive integers
+// that fit an int64
+var ErrInvalidID = errors.New("invalid user ID")
+
+// IDPrefix starts user IDs written in the string form of a sequence, so
+// users-42 is the user 42
+const IDPrefix = "users"
+
+// ParseID parses a user ID from text, such as a path parameter or an auth
+// subject, written as a number or as a sequence ID like users-42. IDs are
+// positive, so zero and negative IDs are rejected before they reach a
+// store.
+func ParseID(s string) (int64, error) {
+	id, err := idgen.ParseInt(s, IDPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidID, s)
+	}
+	return id, nil
+}
+
 // User represents a user entity
 type User struct {
-	ID    int    `json:"id" example:"1"`
-	Name  string `json:"name" example:"John Doe"`
-	Email string `json:"email" example:"john@example.com"`
+	ID    int64  `json:"id" example:"1" readonly:"true"`
+	Name  string `json:"name" example:"John Doe" binding:"required"`
+	Email string `json:"email" example:"john@example.com" format:"email" binding:"required,email"`
+	// LastSeenAt is when the user last made a request, maintained by the
+	// store through RecordActivity
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" example:"2024-01-02T15:04:05Z" readonly:"true"`
+	// CreatedAt and UpdatedAt are maintained by the store. Users recorded
+	// before they were tracked have neither.
+	CreatedAt time.Time `json:"created_at,omitzero" example:"2024-01-01T09:00:00Z" readonly:"true"`
+	UpdatedAt time.Time `json:"updated_at,omitzero" example:"2024-01-02T10:30:00Z" readonly:"true"`
+	// Version is maintained by the store too: 1 when the user is created,
+	// then one more on each update. Activity does not change it. Users
+	// recorded before versions were tracked have none.
+	Version int64 `json:"version,omitempty" example:"3" readonly:"true"`
+}
+
+// In returns the user with its timestamps in loc, e.g. the caller's time
+// zone
+func (u User) In(loc *time.Location) User {
+	if u.LastSeenAt != nil {
+		lastSeen := u.LastSeenAt.In(loc)
+		u.LastSeenAt = &lastSeen
+	}
+	if !u.CreatedAt.IsZero() {
+		u.CreatedAt = u.CreatedAt.In(loc)
+	}
+	if !u.UpdatedAt.IsZero() {
+		u.UpdatedAt = u.UpdatedAt.In(loc)
+	}
+	return u
 }
 
 // UserStore defines the interface for user data operations
 type UserStore interface {
 	GetAll() ([]User, error)
-	GetByID(id int) (*User, error)
+	Count() (int, error)
+	GetByID(id int64) (*User, error)
+	GetByEmail(email string) (*User, error)
 	Create(user User) (*User, error)
-	Update(id int, user User) (*User, error)
-	Delete(id int) error
+	// Update replaces the user id, giving it the next version
+	Update(id int64, user User) (*User, error)
+	Delete(id int64) error
+}
+
+// Revisioner is implemented by stores that count their writes, letting
+// callers cache derived data until the revision changes
+type Revisioner interface {
+	Revision() uint64
+}
+
+// ActivityRecorder is implemented by stores that can record when users were
+// last seen without a full update
+type ActivityRecorder interface {
+	// RecordActivity moves each user's LastSeenAt forward to the given
+	// time, skipping users that no longer exist
+	RecordActivity(seen map[int64]time.Time) error
+}
+
+// Recoverer is implemented by stores that can put a deleted user back
+type Recoverer interface {
+	// Restore recreates a deleted user with its original ID
+	Restore(user User) (*User, error)
+}
+
+// Replacer is implemented by stores that can store a user exactly as given,
+// so replicas can copy writes made elsewhere
+type Replacer interface {
+	// Replace creates the user, or overwrites it when its ID exists,
+	// keeping every field including the ID and timestamps
+	Replace(user User) (*User, error)
+}
+
+// ErrDuplicateEmail is returned when writing a user whose email another
+// user already has, compared ignoring case, Unicode normalization and
+// punycode
+var ErrDuplicateEmail = errors.New("email already in use")
+
+// ErrVersionConflict is returned when writing a user from a version that
+// is no longer the stored one, because another write came first
+var ErrVersionConflict = errors.New("user was changed by another write")
+
+// ErrVersionsUnsupported is returned by UpdateVersion and DeleteVersion for
+// stores that cannot check versions as they write
+var ErrVersionsUnsupported = errors.New("store does not check versions")
+
+// VersionedWriter is implemented by stores that can write a user only while
+// it is at an expected version, checking it in the same step as the write.
+// Users stored before versions were tracked are at version 0.
+type VersionedWriter interface {
+	// UpdateVersion is Update, failing with ErrVersionConflict unless the
+	// user is at version
+	UpdateVersion(id, version int64, user User) (*User, error)
+	// DeleteVersion is Delete, failing with ErrVersionConflict unless the
+	// user is at version
+	DeleteVersion(id, version int64) error
+}
+
+// UpdateVersion updates the user id in s while it is at version, returning
+// ErrVersionsUnsupported when s is not a VersionedWriter
+func UpdateVersion(s UserStore, id, version int64, user User) (*User, error) {
+	writer, ok := s.(VersionedWriter)
+	if !ok {
+		return nil, ErrVersionsUnsupported
+	}
+	return writer.UpdateVersion(id, version, user)
+}
+
+// DeleteVersion deletes the user id from s while it is at version,
+// returning ErrVersionsUnsupported when s is not a VersionedWriter
+func DeleteVersion(s UserStore, id, version int64) error {
+	writer, ok := s.(VersionedWriter)
+	if !ok {
+		return ErrVersionsUnsupported
+	}
+	return writer.DeleteVersion(id, version)
+}
+
+// ErrBatchUnsupported is returned by CreateMany for stores that cannot
+// create users in a batch
+var ErrBatchUnsupported = errors.New("store does not create users in batches")
+
+// BatchCreator is implemented by stores that can create several users at
+// once, all or nothing
+type BatchCreator interface {
+	// CreateMany creates every user, assigning IDs in the order given, or
+	// none of them when it fails
+	CreateMany(users []User) ([]User, error)
+}
+
+// CreateMany creates every user in s or none of them, returning
+// ErrBatchUnsupported when s is not a BatchCreator
+func CreateMany(s UserStore, users []User) ([]User, error) {
+	creator, ok := s.(BatchCreator)
+	if !ok {
+		return nil, ErrBatchUnsupported
+	}
+	return creator.CreateMany(users)
+}
+
+// UserFilter selects users by when they were created and last updated, and
+// by text in their name and email, and orders them. Bounds are exclusive,
+// text matches substrings ignoring case, and zero fields do not filter.
+type UserFilter struct {
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	Name          string
+	Email         string
+	// Query matches users whose name or email contains it
+	Query string
+	// Where selects users by a condition parsed by ParseCondition, on top
+	// of the other fields. Nil selects every user.
+	Where Condition
+	// Sort orders the users, with ties broken by ID. Without it, stores
+	// return users in their own order.
+	Sort UserSort
+	// Collation orders sorted names and emails by the rules of a language,
+	// e.g. from ParseCollation. The zero language.Und compares bytes.
+	Collation language.Tag
+}
+
+// IsZero reports whether the filter selects every user, in the store's
+// own order
+func (f UserFilter) IsZero() bool {
+	return f.CreatedAfter.IsZero() && f.CreatedBefore.IsZero() && f.UpdatedAfter.IsZero() &&
+		f.Name == "" && f.Email == "" && f.Query == "" && f.Where == nil && len(f.Sort) == 0
+}
+
+// Matches reports whether user passes the filter
+func (f UserFilter) Matches(user User) bool {
+	if !f.CreatedAfter.IsZero() && !user.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !user.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if !f.UpdatedAfter.IsZero() && !user.UpdatedAt.After(f.UpdatedAfter) {
+		return false
+	}
+	if !containsFold(user.Name, f.Name) || !containsFold(user.Email, f.Email) {
+		return false
+	}
+	if f.Where != nil && !f.Where.Matches(user) {
+		return false
+	}
+	return containsFold(user.Name, f.Query) || containsFold(user.Email, f.Query)
+}
+
+// containsFold reports whether substr is within s, ignoring case and
+// Unicode normalization, so a decomposed é matches a precomposed one
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(norm.NFC.String(s)), strings.ToLower(norm.NFC.String(substr)))
+}
+
+// Fields users can be sorted by
+const (
+	SortID         = "id"
+	SortName       = "name"
+	SortEmail      = "email"
+	SortCreatedAt  = "created_at"
+	SortUpdatedAt  = "updated_at"
+	SortLastSeenAt = "last_seen_at"
+)
+
+// SortFields lists the fields users can be sorted by
+var SortFields = []string{SortID, SortName, SortEmail, SortCreatedAt, SortUpdatedAt, SortLastSeenAt}
+
+// ErrInvalidSort is returned for sorts naming unknown fields, or a field
+// more than once
+var ErrInvalidSort = errors.New("invalid sort")
+
+// SortKey orders users by a field
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// UserSort orders users by each key in turn
+type UserSort []SortKey
+
+// ParseSort parses sort keys such as name or -email, a leading - sorting
+// descending, e.g. from a comma separated sort parameter
+func ParseSort(keys []string) (UserSort, error) {
+	sort := make(UserSort, 0, len(keys))
+	for _, key := range keys {
+		field, descending := strings.CutPrefix(key, "-")
+		if !slices.Contains(SortFields, field) {
+			return nil, fmt.Errorf("%w: unknown field %q, expected one of %s", ErrInvalidSort, field, strings.Join(SortFields, ", "))
+		}
+		if slices.ContainsFunc(sort, func(k SortKey) bool { return k.Field == field }) {
+			return nil, fmt.Errorf("%w: field %q given twice", ErrInvalidSort, field)
+		}
+		sort = append(sort, SortKey{Field: field, Descending: descending})
+	}
+	return sort, nil
+}
+
+// Compare orders a and b by the sort, then by ID. Strings compare byte by
+// byte, and users never seen come before those seen.
+func (s UserSort) Compare(a, b User) int {
+	return s.compare(a, b, strings.Compare)
+}
+
+// Collate returns a comparison ordering users as Compare does, but with
+// names and emails in the collation order of a language, so accented
+// letters sort beside their base letters rather than after z. Collating
+// language.Und compares bytes. The comparison is not safe for concurrent
+// use.
+func (s UserSort) Collate(tag language.Tag) func(a, b User) int {
+	if tag == language.Und {
+		return s.Compare
+	}
+	collator := collate.New(tag)
+	return func(a, b User) int {
+		return s.compare(a, b, collator.CompareString)
+	}
+}
+
+// SortUsers sorts users by the filter's sort and collation, leaving them
+// in order when there is no sort
+func (f UserFilter) SortUsers(users []User) {
+	if len(f.Sort) > 0 {
+		slices.SortFunc(users, f.Sort.Collate(f.Collation))
+	}
+}
+
+func (s UserSort) compare(a, b User, compareStrings func(a, b string) int) int {
+	for _, key := range s {
+		var c int
+		switch key.Field {
+		case SortID:
+			c = cmp.Compare(a.ID, b.ID)
+		case SortName:
+			c = compareStrings(a.Name, b.Name)
+		case SortEmail:
+			c = compareStrings(a.Email, b.Email)
+		case SortCreatedAt:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case SortUpdatedAt:
+			c = a.UpdatedAt.Compare(b.UpdatedAt)
+		case SortLastSeenAt:
+			c = compareSeen(a.LastSeenAt, b.LastSeenAt)
+		}
+		if key.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// ErrInvalidCollation is returned for collations that are not languages
+var ErrInvalidCollation = errors.New("invalid collation")
+
+// ParseCollation parses the language of a collation, such as de or sv-SE.
+// Languages without rules of their own collate by the Unicode root order,
+// which already sorts accented letters beside their base letters. An empty
+// language, or und, is language.Und, comparing bytes.
+func ParseCollation(s string) (language.Tag, error) {
+	if s == "" {
+		return language.Und, nil
+	}
+	tag, err := language.Parse(s)
+	if err != nil {
+		return language.Und, fmt.Errorf("%w: %q is not a language", ErrInvalidCollation, s)
+	}
+	return tag, nil
+}
+
+// compareSeen orders last seen times, nil first
+func compareSeen(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+// BatchGetter is implemented by stores that can look several users up by ID
+// at once, rather than one request or lock per user
+type BatchGetter interface {
+	// GetByIDs returns the users with ids ordered by ID, skipping IDs with
+	// no user
+	GetByIDs(ids []int64) ([]User, error)
+}
+
+// GetUsers returns the users in s with ids ordered by ID, skipping IDs with
+// no user, letting s look them up at once when it is a BatchGetter
+func GetUsers(s UserStore, ids []int64) ([]User, error) {
+	if getter, ok := s.(BatchGetter); ok {
+		return getter.GetByIDs(ids)
+	}
+
+	users, err := s.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	found := make([]User, 0, len(ids))
+	for _, user := range users {
+		if wanted[user.ID] {
+			found = append(found, user)
+		}
+	}
+	slices.SortFunc(found, func(a, b User) int { return cmp.Compare(a.ID, b.ID) })
+	return found, nil
+}
+
+// sortedIDs returns ids sorted without duplicates, leaving ids as it is
+func sortedIDs(ids []int64) []int64 {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// Finder is implemented by stores that can apply a UserFilter themselves,
+// e.g. from an index, rather than have every user loaded and checked
+type Finder interface {
+	Find(filter UserFilter) ([]User, error)
+}
+
+// FindUsers returns the users in s that pass filter, letting s apply it when
+// it is a Finder
+func FindUsers(s UserStore, filter UserFilter) ([]User, error) {
+	if finder, ok := s.(Finder); ok {
+		return finder.Find(filter)
+	}
+
+	users, err := s.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]User, 0, len(users))
+	for _, user := range users {
+		if filter.Matches(user) {
+			matched = append(matched, user)
+		}
+	}
+	filter.SortUsers(matched)
+	return matched, nil
 }
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1", want: 1},
+		{input: "42", want: 42},
+		{input: strconv.FormatInt(math.MaxInt64, 10), want: math.MaxInt64},
+		{input: "9223372036854775808", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "1.5", wantErr: true},
+		{input: " 1", wantErr: true},
+		{input: "users-42", want: 42},
+		{input: "users-0", wantErr: true},
+		{input: "orgs-42", wantErr: true},
+		{input: "users-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			id, err := ParseID(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidID)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, id)
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		want    UserSort
+		wantErr string
+	}{
+		{name: "none", keys: nil, want: UserSort{}},
+		{name: "fields", keys: []string{"name", "-created_at"}, want: UserSort{{Field: SortName}, {Field: SortCreatedAt, Descending: true}}},
+		{name: "unknown field", keys: []string{"password"}, wantErr: `unknown field "password"`},
+		{name: "empty field", keys: []string{"-"}, wantErr: `unknown field ""`},
+		{name: "repeated field", keys: []string{"name", "-name"}, wantErr: `field "name" given twice`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort, err := ParseSort(tt.keys)
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrInvalidSort)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sort)
+		})
+	}
+}
+
+func TestUserSort_Compare(t *testing.T) {
+	seen := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	users := []User{
+		{ID: 1, Name: "Bea", Email: "b@example.com"},
+		{ID: 2, Name: "Adam", Email: "a@example.com", LastSeenAt: &seen},
+		{ID: 3, Name: "Bea", Email: "c@example.com"},
+	}
+	ids := func(sort UserSort) []int64 {
+		sorted := slices.Clone(users)
+		slices.SortFunc(sorted, sort.Compare)
+		var ids []int64
+		for _, user := range sorted {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{2, 1, 3}, ids(UserSort{{Field: SortName}}), "ties are broken by ID")
+	assert.Equal(t, []int64{1, 3, 2}, ids(UserSort{{Field: SortName, Descending: true}}))
+	assert.Equal(t, []int64{3, 1, 2}, ids(UserSort{{Field: SortName, Descending: true}, {Field: SortEmail, Descending: true}}))
+	assert.Equal(t, []int64{1, 3, 2}, ids(UserSort{{Field: SortLastSeenAt}}), "users never seen come first")
+	assert.Equal(t, []int64{3, 2, 1}, ids(UserSort{{Field: SortID, Descending: true}}))
+}
+
+func TestParseCollation(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    language.Tag
+		wantErr bool
+	}{
+		{name: "none", in: "", want: language.Und},
+		{name: "language", in: "de", want: language.German},
+		{name: "region", in: "sv-SE", want: language.MustParse("sv-SE")},
+		{name: "undetermined", in: "und", want: language.Und},
+		{name: "not a language", in: "not a language", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, err := ParseCollation(tt.in)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidCollation)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tag)
+		})
+	}
+}
+
+func TestUserSort_Collate(t *testing.T) {
+	users := []User{
+		{ID: 1, Name: "Zoe"},
+		{ID: 2, Name: "Ärla"},
+		{ID: 3, Name: "Adam"},
+	}
+	ids := func(filter UserFilter) []int64 {
+		sorted := slices.Clone(users)
+		filter.SortUsers(sorted)
+		var ids []int64
+		for _, user := range sorted {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+	byName := UserSort{{Field: SortName}}
+
+	assert.Equal(t, []int64{1, 2, 3}, ids(UserFilter{}), "users are left in order without a sort")
+	assert.Equal(t, []int64{3, 1, 2}, ids(UserFilter{Sort: byName}), "bytes put accented letters last")
+	assert.Equal(t, []int64{3, 2, 1}, ids(UserFilter{Sort: byName, Collation: language.German}))
+	assert.Equal(t, []int64{3, 1, 2}, ids(UserFilter{Sort: byName, Collation: language.Swedish}), "Swedish sorts ä after z")
+	assert.Equal(t, []int64{1, 2, 3}, ids(UserFilter{Sort: UserSort{{Field: SortName, Descending: true}}, Collation: language.German}))
+}
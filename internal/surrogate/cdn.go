@@ -0,0 +1,149 @@
+package surrogate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CDN APIs purged by the adapters, overridable for testing
+const (
+	CloudflareURL = "https://api.cloudflare.com/client/v4"
+	FastlyURL     = "https://api.fastly.com"
+)
+
+// Keys purged per request, the most each CDN accepts
+const (
+	cloudflareMaxKeys = 30
+	fastlyMaxKeys     = 256
+)
+
+// CloudflareOptions configures a Cloudflare purger
+type CloudflareOptions struct {
+	// URL is the Cloudflare API, CloudflareURL by default
+	URL    string
+	ZoneID string
+	// Token is an API token allowed to purge the zone's cache
+	Token  string
+	Client *http.Client
+}
+
+// Cloudflare purges cache tags through the Cloudflare API. Cloudflare reads
+// tags from the Cache-Tag header, which CacheTags sets from surrogate keys.
+type Cloudflare struct {
+	opts CloudflareOptions
+}
+
+// NewCloudflare creates a purger for the zone opts.ZoneID
+func NewCloudflare(opts CloudflareOptions) *Cloudflare {
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+	if opts.URL == "" {
+		opts.URL = CloudflareURL
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Cloudflare{opts: opts}
+}
+
+// cloudflareResponse is the envelope of Cloudflare API responses
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Purge purges the responses tagged with keys, in as many requests as
+// Cloudflare needs
+func (c *Cloudflare) Purge(ctx context.Context, keys ...string) error {
+	for batch := range slices.Chunk(keys, cloudflareMaxKeys) {
+		body, err := json.Marshal(map[string][]string{"tags": batch})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.URL+"/zones/"+c.opts.ZoneID+"/purge_cache", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create Cloudflare purge request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+
+		resp, err := c.opts.Client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to purge Cloudflare cache: %w", err)
+		}
+		var result cloudflareResponse
+		decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 || decodeErr != nil || !result.Success {
+			if len(result.Errors) > 0 {
+				return fmt.Errorf("cloudflare returned %s: %s (code %d)", resp.Status, result.Errors[0].Message, result.Errors[0].Code)
+			}
+			return fmt.Errorf("cloudflare returned %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// FastlyOptions configures a Fastly purger
+type FastlyOptions struct {
+	// URL is the Fastly API, FastlyURL by default
+	URL       string
+	ServiceID string
+	// Token is an API token allowed to purge the service
+	Token string
+	// Soft marks responses stale rather than removing them, so Fastly can
+	// still serve them while revalidating or if the API is down
+	Soft   bool
+	Client *http.Client
+}
+
+// Fastly purges surrogate keys through the Fastly API
+type Fastly struct {
+	opts FastlyOptions
+}
+
+// NewFastly creates a purger for the service opts.ServiceID
+func NewFastly(opts FastlyOptions) *Fastly {
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+	if opts.URL == "" {
+		opts.URL = FastlyURL
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Fastly{opts: opts}
+}
+
+// Purge purges the responses tagged with keys, in as many requests as
+// Fastly needs
+func (f *Fastly) Purge(ctx context.Context, keys ...string) error {
+	for batch := range slices.Chunk(keys, fastlyMaxKeys) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.opts.URL+"/service/"+f.opts.ServiceID+"/purge", nil)
+		if err != nil {
+			return fmt.Errorf("failed to create Fastly purge request: %w", err)
+		}
+		req.Header.Set("Fastly-Key", f.opts.Token)
+		req.Header.Set(Header, strings.Join(batch, " "))
+		if f.opts.Soft {
+			req.Header.Set("Fastly-Soft-Purge", "1")
+		}
+
+		resp, err := f.opts.Client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to purge Fastly cache: %w", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("fastly returned %s", resp.Status)
+		}
+	}
+	return nil
+}
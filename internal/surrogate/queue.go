@@ -0,0 +1,146 @@
+package surrogate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when keys cannot be queued for purging
+var ErrQueueFull = errors.New("purge queue is full")
+
+// QueueOptions configures a Queue
+type QueueOptions struct {
+	// Size is the number of purges that can wait to be sent
+	Size int
+	// MaxAttempts is how many times sending a purge is tried
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubling after
+	// each further failure
+	InitialBackoff time.Duration
+}
+
+// Queue is a Purger that purges in the background through another,
+// retrying failures, so a slow or failing CDN never delays a request.
+// Purges queued while one is sent are merged into the next.
+type Queue struct {
+	purger Purger
+	opts   QueueOptions
+	jobs   chan []string
+
+	// stopping is cancelled to abandon retries when Stop runs out of time
+	stopping context.Context
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+}
+
+// NewQueue creates a queue that purges through purger once started
+func NewQueue(purger Purger, opts QueueOptions) *Queue {
+	if opts.Size <= 0 {
+		opts.Size = 1000
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 500 * time.Millisecond
+	}
+
+	stopping, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		purger:   purger,
+		opts:     opts,
+		jobs:     make(chan []string, opts.Size),
+		stopping: stopping,
+		cancel:   cancel,
+	}
+}
+
+// Purge queues keys for purging without waiting for it
+func (q *Queue) Purge(_ context.Context, keys ...string) error {
+	select {
+	case q.jobs <- keys:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Start starts purging queued keys
+func (q *Queue) Start() {
+	q.workers.Go(func() {
+		for keys := range q.jobs {
+			q.deliver(q.merge(keys))
+		}
+	})
+}
+
+// merge adds the keys of every purge already waiting to keys, returning
+// them sorted, once each
+func (q *Queue) merge(keys []string) []string {
+	merged := slices.Clone(keys)
+	for {
+		select {
+		case more, ok := <-q.jobs:
+			if !ok {
+				return compact(merged)
+			}
+			merged = append(merged, more...)
+		default:
+			return compact(merged)
+		}
+	}
+}
+
+func compact(keys []string) []string {
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// Stop sends the purges already queued, giving up on those left when ctx
+// expires. Nothing may be queued once Stop has been called.
+func (q *Queue) Stop(ctx context.Context) error {
+	close(q.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return fmt.Errorf("failed to send queued purges: %w", ctx.Err())
+	}
+}
+
+// deliver purges keys, retrying with exponential backoff
+func (q *Queue) deliver(keys []string) {
+	backoff := q.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := q.purger.Purge(q.stopping, keys...)
+		if err == nil {
+			return
+		}
+		if attempt >= q.opts.MaxAttempts || q.stopping.Err() != nil {
+			logger.Error("Failed to purge cached responses, dropping the purge", "keys", keys, "attempts", attempt, "error", err)
+			return
+		}
+
+		logger.Warn("Failed to purge cached responses, retrying", "keys", keys, "attempt", attempt, "backoff", backoff, "error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-q.stopping.Done():
+			timer.Stop()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,120 @@
+// Package surrogate names the resources in responses with Surrogate-Key
+// headers, so caching proxies such as Fastly and Varnish in front of the
+// API can cache responses for long and purge every response containing a
+// resource the moment it changes:
+//
+//	GET /api/v1/users/42  →  Surrogate-Key: user-42
+//	GET /api/v1/users     →  Surrogate-Key: users-collection
+//
+// A write to user 42 purges both keys through a Purger.
+package surrogate
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// Header lists a response's keys, separated by spaces. Proxies strip it
+// before responses reach clients.
+const Header = "Surrogate-Key"
+
+// CacheTagHeader is where Cloudflare reads the same keys, separated by
+// commas
+const CacheTagHeader = "Cache-Tag"
+
+// UsersCollection keys responses listing users, which change whenever any
+// user does
+const UsersCollection = "users-collection"
+
+// logger logs purges that are only logged
+var logger = logging.Named(logging.Events)
+
+// User returns the key of responses containing the user id
+func User(id int64) string {
+	return "user-" + strconv.FormatInt(id, 10)
+}
+
+// UserChanged returns the keys to purge when the user id changes: its own
+// responses, and those listing users
+func UserChanged(id int64) []string {
+	return []string{User(id), UsersCollection}
+}
+
+// Add adds keys to the response's Surrogate-Key header, once each
+func Add(w http.ResponseWriter, keys ...string) {
+	existing := strings.Fields(w.Header().Get(Header))
+	for _, key := range keys {
+		if !slices.Contains(existing, key) {
+			existing = append(existing, key)
+		}
+	}
+	if len(existing) > 0 {
+		w.Header().Set(Header, strings.Join(existing, " "))
+	}
+}
+
+// CacheTags copies the surrogate keys of every response into its Cache-Tag
+// header, for Cloudflare
+func CacheTags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&cacheTagWriter{ResponseWriter: w}, r)
+	})
+}
+
+// cacheTagWriter sets Cache-Tag before the headers are written
+type cacheTagWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (c *cacheTagWriter) WriteHeader(status int) {
+	if !c.written {
+		c.written = true
+		if keys := strings.Fields(c.Header().Get(Header)); len(keys) > 0 {
+			c.Header().Set(CacheTagHeader, strings.Join(keys, ","))
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheTagWriter) Write(b []byte) (int, error) {
+	if !c.written {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (c *cacheTagWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Purger purges the cached responses tagged with any of keys
+type Purger interface {
+	Purge(ctx context.Context, keys ...string) error
+}
+
+// PurgerFunc adapts a function to a Purger
+type PurgerFunc func(ctx context.Context, keys ...string) error
+
+func (f PurgerFunc) Purge(ctx context.Context, keys ...string) error {
+	return f(ctx, keys...)
+}
+
+type logPurger struct{}
+
+// NewLog returns a Purger that logs the keys it would purge, for
+// development or proxies that only expire responses
+func NewLog() Purger {
+	return logPurger{}
+}
+
+func (logPurger) Purge(ctx context.Context, keys ...string) error {
+	logger.InfoContext(ctx, "Purge", "keys", keys)
+	return nil
+}
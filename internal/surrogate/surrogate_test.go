@@ -0,0 +1,202 @@
+package surrogate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		keys     []string
+		expected string
+	}{
+		{name: "adds keys", keys: []string{User(42), UsersCollection}, expected: "user-42 users-collection"},
+		{name: "appends to existing keys", existing: "user-1", keys: []string{User(2)}, expected: "user-1 user-2"},
+		{name: "adds keys once", existing: "user-1", keys: []string{User(1), User(1)}, expected: "user-1"},
+		{name: "no keys", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if tt.existing != "" {
+				w.Header().Set(Header, tt.existing)
+			}
+			Add(w, tt.keys...)
+			assert.Equal(t, tt.expected, w.Header().Get(Header))
+		})
+	}
+}
+
+func TestUserChanged(t *testing.T) {
+	assert.Equal(t, []string{"user-42", "users-collection"}, UserChanged(42))
+}
+
+func TestCacheTags(t *testing.T) {
+	handler := CacheTags(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Add(w, User(42), UsersCollection)
+		_, _ = w.Write([]byte("{}"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "user-42,users-collection", w.Header().Get(CacheTagHeader))
+}
+
+// fakePurger records the keys purged, failing the first failures times
+type fakePurger struct {
+	mutex    sync.Mutex
+	failures int
+	attempts int
+	purged   [][]string
+	// block holds purges until it is closed, when set
+	block chan struct{}
+}
+
+func (p *fakePurger) Purge(ctx context.Context, keys ...string) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.attempts++
+	if p.attempts <= p.failures {
+		return errors.New("purge failed")
+	}
+	p.purged = append(p.purged, keys)
+	return nil
+}
+
+func TestQueue_RetriesFailedPurges(t *testing.T) {
+	tests := []struct {
+		name           string
+		failures       int
+		expectedPurges int
+		expectedTries  int
+	}{
+		{name: "purges first time", failures: 0, expectedPurges: 1, expectedTries: 1},
+		{name: "retries until purged", failures: 2, expectedPurges: 1, expectedTries: 3},
+		{name: "drops after max attempts", failures: 5, expectedPurges: 0, expectedTries: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purger := &fakePurger{failures: tt.failures}
+			queue := NewQueue(purger, QueueOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+			queue.Start()
+
+			require.NoError(t, queue.Purge(context.Background(), UserChanged(42)...))
+			require.NoError(t, queue.Stop(context.Background()))
+
+			assert.Len(t, purger.purged, tt.expectedPurges)
+			assert.Equal(t, tt.expectedTries, purger.attempts)
+		})
+	}
+}
+
+func TestQueue_MergesWaitingPurges(t *testing.T) {
+	purger := &fakePurger{block: make(chan struct{})}
+	queue := NewQueue(purger, QueueOptions{})
+	queue.Start()
+
+	// The first purge is sent and blocks, so the rest wait and are merged
+	require.NoError(t, queue.Purge(context.Background(), UserChanged(1)...))
+	require.Eventually(t, func() bool { return len(queue.jobs) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, queue.Purge(context.Background(), UserChanged(2)...))
+	require.NoError(t, queue.Purge(context.Background(), UserChanged(1)...))
+	close(purger.block)
+	require.NoError(t, queue.Stop(context.Background()))
+
+	assert.Equal(t, [][]string{
+		{"user-1", "users-collection"},
+		{"user-1", "user-2", "users-collection"},
+	}, purger.purged)
+}
+
+func TestQueue_FullAndStopDeadline(t *testing.T) {
+	purger := &fakePurger{failures: 1000}
+	queue := NewQueue(purger, QueueOptions{Size: 1, MaxAttempts: 1000, InitialBackoff: time.Hour})
+
+	require.NoError(t, queue.Purge(context.Background(), User(1)))
+	assert.ErrorIs(t, queue.Purge(context.Background(), User(2)), ErrQueueFull)
+
+	// The retry is abandoned when the deadline passes
+	queue.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, queue.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestCloudflare(t *testing.T) {
+	var requests []map[string][]string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/zones/zone-1/purge_cache", r.URL.Path)
+		assert.Equal(t, "Bearer token-1", r.Header.Get("Authorization"))
+		var body map[string][]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, body)
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`{"success":true,"errors":[]}`))
+		} else {
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`))
+		}
+	}))
+	defer server.Close()
+	purger := NewCloudflare(CloudflareOptions{URL: server.URL, ZoneID: "zone-1", Token: "token-1"})
+
+	keys := make([]string, 31)
+	for i := range keys {
+		keys[i] = User(int64(i))
+	}
+	require.NoError(t, purger.Purge(context.Background(), keys...))
+	require.Len(t, requests, 2, "Cloudflare takes 30 tags a request")
+	assert.Len(t, requests[0]["tags"], 30)
+	assert.Equal(t, []string{"user-30"}, requests[1]["tags"])
+
+	status = http.StatusForbidden
+	err := purger.Purge(context.Background(), User(1))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Authentication error")
+}
+
+func TestFastly(t *testing.T) {
+	var requests []http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/service/service-1/purge", r.URL.Path)
+		requests = append(requests, r.Header.Clone())
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	purger := NewFastly(FastlyOptions{URL: server.URL, ServiceID: "service-1", Token: "token-1", Soft: true})
+
+	keys := make([]string, 257)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+	}
+	require.NoError(t, purger.Purge(context.Background(), keys...))
+	require.Len(t, requests, 2, "Fastly takes 256 keys a request")
+	assert.Equal(t, "token-1", requests[0].Get("Fastly-Key"))
+	assert.Equal(t, "1", requests[0].Get("Fastly-Soft-Purge"))
+	assert.Len(t, strings.Fields(requests[0].Get(Header)), 256)
+	assert.Equal(t, "user-256", requests[1].Get(Header))
+
+	status = http.StatusUnauthorized
+	assert.Error(t, purger.Purge(context.Background(), User(1)))
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+)
+
+// Listeners returns no listeners, as socket activation passes descriptors
+// only on Unix. The environment variables are cleared all the same, so
+// child processes do not inherit them.
+func Listeners() ([]net.Listener, error) {
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	return nil, nil
+}
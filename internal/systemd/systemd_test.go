@@ -0,0 +1,87 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListeners_NotActivated(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  string
+		fds  string
+	}{
+		{name: "unset"},
+		{name: "other process", pid: "1", fds: "1"},
+		{name: "no descriptors", pid: strconv.Itoa(os.Getpid()), fds: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			listeners, err := Listeners()
+			require.NoError(t, err)
+			assert.Empty(t, listeners)
+			assert.Empty(t, os.Getenv("LISTEN_FDS"))
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Run("without a service manager", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		sent, err := Notify(Ready)
+		require.NoError(t, err)
+		assert.False(t, sent)
+	})
+
+	t.Run("sends the state", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		require.NoError(t, err)
+		defer conn.Close()
+		t.Setenv("NOTIFY_SOCKET", path)
+
+		sent, err := Notify(Ready)
+		require.NoError(t, err)
+		assert.True(t, sent)
+
+		buf := make([]byte, 64)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, Ready, string(buf[:n]))
+	})
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		usec     string
+		pid      string
+		expected time.Duration
+	}{
+		{name: "disabled"},
+		{name: "enabled", usec: "30000000", expected: 30 * time.Second},
+		{name: "for this process", usec: "2000000", pid: strconv.Itoa(os.Getpid()), expected: 2 * time.Second},
+		{name: "for another process", usec: "2000000", pid: "1"},
+		{name: "invalid", usec: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			assert.Equal(t, tt.expected, WatchdogInterval())
+		})
+	}
+}
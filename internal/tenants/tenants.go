@@ -0,0 +1,347 @@
+// Package tenants keeps per-tenant overrides of the server's settings: the
+// rate limit profile, feature flags, the email domains users may have and
+// webhook endpoints. Middleware resolves the tenant of each request from a
+// header and puts the tenant's settings in the request context, where the
+// rate limiter, the user handlers and webhook delivery read them.
+package tenants
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/email"
+	"github.com/dazraf/go-api-example/internal/middleware"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// DefaultHeader names the tenant of a request
+const DefaultHeader = "X-Tenant-ID"
+
+// Events lists the events tenant webhooks can receive
+var Events = []string{notify.EventUserCreated, notify.EventUserUpdated}
+
+var (
+	// ErrNotFound is returned for tenants without settings
+	ErrNotFound = errors.New("tenant not found")
+	// ErrInvalidID is returned for tenant IDs that are not lowercase slugs
+	ErrInvalidID = errors.New("tenant IDs are 1-64 lowercase letters, digits and dashes, starting with a letter or digit")
+	// ErrInvalid is returned for settings with a bad value
+	ErrInvalid = errors.New("invalid tenant settings")
+)
+
+var (
+	idPattern      = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+	featurePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+)
+
+// Webhook is an endpoint receiving the user events of a tenant's requests
+type Webhook struct {
+	URL string `json:"url" example:"https://acme.example.com/hooks/users"`
+	// Events are the events posted to the URL
+	Events []string `json:"events" example:"user.created,user.updated"`
+	// Secret signs bodies like subscriptions' secrets; it is set but never
+	// shown
+	Secret    string `json:"secret,omitempty" example:"s3cret"`
+	HasSecret bool   `json:"has_secret" example:"true"`
+}
+
+// Settings override the server's for a tenant. Unset fields use the
+// server's.
+type Settings struct {
+	// RateProfile is the rate limit profile the tenant's requests count
+	// against instead of the default. A profile admins set for a user
+	// still applies to that user's requests.
+	RateProfile string `json:"rate_profile,omitempty" example:"elevated"`
+	// Features turns feature flags on or off over the server's defaults
+	Features map[string]bool `json:"features,omitempty"`
+	// EmailDomains are the only domains users' email addresses may have;
+	// any domain is allowed when empty
+	EmailDomains []string `json:"email_domains,omitempty" example:"acme.example.com"`
+	// Webhooks receive the user events of the tenant's requests, besides
+	// the subscriptions admins register
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+}
+
+// Tenant is a tenant's settings
+type Tenant struct {
+	ID       string   `json:"id" example:"acme"`
+	Settings Settings `json:"settings"`
+}
+
+// AllowsEmail reports whether users may have the email address
+func (s Settings) AllowsEmail(address string) bool {
+	if len(s.EmailDomains) == 0 {
+		return true
+	}
+	domain := email.Domain(address)
+	if domain == "" {
+		return false
+	}
+	// Domains match whether written in Unicode or punycode
+	return slices.ContainsFunc(s.EmailDomains, func(allowed string) bool { return email.NormalizeDomain(allowed) == domain })
+}
+
+// Feature reports whether the feature flag name is on
+func (s Settings) Feature(name string) bool {
+	return s.Features[name]
+}
+
+// Options configures a Store
+type Options struct {
+	// Profiles are the rate limit profiles tenants can use
+	Profiles []string
+	// Features are the feature flags of requests for no tenant, and of
+	// tenants that do not override them
+	Features map[string]bool
+}
+
+// Store holds tenants' settings in memory
+type Store struct {
+	mutex   sync.RWMutex
+	tenants map[string]Settings
+	opts    Options
+}
+
+// NewStore creates a store in which every tenant has the server's settings
+func NewStore(opts Options) *Store {
+	return &Store{tenants: make(map[string]Settings), opts: opts}
+}
+
+// Get returns the settings tenant id overrides
+func (s *Store) Get(id string) (Settings, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	settings, ok := s.tenants[id]
+	if !ok {
+		return Settings{}, ErrNotFound
+	}
+	return view(settings), nil
+}
+
+// List returns the tenants with settings, ordered by ID
+func (s *Store) List() []Tenant {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tenants := make([]Tenant, 0, len(s.tenants))
+	for id, settings := range s.tenants {
+		tenants = append(tenants, Tenant{ID: id, Settings: view(settings)})
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+	return tenants
+}
+
+// Put replaces the settings tenant id overrides
+func (s *Store) Put(id string, settings Settings) (Settings, error) {
+	if !idPattern.MatchString(id) {
+		return Settings{}, ErrInvalidID
+	}
+	settings, err := s.normalize(settings)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tenants[id] = settings
+	return view(settings), nil
+}
+
+// Delete drops tenant id's overrides, so it has the server's settings
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.tenants[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.tenants, id)
+	return nil
+}
+
+// Export returns the tenants with settings, ordered by ID, with the secrets
+// of their webhooks
+func (s *Store) Export() []Tenant {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tenants := make([]Tenant, 0, len(s.tenants))
+	for id, settings := range s.tenants {
+		secrets := view(settings)
+		for i, webhook := range settings.Webhooks {
+			secrets.Webhooks[i].Secret = webhook.Secret
+		}
+		tenants = append(tenants, Tenant{ID: id, Settings: secrets})
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+	return tenants
+}
+
+// Import replaces the settings of each of tenants. Nothing is imported
+// unless every tenant is valid.
+func (s *Store) Import(tenants []Tenant) error {
+	imported := make([]Tenant, len(tenants))
+	for i, tenant := range tenants {
+		if !idPattern.MatchString(tenant.ID) {
+			return fmt.Errorf("tenant %q: %w", tenant.ID, ErrInvalidID)
+		}
+		settings, err := s.normalize(tenant.Settings)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+		imported[i] = Tenant{ID: tenant.ID, Settings: settings}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, tenant := range imported {
+		s.tenants[tenant.ID] = tenant.Settings
+	}
+	return nil
+}
+
+// Resolve returns the settings in effect for tenant id, its overrides over
+// the server's defaults. The empty ID is no tenant.
+func (s *Store) Resolve(id string) Settings {
+	s.mutex.RLock()
+	settings := s.tenants[id]
+	s.mutex.RUnlock()
+
+	features := maps.Clone(s.opts.Features)
+	if features == nil {
+		features = make(map[string]bool, len(settings.Features))
+	}
+	maps.Copy(features, settings.Features)
+	settings.Features = features
+	return settings
+}
+
+// Middleware resolves the tenant each request names in header and puts it
+// and its settings in the request context. Requests naming no tenant get
+// the server's settings; those naming an invalid one are refused.
+func (s *Store) Middleware(header string) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			ctx := r.Context()
+			if id != "" {
+				if !idPattern.MatchString(id) {
+					w.Header().Set("Content-Type", "application/json; charset=utf-8")
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid %s: %s", header, ErrInvalidID), "code": string(apierrors.BadRequest)})
+					return
+				}
+				ctx = reqctx.WithTenant(ctx, id)
+			}
+			ctx = WithSettings(ctx, s.Resolve(id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// normalize validates settings, lowercasing and sorting email domains and
+// webhook events so equal settings compare equal
+func (s *Store) normalize(settings Settings) (Settings, error) {
+	if settings.RateProfile != "" && !slices.Contains(s.opts.Profiles, settings.RateProfile) {
+		return Settings{}, fmt.Errorf("%w: unknown rate limit profile %q", ErrInvalid, settings.RateProfile)
+	}
+	for name := range settings.Features {
+		if !featurePattern.MatchString(name) {
+			return Settings{}, fmt.Errorf("%w: feature %q is not a lowercase name", ErrInvalid, name)
+		}
+	}
+	settings.Features = maps.Clone(settings.Features)
+
+	domains := make([]string, 0, len(settings.EmailDomains))
+	for _, domain := range settings.EmailDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || strings.ContainsAny(domain, "@/ ") {
+			return Settings{}, fmt.Errorf("%w: %q is not an email domain", ErrInvalid, domain)
+		}
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	settings.EmailDomains = domains
+
+	webhooks := make([]Webhook, len(settings.Webhooks))
+	for i, webhook := range settings.Webhooks {
+		target, err := url.Parse(webhook.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return Settings{}, fmt.Errorf("%w: webhook url must be an absolute http or https URL", ErrInvalid)
+		}
+		if len(webhook.Events) == 0 {
+			return Settings{}, fmt.Errorf("%w: subscribe webhooks to at least one of %s", ErrInvalid, strings.Join(Events, ", "))
+		}
+		events := make([]string, 0, len(webhook.Events))
+		for _, event := range webhook.Events {
+			if !slices.Contains(Events, event) {
+				return Settings{}, fmt.Errorf("%w: unknown event %q, expected one of %s", ErrInvalid, event, strings.Join(Events, ", "))
+			}
+			if !slices.Contains(events, event) {
+				events = append(events, event)
+			}
+		}
+		slices.Sort(events)
+		webhooks[i] = Webhook{URL: webhook.URL, Events: events, Secret: webhook.Secret, HasSecret: webhook.Secret != ""}
+	}
+	settings.Webhooks = webhooks
+	return settings, nil
+}
+
+// view returns a copy of settings for callers, without webhook secrets
+func view(settings Settings) Settings {
+	settings.Features = maps.Clone(settings.Features)
+	settings.EmailDomains = slices.Clone(settings.EmailDomains)
+	webhooks := make([]Webhook, len(settings.Webhooks))
+	for i, webhook := range settings.Webhooks {
+		webhook.Events = slices.Clone(webhook.Events)
+		webhook.Secret = ""
+		webhooks[i] = webhook
+	}
+	settings.Webhooks = webhooks
+	return settings
+}
+
+// settingsKey is the context key of the request's tenant settings
+type settingsKey struct{}
+
+// WithSettings returns a context carrying the settings in effect for the
+// request's tenant
+func WithSettings(ctx context.Context, settings Settings) context.Context {
+	return context.WithValue(ctx, settingsKey{}, settings)
+}
+
+// SettingsFrom returns the settings in effect for the request's tenant, if
+// tenants are enabled
+func SettingsFrom(ctx context.Context) (Settings, bool) {
+	settings, ok := ctx.Value(settingsKey{}).(Settings)
+	return settings, ok
+}
+
+// Feature reports whether the feature flag name is on for the request's
+// tenant; flags are off when tenants are not enabled
+func Feature(ctx context.Context, name string) bool {
+	settings, _ := SettingsFrom(ctx)
+	return settings.Feature(name)
+}
@@ -0,0 +1,148 @@
+package tenants
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func TestStore_Put(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		settings Settings
+		want     Settings
+		wantErr  error
+	}{
+		{
+			name: "normalized",
+			id:   "acme",
+			settings: Settings{
+				RateProfile:  "elevated",
+				Features:     map[string]bool{"bulk_export": true},
+				EmailDomains: []string{"Example.COM", "acme.example.com", "example.com"},
+				Webhooks:     []Webhook{{URL: "https://acme.example.com/hooks", Events: []string{notify.EventUserUpdated, notify.EventUserCreated}, Secret: "s3cret"}},
+			},
+			want: Settings{
+				RateProfile:  "elevated",
+				Features:     map[string]bool{"bulk_export": true},
+				EmailDomains: []string{"acme.example.com", "example.com"},
+				Webhooks:     []Webhook{{URL: "https://acme.example.com/hooks", Events: []string{notify.EventUserCreated, notify.EventUserUpdated}, HasSecret: true}},
+			},
+		},
+		{name: "invalid id", id: "Acme Corp", wantErr: ErrInvalidID},
+		{name: "unknown profile", id: "acme", settings: Settings{RateProfile: "unlimited"}, wantErr: ErrInvalid},
+		{name: "invalid feature", id: "acme", settings: Settings{Features: map[string]bool{"Bulk Export": true}}, wantErr: ErrInvalid},
+		{name: "invalid domain", id: "acme", settings: Settings{EmailDomains: []string{"@example.com"}}, wantErr: ErrInvalid},
+		{name: "relative webhook", id: "acme", settings: Settings{Webhooks: []Webhook{{URL: "/hooks", Events: []string{notify.EventUserCreated}}}}, wantErr: ErrInvalid},
+		{name: "webhook without events", id: "acme", settings: Settings{Webhooks: []Webhook{{URL: "https://acme.example.com/hooks"}}}, wantErr: ErrInvalid},
+		{name: "unknown event", id: "acme", settings: Settings{Webhooks: []Webhook{{URL: "https://acme.example.com/hooks", Events: []string{"user.deleted"}}}}, wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := NewStore(Options{Profiles: []string{"default", "elevated"}})
+			settings, err := tenants.Put(tt.id, tt.settings)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, settings)
+
+			got, err := tenants.Get(tt.id)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, []Tenant{{ID: tt.id, Settings: tt.want}}, tenants.List())
+
+			// Resolved settings keep the secret, for delivery
+			assert.Equal(t, "s3cret", tenants.Resolve(tt.id).Webhooks[0].Secret)
+		})
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	tenants := NewStore(Options{})
+	_, err := tenants.Put("acme", Settings{EmailDomains: []string{"acme.example.com"}})
+	require.NoError(t, err)
+
+	require.NoError(t, tenants.Delete("acme"))
+	_, err = tenants.Get("acme")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, tenants.Delete("acme"), ErrNotFound)
+	assert.Empty(t, tenants.Resolve("acme").EmailDomains)
+}
+
+func TestSettings_AllowsEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		email   string
+		want    bool
+	}{
+		{name: "any domain", email: "john@example.com", want: true},
+		{name: "allowed domain", domains: []string{"acme.example.com"}, email: "john@ACME.example.com", want: true},
+		{name: "other domain", domains: []string{"acme.example.com"}, email: "john@example.com"},
+		{name: "subdomain", domains: []string{"example.com"}, email: "john@acme.example.com"},
+		{name: "no domain", domains: []string{"example.com"}, email: "john"},
+		{name: "internationalized domain", domains: []string{"bücher.de"}, email: "jörg@BÜCHER.de", want: true},
+		{name: "internationalized domain in punycode", domains: []string{"bücher.de"}, email: "jörg@xn--bcher-kva.de", want: true},
+		{name: "allowed in punycode", domains: []string{"xn--bcher-kva.de"}, email: "jörg@bücher.de", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Settings{EmailDomains: tt.domains}.AllowsEmail(tt.email))
+		})
+	}
+}
+
+func TestStore_Middleware(t *testing.T) {
+	tenants := NewStore(Options{Features: map[string]bool{"bulk_export": false, "dark_mode": true}})
+	_, err := tenants.Put("acme", Settings{Features: map[string]bool{"bulk_export": true}, EmailDomains: []string{"acme.example.com"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		header      string
+		wantStatus  int
+		wantTenant  string
+		wantExport  bool
+		wantDomains []string
+	}{
+		{name: "tenant with overrides", header: "acme", wantStatus: http.StatusOK, wantTenant: "acme", wantExport: true, wantDomains: []string{"acme.example.com"}},
+		{name: "tenant without overrides", header: "globex", wantStatus: http.StatusOK, wantTenant: "globex"},
+		{name: "no tenant", wantStatus: http.StatusOK},
+		{name: "invalid tenant", header: "../acme", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				tenant   string
+				settings Settings
+			)
+			handler := tenants.Middleware(DefaultHeader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant, _ = reqctx.Tenant(r.Context())
+				settings, _ = SettingsFrom(r.Context())
+				// Defaults the tenant does not override still apply
+				assert.True(t, Feature(r.Context(), "dark_mode"))
+			}))
+
+			req := httptest.NewRequest("GET", "/api/v1/users", nil)
+			if tt.header != "" {
+				req.Header.Set(DefaultHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantTenant, tenant)
+			assert.Equal(t, tt.wantExport, settings.Feature("bulk_export"))
+			assert.Equal(t, tt.wantDomains, settings.EmailDomains)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Package tracing reads and writes distributed tracing headers in the W3C
+// Trace Context and Zipkin B3 formats, so logs can be correlated across
+// services without a full tracing SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// Tracing headers
+const (
+	TraceParentHeader    = "traceparent"
+	TraceResponseHeader  = "traceresponse"
+	B3Header             = "b3"
+	B3TraceIDHeader      = "X-B3-TraceId"
+	B3SpanIDHeader       = "X-B3-SpanId"
+	B3ParentSpanIDHeader = "X-B3-ParentSpanId"
+	B3SampledHeader      = "X-B3-Sampled"
+)
+
+const (
+	traceIDLength = 32
+	spanIDLength  = 16
+)
+
+// Extract reads the caller's trace context from h, preferring W3C
+// traceparent over B3. The returned SpanID is the caller's span.
+func Extract(h http.Header) (reqctx.TraceContext, bool) {
+	if trace, ok := parseTraceParent(h.Get(TraceParentHeader)); ok {
+		return trace, true
+	}
+	if trace, ok := parseB3Single(h.Get(B3Header)); ok {
+		return trace, true
+	}
+	return parseB3Multi(h)
+}
+
+// StartSpan returns the trace context for a span of this service: a child
+// of parent when the caller sent one, otherwise the root of a new trace
+func StartSpan(parent reqctx.TraceContext, ok bool) reqctx.TraceContext {
+	if !ok {
+		return reqctx.TraceContext{TraceID: newID(traceIDLength), SpanID: newID(spanIDLength), Sampled: true}
+	}
+	return reqctx.TraceContext{
+		TraceID:      parent.TraceID,
+		SpanID:       newID(spanIDLength),
+		ParentSpanID: parent.SpanID,
+		Sampled:      parent.Sampled,
+	}
+}
+
+// Inject writes the trace context in ctx to h, in both formats, so an
+// outgoing request joins the trace whichever one the receiver understands
+func Inject(ctx context.Context, h http.Header) {
+	trace, ok := reqctx.Trace(ctx)
+	if !ok {
+		return
+	}
+
+	h.Set(TraceParentHeader, FormatTraceParent(trace))
+	h.Set(B3TraceIDHeader, trace.TraceID)
+	h.Set(B3SpanIDHeader, trace.SpanID)
+	if trace.ParentSpanID != "" {
+		h.Set(B3ParentSpanIDHeader, trace.ParentSpanID)
+	}
+	h.Set(B3SampledHeader, sampledFlag(trace.Sampled, "1", "0"))
+}
+
+// FormatTraceParent formats trace as a W3C traceparent value
+func FormatTraceParent(trace reqctx.TraceContext) string {
+	return "00-" + trace.TraceID + "-" + trace.SpanID + "-" + sampledFlag(trace.Sampled, "01", "00")
+}
+
+// parseTraceParent parses a version 00 traceparent, and the leading fields
+// of later versions as the specification requires
+func parseTraceParent(value string) (reqctx.TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return reqctx.TraceContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return reqctx.TraceContext{}, false
+	}
+	if !validID(traceID, traceIDLength) || !validID(spanID, spanIDLength) || !isHex(flags, 2) {
+		return reqctx.TraceContext{}, false
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return reqctx.TraceContext{TraceID: traceID, SpanID: spanID, Sampled: flagBits[0]&1 == 1}, true
+}
+
+// parseB3Single parses the b3 header: traceid-spanid[-sampled[-parentspanid]]
+func parseB3Single(value string) (reqctx.TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 {
+		return reqctx.TraceContext{}, false
+	}
+	sampled := ""
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return newB3(parts[0], parts[1], sampled)
+}
+
+// parseB3Multi parses the X-B3-* headers
+func parseB3Multi(h http.Header) (reqctx.TraceContext, bool) {
+	return newB3(h.Get(B3TraceIDHeader), h.Get(B3SpanIDHeader), h.Get(B3SampledHeader))
+}
+
+// newB3 validates B3 fields. 64-bit trace IDs are left-padded to 128 bits,
+// so they can be written as traceparent.
+func newB3(traceID, spanID, sampled string) (reqctx.TraceContext, bool) {
+	traceID, spanID = strings.ToLower(traceID), strings.ToLower(spanID)
+	if len(traceID) == spanIDLength {
+		traceID = strings.Repeat("0", traceIDLength-spanIDLength) + traceID
+	}
+	if !validID(traceID, traceIDLength) || !validID(spanID, spanIDLength) {
+		return reqctx.TraceContext{}, false
+	}
+	// Unless the caller decided against it, sample
+	return reqctx.TraceContext{TraceID: traceID, SpanID: spanID, Sampled: sampled != "0"}, true
+}
+
+// validID reports whether id is lowercase hex of the given length and not
+// all zeros, which both formats reserve as invalid
+func validID(id string, length int) bool {
+	return isHex(id, length) && strings.Trim(id, "0") != ""
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newID returns a random ID of length hex digits
+func newID(length int) string {
+	b := make([]byte, length/2)
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sampledFlag(sampled bool, yes, no string) string {
+	if sampled {
+		return yes
+	}
+	return no
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected reqctx.TraceContext
+		ok       bool
+	}{
+		{name: "no headers"},
+		{
+			name:     "traceparent",
+			headers:  map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			expected: reqctx.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+			ok:       true,
+		},
+		{
+			name:     "traceparent not sampled",
+			headers:  map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+			expected: reqctx.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+			ok:       true,
+		},
+		{
+			name:     "future traceparent version",
+			headers:  map[string]string{"traceparent": "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+			expected: reqctx.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+			ok:       true,
+		},
+		{name: "zero trace ID", headers: map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"}},
+		{name: "uppercase traceparent", headers: map[string]string{"traceparent": "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"}},
+		{name: "malformed traceparent", headers: map[string]string{"traceparent": "garbage"}},
+		{
+			name:     "b3 single header",
+			headers:  map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"},
+			expected: reqctx.TraceContext{TraceID: "80f198ee56343ba864fe8b2a57d3eff7", SpanID: "e457b5a2e4d86bd1", Sampled: true},
+			ok:       true,
+		},
+		{
+			name: "b3 multiple headers with 64-bit trace ID",
+			headers: map[string]string{
+				"X-B3-TraceId": "a3ce929d0e0e4736",
+				"X-B3-SpanId":  "00f067aa0ba902b7",
+				"X-B3-Sampled": "0",
+			},
+			expected: reqctx.TraceContext{TraceID: "0000000000000000a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+			ok:       true,
+		},
+		{
+			name: "traceparent wins over b3",
+			headers: map[string]string{
+				"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"b3":          "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1",
+			},
+			expected: reqctx.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+			ok:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+
+			trace, ok := Extract(h)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, trace)
+		})
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	root := StartSpan(reqctx.TraceContext{}, false)
+	assert.Len(t, root.TraceID, 32)
+	assert.Len(t, root.SpanID, 16)
+	assert.Empty(t, root.ParentSpanID)
+	assert.True(t, root.Sampled)
+
+	child := StartSpan(root, true)
+	assert.Equal(t, root.TraceID, child.TraceID)
+	assert.Equal(t, root.SpanID, child.ParentSpanID)
+	assert.NotEqual(t, root.SpanID, child.SpanID)
+}
+
+func TestInject(t *testing.T) {
+	trace := reqctx.TraceContext{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		ParentSpanID: "e457b5a2e4d86bd1",
+		Sampled:      true,
+	}
+	h := http.Header{}
+	Inject(reqctx.WithTrace(context.Background(), trace), h)
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", h.Get("traceparent"))
+	assert.Equal(t, trace.TraceID, h.Get("X-B3-TraceId"))
+	assert.Equal(t, trace.SpanID, h.Get("X-B3-SpanId"))
+	assert.Equal(t, trace.ParentSpanID, h.Get("X-B3-ParentSpanId"))
+	assert.Equal(t, "1", h.Get("X-B3-Sampled"))
+
+	// The injected headers round trip
+	extracted, ok := Extract(h)
+	assert.True(t, ok)
+	assert.Equal(t, trace.TraceID, extracted.TraceID)
+
+	// Without a trace nothing is written
+	h = http.Header{}
+	Inject(context.Background(), h)
+	assert.Empty(t, h)
+}
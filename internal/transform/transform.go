@@ -0,0 +1,194 @@
+// Package transform reshapes JSON responses of routes with Go templates
+// configured per deployment, so downstream clients that expect a different
+// payload shape don't need a fork of the handlers. A template renders the
+// canonical payload: the response the handler wrote, decoded from JSON.
+// Only successful JSON responses are transformed; errors, empty and
+// streamed responses pass through as written.
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dazraf/go-api-example/internal/apierrors"
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// Template is a transformation of a route's responses
+type Template struct {
+	// Source is the template; File is read for it when empty
+	Source string
+	File   string
+	// ContentType replaces the response's content type; JSON output is
+	// checked to be valid
+	ContentType string
+}
+
+// Transformer renders a route's responses with a template
+type Transformer struct {
+	template    *template.Template
+	contentType string
+}
+
+// funcs are the functions templates can call besides the built-in ones
+var funcs = template.FuncMap{
+	// json encodes a value of the payload, e.g. to quote strings
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join": func(sep string, values []any) string {
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = fmt.Sprint(v)
+		}
+		return strings.Join(parts, sep)
+	},
+	// default returns fallback for missing or empty values
+	"default": func(fallback, v any) any {
+		if v == nil || v == "" {
+			return fallback
+		}
+		return v
+	},
+}
+
+// New creates a transformer for the route called name, parsing its template
+func New(name string, t Template) (*Transformer, error) {
+	source := t.Source
+	switch {
+	case source != "" && t.File != "":
+		return nil, fmt.Errorf("transform of %s has both a template and a file", name)
+	case t.File != "":
+		data, err := os.ReadFile(t.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transform of %s: %w", name, err)
+		}
+		source = string(data)
+	case source == "":
+		return nil, fmt.Errorf("transform of %s has no template", name)
+	}
+	if t.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(t.ContentType); err != nil {
+			return nil, fmt.Errorf("transform of %s: invalid content type %q: %w", name, t.ContentType, err)
+		}
+	}
+
+	parsed, err := template.New(name).Funcs(funcs).Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform of %s: %w", name, err)
+	}
+	return &Transformer{template: parsed, contentType: t.ContentType}, nil
+}
+
+// Render renders payload, the JSON of a response, with the template
+func (t *Transformer) Render(payload []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// Numbers keep their text, so IDs are not rendered as 1e+06
+	decoder.UseNumber()
+	var data any
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := t.template.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	if isJSON(t.contentType) && !json.Valid(out.Bytes()) {
+		return nil, fmt.Errorf("transform of %s rendered invalid JSON", t.template.Name())
+	}
+	return out.Bytes(), nil
+}
+
+// Handler transforms the successful JSON responses of next. Responses that
+// fail to render are replaced by a 500 error.
+func (t *Transformer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &transformRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if !recorder.buffering {
+			return
+		}
+
+		body, err := t.Render(recorder.body.Bytes())
+		if err != nil {
+			logging.FromContext(r.Context(), logging.HTTP).Error("Failed to transform response", "route", t.template.Name(), "error", err)
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"Failed to render response","code":"` + string(apierrors.Internal) + `"}` + "\n"))
+			return
+		}
+		if t.contentType != "" {
+			w.Header().Set("Content-Type", t.contentType)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(recorder.status)
+		_, _ = w.Write(body)
+	})
+}
+
+// isJSON reports whether contentType is JSON, including +json types
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// transformRecorder buffers successful JSON responses for rendering, and
+// passes others through as they are written
+type transformRecorder struct {
+	http.ResponseWriter
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (r *transformRecorder) WriteHeader(status int) {
+	if r.status != 0 {
+		return
+	}
+	r.status = status
+	r.buffering = status >= 200 && status < 300 && status != http.StatusNoContent && isJSON(r.Header().Get("Content-Type"))
+	if !r.buffering {
+		r.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (r *transformRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.buffering {
+		return r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush flushes responses passed through; buffered ones are written whole
+func (r *transformRecorder) Flush() {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.buffering {
+		return
+	}
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *transformRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package transform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "user.tmpl")
+	require.NoError(t, os.WriteFile(file, []byte(`{"id": {{.id}}}`), 0o600))
+
+	tests := []struct {
+		name     string
+		template Template
+		wantErr  string
+	}{
+		{name: "source", template: Template{Source: `{"id": {{.id}}}`}},
+		{name: "file", template: Template{File: file, ContentType: "application/vnd.partner+json"}},
+		{name: "no template", wantErr: "no template"},
+		{name: "source and file", template: Template{Source: `{}`, File: file}, wantErr: "both"},
+		{name: "missing file", template: Template{File: file + ".missing"}, wantErr: "failed to read"},
+		{name: "invalid template", template: Template{Source: `{{.id`}, wantErr: "invalid transform of GET /api/v1/users/{id}"},
+		{name: "invalid content type", template: Template{Source: `{}`, ContentType: "json;;"}, wantErr: "invalid content type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New("GET /api/v1/users/{id}", tt.template)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTransformer_Render(t *testing.T) {
+	tests := []struct {
+		name     string
+		template Template
+		payload  string
+		expected string
+		wantErr  string
+	}{
+		{
+			name:     "object",
+			template: Template{Source: `{"userId": {{.id}}, "displayName": {{json (upper .name)}}}`},
+			payload:  `{"id": 12345678901, "name": "John Doe"}`,
+			expected: `{"userId": 12345678901, "displayName": "JOHN DOE"}`,
+		},
+		{
+			name:     "list",
+			template: Template{Source: `{"count": {{len .}}, "emails": [{{range $i, $u := .}}{{if $i}},{{end}}{{json $u.email}}{{end}}]}`},
+			payload:  `[{"email": "john@example.com"}, {"email": "jane@example.com"}]`,
+			expected: `{"count": 2, "emails": ["john@example.com","jane@example.com"]}`,
+		},
+		{
+			name:     "text",
+			template: Template{Source: `{{.name}} <{{default "unknown" .email}}>`, ContentType: "text/plain"},
+			payload:  `{"name": "John Doe"}`,
+			expected: `John Doe <unknown>`,
+		},
+		{
+			name:     "invalid JSON rendered",
+			template: Template{Source: `{"name": {{.name}}}`, ContentType: "application/json"},
+			payload:  `{"name": "John Doe"}`,
+			wantErr:  "rendered invalid JSON",
+		},
+		{
+			name:     "template fails",
+			template: Template{Source: `{{index .tags 5}}`},
+			payload:  `{"tags": []}`,
+			wantErr:  "index out of range",
+		},
+		{
+			name:     "payload not JSON",
+			template: Template{Source: `{}`},
+			payload:  `not json`,
+			wantErr:  "failed to decode response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transformer, err := New("route", tt.template)
+			require.NoError(t, err)
+
+			out, err := transformer.Render([]byte(tt.payload))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+}
+
+func TestTransformer_Handler(t *testing.T) {
+	transformer, err := New("route", Template{Source: `{"user": {{json .name}}}`, ContentType: "application/vnd.partner+json"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantStatus  int
+		wantType    string
+		wantBody    string
+	}{
+		{name: "transformed", status: http.StatusOK, contentType: "application/json; charset=utf-8", body: `{"name":"John"}`, wantStatus: http.StatusOK, wantType: "application/vnd.partner+json", wantBody: `{"user": "John"}`},
+		{name: "created", status: http.StatusCreated, contentType: "application/json", body: `{"name":"Jane"}`, wantStatus: http.StatusCreated, wantType: "application/vnd.partner+json", wantBody: `{"user": "Jane"}`},
+		{name: "errors pass through", status: http.StatusNotFound, contentType: "application/json", body: `{"error":"User not found"}`, wantStatus: http.StatusNotFound, wantType: "application/json", wantBody: `{"error":"User not found"}`},
+		{name: "other types pass through", status: http.StatusOK, contentType: "text/csv", body: "id,name\n", wantStatus: http.StatusOK, wantType: "text/csv", wantBody: "id,name\n"},
+		{name: "render failure", status: http.StatusOK, contentType: "application/json", body: `{"name":`, wantStatus: http.StatusInternalServerError, wantType: "application/json; charset=utf-8", wantBody: `{"error":"Failed to render response","code":"INTERNAL"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := transformer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", "999")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
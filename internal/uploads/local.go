@@ -0,0 +1,175 @@
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// ErrInvalidToken is returned for unknown, used or expired upload tokens
+var ErrInvalidToken = errors.New("invalid or expired upload token")
+
+// LocalContentPath is where files are PUT to local storage, followed by
+// the upload token
+const LocalContentPath = "/api/v1/uploads/content/"
+
+// grant allows one file to be PUT to local storage
+type grant struct {
+	key         string
+	contentType string
+	size        int64
+	expires     time.Time
+}
+
+// LocalStorage keeps files in a directory. Files are PUT to the API with a
+// one-time token in place of a presigned URL, so they do transit the API,
+// but outside the JSON endpoints; use S3 where that matters.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	clock   clock.Clock
+
+	mutex  sync.Mutex
+	grants map[string]grant
+}
+
+// NewLocalStorage creates storage in dir, created if missing. baseURL is
+// prefixed to upload URLs so clients can reach the API, e.g.
+// https://api.example.com; empty gives URLs relative to the API.
+func NewLocalStorage(dir, baseURL string, clk clock.Clock) (*LocalStorage, error) {
+	if dir == "" {
+		return nil, errors.New("uploads local dir is required")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create uploads dir: %w", err)
+	}
+	if clk == nil {
+		clk = clock.Real()
+	}
+	return &LocalStorage{dir: dir, baseURL: baseURL, clock: clk, grants: make(map[string]grant)}, nil
+}
+
+// PresignPut grants a one-time token to PUT the file
+func (s *LocalStorage) PresignPut(_ context.Context, key, contentType string, size int64, expires time.Time) (Target, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Target{}, err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Drop expired grants while here, so unused ones do not pile up
+	now := s.clock.Now()
+	for t, g := range s.grants {
+		if !now.Before(g.expires) {
+			delete(s.grants, t)
+		}
+	}
+	s.grants[token] = grant{key: key, contentType: contentType, size: size, expires: expires}
+
+	headers := map[string]string{}
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	return Target{Method: "PUT", URL: s.baseURL + LocalContentPath + token, Headers: headers}, nil
+}
+
+// Receive stores body as the file token was granted for, using up the
+// token. Bodies larger than declared are rejected with ErrTooLarge.
+func (s *LocalStorage) Receive(token, contentType string, body io.Reader) error {
+	s.mutex.Lock()
+	g, ok := s.grants[token]
+	if ok {
+		delete(s.grants, token)
+	}
+	s.mutex.Unlock()
+	if !ok || !s.clock.Now().Before(g.expires) {
+		return ErrInvalidToken
+	}
+	if g.contentType != "" && contentType != g.contentType {
+		return fmt.Errorf("content type must be %s", g.contentType)
+	}
+
+	return s.write(g.key, body, g.size)
+}
+
+// Put stores body at key
+func (s *LocalStorage) Put(_ context.Context, key, _ string, body io.Reader, size int64) error {
+	return s.write(key, body, size)
+}
+
+// write stores at most size bytes of body at key, failing with ErrTooLarge
+// if there are more
+func (s *LocalStorage) write(key string, body io.Reader, size int64) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create uploads dir: %w", err)
+	}
+	// Write to a temporary file first so partial files are never seen
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(body, size+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		return fmt.Errorf("failed to write upload file: %w", err)
+	case n > size:
+		return fmt.Errorf("%w: expected %d bytes", ErrTooLarge, size)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store upload file: %w", err)
+	}
+	return nil
+}
+
+// Stat returns the size of the stored file
+func (s *LocalStorage) Stat(_ context.Context, key string) (int64, error) {
+	info, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNotUploaded
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Delete deletes the stored file
+func (s *LocalStorage) Delete(_ context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Open opens the stored file for reading
+func (s *LocalStorage) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotUploaded
+	}
+	return f, err
+}
+
+// path returns where key is stored. Keys are generated, never client
+// supplied, so they cannot escape the directory.
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
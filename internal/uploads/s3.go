@@ -0,0 +1,284 @@
+package uploads
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+// maxPresignExpiry is the longest S3 accepts for presigned URLs
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// S3Options configures S3 storage
+type S3Options struct {
+	Bucket string
+	Region string
+	// Endpoint is the S3 API, defaulting to AWS's for the region; set it
+	// for S3 compatible stores such as MinIO
+	Endpoint string
+	// PathStyle puts the bucket in the path rather than the host name, as
+	// most S3 compatible stores need
+	PathStyle bool
+	// Prefix is prepended to keys, e.g. uploads/
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+	Clock           clock.Clock
+}
+
+// S3Storage keeps files in an S3 bucket, which clients PUT to directly
+// with presigned URLs (AWS Signature Version 4)
+type S3Storage struct {
+	opts     S3Options
+	endpoint *url.URL
+}
+
+// NewS3Storage creates S3 storage
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" || opts.Region == "" {
+		return nil, errors.New("uploads s3 bucket and region are required")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("uploads s3 access key id and secret access key are required")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid uploads s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &S3Storage{opts: opts, endpoint: endpoint}, nil
+}
+
+// PresignPut returns a URL to PUT the file to. The size and content type
+// are signed, so S3 rejects any other file.
+func (s *S3Storage) PresignPut(_ context.Context, key, contentType string, size int64, expires time.Time) (Target, error) {
+	headers := map[string]string{"Content-Length": strconv.FormatInt(size, 10)}
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	now := s.opts.Clock.Now()
+	return Target{
+		Method:  http.MethodPut,
+		URL:     s.presign(http.MethodPut, key, headers, expires.Sub(now), now),
+		Headers: headers,
+	}, nil
+}
+
+// Stat returns the size of the stored object
+func (s *S3Storage) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, ErrNotUploaded
+	default:
+		return 0, fmt.Errorf("s3 returned %s for %s", resp.Status, key)
+	}
+}
+
+// Put uploads body as the object at key
+func (s *S3Storage) Put(ctx context.Context, key, contentType string, body io.Reader, size int64) error {
+	now := s.opts.Clock.Now()
+	target, err := s.PresignPut(ctx, key, contentType, size, now.Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, target.Method, target.URL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach s3: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3 returned %s storing %s", resp.Status, key)
+	}
+	return nil
+}
+
+// Delete deletes the stored object
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 returned %s deleting %s", resp.Status, key)
+	}
+	return nil
+}
+
+// Open opens the stored object for reading
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotUploaded
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 returned %s for %s", resp.Status, key)
+	}
+}
+
+// do sends a presigned request for key
+func (s *S3Storage) do(ctx context.Context, method, key string) (*http.Response, error) {
+	now := s.opts.Clock.Now()
+	req, err := http.NewRequestWithContext(ctx, method, s.presign(method, key, nil, time.Minute, now), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach s3: %w", err)
+	}
+	return resp, nil
+}
+
+// presign returns a URL for method on key, valid for expiry from now, that
+// must be sent with headers
+func (s *S3Storage) presign(method, key string, headers map[string]string, expiry time.Duration, now time.Time) string {
+	expiry = min(max(expiry, time.Second), maxPresignExpiry)
+	host := s.endpoint.Host
+	path := "/" + s.opts.Prefix + key
+	if s.opts.PathStyle {
+		path = "/" + s.opts.Bucket + path
+	} else {
+		host = s.opts.Bucket + "." + host
+	}
+
+	signed := map[string]string{"host": host}
+	for name, value := range headers {
+		signed[strings.ToLower(name)] = value
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(signed[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	timestamp := now.UTC().Format("20060102T150405Z")
+	date := timestamp[:8]
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.opts.AccessKeyID + "/" + scope},
+		"X-Amz-Date":          {timestamp},
+		"X-Amz-Expires":       {strconv.Itoa(int(expiry.Seconds()))},
+		"X-Amz-SignedHeaders": {signedHeaders},
+	}
+	canonicalQuery := awsEncode(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		awsEscapePath(path),
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.opts.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.opts.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return s.endpoint.Scheme + "://" + host + awsEscapePath(path) + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEncode encodes query parameters sorted by name, escaping everything
+// but unreserved characters as SigV4 requires
+func awsEncode(values url.Values) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, awsEscape(name)+"="+awsEscape(values.Get(name)))
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscapePath escapes each segment of path
+func awsEscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func awsEscape(s string) string {
+	// PathEscape leaves sub-delimiters such as + and = unescaped, which
+	// SigV4 does not
+	escaped := url.PathEscape(s)
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '%':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package uploads
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scan verdicts
+const (
+	VerdictClean    = "clean"
+	VerdictInfected = "infected"
+)
+
+// ErrInfected is returned when completing an upload whose file a scanner
+// found to be infected
+var ErrInfected = errors.New("file is infected")
+
+// Scanner checks uploaded files for malware or other unwanted content
+type Scanner interface {
+	// Name identifies the scanner in scan results and logs
+	Name() string
+	// Scan reads file and returns VerdictClean or VerdictInfected, with
+	// the name of the threat found, if any
+	Scan(ctx context.Context, file io.Reader) (verdict, threat string, err error)
+}
+
+// ScanResult records what a scanner found in an upload's file
+type ScanResult struct {
+	Scanner   string    `json:"scanner" example:"clamav"`
+	Verdict   string    `json:"verdict" example:"clean"`
+	Threat    string    `json:"threat,omitempty" example:"Eicar-Signature"`
+	ScannedAt time.Time `json:"scanned_at" example:"2024-01-02T15:05:05Z"`
+}
+
+// NopScanner passes every file, for when no scanner is configured
+type NopScanner struct{}
+
+// Name identifies the scanner
+func (NopScanner) Name() string {
+	return "none"
+}
+
+// Scan returns VerdictClean without reading file
+func (NopScanner) Scan(context.Context, io.Reader) (string, string, error) {
+	return VerdictClean, "", nil
+}
+
+// clamChunkSize is the largest chunk streamed to clamd at a time
+const clamChunkSize = 64 << 10
+
+// ClamAV scans files with clamd over TCP, streaming them with INSTREAM.
+// Files larger than clamd's StreamMaxLength fail to scan, so keep it above
+// the largest upload allowed.
+type ClamAV struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClamAV creates a scanner for the clamd at address, e.g.
+// localhost:3310, bounding each scan by timeout
+func NewClamAV(address string, timeout time.Duration) *ClamAV {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &ClamAV{address: address, timeout: timeout}
+}
+
+// Name identifies the scanner
+func (c *ClamAV) Name() string {
+	return "clamav"
+}
+
+// Scan streams file to clamd and parses its reply
+func (c *ClamAV) Scan(ctx context.Context, file io.Reader) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	if err := clamStream(conn, file); err != nil {
+		return "", "", fmt.Errorf("failed to send file to clamd: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamReply(reply)
+}
+
+// clamStream sends file with the INSTREAM command: length-prefixed chunks
+// ended by an empty one
+func clamStream(conn io.Writer, file io.Reader) error {
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+clamChunkSize)
+	for {
+		n, err := file.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamReply parses a reply such as "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseClamReply(reply string) (string, string, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return VerdictClean, "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return VerdictInfected, strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", "", fmt.Errorf("clamd replied %q", reply)
+	}
+}
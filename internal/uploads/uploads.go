@@ -0,0 +1,345 @@
+// Package uploads lets clients send large files, such as avatars or
+// imports, straight to storage rather than through the API. A client
+// creates an upload, PUTs the file to the presigned URL it gets back, then
+// completes the upload, which checks the file arrived and scans it. Uploads
+// not completed before their URL expires are abandoned and their files
+// deleted.
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/logging"
+)
+
+// logger logs scans and cleanups of uploads
+var logger = logging.Named(logging.Jobs)
+
+// Upload states
+const (
+	StatePending   = "pending"
+	StateCompleted = "completed"
+	// StateRejected uploads were infected and their files deleted
+	StateRejected = "rejected"
+	// StateQuarantined uploads were infected and their files kept for
+	// inspection, but cannot be used
+	StateQuarantined = "quarantined"
+)
+
+var (
+	// ErrNotFound is returned for unknown uploads, including those no
+	// longer retained
+	ErrNotFound = errors.New("upload not found")
+	// ErrUnknownPurpose is returned for purposes that are not configured
+	ErrUnknownPurpose = errors.New("unknown upload purpose")
+	// ErrTooLarge is returned for files larger than their purpose allows,
+	// or than was declared
+	ErrTooLarge = errors.New("file is too large")
+	// ErrExpired is returned when completing an upload whose URL expired
+	ErrExpired = errors.New("upload has expired")
+	// ErrNotUploaded is returned when completing an upload whose file has
+	// not arrived
+	ErrNotUploaded = errors.New("file has not been uploaded")
+	// ErrSizeMismatch is returned when the file is not the declared size
+	ErrSizeMismatch = errors.New("file size does not match the declared size")
+)
+
+// Upload is a file being sent to storage
+type Upload struct {
+	ID          string `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Purpose     string `json:"purpose" example:"avatar"`
+	ContentType string `json:"content_type" example:"image/png"`
+	// Size is the declared size in bytes
+	Size  int64  `json:"size" example:"1048576"`
+	State string `json:"state" example:"pending"`
+	// Key is where the file is stored
+	Key         string     `json:"key" example:"avatar/3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	CreatedAt   time.Time  `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	ExpiresAt   time.Time  `json:"expires_at" example:"2024-01-02T15:19:05Z"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" example:"2024-01-02T15:05:05Z"`
+	// Scan is what the scanner found in the file, once completed
+	Scan *ScanResult `json:"scan,omitempty"`
+	// Owner is the caller that created the upload, if any
+	Owner string `json:"-"`
+}
+
+// Target is where and how a client sends an upload's file
+type Target struct {
+	Method string `json:"method" example:"PUT"`
+	URL    string `json:"url" example:"https://uploads.s3.eu-west-1.amazonaws.com/avatar/3f2b9c0e?X-Amz-Signature=..."`
+	// Headers must be sent with the file
+	Headers map[string]string `json:"headers"`
+}
+
+// Storage holds uploaded files
+type Storage interface {
+	// PresignPut returns where to PUT a file of contentType and size to
+	// store it at key, valid until expires
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Time) (Target, error)
+	// Stat returns the size of the file at key, or ErrNotUploaded
+	Stat(ctx context.Context, key string) (int64, error)
+	// Put stores a file of size read from body at key, for files the API
+	// creates itself
+	Put(ctx context.Context, key, contentType string, body io.Reader, size int64) error
+	// Open opens the file at key for reading, or returns ErrNotUploaded
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete deletes the file at key, if any
+	Delete(ctx context.Context, key string) error
+}
+
+// Options configures a Manager
+type Options struct {
+	// Purposes maps what uploads are for, such as avatar, to the largest
+	// file allowed in bytes
+	Purposes map[string]int64
+	// TTL is how long an upload's URL is valid
+	TTL time.Duration
+	// Retention is how long completed uploads are kept
+	Retention time.Duration
+	// Scanner checks files when uploads are completed; NopScanner if nil
+	Scanner Scanner
+	// Quarantine keeps infected files rather than deleting them
+	Quarantine bool
+	Clock      clock.Clock
+	IDs        idgen.Generator
+}
+
+// Manager creates and completes uploads, keeping them in memory
+type Manager struct {
+	storage Storage
+	opts    Options
+
+	mutex   sync.Mutex
+	uploads map[string]Upload
+}
+
+// NewManager creates a manager storing files in storage
+func NewManager(storage Storage, opts Options) *Manager {
+	if opts.TTL <= 0 {
+		opts.TTL = 15 * time.Minute
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.Scanner == nil {
+		opts.Scanner = NopScanner{}
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+	return &Manager{storage: storage, opts: opts, uploads: make(map[string]Upload)}
+}
+
+// Create starts an upload of a file for purpose on behalf of owner,
+// returning where to send it
+func (m *Manager) Create(ctx context.Context, owner, purpose, contentType string, size int64) (Upload, Target, error) {
+	maxSize, ok := m.opts.Purposes[purpose]
+	switch {
+	case !ok:
+		return Upload{}, Target{}, ErrUnknownPurpose
+	case size > maxSize:
+		return Upload{}, Target{}, fmt.Errorf("%w: %s uploads are at most %d bytes", ErrTooLarge, purpose, maxSize)
+	}
+
+	now := m.opts.Clock.Now().UTC()
+	id := m.opts.IDs.NewID()
+	upload := Upload{
+		ID:          id,
+		Purpose:     purpose,
+		ContentType: contentType,
+		Size:        size,
+		State:       StatePending,
+		Key:         purpose + "/" + id,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(m.opts.TTL),
+		Owner:       owner,
+	}
+	target, err := m.storage.PresignPut(ctx, upload.Key, contentType, size, upload.ExpiresAt)
+	if err != nil {
+		return Upload{}, Target{}, fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploads[id] = upload
+	return upload, target, nil
+}
+
+// Get returns the upload with id
+func (m *Manager) Get(id string) (Upload, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	return upload, nil
+}
+
+// Complete checks that the upload's file arrived at its declared size and
+// scans it, marking the upload completed if clean. Infected files are
+// rejected, or quarantined, and ErrInfected returned. Completing an upload
+// twice returns the same result.
+func (m *Manager) Complete(ctx context.Context, id string) (Upload, error) {
+	upload, err := m.Get(id)
+	switch {
+	case err != nil || upload.State == StateCompleted:
+		return upload, err
+	case upload.State != StatePending:
+		return upload, fmt.Errorf("%w with %s", ErrInfected, upload.Scan.Threat)
+	}
+	if !m.opts.Clock.Now().Before(upload.ExpiresAt) {
+		return upload, ErrExpired
+	}
+
+	size, err := m.storage.Stat(ctx, upload.Key)
+	if err != nil {
+		return upload, err
+	}
+	if size != upload.Size {
+		return upload, fmt.Errorf("%w: got %d bytes, expected %d", ErrSizeMismatch, size, upload.Size)
+	}
+	result, err := m.scan(ctx, upload)
+	if err != nil {
+		// The upload stays pending, so completing can be retried
+		return upload, err
+	}
+	logger.InfoContext(ctx, "Audit: upload scanned", "upload", upload.ID, "purpose", upload.Purpose, "owner", upload.Owner,
+		"scanner", result.Scanner, "verdict", result.Verdict, "threat", result.Threat)
+
+	m.mutex.Lock()
+	upload, ok := m.uploads[id]
+	if !ok {
+		m.mutex.Unlock()
+		return Upload{}, ErrNotFound
+	}
+	upload.Scan = &result
+	switch {
+	case result.Verdict == VerdictClean:
+		now := m.opts.Clock.Now().UTC()
+		upload.State = StateCompleted
+		upload.CompletedAt = &now
+	case m.opts.Quarantine:
+		upload.State = StateQuarantined
+	default:
+		upload.State = StateRejected
+	}
+	m.uploads[id] = upload
+	m.mutex.Unlock()
+
+	if upload.State == StateCompleted {
+		return upload, nil
+	}
+	if upload.State == StateRejected {
+		if err := m.storage.Delete(ctx, upload.Key); err != nil {
+			logger.ErrorContext(ctx, "Failed to delete infected upload", "key", upload.Key, "error", err)
+		}
+	}
+	return upload, fmt.Errorf("%w with %s", ErrInfected, result.Threat)
+}
+
+// scan runs the scanner over the upload's file
+func (m *Manager) scan(ctx context.Context, upload Upload) (ScanResult, error) {
+	file, err := m.storage.Open(ctx, upload.Key)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to open upload for scanning: %w", err)
+	}
+	defer file.Close()
+
+	verdict, threat, err := m.opts.Scanner.Scan(ctx, file)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to scan upload: %w", err)
+	}
+	return ScanResult{
+		Scanner:   m.opts.Scanner.Name(),
+		Verdict:   verdict,
+		Threat:    threat,
+		ScannedAt: m.opts.Clock.Now().UTC(),
+	}, nil
+}
+
+// Open opens the file of the completed upload with id
+func (m *Manager) Open(ctx context.Context, id string) (Upload, io.ReadCloser, error) {
+	upload, err := m.Get(id)
+	if err != nil {
+		return Upload{}, nil, err
+	}
+	if upload.State != StateCompleted {
+		return upload, nil, ErrNotUploaded
+	}
+	file, err := m.storage.Open(ctx, upload.Key)
+	return upload, file, err
+}
+
+// List returns the uploads of owner, newest first
+func (m *Manager) List(owner string) []Upload {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := []Upload{}
+	for _, upload := range m.uploads {
+		if upload.Owner == owner {
+			list = append(list, upload)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Cleanup forgets uploads that expired without being completed, deleting
+// their files, and other uploads past their retention, whose files are
+// kept. It returns how many uploads were forgotten.
+func (m *Manager) Cleanup(ctx context.Context) int {
+	m.mutex.Lock()
+	now := m.opts.Clock.Now()
+	var abandoned []string
+	removed := 0
+	for id, upload := range m.uploads {
+		switch {
+		case upload.State == StatePending && !now.Before(upload.ExpiresAt):
+			abandoned = append(abandoned, upload.Key)
+		case upload.State != StatePending && now.Sub(upload.Scan.ScannedAt) >= m.opts.Retention:
+		default:
+			continue
+		}
+		delete(m.uploads, id)
+		removed++
+	}
+	m.mutex.Unlock()
+
+	for _, key := range abandoned {
+		if err := m.storage.Delete(ctx, key); err != nil {
+			logger.ErrorContext(ctx, "Failed to delete abandoned upload", "key", key, "error", err)
+		}
+	}
+	return removed
+}
+
+// Run cleans up uploads every interval until ctx is done
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := m.Cleanup(ctx); removed > 0 {
+				logger.Info("Removed expired uploads", "count", removed)
+			}
+		}
+	}
+}
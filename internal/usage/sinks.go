@@ -0,0 +1,132 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// File is a Sink appending records to a file as JSON lines
+type File struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewFile creates a sink appending to the file at path, created as needed
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Export(_ context.Context, records []Record) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open usage file: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	return file.Close()
+}
+
+// HTTP is a Sink posting records to an endpoint as a JSON array
+type HTTP struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTP creates a sink posting to url, authenticated with token as a
+// bearer token when it is not empty
+func NewHTTP(url, token string, timeout time.Duration) *HTTP {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTP{url: url, token: token, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *HTTP) Export(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post usage: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("usage endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// messageWriter writes messages to Kafka, as kafka.Writer does
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// Kafka is a Sink producing a message per record to a topic, keyed by
+// principal so each principal's records stay in order on one partition
+type Kafka struct {
+	writer messageWriter
+}
+
+// NewKafka creates a sink producing to topic on the brokers
+func NewKafka(brokers []string, topic string, timeout time.Duration) *Kafka {
+	return &Kafka{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		WriteTimeout: timeout,
+	}}
+}
+
+func (k *Kafka) Export(ctx context.Context, records []Record) error {
+	messages := make([]kafka.Message, len(records))
+	for i, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		messages[i] = kafka.Message{Key: []byte(record.Principal), Value: value}
+	}
+	if err := k.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to produce usage to Kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the connections to the brokers
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,365 @@
+// Package usage meters what each principal uses of the API for billing. The
+// requests, and bytes read and written, of each principal's operations are
+// counted in fixed time windows. Closed windows are exported as records to a
+// sink such as a file, an HTTP endpoint or Kafka, and kept for a while so
+// callers can see their own consumption.
+package usage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// logger logs failed exports, which happen in the background
+var logger = logging.Named(logging.Jobs)
+
+// Record is a principal's use of an operation in a window
+type Record struct {
+	Principal string `json:"principal" example:"1"`
+	Tenant    string `json:"tenant,omitempty" example:"acme"`
+	// Operation is the method and route of the requests
+	Operation   string    `json:"operation" example:"POST /api/v1/users"`
+	WindowStart time.Time `json:"window_start" example:"2024-01-02T15:04:00Z"`
+	WindowEnd   time.Time `json:"window_end" example:"2024-01-02T15:05:00Z"`
+	Requests    int64     `json:"requests" example:"12"`
+	// BytesIn and BytesOut are the sizes of the request and response bodies
+	BytesIn  int64 `json:"bytes_in" example:"1840"`
+	BytesOut int64 `json:"bytes_out" example:"2210"`
+}
+
+// Sink receives the records of closed windows
+type Sink interface {
+	Export(ctx context.Context, records []Record) error
+}
+
+// Options configures a Meter
+type Options struct {
+	// Window is how long records aggregate requests for
+	Window time.Duration
+	// Retention is how long records are kept for Report
+	Retention time.Duration
+	// MaxPending bounds the records kept while the sink fails; the oldest
+	// are dropped beyond it
+	MaxPending int
+	Clock      clock.Clock
+}
+
+// key identifies the requests a record counts
+type key struct {
+	principal, tenant, operation string
+}
+
+// counts are what a record counts
+type counts struct {
+	requests, bytesIn, bytesOut int64
+}
+
+// Meter counts usage and exports it to a sink
+type Meter struct {
+	sink Sink
+	opts Options
+
+	mutex sync.Mutex
+	// start is when the current window started
+	start   time.Time
+	current map[key]*counts
+	// history holds closed windows for Report, oldest first
+	history []Record
+	// pending holds closed windows not yet exported, oldest first
+	pending []Record
+	dropped atomic.Uint64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewMeter creates a meter exporting to sink, or only keeping records for
+// Report when sink is nil
+func NewMeter(sink Sink, opts Options) *Meter {
+	if opts.Window <= 0 {
+		opts.Window = time.Minute
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 100000
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Meter{
+		sink:    sink,
+		opts:    opts,
+		start:   opts.Clock.Now().UTC().Truncate(opts.Window),
+		current: make(map[key]*counts),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Handler counts the requests to next as operation, by their principal.
+// Requests without a principal are not metered.
+func (m *Meter) Handler(operation string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := reqctx.PrincipalFrom(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant, _ := reqctx.Tenant(r.Context())
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		writer := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r)
+
+		m.add(key{principal: principal.Subject, tenant: tenant, operation: operation}, body.n, writer.n)
+	})
+}
+
+func (m *Meter) add(k key, bytesIn, bytesOut int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.rotate(m.opts.Clock.Now().UTC())
+	c, ok := m.current[k]
+	if !ok {
+		c = &counts{}
+		m.current[k] = c
+	}
+	c.requests++
+	c.bytesIn += bytesIn
+	c.bytesOut += bytesOut
+}
+
+// rotate closes the current window if now is past it
+func (m *Meter) rotate(now time.Time) {
+	if now.Before(m.start.Add(m.opts.Window)) {
+		return
+	}
+	m.close(m.start.Add(m.opts.Window))
+	m.start = now.Truncate(m.opts.Window)
+}
+
+// close records the current window as ending at end and empties it
+func (m *Meter) close(end time.Time) {
+	records := m.records(end)
+	m.current = make(map[key]*counts)
+	m.history = append(m.history, records...)
+	if m.sink != nil {
+		m.pending = append(m.pending, records...)
+		m.trim()
+	}
+}
+
+// records returns the current window's records, as ending at end
+func (m *Meter) records(end time.Time) []Record {
+	records := make([]Record, 0, len(m.current))
+	for k, c := range m.current {
+		records = append(records, Record{
+			Principal:   k.principal,
+			Tenant:      k.tenant,
+			Operation:   k.operation,
+			WindowStart: m.start,
+			WindowEnd:   end,
+			Requests:    c.requests,
+			BytesIn:     c.bytesIn,
+			BytesOut:    c.bytesOut,
+		})
+	}
+	slices.SortFunc(records, func(a, b Record) int {
+		return cmp.Or(cmp.Compare(a.Principal, b.Principal), cmp.Compare(a.Tenant, b.Tenant), cmp.Compare(a.Operation, b.Operation))
+	})
+	return records
+}
+
+// trim drops the oldest pending records beyond MaxPending
+func (m *Meter) trim() {
+	if excess := len(m.pending) - m.opts.MaxPending; excess > 0 {
+		m.pending = slices.Delete(m.pending, 0, excess)
+		m.dropped.Add(uint64(excess))
+	}
+}
+
+// Flush exports the windows closed since the last flush. Records the sink
+// fails to take are kept for the next flush.
+func (m *Meter) Flush(ctx context.Context) error {
+	m.mutex.Lock()
+	now := m.opts.Clock.Now().UTC()
+	m.rotate(now)
+	m.prune(now)
+	pending := m.pending
+	m.pending = nil
+	m.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := m.sink.Export(ctx, pending); err != nil {
+		m.mutex.Lock()
+		m.pending = append(pending, m.pending...)
+		m.trim()
+		m.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// prune drops records older than the retention from the history
+func (m *Meter) prune(now time.Time) {
+	cutoff := now.Add(-m.opts.Retention)
+	kept := slices.IndexFunc(m.history, func(record Record) bool {
+		return record.WindowEnd.After(cutoff)
+	})
+	if kept < 0 {
+		kept = len(m.history)
+	}
+	m.history = slices.Delete(m.history, 0, kept)
+}
+
+// Start flushes every window in the background until Stop
+func (m *Meter) Start() {
+	m.done.Go(func() {
+		ticker := time.NewTicker(m.opts.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Flush(context.Background()); err != nil {
+					logger.Warn("Failed to export usage, retrying at the next window", "error", err)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop stops flushing, closes the current window early and exports it with
+// the others pending, then closes the sink if it is an io.Closer
+func (m *Meter) Stop(ctx context.Context) error {
+	close(m.stop)
+	m.done.Wait()
+
+	m.mutex.Lock()
+	if len(m.current) > 0 {
+		m.close(m.opts.Clock.Now().UTC())
+	}
+	m.mutex.Unlock()
+	err := m.Flush(ctx)
+	if closer, ok := m.sink.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
+
+// Dropped returns how many records were dropped because the sink failed
+// for too long
+func (m *Meter) Dropped() uint64 {
+	return m.dropped.Load()
+}
+
+// OperationUsage is a principal's use of an operation over a report
+type OperationUsage struct {
+	Operation string `json:"operation" example:"POST /api/v1/users"`
+	Requests  int64  `json:"requests" example:"12"`
+	BytesIn   int64  `json:"bytes_in" example:"1840"`
+	BytesOut  int64  `json:"bytes_out" example:"2210"`
+}
+
+// Report is a principal's usage over a period, in total and by operation
+type Report struct {
+	Principal string    `json:"principal" example:"1"`
+	Since     time.Time `json:"since" example:"2024-01-01T00:00:00Z"`
+	Until     time.Time `json:"until" example:"2024-01-02T15:05:00Z"`
+	Requests  int64     `json:"requests" example:"12"`
+	BytesIn   int64     `json:"bytes_in" example:"1840"`
+	BytesOut  int64     `json:"bytes_out" example:"2210"`
+	// Operations are ordered by operation
+	Operations []OperationUsage `json:"operations"`
+}
+
+// Report returns principal's usage in the windows starting from since and
+// before until, including the current window. Zero times leave the period
+// open, back to the retention.
+func (m *Meter) Report(principal string, since, until time.Time) Report {
+	m.mutex.Lock()
+	now := m.opts.Clock.Now().UTC()
+	m.rotate(now)
+	records := append(slices.Clone(m.history), m.records(m.start.Add(m.opts.Window))...)
+	m.mutex.Unlock()
+
+	report := Report{Principal: principal, Since: since, Until: until, Operations: []OperationUsage{}}
+	if report.Since.IsZero() {
+		report.Since = now.Add(-m.opts.Retention).Truncate(m.opts.Window)
+	}
+	if report.Until.IsZero() {
+		report.Until = m.start.Add(m.opts.Window)
+	}
+	byOperation := make(map[string]*OperationUsage)
+	for _, record := range records {
+		if record.Principal != principal || record.WindowStart.Before(report.Since) || !record.WindowStart.Before(report.Until) {
+			continue
+		}
+		operation, ok := byOperation[record.Operation]
+		if !ok {
+			operation = &OperationUsage{Operation: record.Operation}
+			byOperation[record.Operation] = operation
+		}
+		operation.Requests += record.Requests
+		operation.BytesIn += record.BytesIn
+		operation.BytesOut += record.BytesOut
+		report.Requests += record.Requests
+		report.BytesIn += record.BytesIn
+		report.BytesOut += record.BytesOut
+	}
+	for _, operation := range byOperation {
+		report.Operations = append(report.Operations, *operation)
+	}
+	slices.SortFunc(report.Operations, func(a, b OperationUsage) int {
+		return cmp.Compare(a.Operation, b.Operation)
+	})
+	return report
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to a response body
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,243 @@
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// fakeSink records exports, failing while err is set
+type fakeSink struct {
+	mutex   sync.Mutex
+	records []Record
+	err     error
+	closed  bool
+}
+
+func (s *fakeSink) Export(_ context.Context, records []Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+var start = time.Date(2024, 1, 2, 15, 4, 30, 0, time.UTC)
+
+// serve makes a metered request to operation as principal, with the tenant
+// when not empty
+func serve(m *Meter, operation, principal, tenant, body string) {
+	handler := m.Handler(operation, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	ctx := req.Context()
+	if principal != "" {
+		ctx = reqctx.WithPrincipal(ctx, reqctx.Principal{Subject: principal})
+	}
+	if tenant != "" {
+		ctx = reqctx.WithTenant(ctx, tenant)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+}
+
+func TestMeter_Windows(t *testing.T) {
+	clk := clock.NewFake(start)
+	sink := &fakeSink{}
+	m := NewMeter(sink, Options{Window: time.Minute, Clock: clk})
+
+	serve(m, "POST /api/v1/users", "1", "", "hello")
+	serve(m, "POST /api/v1/users", "1", "", "hi")
+	serve(m, "POST /api/v1/users", "1", "acme", "")
+	serve(m, "GET /api/v1/users", "2", "", "")
+	serve(m, "GET /api/v1/users", "", "", "")
+
+	// Nothing is exported until the window closes
+	require.NoError(t, m.Flush(t.Context()))
+	assert.Empty(t, sink.records)
+
+	clk.Advance(time.Minute)
+	serve(m, "GET /api/v1/users", "1", "", "")
+	require.NoError(t, m.Flush(t.Context()))
+	windowStart := time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)
+	windowEnd := windowStart.Add(time.Minute)
+	assert.Equal(t, []Record{
+		{Principal: "1", Operation: "POST /api/v1/users", WindowStart: windowStart, WindowEnd: windowEnd, Requests: 2, BytesIn: 7, BytesOut: 4},
+		{Principal: "1", Tenant: "acme", Operation: "POST /api/v1/users", WindowStart: windowStart, WindowEnd: windowEnd, Requests: 1, BytesOut: 2},
+		{Principal: "2", Operation: "GET /api/v1/users", WindowStart: windowStart, WindowEnd: windowEnd, Requests: 1, BytesOut: 2},
+	}, sink.records)
+
+	// Stopping exports the current window early and closes the sink
+	m.Start()
+	clk.Advance(10 * time.Second)
+	require.NoError(t, m.Stop(t.Context()))
+	require.Len(t, sink.records, 4)
+	assert.Equal(t, Record{Principal: "1", Operation: "GET /api/v1/users", WindowStart: windowEnd, WindowEnd: clk.Now(), Requests: 1, BytesOut: 2}, sink.records[3])
+	assert.True(t, sink.closed)
+}
+
+func TestMeter_FailingSink(t *testing.T) {
+	clk := clock.NewFake(start)
+	sink := &fakeSink{err: errors.New("unavailable")}
+	m := NewMeter(sink, Options{Window: time.Minute, MaxPending: 2, Clock: clk})
+
+	// Records are kept while the sink fails, dropping the oldest past the
+	// limit
+	for _, principal := range []string{"1", "2", "3"} {
+		serve(m, "GET /api/v1/users", principal, "", "")
+		clk.Advance(time.Minute)
+		assert.Error(t, m.Flush(t.Context()))
+	}
+	assert.Equal(t, uint64(1), m.Dropped())
+
+	sink.err = nil
+	require.NoError(t, m.Flush(t.Context()))
+	require.Len(t, sink.records, 2)
+	assert.Equal(t, "2", sink.records[0].Principal)
+	assert.Equal(t, "3", sink.records[1].Principal)
+
+	require.NoError(t, m.Flush(t.Context()))
+	assert.Len(t, sink.records, 2)
+}
+
+func TestMeter_Report(t *testing.T) {
+	clk := clock.NewFake(start)
+	m := NewMeter(nil, Options{Window: time.Minute, Retention: time.Hour, Clock: clk})
+
+	serve(m, "POST /api/v1/users", "1", "", "hello")
+	serve(m, "GET /api/v1/users", "2", "", "")
+	clk.Advance(time.Minute)
+	serve(m, "GET /api/v1/users", "1", "", "")
+	serve(m, "GET /api/v1/users", "1", "acme", "")
+
+	report := m.Report("1", time.Time{}, time.Time{})
+	assert.Equal(t, int64(3), report.Requests)
+	assert.Equal(t, int64(5), report.BytesIn)
+	assert.Equal(t, int64(6), report.BytesOut)
+	assert.Equal(t, []OperationUsage{
+		{Operation: "GET /api/v1/users", Requests: 2, BytesOut: 4},
+		{Operation: "POST /api/v1/users", Requests: 1, BytesIn: 5, BytesOut: 2},
+	}, report.Operations)
+	assert.Equal(t, time.Date(2024, 1, 2, 15, 6, 0, 0, time.UTC), report.Until)
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		expected     int64
+	}{
+		{name: "first window", until: time.Date(2024, 1, 2, 15, 5, 0, 0, time.UTC), expected: 1},
+		{name: "current window", since: time.Date(2024, 1, 2, 15, 5, 0, 0, time.UTC), expected: 2},
+		{name: "before any", until: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC), expected: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, m.Report("1", tt.since, tt.until).Requests)
+		})
+	}
+
+	// Records past the retention are forgotten
+	clk.Advance(2 * time.Hour)
+	report = m.Report("1", time.Time{}, time.Time{})
+	assert.Zero(t, report.Requests)
+	assert.Empty(t, report.Operations)
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	sink := NewFile(path)
+	require.NoError(t, sink.Export(t.Context(), []Record{{Principal: "1", Requests: 1}}))
+	require.NoError(t, sink.Export(t.Context(), []Record{{Principal: "2", Requests: 2}}))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var principals []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		principals = append(principals, record.Principal)
+	}
+	assert.Equal(t, []string{"1", "2"}, principals)
+}
+
+func TestHTTP(t *testing.T) {
+	var received []Record
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewHTTP(server.URL, "secret", time.Second)
+	require.NoError(t, sink.Export(t.Context(), []Record{{Principal: "1", Requests: 3}}))
+	assert.Equal(t, []Record{{Principal: "1", Requests: 3}}, received)
+
+	status = http.StatusServiceUnavailable
+	assert.ErrorContains(t, sink.Export(t.Context(), []Record{{Principal: "1"}}), "503")
+}
+
+// fakeWriter records the messages written to it
+type fakeWriter struct {
+	messages []kafka.Message
+	err      error
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, messages ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, messages...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestKafka(t *testing.T) {
+	writer := &fakeWriter{}
+	sink := &Kafka{writer: writer}
+	require.NoError(t, sink.Export(t.Context(), []Record{{Principal: "1", Requests: 1}, {Principal: "2", Requests: 2}}))
+	require.Len(t, writer.messages, 2)
+	assert.Equal(t, "2", string(writer.messages[1].Key))
+	var record Record
+	require.NoError(t, json.Unmarshal(writer.messages[1].Value, &record))
+	assert.Equal(t, int64(2), record.Requests)
+
+	writer.err = errors.New("no brokers")
+	assert.ErrorContains(t, sink.Export(t.Context(), []Record{{Principal: "1"}}), "no brokers")
+
+	require.NoError(t, sink.Close())
+	assert.True(t, writer.closed)
+}
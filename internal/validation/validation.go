@@ -0,0 +1,101 @@
+// Package validation checks values against their binding tags, such as
+// binding:"required,email", the tags gin binds requests with, and turns the
+// fields that fail into problems clients can show next to each field,
+// rather than the validator's own messages about Go struct fields.
+package validation
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/dazraf/go-api-example/internal/email"
+	"github.com/dazraf/go-api-example/internal/schemas"
+)
+
+// TagName is the struct tag holding the rules fields must pass
+const TagName = "binding"
+
+var validate = newValidate()
+
+func newValidate() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.SetTagName(TagName)
+	// Problems name fields as clients send them
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return cmp.Or(name, field.Name)
+	})
+	// Emails are checked as everywhere else in the server, accepting
+	// internationalized addresses but not display names
+	if err := v.RegisterValidation("email", func(field validator.FieldLevel) bool {
+		return email.Valid(field.Field().String())
+	}); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Struct checks v, a struct or a pointer to one, against its binding tags,
+// returning a problem for each field that fails its rules, or nil when it
+// passes them all
+func Struct(v any) []schemas.Problem {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+	var failed validator.ValidationErrors
+	if !errors.As(err, &failed) {
+		return []schemas.Problem{{Message: err.Error()}}
+	}
+	problems := make([]schemas.Problem, len(failed))
+	for i, field := range failed {
+		problems[i] = schemas.Problem{Field: path(field), Message: Message(field.Tag(), field.Param(), field.Kind())}
+	}
+	return problems
+}
+
+// path returns the dotted path of the field below the struct validated,
+// such as address.city
+func path(field validator.FieldError) string {
+	_, at, found := strings.Cut(field.Namespace(), ".")
+	if !found {
+		return field.Field()
+	}
+	return at
+}
+
+// Message explains the rule tag, with its param, that a field of kind
+// failed, in words for the people filling in the field
+func Message(tag, param string, kind reflect.Kind) string {
+	switch tag {
+	case "required":
+		return "must not be empty"
+	case "email":
+		return "must be a valid email address, such as john@example.com"
+	case "min", "max", "len":
+		bound := map[string]string{"min": "at least", "max": "at most", "len": "exactly"}[tag]
+		switch kind {
+		case reflect.String:
+			return fmt.Sprintf("must be %s %s characters long", bound, param)
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return fmt.Sprintf("must have %s %s items", bound, param)
+		}
+		return fmt.Sprintf("must be %s %s", bound, param)
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(param), ", ")
+	case "url":
+		return "must be a URL"
+	}
+	if param != "" {
+		return fmt.Sprintf("must pass %s=%s", tag, param)
+	}
+	return "must pass " + tag
+}
@@ -0,0 +1,107 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/store"
+)
+
+func TestStructUser(t *testing.T) {
+	tests := []struct {
+		name string
+		user store.User
+		want []schemas.Problem
+	}{
+		{
+			name: "valid",
+			user: store.User{Name: "John Doe", Email: "john@example.com"},
+		},
+		{
+			name: "internationalized email",
+			user: store.User{Name: "Jörg", Email: "jörg@bücher.de"},
+		},
+		{
+			name: "missing name",
+			user: store.User{Email: "john@example.com"},
+			want: []schemas.Problem{{Field: "name", Message: "must not be empty"}},
+		},
+		{
+			name: "missing email",
+			user: store.User{Name: "John Doe"},
+			want: []schemas.Problem{{Field: "email", Message: "must not be empty"}},
+		},
+		{
+			name: "malformed email",
+			user: store.User{Name: "John Doe", Email: "john"},
+			want: []schemas.Problem{{Field: "email", Message: "must be a valid email address, such as john@example.com"}},
+		},
+		{
+			name: "email with display name",
+			user: store.User{Name: "John Doe", Email: "John <john@example.com>"},
+			want: []schemas.Problem{{Field: "email", Message: "must be a valid email address, such as john@example.com"}},
+		},
+		{
+			name: "empty",
+			want: []schemas.Problem{
+				{Field: "name", Message: "must not be empty"},
+				{Field: "email", Message: "must not be empty"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Struct(tt.user))
+			assert.Equal(t, tt.want, Struct(&tt.user))
+		})
+	}
+}
+
+func TestStructNested(t *testing.T) {
+	type address struct {
+		City string `json:"city" binding:"required"`
+	}
+	type contact struct {
+		Address address  `json:"address"`
+		Tags    []string `json:"tags" binding:"max=2"`
+		Role    string   `json:"role" binding:"omitempty,oneof=admin user"`
+	}
+
+	problems := Struct(contact{Tags: []string{"a", "b", "c"}, Role: "owner"})
+	assert.Equal(t, []schemas.Problem{
+		{Field: "address.city", Message: "must not be empty"},
+		{Field: "tags", Message: "must have at most 2 items"},
+		{Field: "role", Message: "must be one of admin, user"},
+	}, problems)
+}
+
+func TestStructNotStruct(t *testing.T) {
+	problems := Struct("john")
+	assert.Len(t, problems, 1)
+	assert.Empty(t, problems[0].Field)
+}
+
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		tag, param string
+		kind       reflect.Kind
+		want       string
+	}{
+		{"required", "", reflect.String, "must not be empty"},
+		{"email", "", reflect.String, "must be a valid email address, such as john@example.com"},
+		{"min", "2", reflect.String, "must be at least 2 characters long"},
+		{"max", "64", reflect.String, "must be at most 64 characters long"},
+		{"len", "3", reflect.Slice, "must have exactly 3 items"},
+		{"min", "18", reflect.Int, "must be at least 18"},
+		{"oneof", "red green", reflect.String, "must be one of red, green"},
+		{"url", "", reflect.String, "must be a URL"},
+		{"uuid", "", reflect.String, "must pass uuid"},
+		{"gtfield", "Start", reflect.Struct, "must pass gtfield=Start"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Message(tt.tag, tt.param, tt.kind), tt.tag)
+	}
+}
@@ -0,0 +1,137 @@
+// Package views stores named list queries, so clients such as dashboards can
+// ask for GET /api/v1/users?view=active-admins instead of rebuilding the
+// same filters on every call. Views belong to the caller that saved them.
+package views
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+var (
+	// ErrNotFound is returned for views the owner has not saved
+	ErrNotFound = errors.New("view not found")
+	// ErrInvalidName is returned for names that are not lowercase slugs
+	ErrInvalidName = errors.New("view names are 1-64 lowercase letters, digits and dashes, starting with a letter or digit")
+	// ErrLimitReached is returned when saving a new view would exceed the
+	// owner's limit
+	ErrLimitReached = errors.New("view limit reached")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// View is a saved list query
+type View struct {
+	Name string `json:"name" example:"active-admins"`
+	// Query holds the list endpoint's query parameters, URL encoded
+	Query string `json:"query" example:"updated_within=7d"`
+	// Materialized says whether the view's results are kept precomputed
+	// and refreshed in the background, rather than computed per request
+	Materialized bool      `json:"materialized,omitempty" example:"false"`
+	CreatedAt    time.Time `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	UpdatedAt    time.Time `json:"updated_at" example:"2024-01-02T15:04:05Z"`
+}
+
+// Values returns the view's query parameters
+func (v View) Values() url.Values {
+	values, _ := url.ParseQuery(v.Query) // validated when saved
+	return values
+}
+
+// Options configures a Store
+type Options struct {
+	// MaxPerOwner caps how many views each owner can save; zero means no limit
+	MaxPerOwner int
+	Clock       clock.Clock
+}
+
+// Store holds views in memory, keyed by owner and name
+type Store struct {
+	mutex sync.Mutex
+	views map[string]map[string]View
+	opts  Options
+}
+
+// NewStore creates an empty store
+func NewStore(opts Options) *Store {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Store{views: make(map[string]map[string]View), opts: opts}
+}
+
+// Save creates the owner's view with name, or replaces its query and
+// whether it is materialized if it exists
+func (s *Store) Save(owner, name string, query url.Values, materialized bool) (View, error) {
+	if !namePattern.MatchString(name) {
+		return View{}, ErrInvalidName
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	owned, ok := s.views[owner]
+	if !ok {
+		owned = make(map[string]View)
+		s.views[owner] = owned
+	}
+	now := s.opts.Clock.Now().UTC()
+	view, exists := owned[name]
+	if !exists {
+		if s.opts.MaxPerOwner > 0 && len(owned) >= s.opts.MaxPerOwner {
+			return View{}, fmt.Errorf("%w: at most %d views can be saved", ErrLimitReached, s.opts.MaxPerOwner)
+		}
+		view = View{Name: name, CreatedAt: now}
+	}
+	view.Query = query.Encode()
+	view.Materialized = materialized
+	view.UpdatedAt = now
+	owned[name] = view
+	return view, nil
+}
+
+// Get returns the owner's view with name
+func (s *Store) Get(owner, name string) (View, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	view, ok := s.views[owner][name]
+	if !ok {
+		return View{}, ErrNotFound
+	}
+	return view, nil
+}
+
+// List returns the owner's views ordered by name
+func (s *Store) List(owner string) []View {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	views := make([]View, 0, len(s.views[owner]))
+	for _, view := range s.views[owner] {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name < views[j].Name
+	})
+	return views
+}
+
+// Delete removes the owner's view with name
+func (s *Store) Delete(owner, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.views[owner][name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.views[owner], name)
+	return nil
+}
@@ -0,0 +1,60 @@
+package views
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+)
+
+func TestStore(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	store := NewStore(Options{MaxPerOwner: 2, Clock: clk})
+
+	view, err := store.Save("1", "recent", url.Values{"updated_within": {"7d"}}, false)
+	require.NoError(t, err)
+	assert.Equal(t, View{Name: "recent", Query: "updated_within=7d", CreatedAt: start, UpdatedAt: start}, view)
+
+	// Saving again replaces the query but keeps the creation time
+	clk.Advance(time.Hour)
+	view, err = store.Save("1", "recent", url.Values{"updated_within": {"24h"}}, true)
+	require.NoError(t, err)
+	assert.True(t, view.Materialized)
+	assert.Equal(t, start, view.CreatedAt)
+	assert.Equal(t, start.Add(time.Hour), view.UpdatedAt)
+	assert.Equal(t, url.Values{"updated_within": {"24h"}}, view.Values())
+
+	_, err = store.Save("1", "older", url.Values{"created_before": {"2024-01-01"}}, false)
+	require.NoError(t, err)
+	_, err = store.Save("1", "third", nil, false)
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	names := []string{}
+	for _, view := range store.List("1") {
+		names = append(names, view.Name)
+	}
+	assert.Equal(t, []string{"older", "recent"}, names)
+
+	// Other owners neither see nor count against each other's views
+	assert.Empty(t, store.List("2"))
+	_, err = store.Get("2", "recent")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Save("2", "recent", nil, false)
+	assert.NoError(t, err)
+
+	require.NoError(t, store.Delete("1", "recent"))
+	assert.ErrorIs(t, store.Delete("1", "recent"), ErrNotFound)
+}
+
+func TestStore_InvalidNames(t *testing.T) {
+	store := NewStore(Options{})
+	for _, name := range []string{"", "Recent", "-recent", "recent views", "a/b", string(make([]byte, 65))} {
+		_, err := store.Save("1", name, nil, false)
+		assert.ErrorIs(t, err, ErrInvalidName, "name %q", name)
+	}
+}
@@ -0,0 +1,86 @@
+// Package visibility hides fields of API responses from callers whose roles
+// may not see them. Rules name JSON fields, so protecting a new field only
+// takes a rule rather than changes to every handler that returns it.
+package visibility
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+// RoleSelf is held by a caller for records about themselves, so a rule can
+// let users see their own fields
+const RoleSelf = "self"
+
+// Policy maps JSON fields to the roles that may see them. Fields without a
+// rule are visible to everyone.
+type Policy struct {
+	rules map[string][]string
+}
+
+// NewPolicy creates a policy from rules mapping each restricted field to
+// the roles allowed to see it
+func NewPolicy(rules map[string][]string) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Hidden returns the fields the caller of ctx may not see on a record
+// belonging to owner, sorted. It is empty when the caller sees everything,
+// including when p is nil.
+func (p *Policy) Hidden(ctx context.Context, owner string) []string {
+	if p == nil || len(p.rules) == 0 {
+		return nil
+	}
+	principal, authenticated := reqctx.PrincipalFrom(ctx)
+
+	var hidden []string
+	for field, roles := range p.rules {
+		visible := slices.ContainsFunc(roles, func(role string) bool {
+			if !authenticated {
+				return false
+			}
+			if role == RoleSelf {
+				return owner != "" && principal.Subject == owner
+			}
+			return principal.HasRole(role)
+		})
+		if !visible {
+			hidden = append(hidden, field)
+		}
+	}
+	sort.Strings(hidden)
+	return hidden
+}
+
+// Restricts reports whether any field is hidden from the caller of ctx on
+// some record, so callers can skip redaction entirely when it is not
+func (p *Policy) Restricts(ctx context.Context) bool {
+	// Records belonging to no one show the least, as self grants nothing
+	return len(p.Hidden(ctx, "")) > 0
+}
+
+// Redact returns v as a JSON object without the hidden fields, or v itself
+// when nothing is hidden
+func Redact(v any, hidden []string) (any, error) {
+	if len(hidden) == 0 {
+		return v, nil
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record for redaction: %w", err)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return nil, fmt.Errorf("failed to redact record: %w", err)
+	}
+	for _, field := range hidden {
+		delete(object, field)
+	}
+	return object, nil
+}
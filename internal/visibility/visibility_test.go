@@ -0,0 +1,66 @@
+package visibility
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/reqctx"
+)
+
+func TestPolicy_Hidden(t *testing.T) {
+	policy := NewPolicy(map[string][]string{
+		"email":      {"admin", RoleSelf},
+		"last_login": {"admin"},
+	})
+
+	tests := []struct {
+		name      string
+		principal *reqctx.Principal
+		owner     string
+		expected  []string
+	}{
+		{name: "anonymous", owner: "1", expected: []string{"email", "last_login"}},
+		{name: "other user", principal: &reqctx.Principal{Subject: "2"}, owner: "1", expected: []string{"email", "last_login"}},
+		{name: "self", principal: &reqctx.Principal{Subject: "1"}, owner: "1", expected: []string{"last_login"}},
+		{name: "admin", principal: &reqctx.Principal{Subject: "2", Roles: []string{"admin"}}, owner: "1", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = reqctx.WithPrincipal(ctx, *tt.principal)
+			}
+			assert.Equal(t, tt.expected, policy.Hidden(ctx, tt.owner))
+			assert.Equal(t, tt.expected != nil, policy.Restricts(ctx))
+		})
+	}
+}
+
+func TestPolicy_Nil(t *testing.T) {
+	var policy *Policy
+	assert.Empty(t, policy.Hidden(context.Background(), "1"))
+	assert.False(t, policy.Restricts(context.Background()))
+}
+
+func TestRedact(t *testing.T) {
+	type record struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	original := record{Name: "Alice", Email: "alice@example.com"}
+
+	same, err := Redact(original, nil)
+	require.NoError(t, err)
+	assert.Equal(t, original, same)
+
+	redacted, err := Redact(original, []string{"email", "missing"})
+	require.NoError(t, err)
+	encoded, err := json.Marshal(redacted)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Alice"}`, string(encoded))
+}
@@ -0,0 +1,257 @@
+// Package watchdog keeps an eye on the process itself: its goroutine count,
+// heap size and garbage collection pauses. Bounds that are exceeded raise
+// alerts, which are logged, counted and optionally posted to a webhook, and
+// a heap profile can be captured to blob storage for postmortems.
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+// logger logs alerts and the profiles captured for them
+var logger = logging.Named(logging.Jobs)
+
+// Checks made of the process
+const (
+	CheckGoroutines = "goroutines"
+	CheckHeap       = "heap"
+	CheckGCPause    = "gc_pause"
+)
+
+// Checks lists the checks, in the order they are made
+var Checks = []string{CheckGoroutines, CheckHeap, CheckGCPause}
+
+// Stats are the readings the checks are made against
+type Stats struct {
+	Goroutines int
+	// HeapBytes is the memory held by live and not yet swept heap objects
+	HeapBytes uint64
+	// GCPause is the longest stop-the-world pause since the last reading
+	GCPause time.Duration
+}
+
+// Options configures a Watchdog. Bounds left at zero are not checked.
+type Options struct {
+	MaxGoroutines int
+	MaxHeapBytes  uint64
+	MaxGCPause    time.Duration
+	// WebhookURL is posted every alert raised, as JSON
+	WebhookURL string
+	// WebhookSecret signs webhook bodies like notification webhooks
+	WebhookSecret string
+	// Storage is where heap profiles are captured when the heap bound is
+	// exceeded; profiles are not captured when nil
+	Storage uploads.Storage
+	// ProfileCooldown is the least time between heap profiles
+	ProfileCooldown time.Duration
+	Clock           clock.Clock
+	// Read takes the readings, defaulting to the Go runtime's
+	Read func() Stats
+}
+
+// Alert is raised when a reading exceeds its bound, and posted to the
+// webhook
+type Alert struct {
+	Check string `json:"check"`
+	// Value and Limit are counts, bytes or nanoseconds, by check
+	Value float64   `json:"value"`
+	Limit float64   `json:"limit"`
+	Time  time.Time `json:"time"`
+	// Profile is the storage key of the heap profile captured, if any
+	Profile string `json:"profile,omitempty"`
+}
+
+// Watchdog checks the process's readings against their bounds
+type Watchdog struct {
+	opts   Options
+	client *http.Client
+
+	mu sync.Mutex
+	// raised holds the checks over their bounds at the last reading, so an
+	// alert is raised once when a bound is crossed rather than every check
+	raised      map[string]bool
+	lastProfile time.Time
+	alerts      map[string]*atomic.Uint64
+}
+
+// New creates a watchdog with opts
+func New(opts Options) *Watchdog {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.Read == nil {
+		opts.Read = newRuntimeReader()
+	}
+	w := &Watchdog{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		raised: make(map[string]bool, len(Checks)),
+		alerts: make(map[string]*atomic.Uint64, len(Checks)),
+	}
+	for _, check := range Checks {
+		w.alerts[check] = new(atomic.Uint64)
+	}
+	return w
+}
+
+// Alerts returns how many alerts check has raised
+func (w *Watchdog) Alerts(check string) uint64 {
+	if counter, ok := w.alerts[check]; ok {
+		return counter.Load()
+	}
+	return 0
+}
+
+// Check takes a reading and raises an alert for each bound newly exceeded,
+// returning them
+func (w *Watchdog) Check(ctx context.Context) []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := w.opts.Read()
+	readings := []struct {
+		check        string
+		value, limit float64
+	}{
+		{CheckGoroutines, float64(stats.Goroutines), float64(w.opts.MaxGoroutines)},
+		{CheckHeap, float64(stats.HeapBytes), float64(w.opts.MaxHeapBytes)},
+		{CheckGCPause, float64(stats.GCPause), float64(w.opts.MaxGCPause)},
+	}
+
+	var alerts []Alert
+	for _, reading := range readings {
+		over := reading.limit > 0 && reading.value > reading.limit
+		if !over {
+			if w.raised[reading.check] {
+				logger.InfoContext(ctx, "Watchdog check back within bounds",
+					"check", reading.check, "value", reading.value, "limit", reading.limit)
+			}
+			w.raised[reading.check] = false
+			continue
+		}
+		if w.raised[reading.check] {
+			continue
+		}
+		w.raised[reading.check] = true
+		w.alerts[reading.check].Add(1)
+
+		alert := Alert{Check: reading.check, Value: reading.value, Limit: reading.limit, Time: w.opts.Clock.Now()}
+		if reading.check == CheckHeap {
+			alert.Profile = w.captureProfile(ctx)
+		}
+		logger.WarnContext(ctx, "Watchdog bound exceeded",
+			"check", alert.Check, "value", alert.Value, "limit", alert.Limit, "profile", alert.Profile)
+		alerts = append(alerts, alert)
+	}
+
+	for _, alert := range alerts {
+		if err := w.post(ctx, alert); err != nil {
+			logger.ErrorContext(ctx, "Failed to post watchdog alert", "check", alert.Check, "error", err)
+		}
+	}
+	return alerts
+}
+
+// captureProfile writes a heap profile to storage, returning its key, or
+// nothing when there is no storage or the last one is too recent
+func (w *Watchdog) captureProfile(ctx context.Context) string {
+	now := w.opts.Clock.Now()
+	if w.opts.Storage == nil || (!w.lastProfile.IsZero() && now.Sub(w.lastProfile) < w.opts.ProfileCooldown) {
+		return ""
+	}
+
+	var profile bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&profile, 0); err != nil {
+		logger.ErrorContext(ctx, "Failed to capture heap profile", "error", err)
+		return ""
+	}
+	key := "profiles/heap-" + now.UTC().Format("20060102T150405Z") + ".pb.gz"
+	if err := w.opts.Storage.Put(ctx, key, "application/octet-stream", &profile, int64(profile.Len())); err != nil {
+		logger.ErrorContext(ctx, "Failed to store heap profile", "key", key, "error", err)
+		return ""
+	}
+	w.lastProfile = now
+	return key
+}
+
+// post sends alert to the webhook, when one is configured
+func (w *Watchdog) post(ctx context.Context, alert Alert) error {
+	if w.opts.WebhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.opts.WebhookSecret != "" {
+		req.Header.Set(notify.SignatureHeader, notify.Sign(w.opts.WebhookSecret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Run checks the process every interval until ctx is cancelled
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Check(ctx)
+		}
+	}
+}
+
+// newRuntimeReader returns a reader of the Go runtime's stats, which
+// remembers the collections it has seen so pauses are only counted once
+func newRuntimeReader() func() Stats {
+	var lastGC uint32
+	return func() Stats {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		// PauseNs is a ring of the latest 256 pauses
+		var pause uint64
+		for gc := max(lastGC, mem.NumGC-min(mem.NumGC, 256)); gc < mem.NumGC; gc++ {
+			pause = max(pause, mem.PauseNs[gc%256])
+		}
+		lastGC = mem.NumGC
+
+		return Stats{
+			Goroutines: runtime.NumGoroutine(),
+			HeapBytes:  mem.HeapAlloc,
+			GCPause:    time.Duration(pause),
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package watchdog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/uploads"
+)
+
+func TestWatchdog_Check(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		posted []Alert
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		assert.NotEmpty(t, r.Header.Get(notify.SignatureHeader))
+		mu.Lock()
+		posted = append(posted, alert)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	storage, err := uploads.NewLocalStorage(t.TempDir(), "http://localhost", clk)
+	require.NoError(t, err)
+	stats := Stats{Goroutines: 10, HeapBytes: 100, GCPause: time.Millisecond}
+	watchdog := New(Options{
+		MaxGoroutines:   100,
+		MaxHeapBytes:    1000,
+		WebhookURL:      webhook.URL,
+		WebhookSecret:   "secret",
+		Storage:         storage,
+		ProfileCooldown: time.Hour,
+		Clock:           clk,
+		Read:            func() Stats { return stats },
+	})
+	ctx := context.Background()
+
+	// Readings within bounds raise nothing, and unbounded ones are not
+	// checked
+	stats.GCPause = time.Minute
+	assert.Empty(t, watchdog.Check(ctx))
+
+	// Exceeding a bound raises an alert, once until it is back within it
+	stats.Goroutines = 200
+	alerts := watchdog.Check(ctx)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, CheckGoroutines, alerts[0].Check)
+	assert.Equal(t, float64(200), alerts[0].Value)
+	assert.Equal(t, float64(100), alerts[0].Limit)
+	assert.Empty(t, watchdog.Check(ctx))
+	stats.Goroutines = 10
+	assert.Empty(t, watchdog.Check(ctx))
+	stats.Goroutines = 200
+	assert.Len(t, watchdog.Check(ctx), 1)
+	assert.Equal(t, uint64(2), watchdog.Alerts(CheckGoroutines))
+
+	// Heap alerts capture a profile to storage
+	stats.HeapBytes = 2000
+	alerts = watchdog.Check(ctx)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "profiles/heap-20240301T120000Z.pb.gz", alerts[0].Profile)
+	size, err := storage.Stat(ctx, alerts[0].Profile)
+	require.NoError(t, err)
+	assert.Positive(t, size)
+
+	// Profiles are not captured again within the cooldown
+	stats.HeapBytes = 100
+	watchdog.Check(ctx)
+	stats.HeapBytes = 2000
+	alerts = watchdog.Check(ctx)
+	require.Len(t, alerts, 1)
+	assert.Empty(t, alerts[0].Profile)
+	assert.Equal(t, uint64(2), watchdog.Alerts(CheckHeap))
+	assert.Zero(t, watchdog.Alerts(CheckGCPause))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, posted, 4)
+	assert.Equal(t, CheckHeap, posted[2].Check)
+	assert.Equal(t, alerts[0].Limit, posted[3].Limit)
+}
+
+func TestRuntimeReader(t *testing.T) {
+	read := newRuntimeReader()
+	stats := read()
+	assert.Positive(t, stats.Goroutines)
+	assert.Positive(t, stats.HeapBytes)
+}
@@ -0,0 +1,456 @@
+// Package webhooks holds webhook subscriptions that admins register for
+// user events, and delivers the events to them. Bodies are signed with each
+// subscription's secret like notification webhooks, so receivers can check
+// them with notify.Sign. Deliveries are CloudEvents in binary content mode:
+// the body is the event's data and its attributes are ce- headers, including
+// the URI of the schema the data conforms to.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/logging"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/tenants"
+)
+
+// logger logs failed deliveries, which outlive the requests that caused them
+var logger = logging.Named(logging.Events)
+
+// Source is the CloudEvents source of deliveries
+const Source = "/api/v1/users"
+
+// Events lists the events subscriptions can receive
+var Events = []string{notify.EventUserCreated, notify.EventUserUpdated}
+
+var (
+	// ErrNotFound is returned for subscriptions that do not exist
+	ErrNotFound = errors.New("webhook not found")
+	// ErrInvalidName is returned for names that are not lowercase slugs
+	ErrInvalidName = errors.New("webhook names are 1-64 lowercase letters, digits and dashes, starting with a letter or digit")
+	// ErrNameTaken is returned when another subscription has the name
+	ErrNameTaken = errors.New("a webhook with this name already exists")
+	// ErrInvalid is returned for subscriptions with a bad URL or events
+	ErrInvalid = errors.New("invalid webhook")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Webhook is a subscription, without its secret
+type Webhook struct {
+	ID   string `json:"id" example:"3f2b9c0e8a1d4b7c9e6f5a4b3c2d1e0f"`
+	Name string `json:"name" example:"crm-sync"`
+	URL  string `json:"url" example:"https://crm.example.com/hooks/users"`
+	// Events are the events posted to the URL
+	Events  []string `json:"events" example:"user.created,user.updated"`
+	Enabled bool     `json:"enabled" example:"true"`
+	// HasSecret says whether bodies are signed; the secret is never shown
+	HasSecret bool      `json:"has_secret" example:"true"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-02T15:04:05Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2024-01-02T15:04:05Z"`
+}
+
+// Spec is what an admin sets on a subscription
+type Spec struct {
+	Name    string
+	URL     string
+	Events  []string
+	Enabled bool
+	// Secret signs bodies; none are signed when it is empty
+	Secret string
+}
+
+// Event is the body posted to subscriptions
+type Event struct {
+	Event string     `json:"event" example:"user.updated"`
+	Time  time.Time  `json:"time" example:"2024-01-02T15:04:05Z"`
+	User  store.User `json:"user"`
+}
+
+// Options configures a Store
+type Options struct {
+	// Timeout bounds a single delivery
+	Timeout time.Duration
+	Clock   clock.Clock
+	IDs     idgen.Generator
+	// Schemas validates events against the latest schema of their type
+	// before they are delivered, and names it as their dataschema
+	Schemas *schemas.Registry
+}
+
+// subscription is a webhook with its secret
+type subscription struct {
+	Webhook
+	secret string
+}
+
+// Store holds subscriptions in memory and delivers events to them. It is a
+// handlers.UserListener.
+type Store struct {
+	mutex         sync.Mutex
+	subscriptions map[string]*subscription
+	opts          Options
+	client        *http.Client
+
+	pending sync.WaitGroup
+}
+
+// NewStore creates a store without subscriptions
+func NewStore(opts Options) *Store {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.IDs == nil {
+		opts.IDs = idgen.NewRandom()
+	}
+	return &Store{
+		subscriptions: make(map[string]*subscription),
+		opts:          opts,
+		client:        &http.Client{Timeout: opts.Timeout},
+	}
+}
+
+// Create adds a subscription
+func (s *Store) Create(spec Spec) (Webhook, error) {
+	spec, err := normalize(spec)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.byName(spec.Name) != nil {
+		return Webhook{}, ErrNameTaken
+	}
+	now := s.opts.Clock.Now().UTC()
+	sub := &subscription{Webhook: Webhook{ID: s.opts.IDs.NewID(), CreatedAt: now}}
+	sub.set(spec, now)
+	s.subscriptions[sub.ID] = sub
+	return sub.view(), nil
+}
+
+// Get returns the subscription with id
+func (s *Store) Get(id string) (Webhook, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	return sub.view(), nil
+}
+
+// List returns the subscriptions ordered by name, only the one named name
+// when it is not empty
+func (s *Store) List(name string) []Webhook {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webhooks := make([]Webhook, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		if name == "" || sub.Name == name {
+			webhooks = append(webhooks, sub.view())
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Name < webhooks[j].Name
+	})
+	return webhooks
+}
+
+// Update replaces what is set on the subscription with id, including its
+// secret. Setting what is already set changes nothing, including the
+// update time.
+func (s *Store) Update(id string, spec Spec) (Webhook, error) {
+	spec, err := normalize(spec)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	if other := s.byName(spec.Name); other != nil && other.ID != id {
+		return Webhook{}, ErrNameTaken
+	}
+	if sub.spec().differs(spec) {
+		sub.set(spec, s.opts.Clock.Now().UTC())
+	}
+	return sub.view(), nil
+}
+
+// Delete removes the subscription with id
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.subscriptions, id)
+	return nil
+}
+
+// Record is a subscription with its secret, as backed up
+type Record struct {
+	Webhook
+	Secret string `json:"secret,omitempty" example:"s3cret"`
+}
+
+// Export returns every subscription with its secret, ordered by name
+func (s *Store) Export() []Record {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]Record, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		records = append(records, Record{Webhook: sub.view(), Secret: sub.secret})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// Import adds the subscriptions in records, replacing those with the same
+// IDs. Nothing is imported unless every record is valid.
+func (s *Store) Import(records []Record) error {
+	imported := make([]*subscription, len(records))
+	for i, record := range records {
+		if record.ID == "" {
+			return fmt.Errorf("%w: webhook %q has no ID", ErrInvalid, record.Name)
+		}
+		spec, err := normalize(Spec{Name: record.Name, URL: record.URL, Events: record.Events, Enabled: record.Enabled, Secret: record.Secret})
+		if err != nil {
+			return fmt.Errorf("webhook %s: %w", record.ID, err)
+		}
+		sub := &subscription{Webhook: Webhook{ID: record.ID, CreatedAt: record.CreatedAt}}
+		sub.set(spec, record.UpdatedAt)
+		imported[i] = sub
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Names must stay unique among the subscriptions kept and those imported
+	names := make(map[string]string, len(s.subscriptions)+len(imported))
+	for _, sub := range s.subscriptions {
+		if !slices.ContainsFunc(imported, func(other *subscription) bool { return other.ID == sub.ID }) {
+			names[sub.Name] = sub.ID
+		}
+	}
+	for _, sub := range imported {
+		if id, taken := names[sub.Name]; taken && id != sub.ID {
+			return fmt.Errorf("webhook %q: %w", sub.Name, ErrNameTaken)
+		}
+		names[sub.Name] = sub.ID
+	}
+	for _, sub := range imported {
+		s.subscriptions[sub.ID] = sub
+	}
+	return nil
+}
+
+// UserCreated posts user.created to the subscriptions for it
+func (s *Store) UserCreated(ctx context.Context, user store.User) {
+	s.Deliver(ctx, notify.EventUserCreated, user)
+}
+
+// UserUpdated posts user.updated to the subscriptions for it
+func (s *Store) UserUpdated(ctx context.Context, _, after store.User) {
+	s.Deliver(ctx, notify.EventUserUpdated, after)
+}
+
+// Deliver posts event about user to the enabled subscriptions for it, and
+// to the webhooks of the tenant of the request in ctx, in the background
+func (s *Store) Deliver(ctx context.Context, event string, user store.User) {
+	now := s.opts.Clock.Now().UTC()
+	body, err := json.Marshal(Event{Event: event, Time: now, User: user})
+	if err != nil {
+		logging.FromContext(ctx, logging.Events).Error("Failed to encode webhook event", "event", event, "error", err)
+		return
+	}
+	attributes := cloudEvent{ID: s.opts.IDs.NewID(), Type: event, Time: now, Subject: strconv.FormatInt(user.ID, 10)}
+	if s.opts.Schemas != nil {
+		// Consumers are promised events match the schema they name, so
+		// those that don't are not sent
+		schema, err := s.opts.Schemas.Latest(event)
+		if err == nil {
+			err = s.opts.Schemas.Validate(schema, body)
+		}
+		if err != nil {
+			logging.FromContext(ctx, logging.Events).Error("Webhook event does not match its schema", "event", event, "user_id", user.ID, "error", err)
+			return
+		}
+		attributes.DataSchema = schema.URI
+	}
+
+	s.mutex.Lock()
+	var targets []subscription
+	for _, sub := range s.subscriptions {
+		if sub.Enabled && slices.Contains(sub.Events, event) {
+			targets = append(targets, *sub)
+		}
+	}
+	s.mutex.Unlock()
+	if settings, ok := tenants.SettingsFrom(ctx); ok {
+		tenant, _ := reqctx.Tenant(ctx)
+		for _, webhook := range settings.Webhooks {
+			if slices.Contains(webhook.Events, event) {
+				targets = append(targets, subscription{Webhook: Webhook{Name: "tenant " + tenant, URL: webhook.URL}, secret: webhook.Secret})
+			}
+		}
+	}
+
+	for _, target := range targets {
+		// Delivery outlives the request that triggered it
+		s.pending.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+			defer cancel()
+			if err := s.post(ctx, target, attributes, body); err != nil {
+				logger.Error("Failed to deliver webhook", "webhook", target.Name, "event", event, "user_id", user.ID, "error", err)
+			}
+		})
+	}
+}
+
+// cloudEvent holds the CloudEvents attributes of a delivery besides those
+// every delivery shares
+type cloudEvent struct {
+	ID         string
+	Type       string
+	Time       time.Time
+	Subject    string
+	DataSchema string
+}
+
+func (s *Store) post(ctx context.Context, target subscription, attributes cloudEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", attributes.ID)
+	req.Header.Set("ce-source", Source)
+	req.Header.Set("ce-type", attributes.Type)
+	req.Header.Set("ce-time", attributes.Time.Format(time.RFC3339Nano))
+	req.Header.Set("ce-subject", attributes.Subject)
+	if attributes.DataSchema != "" {
+		req.Header.Set("ce-dataschema", attributes.DataSchema)
+	}
+	if target.secret != "" {
+		req.Header.Set(notify.SignatureHeader, notify.Sign(target.secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Wait waits for deliveries in flight, until ctx expires
+func (s *Store) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// byName returns the subscription named name, if any
+func (s *Store) byName(name string) *subscription {
+	for _, sub := range s.subscriptions {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (sub *subscription) set(spec Spec, now time.Time) {
+	sub.Name, sub.URL, sub.Events, sub.Enabled = spec.Name, spec.URL, spec.Events, spec.Enabled
+	sub.secret = spec.Secret
+	sub.HasSecret = spec.Secret != ""
+	sub.UpdatedAt = now
+}
+
+func (sub *subscription) spec() Spec {
+	return Spec{Name: sub.Name, URL: sub.URL, Events: sub.Events, Enabled: sub.Enabled, Secret: sub.secret}
+}
+
+func (sub *subscription) view() Webhook {
+	webhook := sub.Webhook
+	webhook.Events = slices.Clone(sub.Events)
+	return webhook
+}
+
+func (spec Spec) differs(other Spec) bool {
+	return spec.Name != other.Name || spec.URL != other.URL || spec.Enabled != other.Enabled ||
+		spec.Secret != other.Secret || !slices.Equal(spec.Events, other.Events)
+}
+
+// normalize validates spec, sorting its events so equal sets compare equal
+func normalize(spec Spec) (Spec, error) {
+	if !namePattern.MatchString(spec.Name) {
+		return Spec{}, ErrInvalidName
+	}
+	target, err := url.Parse(spec.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return Spec{}, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+	if len(spec.Events) == 0 {
+		return Spec{}, fmt.Errorf("%w: subscribe to at least one of %s", ErrInvalid, strings.Join(Events, ", "))
+	}
+	events := make([]string, 0, len(spec.Events))
+	for _, event := range spec.Events {
+		if !slices.Contains(Events, event) {
+			return Spec{}, fmt.Errorf("%w: unknown event %q, expected one of %s", ErrInvalid, event, strings.Join(Events, ", "))
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	slices.Sort(events)
+	spec.Events = events
+	return spec, nil
+}
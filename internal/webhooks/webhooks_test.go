@@ -0,0 +1,165 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/internal/clock"
+	"github.com/dazraf/go-api-example/internal/idgen"
+	"github.com/dazraf/go-api-example/internal/notify"
+	"github.com/dazraf/go-api-example/internal/reqctx"
+	"github.com/dazraf/go-api-example/internal/schemas"
+	"github.com/dazraf/go-api-example/internal/store"
+	"github.com/dazraf/go-api-example/internal/tenants"
+)
+
+func TestStore(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	hooks := NewStore(Options{Clock: clk, IDs: idgen.NewSequence("hook")})
+	spec := Spec{
+		Name:    "crm-sync",
+		URL:     "https://crm.example.com/hooks/users",
+		Events:  []string{notify.EventUserUpdated, notify.EventUserCreated},
+		Enabled: true,
+		Secret:  "s3cret",
+	}
+
+	webhook, err := hooks.Create(spec)
+	require.NoError(t, err)
+	assert.Equal(t, []string{notify.EventUserCreated, notify.EventUserUpdated}, webhook.Events)
+	assert.True(t, webhook.HasSecret)
+	_, err = hooks.Create(spec)
+	assert.ErrorIs(t, err, ErrNameTaken)
+
+	tests := []struct {
+		name string
+		spec Spec
+		want error
+	}{
+		{name: "bad name", spec: Spec{Name: "CRM", URL: spec.URL, Events: spec.Events}, want: ErrInvalidName},
+		{name: "relative url", spec: Spec{Name: "other", URL: "/hooks", Events: spec.Events}, want: ErrInvalid},
+		{name: "other scheme", spec: Spec{Name: "other", URL: "ftp://example.com", Events: spec.Events}, want: ErrInvalid},
+		{name: "no events", spec: Spec{Name: "other", URL: spec.URL}, want: ErrInvalid},
+		{name: "unknown event", spec: Spec{Name: "other", URL: spec.URL, Events: []string{"user.deleted"}}, want: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hooks.Create(tt.spec)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	assert.Equal(t, []Webhook{webhook}, hooks.List("crm-sync"))
+	assert.Empty(t, hooks.List("other"))
+
+	// Sending what is already set changes nothing
+	clk.Advance(time.Hour)
+	same, err := hooks.Update(webhook.ID, spec)
+	require.NoError(t, err)
+	assert.Equal(t, webhook, same)
+
+	spec.Secret = ""
+	updated, err := hooks.Update(webhook.ID, spec)
+	require.NoError(t, err)
+	assert.False(t, updated.HasSecret)
+	assert.Equal(t, clk.Now(), updated.UpdatedAt)
+
+	require.NoError(t, hooks.Delete(webhook.ID))
+	_, err = hooks.Get(webhook.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_Deliver(t *testing.T) {
+	var (
+		mutex      sync.Mutex
+		bodies     [][]byte
+		signatures []string
+		headers    []http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		bodies = append(bodies, body)
+		signatures = append(signatures, r.Header.Get(notify.SignatureHeader))
+		headers = append(headers, r.Header)
+	}))
+	defer server.Close()
+
+	registry, err := schemas.NewRegistry("https://api.example.com")
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	hooks := NewStore(Options{Clock: clk, IDs: idgen.NewSequence("event"), Schemas: registry})
+	_, err = hooks.Create(Spec{Name: "created", URL: server.URL, Events: []string{notify.EventUserCreated}, Enabled: true, Secret: "s3cret"})
+	require.NoError(t, err)
+	_, err = hooks.Create(Spec{Name: "disabled", URL: server.URL, Events: []string{notify.EventUserCreated}})
+	require.NoError(t, err)
+
+	user := store.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+	hooks.UserCreated(context.Background(), user)
+	hooks.UserUpdated(context.Background(), user, user)
+	require.NoError(t, hooks.Wait(context.Background()))
+
+	// Only the enabled subscription to user.created is posted to
+	require.Len(t, bodies, 1)
+	var event Event
+	require.NoError(t, json.Unmarshal(bodies[0], &event))
+	assert.Equal(t, notify.EventUserCreated, event.Event)
+	assert.Equal(t, user.ID, event.User.ID)
+	assert.Equal(t, notify.Sign("s3cret", bodies[0]), signatures[0])
+
+	// Deliveries are CloudEvents naming the schema their body matches
+	assert.Equal(t, "1.0", headers[0].Get("ce-specversion"))
+	assert.NotEmpty(t, headers[0].Get("ce-id"))
+	assert.Equal(t, Source, headers[0].Get("ce-source"))
+	assert.Equal(t, notify.EventUserCreated, headers[0].Get("ce-type"))
+	assert.Equal(t, "2024-01-02T15:04:05Z", headers[0].Get("ce-time"))
+	assert.Equal(t, "1", headers[0].Get("ce-subject"))
+	assert.Equal(t, "https://api.example.com/schemas/user.created/1", headers[0].Get("ce-dataschema"))
+
+	// Events that don't match their schema are not sent
+	hooks.UserCreated(context.Background(), store.User{ID: 2, Name: "Jane Smith", Email: "not an email"})
+	require.NoError(t, hooks.Wait(context.Background()))
+	assert.Len(t, bodies, 1)
+}
+
+func TestStore_DeliverTenantWebhooks(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		// signatures maps the paths posted to to their signatures
+		signatures = make(map[string]string)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		signatures[r.URL.Path] = r.Header.Get(notify.SignatureHeader)
+	}))
+	defer server.Close()
+
+	hooks := NewStore(Options{})
+	_, err := hooks.Create(Spec{Name: "crm-sync", URL: server.URL + "/crm", Events: []string{notify.EventUserCreated}, Enabled: true})
+	require.NoError(t, err)
+
+	ctx := reqctx.WithTenant(context.Background(), "acme")
+	ctx = tenants.WithSettings(ctx, tenants.Settings{Webhooks: []tenants.Webhook{
+		{URL: server.URL + "/acme", Events: []string{notify.EventUserCreated}, Secret: "s3cret"},
+		{URL: server.URL + "/acme-updates", Events: []string{notify.EventUserUpdated}},
+	}})
+	hooks.UserCreated(ctx, store.User{ID: 1, Name: "John Doe", Email: "john@acme.example.com"})
+	require.NoError(t, hooks.Wait(context.Background()))
+
+	// The tenant's webhooks for the event are posted to besides the
+	// subscriptions
+	require.Len(t, signatures, 2)
+	assert.Empty(t, signatures["/crm"])
+	assert.NotEmpty(t, signatures["/acme"])
+}
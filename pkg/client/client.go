@@ -0,0 +1,238 @@
+// Package client is a Go client for the users API, for services and tools
+// that call a deployment rather than link the server's packages.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User is a user as the API represents it
+type User struct {
+	ID         int64      `json:"id,omitempty"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at,omitzero"`
+	UpdatedAt  time.Time  `json:"updated_at,omitzero"`
+	// Version is one more on each update, as the ETag of the user's
+	// responses
+	Version int64 `json:"version,omitempty"`
+}
+
+// Error is returned for responses with a status other than 2xx
+type Error struct {
+	StatusCode int `json:"-"`
+	// Message is the error the API gave, empty when the body was not an
+	// error response
+	Message string `json:"error"`
+	// Code says what went wrong, such as USER_NOT_FOUND, empty when the
+	// body was not an error response
+	Code string `json:"code"`
+	// TraceID matches the error to the server's logs
+	TraceID string `json:"trace_id"`
+	// ContentType is the response's media type
+	ContentType string `json:"-"`
+	// Body is the response body, kept when it was not an error response
+	Body []byte `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// StatusCode returns the status of the response err is for, or 0 when err
+// is not an *Error
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// ErrorCode returns the code of the error response err is for, or "" when
+// err is not an *Error or the response had none
+func ErrorCode(err error) string {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
+
+// Client calls the users API at a base URL
+type Client struct {
+	baseURL string
+	// HTTPClient sends requests, defaulting to one with a 30 second timeout
+	HTTPClient *http.Client
+	// Token is sent as a bearer token, when set
+	Token string
+}
+
+// New creates a client for the API at baseURL, such as
+// https://api.example.com
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ListQuery narrows the users listed. Zero fields do not filter.
+type ListQuery struct {
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Extra holds other query parameters, sent as they are
+	Extra url.Values
+}
+
+func (q ListQuery) values() url.Values {
+	values := url.Values{}
+	for key, vals := range q.Extra {
+		values[key] = vals
+	}
+	if !q.CreatedAfter.IsZero() {
+		values.Set("created_after", q.CreatedAfter.Format(time.RFC3339Nano))
+	}
+	if !q.CreatedBefore.IsZero() {
+		values.Set("created_before", q.CreatedBefore.Format(time.RFC3339Nano))
+	}
+	return values
+}
+
+// ListUsers lists the users passing query
+func (c *Client) ListUsers(ctx context.Context, query ListQuery) ([]User, error) {
+	var users []User
+	path := "/api/v1/users"
+	if values := query.values(); len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+	_, err := c.Do(ctx, http.MethodGet, path, nil, &users)
+	return users, err
+}
+
+// GetUser gets the user with id
+func (c *Client) GetUser(ctx context.Context, id int64) (*User, error) {
+	var user User
+	if _, err := c.Do(ctx, http.MethodGet, userPath(id), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser creates user, returning it as stored
+func (c *Client) CreateUser(ctx context.Context, user User) (*User, error) {
+	var created User
+	if _, err := c.Do(ctx, http.MethodPost, "/api/v1/users", user, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateUser replaces the user with id, returning it as stored. When user
+// has a version, such as when it was read and changed, it is sent in
+// If-Match, so the update fails with 412 if another write came first.
+func (c *Client) UpdateUser(ctx context.Context, id int64, user User) (*User, error) {
+	var header http.Header
+	if user.Version != 0 {
+		header = http.Header{"If-Match": {strconv.Quote(strconv.FormatInt(user.Version, 10))}}
+	}
+	var updated User
+	if _, err := c.do(ctx, http.MethodPut, userPath(id), header, user, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteUser deletes the user with id. Deletions that need approval are
+// accepted with 202 rather than done, which is reported as an *Error.
+func (c *Client) DeleteUser(ctx context.Context, id int64) error {
+	resp, err := c.Do(ctx, http.MethodDelete, userPath(id), nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return &Error{StatusCode: resp.StatusCode, Message: "deletion was not done straight away"}
+	}
+	return nil
+}
+
+func userPath(id int64) string {
+	return "/api/v1/users/" + strconv.FormatInt(id, 10)
+}
+
+// Do sends a request with body encoded as JSON, when not nil, and decodes
+// a 2xx response into out, when not nil. Other responses are returned as
+// an *Error. The response is returned with its body consumed.
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) (*http.Response, error) {
+	return c.do(ctx, method, path, nil, body, out)
+}
+
+// do is Do sending header too
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body, out any) (*http.Response, error) {
+	var reader io.Reader
+	if raw, ok := body.([]byte); ok {
+		// Raw bodies are sent as they are, e.g. to check malformed JSON is
+		// rejected
+		reader = bytes.NewReader(raw)
+	} else if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message, apiErr.Code = "", ""
+			apiErr.Body = data
+		}
+		return resp, apiErr
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp, fmt.Errorf("failed to decode %s %s response: %w", method, path, err)
+		}
+	}
+	return resp, nil
+}
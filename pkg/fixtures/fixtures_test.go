@@ -0,0 +1,105 @@
+package fixtures
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dazraf/go-api-example/pkg/client"
+)
+
+func TestFactory_Deterministic(t *testing.T) {
+	assert.Equal(t, New(7).Users(20), New(7).Users(20))
+	assert.NotEqual(t, New(7).Users(20), New(8).Users(20))
+
+	Reset()
+	first := Users(5)
+	Reset()
+	assert.Equal(t, first, Users(5))
+}
+
+func TestFactory_Users(t *testing.T) {
+	users := New(1).Users(500)
+	emails := make(map[string]bool, len(users))
+	for _, user := range users {
+		assert.NotEmpty(t, user.Name)
+		assert.Regexp(t, `^[a-z]+\.[a-z]+\.\d+@example\.com$`, user.Email)
+		assert.Zero(t, user.ID)
+		assert.Zero(t, user.CreatedAt)
+		emails[user.Email] = true
+	}
+	assert.Len(t, emails, len(users), "emails are distinct")
+}
+
+func TestFactory_ConcurrentUsersAreDistinct(t *testing.T) {
+	factory := New(1)
+	var (
+		mutex  sync.Mutex
+		emails = make(map[string]bool)
+		wg     sync.WaitGroup
+	)
+	for range 8 {
+		wg.Go(func() {
+			for _, user := range factory.Users(100) {
+				mutex.Lock()
+				emails[user.Email] = true
+				mutex.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	assert.Len(t, emails, 800)
+}
+
+func TestUserBuilder(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	// Each case builds the first user of a fresh factory, named so
+	base := New(1).User().Build()
+
+	tests := []struct {
+		name     string
+		builder  *UserBuilder
+		expected client.User
+	}{
+		{
+			name:     "defaults",
+			builder:  New(1).User(),
+			expected: base,
+		},
+		{
+			name:     "email",
+			builder:  New(1).User().WithEmail("jane@example.com"),
+			expected: client.User{Name: base.Name, Email: "jane@example.com"},
+		},
+		{
+			name:     "stored user",
+			builder:  New(1).User().WithID(42).WithName("Jane Doe").WithCreatedAt(at),
+			expected: client.User{ID: 42, Name: "Jane Doe", Email: base.Email, CreatedAt: at, UpdatedAt: at},
+		},
+		{
+			name:     "updated later",
+			builder:  New(1).User().WithUpdatedAt(at.Add(time.Hour)).WithCreatedAt(at),
+			expected: client.User{Name: base.Name, Email: base.Email, CreatedAt: at, UpdatedAt: at.Add(time.Hour)},
+		},
+		{
+			name:     "last seen",
+			builder:  New(1).User().WithLastSeenAt(at),
+			expected: client.User{Name: base.Name, Email: base.Email, LastSeenAt: &at},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.builder.Build())
+		})
+	}
+
+	// Built users do not share state with the builder
+	builder := New(1).User().WithLastSeenAt(at)
+	user := builder.Build()
+	*user.LastSeenAt = at.Add(time.Hour)
+	require.NotNil(t, builder.Build().LastSeenAt)
+	assert.Equal(t, at, *builder.Build().LastSeenAt)
+}
@@ -0,0 +1,334 @@
+// Package httpx binds HTTP query parameters into typed structs, so handlers
+// validate them the same way and report problems with consistent errors.
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error describes a query parameter that could not be bound
+type Error struct {
+	Param   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Message)
+}
+
+// TimeRange is a period written as start/end, where either end may be left
+// open, e.g. 2024-01-01/2024-02-01 or 2024-01-01T12:00:00Z/
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains reports whether t falls within the range, inclusive of its ends
+func (tr TimeRange) Contains(t time.Time) bool {
+	return (tr.From.IsZero() || !t.Before(tr.From)) && (tr.To.IsZero() || !t.After(tr.To))
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	timeRangeType = reflect.TypeOf(TimeRange{})
+)
+
+// BindQuery fills the struct pointed to by dst from r's query parameters.
+// Fields are bound from the parameter named by their query tag and may
+// also carry:
+//
+//   - default:"..." - the value used when the parameter is absent
+//   - required:"true" - the parameter must be present
+//   - min:"..." and max:"..." - bounds for numbers, and for the length of lists
+//   - enum:"a,b,c" - the allowed values for strings and lists of strings
+//
+// Supported types are strings, bools, ints, uints, floats, time.Time
+// (RFC 3339 or a date), time.Duration (which also accepts days and weeks,
+// such as 30d or 2w), TimeRange, slices of those (comma separated or
+// repeated) and pointers to them, which stay nil when the parameter is
+// absent. The first invalid parameter is returned as an *Error.
+func BindQuery(r *http.Request, dst any) error {
+	return BindValues(r.URL.Query(), dst)
+}
+
+// BindValues is BindQuery for query parameters that did not come from a
+// request, such as a saved query
+func BindValues(query url.Values, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("httpx: BindValues needs a pointer to a struct, got %T", dst)
+	}
+	return bindStruct(query, v.Elem())
+}
+
+// Params returns the query parameter names bound into structs of dst's type
+func Params(dst any) []string {
+	t := reflect.TypeOf(dst)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var names []string
+	for i := range t.NumField() {
+		if name := t.Field(i).Tag.Get("query"); name != "" && name != "-" && t.Field(i).IsExported() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func bindStruct(query url.Values, v reflect.Value) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := field.Tag.Get("query")
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		values, present := query[name]
+		if !present {
+			def, ok := field.Tag.Lookup("default")
+			if !ok {
+				if field.Tag.Get("required") == "true" {
+					return &Error{Param: name, Message: "is required"}
+				}
+				continue
+			}
+			values = []string{def}
+		}
+		if err := bindField(v.Field(i), field.Tag, values); err != nil {
+			return &Error{Param: name, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+func bindField(v reflect.Value, tag reflect.StructTag, values []string) error {
+	if v.Kind() == reflect.Pointer {
+		value := reflect.New(v.Type().Elem())
+		if err := bindField(value.Elem(), tag, values); err != nil {
+			return err
+		}
+		v.Set(value)
+		return nil
+	}
+
+	if v.Kind() != reflect.Slice {
+		if err := bindValue(v, values[len(values)-1]); err != nil {
+			return err
+		}
+		return validate(v, tag)
+	}
+
+	var items []string
+	for _, value := range values {
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	list := reflect.MakeSlice(v.Type(), len(items), len(items))
+	for i, item := range items {
+		if err := bindValue(list.Index(i), item); err != nil {
+			return err
+		}
+		if err := validateEnum(list.Index(i), tag); err != nil {
+			return err
+		}
+	}
+	if err := validateBounds(float64(len(items)), tag, "items"); err != nil {
+		return err
+	}
+	v.Set(list)
+	return nil
+}
+
+// bindValue parses a single value into v
+func bindValue(v reflect.Value, value string) error {
+	switch v.Type() {
+	case timeType:
+		t, err := ParseTime(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case timeRangeType:
+		tr, err := ParseTimeRange(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(tr))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a non-negative integer", value)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// validate checks a bound single value against its enum, min and max tags
+func validate(v reflect.Value, tag reflect.StructTag) error {
+	if err := validateEnum(v, tag); err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			return nil
+		}
+		return validateBounds(float64(v.Int()), tag, "")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return validateBounds(float64(v.Uint()), tag, "")
+	case reflect.Float32, reflect.Float64:
+		return validateBounds(v.Float(), tag, "")
+	}
+	return nil
+}
+
+func validateEnum(v reflect.Value, tag reflect.StructTag) error {
+	enum, ok := tag.Lookup("enum")
+	if !ok || v.Kind() != reflect.String {
+		return nil
+	}
+	allowed := strings.Split(enum, ",")
+	for _, option := range allowed {
+		if v.String() == option {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", v.String(), strings.Join(allowed, ", "))
+}
+
+// validateBounds checks n against the min and max tags. unit names what is
+// counted, for lists.
+func validateBounds(n float64, tag reflect.StructTag, unit string) error {
+	suffix := ""
+	if unit != "" {
+		suffix = " " + unit
+	}
+	if lower, ok := tag.Lookup("min"); ok {
+		if bound, err := strconv.ParseFloat(lower, 64); err == nil && n < bound {
+			return fmt.Errorf("must be at least %s%s", lower, suffix)
+		}
+	}
+	if upper, ok := tag.Lookup("max"); ok {
+		if bound, err := strconv.ParseFloat(upper, 64); err == nil && n > bound {
+			return fmt.Errorf("must be at most %s%s", upper, suffix)
+		}
+	}
+	return nil
+}
+
+// ParseTime parses an RFC 3339 time or a date such as 2024-01-31, which is
+// taken as midnight UTC
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or a date", value)
+}
+
+// ParseDuration parses a positive duration such as 30d, 2w or 12h. Days and
+// weeks are accepted on top of time.ParseDuration's units.
+func ParseDuration(value string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+
+	var d time.Duration
+	if unit == 0 {
+		var err error
+		if d, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("%q is not a duration", value)
+		}
+	} else {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a duration", value)
+		}
+		d = time.Duration(n) * unit
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", value)
+	}
+	return d, nil
+}
+
+// ParseTimeRange parses start/end, where either side may be empty but not
+// both, and start may not be after end
+func ParseTimeRange(value string) (TimeRange, error) {
+	start, end, ok := strings.Cut(value, "/")
+	if !ok || (start == "" && end == "") {
+		return TimeRange{}, fmt.Errorf("%q is not a start/end range", value)
+	}
+
+	var tr TimeRange
+	var err error
+	if start != "" {
+		if tr.From, err = ParseTime(start); err != nil {
+			return TimeRange{}, err
+		}
+	}
+	if end != "" {
+		if tr.To, err = ParseTime(end); err != nil {
+			return TimeRange{}, err
+		}
+	}
+	if !tr.From.IsZero() && !tr.To.IsZero() && tr.From.After(tr.To) {
+		return TimeRange{}, errors.New("start is after end")
+	}
+	return tr, nil
+}
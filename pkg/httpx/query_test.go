@@ -0,0 +1,110 @@
+package httpx
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type listQuery struct {
+	Limit    int            `query:"limit" default:"20" min:"1" max:"100"`
+	Active   *bool          `query:"active"`
+	Sort     string         `query:"sort" default:"name" enum:"name,email"`
+	IDs      []int          `query:"ids" max:"3"`
+	Fields   []string       `query:"fields" enum:"id,name,email"`
+	Since    time.Time      `query:"since"`
+	Within   *time.Duration `query:"within"`
+	Created  TimeRange      `query:"created"`
+	Required string         `query:"q" required:"true"`
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestBindQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected listQuery
+		param    string
+		message  string
+	}{
+		{
+			name:     "defaults",
+			query:    "q=x",
+			expected: listQuery{Limit: 20, Sort: "name", Required: "x"},
+		},
+		{
+			name:  "all set",
+			query: "q=x&limit=50&active=false&sort=email&ids=1,2&ids=3&fields=id,name&since=2024-01-31&within=2w&created=2024-01-01/2024-02-01T12:00:00Z",
+			expected: listQuery{
+				Limit:    50,
+				Active:   ptr(false),
+				Sort:     "email",
+				IDs:      []int{1, 2, 3},
+				Fields:   []string{"id", "name"},
+				Since:    time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+				Within:   ptr(14 * 24 * time.Hour),
+				Created:  TimeRange{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)},
+				Required: "x",
+			},
+		},
+		{name: "missing required", query: "", param: "q", message: "is required"},
+		{name: "not an int", query: "q=x&limit=ten", param: "limit", message: `"ten" is not an integer`},
+		{name: "below min", query: "q=x&limit=0", param: "limit", message: "must be at least 1"},
+		{name: "above max", query: "q=x&limit=101", param: "limit", message: "must be at most 100"},
+		{name: "not a bool", query: "q=x&active=maybe", param: "active", message: `"maybe" is not a boolean`},
+		{name: "not in enum", query: "q=x&sort=age", param: "sort", message: `"age" is not one of name, email`},
+		{name: "list item not in enum", query: "q=x&fields=id,password", param: "fields", message: `"password" is not one of id, name, email`},
+		{name: "list too long", query: "q=x&ids=1,2,3,4", param: "ids", message: "must be at most 3 items"},
+		{name: "invalid time", query: "q=x&since=yesterday", param: "since", message: `"yesterday" is not an RFC 3339 time or a date`},
+		{name: "negative duration", query: "q=x&within=-1d", param: "within", message: `duration "-1d" must be positive`},
+		{name: "reversed range", query: "q=x&created=2024-02-01/2024-01-01", param: "created", message: "start is after end"},
+		{name: "empty range", query: "q=x&created=/", param: "created", message: `"/" is not a start/end range`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/users?"+tt.query, nil)
+			var query listQuery
+			err := BindQuery(req, &query)
+
+			if tt.param == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, query)
+				return
+			}
+			var invalid *Error
+			require.ErrorAs(t, err, &invalid)
+			assert.Equal(t, tt.param, invalid.Param)
+			assert.Equal(t, tt.message, invalid.Message)
+		})
+	}
+}
+
+func TestBindQuery_NotAStruct(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users", nil)
+	var limit int
+	assert.Error(t, BindQuery(req, &limit))
+}
+
+func TestParams(t *testing.T) {
+	assert.Equal(t,
+		[]string{"limit", "active", "sort", "ids", "fields", "since", "within", "created", "q"},
+		Params(&listQuery{}))
+}
+
+func TestTimeRange_Contains(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.True(t, TimeRange{From: jan, To: feb}.Contains(jan))
+	assert.True(t, TimeRange{From: jan, To: feb}.Contains(feb))
+	assert.False(t, TimeRange{From: jan, To: feb}.Contains(feb.Add(time.Second)))
+	assert.True(t, TimeRange{From: jan}.Contains(feb.AddDate(1, 0, 0)))
+	assert.False(t, TimeRange{To: jan}.Contains(feb))
+}
@@ -0,0 +1,136 @@
+// Package plugin is the contract between the API server and the plugins
+// that intercept its user lifecycle events. The server runs a plugin's
+// command for each hook call, writes one gob-encoded Request to its stdin
+// and reads one gob-encoded Response from its stdout. Anything the plugin
+// writes to stderr is reported with its failures.
+//
+// The contract is stable within a ProtocolVersion: fields may be added, as
+// gob ignores fields one side does not know, but are never removed or
+// changed. Plugins written in Go only need Serve:
+//
+//	func main() {
+//		plugin.Serve(plugin.HookFuncs{
+//			ValidateBeforeCreate: func(user plugin.User) (string, error) {
+//				if strings.HasSuffix(user.Email, "@example.org") {
+//					return "example.org addresses are not accepted", nil
+//				}
+//				return "", nil
+//			},
+//		})
+//	}
+package plugin
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ProtocolVersion is the version of the contract, sent with each request
+const ProtocolVersion = 1
+
+const (
+	// HookValidateBeforeCreate is called with the user about to be created,
+	// and can reject it
+	HookValidateBeforeCreate = "validate-before-create"
+	// HookEnrichAfterRead is called with users read for a response, and can
+	// add attributes to them
+	HookEnrichAfterRead = "enrich-after-read"
+)
+
+// Hooks lists the hooks plugins can handle
+var Hooks = []string{HookValidateBeforeCreate, HookEnrichAfterRead}
+
+// User is a user as plugins see it
+type User struct {
+	// ID is zero for users not yet created
+	ID        int64
+	Name      string
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Attributes are added to the user by enrich-after-read; earlier
+	// plugins' attributes are passed on to later ones
+	Attributes map[string]string
+}
+
+// Request is a hook call
+type Request struct {
+	Version int
+	Hook    string
+	// Users holds the user to validate, or the users to enrich
+	Users []User
+}
+
+// Response is a plugin's answer to a Request
+type Response struct {
+	// Rejection refuses the user of validate-before-create, telling the
+	// caller why
+	Rejection string
+	// Users are the users of enrich-after-read, in the same order, with
+	// their attributes
+	Users []User
+	// Error reports that the plugin failed to handle the request
+	Error string
+}
+
+// HookFuncs implements the hooks a plugin handles; those left nil accept
+// users as they are
+type HookFuncs struct {
+	ValidateBeforeCreate func(user User) (rejection string, err error)
+	EnrichAfterRead      func(users []User) ([]User, error)
+}
+
+// Serve answers the request on stdin with hooks, then exits
+func Serve(hooks HookFuncs) {
+	if err := ServeIO(os.Stdin, os.Stdout, hooks); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// ServeIO reads a request from r, answers it with hooks and writes the
+// response to w. Failures of hooks are written as the response's Error;
+// only failures to read or write are returned.
+func ServeIO(r io.Reader, w io.Writer, hooks HookFuncs) error {
+	var request Request
+	if err := gob.NewDecoder(r).Decode(&request); err != nil {
+		return fmt.Errorf("failed to read plugin request: %w", err)
+	}
+	response := handle(request, hooks)
+	if err := gob.NewEncoder(w).Encode(response); err != nil {
+		return fmt.Errorf("failed to write plugin response: %w", err)
+	}
+	return nil
+}
+
+func handle(request Request, hooks HookFuncs) Response {
+	if request.Version != ProtocolVersion {
+		return Response{Error: fmt.Sprintf("unsupported protocol version %d, expected %d", request.Version, ProtocolVersion)}
+	}
+	switch request.Hook {
+	case HookValidateBeforeCreate:
+		if hooks.ValidateBeforeCreate == nil || len(request.Users) != 1 {
+			return Response{}
+		}
+		rejection, err := hooks.ValidateBeforeCreate(request.Users[0])
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		return Response{Rejection: rejection}
+	case HookEnrichAfterRead:
+		if hooks.EnrichAfterRead == nil {
+			return Response{Users: request.Users}
+		}
+		users, err := hooks.EnrichAfterRead(request.Users)
+		if err != nil {
+			return Response{Error: err.Error()}
+		}
+		return Response{Users: users}
+	default:
+		return Response{Error: fmt.Sprintf("unknown hook %q", request.Hook)}
+	}
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeIO(t *testing.T) {
+	hooks := HookFuncs{
+		ValidateBeforeCreate: func(user User) (string, error) {
+			if strings.HasSuffix(user.Email, "@example.org") {
+				return "example.org addresses are not accepted", nil
+			}
+			if user.Email == "" {
+				return "", errors.New("directory unavailable")
+			}
+			return "", nil
+		},
+		EnrichAfterRead: func(users []User) ([]User, error) {
+			for i := range users {
+				users[i].Attributes = map[string]string{"plan": "enterprise"}
+			}
+			return users, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		hooks    HookFuncs
+		request  Request
+		expected Response
+	}{
+		{
+			name:     "accepts",
+			hooks:    hooks,
+			request:  Request{Version: ProtocolVersion, Hook: HookValidateBeforeCreate, Users: []User{{Email: "john@example.com"}}},
+			expected: Response{},
+		},
+		{
+			name:     "rejects",
+			hooks:    hooks,
+			request:  Request{Version: ProtocolVersion, Hook: HookValidateBeforeCreate, Users: []User{{Email: "john@example.org"}}},
+			expected: Response{Rejection: "example.org addresses are not accepted"},
+		},
+		{
+			name:     "hook fails",
+			hooks:    hooks,
+			request:  Request{Version: ProtocolVersion, Hook: HookValidateBeforeCreate, Users: []User{{}}},
+			expected: Response{Error: "directory unavailable"},
+		},
+		{
+			name:     "enriches",
+			hooks:    hooks,
+			request:  Request{Version: ProtocolVersion, Hook: HookEnrichAfterRead, Users: []User{{ID: 1}, {ID: 2}}},
+			expected: Response{Users: []User{{ID: 1, Attributes: map[string]string{"plan": "enterprise"}}, {ID: 2, Attributes: map[string]string{"plan": "enterprise"}}}},
+		},
+		{
+			name:     "unhandled hook passes users through",
+			request:  Request{Version: ProtocolVersion, Hook: HookEnrichAfterRead, Users: []User{{ID: 1}}},
+			expected: Response{Users: []User{{ID: 1}}},
+		},
+		{
+			name:     "unknown hook",
+			hooks:    hooks,
+			request:  Request{Version: ProtocolVersion, Hook: "delete-after-read"},
+			expected: Response{Error: `unknown hook "delete-after-read"`},
+		},
+		{
+			name:     "newer protocol",
+			hooks:    hooks,
+			request:  Request{Version: ProtocolVersion + 1, Hook: HookEnrichAfterRead},
+			expected: Response{Error: "unsupported protocol version 2, expected 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in, out bytes.Buffer
+			require.NoError(t, gob.NewEncoder(&in).Encode(tt.request))
+			require.NoError(t, ServeIO(&in, &out, tt.hooks))
+
+			var response Response
+			require.NoError(t, gob.NewDecoder(&out).Decode(&response))
+			assert.Equal(t, tt.expected, response)
+		})
+	}
+}
+
+func TestServeIO_InvalidRequest(t *testing.T) {
+	var out bytes.Buffer
+	assert.Error(t, ServeIO(strings.NewReader("not gob"), &out, HookFuncs{}))
+	assert.Zero(t, out.Len())
+}
@@ -0,0 +1,181 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the most digits a Decimal can have after the point
+const MaxScale = 18
+
+var errDecimalRange = errors.New("is out of range")
+
+// Decimal is an exact decimal value, such as an amount of money, held as an
+// integer number of units of 10^-scale. Its scale is kept when written, so
+// 1234.50 stays 1234.50. It is written in JSON as a string.
+type Decimal struct {
+	units int64
+	scale int
+}
+
+// NewDecimal returns units × 10^-scale, e.g. NewDecimal(123450, 2) is 1234.50
+func NewDecimal(units int64, scale int) (Decimal, error) {
+	if scale < 0 || scale > MaxScale {
+		return Decimal{}, fmt.Errorf("scale %d is not between 0 and %d", scale, MaxScale)
+	}
+	return Decimal{units: units, scale: scale}, nil
+}
+
+// ParseDecimal parses digits with an optional sign and '.' separator, such as
+// -1234.50. Grouping separators, exponents and other locales' separators are
+// rejected rather than guessed at.
+func ParseDecimal(s string) (Decimal, error) {
+	digits, negative := strings.CutPrefix(s, "-")
+	if !negative {
+		digits = strings.TrimPrefix(s, "+")
+	}
+	whole, fraction, hasPoint := strings.Cut(digits, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) || hasPoint && fraction == "" {
+		return Decimal{}, fmt.Errorf("%q is not a decimal", s)
+	}
+	if len(fraction) > MaxScale {
+		return Decimal{}, fmt.Errorf("%q has more than %d decimal places", s, MaxScale)
+	}
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("%q %w", s, errDecimalRange)
+	}
+	if negative {
+		units = -units
+	}
+	return Decimal{units: units, scale: len(fraction)}, nil
+}
+
+// MustParseDecimal is like ParseDecimal but panics on invalid input, for
+// constants
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Units returns the decimal's value in units of 10^-Scale
+func (d Decimal) Units() int64 {
+	return d.units
+}
+
+// Scale returns the number of digits after the point
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+// IsZero reports whether the decimal is zero, at any scale
+func (d Decimal) IsZero() bool {
+	return d.units == 0
+}
+
+// Rescale returns the decimal with scale digits after the point. Reducing
+// the scale rounds half away from zero.
+func (d Decimal) Rescale(scale int) (Decimal, error) {
+	if scale < 0 || scale > MaxScale {
+		return Decimal{}, fmt.Errorf("scale %d is not between 0 and %d", scale, MaxScale)
+	}
+	units := d.units
+	for s := d.scale; s < scale; s++ {
+		if units > math.MaxInt64/10 || units < math.MinInt64/10 {
+			return Decimal{}, fmt.Errorf("%s at scale %d %w", d, scale, errDecimalRange)
+		}
+		units *= 10
+	}
+	for s := d.scale; s > scale; s-- {
+		remainder := units % 10
+		units /= 10
+		switch {
+		case remainder >= 5:
+			units++
+		case remainder <= -5:
+			units--
+		}
+	}
+	return Decimal{units: units, scale: scale}, nil
+}
+
+// Cmp compares d and other by value, returning -1, 0 or +1
+func (d Decimal) Cmp(other Decimal) int {
+	return d.big(MaxScale).Cmp(other.big(MaxScale))
+}
+
+// big returns the decimal in units of 10^-scale, for scale >= d.scale
+func (d Decimal) big(scale int) *big.Int {
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.scale)), nil)
+	return factor.Mul(factor, big.NewInt(d.units))
+}
+
+// String returns the decimal with a '.' separator and Scale digits after it
+func (d Decimal) String() string {
+	digits := strconv.FormatUint(absUnits(d.units), 10)
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if d.units < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// absUnits returns |units|, which for math.MinInt64 only fits in a uint64
+func absUnits(units int64) uint64 {
+	if units < 0 {
+		return uint64(-(units + 1)) + 1
+	}
+	return uint64(units)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Decimal) UnmarshalText(text []byte) error {
+	parsed, err := ParseDecimal(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, writing the decimal as a string
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts a string or a plain
+// number, which is parsed exactly rather than through a float.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s, _, err := unquote(data)
+	if err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
@@ -0,0 +1,57 @@
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration written as a Go duration string such as
+// 1h30m0s, rather than the integer nanoseconds encoding/json writes
+type Duration time.Duration
+
+// ParseDuration parses a Go duration string
+func ParseDuration(s string) (Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", s)
+	}
+	return Duration(d), nil
+}
+
+// String returns the duration as a Go duration string
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s, quoted, err := unquote(data)
+	if err != nil {
+		return err
+	}
+	if !quoted {
+		return fmt.Errorf("duration must be a JSON string, got %s", data)
+	}
+	return d.UnmarshalText([]byte(s))
+}
@@ -0,0 +1,85 @@
+// Package serial encodes times, durations and decimal values the same way in
+// every output format. Each type implements encoding.TextMarshaler, which
+// encoding/xml uses for elements and attributes, and json.Marshaler, while
+// Text formats any value for a CSV cell. Output never depends on the host's
+// time zone or locale:
+//
+//   - Time is written in UTC as RFC 3339, with as many fractional seconds as
+//     needed, e.g. 2024-01-02T15:04:05.5Z
+//   - Duration is written as a Go duration, e.g. 1h30m0s
+//   - Decimal is written with a '.' separator and no grouping, e.g. 1234.50,
+//     and as a JSON string so clients never round it through a float
+package serial
+
+import (
+	"encoding"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Text formats v for a CSV cell or other plain text output, using the same
+// representation as the JSON and XML encodings. nil formats as "".
+func Text(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return NewTime(v).String(), nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return NewTime(*v).String(), nil
+	case time.Duration:
+		return Duration(v).String(), nil
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(text), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("cannot format %T as text", v)
+	}
+}
+
+// Texts formats each of values with Text, e.g. for a CSV record
+func Texts(values ...any) ([]string, error) {
+	record := make([]string, len(values))
+	for i, v := range values {
+		text, err := Text(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to format field %d: %w", i, err)
+		}
+		record[i] = text
+	}
+	return record, nil
+}
+
+// unquote returns the contents of a JSON string, or data itself when it is
+// not a string
+func unquote(data []byte) (string, bool, error) {
+	if len(data) == 0 || data[0] != '"' {
+		return string(data), false, nil
+	}
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return "", true, fmt.Errorf("invalid JSON string %s", data)
+	}
+	return s, true, nil
+}
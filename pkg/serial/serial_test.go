@@ -0,0 +1,227 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// quota is a record using each type, as a model with billing attributes would
+type quota struct {
+	XMLName   xml.Name `json:"-" xml:"quota"`
+	Name      string   `json:"name" xml:"name,attr"`
+	Price     Decimal  `json:"price" xml:"price"`
+	Period    Duration `json:"period" xml:"period"`
+	StartsAt  Time     `json:"starts_at" xml:"starts_at"`
+	ExpiresAt Time     `json:"expires_at" xml:"expires_at"`
+}
+
+func (q quota) record() ([]string, error) {
+	return Texts(q.Name, q.Price, q.Period, q.StartsAt, q.ExpiresAt)
+}
+
+func quotas() []quota {
+	// Created in several zones, and with the host zone set elsewhere, to
+	// show that output does not depend on either
+	tokyo := time.FixedZone("JST", 9*60*60)
+	return []quota{
+		{
+			Name:     "api-calls",
+			Price:    MustParseDecimal("1234.50"),
+			Period:   Duration(30 * 24 * time.Hour),
+			StartsAt: NewTime(time.Date(2024, 1, 2, 9, 0, 0, 0, tokyo)),
+		},
+		{
+			Name:      "storage",
+			Price:     MustParseDecimal("-0.000001"),
+			Period:    Duration(90 * time.Minute),
+			StartsAt:  NewTime(time.Date(2024, 3, 31, 23, 30, 0, 500_000_000, time.UTC)),
+			ExpiresAt: NewTime(time.Date(2024, 6, 30, 18, 0, 0, 0, time.FixedZone("EST", -5*60*60))),
+		},
+	}
+}
+
+// golden compares got with testdata/name, rewriting it when -update is set
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.WriteFile(path, got, 0o644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err, "run go test ./pkg/serial -update to create golden files")
+	assert.Equal(t, string(expected), string(got))
+}
+
+func TestGolden(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("CEST", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	t.Run("json", func(t *testing.T) {
+		encoded, err := json.MarshalIndent(quotas(), "", "  ")
+		require.NoError(t, err)
+		golden(t, "quotas.json", append(encoded, '\n'))
+
+		var decoded []quota
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.Equal(t, quotas(), decoded)
+	})
+
+	t.Run("xml", func(t *testing.T) {
+		encoded, err := xml.MarshalIndent(struct {
+			XMLName xml.Name `xml:"quotas"`
+			Quotas  []quota
+		}{Quotas: quotas()}, "", "  ")
+		require.NoError(t, err)
+		golden(t, "quotas.xml", append(encoded, '\n'))
+
+		var decoded struct {
+			Quotas []quota `xml:"quota"`
+		}
+		require.NoError(t, xml.Unmarshal(encoded, &decoded))
+		assert.Equal(t, quotas(), stripXMLNames(decoded.Quotas))
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		require.NoError(t, writer.Write([]string{"name", "price", "period", "starts_at", "expires_at"}))
+		for _, q := range quotas() {
+			record, err := q.record()
+			require.NoError(t, err)
+			require.NoError(t, writer.Write(record))
+		}
+		writer.Flush()
+		require.NoError(t, writer.Error())
+		golden(t, "quotas.csv", buf.Bytes())
+	})
+}
+
+func stripXMLNames(quotas []quota) []quota {
+	for i := range quotas {
+		quotas[i].XMLName = xml.Name{}
+	}
+	return quotas
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{input: "0", expected: "0"},
+		{input: "+12", expected: "12"},
+		{input: "1234.50", expected: "1234.50"},
+		{input: "-0.05", expected: "-0.05"},
+		{input: "9223372036854775807", expected: "9223372036854775807"},
+		{input: "", err: `"" is not a decimal`},
+		{input: "1,234.50", err: `"1,234.50" is not a decimal`},
+		{input: "1234,50", err: `"1234,50" is not a decimal`},
+		{input: "1e3", err: `"1e3" is not a decimal`},
+		{input: ".5", err: `".5" is not a decimal`},
+		{input: "5.", err: `"5." is not a decimal`},
+		{input: "-+5", err: `"-+5" is not a decimal`},
+		{input: " 5", err: `" 5" is not a decimal`},
+		{input: "0.1234567890123456789", err: `"0.1234567890123456789" has more than 18 decimal places`},
+		{input: "92233720368547758070", err: `"92233720368547758070" is out of range`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d, err := ParseDecimal(tt.input)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, d.String())
+		})
+	}
+}
+
+func TestDecimal_Rescale(t *testing.T) {
+	tests := []struct {
+		input    string
+		scale    int
+		expected string
+	}{
+		{input: "1.005", scale: 2, expected: "1.01"},
+		{input: "1.004", scale: 2, expected: "1.00"},
+		{input: "-1.005", scale: 2, expected: "-1.01"},
+		{input: "12", scale: 2, expected: "12.00"},
+		{input: "0.5", scale: 0, expected: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d, err := MustParseDecimal(tt.input).Rescale(tt.scale)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, d.String())
+		})
+	}
+
+	_, err := MustParseDecimal("9223372036854775807").Rescale(1)
+	assert.Error(t, err)
+}
+
+func TestDecimal_Cmp(t *testing.T) {
+	assert.Equal(t, 0, MustParseDecimal("1.50").Cmp(MustParseDecimal("1.5")))
+	assert.Equal(t, -1, MustParseDecimal("-2").Cmp(MustParseDecimal("1.999")))
+	assert.Equal(t, 1, MustParseDecimal("9223372036854775807").Cmp(MustParseDecimal("0.000000000000000001")))
+}
+
+func TestDecimal_UnmarshalJSONNumber(t *testing.T) {
+	// Numbers are read exactly, without passing through a float64
+	var d Decimal
+	require.NoError(t, json.Unmarshal([]byte(`0.100000000000000005`), &d))
+	assert.Equal(t, "0.100000000000000005", d.String())
+	assert.Error(t, json.Unmarshal([]byte(`1e3`), &d))
+}
+
+func TestTime(t *testing.T) {
+	parsed, err := ParseTime("2024-01-02T09:00:00+09:00")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02T00:00:00Z", parsed.String())
+
+	_, err = ParseTime("2024-01-02")
+	assert.EqualError(t, err, `"2024-01-02" is not an RFC 3339 time`)
+
+	var zero Time
+	encoded, err := json.Marshal(zero)
+	require.NoError(t, err)
+	assert.Equal(t, "null", string(encoded))
+	require.NoError(t, json.Unmarshal([]byte(`null`), &parsed))
+	assert.True(t, parsed.IsZero())
+	assert.Error(t, json.Unmarshal([]byte(`1704153600`), &parsed))
+}
+
+func TestDuration(t *testing.T) {
+	var d Duration
+	require.NoError(t, json.Unmarshal([]byte(`"1h30m"`), &d))
+	assert.Equal(t, Duration(90*time.Minute), d)
+	assert.Error(t, json.Unmarshal([]byte(`5400000000000`), &d))
+	assert.Error(t, json.Unmarshal([]byte(`"soon"`), &d))
+}
+
+func TestTexts(t *testing.T) {
+	when := time.Date(2024, 1, 2, 9, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	record, err := Texts(nil, "x", true, 42, 0.25, when, &when, (*time.Time)(nil), 90*time.Minute, MustParseDecimal("1.50"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "x", "true", "42", "0.25", "2024-01-02T00:00:00Z", "2024-01-02T00:00:00Z", "", "1h30m0s", "1.50"}, record)
+
+	_, err = Texts(struct{}{})
+	assert.EqualError(t, err, "failed to format field 0: cannot format struct {} as text")
+}
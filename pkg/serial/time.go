@@ -0,0 +1,80 @@
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Time is an instant written in UTC as RFC 3339, whatever zone it was
+// created or parsed in. The zero Time is written as null in JSON and as ""
+// in text.
+type Time struct {
+	time.Time
+}
+
+// NewTime returns t as a Time, converted to UTC
+func NewTime(t time.Time) Time {
+	return Time{Time: t.UTC()}
+}
+
+// ParseTime parses an RFC 3339 time with any offset, returning it in UTC
+func ParseTime(s string) (Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return Time{}, fmt.Errorf("%q is not an RFC 3339 time", s)
+	}
+	return NewTime(t), nil
+}
+
+// String returns the time in UTC as RFC 3339, or "" for the zero Time
+func (t Time) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t Time) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text is the zero
+// Time.
+func (t *Time) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*t = Time{}
+		return nil
+	}
+	parsed, err := ParseTime(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.Quote(t.String())), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = Time{}
+		return nil
+	}
+	s, quoted, err := unquote(data)
+	if err != nil {
+		return err
+	}
+	if !quoted {
+		return fmt.Errorf("time must be a JSON string, got %s", data)
+	}
+	return t.UnmarshalText([]byte(s))
+}